@@ -13,15 +13,30 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/btrfs"
+	"github.com/diskfs/go-diskfs/filesystem/erofs"
+	"github.com/diskfs/go-diskfs/filesystem/exfat"
+	"github.com/diskfs/go-diskfs/filesystem/ext4"
 	"github.com/diskfs/go-diskfs/filesystem/fat32"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/ntfs"
 	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
+	"github.com/diskfs/go-diskfs/filesystem/xfs"
 	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 // Disk is a reference to a single disk block device or image that has been Create() or Open()
+//
+// File is where all reads and writes of the disk contents go. For a raw disk opened by
+// github.com/diskfs/go-diskfs, it is the *os.File of the image or device itself; for other disk image
+// formats, it presents the virtual disk stored in the image, e.g. a *qcow2.Image. A Disk can be backed
+// by any other util.File, e.g. one in memory, by creating it with New.
+//
+// Call Close when done with the Disk; some image formats are complete only once closed.
 type Disk struct {
-	File              *os.File
+	File              util.File
 	Info              os.FileInfo
 	Type              Type
 	Size              int64
@@ -42,10 +57,62 @@ const (
 	Device
 )
 
+const defaultBlocksize = 512
+
 var (
 	errIncorrectOpenMode = errors.New("disk file or device not open for write")
 )
 
+// New creates a Disk backed by f, which holds a disk of size bytes. A logicalBlocksize or physicalBlocksize
+// of 0 means the default of 512 bytes.
+//
+// The Disk is writable, of Type File, and has no Info. If f already holds a partition table, it is read
+// into Table; it is perfectly fine for there to be none.
+func New(f util.File, size, logicalBlocksize, physicalBlocksize int64) (*Disk, error) {
+	defaultBlocks := logicalBlocksize == 0 && physicalBlocksize == 0
+	if logicalBlocksize == 0 {
+		logicalBlocksize = defaultBlocksize
+	}
+	if physicalBlocksize == 0 {
+		physicalBlocksize = defaultBlocksize
+	}
+	switch {
+	case f == nil:
+		return nil, errors.New("must pass a file to back the disk")
+	case size <= 0:
+		return nil, fmt.Errorf("invalid disk size %d", size)
+	case logicalBlocksize < 0 || logicalBlocksize%512 != 0:
+		return nil, fmt.Errorf("invalid logical block size %d, must be a multiple of 512", logicalBlocksize)
+	case physicalBlocksize < logicalBlocksize || physicalBlocksize%logicalBlocksize != 0:
+		return nil, fmt.Errorf("invalid physical block size %d, must be a multiple of the logical block size %d", physicalBlocksize, logicalBlocksize)
+	}
+	d := &Disk{
+		File:              f,
+		Type:              File,
+		Size:              size,
+		LogicalBlocksize:  logicalBlocksize,
+		PhysicalBlocksize: physicalBlocksize,
+		Writable:          true,
+		DefaultBlocks:     defaultBlocks,
+	}
+	// we ignore errors, because it is perfectly fine to use a disk before it has a partition table
+	if table, err := d.GetPartitionTable(); err == nil && table != nil {
+		d.Table = table
+	}
+	return d, nil
+}
+
+// Close releases the disk. If File implements io.Closer, as an *os.File and the image formats
+// in github.com/diskfs/go-diskfs/format do, it is closed, completing any image that is written as a stream.
+func (d *Disk) Close() error {
+	if c, ok := d.File.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("unable to close disk: %v", err)
+		}
+	}
+	return nil
+}
+
 // GetPartitionTable retrieves a PartitionTable for a Disk
 //
 // If the table is able to be retrieved from the disk, it is saved in the instance.
@@ -187,25 +254,72 @@ func (d *Disk) CreateFilesystem(spec FilesystemSpec) (filesystem.FileSystem, err
 		return iso9660.Create(d.File, size, start, d.LogicalBlocksize, spec.WorkDir)
 	case filesystem.TypeSquashfs:
 		return nil, errors.New("squashfs is a read-only filesystem")
+	case filesystem.TypeEROFS:
+		return nil, errors.New("erofs is a read-only filesystem")
+	case filesystem.TypeNTFS:
+		return nil, errors.New("ntfs is a read-only filesystem")
+	case filesystem.TypeBtrfs:
+		return nil, errors.New("btrfs is a read-only filesystem")
+	case filesystem.TypeXFS:
+		return nil, errors.New("xfs is a read-only filesystem")
+	case filesystem.TypeExFAT:
+		return exfat.Create(d.File, size, start, d.LogicalBlocksize, spec.VolumeLabel)
+	case filesystem.TypeExt4:
+		return ext4.Create(d.File, size, start, d.LogicalBlocksize, spec.VolumeLabel)
+	case filesystem.TypeUDF:
+		fs, err := udf.Create(d.File, size, start, d.LogicalBlocksize)
+		if err != nil {
+			return nil, err
+		}
+		if err := fs.SetLabel(spec.VolumeLabel); err != nil {
+			return nil, err
+		}
+		return fs, nil
 	default:
 		return nil, errors.New("unknown filesystem type requested")
 	}
 }
 
+type getFilesystemOpts struct {
+	subvolume string
+}
+
+// GetFilesystemOpt func that process GetFilesystem options
+type GetFilesystemOpt func(o *getFilesystemOpts) error
+
+// WithSubvolume reads the btrfs subvolume at path, such as "/@home", instead of the default subvolume.
+// "/" is the top level subvolume. The filesystem must be btrfs, so no other type is tried.
+func WithSubvolume(path string) GetFilesystemOpt {
+	return func(o *getFilesystemOpts) error {
+		if path == "" {
+			return errors.New("subvolume path must not be empty")
+		}
+		o.subvolume = path
+		return nil
+	}
+}
+
 // GetFilesystem gets the filesystem that already exists on a disk image
 //
 // pass the desired partition number, or 0 to create the filesystem on the entire block device / disk image,
+// and use GetFilesystemOpt to control options, such as which btrfs subvolume to read
 //
 // if successful, returns a filesystem-implementing structure for the given filesystem type
 //
 // returns error if there was an error reading the filesystem, or the partition table is invalid and did not
 // request the entire disk.
-func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
+func (d *Disk) GetFilesystem(part int, opts ...GetFilesystemOpt) (filesystem.FileSystem, error) {
 	// find out where the partition starts and ends, or if it is the entire disk
 	var (
 		size, start int64
 		err         error
 	)
+	opt := &getFilesystemOpts{}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
 
 	switch {
 	case part == 0:
@@ -223,6 +337,10 @@ func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 		start = partitions[part-1].GetStart()
 	}
 
+	if opt.subvolume != "" {
+		return btrfs.ReadSubvolume(d.File, size, start, d.LogicalBlocksize, opt.subvolume)
+	}
+
 	// just try each type
 	log.Debug("trying fat32")
 	fat32FS, err := fat32.Read(d.File, size, start, d.LogicalBlocksize)
@@ -230,6 +348,42 @@ func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 		return fat32FS, nil
 	}
 	log.Debugf("fat32 failed: %v", err)
+	log.Debug("trying exfat")
+	exfatFS, err := exfat.Read(d.File, size, start, d.LogicalBlocksize)
+	if err == nil {
+		return exfatFS, nil
+	}
+	log.Debugf("exfat failed: %v", err)
+	log.Debug("trying ext4")
+	ext4FS, err := ext4.Read(d.File, size, start, d.LogicalBlocksize)
+	if err == nil {
+		return ext4FS, nil
+	}
+	log.Debugf("ext4 failed: %v", err)
+	log.Debug("trying erofs")
+	erofsFS, err := erofs.Read(d.File, size, start, d.LogicalBlocksize)
+	if err == nil {
+		return erofsFS, nil
+	}
+	log.Debugf("erofs failed: %v", err)
+	log.Debug("trying ntfs")
+	ntfsFS, err := ntfs.Read(d.File, size, start, d.LogicalBlocksize)
+	if err == nil {
+		return ntfsFS, nil
+	}
+	log.Debugf("ntfs failed: %v", err)
+	log.Debug("trying btrfs")
+	btrfsFS, err := btrfs.Read(d.File, size, start, d.LogicalBlocksize)
+	if err == nil {
+		return btrfsFS, nil
+	}
+	log.Debugf("btrfs failed: %v", err)
+	log.Debug("trying xfs")
+	xfsFS, err := xfs.Read(d.File, size, start, d.LogicalBlocksize)
+	if err == nil {
+		return xfsFS, nil
+	}
+	log.Debugf("xfs failed: %v", err)
 	pbs := d.PhysicalBlocksize
 	if d.DefaultBlocks {
 		pbs = 0
@@ -240,6 +394,12 @@ func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 		return iso9660FS, nil
 	}
 	log.Debugf("iso9660 failed: %v", err)
+	log.Debugf("trying udf with physical block size %d", pbs)
+	udfFS, err := udf.Read(d.File, size, start, pbs)
+	if err == nil {
+		return udfFS, nil
+	}
+	log.Debugf("udf failed: %v", err)
 	squashFS, err := squashfs.Read(d.File, size, start, d.LogicalBlocksize)
 	if err == nil {
 		return squashFS, nil
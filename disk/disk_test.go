@@ -15,6 +15,8 @@ import (
 
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/erofs"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
 	"github.com/diskfs/go-diskfs/partition"
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
@@ -529,6 +531,89 @@ func TestCreateFilesystem(t *testing.T) {
 			t.Errorf("returned filesystem was unexpectedly nil")
 		}
 	})
+	t.Run("exfat", func(t *testing.T) {
+		f, err := tmpDisk("")
+		if err != nil {
+			t.Fatalf("error creating new temporary disk: %v", err)
+		}
+		defer f.Close()
+
+		if keepTmpFiles {
+			defer os.Remove(f.Name())
+		} else {
+			fmt.Println(f.Name())
+		}
+
+		fileInfo, err := f.Stat()
+		if err != nil {
+			t.Fatalf("error reading info on temporary disk: %v", err)
+		}
+
+		d := &disk.Disk{
+			File:              f,
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Info:              fileInfo,
+			Size:              fileInfo.Size(),
+			Writable:          true,
+		}
+		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeExFAT, VolumeLabel: "EXFAT"})
+		if err != nil {
+			t.Fatalf("error unexpectedly not nil:  %v", err)
+		}
+		if fs.Type() != filesystem.TypeExFAT {
+			t.Errorf("mismatched filesystem type %v", fs.Type())
+		}
+		// it must be found again, and not mistaken for FAT
+		fs, err = d.GetFilesystem(0)
+		if err != nil {
+			t.Fatalf("error unexpectedly not nil:  %v", err)
+		}
+		if fs.Type() != filesystem.TypeExFAT || fs.Label() != "EXFAT" {
+			t.Errorf("mismatched filesystem type %v with label %q", fs.Type(), fs.Label())
+		}
+	})
+	t.Run("ext4", func(t *testing.T) {
+		f, err := tmpDisk("")
+		if err != nil {
+			t.Fatalf("error creating new temporary disk: %v", err)
+		}
+		defer f.Close()
+
+		if keepTmpFiles {
+			defer os.Remove(f.Name())
+		} else {
+			fmt.Println(f.Name())
+		}
+
+		fileInfo, err := f.Stat()
+		if err != nil {
+			t.Fatalf("error reading info on temporary disk: %v", err)
+		}
+
+		d := &disk.Disk{
+			File:              f,
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Info:              fileInfo,
+			Size:              fileInfo.Size(),
+			Writable:          true,
+		}
+		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeExt4, VolumeLabel: "go-diskfs"})
+		if err != nil {
+			t.Fatalf("error unexpectedly not nil:  %v", err)
+		}
+		if fs.Type() != filesystem.TypeExt4 {
+			t.Errorf("mismatched filesystem type %v", fs.Type())
+		}
+		fs, err = d.GetFilesystem(0)
+		if err != nil {
+			t.Fatalf("error unexpectedly not nil:  %v", err)
+		}
+		if fs.Type() != filesystem.TypeExt4 || fs.Label() != "go-diskfs" {
+			t.Errorf("mismatched filesystem type %v with label %q", fs.Type(), fs.Label())
+		}
+	})
 	t.Run("readonly", func(t *testing.T) {
 		d := &disk.Disk{
 			Writable: false,
@@ -653,4 +738,208 @@ func TestGetFilesystem(t *testing.T) {
 			t.Errorf("returned filesystem was unexpectedly nil")
 		}
 	})
+	t.Run("erofs", func(t *testing.T) {
+		f, err := tmpDisk("")
+		if err != nil {
+			t.Fatalf("error creating new temporary disk: %v", err)
+		}
+		defer f.Close()
+
+		if keepTmpFiles {
+			defer os.Remove(f.Name())
+		} else {
+			fmt.Println(f.Name())
+		}
+
+		fileInfo, err := f.Stat()
+		if err != nil {
+			t.Fatalf("error reading info on temporary disk: %v", err)
+		}
+
+		d := &disk.Disk{
+			File:              f,
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Info:              fileInfo,
+			Size:              fileInfo.Size(),
+			Writable:          true,
+		}
+		if _, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeEROFS}); err == nil {
+			t.Errorf("created read-only erofs without error")
+		}
+		efs, err := erofs.Create(f, fileInfo.Size(), 0, 0)
+		if err != nil {
+			t.Fatalf("error creating erofs: %v", err)
+		}
+		defer os.RemoveAll(efs.Workspace())
+		if err := efs.SetLabel("go-diskfs"); err != nil {
+			t.Fatalf("error setting label: %v", err)
+		}
+		if err := efs.Finalize(erofs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing erofs: %v", err)
+		}
+		fs, err := d.GetFilesystem(0)
+		if err != nil {
+			t.Fatalf("error unexpectedly not nil:  %v", err)
+		}
+		if fs.Type() != filesystem.TypeEROFS || fs.Label() != "go-diskfs" {
+			t.Errorf("mismatched filesystem type %v with label %q", fs.Type(), fs.Label())
+		}
+	})
+	t.Run("udf", func(t *testing.T) {
+		f, err := tmpDisk("")
+		if err != nil {
+			t.Fatalf("error creating new temporary disk: %v", err)
+		}
+		defer f.Close()
+
+		if keepTmpFiles {
+			defer os.Remove(f.Name())
+		} else {
+			fmt.Println(f.Name())
+		}
+
+		fileInfo, err := f.Stat()
+		if err != nil {
+			t.Fatalf("error reading info on temporary disk: %v", err)
+		}
+
+		d := &disk.Disk{
+			File:              f,
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Info:              fileInfo,
+			Size:              fileInfo.Size(),
+			Writable:          true,
+		}
+		created, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeUDF, VolumeLabel: "go-diskfs"})
+		if err != nil {
+			t.Fatalf("error creating udf: %v", err)
+		}
+		ufs := created.(*udf.FileSystem)
+		defer os.RemoveAll(ufs.Workspace())
+		if err := ufs.Finalize(udf.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing udf: %v", err)
+		}
+		fs, err := d.GetFilesystem(0)
+		if err != nil {
+			t.Fatalf("error unexpectedly not nil:  %v", err)
+		}
+		if fs.Type() != filesystem.TypeUDF || fs.Label() != "go-diskfs" {
+			t.Errorf("mismatched filesystem type %v with label %q", fs.Type(), fs.Label())
+		}
+	})
+}
+
+// sectionFile is a util.File presenting a section of a larger file
+type sectionFile struct {
+	f      *os.File
+	offset int64
+}
+
+func (s *sectionFile) ReadAt(b []byte, off int64) (int, error) {
+	return s.f.ReadAt(b, s.offset+off)
+}
+
+func (s *sectionFile) WriteAt(b []byte, off int64) (int, error) {
+	return s.f.WriteAt(b, s.offset+off)
+}
+
+func (s *sectionFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("seek not supported")
+}
+
+func TestNew(t *testing.T) {
+	f, err := tmpDisk("")
+	if err != nil {
+		t.Fatalf("error creating new temporary disk: %v", err)
+	}
+	defer f.Close()
+	if keepTmpFiles {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			f        *os.File
+			size     int64
+			lbs, pbs int64
+			err      string
+		}{
+			{nil, 10, 0, 0, "must pass a file"},
+			{f, 0, 0, 0, "invalid disk size 0"},
+			{f, 10, 1000, 0, "invalid logical block size 1000"},
+			{f, 10, 4096, 512, "invalid physical block size 512"},
+		}
+		for i, tt := range tests {
+			var err error
+			if tt.f == nil {
+				_, err = disk.New(nil, tt.size, tt.lbs, tt.pbs)
+			} else {
+				_, err = disk.New(tt.f, tt.size, tt.lbs, tt.pbs)
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%d: mismatched error, actual %v expected prefix %q", i, err, tt.err)
+			}
+		}
+	})
+
+	t.Run("section of a file", func(t *testing.T) {
+		// the disk starts 1MB into the file, and takes up the rest of it
+		offset := int64(1024 * 1024)
+		size := int64(9 * 1024 * 1024)
+		d, err := disk.New(&sectionFile{f: f, offset: offset}, size, 0, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d.LogicalBlocksize != 512 || d.PhysicalBlocksize != 512 || !d.DefaultBlocks || !d.Writable || d.Table != nil {
+			t.Errorf("unexpected disk %#v", d)
+		}
+		table := &gpt.Table{
+			Partitions: []*gpt.Partition{
+				{Start: 2048, End: 2048 + 5*1024*2 - 1, Type: gpt.LinuxFilesystem, Name: "data"},
+			},
+			LogicalSectorSize: 512,
+			ProtectiveMBR:     true,
+		}
+		if err := d.Partition(table); err != nil {
+			t.Fatalf("unable to partition: %v", err)
+		}
+		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "SECTION"})
+		if err != nil {
+			t.Fatalf("unable to create filesystem: %v", err)
+		}
+		if err := fs.Mkdir("/FOO"); err != nil {
+			t.Fatalf("unable to mkdir: %v", err)
+		}
+
+		// nothing was written before the section
+		b := make([]byte, offset)
+		if _, err := f.ReadAt(b, 0); err != nil {
+			t.Fatalf("unable to read file: %v", err)
+		}
+		if !bytes.Equal(b, make([]byte, offset)) {
+			t.Errorf("disk wrote outside its section")
+		}
+
+		d2, err := disk.New(&sectionFile{f: f, offset: offset}, size, 512, 512)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d2.Table == nil {
+			t.Fatalf("partition table was not read")
+		}
+		fs2, err := d2.GetFilesystem(1)
+		if err != nil {
+			t.Fatalf("unable to read filesystem: %v", err)
+		}
+		if label := strings.TrimSpace(fs2.Label()); label != "SECTION" {
+			t.Errorf("label %q instead of SECTION", label)
+		}
+		if _, err := fs2.ReadDir("/FOO"); err != nil {
+			t.Errorf("unable to read directory: %v", err)
+		}
+	})
 }
@@ -5,6 +5,7 @@ package disk
 
 import (
 	"fmt"
+	"os"
 
 	"golang.org/x/sys/unix"
 )
@@ -16,9 +17,15 @@ const (
 // ReReadPartitionTable forces the kernel to re-read the partition table
 // on the disk.
 //
-// It is done via an ioctl call with request as BLKRRPART.
+// It is done via an ioctl call with request as BLKRRPART. If the disk is not accessed
+// directly through an *os.File, e.g. a qcow2 image, there is nothing the kernel can re-read,
+// and it does nothing.
 func (d *Disk) ReReadPartitionTable() error {
-	fd := d.File.Fd()
+	f, ok := d.File.(*os.File)
+	if !ok {
+		return nil
+	}
+	fd := f.Fd()
 	_, err := unix.IoctlGetInt(int(fd), blkrrpart)
 	if err != nil {
 		return fmt.Errorf("unable to re-read partition table: %v", err)
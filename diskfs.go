@@ -111,6 +111,11 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/format/qcow2"
+	"github.com/diskfs/go-diskfs/format/vhd"
+	"github.com/diskfs/go-diskfs/format/vhdx"
+	"github.com/diskfs/go-diskfs/format/vmdk"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 // when we use a disk image with a GPT, we cannot get the logical sector size from the disk via the kernel
@@ -129,8 +134,44 @@ type Format int
 const (
 	// Raw disk format for basic raw disk
 	Raw Format = iota
+	// Qcow2 disk format for a QEMU qcow2 image, see github.com/diskfs/go-diskfs/format/qcow2
+	Qcow2
+	// VHDFixed disk format for a fixed size Microsoft VHD image, see github.com/diskfs/go-diskfs/format/vhd
+	VHDFixed
+	// VHDDynamic disk format for a dynamically growing Microsoft VHD image
+	VHDDynamic
+	// VHDXFixed disk format for a fixed size Microsoft VHDX image, see github.com/diskfs/go-diskfs/format/vhdx
+	VHDXFixed
+	// VHDXDynamic disk format for a dynamically growing Microsoft VHDX image
+	VHDXDynamic
+	// VMDK disk format for a VMware image, see github.com/diskfs/go-diskfs/format/vmdk.
+	// Create makes a streamOptimized image, which is complete only once the Disk is closed;
+	// Open reads monolithicSparse and streamOptimized images, and only with ReadOnly.
+	VMDK
 )
 
+// Format.String()
+func (f Format) String() string {
+	switch f {
+	case Raw:
+		return "raw"
+	case Qcow2:
+		return "qcow2"
+	case VHDFixed:
+		return "vhd-fixed"
+	case VHDDynamic:
+		return "vhd-dynamic"
+	case VHDXFixed:
+		return "vhdx-fixed"
+	case VHDXDynamic:
+		return "vhdx-dynamic"
+	case VMDK:
+		return "vmdk"
+	default:
+		return "unknown"
+	}
+}
+
 // OpenModeOption represents file open modes
 type OpenModeOption int
 
@@ -181,7 +222,93 @@ func writableMode(mode OpenModeOption) bool {
 	return false
 }
 
-func initDisk(f *os.File, openMode OpenModeOption, sectorSize SectorSize) (*disk.Disk, error) {
+// image is a disk image format presenting the virtual disk it contains
+type image interface {
+	util.File
+	Size() int64
+}
+
+// openImage opens the disk image of the given format in f, returning nil for a Raw disk
+func openImage(f *os.File, format Format, writable bool) (image, error) {
+	switch format {
+	case Raw:
+		return nil, nil
+	case Qcow2:
+		img, err := qcow2.Open(f, writable)
+		if err != nil {
+			return nil, fmt.Errorf("could not open qcow2 image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	case VHDFixed, VHDDynamic:
+		// either type can be opened with either format; the image knows which one it is
+		img, err := vhd.Open(f, writable)
+		if err != nil {
+			return nil, fmt.Errorf("could not open VHD image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	case VHDXFixed, VHDXDynamic:
+		img, err := vhdx.Open(f, writable)
+		if err != nil {
+			return nil, fmt.Errorf("could not open VHDX image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	case VMDK:
+		img, err := vmdk.Open(f, writable)
+		if err != nil {
+			return nil, fmt.Errorf("could not open VMDK image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unsupported disk format %v", format)
+	}
+}
+
+// createImage creates a new disk image of the given format in f, returning nil for a Raw disk
+func createImage(f *os.File, size int64, format Format, sectorSize SectorSize) (image, error) {
+	switch format {
+	case Raw:
+		if err := f.Truncate(size); err != nil {
+			return nil, fmt.Errorf("could not expand device %s to size %d", f.Name(), size)
+		}
+		return nil, nil
+	case Qcow2:
+		img, err := qcow2.Create(f, size, 0)
+		if err != nil {
+			return nil, fmt.Errorf("could not create qcow2 image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	case VHDFixed, VHDDynamic:
+		diskType := vhd.Fixed
+		if format == VHDDynamic {
+			diskType = vhd.Dynamic
+		}
+		img, err := vhd.Create(f, size, diskType, 0)
+		if err != nil {
+			return nil, fmt.Errorf("could not create VHD image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	case VHDXFixed, VHDXDynamic:
+		diskType := vhdx.Fixed
+		if format == VHDXDynamic {
+			diskType = vhdx.Dynamic
+		}
+		img, err := vhdx.Create(f, size, diskType, 0, int64(sectorSize))
+		if err != nil {
+			return nil, fmt.Errorf("could not create VHDX image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	case VMDK:
+		img, err := vmdk.Create(f, size)
+		if err != nil {
+			return nil, fmt.Errorf("could not create VMDK image %s: %v", f.Name(), err)
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unsupported disk format %v", format)
+	}
+}
+
+func initDisk(f *os.File, openMode OpenModeOption, sectorSize SectorSize, img image) (*disk.Disk, error) {
 	var (
 		diskType      disk.Type
 		size          int64
@@ -237,24 +364,27 @@ func initDisk(f *os.File, openMode OpenModeOption, sectorSize SectorSize) (*disk
 
 	writable := writableMode(openMode)
 
-	ret := &disk.Disk{
-		File:              f,
-		Info:              devInfo,
-		Type:              diskType,
-		Size:              size,
-		LogicalBlocksize:  lblksize,
-		PhysicalBlocksize: pblksize,
-		Writable:          writable,
-		DefaultBlocks:     defaultBlocks,
+	// for anything but a raw disk, all access goes through the image format
+	var backend util.File = f
+	if img != nil {
+		backend = img
+		size = img.Size()
+		if sized, ok := img.(interface{ LogicalSectorSize() int64 }); ok && sectorSize == SectorSizeDefault {
+			lblksize = sized.LogicalSectorSize()
+			if pblksize < lblksize {
+				pblksize = lblksize
+			}
+		}
 	}
 
-	// try to initialize the partition table.
-	// we ignore errors, because it is perfectly fine to open a disk
-	// and use it before it has a partition table. This is solely
-	// a convenience.
-	if table, err := ret.GetPartitionTable(); err == nil && table != nil {
-		ret.Table = table
+	ret, err := disk.New(backend, size, lblksize, pblksize)
+	if err != nil {
+		return nil, fmt.Errorf("could not create disk for device %s: %v", f.Name(), err)
 	}
+	ret.Info = devInfo
+	ret.Type = diskType
+	ret.Writable = writable
+	ret.DefaultBlocks = defaultBlocks
 	return ret, nil
 }
 
@@ -272,12 +402,14 @@ func checkDevice(device string) error {
 type openOpts struct {
 	mode       OpenModeOption
 	sectorSize SectorSize
+	format     Format
 }
 
 func openOptsDefaults() *openOpts {
 	return &openOpts{
 		mode:       ReadWriteExclusive,
 		sectorSize: SectorSizeDefault,
+		format:     Raw,
 	}
 }
 
@@ -302,6 +434,16 @@ func WithSectorSize(sectorSize SectorSize) OpenOpt {
 	}
 }
 
+// WithFormat opens the disk file or block device as an image of the given Format.
+// Defaults to Raw. The format is not detected automatically, as a raw disk could
+// contain data that looks like the header of another format.
+func WithFormat(format Format) OpenOpt {
+	return func(o *openOpts) error {
+		o.format = format
+		return nil
+	}
+}
+
 // Open a Disk from a path to a device in read-write exclusive mode
 // Should pass a path to a block device e.g. /dev/sda or a path to a file /tmp/foo.img
 // The provided device must exist at the time you call Open().
@@ -328,13 +470,26 @@ func Open(device string, opts ...OpenOpt) (*disk.Disk, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not open device %s exclusively for writing", device)
 	}
+	img, err := openImage(f, opt.format, writableMode(opt.mode))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
 	// return our disk
-	return initDisk(f, ReadWriteExclusive, opt.sectorSize)
+	d, err := initDisk(f, opt.mode, opt.sectorSize, img)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
 // Create a Disk from a path to a device
 // Should pass a path to a block device e.g. /dev/sda or a path to a file /tmp/foo.img
 // The provided device must not exist at the time you call Create()
+//
+// size is the size of the disk as seen by its users; for an image format other than Raw,
+// the file itself starts out much smaller and grows as the disk is written.
 func Create(device string, size int64, format Format, sectorSize SectorSize) (*disk.Disk, error) {
 	if device == "" {
 		return nil, errors.New("must pass device name")
@@ -346,10 +501,16 @@ func Create(device string, size int64, format Format, sectorSize SectorSize) (*d
 	if err != nil {
 		return nil, fmt.Errorf("could not create device %s", device)
 	}
-	err = os.Truncate(device, size)
+	img, err := createImage(f, size, format, sectorSize)
 	if err != nil {
-		return nil, fmt.Errorf("could not expand device %s to size %d", device, size)
+		f.Close()
+		return nil, err
 	}
 	// return our disk
-	return initDisk(f, ReadWriteExclusive, sectorSize)
+	d, err := initDisk(f, ReadWriteExclusive, sectorSize, img)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
 }
@@ -11,6 +11,8 @@ import (
 
 	diskfs "github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
 )
 
 const oneMB = 10 * 1024 * 1024
@@ -113,6 +115,13 @@ func TestCreate(t *testing.T) {
 		{"10MB with default sector size", "disk", 10 * oneMB, diskfs.Raw, diskfs.SectorSizeDefault, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
 		{"10MB with 512 sector size", "disk", 10 * oneMB, diskfs.Raw, diskfs.SectorSize512, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
 		{"10MB with 2048 sector size", "disk", 10 * oneMB, diskfs.Raw, diskfs.SectorSize4k, &disk.Disk{LogicalBlocksize: 4096, PhysicalBlocksize: 4096, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"10MB qcow2", "disk", 10 * oneMB, diskfs.Qcow2, diskfs.SectorSizeDefault, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"10MB fixed VHD", "disk", 10 * oneMB, diskfs.VHDFixed, diskfs.SectorSizeDefault, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"10MB dynamic VHD", "disk", 10 * oneMB, diskfs.VHDDynamic, diskfs.SectorSizeDefault, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"10MB fixed VHDX", "disk", 10 * oneMB, diskfs.VHDXFixed, diskfs.SectorSizeDefault, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"10MB dynamic VHDX with 4k sectors", "disk", 10 * oneMB, diskfs.VHDXDynamic, diskfs.SectorSize4k, &disk.Disk{LogicalBlocksize: 4096, PhysicalBlocksize: 4096, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"10MB VMDK", "disk", 10 * oneMB, diskfs.VMDK, diskfs.SectorSizeDefault, &disk.Disk{LogicalBlocksize: 512, PhysicalBlocksize: 512, Size: 10 * oneMB, Type: disk.File}, nil},
+		{"unknown format", "disk", 10 * oneMB, diskfs.Format(100), diskfs.SectorSizeDefault, nil, fmt.Errorf("unsupported disk format")},
 	}
 
 	for i, tt := range tests {
@@ -130,6 +139,88 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestImageFormats(t *testing.T) {
+	tests := []struct {
+		format diskfs.Format
+		// whether the image file should be smaller than the disk after writing a filesystem
+		sparse bool
+	}{
+		{diskfs.Qcow2, true},
+		{diskfs.VHDFixed, false},
+		{diskfs.VHDDynamic, true},
+		{diskfs.VHDXFixed, false},
+		{diskfs.VHDXDynamic, false},
+		{diskfs.VMDK, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.format.String(), func(t *testing.T) {
+			filename := testTmpFilename(t, "diskfs_test", ".img")
+			defer os.RemoveAll(filename)
+			size := int64(20 * oneMB)
+			d, err := diskfs.Create(filename, size, tt.format, diskfs.SectorSizeDefault)
+			if err != nil {
+				t.Fatalf("error creating %v disk: %v", tt.format, err)
+			}
+			table := &gpt.Table{
+				LogicalSectorSize:  512,
+				PhysicalSectorSize: 512,
+				ProtectiveMBR:      true,
+				Partitions: []*gpt.Partition{
+					{Start: 2048, End: uint64(size/512) - 2048, Type: gpt.LinuxFilesystem, Name: "data"},
+				},
+			}
+			if err := d.Partition(table); err != nil {
+				t.Fatalf("error partitioning %v disk: %v", tt.format, err)
+			}
+			fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "IMAGE"})
+			if err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			if err := fs.Mkdir("/FOO"); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+			if err := d.Close(); err != nil {
+				t.Fatalf("error closing %v disk: %v", tt.format, err)
+			}
+			fileInfo, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("error getting info for %s: %v", filename, err)
+			}
+			if tt.sparse && fileInfo.Size() >= size {
+				t.Errorf("%v image file is %d bytes, not smaller than the %d byte disk", tt.format, fileInfo.Size(), size)
+			}
+
+			d2, err := diskfs.Open(filename, diskfs.WithFormat(tt.format), diskfs.WithOpenMode(diskfs.ReadOnly))
+			if err != nil {
+				t.Fatalf("error opening %v disk: %v", tt.format, err)
+			}
+			if d2.Size != size {
+				t.Errorf("opened disk size %d instead of %d", d2.Size, size)
+			}
+			if d2.Table == nil {
+				t.Fatalf("opened disk has no partition table")
+			}
+			fs2, err := d2.GetFilesystem(1)
+			if err != nil {
+				t.Fatalf("error reading filesystem: %v", err)
+			}
+			if label := strings.TrimSpace(fs2.Label()); label != "IMAGE" {
+				t.Errorf("filesystem label %q instead of %q", label, "IMAGE")
+			}
+			if _, err := fs2.ReadDir("/FOO"); err != nil {
+				t.Errorf("error reading directory: %v", err)
+			}
+			if d2.Writable {
+				t.Errorf("disk opened read-only is writable")
+			}
+			if err := d2.Close(); err != nil {
+				t.Errorf("error closing disk: %v", err)
+			}
+		})
+	}
+}
+
 func testTmpFilename(t *testing.T, prefix, suffix string) string {
 	t.Helper()
 	randBytes := make([]byte, 16)
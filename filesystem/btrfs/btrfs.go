@@ -0,0 +1,447 @@
+package btrfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// maxSymlinkHops is how many symlinks Stat follows before giving up, as Linux does
+	maxSymlinkHops = 40
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	superblock *superblock
+	size       int64
+	start      int64
+	file       util.File
+	// chunks map logical addresses to this device, in order of their logical addresses
+	chunks   []*chunk
+	nodes    map[uint64]*node
+	rootTree tree
+	roots    map[uint64]*root
+	// subvolume is the ID of the subvolume that is read as the root of the filesystem
+	subvolume uint64
+}
+
+// Read reads a filesystem from a given disk, with its default subvolume as the root.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// note that you are *not* required to read a filesystem on the entire disk. You could have a disk of size
+// 20GB, and a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for working with filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// The sector and node sizes are taken from the superblock, so blocksize is only checked: it must be 0, or a
+// power of 2 from 512 to 4096 bytes.
+//
+// The filesystem is read-only: every method that would change it returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	return ReadSubvolume(file, size, start, blocksize, "")
+}
+
+// ReadSubvolume reads a filesystem from a given disk, like Read, with the subvolume at the path subvolume as the
+// root. The path is from the top level subvolume, whose own path is "/", whatever the default subvolume is,
+// like the subvol option of mount. An empty subvolume reads the default one.
+//
+// Only a single device is read, so chunks that are only on other devices of the filesystem, or striped
+// across them with RAID0, RAID10, RAID5 or RAID6, cannot be read.
+func ReadSubvolume(file util.File, size, start, blocksize int64, subvolume string) (*FileSystem, error) {
+	switch blocksize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("blocksize for btrfs must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	fs := &FileSystem{
+		size:  size,
+		start: start,
+		file:  file,
+		nodes: map[uint64]*node{},
+		roots: map[uint64]*root{},
+	}
+	b := make([]byte, superblockSize)
+	if err := fs.readFull(b, superblockOffset); err != nil {
+		return nil, fmt.Errorf("unable to read bytes for superblock: %v", err)
+	}
+	sb, err := parseSuperblock(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing superblock: %v", err)
+	}
+	fs.superblock = sb
+	if err := fs.loadChunks(); err != nil {
+		return nil, err
+	}
+	fs.rootTree = tree{bytenr: sb.root, level: sb.rootLevel}
+
+	if subvolume == "" {
+		fs.subvolume, err = fs.defaultSubvolume()
+	} else {
+		fs.subvolume, err = fs.subvolumeByPath(subvolume)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find subvolume %s: %v", subvolume, err)
+	}
+	if _, err := fs.readRoot(fs.subvolume); err != nil {
+		return nil, fmt.Errorf("unable to read subvolume %d: %v", fs.subvolume, err)
+	}
+	return fs, nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeBtrfs
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeBtrfs
+}
+
+// Label return the filesystem label
+func (fs *FileSystem) Label() string {
+	return fs.superblock.label
+}
+
+// SetLabel changes the label on the filesystem, but btrfs is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) SetLabel(label string) error {
+	return fmt.Errorf("cannot set label on btrfs filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Statfs get the capacity of the filesystem, from its superblock. As it is read-only, it has no free space, and
+// btrfs does not count its files.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	total := int64(fs.superblock.totalBytes)
+	return filesystem.Statfs{
+		BlockSize:  int64(fs.superblock.sectorSize),
+		TotalBytes: total,
+		UsedBytes:  total,
+	}, nil
+}
+
+// Mkdir make a directory at the given path, but btrfs is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Mkdir(p string) error {
+	return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory, in the order they were created.
+// A subvolume in the directory is included as the directory at its root.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	in, err := fs.lookup(fs.subvolume, p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	entries, err := fs.readDirectory(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+	}
+	fi := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi = append(fi, e)
+	}
+	return fi, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+//
+// accepts normal os.OpenFile flags, but as btrfs is read-only, any that would write return an error wrapping
+// filesystem.ErrReadonlyFilesystem
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if de.IsDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	if de.inode.mode&modeTypeMask != modeRegular {
+		return nil, fmt.Errorf("cannot open %s, which is not a regular file", p)
+	}
+	return fs.newFile(de.inode)
+}
+
+// Stat returns the FileInfo for a file or directory. If p is a symlink, Stat returns the FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !de.inode.isSymlink() {
+			return de, nil
+		}
+		target, err := fs.readlink(de.inode)
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, fmt.Errorf("too many levels of symlinks at %s", p)
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	return fs.lstat(p)
+}
+
+// Readlink returns the target of a symlink.
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	de, err := fs.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if !de.inode.isSymlink() {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	return fs.readlink(de.inode)
+}
+
+// Symlink creates newname as a symlink to oldname, but btrfs is read-only, so this always returns an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return fmt.Errorf("cannot create symlink %s: %w", newname, filesystem.ErrReadonlyFilesystem)
+}
+
+// Getxattr returns the value of the named extended attribute of p. Returns an error wrapping
+// filesystem.ErrXattrNotExist if p does not have it.
+func (fs *FileSystem) Getxattr(p, name string) ([]byte, error) {
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := fs.readRoot(de.inode.subvolume)
+	if err != nil {
+		return nil, err
+	}
+	x, err := fs.findDirItem(r.tree, de.inode.number, itemXattr, name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read xattrs of %s: %v", p, err)
+	}
+	if x == nil {
+		return nil, fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	return x.data, nil
+}
+
+// Listxattr returns the sorted names of the extended attributes of p
+func (fs *FileSystem) Listxattr(p string) ([]string, error) {
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := fs.readRoot(de.inode.subvolume)
+	if err != nil {
+		return nil, err
+	}
+	items, err := fs.items(r.tree, de.inode.number, itemXattr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read xattrs of %s: %v", p, err)
+	}
+	names := []string{}
+	for _, it := range items {
+		xattrs, err := parseDirItems(it.data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid xattr of %s: %v", p, err)
+		}
+		for _, x := range xattrs {
+			names = append(names, x.name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Setxattr sets the named extended attribute of p, but btrfs is read-only, so this always returns an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Setxattr(p, name string, value []byte) error {
+	return fmt.Errorf("cannot set xattr on %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// Removexattr removes the named extended attribute of p, but btrfs is read-only, so this always returns an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Removexattr(p, name string) error {
+	return fmt.Errorf("cannot remove xattr from %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// Remove remove a file or empty directory, but btrfs is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// RemoveAll remove a file or directory and everything in it, but btrfs is read-only, so this always returns an
+// error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// Rename rename a file or directory, but btrfs is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	return fmt.Errorf("cannot rename %s: %w", oldpath, filesystem.ErrReadonlyFilesystem)
+}
+
+// Truncate change the size of a file, but btrfs is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return fmt.Errorf("cannot truncate %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// lstat finds the directory entry for p, without following a symlink at the end of it
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	in, err := fs.lookup(fs.subvolume, p)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(path.Join("/", universalizePath(p)))
+	return newDirectoryEntry(name, in), nil
+}
+
+// lookup finds the inode at p from the root of a subvolume, by the names in each directory, crossing into any
+// subvolume on the way
+func (fs *FileSystem) lookup(subvolume uint64, p string) (*inode, error) {
+	r, err := fs.readRoot(subvolume)
+	if err != nil {
+		return nil, err
+	}
+	in, err := fs.readInode(subvolume, r.dirID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read root directory of subvolume %d: %v", subvolume, err)
+	}
+	name := "/"
+	for _, part := range splitPath(p) {
+		if !in.isDir() {
+			return nil, fmt.Errorf("%s in path %s is not a directory", name, p)
+		}
+		r, err := fs.readRoot(in.subvolume)
+		if err != nil {
+			return nil, err
+		}
+		d, err := fs.findDirItem(r.tree, in.number, itemDirItem, part)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %v", name, err)
+		}
+		if d == nil {
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+		}
+		if in, err = fs.entryInode(in.subvolume, d.location); err != nil {
+			return nil, fmt.Errorf("error finding %s: %v", p, err)
+		}
+		name = part
+	}
+	return in, nil
+}
+
+// readDirectory returns the entries of a directory, from its index, without . and ..
+func (fs *FileSystem) readDirectory(dir *inode) ([]*directoryEntry, error) {
+	if !dir.isDir() {
+		return nil, fmt.Errorf("inode %d is not a directory", dir.number)
+	}
+	r, err := fs.readRoot(dir.subvolume)
+	if err != nil {
+		return nil, err
+	}
+	items, err := fs.items(r.tree, dir.number, itemDirIndex)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*directoryEntry, 0, len(items))
+	for _, it := range items {
+		dirItems, err := parseDirItems(it.data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid directory index %d: %v", it.key.offset, err)
+		}
+		for _, d := range dirItems {
+			in, err := fs.entryInode(dir.subvolume, d.location)
+			if err != nil {
+				return nil, fmt.Errorf("error finding inode for %s: %v", d.name, err)
+			}
+			entries = append(entries, newDirectoryEntry(d.name, in))
+		}
+	}
+	return entries, nil
+}
+
+// entryInode reads the inode a directory entry in subvolume points to, which for a subvolume in it is the root
+// directory of that subvolume
+func (fs *FileSystem) entryInode(subvolume uint64, location key) (*inode, error) {
+	switch location.itemType {
+	case itemInode:
+		return fs.readInode(subvolume, location.objectID)
+	case itemRoot:
+		r, err := fs.readRoot(location.objectID)
+		if err != nil {
+			return nil, err
+		}
+		return fs.readInode(location.objectID, r.dirID)
+	default:
+		return nil, fmt.Errorf("directory entry points to item of type %d", location.itemType)
+	}
+}
+
+// readInode reads inode number of subvolume
+func (fs *FileSystem) readInode(subvolume, number uint64) (*inode, error) {
+	r, err := fs.readRoot(subvolume)
+	if err != nil {
+		return nil, err
+	}
+	it, err := fs.find(r.tree, key{number, itemInode, 0})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inode %d of subvolume %d: %v", number, subvolume, err)
+	}
+	if it == nil {
+		return nil, fmt.Errorf("inode %d of subvolume %d does not exist", number, subvolume)
+	}
+	return parseInode(it.data, subvolume, number)
+}
+
+// readlink returns the target of a symlink inode
+func (fs *FileSystem) readlink(in *inode) (string, error) {
+	fl, err := fs.newFile(in)
+	if err != nil {
+		return "", fmt.Errorf("could not read symlink target: %v", err)
+	}
+	b := make([]byte, in.size)
+	if _, err := io.ReadFull(fl, b); err != nil && !(errors.Is(err, io.EOF) && in.size == 0) {
+		return "", fmt.Errorf("could not read symlink target: %v", err)
+	}
+	return string(b), nil
+}
+
+// readFull reads all of b from pos in the filesystem
+func (fs *FileSystem) readFull(b []byte, pos int64) error {
+	if fs.size > 0 && pos+int64(len(b)) > fs.size {
+		return fmt.Errorf("cannot read %d bytes at %d, past the end of the filesystem at %d", len(b), pos, fs.size)
+	}
+	n, err := fs.file.ReadAt(b, fs.start+pos)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(b)) {
+		return fmt.Errorf("error reading %d bytes at %d: %v", len(b), pos, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package btrfs
+
+import (
+	"testing"
+)
+
+func TestNameHash(t *testing.T) {
+	if hash := nameHash("default"); hash != 0x8dbfc2d2 {
+		t.Errorf("hash %#x instead of %#x", hash, 0x8dbfc2d2)
+	}
+}
@@ -0,0 +1,674 @@
+package btrfs_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/btrfs"
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+const (
+	imageSize = 256 * btrfs.MB
+	label     = "go-diskfs"
+	// where the superblock is, and where in it are the fsid, the root tree, the incompatible features and the label
+	superblockOffset = 64 * btrfs.KB
+	superblockSize   = 4096
+	fsidOffset       = 0x20
+	rootOffset       = 0x50
+	incompatOffset   = 0xbc
+	// where in a node header is the logical address of the node
+	nodeBytenrOffset = 0x30
+)
+
+var modTime = time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+
+// tree is what the test images are made from, written to a directory for mkfs.btrfs to copy in
+type tree struct {
+	big       []byte
+	text      []byte
+	manyFiles int
+	// xattrs is whether the xattrs of the tree could be set, which the filesystem of the directory might not allow
+	xattrs bool
+}
+
+func newTree(t *testing.T) *tree {
+	t.Helper()
+	big := make([]byte, 300*1024+17)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatalf("unable to generate random data: %v", err)
+	}
+	// text that compresses well, over several compressed extents, which are at most 128 KB
+	var text []byte
+	for i := 0; len(text) < 1024*1024; i++ {
+		text = append(text, fmt.Sprintf("line %d of a file that compresses well\n", i)...)
+	}
+	return &tree{big: big, text: text, manyFiles: 600}
+}
+
+// files returns the regular files of the tree and their contents
+func (tr *tree) files() map[string][]byte {
+	files := map[string][]byte{
+		"hello.txt":            []byte("hello world\n"),
+		"big.bin":              tr.big,
+		"text.txt":             tr.text,
+		"inline.txt":           tr.text[:2000],
+		"empty":                nil,
+		"private.txt":          []byte("private\n"),
+		"sparse.bin":           tr.sparse(),
+		"dir/sub/nested.txt":   bytes.Repeat([]byte("nested "), 100),
+		"@home/user/notes.txt": []byte("notes\n"),
+		"nested/sub/file.txt":  tr.text[:5000],
+	}
+	for i := 0; i < tr.manyFiles; i++ {
+		files[fmt.Sprintf("many/file_with_a_long_name_%04d", i)] = []byte(fmt.Sprintf("%d\n", i))
+	}
+	return files
+}
+
+// the sparse file has sparseData at sparseOffset and is otherwise empty
+const (
+	sparseSize   = 1024 * 1024
+	sparseOffset = 512*1024 + 1000
+)
+
+var sparseData = []byte("in the middle of nothing")
+
+func (tr *tree) sparse() []byte {
+	b := make([]byte, sparseSize)
+	copy(b[sparseOffset:], sparseData)
+	return b
+}
+
+func (tr *tree) write(t *testing.T, dir string) {
+	t.Helper()
+	for name, content := range tr.files() {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if name == "sparse.bin" {
+			// written as a hole and a little data, rather than as all of its bytes
+			f, err := os.Create(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Truncate(sparseSize); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt(sparseData, sparseOffset); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			continue
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(dir, "private.txt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "big.bin"), filepath.Join(dir, "dir", "hard.bin")); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"link":    "hello.txt",
+		"dirlink": "dir/sub",
+		"abslink": "/dir/sub/nested.txt",
+		"loop1":   "loop2",
+		"loop2":   "loop1",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name := range tr.files() {
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mkfs makes an image with testdata/mkbtrfs.sh, which runs mkfs.btrfs on the tree with the given extra arguments.
+// It skips the test if mkfs.btrfs is not available.
+func mkfs(t *testing.T, tr *tree, args []string) string {
+	t.Helper()
+	dir := t.TempDir()
+	tr.write(t, filepath.Join(dir, "src"))
+	img := filepath.Join(dir, "btrfs.img")
+	f, err := os.Create(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(imageSize); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	script, err := filepath.Abs(filepath.Join("testdata", "mkbtrfs.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdArgs := append([]string{"sh", script, img, label, "src"}, args...)
+	out, err := testhelper.RunTools(t, dir, []string{"mkfs.btrfs"}, cmdArgs...)
+	if err != nil {
+		t.Fatalf("%v failed: %v\n%s", cmdArgs, err, out)
+	}
+	tr.xattrs = bytes.Contains(out, []byte("xattrs set"))
+	return img
+}
+
+// requireOption skips the test if mkfs.btrfs does not have an option, which is a long option, looked for in its
+// help, or a feature, looked for in the features it lists, as older versions do not have all of them
+func requireOption(t *testing.T, option string) {
+	t.Helper()
+	args := []string{"mkfs.btrfs", "-O", "list-all"}
+	if strings.HasPrefix(option, "--") {
+		args = []string{"mkfs.btrfs", "--help"}
+	}
+	// older versions exit with an error after showing the help
+	out, _ := testhelper.RunTools(t, t.TempDir(), []string{"mkfs.btrfs"}, args...)
+	if !bytes.Contains(out, []byte(option)) {
+		t.Skipf("mkfs.btrfs does not have %s", option)
+	}
+}
+
+func openImage(t *testing.T, img string) *os.File {
+	t.Helper()
+	f, err := os.Open(img)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", img, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func readImage(t *testing.T, img string) *btrfs.FileSystem {
+	t.Helper()
+	fs, err := btrfs.Read(openImage(t, img), imageSize, 0, 512)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	return fs
+}
+
+func readFile(t *testing.T, fs *btrfs.FileSystem, p string) []byte {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", p, err)
+	}
+	defer f.Close()
+	// read in pieces that do not line up with the sectors
+	var data []byte
+	buf := make([]byte, 1000)
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read %s: %v", p, err)
+		}
+	}
+	return data
+}
+
+func TestRead(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name   string
+		args   []string
+		option string
+		// incompat are incompatible features the image must have, to be sure it has what is being tested
+		incompat uint64
+	}{
+		{"default", nil, "", 0},
+		{"4k nodes", []string{"-n", "4096"}, "", 0},
+		{"64k nodes", []string{"-n", "65536"}, "", 0},
+		{"mixed block groups", []string{"-M"}, "", 0x4},
+		{"without no-holes", []string{"-O", "^no-holes"}, "", 0},
+		{"block group tree", []string{"-O", "block-group-tree"}, "block-group-tree", 0},
+		{"single metadata", []string{"-m", "single"}, "", 0},
+		{"xxhash checksums", []string{"--csum", "xxhash"}, "--csum", 0},
+		{"sha256 checksums", []string{"--csum", "sha256"}, "--csum", 0},
+		{"zlib", []string{"--compress", "zlib"}, "--compress", 0},
+		{"lzo", []string{"--compress", "lzo"}, "--compress", 0x8},
+		{"zstd", []string{"--compress", "zstd"}, "--compress", 0x10},
+		{"zstd level 15", []string{"--compress", "zstd:15"}, "--compress", 0x10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.option != "" {
+				requireOption(t, tt.option)
+			}
+			img := mkfs(t, tr, tt.args)
+			f := openImage(t, img)
+			sb := make([]byte, superblockSize)
+			if _, err := f.ReadAt(sb, superblockOffset); err != nil {
+				t.Fatalf("unable to read superblock: %v", err)
+			}
+			if incompat := binary.LittleEndian.Uint64(sb[incompatOffset:]); incompat&tt.incompat != tt.incompat {
+				t.Fatalf("image has incompatible features %#x without %#x", incompat, tt.incompat)
+			}
+
+			fs, err := btrfs.Read(f, imageSize, 0, 512)
+			if err != nil {
+				t.Fatalf("unable to read filesystem: %v", err)
+			}
+			if fs.Type() != filesystem.TypeBtrfs {
+				t.Errorf("type %v instead of %v", fs.Type(), filesystem.TypeBtrfs)
+			}
+			if fs.Label() != label {
+				t.Errorf("label %q instead of %q", fs.Label(), label)
+			}
+			stat, err := fs.Statfs()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stat.BlockSize != 4096 || stat.TotalBytes != imageSize || stat.UsedBytes != imageSize {
+				t.Errorf("mismatched statfs %+v", stat)
+			}
+			for name, content := range tr.files() {
+				if b := readFile(t, fs, "/"+name); !bytes.Equal(b, content) {
+					t.Errorf("read %d bytes of %s that do not match the expected %d", len(b), name, len(content))
+				}
+			}
+			if b := readFile(t, fs, "/dir/hard.bin"); !bytes.Equal(b, tr.big) {
+				t.Errorf("read %d bytes of a hard link that do not match the expected %d", len(b), len(tr.big))
+			}
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	img := mkfs(t, newTree(t), nil)
+	f := openImage(t, img)
+	sb := make([]byte, superblockSize)
+	if _, err := f.ReadAt(sb, superblockOffset); err != nil {
+		t.Fatalf("unable to read superblock: %v", err)
+	}
+	// every copy of the root of the root tree, found by the address in its header
+	var roots []int64
+	root := binary.LittleEndian.Uint64(sb[rootOffset:])
+	node := make([]byte, nodeBytenrOffset+8)
+	for pos := int64(0); pos < imageSize; pos += 4096 {
+		if _, err := f.ReadAt(node, pos); err != nil {
+			t.Fatalf("unable to read image: %v", err)
+		}
+		if bytes.Equal(node[fsidOffset:fsidOffset+16], sb[fsidOffset:fsidOffset+16]) && binary.LittleEndian.Uint64(node[nodeBytenrOffset:]) == root {
+			roots = append(roots, pos)
+		}
+	}
+	if len(roots) == 0 {
+		t.Fatalf("unable to find root tree at %d", root)
+	}
+
+	withChecksum := func(b []byte) {
+		binary.LittleEndian.PutUint32(b[0:4], crc32.Checksum(b[32:], crc32.MakeTable(crc32.Castagnoli)))
+	}
+	tests := []struct {
+		name    string
+		changes map[int64][]byte
+	}{
+		{"not btrfs", map[int64][]byte{superblockOffset + 0x40: []byte("NOTBTRFS")}},
+		{"superblock checksum", map[int64][]byte{superblockOffset + 0x100: {0xff}}},
+		{"unsupported feature", func() map[int64][]byte {
+			changed := append([]byte{}, sb...)
+			changed[incompatOffset+1] |= 0x20
+			withChecksum(changed)
+			return map[int64][]byte{superblockOffset: changed}
+		}()},
+		{"node checksum", func() map[int64][]byte {
+			changes := map[int64][]byte{}
+			for _, pos := range roots {
+				changes[pos+0x70] = []byte{0xff, 0xff, 0xff, 0xff}
+			}
+			return changes
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := &testhelper.FileImpl{
+				Reader: func(b []byte, offset int64) (int, error) {
+					n, err := f.ReadAt(b, offset)
+					for pos, change := range tt.changes {
+						for i, c := range change {
+							if p := pos + int64(i) - offset; p >= 0 && p < int64(n) {
+								b[p] = c
+							}
+						}
+					}
+					return n, err
+				},
+			}
+			if _, err := btrfs.Read(changed, imageSize, 0, 512); err == nil {
+				t.Errorf("no error reading invalid image")
+			}
+		})
+	}
+	if _, err := btrfs.Read(f, imageSize, 0, 3000); err == nil {
+		t.Errorf("no error for invalid blocksize")
+	}
+}
+
+// mkfsSubvolumes makes an image with @home and nested/sub as subvolumes, skipping the test if mkfs.btrfs cannot
+func mkfsSubvolumes(t *testing.T) (string, *tree) {
+	t.Helper()
+	requireOption(t, "--subvol")
+	tr := newTree(t)
+	return mkfs(t, tr, []string{"--subvol", "@home", "--subvol", "nested/sub"}), tr
+}
+
+func TestReadSubvolume(t *testing.T) {
+	img, tr := mkfsSubvolumes(t)
+	f := openImage(t, img)
+	tests := []struct {
+		subvolume string
+		path      string
+		expected  []byte
+	}{
+		// the default subvolume, which is the top level one
+		{"", "/hello.txt", tr.files()["hello.txt"]},
+		{"/", "/@home/user/notes.txt", tr.files()["@home/user/notes.txt"]},
+		{"/@home", "/user/notes.txt", tr.files()["@home/user/notes.txt"]},
+		{"nested/sub", "/file.txt", tr.files()["nested/sub/file.txt"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subvolume, func(t *testing.T) {
+			fs, err := btrfs.ReadSubvolume(f, imageSize, 0, 512, tt.subvolume)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if b := readFile(t, fs, tt.path); !bytes.Equal(b, tt.expected) {
+				t.Errorf("read %d bytes that do not match the expected %d", len(b), len(tt.expected))
+			}
+		})
+	}
+	for _, subvolume := range []string{"/dir", "/missing", "/hello.txt/x", "/nested"} {
+		if _, err := btrfs.ReadSubvolume(f, imageSize, 0, 512, subvolume); err == nil {
+			t.Errorf("%s: no error reading a subvolume that does not exist", subvolume)
+		}
+	}
+}
+
+func TestSubvolumes(t *testing.T) {
+	img, _ := mkfsSubvolumes(t)
+	fs := readImage(t, img)
+	subvolumes, err := fs.Subvolumes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	paths := make([]string, 0, len(subvolumes))
+	for _, s := range subvolumes {
+		paths = append(paths, s.Path)
+	}
+	sort.Strings(paths)
+	if expected := []string{"/", "/@home", "/nested/sub"}; strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Fatalf("subvolumes %v instead of %v", paths, expected)
+	}
+	if subvolumes[0].ID != 5 || subvolumes[0].Path != "/" {
+		t.Errorf("first subvolume %+v instead of the top level one", subvolumes[0])
+	}
+	for _, s := range subvolumes[1:] {
+		if s.ParentID != 5 {
+			t.Errorf("subvolume %+v is not in the top level one", s)
+		}
+		fi, err := fs.Stat(s.Path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sys := fi.Sys().(btrfs.FileStat)
+		if !fi.IsDir() || sys.Subvolume() != s.ID || sys.Inode() != 256 {
+			t.Errorf("%s is subvolume %d inode %d instead of the root of subvolume %d", s.Path, sys.Subvolume(), sys.Inode(), s.ID)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	tr := newTree(t)
+	// small nodes, so that the directories are over several leaves
+	fs := readImage(t, mkfs(t, tr, []string{"-n", "4096"}))
+	many := make([]string, tr.manyFiles)
+	for i := range many {
+		many[i] = fmt.Sprintf("file_with_a_long_name_%04d", i)
+	}
+	tests := []struct {
+		path  string
+		names []string
+	}{
+		{"/", []string{"@home", "abslink", "big.bin", "dir", "dirlink", "empty", "hello.txt", "inline.txt", "link", "loop1", "loop2", "many", "nested", "private.txt", "sparse.bin", "text.txt"}},
+		{"/dir", []string{"hard.bin", "sub"}},
+		{"/dir/sub/", []string{"nested.txt"}},
+		{"/many", many},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			fi, err := fs.ReadDir(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			names := make([]string, 0, len(fi))
+			for _, f := range fi {
+				names = append(names, f.Name())
+				if !f.Mode().IsRegular() {
+					continue
+				}
+				p := path.Join(tt.path, f.Name())
+				if b := readFile(t, fs, p); int64(len(b)) != f.Size() {
+					t.Errorf("size of %s is %d, but %d bytes were read", p, f.Size(), len(b))
+				}
+			}
+			// the entries are in the order mkfs.btrfs found the files in
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.names, ",") {
+				t.Errorf("names %v instead of %v", names, tt.names)
+			}
+		})
+	}
+	if _, err := fs.ReadDir("/hello.txt"); err == nil {
+		t.Errorf("no error reading a file as a directory")
+	}
+	if _, err := fs.ReadDir("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v instead of one wrapping os.ErrNotExist", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), nil))
+	for _, p := range []string{"/dir", "/missing", "/hello.txt/x"} {
+		if _, err := fs.OpenFile(p, os.O_RDONLY); err == nil {
+			t.Errorf("%s: no error opening something that is not a file", p)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name string
+		args []string
+		path string
+	}{
+		{"sparse", nil, "/sparse.bin"},
+		{"big", nil, "/big.bin"},
+		{"zstd", []string{"--compress", "zstd"}, "/text.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.args != nil {
+				requireOption(t, "--compress")
+			}
+			fs := readImage(t, mkfs(t, tr, tt.args))
+			content := tr.files()[strings.TrimPrefix(tt.path, "/")]
+			f, err := fs.OpenFile(tt.path, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer f.Close()
+			size := int64(len(content))
+			seeks := []struct {
+				offset int64
+				whence int
+				pos    int64
+			}{
+				{size/2 + 900, io.SeekStart, size/2 + 900},
+				{-200, io.SeekEnd, size - 200},
+				// after reading 150 bytes
+				{-3 * 4096, io.SeekCurrent, size - 50 - 3*4096},
+				{200 * 1024, io.SeekStart, 200 * 1024},
+			}
+			for _, s := range seeks {
+				pos, err := f.Seek(s.offset, s.whence)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if pos != s.pos {
+					t.Errorf("position %d instead of %d", pos, s.pos)
+				}
+				b := make([]byte, 150)
+				if _, err := io.ReadFull(f, b); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(b, content[pos:pos+150]) {
+					t.Errorf("mismatched data at %d", pos)
+				}
+			}
+			if _, err := f.Seek(-1, io.SeekStart); err == nil {
+				t.Errorf("no error seeking before the start")
+			}
+		})
+	}
+}
+
+func TestStat(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	fi, err := fs.Stat("/link")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Name() != "hello.txt" || fi.Size() != 12 || fi.Mode() != 0o644 {
+		t.Errorf("stat of link was %s of %d bytes with mode %v", fi.Name(), fi.Size(), fi.Mode())
+	}
+	fi, err = fs.Lstat("/link")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode() != os.ModeSymlink|0o777 {
+		t.Errorf("mode %v instead of symlink", fi.Mode())
+	}
+	target, err := fs.Readlink("/abslink")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if target != "/dir/sub/nested.txt" {
+		t.Errorf("target %q instead of %q", target, "/dir/sub/nested.txt")
+	}
+	if _, err := fs.Readlink("/hello.txt"); err == nil {
+		t.Errorf("no error reading a file as a link")
+	}
+	if _, err := fs.Stat("/loop1"); err == nil {
+		t.Errorf("no error for a symlink loop")
+	}
+
+	fi, err = fs.Stat("/private.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode() != 0o600 {
+		t.Errorf("mode %v instead of %v", fi.Mode(), os.FileMode(0o600))
+	}
+	if !fi.ModTime().Equal(modTime) {
+		t.Errorf("modification time %v instead of %v", fi.ModTime(), modTime)
+	}
+	sys := fi.Sys().(btrfs.FileStat)
+	if sys.UID() != uint32(os.Getuid()) || sys.GID() != uint32(os.Getgid()) || sys.Nlink() != 1 || sys.Subvolume() != 5 {
+		t.Errorf("mismatched stat %+v", sys)
+	}
+
+	fi, err = fs.Stat("/dir/hard.bin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sys := fi.Sys().(btrfs.FileStat); sys.Nlink() != 2 || fi.Size() != int64(len(tr.big)) {
+		t.Errorf("hard link has %d links and %d bytes instead of 2 and %d", sys.Nlink(), fi.Size(), len(tr.big))
+	}
+	if _, err := fs.Stat("/hello.txt/x"); err == nil {
+		t.Errorf("no error for a path through a file")
+	}
+	if _, err := fs.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v instead of one wrapping os.ErrNotExist", err)
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	if !tr.xattrs {
+		t.Skip("unable to set xattrs on the files for the image")
+	}
+	names, err := fs.Listxattr("/hello.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"user.comment"}; strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("xattrs %v instead of %v", names, expected)
+	}
+	value, err := fs.Getxattr("/hello.txt", "user.comment")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(value) != "a comment" {
+		t.Errorf("value %q instead of %q", value, "a comment")
+	}
+	if _, err := fs.Getxattr("/hello.txt", "user.missing"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+		t.Errorf("error %v instead of one wrapping filesystem.ErrXattrNotExist", err)
+	}
+	if names, err := fs.Listxattr("/big.bin"); err != nil || len(names) != 0 {
+		t.Errorf("xattrs %v with error %v for a file without any", names, err)
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), nil))
+	f, err := fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	_, writeErr := f.Write([]byte("x"))
+	_, openErr := fs.OpenFile("/hello.txt", os.O_RDWR)
+	for _, err := range []error{
+		fs.Mkdir("/new"),
+		fs.Remove("/hello.txt"),
+		fs.RemoveAll("/dir"),
+		fs.Rename("/hello.txt", "/other.txt"),
+		fs.Truncate("/hello.txt", 10),
+		fs.SetLabel("label"),
+		fs.Symlink("/hello.txt", "/link2"),
+		fs.Setxattr("/hello.txt", "user.a", []byte("b")),
+		fs.Removexattr("/hello.txt", "user.comment"),
+		writeErr,
+		openErr,
+	} {
+		if !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("error %v instead of one wrapping filesystem.ErrReadonlyFilesystem", err)
+		}
+	}
+}
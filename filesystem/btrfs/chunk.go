@@ -0,0 +1,164 @@
+package btrfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	// chunkItemSize is the size of a chunk item before its stripes
+	chunkItemSize = 48
+	stripeSize    = 32
+)
+
+// block group flags of a chunk: what it holds and its RAID profile
+const (
+	blockGroupRAID0  uint64 = 0x8
+	blockGroupRAID10 uint64 = 0x40
+	blockGroupRAID5  uint64 = 0x80
+	blockGroupRAID6  uint64 = 0x100
+	// blockGroupStriped are the profiles that spread data over several devices, rather than keeping whole
+	// copies of it
+	blockGroupStriped = blockGroupRAID0 | blockGroupRAID10 | blockGroupRAID5 | blockGroupRAID6
+)
+
+// chunk maps a range of logical addresses, which is what all trees use, to where it is on the devices
+type chunk struct {
+	logical uint64
+	length  uint64
+	flags   uint64
+	stripes []stripe
+}
+
+// stripe is one place a chunk is, a whole copy of it unless the profile is striped
+type stripe struct {
+	devID  uint64
+	offset uint64
+}
+
+// parseChunk parses the chunk item from the start of b for the chunk at logical, and returns it and how many
+// bytes it took
+func parseChunk(b []byte, logical uint64) (*chunk, int, error) {
+	if len(b) < chunkItemSize {
+		return nil, 0, fmt.Errorf("chunk item was %d bytes instead of at least %d", len(b), chunkItemSize)
+	}
+	c := &chunk{
+		logical: logical,
+		length:  binary.LittleEndian.Uint64(b[0:8]),
+		flags:   binary.LittleEndian.Uint64(b[24:32]),
+	}
+	count := int(binary.LittleEndian.Uint16(b[44:46]))
+	size := chunkItemSize + count*stripeSize
+	if count == 0 || len(b) < size {
+		return nil, 0, fmt.Errorf("chunk item of %d bytes does not have room for %d stripes", len(b), count)
+	}
+	for i := 0; i < count; i++ {
+		s := b[chunkItemSize+i*stripeSize:]
+		c.stripes = append(c.stripes, stripe{
+			devID:  binary.LittleEndian.Uint64(s[0:8]),
+			offset: binary.LittleEndian.Uint64(s[8:16]),
+		})
+	}
+	return c, size, nil
+}
+
+// parseSysChunkArray parses the chunks in the superblock, which are where the chunk tree is
+func parseSysChunkArray(b []byte) ([]*chunk, error) {
+	var chunks []*chunk
+	for len(b) > 0 {
+		if len(b) < keySize {
+			return nil, fmt.Errorf("%d bytes left are too few for a key", len(b))
+		}
+		k := parseKey(b)
+		if k.itemType != itemChunk {
+			return nil, fmt.Errorf("item of type %d instead of a chunk", k.itemType)
+		}
+		c, n, err := parseChunk(b[keySize:], k.offset)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+		b = b[keySize+n:]
+	}
+	return chunks, nil
+}
+
+// addChunk adds a chunk to the map of logical addresses, unless it is already there
+func (fs *FileSystem) addChunk(c *chunk) {
+	i := sort.Search(len(fs.chunks), func(i int) bool {
+		return fs.chunks[i].logical >= c.logical
+	})
+	if i < len(fs.chunks) && fs.chunks[i].logical == c.logical {
+		return
+	}
+	fs.chunks = append(fs.chunks, nil)
+	copy(fs.chunks[i+1:], fs.chunks[i:])
+	fs.chunks[i] = c
+}
+
+// physical returns where logical address is on this device, from the start of the filesystem, and how many
+// bytes from there are in the same chunk
+func (fs *FileSystem) physical(logical uint64) (int64, uint64, error) {
+	i := sort.Search(len(fs.chunks), func(i int) bool {
+		return fs.chunks[i].logical+fs.chunks[i].length > logical
+	})
+	if i == len(fs.chunks) || fs.chunks[i].logical > logical {
+		return 0, 0, fmt.Errorf("logical address %d is not in any chunk", logical)
+	}
+	c := fs.chunks[i]
+	if c.flags&blockGroupStriped != 0 && len(c.stripes) > 1 {
+		return 0, 0, fmt.Errorf("chunk at %d has unsupported striped profile %#x", c.logical, c.flags&blockGroupStriped)
+	}
+	// every stripe is a whole copy, so any on this device will do
+	for _, s := range c.stripes {
+		if s.devID == fs.superblock.devID {
+			return int64(s.offset + logical - c.logical), c.logical + c.length - logical, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("chunk at %d is not on device %d", c.logical, fs.superblock.devID)
+}
+
+// readLogical reads len(b) bytes from logical address, which may cross chunks
+func (fs *FileSystem) readLogical(b []byte, logical uint64) error {
+	for len(b) > 0 {
+		offset, left, err := fs.physical(logical)
+		if err != nil {
+			return err
+		}
+		n := len(b)
+		if uint64(n) > left {
+			n = int(left)
+		}
+		if err := fs.readFull(b[:n], offset); err != nil {
+			return err
+		}
+		b = b[n:]
+		logical += uint64(n)
+	}
+	return nil
+}
+
+// loadChunks reads the chunk tree, starting from the chunks in the superblock which hold it
+func (fs *FileSystem) loadChunks() error {
+	chunks, err := parseSysChunkArray(fs.superblock.sysChunkArray)
+	if err != nil {
+		return fmt.Errorf("invalid system chunk array: %v", err)
+	}
+	for _, c := range chunks {
+		fs.addChunk(c)
+	}
+	chunkTree := tree{bytenr: fs.superblock.chunkRoot, level: fs.superblock.chunkRootLevel}
+	items, err := fs.items(chunkTree, objectIDFirstChunkTree, itemChunk)
+	if err != nil {
+		return fmt.Errorf("unable to read chunk tree: %v", err)
+	}
+	for _, it := range items {
+		c, _, err := parseChunk(it.data, it.key.offset)
+		if err != nil {
+			return fmt.Errorf("invalid chunk at %d: %v", it.key.offset, err)
+		}
+		fs.addChunk(c)
+	}
+	return nil
+}
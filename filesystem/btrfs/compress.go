@@ -0,0 +1,121 @@
+package btrfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/filesystem/internal/lzo"
+	"github.com/diskfs/go-diskfs/filesystem/internal/zstd"
+)
+
+// compression is how the data of a file extent is compressed
+type compression uint8
+
+const (
+	compressionNone compression = 0
+	compressionZlib compression = 1
+	compressionLZO  compression = 2
+	compressionZstd compression = 3
+)
+
+func (c compression) String() string {
+	switch c {
+	case compressionNone:
+		return "none"
+	case compressionZlib:
+		return "zlib"
+	case compressionLZO:
+		return "lzo"
+	case compressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// lzoHeaderSize is the size of the length of the data compressed with lzo, and of each segment of it
+const lzoHeaderSize = 4
+
+// decompress decompresses the data of an extent, which is size bytes when uncompressed. Compressed data that
+// ends early is the same as if it ended with zeros.
+func decompress(c compression, in []byte, size int, sectorSize uint32) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch c {
+	case compressionNone:
+		out = in
+	case compressionZlib:
+		out, err = zlibDecompress(in, size)
+	case compressionLZO:
+		out, err = lzoDecompress(in, size, int(sectorSize))
+	case compressionZstd:
+		out, err = zstd.Decompress(in, size)
+	default:
+		return nil, fmt.Errorf("unsupported compression %v", c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress %v data: %v", c, err)
+	}
+	switch {
+	case len(out) > size:
+		out = out[:size]
+	case len(out) < size:
+		out = append(out, make([]byte, size-len(out))...)
+	}
+	return out, nil
+}
+
+func zlibDecompress(in []byte, size int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, size)
+	n, err := io.ReadFull(r, out)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return out[:n], nil
+}
+
+// lzoDecompress decompresses data the way btrfs compresses it with lzo: the total length, and then segments
+// that are each a sector of data, compressed on its own and preceded by its length. The length of a segment
+// never crosses the end of a sector; if there is not room for it, the rest of the sector is padding.
+func lzoDecompress(in []byte, size, sectorSize int) ([]byte, error) {
+	if len(in) < lzoHeaderSize {
+		return nil, fmt.Errorf("lzo data of %d bytes is too short: %w", len(in), lzo.ErrCorrupt)
+	}
+	total := int(binary.LittleEndian.Uint32(in[0:lzoHeaderSize]))
+	if total > len(in) || total < lzoHeaderSize {
+		return nil, fmt.Errorf("lzo data has length %d with %d bytes: %w", total, len(in), lzo.ErrCorrupt)
+	}
+	out := make([]byte, 0, size)
+	pos := lzoHeaderSize
+	for pos < total && len(out) < size {
+		if left := sectorSize - pos%sectorSize; left < lzoHeaderSize {
+			pos += left
+			continue
+		}
+		if pos+lzoHeaderSize > total {
+			return nil, fmt.Errorf("lzo segment header at %d overflows the data: %w", pos, lzo.ErrCorrupt)
+		}
+		length := int(binary.LittleEndian.Uint32(in[pos : pos+lzoHeaderSize]))
+		pos += lzoHeaderSize
+		if pos+length > total {
+			return nil, fmt.Errorf("lzo segment of %d bytes at %d overflows the data: %w", length, pos, lzo.ErrCorrupt)
+		}
+		segment, err := lzo.Decompress(in[pos:pos+length], size-len(out))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress lzo segment at %d: %v", pos, err)
+		}
+		out = append(out, segment...)
+		pos += length
+	}
+	return out, nil
+}
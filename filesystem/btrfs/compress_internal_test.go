@@ -0,0 +1,84 @@
+package btrfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// lzoLiterals is an LZO1X stream of b, which is at most 238 bytes, as literals only
+func lzoLiterals(b []byte) []byte {
+	out := append([]byte{byte(17 + len(b))}, b...)
+	return append(out, 0x11, 0, 0)
+}
+
+// lzoCompress frames data the way btrfs compresses it with lzo, a sector at a time, each sector as literals
+func lzoCompress(in []byte, sectorSize int) []byte {
+	out := make([]byte, lzoHeaderSize)
+	for len(in) > 0 {
+		n := sectorSize
+		if n > len(in) {
+			n = len(in)
+		}
+		if left := sectorSize - len(out)%sectorSize; left < lzoHeaderSize {
+			out = append(out, make([]byte, left)...)
+		}
+		segment := lzoLiterals(in[:n])
+		out = append(out, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(out[len(out)-4:], uint32(len(segment)))
+		out = append(out, segment...)
+		in = in[n:]
+	}
+	binary.LittleEndian.PutUint32(out, uint32(len(out)))
+	return out
+}
+
+func TestLZODecompress(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+	tests := []struct {
+		name       string
+		data       []byte
+		sectorSize int
+	}{
+		{"empty", []byte{}, 128},
+		{"short", []byte("abc"), 128},
+		{"segments", random, 128},
+		// after 5 segments, the header of the next does not fit in its sector
+		{"padding", random[:300], 45},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := decompress(compressionLZO, lzoCompress(tt.data, tt.sectorSize), len(tt.data), uint32(tt.sectorSize))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(out, tt.data) {
+				t.Errorf("decompressed %d bytes did not match the original %d", len(out), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestLZODecompressInvalid(t *testing.T) {
+	segment := lzoLiterals([]byte("abcd"))
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"too short", []byte{1, 0}},
+		{"length past the data", []byte{0xff, 0, 0, 0, 0, 0, 0, 0}},
+		{"segment header past the length", []byte{6, 0, 0, 0, 0, 0}},
+		{"segment past the length", append([]byte{8 + byte(len(segment)) - 1, 0, 0, 0, byte(len(segment)), 0, 0, 0}, segment...)},
+		{"segment without an end", []byte{13, 0, 0, 0, 5, 0, 0, 0, 21, 'a', 'b', 'c', 'd'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := lzoDecompress(tt.in, 4, 128); err == nil {
+				t.Errorf("did not return an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package btrfs
+
+import (
+	"os"
+	"time"
+)
+
+// FileStat is the extended data underlying a single file, similar to https://golang.org/pkg/syscall/#Stat_t
+type FileStat struct {
+	subvolume  uint64
+	inode      uint64
+	links      uint32
+	uid        uint32
+	gid        uint32
+	rdev       uint64
+	accessTime time.Time
+	changeTime time.Time
+	createTime time.Time
+}
+
+// Subvolume get the ID of the subvolume file is in
+func (f *FileStat) Subvolume() uint64 {
+	return f.subvolume
+}
+
+// Inode get the inode number of file, which is unique within its subvolume
+func (f *FileStat) Inode() uint64 {
+	return f.inode
+}
+
+// Nlink get the number of hard links to file
+func (f *FileStat) Nlink() uint32 {
+	return f.links
+}
+
+// UID get uid of file
+func (f *FileStat) UID() uint32 {
+	return f.uid
+}
+
+// GID get gid of file
+func (f *FileStat) GID() uint32 {
+	return f.gid
+}
+
+// Rdev get the major and minor device numbers of a block or character device
+func (f *FileStat) Rdev() (major, minor uint32) {
+	return decodeDev(f.rdev)
+}
+
+// AccessTime get the time file was last read
+func (f *FileStat) AccessTime() time.Time {
+	return f.accessTime
+}
+
+// ChangeTime get the time the inode of file last changed
+func (f *FileStat) ChangeTime() time.Time {
+	return f.changeTime
+}
+
+// CreationTime get the time file was created
+func (f *FileStat) CreationTime() time.Time {
+	return f.createTime
+}
+
+// directoryEntry is a single directory entry
+// it combines information from inode and the actual entry
+// also fulfills os.FileInfo
+//
+//	Name() string       // base name of the file
+//	Size() int64        // length in bytes for regular files; system-dependent for others
+//	Mode() FileMode     // file mode bits
+//	ModTime() time.Time // modification time
+//	IsDir() bool        // abbreviation for Mode().IsDir()
+//	Sys() interface{}   // underlying data source (can return nil)
+type directoryEntry struct {
+	name  string
+	inode *inode
+	sys   FileStat
+}
+
+// newDirectoryEntry creates the directory entry for an inode found by name
+func newDirectoryEntry(name string, in *inode) *directoryEntry {
+	return &directoryEntry{
+		name:  name,
+		inode: in,
+		sys: FileStat{
+			subvolume:  in.subvolume,
+			inode:      in.number,
+			links:      in.nlink,
+			uid:        in.uid,
+			gid:        in.gid,
+			rdev:       in.rdev,
+			accessTime: in.accessTime,
+			changeTime: in.changeTime,
+			createTime: in.createTime,
+		},
+	}
+}
+
+// Name string       // base name of the file
+func (d *directoryEntry) Name() string {
+	return d.name
+}
+
+// Size int64        // length in bytes for regular files; system-dependent for others
+func (d *directoryEntry) Size() int64 {
+	return int64(d.inode.size)
+}
+
+// IsDir bool        // abbreviation for Mode().IsDir()
+func (d *directoryEntry) IsDir() bool {
+	return d.inode.isDir()
+}
+
+// ModTime time.Time // modification time
+func (d *directoryEntry) ModTime() time.Time {
+	return d.inode.modTime
+}
+
+// Mode FileMode     // file mode bits
+func (d *directoryEntry) Mode() os.FileMode {
+	return fileMode(d.inode.mode)
+}
+
+// Sys interface{}   // underlying data source (can return nil)
+func (d *directoryEntry) Sys() interface{} {
+	return d.sys
+}
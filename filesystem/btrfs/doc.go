@@ -0,0 +1,14 @@
+// Package btrfs provides read-only access to a btrfs filesystem on a block device or a disk image, such as a
+// root partition with subvolumes.
+//
+// It reads the files and directories of one subvolume, the default one or one chosen by its path with
+// ReadSubvolume, and of the subvolumes and snapshots inside it, which appear as directories. File data may be
+// inline, in extents or sparse, and compressed with zlib, lzo or zstd. Symlinks and extended attributes are
+// supported. Only one device is read, so chunks that are only on other devices, or striped across them, cannot
+// be read, and nothing can be written.
+//
+// references:
+//
+//	https://btrfs.readthedocs.io/en/latest/dev/On-disk-format.html
+//	https://github.com/torvalds/linux/tree/master/fs/btrfs
+package btrfs
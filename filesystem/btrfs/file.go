@@ -0,0 +1,180 @@
+package btrfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in a btrfs filesystem
+type File struct {
+	inode      *inode
+	extents    []*fileExtent
+	offset     int64
+	filesystem *FileSystem
+	// extent is the last compressed extent read, with its decompressed data
+	extent      *fileExtent
+	extentBytes []byte
+}
+
+// newFile creates a File to read the data of an inode, from its extents
+func (fs *FileSystem) newFile(in *inode) (*File, error) {
+	r, err := fs.readRoot(in.subvolume)
+	if err != nil {
+		return nil, err
+	}
+	items, err := fs.items(r.tree, in.number, itemExtentData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read extents of inode %d: %v", in.number, err)
+	}
+	extents := make([]*fileExtent, 0, len(items))
+	for _, it := range items {
+		e, err := parseFileExtent(it.data, it.key.offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extent at %d of inode %d: %v", it.key.offset, in.number, err)
+		}
+		extents = append(extents, e)
+	}
+	return &File{
+		inode:      in,
+		extents:    extents,
+		filesystem: fs,
+	}, nil
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	size := int64(fl.inode.size)
+	if fl.offset >= size {
+		return 0, io.EOF
+	}
+	if remaining := size - fl.offset; remaining < int64(len(b)) {
+		b = b[:remaining]
+	}
+	read := 0
+	for read < len(b) {
+		n, err := fl.readAt(b[read:], uint64(fl.offset)+uint64(read))
+		if err != nil {
+			return read, err
+		}
+		read += n
+	}
+	fl.offset += int64(read)
+	var retErr error
+	if fl.offset >= size {
+		retErr = io.EOF
+	}
+	return read, retErr
+}
+
+// readAt reads from off in the file into b, as far as one extent, or the hole before the next one, goes, and
+// returns how much it read
+func (fl *File) readAt(b []byte, off uint64) (int, error) {
+	// the last extent that starts at or before off
+	i := sort.Search(len(fl.extents), func(i int) bool {
+		return fl.extents[i].offset > off
+	}) - 1
+	if i < 0 || off >= fl.extents[i].offset+fl.extents[i].numBytes {
+		// a hole, up to the next extent, which is not stored with NO_HOLES
+		end := uint64(len(b))
+		if i+1 < len(fl.extents) && fl.extents[i+1].offset-off < end {
+			end = fl.extents[i+1].offset - off
+		}
+		for j := uint64(0); j < end; j++ {
+			b[j] = 0
+		}
+		return int(end), nil
+	}
+	e := fl.extents[i]
+	rel := off - e.offset
+	if left := e.numBytes - rel; left < uint64(len(b)) {
+		b = b[:left]
+	}
+	fs := fl.filesystem
+	switch {
+	case e.isHole():
+		for j := range b {
+			b[j] = 0
+		}
+	case e.compression == compressionNone && e.extentType == extentRegular:
+		if err := fs.readLogical(b, e.diskBytenr+e.dataOffset+rel); err != nil {
+			return 0, fmt.Errorf("unable to read extent at %d of inode %d: %v", e.offset, fl.inode.number, err)
+		}
+	default:
+		data, err := fl.extentData(e)
+		if err != nil {
+			return 0, err
+		}
+		start := e.dataOffset + rel
+		if start >= uint64(len(data)) {
+			return 0, fmt.Errorf("extent at %d of inode %d has %d bytes, too few for %d", e.offset, fl.inode.number, len(data), start)
+		}
+		copy(b, data[start:])
+	}
+	return len(b), nil
+}
+
+// extentData returns all of the data of an inline or compressed extent, decompressed
+func (fl *File) extentData(e *fileExtent) ([]byte, error) {
+	if fl.extent == e {
+		return fl.extentBytes, nil
+	}
+	fs := fl.filesystem
+	raw := e.inline
+	if e.extentType == extentRegular {
+		raw = make([]byte, e.diskNumBytes)
+		if err := fs.readLogical(raw, e.diskBytenr); err != nil {
+			return nil, fmt.Errorf("unable to read extent at %d of inode %d: %v", e.offset, fl.inode.number, err)
+		}
+	}
+	data, err := decompress(e.compression, raw, int(e.ramBytes), fs.superblock.sectorSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read extent at %d of inode %d: %v", e.offset, fl.inode.number, err)
+	}
+	fl.extent, fl.extentBytes = e, data
+	return data, nil
+}
+
+// Write writes len(b) bytes to the File.
+//
+//	you cannot write to a btrfs filesystem, so this returns an error
+func (fl *File) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("cannot write to a read-only btrfs filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = int64(fl.inode.size) + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
@@ -0,0 +1,184 @@
+package btrfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	inodeItemSize = 160
+	// dirItemHeaderSize is the size of a directory item, or an extended attribute, before its name and data
+	dirItemHeaderSize = 30
+	// inodeRefHeaderSize is the size of an inode ref before its name
+	inodeRefHeaderSize = 10
+	// rootRefHeaderSize is the size of a root ref or backref before its name
+	rootRefHeaderSize = 18
+)
+
+// inode is an inode of a file in a subvolume, which is identified by both
+type inode struct {
+	subvolume  uint64
+	number     uint64
+	size       uint64
+	nlink      uint32
+	uid        uint32
+	gid        uint32
+	mode       uint32
+	rdev       uint64
+	accessTime time.Time
+	changeTime time.Time
+	modTime    time.Time
+	createTime time.Time
+}
+
+func parseInode(b []byte, subvolume, number uint64) (*inode, error) {
+	if len(b) < inodeItemSize {
+		return nil, fmt.Errorf("inode item was %d bytes instead of %d", len(b), inodeItemSize)
+	}
+	ts := func(offset int) time.Time {
+		return timespec(binary.LittleEndian.Uint64(b[offset:offset+8]), binary.LittleEndian.Uint32(b[offset+8:offset+12]))
+	}
+	return &inode{
+		subvolume:  subvolume,
+		number:     number,
+		size:       binary.LittleEndian.Uint64(b[16:24]),
+		nlink:      binary.LittleEndian.Uint32(b[40:44]),
+		uid:        binary.LittleEndian.Uint32(b[44:48]),
+		gid:        binary.LittleEndian.Uint32(b[48:52]),
+		mode:       binary.LittleEndian.Uint32(b[52:56]),
+		rdev:       binary.LittleEndian.Uint64(b[56:64]),
+		accessTime: ts(112),
+		changeTime: ts(124),
+		modTime:    ts(136),
+		createTime: ts(148),
+	}, nil
+}
+
+func (in *inode) isDir() bool {
+	return in.mode&modeTypeMask == modeDirectory
+}
+
+func (in *inode) isSymlink() bool {
+	return in.mode&modeTypeMask == modeSymlink
+}
+
+// dirItem is an entry in a directory, or an extended attribute, which have the same layout
+type dirItem struct {
+	location key
+	name     string
+	data     []byte
+}
+
+// parseDirItems parses the directory items in the data of an item, of which there is more than one when
+// their names have the same hash
+func parseDirItems(b []byte) ([]dirItem, error) {
+	var items []dirItem
+	for len(b) > 0 {
+		if len(b) < dirItemHeaderSize {
+			return nil, fmt.Errorf("directory item was %d bytes instead of at least %d", len(b), dirItemHeaderSize)
+		}
+		dataLen := int(binary.LittleEndian.Uint16(b[25:27]))
+		nameLen := int(binary.LittleEndian.Uint16(b[27:29]))
+		size := dirItemHeaderSize + nameLen + dataLen
+		if len(b) < size {
+			return nil, fmt.Errorf("directory item was %d bytes, too few for its name of %d and data of %d", len(b), nameLen, dataLen)
+		}
+		items = append(items, dirItem{
+			location: parseKey(b[0:keySize]),
+			name:     string(b[dirItemHeaderSize : dirItemHeaderSize+nameLen]),
+			data:     b[dirItemHeaderSize+nameLen : size],
+		})
+		b = b[size:]
+	}
+	return items, nil
+}
+
+// findDirItem finds the directory item or extended attribute with name, from those whose key is its hash
+func (fs *FileSystem) findDirItem(t tree, objectID uint64, itemType uint8, name string) (*dirItem, error) {
+	it, err := fs.find(t, key{objectID, itemType, nameHash(name)})
+	if err != nil || it == nil {
+		return nil, err
+	}
+	items, err := parseDirItems(it.data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if items[i].name == name {
+			return &items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// file extent types
+const (
+	extentInline   uint8 = 0
+	extentRegular  uint8 = 1
+	extentPrealloc uint8 = 2
+)
+
+const (
+	// fileExtentInlineSize is the size of a file extent item before its inline data
+	fileExtentInlineSize = 21
+	fileExtentSize       = 53
+)
+
+// fileExtent is a range of the data of a file
+type fileExtent struct {
+	// offset is where the extent is in the file
+	offset      uint64
+	extentType  uint8
+	compression compression
+	// ramBytes is the size of all of the data of the extent once it is decompressed, of which the file
+	// may use only part
+	ramBytes uint64
+	inline   []byte
+	// diskBytenr is the logical address of the data, or 0 for a hole
+	diskBytenr   uint64
+	diskNumBytes uint64
+	// dataOffset is where in the decompressed data the part the file uses starts
+	dataOffset uint64
+	numBytes   uint64
+}
+
+func parseFileExtent(b []byte, offset uint64) (*fileExtent, error) {
+	if len(b) < fileExtentInlineSize {
+		return nil, fmt.Errorf("file extent item was %d bytes instead of at least %d", len(b), fileExtentInlineSize)
+	}
+	e := &fileExtent{
+		offset:      offset,
+		ramBytes:    binary.LittleEndian.Uint64(b[8:16]),
+		compression: compression(b[16]),
+		extentType:  b[20],
+	}
+	if b[17] != 0 {
+		return nil, fmt.Errorf("unsupported encryption %d", b[17])
+	}
+	if b[18] != 0 || b[19] != 0 {
+		return nil, fmt.Errorf("unsupported other encoding %d", binary.LittleEndian.Uint16(b[18:20]))
+	}
+	switch e.extentType {
+	case extentInline:
+		e.inline = b[fileExtentInlineSize:]
+		e.numBytes = e.ramBytes
+	case extentRegular, extentPrealloc:
+		if len(b) < fileExtentSize {
+			return nil, fmt.Errorf("file extent item was %d bytes instead of %d", len(b), fileExtentSize)
+		}
+		e.diskBytenr = binary.LittleEndian.Uint64(b[21:29])
+		e.diskNumBytes = binary.LittleEndian.Uint64(b[29:37])
+		e.dataOffset = binary.LittleEndian.Uint64(b[37:45])
+		e.numBytes = binary.LittleEndian.Uint64(b[45:53])
+	default:
+		return nil, fmt.Errorf("unknown extent type %d", e.extentType)
+	}
+	return e, nil
+}
+
+// isHole says whether the extent reads as zeros: a hole punched in the file, or space that is allocated
+// but not yet written
+func (e *fileExtent) isHole() bool {
+	return e.extentType == extentPrealloc || (e.extentType == extentRegular && e.diskBytenr == 0)
+}
@@ -0,0 +1,203 @@
+package btrfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+)
+
+const (
+	// rootItemSize is how much of a root item this package reads, up to its level
+	rootItemSize = 239
+	// maxDirDepth is how deep a directory can be in a subvolume, to stop a loop of inode refs
+	maxDirDepth = 4096
+)
+
+// Subvolume is a subvolume of a btrfs filesystem, as returned by FileSystem.Subvolumes()
+type Subvolume struct {
+	// ID the ID of the subvolume, which is 5 for the top level one
+	ID uint64
+	// ParentID the ID of the subvolume it is in, or 0 for the top level one
+	ParentID uint64
+	// Path the path of the subvolume from the top level one, such as "/@home", which is what to give
+	// ReadSubvolume to read it
+	Path string
+}
+
+// root is where a subvolume, or another tree in the root tree, starts
+type root struct {
+	tree  tree
+	dirID uint64
+}
+
+// readRoot reads the root item of the subvolume or tree with id. A snapshot may have more than one, with the
+// latest last.
+func (fs *FileSystem) readRoot(id uint64) (*root, error) {
+	if r, ok := fs.roots[id]; ok {
+		return r, nil
+	}
+	items, err := fs.items(fs.rootTree, id, itemRoot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read root of tree %d: %v", id, err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("tree %d does not exist", id)
+	}
+	b := items[len(items)-1].data
+	if len(b) < rootItemSize {
+		return nil, fmt.Errorf("root item of tree %d was %d bytes instead of at least %d", id, len(b), rootItemSize)
+	}
+	r := &root{
+		tree: tree{
+			bytenr: binary.LittleEndian.Uint64(b[176:184]),
+			level:  b[238],
+		},
+		dirID: binary.LittleEndian.Uint64(b[168:176]),
+	}
+	fs.roots[id] = r
+	return r, nil
+}
+
+// defaultSubvolume returns the ID of the subvolume to read when none is given, which is set with
+// `btrfs subvolume set-default`, or else the top level one
+func (fs *FileSystem) defaultSubvolume() (uint64, error) {
+	d, err := fs.findDirItem(fs.rootTree, objectIDRootTreeDir, itemDirItem, "default")
+	if err != nil {
+		return 0, fmt.Errorf("unable to find default subvolume: %v", err)
+	}
+	if d == nil {
+		return objectIDFSTree, nil
+	}
+	return d.location.objectID, nil
+}
+
+// subvolumeByPath returns the ID of the subvolume at p from the top level one
+func (fs *FileSystem) subvolumeByPath(p string) (uint64, error) {
+	in, err := fs.lookup(objectIDFSTree, p)
+	if err != nil {
+		return 0, err
+	}
+	r, err := fs.readRoot(in.subvolume)
+	if err != nil {
+		return 0, err
+	}
+	if in.number != r.dirID {
+		return 0, fmt.Errorf("%s is not a subvolume", p)
+	}
+	return in.subvolume, nil
+}
+
+// Subvolumes returns all of the subvolumes of the filesystem, including snapshots, in order of their IDs. The
+// first is always the top level one, whose path is "/".
+func (fs *FileSystem) Subvolumes() ([]Subvolume, error) {
+	type backref struct {
+		parent, dirID uint64
+		name          string
+	}
+	backrefs := map[uint64]backref{}
+	min, max := key{objectIDFirstFree, itemRootBackref, 0}, key{objectIDLastFree, itemRootBackref, math.MaxUint64}
+	err := fs.walk(fs.rootTree, min, max, func(it item) (bool, error) {
+		if it.key.itemType != itemRootBackref {
+			return true, nil
+		}
+		dirID, name, err := parseRootRef(it.data)
+		if err != nil {
+			return false, fmt.Errorf("invalid backref of subvolume %d: %v", it.key.objectID, err)
+		}
+		backrefs[it.key.objectID] = backref{parent: it.key.offset, dirID: dirID, name: name}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read subvolumes: %v", err)
+	}
+
+	paths := map[uint64]string{objectIDFSTree: "/"}
+	var subvolumePath func(id uint64, depth int) (string, error)
+	subvolumePath = func(id uint64, depth int) (string, error) {
+		if p, ok := paths[id]; ok {
+			return p, nil
+		}
+		ref, ok := backrefs[id]
+		if !ok || depth > len(backrefs) {
+			return "", fmt.Errorf("subvolume %d is not in any other subvolume", id)
+		}
+		parent, err := subvolumePath(ref.parent, depth+1)
+		if err != nil {
+			return "", err
+		}
+		dir, err := fs.dirPath(ref.parent, ref.dirID)
+		if err != nil {
+			return "", fmt.Errorf("unable to find directory of subvolume %d: %v", id, err)
+		}
+		p := path.Join(parent, dir, ref.name)
+		paths[id] = p
+		return p, nil
+	}
+	subvolumes := []Subvolume{{ID: objectIDFSTree, Path: "/"}}
+	for id, ref := range backrefs {
+		p, err := subvolumePath(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		subvolumes = append(subvolumes, Subvolume{ID: id, ParentID: ref.parent, Path: p})
+	}
+	sort.Slice(subvolumes, func(i, j int) bool {
+		return subvolumes[i].ID < subvolumes[j].ID
+	})
+	return subvolumes, nil
+}
+
+// dirPath returns the path of directory dirID in a subvolume, from the refs to each directory from the one it
+// is in
+func (fs *FileSystem) dirPath(subvolume, dirID uint64) (string, error) {
+	r, err := fs.readRoot(subvolume)
+	if err != nil {
+		return "", err
+	}
+	p := "/"
+	for depth := 0; dirID != r.dirID; depth++ {
+		if depth > maxDirDepth {
+			return "", fmt.Errorf("directory %d is more than %d deep", dirID, maxDirDepth)
+		}
+		refs, err := fs.items(r.tree, dirID, itemInodeRef)
+		if err != nil {
+			return "", err
+		}
+		if len(refs) == 0 {
+			return "", fmt.Errorf("directory %d is not in any directory", dirID)
+		}
+		name, err := parseInodeRef(refs[0].data)
+		if err != nil {
+			return "", fmt.Errorf("invalid ref of directory %d: %v", dirID, err)
+		}
+		p = path.Join("/", name, p)
+		dirID = refs[0].key.offset
+	}
+	return p, nil
+}
+
+// parseRootRef parses a root ref or backref, which is the directory and name of a subvolume in the one it is in
+func parseRootRef(b []byte) (uint64, string, error) {
+	if len(b) < rootRefHeaderSize {
+		return 0, "", fmt.Errorf("root ref was %d bytes instead of at least %d", len(b), rootRefHeaderSize)
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b[16:18]))
+	if len(b) < rootRefHeaderSize+nameLen {
+		return 0, "", fmt.Errorf("root ref was %d bytes, too few for its name of %d", len(b), nameLen)
+	}
+	return binary.LittleEndian.Uint64(b[0:8]), string(b[rootRefHeaderSize : rootRefHeaderSize+nameLen]), nil
+}
+
+// parseInodeRef parses the name of the first ref to an inode from a directory
+func parseInodeRef(b []byte) (string, error) {
+	if len(b) < inodeRefHeaderSize {
+		return "", fmt.Errorf("inode ref was %d bytes instead of at least %d", len(b), inodeRefHeaderSize)
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b[8:10]))
+	if len(b) < inodeRefHeaderSize+nameLen {
+		return "", fmt.Errorf("inode ref was %d bytes, too few for its name of %d", len(b), nameLen)
+	}
+	return string(b[inodeRefHeaderSize : inodeRefHeaderSize+nameLen]), nil
+}
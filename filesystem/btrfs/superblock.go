@@ -0,0 +1,151 @@
+package btrfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/filesystem/internal/xxhash"
+	"github.com/google/uuid"
+)
+
+const (
+	superblockMagic = "_BHRfS_M"
+	// superblockOffset is where the primary superblock is; there are copies at 64 MB and 256 GB, which are
+	// only needed when it is damaged
+	superblockOffset int64 = 0x10000
+	superblockSize   int64 = 4096
+	// checksumSize is the room for a checksum at the start of the superblock and of each node
+	checksumSize = 32
+	labelSize    = 256
+	// sysChunkArrayMaxSize is the room in the superblock for the chunks that hold the chunk tree
+	sysChunkArrayMaxSize = 2048
+	minSectorSize        = 512
+	maxNodeSize          = 64 * 1024
+)
+
+// checksum types
+const (
+	checksumCRC32C   uint16 = 0
+	checksumXXHash64 uint16 = 1
+	checksumSHA256   uint16 = 2
+	checksumBLAKE2b  uint16 = 3
+)
+
+// incompatible features, which a reader must know to read the filesystem
+const (
+	featureIncompatMixedBackref   uint64 = 0x1
+	featureIncompatDefaultSubvol  uint64 = 0x2
+	featureIncompatMixedGroups    uint64 = 0x4
+	featureIncompatCompressLZO    uint64 = 0x8
+	featureIncompatCompressZstd   uint64 = 0x10
+	featureIncompatBigMetadata    uint64 = 0x20
+	featureIncompatExtendedIref   uint64 = 0x40
+	featureIncompatRAID56         uint64 = 0x80
+	featureIncompatSkinnyMetadata uint64 = 0x100
+	featureIncompatNoHoles        uint64 = 0x200
+	featureIncompatMetadataUUID   uint64 = 0x400
+	featureIncompatRAID1C34       uint64 = 0x800
+	featureIncompatZoned          uint64 = 0x1000
+	featureIncompatSimpleQuota    uint64 = 0x10000
+)
+
+// featureIncompatSupported are the incompatible features this package can read. Most only matter to writing,
+// or to trees it does not read. Chunks with a RAID profile that stripes data are not supported even with
+// featureIncompatRAID56.
+const featureIncompatSupported = featureIncompatMixedBackref | featureIncompatDefaultSubvol |
+	featureIncompatMixedGroups | featureIncompatCompressLZO | featureIncompatCompressZstd |
+	featureIncompatBigMetadata | featureIncompatExtendedIref | featureIncompatRAID56 |
+	featureIncompatSkinnyMetadata | featureIncompatNoHoles | featureIncompatMetadataUUID |
+	featureIncompatRAID1C34 | featureIncompatZoned | featureIncompatSimpleQuota
+
+type superblock struct {
+	checksumType   uint16
+	fsid           uuid.UUID
+	metadataUUID   uuid.UUID
+	root           uint64
+	chunkRoot      uint64
+	totalBytes     uint64
+	sectorSize     uint32
+	nodeSize       uint32
+	incompat       uint64
+	rootLevel      uint8
+	chunkRootLevel uint8
+	devID          uint64
+	label          string
+	sysChunkArray  []byte
+}
+
+// parseSuperblock parses the superblock from b, which must be all superblockSize bytes of it
+func parseSuperblock(b []byte) (*superblock, error) {
+	if int64(len(b)) < superblockSize {
+		return nil, fmt.Errorf("superblock was %d bytes instead of %d", len(b), superblockSize)
+	}
+	if magic := string(b[0x40:0x48]); magic != superblockMagic {
+		return nil, fmt.Errorf("superblock had magic of %q instead of expected %q", magic, superblockMagic)
+	}
+	s := &superblock{
+		root:           binary.LittleEndian.Uint64(b[0x50:0x58]),
+		chunkRoot:      binary.LittleEndian.Uint64(b[0x58:0x60]),
+		totalBytes:     binary.LittleEndian.Uint64(b[0x70:0x78]),
+		sectorSize:     binary.LittleEndian.Uint32(b[0x90:0x94]),
+		nodeSize:       binary.LittleEndian.Uint32(b[0x94:0x98]),
+		incompat:       binary.LittleEndian.Uint64(b[0xbc:0xc4]),
+		checksumType:   binary.LittleEndian.Uint16(b[0xc4:0xc6]),
+		rootLevel:      b[0xc6],
+		chunkRootLevel: b[0xc7],
+		devID:          binary.LittleEndian.Uint64(b[0xc9:0xd1]),
+		label:          strings.TrimRight(string(b[0x12b:0x12b+labelSize]), "\x00"),
+	}
+	copy(s.fsid[:], b[0x20:0x30])
+	s.metadataUUID = s.fsid
+	if s.incompat&featureIncompatMetadataUUID != 0 {
+		copy(s.metadataUUID[:], b[0x23b:0x24b])
+	}
+	if err := verifyChecksum(s.checksumType, b[:superblockSize]); err != nil {
+		return nil, fmt.Errorf("superblock: %v", err)
+	}
+	if unsupported := s.incompat &^ featureIncompatSupported; unsupported != 0 {
+		return nil, fmt.Errorf("unsupported incompatible features %#x", unsupported)
+	}
+	if s.sectorSize < minSectorSize || bits.OnesCount32(s.sectorSize) != 1 {
+		return nil, fmt.Errorf("sector size %d is not a power of 2 of at least %d", s.sectorSize, minSectorSize)
+	}
+	if s.nodeSize < s.sectorSize || s.nodeSize > maxNodeSize || bits.OnesCount32(s.nodeSize) != 1 {
+		return nil, fmt.Errorf("node size %d is not a power of 2 from the sector size %d to %d", s.nodeSize, s.sectorSize, maxNodeSize)
+	}
+	arraySize := binary.LittleEndian.Uint32(b[0xa0:0xa4])
+	if arraySize > sysChunkArrayMaxSize {
+		return nil, fmt.Errorf("system chunk array of %d bytes is more than the maximum %d", arraySize, sysChunkArrayMaxSize)
+	}
+	s.sysChunkArray = b[0x32b : 0x32b+arraySize]
+	return s, nil
+}
+
+// verifyChecksum checks the checksum at the start of b, of the superblock or a node, which covers all of the
+// rest of it
+func verifyChecksum(checksumType uint16, b []byte) error {
+	var sum []byte
+	switch checksumType {
+	case checksumCRC32C:
+		sum = make([]byte, 4)
+		binary.LittleEndian.PutUint32(sum, crc32.Checksum(b[checksumSize:], crc32cTable))
+	case checksumXXHash64:
+		sum = make([]byte, 8)
+		binary.LittleEndian.PutUint64(sum, xxhash.Sum64(b[checksumSize:]))
+	case checksumSHA256:
+		s := sha256.Sum256(b[checksumSize:])
+		sum = s[:]
+	case checksumBLAKE2b:
+		return fmt.Errorf("unsupported checksum type blake2b")
+	default:
+		return fmt.Errorf("unknown checksum type %d", checksumType)
+	}
+	if expected := b[:len(sum)]; string(expected) != string(sum) {
+		return fmt.Errorf("checksum %x does not match expected %x", sum, expected)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+#!/bin/sh
+# mkbtrfs.sh image label src [mkfs.btrfs options]
+#
+# Makes a btrfs filesystem in image, which must already have its size, from the tree in src, with mkfs.btrfs --rootdir.
+# It first gives hello.txt in src a user.comment xattr, and says so, if the filesystem src is on allows it.
+set -e
+img=$1
+label=$2
+src=$3
+shift 3
+
+if setfattr -n user.comment -v "a comment" "$src"/hello.txt 2>/dev/null; then
+	echo "xattrs set"
+fi
+mkfs.btrfs -q -f -L "$label" --rootdir "$src" "$@" "$img"
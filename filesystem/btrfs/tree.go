@@ -0,0 +1,238 @@
+package btrfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	nodeHeaderSize = 0x65
+	keySize        = 17
+	// keyPtrSize is the size of a pointer from an internal node to a node below it: its first key, where it
+	// is and its generation
+	keyPtrSize = keySize + 16
+	// itemSize is the size of an item header in a leaf: its key, and the offset and size of its data
+	itemSize = keySize + 8
+	// maxLevel is how deep a tree can be, counting the leaves as level 0
+	maxLevel = 7
+	// nodeCacheSize is how many nodes to keep once they are read
+	nodeCacheSize = 256
+)
+
+// item types, which are part of the key of each item and say what it holds
+const (
+	itemInode       uint8 = 1
+	itemInodeRef    uint8 = 12
+	itemXattr       uint8 = 24
+	itemDirItem     uint8 = 84
+	itemDirIndex    uint8 = 96
+	itemExtentData  uint8 = 108
+	itemRoot        uint8 = 132
+	itemRootBackref uint8 = 144
+	itemRootRef     uint8 = 156
+	itemChunk       uint8 = 228
+)
+
+// object IDs of the trees, and of objects in them, that have fixed IDs
+const (
+	objectIDRootTree uint64 = 1
+	objectIDFSTree   uint64 = 5
+	// objectIDRootTreeDir is the directory in the root tree which holds the default subvolume
+	objectIDRootTreeDir uint64 = 6
+	// objectIDFirstFree is the first ID of a subvolume after the top level one, and of an inode in a
+	// subvolume, which is its root directory
+	objectIDFirstFree uint64 = 256
+	// objectIDLastFree is the last ID of a subvolume; higher ones are for trees such as the tree log
+	objectIDLastFree uint64 = math.MaxUint64 - 256
+	// objectIDFirstChunkTree is the object ID of every chunk in the chunk tree
+	objectIDFirstChunkTree uint64 = 256
+)
+
+// key identifies an item in a tree, which are sorted by object ID, type and then offset
+type key struct {
+	objectID uint64
+	itemType uint8
+	offset   uint64
+}
+
+func parseKey(b []byte) key {
+	return key{
+		objectID: binary.LittleEndian.Uint64(b[0:8]),
+		itemType: b[8],
+		offset:   binary.LittleEndian.Uint64(b[9:17]),
+	}
+}
+
+// compare returns -1, 0 or 1 as k is before, the same as or after o
+func (k key) compare(o key) int {
+	switch {
+	case k.objectID < o.objectID:
+		return -1
+	case k.objectID > o.objectID:
+		return 1
+	case k.itemType < o.itemType:
+		return -1
+	case k.itemType > o.itemType:
+		return 1
+	case k.offset < o.offset:
+		return -1
+	case k.offset > o.offset:
+		return 1
+	}
+	return 0
+}
+
+// item is an item in a leaf, with its data
+type item struct {
+	key  key
+	data []byte
+}
+
+// keyPtr is a pointer in an internal node to a node below it, whose first key is key
+type keyPtr struct {
+	key      key
+	blockPtr uint64
+}
+
+// node is a node of a tree, a leaf with items or an internal node with pointers to the level below
+type node struct {
+	level uint8
+	items []item
+	ptrs  []keyPtr
+}
+
+// tree is where a tree starts
+type tree struct {
+	bytenr uint64
+	level  uint8
+}
+
+// parseNode parses the node at bytenr from b, which is all of it, and checks that it is the node it should be
+func (fs *FileSystem) parseNode(b []byte, bytenr uint64) (*node, error) {
+	if err := verifyChecksum(fs.superblock.checksumType, b); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b[0x20:0x30], fs.superblock.metadataUUID[:]) {
+		return nil, fmt.Errorf("filesystem ID %x does not match the superblock", b[0x20:0x30])
+	}
+	if actual := binary.LittleEndian.Uint64(b[0x30:0x38]); actual != bytenr {
+		return nil, fmt.Errorf("node says it is at %d", actual)
+	}
+	n := &node{level: b[0x64]}
+	count := int(binary.LittleEndian.Uint32(b[0x60:0x64]))
+	if n.level > maxLevel {
+		return nil, fmt.Errorf("level %d is more than the maximum %d", n.level, maxLevel)
+	}
+	if n.level > 0 {
+		if nodeHeaderSize+count*keyPtrSize > len(b) {
+			return nil, fmt.Errorf("%d pointers overflow the node", count)
+		}
+		n.ptrs = make([]keyPtr, count)
+		for i := range n.ptrs {
+			p := b[nodeHeaderSize+i*keyPtrSize:]
+			n.ptrs[i] = keyPtr{key: parseKey(p), blockPtr: binary.LittleEndian.Uint64(p[keySize : keySize+8])}
+		}
+		return n, nil
+	}
+	if nodeHeaderSize+count*itemSize > len(b) {
+		return nil, fmt.Errorf("%d items overflow the node", count)
+	}
+	n.items = make([]item, count)
+	for i := range n.items {
+		p := b[nodeHeaderSize+i*itemSize:]
+		offset := int(binary.LittleEndian.Uint32(p[keySize : keySize+4]))
+		size := int(binary.LittleEndian.Uint32(p[keySize+4 : keySize+8]))
+		if nodeHeaderSize+offset+size > len(b) {
+			return nil, fmt.Errorf("item %d of %d bytes at %d overflows the node", i, size, offset)
+		}
+		n.items[i] = item{key: parseKey(p), data: b[nodeHeaderSize+offset : nodeHeaderSize+offset+size]}
+	}
+	return n, nil
+}
+
+// readNode reads the node at logical address bytenr, which must be at level
+func (fs *FileSystem) readNode(bytenr uint64, level uint8) (*node, error) {
+	n, ok := fs.nodes[bytenr]
+	if !ok {
+		b := make([]byte, fs.superblock.nodeSize)
+		if err := fs.readLogical(b, bytenr); err != nil {
+			return nil, fmt.Errorf("unable to read node at %d: %v", bytenr, err)
+		}
+		var err error
+		if n, err = fs.parseNode(b, bytenr); err != nil {
+			return nil, fmt.Errorf("invalid node at %d: %v", bytenr, err)
+		}
+		if len(fs.nodes) >= nodeCacheSize {
+			fs.nodes = map[uint64]*node{}
+		}
+		fs.nodes[bytenr] = n
+	}
+	if n.level != level {
+		return nil, fmt.Errorf("node at %d is at level %d instead of expected %d", bytenr, n.level, level)
+	}
+	return n, nil
+}
+
+// walk calls fn for each item in t with a key from min to max, in order, until fn returns false or an error
+func (fs *FileSystem) walk(t tree, min, max key, fn func(item) (bool, error)) error {
+	_, err := fs.walkNode(t.bytenr, t.level, min, max, fn)
+	return err
+}
+
+func (fs *FileSystem) walkNode(bytenr uint64, level uint8, min, max key, fn func(item) (bool, error)) (bool, error) {
+	n, err := fs.readNode(bytenr, level)
+	if err != nil {
+		return false, err
+	}
+	if level == 0 {
+		for _, it := range n.items {
+			if it.key.compare(min) < 0 {
+				continue
+			}
+			if it.key.compare(max) > 0 {
+				return false, nil
+			}
+			more, err := fn(it)
+			if err != nil || !more {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	for i, p := range n.ptrs {
+		// the node below has the keys up to the first one of the next
+		if i+1 < len(n.ptrs) && n.ptrs[i+1].key.compare(min) <= 0 {
+			continue
+		}
+		if p.key.compare(max) > 0 {
+			return false, nil
+		}
+		more, err := fs.walkNode(p.blockPtr, level-1, min, max, fn)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// items returns the items in t with objectID and itemType, in order of their offset
+func (fs *FileSystem) items(t tree, objectID uint64, itemType uint8) ([]item, error) {
+	var items []item
+	err := fs.walk(t, key{objectID, itemType, 0}, key{objectID, itemType, math.MaxUint64}, func(it item) (bool, error) {
+		items = append(items, it)
+		return true, nil
+	})
+	return items, err
+}
+
+// find returns the item in t with key k, or nil if there is none
+func (fs *FileSystem) find(t tree, k key) (*item, error) {
+	var found *item
+	err := fs.walk(t, k, k, func(it item) (bool, error) {
+		found = &it
+		return false, nil
+	})
+	return found, err
+}
@@ -0,0 +1,98 @@
+package btrfs
+
+import (
+	"hash/crc32"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// KB represents one KB
+	KB int64 = 1024
+	// MB represents one MB
+	MB int64 = 1024 * KB
+	// GB represents one GB
+	GB int64 = 1024 * MB
+)
+
+// unix file mode bits, as stored in an inode
+const (
+	modeTypeMask   uint32 = 0o170000
+	modeSocket     uint32 = 0o140000
+	modeSymlink    uint32 = 0o120000
+	modeRegular    uint32 = 0o100000
+	modeBlockDev   uint32 = 0o060000
+	modeDirectory  uint32 = 0o040000
+	modeCharDev    uint32 = 0o020000
+	modeFifo       uint32 = 0o010000
+	modeSetuid     uint32 = 0o4000
+	modeSetgid     uint32 = 0o2000
+	modeSticky     uint32 = 0o1000
+	modePermission uint32 = 0o777
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// nameHash is the hash of a name in a directory or of an extended attribute, which is part of the key of its
+// item: a crc32c the way the kernel does it, without inverting it at the start or end, with a seed of ^1
+func nameHash(name string) uint64 {
+	return uint64(^crc32.Update(1, crc32cTable, []byte(name)))
+}
+
+// fileMode converts a unix mode, as stored in an inode, to an os.FileMode
+func fileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & modePermission)
+	switch m & modeTypeMask {
+	case modeDirectory:
+		mode |= os.ModeDir
+	case modeSymlink:
+		mode |= os.ModeSymlink
+	case modeBlockDev:
+		mode |= os.ModeDevice
+	case modeCharDev:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case modeFifo:
+		mode |= os.ModeNamedPipe
+	case modeSocket:
+		mode |= os.ModeSocket
+	}
+	if m&modeSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&modeSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&modeSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// decodeDev splits a device number into major and minor the way Linux new_decode_dev does
+func decodeDev(dev uint64) (major, minor uint32) {
+	return uint32((dev & 0xfff00) >> 8), uint32((dev & 0xff) | ((dev >> 12) & 0xfff00))
+}
+
+// timespec converts a btrfs time, of seconds since the epoch and nanoseconds, to a time.Time
+func timespec(sec uint64, nsec uint32) time.Time {
+	return time.Unix(int64(sec), int64(nsec)).UTC()
+}
+
+func universalizePath(p string) string {
+	// globalize the separator
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+func splitPath(p string) []string {
+	ps := universalizePath(p)
+	parts := strings.Split(ps, "/")
+	// eliminate empty parts
+	ret := make([]string, 0)
+	for _, sub := range parts {
+		if sub != "" && sub != "." {
+			ret = append(ret, sub)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,267 @@
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+type compression uint8
+
+const (
+	compressionLz4     compression = 0
+	compressionLzma    compression = 1
+	compressionDeflate compression = 2
+	compressionZstd    compression = 3
+)
+
+const (
+	// lz4HashTableSize is the size of the hash table the lz4 block compressor needs
+	lz4HashTableSize = 1 << 16
+	// lzmaDefaultDictSize is the dictionary size for lzma, which only needs to cover the largest extent
+	lzmaDefaultDictSize uint32 = 64 * 1024
+	// lzmaMaxDictSize is the largest dictionary Linux allows for lzma
+	lzmaMaxDictSize uint32 = 8 * 1024 * 1024
+	// compressionConfigSize is the size of the configuration of each algorithm
+	compressionConfigSize = 14
+)
+
+// Compressor defines a compressor for the data of regular files. Fulfilled by various implementations in
+// this package.
+//
+// Data is compressed into extents, each of which fits in a single block.
+type Compressor interface {
+	// compress compresses in, returning nil if it does not fit in max bytes
+	compress(in []byte, max int) ([]byte, error)
+	// decompress decompresses in to size bytes. It skips any padding of zeros in front of the data.
+	decompress(in []byte, size int) ([]byte, error)
+	// loadConfig loads the configuration of the algorithm that follows the superblock
+	loadConfig([]byte) error
+	// configBytes returns the configuration of the algorithm to follow the superblock, or nil if it needs none
+	configBytes() []byte
+	flavour() compression
+}
+
+// CompressorLz4 lz4 compression, with the lz4 block format
+type CompressorLz4 struct {
+}
+
+func (c *CompressorLz4) compress(in []byte, max int) ([]byte, error) {
+	out := make([]byte, lz4.CompressBlockBound(len(in)))
+	n, err := lz4.CompressBlock(in, out, make([]int, lz4HashTableSize))
+	if err != nil {
+		return nil, fmt.Errorf("error compressing with lz4: %v", err)
+	}
+	// 0 means it is not compressible
+	if n == 0 || n > max {
+		return nil, nil
+	}
+	return out[:n], nil
+}
+
+// decompress decompresses an lz4 block. It stops once it has size bytes, as without zero padding there
+// may be anything following the compressed data.
+func (c *CompressorLz4) decompress(in []byte, size int) ([]byte, error) {
+	in = trimZeroPadding(in)
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in) && len(out) < size; {
+		token := in[i]
+		i++
+		literals, n, err := lz4Length(in[i:], int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		if i+literals > len(in) {
+			return nil, fmt.Errorf("lz4 literals of %d bytes overflow the input at %d", literals, i)
+		}
+		out = append(out, in[i:i+literals]...)
+		i += literals
+		// the last sequence is only literals
+		if i == len(in) || len(out) >= size {
+			break
+		}
+		if i+2 > len(in) {
+			return nil, fmt.Errorf("lz4 match offset overflows the input at %d", i)
+		}
+		offset := int(binary.LittleEndian.Uint16(in[i : i+2]))
+		i += 2
+		if offset == 0 || offset > len(out) {
+			return nil, fmt.Errorf("invalid lz4 match offset %d with %d bytes decompressed", offset, len(out))
+		}
+		match, n, err := lz4Length(in[i:], int(token&0xf))
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		start := len(out) - offset
+		for j := 0; j < match+4; j++ {
+			out = append(out, out[start+j])
+		}
+	}
+	if len(out) < size {
+		return nil, fmt.Errorf("lz4 decompressed to %d bytes instead of expected %d", len(out), size)
+	}
+	return out[:size], nil
+}
+
+// lz4Length finishes reading a literal or match length from the nibble in its token, which is extended by
+// the bytes that follow while it is 15, and returns how many bytes that took
+func lz4Length(b []byte, nibble int) (length, n int, err error) {
+	length = nibble
+	if nibble != 0xf {
+		return length, 0, nil
+	}
+	for {
+		if n >= len(b) {
+			return 0, 0, fmt.Errorf("lz4 length overflows the input")
+		}
+		length += int(b[n])
+		n++
+		if b[n-1] != 0xff {
+			return length, n, nil
+		}
+	}
+}
+
+func (c *CompressorLz4) loadConfig(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("lz4 configuration was %d bytes instead of at least 4", len(b))
+	}
+	return nil
+}
+
+// configBytes returns nil, as the defaults for lz4 are what it uses
+func (c *CompressorLz4) configBytes() []byte {
+	return nil
+}
+
+func (c *CompressorLz4) flavour() compression {
+	return compressionLz4
+}
+
+// CompressorLzma lzma compression, with the MicroLZMA format Linux uses for EROFS: a raw lzma stream without
+// a header or end marker, whose first byte, always 0, is replaced by the inverted properties byte
+type CompressorLzma struct {
+	dictSize uint32
+}
+
+func (c *CompressorLzma) dictCap() uint32 {
+	if c.dictSize == 0 {
+		return lzmaDefaultDictSize
+	}
+	return c.dictSize
+}
+
+func (c *CompressorLzma) compress(in []byte, max int) ([]byte, error) {
+	var b bytes.Buffer
+	props := lzma.Properties{LC: 3, LP: 0, PB: 2}
+	config := lzma.WriterConfig{
+		Properties:   &props,
+		DictCap:      int(c.dictCap()),
+		SizeInHeader: true,
+		Size:         int64(len(in)),
+	}
+	lz, err := config.NewWriter(&b)
+	if err != nil {
+		return nil, fmt.Errorf("error creating lzma compressor: %v", err)
+	}
+	if _, err := lz.Write(in); err != nil {
+		return nil, fmt.Errorf("error compressing with lzma: %v", err)
+	}
+	if err := lz.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing with lzma: %v", err)
+	}
+	// drop the 13 byte header, and replace the first byte of the stream
+	out := b.Bytes()[lzmaHeaderSize:]
+	if len(out) == 0 || out[0] != 0 {
+		return nil, fmt.Errorf("lzma stream does not start with 0")
+	}
+	if len(out) > max {
+		return nil, nil
+	}
+	out[0] = ^props.Code()
+	return out, nil
+}
+
+// lzmaHeaderSize is the size of the header of an lzma file: properties, dictionary size and uncompressed size
+const lzmaHeaderSize = 13
+
+func (c *CompressorLzma) decompress(in []byte, size int) ([]byte, error) {
+	in = trimZeroPadding(in)
+	if len(in) == 0 {
+		return nil, fmt.Errorf("no lzma data")
+	}
+	// turn it back into an lzma file, with a header, for the decompressor
+	b := make([]byte, lzmaHeaderSize, lzmaHeaderSize+len(in))
+	b[0] = ^in[0]
+	binary.LittleEndian.PutUint32(b[1:5], c.dictCap())
+	binary.LittleEndian.PutUint64(b[5:13], uint64(size))
+	b = append(b, 0)
+	b = append(b, in[1:]...)
+	lz, err := lzma.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("error creating lzma decompressor: %v", err)
+	}
+	out := make([]byte, size)
+	if _, err := io.ReadFull(lz, out); err != nil {
+		return nil, fmt.Errorf("error decompressing lzma: %v", err)
+	}
+	return out, nil
+}
+
+func (c *CompressorLzma) loadConfig(b []byte) error {
+	if len(b) < 6 {
+		return fmt.Errorf("lzma configuration was %d bytes instead of at least 6", len(b))
+	}
+	dictSize := binary.LittleEndian.Uint32(b[0:4])
+	if dictSize > lzmaMaxDictSize {
+		return fmt.Errorf("lzma dictionary size %d is more than the maximum %d", dictSize, lzmaMaxDictSize)
+	}
+	if format := binary.LittleEndian.Uint16(b[4:6]); format != 0 {
+		return fmt.Errorf("unsupported lzma format %d", format)
+	}
+	c.dictSize = dictSize
+	return nil
+}
+
+func (c *CompressorLzma) configBytes() []byte {
+	b := make([]byte, compressionConfigSize)
+	binary.LittleEndian.PutUint32(b[0:4], c.dictCap())
+	return b
+}
+
+func (c *CompressorLzma) flavour() compression {
+	return compressionLzma
+}
+
+func newCompressor(flavour compression) (Compressor, error) {
+	var c Compressor
+	switch flavour {
+	case compressionLz4:
+		c = &CompressorLz4{}
+	case compressionLzma:
+		c = &CompressorLzma{}
+	case compressionDeflate:
+		return nil, fmt.Errorf("deflate compression not yet supported")
+	case compressionZstd:
+		return nil, fmt.Errorf("zstd compression not yet supported")
+	default:
+		return nil, fmt.Errorf("unknown compression type: %d", flavour)
+	}
+	return c, nil
+}
+
+// trimZeroPadding drops the zeros in front of compressed data that is at the end of its blocks
+func trimZeroPadding(b []byte) []byte {
+	for i, c := range b {
+		if c != 0 {
+			return b[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package erofs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+	compressible := bytes.Repeat([]byte("erofs compresses this text again and again\n"), 1000)
+	random := make([]byte, 4096)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("unable to generate random data: %v", err)
+	}
+	for _, c := range []Compressor{&CompressorLz4{}, &CompressorLzma{}} {
+		out, err := c.compress(compressible, 4096)
+		if err != nil {
+			t.Fatalf("%d: unexpected error compressing: %v", c.flavour(), err)
+		}
+		if out == nil || len(out) > 4096 {
+			t.Fatalf("%d: compressed %d bytes into %d", c.flavour(), len(compressible), len(out))
+		}
+		// compressed data is at the end of its block, with zeros in front of it
+		block := make([]byte, 4096)
+		copy(block[len(block)-len(out):], out)
+		in, err := c.decompress(block, len(compressible))
+		if err != nil {
+			t.Fatalf("%d: unexpected error decompressing: %v", c.flavour(), err)
+		}
+		if !bytes.Equal(in, compressible) {
+			t.Errorf("%d: mismatched decompressed data", c.flavour())
+		}
+		// what does not fit is not compressed
+		if out, err := c.compress(random, 4096); out != nil || err != nil {
+			t.Errorf("%d: compressed random data into %d bytes, error %v", c.flavour(), len(out), err)
+		}
+	}
+}
+
+func TestCompressorLzmaConfig(t *testing.T) {
+	c := &CompressorLzma{}
+	b := c.configBytes()
+	if len(b) != compressionConfigSize {
+		t.Fatalf("configuration of %d bytes instead of %d", len(b), compressionConfigSize)
+	}
+	loaded := &CompressorLzma{}
+	if err := loaded.loadConfig(b); err != nil {
+		t.Fatalf("unexpected error loading configuration: %v", err)
+	}
+	if loaded.dictCap() != lzmaDefaultDictSize {
+		t.Errorf("dictionary size %d instead of %d", loaded.dictCap(), lzmaDefaultDictSize)
+	}
+	b[3] = 0xff
+	if err := loaded.loadConfig(b); err == nil {
+		t.Errorf("loaded oversized dictionary without error")
+	}
+}
+
+func TestNewCompressor(t *testing.T) {
+	tests := []struct {
+		flavour compression
+		valid   bool
+	}{
+		{compressionLz4, true},
+		{compressionLzma, true},
+		{compressionDeflate, false},
+		{compressionZstd, false},
+		{compression(9), false},
+	}
+	for _, tt := range tests {
+		c, err := newCompressor(tt.flavour)
+		switch {
+		case tt.valid && (err != nil || c.flavour() != tt.flavour):
+			t.Errorf("%d: unexpected error %v", tt.flavour, err)
+		case !tt.valid && err == nil:
+			t.Errorf("%d: created unsupported compressor without error", tt.flavour)
+		}
+	}
+}
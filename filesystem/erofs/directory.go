@@ -0,0 +1,130 @@
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const direntSize = 12
+
+// file types in a directory entry
+const (
+	fileTypeUnknown  uint8 = 0
+	fileTypeRegular  uint8 = 1
+	fileTypeDir      uint8 = 2
+	fileTypeCharDev  uint8 = 3
+	fileTypeBlockDev uint8 = 4
+	fileTypeFifo     uint8 = 5
+	fileTypeSocket   uint8 = 6
+	fileTypeSymlink  uint8 = 7
+)
+
+// dirent is a single entry in a directory, pointing to the inode of a file by its nid
+type dirent struct {
+	nid      uint64
+	fileType uint8
+	name     string
+}
+
+// fileTypeFromMode is the file type in a directory entry for an inode of the given mode
+func fileTypeFromMode(mode uint16) uint8 {
+	switch mode & modeTypeMask {
+	case modeRegular:
+		return fileTypeRegular
+	case modeDirectory:
+		return fileTypeDir
+	case modeCharDev:
+		return fileTypeCharDev
+	case modeBlockDev:
+		return fileTypeBlockDev
+	case modeFifo:
+		return fileTypeFifo
+	case modeSocket:
+		return fileTypeSocket
+	case modeSymlink:
+		return fileTypeSymlink
+	}
+	return fileTypeUnknown
+}
+
+// parseDirectoryBlock parses the entries in a single directory block. b is just the part of the block within
+// the size of the directory, so the last name ends at the end of b, or at a 0 byte before it.
+//
+// Each block starts with the entries, and then their names, with the offset to the first name giving how
+// many entries there are.
+func parseDirectoryBlock(b []byte) ([]*dirent, error) {
+	if len(b) < direntSize {
+		return nil, fmt.Errorf("directory block of %d bytes is too small for any entry", len(b))
+	}
+	first := int(binary.LittleEndian.Uint16(b[8:10]))
+	if first < direntSize || first%direntSize != 0 || first > len(b) {
+		return nil, fmt.Errorf("invalid offset %d to the first name in a directory block of %d bytes", first, len(b))
+	}
+	count := first / direntSize
+	entries := make([]*dirent, 0, count)
+	for i := 0; i < count; i++ {
+		e := b[i*direntSize : (i+1)*direntSize]
+		nameOff := int(binary.LittleEndian.Uint16(e[8:10]))
+		nameEnd := len(b)
+		if i+1 < count {
+			nameEnd = int(binary.LittleEndian.Uint16(b[(i+1)*direntSize+8:]))
+		}
+		if nameOff < first || nameEnd > len(b) || nameOff >= nameEnd {
+			return nil, fmt.Errorf("invalid name from %d to %d for directory entry %d", nameOff, nameEnd, i)
+		}
+		name := b[nameOff:nameEnd]
+		if i+1 == count {
+			if n := bytes.IndexByte(name, 0); n >= 0 {
+				name = name[:n]
+			}
+		}
+		entries = append(entries, &dirent{
+			nid:      binary.LittleEndian.Uint64(e[0:8]),
+			fileType: e[10],
+			name:     string(name),
+		})
+	}
+	return entries, nil
+}
+
+// directoryBytes returns the content of a directory with the given entries, which should include . and ..
+// Entries are sorted by name, which lets the kernel look them up with a binary search, and packed into
+// blocks without spanning any two. The last block is only as long as what it holds.
+func directoryBytes(entries []*dirent, blocksize int) ([]byte, error) {
+	sorted := make([]*dirent, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+
+	var b []byte
+	for i := 0; i < len(sorted); {
+		// find how many entries fit in this block
+		used, count := 0, 0
+		for _, e := range sorted[i:] {
+			if used+direntSize+len(e.name) > blocksize {
+				break
+			}
+			used += direntSize + len(e.name)
+			count++
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("directory entry %s does not fit in a block", sorted[i].name)
+		}
+		if len(b)%blocksize != 0 {
+			b = append(b, make([]byte, blocksize-len(b)%blocksize)...)
+		}
+		block := make([]byte, used)
+		nameOff := count * direntSize
+		for j, e := range sorted[i : i+count] {
+			d := block[j*direntSize : (j+1)*direntSize]
+			binary.LittleEndian.PutUint64(d[0:8], e.nid)
+			binary.LittleEndian.PutUint16(d[8:10], uint16(nameOff))
+			d[10] = e.fileType
+			nameOff += copy(block[nameOff:], e.name)
+		}
+		b = append(b, block...)
+		i += count
+	}
+	return b, nil
+}
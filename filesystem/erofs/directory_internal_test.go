@@ -0,0 +1,68 @@
+package erofs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryRoundTrip(t *testing.T) {
+	blocksize := 512
+	entries := []*dirent{
+		{nid: 0, fileType: fileTypeDir, name: "."},
+		{nid: 0, fileType: fileTypeDir, name: ".."},
+	}
+	for i := 0; i < 50; i++ {
+		entries = append(entries, &dirent{nid: uint64(i + 2), fileType: fileTypeRegular, name: fmt.Sprintf("file_%03d", 49-i)})
+	}
+	b, err := directoryBytes(entries, blocksize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b) <= blocksize || len(b)%blocksize == 0 {
+		t.Errorf("%d bytes is not several blocks, the last only partly used", len(b))
+	}
+	var parsed []*dirent
+	for pos := 0; pos < len(b); pos += blocksize {
+		end := pos + blocksize
+		if end > len(b) {
+			end = len(b)
+		}
+		block, err := parseDirectoryBlock(b[pos:end])
+		if err != nil {
+			t.Fatalf("unexpected error parsing block at %d: %v", pos, err)
+		}
+		parsed = append(parsed, block...)
+	}
+	if len(parsed) != len(entries) {
+		t.Fatalf("parsed %d entries instead of %d", len(parsed), len(entries))
+	}
+	for i, e := range parsed {
+		if i > 0 && parsed[i-1].name >= e.name {
+			t.Errorf("entry %s is not sorted after %s", e.name, parsed[i-1].name)
+		}
+		if e.name != "." && e.name != ".." && e.nid != uint64(51-int(e.name[7]-'0')-10*int(e.name[6]-'0')) {
+			t.Errorf("entry %s has mismatched nid %d", e.name, e.nid)
+		}
+	}
+}
+
+func TestDirectoryBytesTooLong(t *testing.T) {
+	entries := []*dirent{{nid: 1, fileType: fileTypeRegular, name: strings.Repeat("a", 600)}}
+	if _, err := directoryBytes(entries, 512); err == nil {
+		t.Errorf("created directory with a name longer than a block without error")
+	}
+}
+
+func TestParseDirectoryBlockInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"too short":         make([]byte, 8),
+		"bad first name":    {0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 1, 0},
+		"name past the end": {0, 0, 0, 0, 0, 0, 0, 0, 100, 0, 1, 0},
+	}
+	for name, b := range tests {
+		if _, err := parseDirectoryBlock(b); err == nil {
+			t.Errorf("%s: parsed invalid block without error", name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package erofs
+
+import (
+	"os"
+	"time"
+)
+
+// FileStat is the extended data underlying a single file, similar to https://golang.org/pkg/syscall/#Stat_t
+type FileStat struct {
+	nid    uint64
+	links  uint32
+	uid    uint32
+	gid    uint32
+	rdev   uint32
+	xattrs map[string]string
+}
+
+// Nid get the nid of the inode of file, which is where it is in the metadata of the filesystem
+func (f *FileStat) Nid() uint64 {
+	return f.nid
+}
+
+// Nlink get the number of hard links to file
+func (f *FileStat) Nlink() uint32 {
+	return f.links
+}
+
+// UID get uid of file
+func (f *FileStat) UID() uint32 {
+	return f.uid
+}
+
+// GID get gid of file
+func (f *FileStat) GID() uint32 {
+	return f.gid
+}
+
+// Rdev get the major and minor device numbers of a block or character device
+func (f *FileStat) Rdev() (major, minor uint32) {
+	return decodeDev(f.rdev)
+}
+
+// Xattrs get extended attributes of file
+func (f *FileStat) Xattrs() map[string]string {
+	return f.xattrs
+}
+
+// directoryEntry is a single directory entry
+// it combines information from inode and the actual entry
+// also fulfills os.FileInfo
+//
+//	Name() string       // base name of the file
+//	Size() int64        // length in bytes for regular files; system-dependent for others
+//	Mode() FileMode     // file mode bits
+//	ModTime() time.Time // modification time
+//	IsDir() bool        // abbreviation for Mode().IsDir()
+//	Sys() interface{}   // underlying data source (can return nil)
+type directoryEntry struct {
+	name  string
+	inode *inode
+	sys   FileStat
+}
+
+// Name string       // base name of the file
+func (d *directoryEntry) Name() string {
+	return d.name
+}
+
+// Size int64        // length in bytes for regular files; system-dependent for others
+func (d *directoryEntry) Size() int64 {
+	return int64(d.inode.size)
+}
+
+// IsDir bool        // abbreviation for Mode().IsDir()
+func (d *directoryEntry) IsDir() bool {
+	return d.inode.isDir()
+}
+
+// ModTime time.Time // modification time
+func (d *directoryEntry) ModTime() time.Time {
+	return d.inode.modTime
+}
+
+// Mode FileMode     // file mode bits
+func (d *directoryEntry) Mode() os.FileMode {
+	return fileMode(d.inode.mode)
+}
+
+// Sys interface{}   // underlying data source (can return nil)
+func (d *directoryEntry) Sys() interface{} {
+	return d.sys
+}
@@ -0,0 +1,12 @@
+// Package erofs provides support for reading and creating EROFS, the Enhanced Read-Only File System
+// used for Android system partitions and container images.
+//
+// Like squashfs, a new filesystem is built in a workspace directory and written out by Finalize, after
+// which it is read-only.
+//
+// references:
+//
+//	https://docs.kernel.org/filesystems/erofs.html
+//	https://github.com/torvalds/linux/blob/master/fs/erofs/erofs_fs.h
+//	https://git.kernel.org/pub/scm/linux/kernel/git/xiang/erofs-utils.git
+package erofs
@@ -0,0 +1,796 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/internal/workspace"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/pkg/xattr"
+)
+
+const (
+	defaultBlockSize int64 = 4096
+	minBlocksize     int64 = 1 << minBlockBits
+	maxBlocksize     int64 = 1 << maxBlockBits
+	// maxVolumeNameSize is the most bytes a label can have
+	maxVolumeNameSize = 16
+	// maxSymlinkHops is how many symlinks Stat follows before giving up, as Linux does
+	maxSymlinkHops = 40
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	workspace   string
+	superblock  *superblock
+	size        int64
+	start       int64
+	file        util.File
+	blocksize   int64
+	compressors map[compression]Compressor
+	rootDir     *inode
+	// label is the label set in the workspace, to write when it is finalized
+	label string
+	// stagedXattrs holds the xattrs set in the workspace, by path, which replace any the files there have
+	stagedXattrs map[string]map[string]string
+}
+
+// Label return the filesystem label
+func (fs *FileSystem) Label() string {
+	if fs.workspace != "" || fs.superblock == nil {
+		return fs.label
+	}
+	return fs.superblock.volumeName
+}
+
+// SetLabel sets the label to write to the filesystem when it is finalized, of at most 16 bytes. Once the
+// filesystem is finalized, it is read-only and SetLabel returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) SetLabel(label string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot set label on EROFS filesystem: %w", filesystem.ErrReadonlyFilesystem)
+	}
+	if len(label) > maxVolumeNameSize {
+		return fmt.Errorf("label %s is %d bytes, more than the maximum %d", label, len(label), maxVolumeNameSize)
+	}
+	fs.label = label
+	return nil
+}
+
+// Statfs get the capacity of the filesystem, from its superblock. As it is read-only, it has no free space.
+//
+// Before it is finalized, it has no size of its own, so TotalBytes is 0, and UsedBytes is the uncompressed
+// size of the files in the workspace, each rounded up to a whole block, which is usually more than the
+// finalized size.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	if fs.workspace != "" {
+		return workspace.Statfs(fs.workspace, fs.blocksize)
+	}
+	if fs.superblock == nil {
+		return filesystem.Statfs{}, fmt.Errorf("filesystem has no superblock")
+	}
+	total := int64(fs.superblock.blocks) * fs.superblock.blocksize()
+	return filesystem.Statfs{
+		BlockSize:  fs.superblock.blocksize(),
+		TotalBytes: total,
+		UsedBytes:  total,
+		Files:      fs.superblock.inodes,
+	}, nil
+}
+
+// Workspace get the workspace path
+func (fs *FileSystem) Workspace() string {
+	return fs.workspace
+}
+
+// Create creates an erofs filesystem in a given directory
+//
+// requires the util.File where to create the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File to create the filesystem,
+// and blocksize is is the block size of the filesystem
+//
+// note that you are *not* required to create the filesystem on the entire disk. You could have a disk of size
+// 20GB, and create a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for creating filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// If the provided blocksize is 0, it will use the default of 4 KB. It must be a power of 2 from 512 bytes to
+// 64 KB, but Linux only mounts filesystems whose block size is no more than its page size, and before 6.4,
+// only those whose block size is the page size, usually 4 KB.
+//
+// Nothing is written until Finalize; until then, the filesystem is a workspace directory.
+func Create(f util.File, size, start, blocksize int64) (*FileSystem, error) {
+	if blocksize == 0 {
+		blocksize = defaultBlockSize
+	}
+	// make sure it is an allowed blocksize
+	if err := validateBlocksize(blocksize); err != nil {
+		return nil, err
+	}
+
+	// create a temporary working area where we can create the filesystem.
+	//  It is only on `Finalize()` that we write it out to the actual disk file
+	tmpdir, err := os.MkdirTemp("", "diskfs_erofs")
+	if err != nil {
+		return nil, fmt.Errorf("could not create working directory: %v", err)
+	}
+
+	return &FileSystem{
+		workspace: tmpdir,
+		start:     start,
+		size:      size,
+		file:      f,
+		blocksize: blocksize,
+	}, nil
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// note that you are *not* required to read a filesystem on the entire disk. You could have a disk of size
+// 20GB, and a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for working with filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// The filesystem block size is taken from the superblock, so blocksize is only checked: it must be 0, or a
+// power of 2 from 512 bytes to 64 KB.
+//
+// It reads files that are uncompressed, chunk-based, or compressed with lz4 or lzma, but not filesystems with
+// extra devices, fragments, deduplication or long xattr prefixes.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	if blocksize != 0 {
+		if err := validateBlocksize(blocksize); err != nil {
+			return nil, err
+		}
+	}
+
+	// read the superblock, and as much after it as its checksum covers, which depends on the block size in it
+	b := make([]byte, superblockSize)
+	if _, err := file.ReadAt(b, start+superblockOffset); err != nil {
+		return nil, fmt.Errorf("unable to read bytes for superblock: %v", err)
+	}
+	if magic := binary.LittleEndian.Uint32(b[0:4]); magic != superblockMagic {
+		return nil, fmt.Errorf("superblock had magic of %#x instead of expected %#x", magic, superblockMagic)
+	}
+	if blockBits := b[12]; blockBits >= minBlockBits && blockBits <= maxBlockBits {
+		if n := checksumSize(1 << blockBits); n > superblockSize {
+			b = make([]byte, n)
+			if _, err := file.ReadAt(b, start+superblockOffset); err != nil {
+				return nil, fmt.Errorf("unable to read bytes for superblock: %v", err)
+			}
+		}
+	}
+	s, err := parseSuperblock(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing superblock: %v", err)
+	}
+	if size > 0 && int64(s.blocks)*s.blocksize() > size {
+		return nil, fmt.Errorf("filesystem of %d blocks of %d bytes is larger than the %d bytes given", s.blocks, s.blocksize(), size)
+	}
+
+	fs := &FileSystem{
+		workspace:  "", // no workspace when we do nothing with it
+		start:      start,
+		size:       size,
+		file:       file,
+		superblock: s,
+		blocksize:  s.blocksize(),
+	}
+	if err := fs.loadCompressors(); err != nil {
+		return nil, fmt.Errorf("error reading compression configuration: %v", err)
+	}
+	// for efficiency, read in the root inode right now
+	rootInode, err := fs.readInode(uint64(s.rootNid))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read root inode: %v", err)
+	}
+	if !rootInode.isDir() {
+		return nil, fmt.Errorf("root inode %d is not a directory", s.rootNid)
+	}
+	fs.rootDir = rootInode
+	return fs, nil
+}
+
+// loadCompressors finds the compression algorithms the filesystem uses, with their configuration. Without
+// any configuration, it is just lz4.
+func (fs *FileSystem) loadCompressors() error {
+	s := fs.superblock
+	fs.compressors = map[compression]Compressor{}
+	if s.incompatFeatures&featureIncompatComprCfgs == 0 {
+		fs.compressors[compressionLz4] = &CompressorLz4{}
+		return nil
+	}
+	// each configuration is a 2 byte size followed by the configuration itself, 4 byte aligned
+	pos := superblockOffset + s.size()
+	for algs := s.availableComprAlgs; algs != 0; algs &= algs - 1 {
+		alg := compression(bits.TrailingZeros16(algs))
+		pos = (pos + 3) &^ 3
+		b, err := fs.readBytes(pos, 2)
+		if err != nil {
+			return err
+		}
+		size := int(binary.LittleEndian.Uint16(b))
+		if size == 0 {
+			size = 1 << 16
+		}
+		config, err := fs.readBytes(pos+2, size)
+		if err != nil {
+			return err
+		}
+		pos += 2 + int64(size)
+		// leave out any algorithm this does not support, which gives an error only when a file uses it
+		c, err := newCompressor(alg)
+		if err != nil {
+			continue
+		}
+		if err := c.loadConfig(config); err != nil {
+			return fmt.Errorf("invalid configuration for compression %d: %v", alg, err)
+		}
+		fs.compressors[alg] = c
+	}
+	return nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeEROFS
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeEROFS
+}
+
+// Mkdir make a directory at the given path. It is equivalent to `mkdir -p`, i.e. idempotent, in that:
+//
+// * It will make the entire tree path if it does not exist
+// * It will not return an error if the path already exists
+//
+// if readonly and not in workspace, will return an error
+func (fs *FileSystem) Mkdir(p string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	err := os.MkdirAll(path.Join(fs.workspace, p), 0o755)
+	if err != nil {
+		return fmt.Errorf("could not create directory %s: %v", p, err)
+	}
+	return nil
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	var fi []os.FileInfo
+	// non-workspace: read from erofs
+	// workspace: read from regular filesystem
+	if fs.workspace != "" {
+		fullPath := path.Join(fs.workspace, p)
+		// read the entries
+		dirEntries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+		}
+		for _, e := range dirEntries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+			}
+			fi = append(fi, info)
+		}
+	} else {
+		dirEntries, err := fs.readDirectory(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+		}
+		fi = make([]os.FileInfo, 0, len(dirEntries))
+		for _, entry := range dirEntries {
+			fi = append(fi, entry)
+		}
+	}
+	return fi, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+// or write contents to the file
+//
+// accepts normal os.OpenFile flags
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	if fs.workspace != "" {
+		f, err := os.OpenFile(path.Join(fs.workspace, p), flag, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("target file %s does not exist: %v", p, err)
+		}
+		return f, nil
+	}
+
+	// cannot open to write or append or create if we do not have a workspace
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if de.IsDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	if !de.inode.isRegular() {
+		return nil, fmt.Errorf("cannot open %s, which is not a regular file", p)
+	}
+	return fs.newFile(de.inode)
+}
+
+// Stat returns the FileInfo for a file or directory, from the workspace if the filesystem has not been finalized.
+// If p is a symlink, Stat returns the FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Stat(fs.workspace, p)
+	}
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !de.inode.isSymlink() {
+			return de, nil
+		}
+		target, err := fs.readlink(de.inode)
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, fmt.Errorf("too many levels of symlinks at %s", p)
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+// It reads from the workspace if the filesystem has not been finalized.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Lstat(fs.workspace, p)
+	}
+	return fs.lstat(p)
+}
+
+// Readlink returns the target of a symlink, from the workspace if the filesystem has not been finalized.
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	if fs.workspace != "" {
+		return workspace.Readlink(fs.workspace, p)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if !de.inode.isSymlink() {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	return fs.readlink(de.inode)
+}
+
+// Symlink creates newname as a symlink to oldname in the workspace. Once the filesystem is finalized, it is
+// read-only and Symlink returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return workspace.Symlink(fs.workspace, oldname, newname)
+}
+
+// Getxattr returns the value of the named extended attribute of p, from the workspace if the filesystem has not
+// been finalized. Returns an error wrapping filesystem.ErrXattrNotExist if p does not have it.
+func (fs *FileSystem) Getxattr(p, name string) ([]byte, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	val, ok := xattrs[name]
+	if !ok {
+		return nil, fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	return []byte(val), nil
+}
+
+// Listxattr returns the sorted names of the extended attributes of p, from the workspace if the filesystem
+// has not been finalized
+func (fs *FileSystem) Listxattr(p string) ([]string, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(xattrs))
+	for k := range xattrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Setxattr sets the named extended attribute of p in the workspace. erofs only supports names in the
+// user, trusted, lustre and security namespaces, and the posix acls.
+//
+// Extended attributes are kept with the filesystem rather than set on the workspace, so they need no
+// privileges, and replace any the workspace files have. They are only stored in the finalized filesystem if
+// FinalizeOptions.Xattrs is set. Once the filesystem is finalized, it is read-only and Setxattr returns
+// an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Setxattr(p, name string, value []byte) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot set xattr on %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	if _, _, err := xattrKeyConvert(name); err != nil {
+		return err
+	}
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return err
+	}
+	staged := make(map[string]string, len(xattrs)+1)
+	for k, v := range xattrs {
+		staged[k] = v
+	}
+	staged[name] = string(value)
+	if fs.stagedXattrs == nil {
+		fs.stagedXattrs = map[string]map[string]string{}
+	}
+	fs.stagedXattrs[path.Join("/", p)] = staged
+	return nil
+}
+
+// Removexattr removes the named extended attribute of p in the workspace. Returns an error wrapping
+// filesystem.ErrXattrNotExist if p does not have it. Once the filesystem is finalized, it is read-only
+// and Removexattr returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Removexattr(p, name string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot remove xattr from %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return err
+	}
+	if _, ok := xattrs[name]; !ok {
+		return fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	staged := make(map[string]string, len(xattrs))
+	for k, v := range xattrs {
+		if k != name {
+			staged[k] = v
+		}
+	}
+	if fs.stagedXattrs == nil {
+		fs.stagedXattrs = map[string]map[string]string{}
+	}
+	fs.stagedXattrs[path.Join("/", p)] = staged
+	return nil
+}
+
+// getXattrs returns the xattrs of p: those staged for it or the ones in the workspace before the
+// filesystem is finalized, and the ones stored with it after
+func (fs *FileSystem) getXattrs(p string) (map[string]string, error) {
+	if fs.workspace == "" {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		return de.sys.xattrs, nil
+	}
+	fp := workspace.Path(fs.workspace, p)
+	if _, err := os.Lstat(fp); err != nil {
+		return nil, fmt.Errorf("could not stat %s: %w", p, err)
+	}
+	if staged, ok := fs.stagedXattrs[path.Join("/", p)]; ok {
+		return staged, nil
+	}
+	return workspaceXattrs(fp)
+}
+
+// workspaceXattrs reads the xattrs of a file in the workspace, without following a symlink
+func workspaceXattrs(fp string) (map[string]string, error) {
+	names, err := xattr.LList(fp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list xattrs for %s: %v", fp, err)
+	}
+	xattrs := make(map[string]string, len(names))
+	for _, name := range names {
+		val, err := xattr.LGet(fp, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get xattr %s for %s: %v", name, fp, err)
+		}
+		xattrs[name] = string(val)
+	}
+	return xattrs, nil
+}
+
+// moveStagedXattrs moves the staged xattrs of oldpath, and everything under it, to newpath,
+// or drops them if newpath is ""
+func (fs *FileSystem) moveStagedXattrs(oldpath, newpath string) {
+	oldpath = path.Join("/", oldpath)
+	for k, v := range fs.stagedXattrs {
+		if k != oldpath && !strings.HasPrefix(k, oldpath+"/") {
+			continue
+		}
+		delete(fs.stagedXattrs, k)
+		if newpath != "" {
+			fs.stagedXattrs[path.Join("/", newpath, strings.TrimPrefix(k, oldpath))] = v
+		}
+	}
+}
+
+// Remove removes a file or empty directory from the workspace. Once the filesystem is finalized, it is read-only
+// and Remove returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	if err := workspace.Remove(fs.workspace, p); err != nil {
+		return err
+	}
+	fs.moveStagedXattrs(p, "")
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it, from the workspace. It returns nil if the path
+// does not exist. Once the filesystem is finalized, it is read-only and RemoveAll returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	if err := workspace.RemoveAll(fs.workspace, p); err != nil {
+		return err
+	}
+	fs.moveStagedXattrs(p, "")
+	return nil
+}
+
+// Rename renames or moves a file or directory in the workspace. Once the filesystem is finalized, it is read-only
+// and Rename returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if err := workspace.Rename(fs.workspace, oldpath, newpath); err != nil {
+		return err
+	}
+	fs.moveStagedXattrs(newpath, "")
+	fs.moveStagedXattrs(oldpath, newpath)
+	return nil
+}
+
+// Truncate changes the size of a file in the workspace. Once the filesystem is finalized, it is read-only
+// and Truncate returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return workspace.Truncate(fs.workspace, p, size)
+}
+
+// lstat finds the directory entry for p in a finalized filesystem
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	// if the dir == filename, then it is just /
+	if dir == filename {
+		return fs.hydrate("/", fs.rootDir)
+	}
+	entries, err := fs.readDirectory(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory entries for %s: %v", dir, err)
+	}
+	for _, e := range entries {
+		if e.Name() == filename {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+}
+
+// readDirectory returns the entries of the directory at p, without . and ..
+func (fs *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
+	in := fs.rootDir
+	for _, part := range splitPath(p) {
+		dirents, err := fs.readDirents(in)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory at path %s: %v", p, err)
+		}
+		var found *dirent
+		for _, d := range dirents {
+			if d.name == part {
+				found = d
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("could not find path %s: %w", p, os.ErrNotExist)
+		}
+		if in, err = fs.readInode(found.nid); err != nil {
+			return nil, fmt.Errorf("error finding inode for %s: %v", p, err)
+		}
+	}
+	if !in.isDir() {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	dirents, err := fs.readDirents(in)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory at path %s: %v", p, err)
+	}
+	entries := make([]*directoryEntry, 0, len(dirents))
+	for _, d := range dirents {
+		if d.name == "." || d.name == ".." {
+			continue
+		}
+		child, err := fs.readInode(d.nid)
+		if err != nil {
+			return nil, fmt.Errorf("error finding inode for %s: %v", d.name, err)
+		}
+		e, err := fs.hydrate(d.name, child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// readDirents reads all of the entries of a directory inode
+func (fs *FileSystem) readDirents(in *inode) ([]*dirent, error) {
+	if !in.isDir() {
+		return nil, fmt.Errorf("inode %d is not a directory", in.nid)
+	}
+	b, err := fs.readAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var dirents []*dirent
+	blocksize := int(fs.superblock.blocksize())
+	for pos := 0; pos < len(b); pos += blocksize {
+		end := pos + blocksize
+		if end > len(b) {
+			end = len(b)
+		}
+		entries, err := parseDirectoryBlock(b[pos:end])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing directory block at %d: %v", pos, err)
+		}
+		dirents = append(dirents, entries...)
+	}
+	return dirents, nil
+}
+
+// hydrate creates the directory entry for an inode, with its xattrs
+func (fs *FileSystem) hydrate(name string, in *inode) (*directoryEntry, error) {
+	xattrs, err := fs.readXattrs(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading xattrs for %s: %v", name, err)
+	}
+	e := &directoryEntry{
+		name:  name,
+		inode: in,
+		sys: FileStat{
+			nid:    in.nid,
+			links:  in.nlink,
+			uid:    in.uid,
+			gid:    in.gid,
+			xattrs: xattrs,
+		},
+	}
+	if in.isDevice() {
+		e.sys.rdev = in.u
+	}
+	return e, nil
+}
+
+// readlink returns the target of a symlink inode
+func (fs *FileSystem) readlink(in *inode) (string, error) {
+	b, err := fs.readAll(in)
+	if err != nil {
+		return "", fmt.Errorf("could not read symlink target: %v", err)
+	}
+	return string(b), nil
+}
+
+// readAll reads all of the data of an inode
+func (fs *FileSystem) readAll(in *inode) ([]byte, error) {
+	fl, err := fs.newFile(in)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, in.size)
+	if _, err := io.ReadFull(fl, b); err != nil && !(err == io.EOF && in.size == 0) {
+		return nil, fmt.Errorf("could not read inode %d: %v", in.nid, err)
+	}
+	return b, nil
+}
+
+// readXattrs reads the xattrs of an inode, those inline after it and those shared with others
+func (fs *FileSystem) readXattrs(in *inode) (map[string]string, error) {
+	xattrs := map[string]string{}
+	if in.xattrCount == 0 {
+		return xattrs, nil
+	}
+	b, err := fs.readBytes(fs.inodeLocation(in.nid)+in.inodeSize(), int(in.xattrSize()))
+	if err != nil {
+		return nil, err
+	}
+	shared, err := parseXattrBody(b, xattrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range shared {
+		pos := int64(fs.superblock.xattrBlockAddr)*fs.superblock.blocksize() + int64(id)*4
+		header, err := fs.readBytes(pos, xattrEntrySize)
+		if err != nil {
+			return nil, fmt.Errorf("could not read shared xattr %d: %v", id, err)
+		}
+		b, err := fs.readBytes(pos, xattrEntrySize+int(header[0])+int(binary.LittleEndian.Uint16(header[2:4])))
+		if err != nil {
+			return nil, fmt.Errorf("could not read shared xattr %d: %v", id, err)
+		}
+		name, value, _, err := parseXattrEntry(b)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing shared xattr %d: %v", id, err)
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+// inodeLocation is where on the filesystem the inode with the given nid is
+func (fs *FileSystem) inodeLocation(nid uint64) int64 {
+	return int64(fs.superblock.metaBlockAddr)*fs.superblock.blocksize() + int64(nid<<inodeSlotBits)
+}
+
+// readInode reads the inode with the given nid
+func (fs *FileSystem) readInode(nid uint64) (*inode, error) {
+	pos := fs.inodeLocation(nid)
+	b, err := fs.readBytes(pos, inodeCompactSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not read inode %d: %v", nid, err)
+	}
+	if n := inodeHeaderSize(b); n > len(b) {
+		if b, err = fs.readBytes(pos, n); err != nil {
+			return nil, fmt.Errorf("could not read inode %d: %v", nid, err)
+		}
+	}
+	return parseInode(b, nid, fs.superblock.buildTime)
+}
+
+// readBytes reads n bytes from pos on the filesystem
+func (fs *FileSystem) readBytes(pos int64, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := fs.readFull(b, pos); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readFull reads all of b from pos on the filesystem
+func (fs *FileSystem) readFull(b []byte, pos int64) (int, error) {
+	if fs.size > 0 && pos+int64(len(b)) > fs.size {
+		return 0, fmt.Errorf("cannot read %d bytes at %d, past the end of the filesystem at %d", len(b), pos, fs.size)
+	}
+	n, err := fs.file.ReadAt(b, fs.start+pos)
+	if err != nil && !(err == io.EOF && n == len(b)) {
+		return n, fmt.Errorf("error reading %d bytes at %d: %v", len(b), pos, err)
+	}
+	return n, nil
+}
+
+func validateBlocksize(blocksize int64) error {
+	switch {
+	case blocksize < minBlocksize:
+		return fmt.Errorf("blocksize %d too small, must be at least %d", blocksize, minBlocksize)
+	case blocksize > maxBlocksize:
+		return fmt.Errorf("blocksize %d too large, must be no more than %d", blocksize, maxBlocksize)
+	case blocksize&(blocksize-1) != 0:
+		return fmt.Errorf("blocksize %d is not a power of 2", blocksize)
+	}
+	return nil
+}
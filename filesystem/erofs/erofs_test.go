@@ -0,0 +1,430 @@
+package erofs_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/erofs"
+)
+
+// tree is what the test images are made from
+type tree struct {
+	files    map[string][]byte
+	links    map[string]string
+	manyDirs int
+}
+
+func newTree(t *testing.T) *tree {
+	t.Helper()
+	random := make([]byte, 100*1024+17)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("unable to generate random data: %v", err)
+	}
+	// mixed has runs that compress and runs that do not, so it has both kinds of extent
+	var mixed []byte
+	for i := 0; i < 6; i++ {
+		mixed = append(mixed, random[i*8192:(i+1)*8192]...)
+		mixed = append(mixed, bytes.Repeat([]byte{byte(i)}, 20000)...)
+	}
+	tr := &tree{
+		files: map[string][]byte{
+			"/hello.txt":          []byte("hello world\n"),
+			"/random.bin":         random,
+			"/mixed.bin":          mixed,
+			"/text.txt":           bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 7000),
+			"/empty":              nil,
+			"/block":              bytes.Repeat([]byte{0xa5}, 4096),
+			"/dir/sub/nested.txt": bytes.Repeat([]byte("nested "), 1000),
+		},
+		links: map[string]string{
+			"/link":     "hello.txt",
+			"/dirlink":  "dir/sub",
+			"/abslink":  "/dir/sub/nested.txt",
+			"/longlink": "/" + strings.Repeat("a", 200) + "/" + strings.Repeat("b", 200),
+		},
+		manyDirs: 400,
+	}
+	for i := 0; i < tr.manyDirs; i++ {
+		tr.files[fmt.Sprintf("/many/file_with_a_long_name_%04d", i)] = []byte(fmt.Sprintf("%d\n", i))
+	}
+	return tr
+}
+
+// create builds a finalized filesystem from the tree in a new temporary file
+func (tr *tree) create(t *testing.T, blocksize int64, options erofs.FinalizeOptions) (*erofs.FileSystem, *os.File) {
+	t.Helper()
+	f, err := os.CreateTemp("", "erofs_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	fs, err := erofs.Create(f, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("Failed to erofs.Create: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(fs.Workspace()) })
+	for p, content := range tr.files {
+		if err := fs.Mkdir(p[:strings.LastIndex(p, "/")]); err != nil {
+			t.Fatalf("Failed to erofs.Mkdir for %s: %v", p, err)
+		}
+		fl, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to erofs.OpenFile(%s): %v", p, err)
+		}
+		if _, err := fl.Write(content); err != nil {
+			t.Fatalf("error writing to %s: %v", p, err)
+		}
+		fl.Close()
+	}
+	for p, target := range tr.links {
+		if err := fs.Symlink(target, p); err != nil {
+			t.Fatalf("Failed to erofs.Symlink(%s): %v", p, err)
+		}
+	}
+	if err := fs.Setxattr("/hello.txt", "user.comment", []byte("greetings")); err != nil {
+		t.Fatalf("Failed to erofs.Setxattr: %v", err)
+	}
+	if err := fs.Setxattr("/dir", "security.selinux", []byte("system_u:object_r:etc_t:s0\x00")); err != nil {
+		t.Fatalf("Failed to erofs.Setxattr: %v", err)
+	}
+	if err := fs.SetLabel("go-diskfs"); err != nil {
+		t.Fatalf("Failed to erofs.SetLabel: %v", err)
+	}
+	if err := fs.Finalize(options); err != nil {
+		t.Fatalf("Failed to erofs.Finalize: %v", err)
+	}
+	return fs, f
+}
+
+// check reads everything in the tree back from fs
+func (tr *tree) check(t *testing.T, fs filesystem.FileSystem) {
+	t.Helper()
+	for p, expected := range tr.files {
+		fl, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		b, err := io.ReadAll(fl)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("%s: read %d bytes that do not match the %d written", p, len(b), len(expected))
+		}
+		fi, err := fs.Stat(p)
+		if err != nil {
+			t.Fatalf("error stating %s: %v", p, err)
+		}
+		if fi.Size() != int64(len(expected)) || !fi.Mode().IsRegular() {
+			t.Errorf("%s: mismatched size %d or mode %v", p, fi.Size(), fi.Mode())
+		}
+	}
+	sfs, ok := fs.(filesystem.SymlinkFileSystem)
+	if !ok {
+		t.Fatalf("erofs does not implement filesystem.SymlinkFileSystem")
+	}
+	for p, expected := range tr.links {
+		target, err := sfs.Readlink(p)
+		if err != nil {
+			t.Fatalf("error reading link %s: %v", p, err)
+		}
+		if target != expected {
+			t.Errorf("%s: link to %s instead of %s", p, target, expected)
+		}
+		fi, err := sfs.Lstat(p)
+		if err != nil {
+			t.Fatalf("error stating link %s: %v", p, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: mode %v is not a symlink", p, fi.Mode())
+		}
+	}
+
+	entries, err := fs.ReadDir("/many")
+	if err != nil {
+		t.Fatalf("error reading /many: %v", err)
+	}
+	if len(entries) != tr.manyDirs {
+		t.Errorf("read %d entries in /many instead of %d", len(entries), tr.manyDirs)
+	}
+	root, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading /: %v", err)
+	}
+	names := make([]string, 0, len(root))
+	for _, e := range root {
+		names = append(names, e.Name())
+	}
+	expected := []string{"abslink", "block", "dir", "dirlink", "empty", "hello.txt", "link", "longlink", "many", "mixed.bin", "random.bin", "text.txt"}
+	if !sort.StringsAreSorted(names) || strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("root has %v instead of %v", names, expected)
+	}
+	if fs.Label() != "go-diskfs" {
+		t.Errorf("label %q instead of go-diskfs", fs.Label())
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name      string
+		blocksize int64
+		options   erofs.FinalizeOptions
+	}{
+		{"uncompressed", 4096, erofs.FinalizeOptions{}},
+		{"lz4", 4096, erofs.FinalizeOptions{Compression: &erofs.CompressorLz4{}}},
+		{"lzma", 4096, erofs.FinalizeOptions{Compression: &erofs.CompressorLzma{}}},
+		{"small blocks", 512, erofs.FinalizeOptions{Compression: &erofs.CompressorLz4{}}},
+		{"big blocks", 16384, erofs.FinalizeOptions{Compression: &erofs.CompressorLzma{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, f := tr.create(t, tt.blocksize, tt.options)
+			if fs.Workspace() != "" {
+				t.Errorf("workspace %s remains after Finalize", fs.Workspace())
+			}
+			// what was finalized can be read at once, and again from the file
+			tr.check(t, fs)
+			read, err := erofs.Read(f, 0, 0, 0)
+			if err != nil {
+				t.Fatalf("error reading finalized filesystem: %v", err)
+			}
+			tr.check(t, read)
+		})
+	}
+	t.Run("compression saves space", func(t *testing.T) {
+		_, plain := tr.create(t, 4096, erofs.FinalizeOptions{})
+		_, compressed := tr.create(t, 4096, erofs.FinalizeOptions{Compression: &erofs.CompressorLz4{}})
+		plainInfo, err := plain.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressedInfo, err := compressed.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressedInfo.Size() >= plainInfo.Size() {
+			t.Errorf("compressed image of %d bytes is no smaller than uncompressed of %d", compressedInfo.Size(), plainInfo.Size())
+		}
+	})
+	t.Run("too small", func(t *testing.T) {
+		f, err := os.CreateTemp("", "erofs_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer os.Remove(f.Name())
+		fs, err := erofs.Create(f, 8192, 0, 4096)
+		if err != nil {
+			t.Fatalf("Failed to erofs.Create: %v", err)
+		}
+		fl, err := fs.OpenFile("/big", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to erofs.OpenFile: %v", err)
+		}
+		if _, err := fl.Write(make([]byte, 20000)); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		if err := fs.Finalize(erofs.FinalizeOptions{}); err == nil {
+			t.Errorf("finalized 20000 byte file into 8192 bytes without error")
+		}
+	})
+}
+
+func TestXattrs(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name    string
+		options erofs.FinalizeOptions
+		xattrs  []string
+	}{
+		{"with xattrs", erofs.FinalizeOptions{Xattrs: true}, []string{"user.comment"}},
+		{"without xattrs", erofs.FinalizeOptions{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, f := tr.create(t, 4096, tt.options)
+			fs, err := erofs.Read(f, 0, 0, 0)
+			if err != nil {
+				t.Fatalf("error reading finalized filesystem: %v", err)
+			}
+			names, err := fs.Listxattr("/hello.txt")
+			if err != nil {
+				t.Fatalf("error listing xattrs: %v", err)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.xattrs, ",") {
+				t.Errorf("xattrs %v instead of %v", names, tt.xattrs)
+			}
+			value, err := fs.Getxattr("/hello.txt", "user.comment")
+			switch {
+			case len(tt.xattrs) == 0 && !errors.Is(err, filesystem.ErrXattrNotExist):
+				t.Errorf("mismatched error %v instead of filesystem.ErrXattrNotExist", err)
+			case len(tt.xattrs) > 0 && string(value) != "greetings":
+				t.Errorf("xattr %q instead of greetings, error %v", value, err)
+			}
+			fi, err := fs.Stat("/dir")
+			if err != nil {
+				t.Fatalf("error stating /dir: %v", err)
+			}
+			stat := fi.Sys().(erofs.FileStat)
+			if _, ok := stat.Xattrs()["security.selinux"]; ok != (len(tt.xattrs) > 0) {
+				t.Errorf("mismatched xattrs on /dir %v", stat.Xattrs())
+			}
+		})
+	}
+	t.Run("unsupported name", func(t *testing.T) {
+		f, err := os.CreateTemp("", "erofs_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer os.Remove(f.Name())
+		fs, err := erofs.Create(f, 0, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to erofs.Create: %v", err)
+		}
+		if err := fs.Setxattr("/", "system.unknown", []byte("x")); err == nil {
+			t.Errorf("set unsupported xattr without error")
+		}
+	})
+}
+
+func TestStat(t *testing.T) {
+	tr := newTree(t)
+	uid, gid := uint32(1234), uint32(5678)
+	fs, _ := tr.create(t, 4096, erofs.FinalizeOptions{FileUID: &uid, FileGID: &gid})
+	tests := []struct {
+		path  string
+		isDir bool
+		size  int64
+		links uint32
+	}{
+		{"/", true, 0, 4},
+		{"/dir", true, 0, 3},
+		{"/link", false, 12, 1},
+		{"/dirlink", true, 0, 2},
+		{"/abslink", false, 7000, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			fi, err := fs.Stat(tt.path)
+			if err != nil {
+				t.Fatalf("error stating %s: %v", tt.path, err)
+			}
+			if fi.IsDir() != tt.isDir || (!tt.isDir && fi.Size() != tt.size) {
+				t.Errorf("mismatched dir %v or size %d", fi.IsDir(), fi.Size())
+			}
+			stat := fi.Sys().(erofs.FileStat)
+			if stat.Nlink() != tt.links || stat.UID() != uid || stat.GID() != gid {
+				t.Errorf("mismatched links %d, uid %d or gid %d", stat.Nlink(), stat.UID(), stat.GID())
+			}
+		})
+	}
+	if _, err := fs.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("mismatched error %v instead of os.ErrNotExist", err)
+	}
+	if _, err := fs.ReadDir("/hello.txt"); err == nil {
+		t.Errorf("read directory of a file without error")
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tr := newTree(t)
+	for _, c := range []erofs.Compressor{nil, &erofs.CompressorLz4{}} {
+		fs, _ := tr.create(t, 4096, erofs.FinalizeOptions{Compression: c})
+		expected := tr.files["/mixed.bin"]
+		fl, err := fs.OpenFile("/mixed.bin", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening: %v", err)
+		}
+		for _, off := range []int64{70000, 5, 100000, 8190} {
+			if _, err := fl.Seek(off, io.SeekStart); err != nil {
+				t.Fatalf("error seeking to %d: %v", off, err)
+			}
+			b := make([]byte, 10000)
+			n, err := fl.Read(b)
+			if err != nil && err != io.EOF {
+				t.Fatalf("error reading at %d: %v", off, err)
+			}
+			if !bytes.Equal(b[:n], expected[off:off+int64(n)]) || n == 0 {
+				t.Errorf("mismatched %d bytes at %d", n, off)
+			}
+		}
+		if pos, err := fl.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(expected))-10 {
+			t.Errorf("seek from end to %d, error %v", pos, err)
+		}
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	tr := newTree(t)
+	fs, _ := tr.create(t, 4096, erofs.FinalizeOptions{})
+	checks := map[string]error{
+		"Mkdir":       fs.Mkdir("/new"),
+		"Remove":      fs.Remove("/hello.txt"),
+		"RemoveAll":   fs.RemoveAll("/dir"),
+		"Rename":      fs.Rename("/hello.txt", "/bye.txt"),
+		"Truncate":    fs.Truncate("/hello.txt", 0),
+		"Symlink":     fs.Symlink("hello.txt", "/new"),
+		"Setxattr":    fs.Setxattr("/hello.txt", "user.a", nil),
+		"Removexattr": fs.Removexattr("/hello.txt", "user.comment"),
+		"SetLabel":    fs.SetLabel("new"),
+		"Finalize":    fs.Finalize(erofs.FinalizeOptions{}),
+	}
+	_, err := fs.OpenFile("/hello.txt", os.O_RDWR)
+	checks["OpenFile"] = err
+	fl, err := fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	_, checks["Write"] = fl.Write([]byte("x"))
+	for name, err := range checks {
+		if err == nil {
+			t.Errorf("%s: no error on a finalized filesystem", name)
+			continue
+		}
+		if name != "Finalize" && !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("%s: error %v is not filesystem.ErrReadonlyFilesystem", name, err)
+		}
+	}
+}
+
+func TestStatfs(t *testing.T) {
+	tr := newTree(t)
+	fs, f := tr.create(t, 4096, erofs.FinalizeOptions{})
+	st, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("error getting Statfs: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := uint64(len(tr.files) + len(tr.links) + 4)
+	if st.BlockSize != 4096 || st.TotalBytes != fi.Size() || st.UsedBytes != st.TotalBytes || st.Files != files {
+		t.Errorf("mismatched Statfs %+v for image of %d bytes and %d files", st, fi.Size(), files)
+	}
+}
+
+func TestReadNotEROFS(t *testing.T) {
+	f, err := os.CreateTemp("", "erofs_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(make([]byte, 8192)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := erofs.Read(f, 0, 0, 0); err == nil {
+		t.Errorf("read an empty image as erofs without error")
+	}
+}
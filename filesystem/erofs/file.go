@@ -0,0 +1,190 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in an erofs filesystem
+//
+//	it is NOT used when working in a workspace, where we just use the underlying OS
+type File struct {
+	inode      *inode
+	offset     int64
+	filesystem *FileSystem
+	// extents of a compressed file, and the last one decompressed with its data
+	extents     []*extent
+	extent      *extent
+	extentBytes []byte
+}
+
+// newFile creates a File to read the data of an inode
+func (fs *FileSystem) newFile(in *inode) (*File, error) {
+	fl := &File{
+		inode:      in,
+		filesystem: fs,
+	}
+	if in.layout.compressed() {
+		extents, err := fs.compressedExtents(in)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the extents of compressed inode %d: %v", in.nid, err)
+		}
+		fl.extents = extents
+	}
+	return fl, nil
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	size := int64(fl.inode.size)
+	if fl.offset >= size {
+		return 0, io.EOF
+	}
+	if remaining := size - fl.offset; remaining < int64(len(b)) {
+		b = b[:remaining]
+	}
+	read := 0
+	for read < len(b) {
+		n, err := fl.readAt(b[read:], fl.offset+int64(read))
+		if err != nil {
+			return read, err
+		}
+		read += n
+	}
+	fl.offset += int64(read)
+	var retErr error
+	if fl.offset >= size {
+		retErr = io.EOF
+	}
+	return read, retErr
+}
+
+// readAt reads from off in the file into b, as far as the data is in one place, and returns how much it read
+func (fl *File) readAt(b []byte, off int64) (int, error) {
+	fs := fl.filesystem
+	in := fl.inode
+	blocksize := fs.superblock.blocksize()
+	switch in.layout {
+	case layoutFlatPlain:
+		return fs.readFull(b, int64(in.u)*blocksize+off)
+	case layoutFlatInline:
+		full := int64(in.size) / blocksize * blocksize
+		if off < full {
+			if full-off < int64(len(b)) {
+				b = b[:full-off]
+			}
+			return fs.readFull(b, int64(in.u)*blocksize+off)
+		}
+		return fs.readFull(b, fs.inodeLocation(in.nid)+in.inodeSize()+in.xattrSize()+off-full)
+	case layoutChunkBased:
+		return fl.readChunk(b, off)
+	case layoutCompressedFull, layoutCompressedCompact:
+		return fl.readCompressed(b, off)
+	}
+	return 0, fmt.Errorf("unknown data layout %d", in.layout)
+}
+
+// readChunk reads from the chunk that holds off. The chunks are found from an array after the inode, either
+// of block addresses or of indexes that also say which device the chunk is on.
+func (fl *File) readChunk(b []byte, off int64) (int, error) {
+	fs := fl.filesystem
+	in := fl.inode
+	format := uint16(in.u)
+	chunkBits := uint(fs.superblock.blockBits) + uint(format&chunkFormatBlkbitsMask)
+	chunkSize := int64(1) << chunkBits
+	chunk := off >> chunkBits
+	within := off & (chunkSize - 1)
+	if chunkSize-within < int64(len(b)) {
+		b = b[:chunkSize-within]
+	}
+	unit := int64(4)
+	if format&chunkFormatIndexes != 0 {
+		unit = 8
+	}
+	pos := fs.inodeLocation(in.nid) + in.inodeSize() + in.xattrSize()
+	pos = (pos+unit-1)/unit*unit + chunk*unit
+	entry, err := fs.readBytes(pos, int(unit))
+	if err != nil {
+		return 0, fmt.Errorf("could not read chunk %d: %v", chunk, err)
+	}
+	addr := binary.LittleEndian.Uint32(entry[0:4])
+	if unit == 8 {
+		if device := binary.LittleEndian.Uint16(entry[2:4]); device != 0 {
+			return 0, fmt.Errorf("chunk %d is on unsupported extra device %d", chunk, device)
+		}
+		addr = binary.LittleEndian.Uint32(entry[4:8])
+	}
+	// a hole reads as zeros
+	if addr == nullAddr {
+		for i := range b {
+			b[i] = 0
+		}
+		return len(b), nil
+	}
+	return fs.readFull(b, int64(addr)*fs.superblock.blocksize()+within)
+}
+
+// readCompressed reads from the extent that holds off, decompressing it unless it is the one last read
+func (fl *File) readCompressed(b []byte, off int64) (int, error) {
+	i := sort.Search(len(fl.extents), func(i int) bool {
+		return fl.extents[i].offset+fl.extents[i].length > off
+	})
+	if i == len(fl.extents) || fl.extents[i].offset > off {
+		return 0, fmt.Errorf("no extent holds offset %d", off)
+	}
+	e := fl.extents[i]
+	if fl.extent != e {
+		data, err := fl.filesystem.readExtent(e)
+		if err != nil {
+			return 0, err
+		}
+		fl.extent, fl.extentBytes = e, data
+	}
+	return copy(b, fl.extentBytes[off-e.offset:]), nil
+}
+
+// Write writes len(b) bytes to the File.
+//
+//	you cannot write to a finished erofs, so this returns an error
+func (fl *File) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("cannot write to a read-only erofs filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = int64(fl.inode.size) + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
@@ -0,0 +1,425 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxExtentSize is the most data of a compressed file that goes in a single extent, and so in a single
+// compressed block
+const maxExtentSize = 64 * KB
+
+// FinalizeOptions options to pass to finalize
+type FinalizeOptions struct {
+	// Compression which compressor to use for the data of regular files, CompressorLz4 or CompressorLzma.
+	// Defaults to nil, i.e. no compression
+	Compression Compressor
+	// Xattrs whether or not to store extended attributes. Defaults to false
+	Xattrs bool
+	// FileUID set all files to be owned by the UID provided, default is to leave as in filesystem
+	FileUID *uint32
+	// FileGID set all files to be owned by the GID provided, default is to leave as in filesystem
+	FileGID *uint32
+}
+
+// Finalize finalize a read-only filesystem by writing it out to a read-only format
+func (fs *FileSystem) Finalize(options FinalizeOptions) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot finalize an already finalized filesystem")
+	}
+
+	/*
+		The data is kept apart from the metadata, so that the data of regular files can be written, and
+		compressed, before the nids of anything are known. In order:
+		- superblock at byte 1024, with the configuration of the compression, if any, after it
+		- data of regular files and symlinks, from the next block; the last partial block of each goes
+		  inline after its inode, if there is room there
+		- metadata: each inode, with its xattrs, then its inline data or the indexes of its compressed data
+		- full blocks of directories, which cannot be written until the nids of what is in them are known
+
+		Every inode is extended, to keep its modification time, and the root inode is first, at nid 0.
+	*/
+
+	blocksize := fs.blocksize
+	fileList, err := walkTree(fs.workspace)
+	if err != nil {
+		return fmt.Errorf("error walking tree: %v", err)
+	}
+	for _, e := range fileList {
+		// xattrs set with Setxattr replace those read from the workspace
+		if m, ok := fs.stagedXattrs[path.Join("/", e.path)]; ok {
+			e.xattrs = m
+		}
+		if !options.Xattrs {
+			e.xattrs = nil
+		}
+		if options.FileUID != nil {
+			e.uid = *options.FileUID
+		}
+		if options.FileGID != nil {
+			e.gid = *options.FileGID
+		}
+	}
+
+	sb := &superblock{
+		compatFeatures: featureCompatSbChecksum,
+		blockBits:      uint8(bits.TrailingZeros64(uint64(blocksize))),
+		buildTime:      time.Now(),
+		uuid:           uuid.New(),
+		volumeName:     fs.label,
+		inodes:         uint64(len(fileList)),
+	}
+	var configs []byte
+	if c := options.Compression; c != nil {
+		sb.incompatFeatures |= featureIncompatZeroPadding
+		if config := c.configBytes(); config != nil {
+			sb.incompatFeatures |= featureIncompatComprCfgs
+			sb.availableComprAlgs = 1 << c.flavour()
+			configs = make([]byte, 2+len(config))
+			binary.LittleEndian.PutUint16(configs[0:2], uint16(len(config)))
+			copy(configs[2:], config)
+		}
+	}
+
+	// write the data, from the first block after the superblock and configuration
+	block := uint32((superblockOffset + superblockSize + int64(len(configs)) + blocksize - 1) / blocksize)
+	for i, e := range fileList {
+		in := &inode{
+			extended: true,
+			mode:     unixMode(e.mode),
+			nlink:    1,
+			ino:      uint32(i + 1),
+			uid:      e.uid,
+			gid:      e.gid,
+			modTime:  e.modTime,
+		}
+		e.inode = in
+		if e.xattrBytes, in.xattrCount, err = xattrBodyBytes(e.xattrs); err != nil {
+			return fmt.Errorf("invalid xattrs for %s: %v", e.path, err)
+		}
+		fp := filepath.Join(fs.workspace, e.path)
+		switch {
+		case e.mode.IsDir():
+			in.nlink = 2
+			for _, c := range e.children {
+				if c.mode.IsDir() {
+					in.nlink++
+				}
+			}
+		case e.mode.IsRegular():
+			if block, err = fs.writeRegularFile(e, fp, block, options.Compression); err != nil {
+				return fmt.Errorf("error writing data for %s: %v", e.path, err)
+			}
+		case e.mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(fp)
+			if err != nil {
+				return fmt.Errorf("unable to read target for symlink at %s: %v", e.path, err)
+			}
+			if block, err = fs.writeData(e, strings.NewReader(target), int64(len(target)), block); err != nil {
+				return fmt.Errorf("error writing target for symlink %s: %v", e.path, err)
+			}
+		case e.mode&os.ModeDevice != 0:
+			major, minor, err := getDeviceNumbers(fp)
+			if err != nil {
+				return fmt.Errorf("unable to read major/minor device numbers for device at %s: %v", e.path, err)
+			}
+			in.u = encodeDev(major, minor)
+		}
+	}
+
+	// work out how big each directory is, which does not depend on the nids in it, so whether it fits inline
+	for _, e := range fileList {
+		if !e.mode.IsDir() {
+			continue
+		}
+		b, err := directoryBytes(e.dirents(), int(blocksize))
+		if err != nil {
+			return fmt.Errorf("error creating directory %s: %v", e.path, err)
+		}
+		e.inode.size = uint64(len(b))
+		e.inode.layout = layoutFlatPlain
+		if tail := int64(len(b)) % blocksize; e.inlineFits(tail, blocksize) {
+			e.inode.layout = layoutFlatInline
+			e.data = b[int64(len(b))-tail:]
+		}
+	}
+
+	// lay out the inodes in the metadata area, never letting one and its inline data span two blocks
+	var pos int64
+	for _, e := range fileList {
+		keep := e.metadataHead()
+		if keep <= blocksize && pos%blocksize+keep > blocksize {
+			pos = (pos + blocksize - 1) / blocksize * blocksize
+		}
+		e.nid = uint64(pos >> inodeSlotBits)
+		e.inode.nid = e.nid
+		pos += e.metadataSize()
+		pos = (pos + 1<<inodeSlotBits - 1) &^ (1<<inodeSlotBits - 1)
+	}
+	sb.metaBlockAddr = block
+	meta := make([]byte, (pos+blocksize-1)/blocksize*blocksize)
+	block += uint32(int64(len(meta)) / blocksize)
+
+	// now that the nids are known, write the directories, with their full blocks after the metadata
+	for _, e := range fileList {
+		if !e.mode.IsDir() {
+			continue
+		}
+		b, err := directoryBytes(e.dirents(), int(blocksize))
+		if err != nil {
+			return fmt.Errorf("error creating directory %s: %v", e.path, err)
+		}
+		full := int64(len(b))
+		if e.inode.layout == layoutFlatInline {
+			full = full / blocksize * blocksize
+			e.data = b[full:]
+		}
+		e.inode.u = block
+		if block, err = fs.writeBlocks(b[:full], block); err != nil {
+			return fmt.Errorf("error writing directory %s: %v", e.path, err)
+		}
+	}
+
+	for _, e := range fileList {
+		copy(meta[int64(e.nid)<<inodeSlotBits:], e.metadataBytes())
+	}
+	if _, err := fs.file.WriteAt(meta, fs.start+int64(sb.metaBlockAddr)*blocksize); err != nil {
+		return fmt.Errorf("failed to write inodes: %v", err)
+	}
+
+	sb.blocks = block
+	sb.rootNid = uint16(fileList[0].nid)
+	if fs.size > 0 && int64(sb.blocks)*blocksize > fs.size {
+		return fmt.Errorf("filesystem of %d blocks of %d bytes is larger than the %d bytes available", sb.blocks, blocksize, fs.size)
+	}
+
+	// write the superblock, with the checksum over the rest of its block
+	b := make([]byte, checksumSize(blocksize))
+	if n := superblockSize + int64(len(configs)); n > int64(len(b)) {
+		b = make([]byte, n)
+	}
+	copy(b, sb.toBytes())
+	copy(b[superblockSize:], configs)
+	sb.checksum = superblockChecksum(b[:checksumSize(blocksize)])
+	binary.LittleEndian.PutUint32(b[4:8], sb.checksum)
+	if _, err := fs.file.WriteAt(b, fs.start+superblockOffset); err != nil {
+		return fmt.Errorf("failed to write superblock: %v", err)
+	}
+
+	// finish by setting as finalized, and ready to read
+	fs.superblock = sb
+	fs.workspace = ""
+	if err := fs.loadCompressors(); err != nil {
+		return fmt.Errorf("error loading compression: %v", err)
+	}
+	if fs.rootDir, err = fs.readInode(uint64(sb.rootNid)); err != nil {
+		return fmt.Errorf("unable to read root inode: %v", err)
+	}
+	return nil
+}
+
+// writeRegularFile writes the data of a regular file from block, compressing it with c if it is set and
+// that saves space, and returns the block after it
+func (fs *FileSystem) writeRegularFile(e *finalizeFileInfo, fp string, block uint32, c Compressor) (uint32, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return block, err
+	}
+	defer f.Close()
+	if c != nil && e.size > fs.blocksize {
+		next, ok, err := fs.writeCompressedData(e, f, block, c)
+		if err != nil || ok {
+			return next, err
+		}
+	}
+	return fs.writeData(e, f, e.size, block)
+}
+
+// writeData writes size bytes of data for a file from block, keeping the last partial block to go inline after
+// its inode if there is room for it there, and returns the block after it
+func (fs *FileSystem) writeData(e *finalizeFileInfo, r io.ReaderAt, size int64, block uint32) (uint32, error) {
+	in := e.inode
+	in.size = uint64(size)
+	in.layout = layoutFlatPlain
+	full := size
+	if tail := size % fs.blocksize; e.inlineFits(tail, fs.blocksize) {
+		in.layout = layoutFlatInline
+		full -= tail
+		e.data = make([]byte, tail)
+		if _, err := r.ReadAt(e.data, full); err != nil && err != io.EOF {
+			return block, fmt.Errorf("could not read last %d bytes: %v", tail, err)
+		}
+	}
+	if size > 0 {
+		in.u = block
+	}
+	buf := make([]byte, fs.blocksize)
+	for off := int64(0); off < full; off += fs.blocksize {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return block, fmt.Errorf("could not read at %d: %v", off, err)
+		}
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		if block, err = fs.writeBlocks(buf, block); err != nil {
+			return block, err
+		}
+	}
+	return block, nil
+}
+
+// writeCompressedData writes the data of a regular file compressed from block, into extents of as many logical
+// clusters as compress into a single block, and any that do not compress as they are. It returns the block
+// after it, and false if compressing saved nothing, in which case the data should be written uncompressed to
+// the same blocks.
+func (fs *FileSystem) writeCompressedData(e *finalizeFileInfo, r io.ReaderAt, block uint32, c Compressor) (uint32, bool, error) {
+	blocksize := fs.blocksize
+	count := (e.size + blocksize - 1) / blocksize
+	maxClusters := maxExtentSize / blocksize
+	if maxClusters < 1 {
+		maxClusters = 1
+	}
+	start := block
+	indexes := make([]byte, 0, count*fullIndexSize)
+	buf := make([]byte, maxClusters*blocksize)
+	for lcn := int64(0); lcn < count; {
+		off := lcn * blocksize
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return block, false, fmt.Errorf("could not read at %d: %v", off, err)
+		}
+		if remaining := e.size - off; int64(n) > remaining {
+			n = int(remaining)
+		}
+		data := buf[:n]
+		extentData := func(clusters int64) []byte {
+			if end := clusters * blocksize; end < int64(len(data)) {
+				return data[:end]
+			}
+			return data
+		}
+
+		// find the most logical clusters that compress into a block
+		var (
+			clusters int64
+			out      []byte
+		)
+		lo, hi := int64(1), (int64(len(data))+blocksize-1)/blocksize
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			b, err := c.compress(extentData(mid), int(blocksize))
+			if err != nil {
+				return block, false, err
+			}
+			if b == nil {
+				hi = mid - 1
+				continue
+			}
+			clusters, out = mid, b
+			lo = mid + 1
+		}
+
+		head := &lclusterIndex{lclusterType: lclusterHead1, blockAddr: block}
+		physical := make([]byte, blocksize)
+		if out == nil {
+			// store it as it is, at the start of the block
+			clusters = 1
+			head.lclusterType = lclusterPlain
+			copy(physical, extentData(1))
+		} else {
+			// compressed data goes at the end of the block, with zeros in front of it
+			copy(physical[blocksize-int64(len(out)):], out)
+		}
+		indexes = append(indexes, head.fullIndexBytes(0, 0)...)
+		for i := int64(1); i < clusters; i++ {
+			nonHead := &lclusterIndex{lclusterType: lclusterNonHead}
+			indexes = append(indexes, nonHead.fullIndexBytes(uint16(i), uint16(clusters-i))...)
+		}
+		if block, err = fs.writeBlocks(physical, block); err != nil {
+			return block, false, err
+		}
+		lcn += clusters
+	}
+	if int64(block-start) >= count {
+		return start, false, nil
+	}
+
+	in := e.inode
+	in.layout = layoutCompressedFull
+	in.size = uint64(e.size)
+	in.u = block - start
+	h := &mapHeader{algorithms: uint8(c.flavour())}
+	e.data = append(h.toBytes(), make([]byte, fullIndexPadding)...)
+	e.data = append(e.data, indexes...)
+	return block, true, nil
+}
+
+// writeBlocks writes b from block, padded with zeros to whole blocks, and returns the block after it
+func (fs *FileSystem) writeBlocks(b []byte, block uint32) (uint32, error) {
+	if len(b) == 0 {
+		return block, nil
+	}
+	count := (int64(len(b)) + fs.blocksize - 1) / fs.blocksize
+	if pad := count*fs.blocksize - int64(len(b)); pad > 0 {
+		b = append(b[:len(b):len(b)], make([]byte, pad)...)
+	}
+	if _, err := fs.file.WriteAt(b, fs.start+int64(block)*fs.blocksize); err != nil {
+		return block, fmt.Errorf("could not write %d blocks at block %d: %v", count, block, err)
+	}
+	return block + uint32(count), nil
+}
+
+// walkTree walks the workspace, returning every file and directory in it, each directory before what is in it,
+// starting with the root
+func walkTree(workspace string) ([]*finalizeFileInfo, error) {
+	dirMap := make(map[string]*finalizeFileInfo)
+	fileList := make([]*finalizeFileInfo, 0)
+	err := filepath.Walk(workspace, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workspace, fp)
+		if err != nil {
+			return err
+		}
+		xattrs, err := workspaceXattrs(fp)
+		if err != nil {
+			return err
+		}
+		uid, gid := getFileProperties(fi)
+		entry := &finalizeFileInfo{
+			path:    filepath.ToSlash(rel),
+			name:    fi.Name(),
+			mode:    fi.Mode(),
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			xattrs:  xattrs,
+			uid:     uid,
+			gid:     gid,
+		}
+		if rel != "." {
+			parent := dirMap[filepath.Dir(rel)]
+			entry.parent = parent
+			parent.children = append(parent.children, entry)
+		}
+		if fi.IsDir() {
+			dirMap[rel] = entry
+		}
+		fileList = append(fileList, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileList, nil
+}
@@ -0,0 +1,99 @@
+package erofs
+
+import (
+	"os"
+	"time"
+)
+
+// finalizeFileInfo is a file info useful for finalization
+// fulfills os.FileInfo
+//
+//	Name() string       // base name of the file
+//	Size() int64        // length in bytes for regular files; system-dependent for others
+//	Mode() FileMode     // file mode bits
+//	ModTime() time.Time // modification time
+//	IsDir() bool        // abbreviation for Mode().IsDir()
+//	Sys() interface{}   // underlying data source (can return nil)
+type finalizeFileInfo struct {
+	path     string
+	name     string
+	size     int64
+	mode     os.FileMode
+	modTime  time.Time
+	uid      uint32
+	gid      uint32
+	xattrs   map[string]string
+	parent   *finalizeFileInfo
+	children []*finalizeFileInfo
+	nid      uint64
+	inode    *inode
+	// xattrBytes are the xattrs to follow the inode
+	xattrBytes []byte
+	// data follows the inode and its xattrs: the inline data, or the indexes of compressed data
+	data []byte
+}
+
+func (fi *finalizeFileInfo) Name() string {
+	return fi.name
+}
+func (fi *finalizeFileInfo) Size() int64 {
+	return fi.size
+}
+func (fi *finalizeFileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+func (fi *finalizeFileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+func (fi *finalizeFileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+func (fi *finalizeFileInfo) Sys() interface{} {
+	return nil
+}
+
+// inlineFits says whether the last partial block of data, of tail bytes, fits in the block after the inode
+// and its xattrs
+func (fi *finalizeFileInfo) inlineFits(tail, blocksize int64) bool {
+	return tail > 0 && inodeExtendedSize+int64(len(fi.xattrBytes))+tail <= blocksize
+}
+
+// metadataHead is how much of the metadata of the file must be in a single block: the inode, its xattrs and any
+// inline data, but not the indexes of compressed data
+func (fi *finalizeFileInfo) metadataHead() int64 {
+	if fi.inode.layout.compressed() {
+		return inodeExtendedSize + int64(len(fi.xattrBytes))
+	}
+	return inodeExtendedSize + int64(len(fi.xattrBytes)) + int64(len(fi.data))
+}
+
+// metadataSize is how many bytes the metadata of the file takes
+func (fi *finalizeFileInfo) metadataSize() int64 {
+	return int64(len(fi.metadataBytes()))
+}
+
+// metadataBytes returns the metadata of the file: the inode, its xattrs and then either its inline data or,
+// 8 byte aligned, the indexes of its compressed data
+func (fi *finalizeFileInfo) metadataBytes() []byte {
+	b := append(fi.inode.toBytes(), fi.xattrBytes...)
+	if fi.inode.layout.compressed() && len(b)%8 != 0 {
+		b = append(b, make([]byte, 8-len(b)%8)...)
+	}
+	return append(b, fi.data...)
+}
+
+// dirents returns the entries of a directory, including . and ..
+func (fi *finalizeFileInfo) dirents() []*dirent {
+	parent := fi.parent
+	if parent == nil {
+		parent = fi
+	}
+	entries := []*dirent{
+		{nid: fi.nid, fileType: fileTypeDir, name: "."},
+		{nid: parent.nid, fileType: fileTypeDir, name: ".."},
+	}
+	for _, c := range fi.children {
+		entries = append(entries, &dirent{nid: c.nid, fileType: fileTypeFromMode(unixMode(c.mode)), name: c.name})
+	}
+	return entries
+}
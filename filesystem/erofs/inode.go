@@ -0,0 +1,168 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	inodeCompactSize  = 32
+	inodeExtendedSize = 64
+	// inodeSlotBits is the shift from a nid to the offset of its inode in the metadata area
+	inodeSlotBits = 5
+	// nullAddr is the block address of something that has no block, such as a hole in a chunk-based file
+	nullAddr uint32 = 0xffffffff
+)
+
+// dataLayout is how the data of an inode is stored
+type dataLayout uint8
+
+const (
+	// layoutFlatPlain the data is in consecutive blocks
+	layoutFlatPlain dataLayout = 0
+	// layoutCompressedFull the data is compressed, with an 8 byte index for each logical cluster
+	layoutCompressedFull dataLayout = 1
+	// layoutFlatInline the data is in consecutive blocks, but for the last partial block, which follows the inode
+	layoutFlatInline dataLayout = 2
+	// layoutCompressedCompact the data is compressed, with the indexes packed into 2 or 4 bytes each
+	layoutCompressedCompact dataLayout = 3
+	// layoutChunkBased the data is in chunks, each of which can be anywhere
+	layoutChunkBased dataLayout = 4
+)
+
+func (l dataLayout) compressed() bool {
+	return l == layoutCompressedFull || l == layoutCompressedCompact
+}
+
+// chunk-based inodes keep the chunk format in i_u
+const (
+	chunkFormatBlkbitsMask uint16 = 0x1f
+	chunkFormatIndexes     uint16 = 0x20
+)
+
+// inode is an inode, compact or extended. There is no inode number of its own; it is found by its nid, which
+// is its offset into the metadata area in 32 byte slots.
+type inode struct {
+	nid        uint64
+	extended   bool
+	layout     dataLayout
+	xattrCount uint16
+	mode       uint16
+	nlink      uint32
+	size       uint64
+	// u is the block address, compressed block count, device number or chunk format, by the type of inode
+	u       uint32
+	ino     uint32
+	uid     uint32
+	gid     uint32
+	modTime time.Time
+}
+
+// inodeHeaderSize is how many bytes an inode has, from the format that starts it
+func inodeHeaderSize(b []byte) int {
+	if binary.LittleEndian.Uint16(b[0:2])&1 != 0 {
+		return inodeExtendedSize
+	}
+	return inodeCompactSize
+}
+
+// parseInode parses an inode with the given nid from b. A compact inode takes the modification time of the
+// filesystem, buildTime, as it has none of its own.
+func parseInode(b []byte, nid uint64, buildTime time.Time) (*inode, error) {
+	if len(b) < inodeCompactSize {
+		return nil, fmt.Errorf("inode was %d bytes instead of at least %d", len(b), inodeCompactSize)
+	}
+	format := binary.LittleEndian.Uint16(b[0:2])
+	in := &inode{
+		nid:        nid,
+		extended:   format&1 != 0,
+		layout:     dataLayout((format >> 1) & 0x7),
+		xattrCount: binary.LittleEndian.Uint16(b[2:4]),
+		mode:       binary.LittleEndian.Uint16(b[4:6]),
+	}
+	if format>>4 != 0 {
+		return nil, fmt.Errorf("inode %d has unsupported format %#x", nid, format)
+	}
+	if in.layout > layoutChunkBased {
+		return nil, fmt.Errorf("inode %d has unknown data layout %d", nid, in.layout)
+	}
+	if !in.extended {
+		in.nlink = uint32(binary.LittleEndian.Uint16(b[6:8]))
+		in.size = uint64(binary.LittleEndian.Uint32(b[8:12]))
+		in.u = binary.LittleEndian.Uint32(b[16:20])
+		in.ino = binary.LittleEndian.Uint32(b[20:24])
+		in.uid = uint32(binary.LittleEndian.Uint16(b[24:26]))
+		in.gid = uint32(binary.LittleEndian.Uint16(b[26:28]))
+		in.modTime = buildTime
+		return in, nil
+	}
+	if len(b) < inodeExtendedSize {
+		return nil, fmt.Errorf("extended inode was %d bytes instead of at least %d", len(b), inodeExtendedSize)
+	}
+	in.size = binary.LittleEndian.Uint64(b[8:16])
+	in.u = binary.LittleEndian.Uint32(b[16:20])
+	in.ino = binary.LittleEndian.Uint32(b[20:24])
+	in.uid = binary.LittleEndian.Uint32(b[24:28])
+	in.gid = binary.LittleEndian.Uint32(b[28:32])
+	in.modTime = time.Unix(int64(binary.LittleEndian.Uint64(b[32:40])), int64(binary.LittleEndian.Uint32(b[40:44])))
+	in.nlink = binary.LittleEndian.Uint32(b[44:48])
+	return in, nil
+}
+
+// toBytes returns the inode, always as an extended one, which has room for the modification time
+func (in *inode) toBytes() []byte {
+	b := make([]byte, inodeExtendedSize)
+	binary.LittleEndian.PutUint16(b[0:2], 1|uint16(in.layout)<<1)
+	binary.LittleEndian.PutUint16(b[2:4], in.xattrCount)
+	binary.LittleEndian.PutUint16(b[4:6], in.mode)
+	binary.LittleEndian.PutUint64(b[8:16], in.size)
+	binary.LittleEndian.PutUint32(b[16:20], in.u)
+	binary.LittleEndian.PutUint32(b[20:24], in.ino)
+	binary.LittleEndian.PutUint32(b[24:28], in.uid)
+	binary.LittleEndian.PutUint32(b[28:32], in.gid)
+	binary.LittleEndian.PutUint64(b[32:40], uint64(in.modTime.Unix()))
+	binary.LittleEndian.PutUint32(b[40:44], uint32(in.modTime.Nanosecond()))
+	binary.LittleEndian.PutUint32(b[44:48], in.nlink)
+	return b
+}
+
+// inodeSize is how many bytes the inode itself takes
+func (in *inode) inodeSize() int64 {
+	if in.extended {
+		return inodeExtendedSize
+	}
+	return inodeCompactSize
+}
+
+// xattrSize is how many bytes of xattrs follow the inode
+func (in *inode) xattrSize() int64 {
+	return xattrBodySize(in.xattrCount)
+}
+
+func (in *inode) isDir() bool {
+	return in.mode&modeTypeMask == modeDirectory
+}
+
+func (in *inode) isRegular() bool {
+	return in.mode&modeTypeMask == modeRegular
+}
+
+func (in *inode) isSymlink() bool {
+	return in.mode&modeTypeMask == modeSymlink
+}
+
+func (in *inode) isDevice() bool {
+	t := in.mode & modeTypeMask
+	return t == modeBlockDev || t == modeCharDev
+}
+
+// decodeDev splits a device number, in the encoding of the Linux new_encode_dev, into major and minor
+func decodeDev(dev uint32) (major, minor uint32) {
+	return (dev & 0xfff00) >> 8, (dev & 0xff) | ((dev >> 12) & 0xfff00)
+}
+
+// encodeDev combines major and minor into a device number the way Linux new_encode_dev does
+func encodeDev(major, minor uint32) uint32 {
+	return (minor & 0xff) | (major << 8) | ((minor &^ 0xff) << 12)
+}
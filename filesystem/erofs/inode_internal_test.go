@@ -0,0 +1,88 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestInodeRoundTrip(t *testing.T) {
+	in := &inode{
+		nid:        12,
+		extended:   true,
+		layout:     layoutFlatInline,
+		xattrCount: 3,
+		mode:       modeRegular | 0o644,
+		nlink:      1,
+		size:       1 << 33,
+		u:          77,
+		ino:        5,
+		uid:        100000,
+		gid:        100001,
+		modTime:    time.Unix(1700000000, 5),
+	}
+	parsed, err := parseInode(in.toBytes(), in.nid, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error parsing inode: %v", err)
+	}
+	if *parsed != *in {
+		t.Errorf("mismatched inode\nactual   %+v\nexpected %+v", parsed, in)
+	}
+	if parsed.xattrSize() != 20 || parsed.inodeSize() != inodeExtendedSize {
+		t.Errorf("mismatched sizes %d of xattrs and %d of inode", parsed.xattrSize(), parsed.inodeSize())
+	}
+}
+
+func TestParseInode(t *testing.T) {
+	buildTime := time.Unix(1600000000, 0)
+	compact := make([]byte, inodeCompactSize)
+	binary.LittleEndian.PutUint16(compact[0:2], uint16(layoutChunkBased)<<1)
+	binary.LittleEndian.PutUint16(compact[4:6], modeDirectory|0o755)
+	binary.LittleEndian.PutUint16(compact[6:8], 2)
+	binary.LittleEndian.PutUint32(compact[8:12], 4096)
+	binary.LittleEndian.PutUint32(compact[16:20], 12)
+	binary.LittleEndian.PutUint16(compact[24:26], 1000)
+	binary.LittleEndian.PutUint16(compact[26:28], 1001)
+
+	in, err := parseInode(compact, 1, buildTime)
+	if err != nil {
+		t.Fatalf("unexpected error parsing compact inode: %v", err)
+	}
+	expected := &inode{nid: 1, layout: layoutChunkBased, mode: modeDirectory | 0o755, nlink: 2, size: 4096, u: 12, uid: 1000, gid: 1001, modTime: buildTime}
+	if *in != *expected {
+		t.Errorf("mismatched inode\nactual   %+v\nexpected %+v", in, expected)
+	}
+	if !in.isDir() || in.isRegular() || inodeHeaderSize(compact) != inodeCompactSize {
+		t.Errorf("mismatched type of compact inode")
+	}
+
+	invalid := map[string][]byte{
+		"too short":      compact[:20],
+		"short extended": append([]byte{1, 0}, compact[2:]...),
+		"unknown layout": append([]byte{5 << 1, 0}, compact[2:]...),
+	}
+	for name, b := range invalid {
+		if _, err := parseInode(b, 1, buildTime); err == nil {
+			t.Errorf("%s: parsed invalid inode without error", name)
+		}
+	}
+}
+
+func TestDeviceNumbers(t *testing.T) {
+	tests := []struct {
+		major, minor uint32
+		dev          uint32
+	}{
+		{8, 1, 0x801},
+		{1, 3, 0x103},
+		{259, 0x12345, 0x12310345},
+	}
+	for _, tt := range tests {
+		if dev := encodeDev(tt.major, tt.minor); dev != tt.dev {
+			t.Errorf("%d:%d: encoded as %#x instead of %#x", tt.major, tt.minor, dev, tt.dev)
+		}
+		if major, minor := decodeDev(tt.dev); major != tt.major || minor != tt.minor {
+			t.Errorf("%#x: decoded as %d:%d instead of %d:%d", tt.dev, major, minor, tt.major, tt.minor)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	superblockMagic uint32 = 0xe0f5e1e2
+	// superblockOffset is where the superblock starts, leaving room for a boot sector before it
+	superblockOffset int64 = 1024
+	superblockSize   int64 = 128
+	minBlockBits     uint8 = 9
+	maxBlockBits     uint8 = 16
+)
+
+// compatible features, which a reader that does not know them can ignore
+const (
+	featureCompatSbChecksum uint32 = 0x1
+)
+
+// incompatible features, which a reader must know to read the filesystem
+// Some bits have two names in the kernel: compression configurations with big physical clusters, a device
+// table with a second compression algorithm, and fragments with deduplication.
+const (
+	featureIncompatZeroPadding  uint32 = 0x1
+	featureIncompatComprCfgs    uint32 = 0x2
+	featureIncompatChunkedFile  uint32 = 0x4
+	featureIncompatDeviceTable  uint32 = 0x8
+	featureIncompatZtailpacking uint32 = 0x10
+	featureIncompatFragments    uint32 = 0x20
+)
+
+// featureIncompatSupported are the incompatible features this package can read. A device table is only
+// supported when there are no extra devices.
+const featureIncompatSupported = featureIncompatZeroPadding | featureIncompatComprCfgs | featureIncompatChunkedFile |
+	featureIncompatDeviceTable | featureIncompatZtailpacking
+
+type superblock struct {
+	checksum           uint32
+	compatFeatures     uint32
+	blockBits          uint8
+	extSlots           uint8
+	rootNid            uint16
+	inodes             uint64
+	buildTime          time.Time
+	blocks             uint32
+	metaBlockAddr      uint32
+	xattrBlockAddr     uint32
+	uuid               uuid.UUID
+	volumeName         string
+	incompatFeatures   uint32
+	availableComprAlgs uint16
+	extraDevices       uint16
+	devtSlotOff        uint16
+	dirBlockBits       uint8
+	xattrPrefixCount   uint8
+	xattrPrefixStart   uint32
+	packedNid          uint64
+}
+
+func (s *superblock) blocksize() int64 {
+	return 1 << s.blockBits
+}
+
+// size is the size of the superblock itself, including any extension slots
+func (s *superblock) size() int64 {
+	return superblockSize + int64(s.extSlots)*16
+}
+
+// checksumSize is how many bytes from the start of the superblock the checksum covers: the rest of the
+// block it is in, or a whole block if blocks are no bigger than the superblock offset
+func checksumSize(blocksize int64) int64 {
+	if blocksize > superblockOffset {
+		return blocksize - superblockOffset
+	}
+	return blocksize
+}
+
+// parseSuperblock parses the superblock from b, which starts at the superblock and must cover what its
+// checksum does, as from checksumSize
+func parseSuperblock(b []byte) (*superblock, error) {
+	if int64(len(b)) < superblockSize {
+		return nil, fmt.Errorf("superblock was %d bytes instead of at least %d", len(b), superblockSize)
+	}
+	if magic := binary.LittleEndian.Uint32(b[0:4]); magic != superblockMagic {
+		return nil, fmt.Errorf("superblock had magic of %#x instead of expected %#x", magic, superblockMagic)
+	}
+	s := &superblock{
+		checksum:           binary.LittleEndian.Uint32(b[4:8]),
+		compatFeatures:     binary.LittleEndian.Uint32(b[8:12]),
+		blockBits:          b[12],
+		extSlots:           b[13],
+		rootNid:            binary.LittleEndian.Uint16(b[14:16]),
+		inodes:             binary.LittleEndian.Uint64(b[16:24]),
+		buildTime:          time.Unix(int64(binary.LittleEndian.Uint64(b[24:32])), int64(binary.LittleEndian.Uint32(b[32:36]))),
+		blocks:             binary.LittleEndian.Uint32(b[36:40]),
+		metaBlockAddr:      binary.LittleEndian.Uint32(b[40:44]),
+		xattrBlockAddr:     binary.LittleEndian.Uint32(b[44:48]),
+		volumeName:         strings.TrimRight(string(b[64:80]), "\x00"),
+		incompatFeatures:   binary.LittleEndian.Uint32(b[80:84]),
+		availableComprAlgs: binary.LittleEndian.Uint16(b[84:86]),
+		extraDevices:       binary.LittleEndian.Uint16(b[86:88]),
+		devtSlotOff:        binary.LittleEndian.Uint16(b[88:90]),
+		dirBlockBits:       b[90],
+		xattrPrefixCount:   b[91],
+		xattrPrefixStart:   binary.LittleEndian.Uint32(b[92:96]),
+		packedNid:          binary.LittleEndian.Uint64(b[96:104]),
+	}
+	copy(s.uuid[:], b[48:64])
+	if s.blockBits < minBlockBits || s.blockBits > maxBlockBits {
+		return nil, fmt.Errorf("block size of 2^%d is not between %d and %d bytes", s.blockBits, 1<<minBlockBits, 1<<maxBlockBits)
+	}
+	if s.compatFeatures&featureCompatSbChecksum != 0 {
+		n := checksumSize(s.blocksize())
+		if int64(len(b)) < n {
+			return nil, fmt.Errorf("superblock was %d bytes, too few for its checksum over %d", len(b), n)
+		}
+		if crc := superblockChecksum(b[:n]); crc != s.checksum {
+			return nil, fmt.Errorf("superblock checksum %#x does not match expected %#x", s.checksum, crc)
+		}
+	}
+	if unsupported := s.incompatFeatures &^ featureIncompatSupported; unsupported != 0 {
+		return nil, fmt.Errorf("unsupported incompatible features %#x", unsupported)
+	}
+	if s.incompatFeatures&featureIncompatDeviceTable != 0 && s.extraDevices != 0 {
+		return nil, fmt.Errorf("unsupported %d extra devices", s.extraDevices)
+	}
+	if s.dirBlockBits != 0 {
+		return nil, fmt.Errorf("unsupported directory block size of 2^%d blocks", s.dirBlockBits)
+	}
+	return s, nil
+}
+
+// toBytes returns the superblock, without its checksum, which covers more than the superblock
+func (s *superblock) toBytes() []byte {
+	b := make([]byte, superblockSize)
+	binary.LittleEndian.PutUint32(b[0:4], superblockMagic)
+	binary.LittleEndian.PutUint32(b[8:12], s.compatFeatures)
+	b[12] = s.blockBits
+	b[13] = s.extSlots
+	binary.LittleEndian.PutUint16(b[14:16], s.rootNid)
+	binary.LittleEndian.PutUint64(b[16:24], s.inodes)
+	binary.LittleEndian.PutUint64(b[24:32], uint64(s.buildTime.Unix()))
+	binary.LittleEndian.PutUint32(b[32:36], uint32(s.buildTime.Nanosecond()))
+	binary.LittleEndian.PutUint32(b[36:40], s.blocks)
+	binary.LittleEndian.PutUint32(b[40:44], s.metaBlockAddr)
+	binary.LittleEndian.PutUint32(b[44:48], s.xattrBlockAddr)
+	copy(b[48:64], s.uuid[:])
+	copy(b[64:80], s.volumeName)
+	binary.LittleEndian.PutUint32(b[80:84], s.incompatFeatures)
+	binary.LittleEndian.PutUint16(b[84:86], s.availableComprAlgs)
+	binary.LittleEndian.PutUint16(b[86:88], s.extraDevices)
+	binary.LittleEndian.PutUint16(b[88:90], s.devtSlotOff)
+	b[90] = s.dirBlockBits
+	b[91] = s.xattrPrefixCount
+	binary.LittleEndian.PutUint32(b[92:96], s.xattrPrefixStart)
+	binary.LittleEndian.PutUint64(b[96:104], s.packedNid)
+	return b
+}
+
+// superblockChecksum is the checksum of b, which starts with the superblock, with the checksum field itself
+// taken as 0
+func superblockChecksum(b []byte) uint32 {
+	zero := make([]byte, 4)
+	crc := crc32c(^uint32(0), b[:4])
+	crc = crc32c(crc, zero)
+	return crc32c(crc, b[8:])
+}
@@ -0,0 +1,107 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testSuperblock() *superblock {
+	return &superblock{
+		compatFeatures:   featureCompatSbChecksum,
+		blockBits:        12,
+		rootNid:          36,
+		inodes:           1000,
+		buildTime:        time.Unix(1700000000, 123456789),
+		blocks:           4096,
+		metaBlockAddr:    17,
+		uuid:             uuid.MustParse("ad79a6c1-7c2a-4c43-9a5e-07bd5b51a5e7"),
+		volumeName:       "erofs",
+		incompatFeatures: featureIncompatZeroPadding,
+	}
+}
+
+// superblockBytes returns the superblock with its checksum, over as much as it covers
+func superblockBytes(s *superblock) []byte {
+	b := make([]byte, checksumSize(s.blocksize()))
+	copy(b, s.toBytes())
+	binary.LittleEndian.PutUint32(b[4:8], superblockChecksum(b))
+	return b
+}
+
+func TestSuperblockRoundTrip(t *testing.T) {
+	s := testSuperblock()
+	b := superblockBytes(s)
+	parsed, err := parseSuperblock(b)
+	if err != nil {
+		t.Fatalf("unexpected error parsing superblock: %v", err)
+	}
+	s.checksum = binary.LittleEndian.Uint32(b[4:8])
+	if !parsed.buildTime.Equal(s.buildTime) {
+		t.Errorf("mismatched build time %v instead of %v", parsed.buildTime, s.buildTime)
+	}
+	parsed.buildTime = s.buildTime
+	if *parsed != *s {
+		t.Errorf("mismatched superblock\nactual   %+v\nexpected %+v", parsed, s)
+	}
+}
+
+func TestParseSuperblock(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s *superblock, b []byte) []byte
+	}{
+		{"bad magic", func(s *superblock, b []byte) []byte {
+			b[0] = 0
+			return b
+		}},
+		{"bad checksum", func(s *superblock, b []byte) []byte {
+			b[100]++
+			return b
+		}},
+		{"too short for checksum", func(s *superblock, b []byte) []byte {
+			return b[:superblockSize]
+		}},
+		{"block size too large", func(s *superblock, b []byte) []byte {
+			s.blockBits = 17
+			return superblockBytes(s)
+		}},
+		{"unsupported feature", func(s *superblock, b []byte) []byte {
+			s.incompatFeatures |= featureIncompatFragments
+			return superblockBytes(s)
+		}},
+		{"extra devices", func(s *superblock, b []byte) []byte {
+			s.incompatFeatures |= featureIncompatDeviceTable
+			s.extraDevices = 1
+			return superblockBytes(s)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSuperblock()
+			b := tt.modify(s, superblockBytes(s))
+			if _, err := parseSuperblock(b); err == nil {
+				t.Errorf("parsed invalid superblock without error")
+			}
+		})
+	}
+}
+
+func TestChecksumSize(t *testing.T) {
+	tests := []struct {
+		blocksize int64
+		size      int64
+	}{
+		{512, 512},
+		{1024, 1024},
+		{4096, 3072},
+		{65536, 64512},
+	}
+	for _, tt := range tests {
+		if size := checksumSize(tt.blocksize); size != tt.size {
+			t.Errorf("%d: checksum over %d bytes instead of %d", tt.blocksize, size, tt.size)
+		}
+	}
+}
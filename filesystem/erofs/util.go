@@ -0,0 +1,118 @@
+package erofs
+
+import (
+	"hash/crc32"
+	"os"
+	"strings"
+)
+
+const (
+	// KB represents one KB
+	KB int64 = 1024
+	// MB represents one MB
+	MB int64 = 1024 * KB
+	// GB represents one GB
+	GB int64 = 1024 * MB
+)
+
+// unix file mode bits, as stored in i_mode
+const (
+	modeTypeMask   uint16 = 0o170000
+	modeSocket     uint16 = 0o140000
+	modeSymlink    uint16 = 0o120000
+	modeRegular    uint16 = 0o100000
+	modeBlockDev   uint16 = 0o060000
+	modeDirectory  uint16 = 0o040000
+	modeCharDev    uint16 = 0o020000
+	modeFifo       uint16 = 0o010000
+	modeSetuid     uint16 = 0o4000
+	modeSetgid     uint16 = 0o2000
+	modeSticky     uint16 = 0o1000
+	modePermission uint16 = 0o777
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32c continues a crc32c from seed the way the kernel does, without inverting it at the start or end.
+// Start a new one with a seed of ^0.
+func crc32c(seed uint32, b []byte) uint32 {
+	return ^crc32.Update(^seed, crc32cTable, b)
+}
+
+// fileMode converts a unix mode, as stored in an inode, to an os.FileMode
+func fileMode(m uint16) os.FileMode {
+	mode := os.FileMode(m & modePermission)
+	switch m & modeTypeMask {
+	case modeDirectory:
+		mode |= os.ModeDir
+	case modeSymlink:
+		mode |= os.ModeSymlink
+	case modeBlockDev:
+		mode |= os.ModeDevice
+	case modeCharDev:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case modeFifo:
+		mode |= os.ModeNamedPipe
+	case modeSocket:
+		mode |= os.ModeSocket
+	}
+	if m&modeSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&modeSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&modeSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// unixMode converts an os.FileMode to a unix mode to store in an inode
+func unixMode(mode os.FileMode) uint16 {
+	m := uint16(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= modeDirectory
+	case mode&os.ModeSymlink != 0:
+		m |= modeSymlink
+	case mode&os.ModeCharDevice != 0:
+		m |= modeCharDev
+	case mode&os.ModeDevice != 0:
+		m |= modeBlockDev
+	case mode&os.ModeNamedPipe != 0:
+		m |= modeFifo
+	case mode&os.ModeSocket != 0:
+		m |= modeSocket
+	default:
+		m |= modeRegular
+	}
+	if mode&os.ModeSetuid != 0 {
+		m |= modeSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= modeSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= modeSticky
+	}
+	return m
+}
+
+func universalizePath(p string) string {
+	// globalize the separator
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+func splitPath(p string) []string {
+	ps := universalizePath(p)
+	parts := strings.Split(ps, "/")
+	// eliminate empty parts
+	ret := make([]string, 0)
+	for _, sub := range parts {
+		if sub != "" {
+			ret = append(ret, sub)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,137 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	xattrHeaderSize = 12
+	xattrEntrySize  = 4
+	// xattrLongPrefix in a name index says it is for a long prefix, which needs the xattr_prefixes feature
+	xattrLongPrefix uint8 = 0x80
+)
+
+// xattrPrefixes are the prefixes each name index stands for, which are left out of the stored name
+var xattrPrefixes = map[uint8]string{
+	1: "user.",
+	2: "system.posix_acl_access",
+	3: "system.posix_acl_default",
+	4: "trusted.",
+	5: "lustre.",
+	6: "security.",
+}
+
+// xattrBodySize is how many bytes of xattrs follow an inode with the given i_xattr_icount
+func xattrBodySize(count uint16) int64 {
+	if count == 0 {
+		return 0
+	}
+	return xattrHeaderSize + int64(count-1)*4
+}
+
+// xattrKeyConvert splits the name of an xattr into the index of its prefix and what follows the prefix.
+// The posix acl prefixes are whole names, with nothing following them.
+func xattrKeyConvert(name string) (index uint8, suffix string, err error) {
+	for i, prefix := range xattrPrefixes {
+		switch {
+		case !strings.HasSuffix(prefix, "."):
+			if name == prefix {
+				return i, "", nil
+			}
+		case strings.HasPrefix(name, prefix) && len(name) > len(prefix):
+			return i, name[len(prefix):], nil
+		}
+	}
+	return 0, "", fmt.Errorf("unsupported xattr name %s", name)
+}
+
+// parseXattrEntry parses one xattr entry at the start of b, returning it and how many bytes it took
+func parseXattrEntry(b []byte) (name, value string, n int, err error) {
+	if len(b) < xattrEntrySize {
+		return "", "", 0, fmt.Errorf("xattr entry was %d bytes instead of at least %d", len(b), xattrEntrySize)
+	}
+	nameLen := int(b[0])
+	index := b[1]
+	valueSize := int(binary.LittleEndian.Uint16(b[2:4]))
+	end := xattrEntrySize + nameLen + valueSize
+	if len(b) < end {
+		return "", "", 0, fmt.Errorf("xattr entry of %d bytes overflows the %d bytes left", end, len(b))
+	}
+	if index&xattrLongPrefix != 0 {
+		return "", "", 0, fmt.Errorf("unsupported long xattr prefix %d", index&^xattrLongPrefix)
+	}
+	prefix, ok := xattrPrefixes[index]
+	if !ok {
+		return "", "", 0, fmt.Errorf("unknown xattr name index %d", index)
+	}
+	name = prefix + string(b[xattrEntrySize:xattrEntrySize+nameLen])
+	value = string(b[xattrEntrySize+nameLen : end])
+	return name, value, (end + 3) &^ 3, nil
+}
+
+// parseXattrBody parses the xattrs that follow an inode into xattrs. The shared ones are only given by their
+// ids, and shared returns those to find in the shared xattr area.
+func parseXattrBody(b []byte, xattrs map[string]string) (shared []uint32, err error) {
+	if len(b) < xattrHeaderSize {
+		return nil, fmt.Errorf("xattrs were %d bytes instead of at least %d", len(b), xattrHeaderSize)
+	}
+	sharedCount := int(b[4])
+	pos := xattrHeaderSize + sharedCount*4
+	if pos > len(b) {
+		return nil, fmt.Errorf("%d shared xattrs overflow the %d bytes of xattrs", sharedCount, len(b))
+	}
+	for i := 0; i < sharedCount; i++ {
+		shared = append(shared, binary.LittleEndian.Uint32(b[xattrHeaderSize+i*4:]))
+	}
+	for pos < len(b) {
+		name, value, n, err := parseXattrEntry(b[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing xattr at offset %d: %v", pos, err)
+		}
+		xattrs[name] = value
+		pos += n
+	}
+	return shared, nil
+}
+
+// xattrBodyBytes returns the xattrs to follow an inode, all of them inline rather than shared, and the
+// i_xattr_icount for them. There are none at all for no xattrs.
+func xattrBodyBytes(xattrs map[string]string) ([]byte, uint16, error) {
+	if len(xattrs) == 0 {
+		return nil, 0, nil
+	}
+	names := make([]string, 0, len(xattrs))
+	for k := range xattrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	b := make([]byte, xattrHeaderSize)
+	for _, name := range names {
+		index, suffix, err := xattrKeyConvert(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		value := xattrs[name]
+		if len(suffix) > 0xff {
+			return nil, 0, fmt.Errorf("xattr name %s is too long", name)
+		}
+		if len(value) > 0xffff {
+			return nil, 0, fmt.Errorf("value of xattr %s is %d bytes, more than the maximum %d", name, len(value), 0xffff)
+		}
+		entry := make([]byte, (xattrEntrySize+len(suffix)+len(value)+3)&^3)
+		entry[0] = uint8(len(suffix))
+		entry[1] = index
+		binary.LittleEndian.PutUint16(entry[2:4], uint16(len(value)))
+		copy(entry[xattrEntrySize:], suffix)
+		copy(entry[xattrEntrySize+len(suffix):], value)
+		b = append(b, entry...)
+	}
+	count := (len(b)-xattrHeaderSize)/4 + 1
+	if count > 0xffff {
+		return nil, 0, fmt.Errorf("xattrs take %d bytes, more than fit with an inode", len(b))
+	}
+	return b, uint16(count), nil
+}
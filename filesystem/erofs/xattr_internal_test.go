@@ -0,0 +1,62 @@
+package erofs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestXattrKeyConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		index  uint8
+		suffix string
+		valid  bool
+	}{
+		{"user.comment", 1, "comment", true},
+		{"system.posix_acl_access", 2, "", true},
+		{"system.posix_acl_default", 3, "", true},
+		{"trusted.overlay.opaque", 4, "overlay.opaque", true},
+		{"security.selinux", 6, "selinux", true},
+		{"user.", 0, "", false},
+		{"system.posix_acl_access.x", 0, "", false},
+		{"other.name", 0, "", false},
+	}
+	for _, tt := range tests {
+		index, suffix, err := xattrKeyConvert(tt.name)
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case !tt.valid && err == nil:
+			t.Errorf("%s: converted invalid name without error", tt.name)
+		case index != tt.index || suffix != tt.suffix:
+			t.Errorf("%s: converted to %d %q instead of %d %q", tt.name, index, suffix, tt.index, tt.suffix)
+		}
+	}
+}
+
+func TestXattrBodyRoundTrip(t *testing.T) {
+	xattrs := map[string]string{
+		"user.comment":            "hello",
+		"security.selinux":        "system_u:object_r:etc_t:s0\x00",
+		"system.posix_acl_access": "\x02\x00\x00\x00",
+		"trusted.empty":           "",
+	}
+	b, count, err := xattrBodyBytes(xattrs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if int64(len(b)) != xattrBodySize(count) {
+		t.Errorf("%d bytes of xattrs for count %d, which is %d bytes", len(b), count, xattrBodySize(count))
+	}
+	parsed := map[string]string{}
+	shared, err := parseXattrBody(b, parsed)
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	if len(shared) != 0 || !reflect.DeepEqual(parsed, xattrs) {
+		t.Errorf("mismatched xattrs %v and shared %v instead of %v", parsed, shared, xattrs)
+	}
+	if b, count, err := xattrBodyBytes(nil); b != nil || count != 0 || err != nil {
+		t.Errorf("no xattrs gave %v, %d, %v", b, count, err)
+	}
+}
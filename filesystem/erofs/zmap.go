@@ -0,0 +1,431 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+How compressed data is laid out
+
+The data of a compressed file is split into logical clusters, each usually a block. Each has an index,
+which says whether an extent of data starts in it, the head, or it is in the middle of one. An extent is
+stored, compressed, in a physical cluster of one or more blocks, with the compressed data at the end of it
+and zeros before. The decompressed extent is the data from where it starts to where the next one starts.
+
+The indexes follow the inode and its xattrs, after a map header, either as 8 bytes each, full, or packed
+into fewer bits, compact.
+*/
+
+const (
+	mapHeaderSize = 8
+	// fullIndexSize is the size of each full index
+	fullIndexSize = 8
+	// fullIndexPadding is between the map header and the first full index
+	fullIndexPadding = 8
+)
+
+// flags in h_advise of the map header
+const (
+	adviseCompacted2B        uint16 = 0x1
+	adviseBigPcluster1       uint16 = 0x2
+	adviseBigPcluster2       uint16 = 0x4
+	adviseInlinePcluster     uint16 = 0x8
+	adviseInterlacedPcluster uint16 = 0x10
+	adviseFragmentPcluster   uint16 = 0x20
+)
+
+// lclusterType is the type of a logical cluster
+type lclusterType uint8
+
+const (
+	// lclusterPlain the head of an extent stored uncompressed
+	lclusterPlain lclusterType = 0
+	// lclusterHead1 the head of an extent compressed with the first algorithm
+	lclusterHead1 lclusterType = 1
+	// lclusterNonHead in the middle of an extent
+	lclusterNonHead lclusterType = 2
+	// lclusterHead2 the head of an extent compressed with the second algorithm
+	lclusterHead2 lclusterType = 3
+)
+
+// indexD0CblkCnt in the first delta of the first logical cluster after a head says the rest of it is the
+// number of blocks in the physical cluster
+const indexD0CblkCnt uint16 = 1 << 11
+
+// mapHeader is the header before the indexes of a compressed file
+type mapHeader struct {
+	// inlineSize is the compressed size of the last extent, when it follows the indexes
+	inlineSize  uint16
+	advise      uint16
+	algorithms  uint8
+	clusterBits uint8
+}
+
+func parseMapHeader(b []byte) *mapHeader {
+	return &mapHeader{
+		inlineSize:  binary.LittleEndian.Uint16(b[2:4]),
+		advise:      binary.LittleEndian.Uint16(b[4:6]),
+		algorithms:  b[6],
+		clusterBits: b[7],
+	}
+}
+
+func (h *mapHeader) toBytes() []byte {
+	b := make([]byte, mapHeaderSize)
+	binary.LittleEndian.PutUint16(b[2:4], h.inlineSize)
+	binary.LittleEndian.PutUint16(b[4:6], h.advise)
+	b[6] = h.algorithms
+	b[7] = h.clusterBits
+	return b
+}
+
+// lclusterIndex is the index of a single logical cluster
+type lclusterIndex struct {
+	lclusterType lclusterType
+	// clusterOffset is where in a head logical cluster its extent starts
+	clusterOffset uint32
+	// blockAddr is the first block of the physical cluster of a head
+	blockAddr uint32
+	// blockCount is the number of blocks in the physical cluster of the extent, given by the first logical
+	// cluster after its head, or 0 if it does not give it
+	blockCount uint32
+}
+
+// fullIndexBytes returns a full index
+func (l *lclusterIndex) fullIndexBytes(delta0, delta1 uint16) []byte {
+	b := make([]byte, fullIndexSize)
+	binary.LittleEndian.PutUint16(b[0:2], uint16(l.lclusterType))
+	if l.lclusterType == lclusterNonHead {
+		binary.LittleEndian.PutUint16(b[4:6], delta0)
+		binary.LittleEndian.PutUint16(b[6:8], delta1)
+		return b
+	}
+	binary.LittleEndian.PutUint16(b[2:4], uint16(l.clusterOffset))
+	binary.LittleEndian.PutUint32(b[4:8], l.blockAddr)
+	return b
+}
+
+func parseFullIndex(b []byte) *lclusterIndex {
+	advise := binary.LittleEndian.Uint16(b[0:2])
+	l := &lclusterIndex{lclusterType: lclusterType(advise & 0x3)}
+	if l.lclusterType == lclusterNonHead {
+		if delta0 := binary.LittleEndian.Uint16(b[4:6]); delta0&indexD0CblkCnt != 0 {
+			l.blockCount = uint32(delta0 &^ indexD0CblkCnt)
+		}
+		return l
+	}
+	l.clusterOffset = uint32(binary.LittleEndian.Uint16(b[2:4]))
+	l.blockAddr = binary.LittleEndian.Uint32(b[4:8])
+	return l
+}
+
+// extent is a run of the data of a compressed file, and where it is stored
+type extent struct {
+	// offset is where in the file it starts
+	offset int64
+	length int64
+	// location is where on the filesystem its physical cluster is
+	location int64
+	// physicalLength is the size of its physical cluster
+	physicalLength int64
+	// plain says it is stored uncompressed
+	plain bool
+	// interlaced says, for an extent stored uncompressed, that it is rotated to where it starts in a block
+	interlaced  bool
+	compression compression
+}
+
+// indexReader finds the indexes of a compressed file, which are in b, starting at base on the filesystem
+type indexReader struct {
+	b            []byte
+	base         int64
+	compact      bool
+	lclusterBits uint
+	count        int
+	bigPcluster  bool
+	// for compact indexes, the number at the start with 4 bytes each and then those with 2
+	compact4bInitial int
+	compact2b        int
+}
+
+// newIndexReader creates an indexReader for count indexes whose first is at ebase, from the end of the
+// map header
+func newIndexReader(ebase int64, count int, compact bool, h *mapHeader, lclusterBits uint) *indexReader {
+	r := &indexReader{
+		base:         ebase,
+		compact:      compact,
+		lclusterBits: lclusterBits,
+		count:        count,
+		bigPcluster:  h.advise&adviseBigPcluster1 != 0,
+	}
+	if !compact {
+		r.base += fullIndexPadding
+		return r
+	}
+	// the first indexes take 4 bytes until 32 byte aligned, and then 2 bytes in packs of 16
+	r.compact4bInitial = int((32 - ebase%32) / 4)
+	if r.compact4bInitial == 32/4 {
+		r.compact4bInitial = 0
+	}
+	if h.advise&adviseCompacted2B != 0 && r.compact4bInitial < count {
+		r.compact2b = (count - r.compact4bInitial) / 16 * 16
+	}
+	return r
+}
+
+// position is where on the filesystem the index of logical cluster lcn is, and for compact ones,
+// log2 of the bytes it takes
+func (r *indexReader) position(lcn int) (pos int64, amortizedShift uint) {
+	if !r.compact {
+		return r.base + int64(lcn)*fullIndexSize, 3
+	}
+	pos = r.base
+	switch {
+	case lcn < r.compact4bInitial:
+		return pos + int64(lcn)*4, 2
+	case lcn < r.compact4bInitial+r.compact2b:
+		return pos + int64(r.compact4bInitial)*4 + int64(lcn-r.compact4bInitial)*2, 1
+	}
+	return pos + int64(r.compact4bInitial)*4 + int64(r.compact2b)*2 + int64(lcn-r.compact4bInitial-r.compact2b)*4, 2
+}
+
+// end is where the indexes end, which for compact ones is the end of the pack of the last one
+func (r *indexReader) end() int64 {
+	if r.count == 0 {
+		return r.base
+	}
+	pos, shift := r.position(r.count - 1)
+	if !r.compact {
+		return pos + fullIndexSize
+	}
+	packSize := int64(r.packCount(shift)) << shift
+	return pos/packSize*packSize + packSize
+}
+
+// packCount is how many compact indexes are in each pack
+func (r *indexReader) packCount(amortizedShift uint) int {
+	if amortizedShift == 1 {
+		return 16
+	}
+	return 2
+}
+
+// index returns the index of logical cluster lcn
+func (r *indexReader) index(lcn int) (*lclusterIndex, error) {
+	if lcn < 0 || lcn >= r.count {
+		return nil, fmt.Errorf("logical cluster %d is not between 0 and %d", lcn, r.count)
+	}
+	pos, shift := r.position(lcn)
+	if !r.compact {
+		off := pos - r.base
+		if off+fullIndexSize > int64(len(r.b)) {
+			return nil, fmt.Errorf("index %d overflows the %d bytes of indexes", lcn, len(r.b))
+		}
+		return parseFullIndex(r.b[off:]), nil
+	}
+	return r.compactIndex(pos, shift)
+}
+
+// compactIndex decodes the compact index at pos, which is in a pack of indexes whose last 4 bytes are the
+// block address of the first physical cluster in it. Each of the rest is a type and a value, which is the
+// cluster offset for a head, and otherwise the distance back to the head, or the block count.
+func (r *indexReader) compactIndex(pos int64, amortizedShift uint) (*lclusterIndex, error) {
+	vcnt := r.packCount(amortizedShift)
+	if (amortizedShift == 1 && r.lclusterBits > 12) || r.lclusterBits > 14 {
+		return nil, fmt.Errorf("compact indexes do not support logical clusters of 2^%d bytes", r.lclusterBits)
+	}
+	packSize := int64(vcnt) << amortizedShift
+	packStart := pos / packSize * packSize
+	if packStart < r.base || packStart+packSize-r.base > int64(len(r.b)) {
+		return nil, fmt.Errorf("index pack at %d overflows the indexes", packStart)
+	}
+	in := r.b[packStart-r.base : packStart-r.base+packSize]
+	loBits := r.lclusterBits
+	if loBits < 12 {
+		loBits = 12
+	}
+	encodeBits := uint((packSize - 4) * 8 / int64(vcnt))
+	i := int((pos - packStart) >> amortizedShift)
+
+	decode := func(i int) (lo uint32, t lclusterType) {
+		bit := encodeBits * uint(i)
+		var v uint32
+		for j := 0; j < 4 && int(bit/8)+j < len(in); j++ {
+			v |= uint32(in[int(bit/8)+j]) << (8 * j)
+		}
+		v >>= bit & 7
+		return v & (1<<loBits - 1), lclusterType((v >> loBits) & 3)
+	}
+
+	lo, t := decode(i)
+	l := &lclusterIndex{lclusterType: t}
+	if t == lclusterNonHead {
+		if lo&uint32(indexD0CblkCnt) != 0 {
+			if !r.bigPcluster {
+				return nil, fmt.Errorf("block count in index without big physical clusters")
+			}
+			l.blockCount = lo &^ uint32(indexD0CblkCnt)
+		}
+		return l, nil
+	}
+	l.clusterOffset = lo
+	// the block address of a head is that of the pack, after the physical clusters of the heads before it
+	var blocks uint32
+	if !r.bigPcluster {
+		blocks = 1
+		for i > 0 {
+			i--
+			lo, t = decode(i)
+			if t == lclusterNonHead {
+				i -= int(lo)
+			}
+			if i >= 0 {
+				blocks++
+			}
+		}
+	} else {
+		for i > 0 {
+			i--
+			lo, t = decode(i)
+			if t != lclusterNonHead {
+				blocks++
+				continue
+			}
+			if lo&uint32(indexD0CblkCnt) != 0 {
+				i--
+				blocks += lo &^ uint32(indexD0CblkCnt)
+				continue
+			}
+			if lo <= 1 {
+				return nil, fmt.Errorf("invalid index delta %d with big physical clusters", lo)
+			}
+			i -= int(lo) - 2
+		}
+	}
+	l.blockAddr = binary.LittleEndian.Uint32(in[packSize-4:]) + blocks
+	return l, nil
+}
+
+// compressedExtents reads the indexes of a compressed inode, and returns its extents
+func (fs *FileSystem) compressedExtents(in *inode) ([]*extent, error) {
+	if in.size == 0 {
+		return nil, nil
+	}
+	blocksize := fs.superblock.blocksize()
+	headerPos := (fs.inodeLocation(in.nid) + in.inodeSize() + in.xattrSize() + 7) &^ 7
+	b, err := fs.readBytes(headerPos, mapHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not read map header: %v", err)
+	}
+	h := parseMapHeader(b)
+	if h.advise&adviseFragmentPcluster != 0 || h.clusterBits&0x80 != 0 {
+		return nil, fmt.Errorf("unsupported fragments")
+	}
+	lclusterBits := uint(fs.superblock.blockBits) + uint(h.clusterBits&0x7)
+	lclusterSize := int64(1) << lclusterBits
+	count := int((int64(in.size) + lclusterSize - 1) >> lclusterBits)
+	r := newIndexReader(headerPos+mapHeaderSize, count, in.layout == layoutCompressedCompact, h, lclusterBits)
+	end := r.end()
+	if r.b, err = fs.readBytes(r.base, int(end-r.base)); err != nil {
+		return nil, fmt.Errorf("could not read indexes: %v", err)
+	}
+
+	var (
+		extents []*extent
+		// bigPcluster is whether the physical cluster of the last extent can be more than one block
+		bigPcluster bool
+	)
+	for lcn := 0; lcn < count; lcn++ {
+		l, err := r.index(lcn)
+		if err != nil {
+			return nil, err
+		}
+		if l.lclusterType == lclusterNonHead {
+			if lcn == 0 {
+				return nil, fmt.Errorf("first logical cluster is not a head")
+			}
+			// the first after a head may give the size of its physical cluster
+			if last := extents[len(extents)-1]; bigPcluster && l.blockCount != 0 && last.offset>>lclusterBits == int64(lcn-1) {
+				last.physicalLength = int64(l.blockCount) * blocksize
+			}
+			continue
+		}
+		if l.clusterOffset >= uint32(lclusterSize) {
+			return nil, fmt.Errorf("logical cluster %d starts at %d, past its end", lcn, l.clusterOffset)
+		}
+		e := &extent{
+			offset:         int64(lcn)<<lclusterBits + int64(l.clusterOffset),
+			location:       int64(l.blockAddr) * blocksize,
+			physicalLength: blocksize,
+		}
+		switch l.lclusterType {
+		case lclusterPlain:
+			e.plain = true
+			e.interlaced = h.advise&adviseInterlacedPcluster != 0
+			bigPcluster = h.advise&adviseBigPcluster2 != 0
+		case lclusterHead1:
+			e.compression = compression(h.algorithms & 0xf)
+			bigPcluster = h.advise&adviseBigPcluster1 != 0
+		case lclusterHead2:
+			e.compression = compression(h.algorithms >> 4)
+			bigPcluster = h.advise&adviseBigPcluster2 != 0
+		}
+		// a big physical cluster without a block count after the head is a whole logical cluster
+		if bigPcluster {
+			e.physicalLength = lclusterSize
+		}
+		if len(extents) > 0 {
+			last := extents[len(extents)-1]
+			last.length = e.offset - last.offset
+		}
+		extents = append(extents, e)
+	}
+	if len(extents) == 0 {
+		return nil, fmt.Errorf("no extents in compressed file")
+	}
+	last := extents[len(extents)-1]
+	last.length = int64(in.size) - last.offset
+	// the last extent may follow the indexes
+	if h.advise&adviseInlinePcluster != 0 {
+		last.location = end
+		last.physicalLength = int64(h.inlineSize)
+	}
+	for _, e := range extents {
+		if e.length <= 0 {
+			return nil, fmt.Errorf("extent at %d has invalid length %d", e.offset, e.length)
+		}
+		if e.plain && e.length > e.physicalLength {
+			return nil, fmt.Errorf("uncompressed extent at %d of %d bytes overflows its %d bytes", e.offset, e.length, e.physicalLength)
+		}
+	}
+	return extents, nil
+}
+
+// readExtent returns the decompressed data of an extent
+func (fs *FileSystem) readExtent(e *extent) ([]byte, error) {
+	b, err := fs.readBytes(e.location, int(e.physicalLength))
+	if err != nil {
+		return nil, fmt.Errorf("could not read extent at %d: %v", e.offset, err)
+	}
+	if e.plain {
+		if !e.interlaced {
+			return b[:e.length], nil
+		}
+		out := make([]byte, e.length)
+		start := e.offset % fs.superblock.blocksize()
+		for i := range out {
+			out[i] = b[(start+int64(i))%int64(len(b))]
+		}
+		return out, nil
+	}
+	c, ok := fs.compressors[e.compression]
+	if !ok {
+		return nil, fmt.Errorf("extent at %d uses compression %d that the filesystem does not have", e.offset, e.compression)
+	}
+	out, err := c.decompress(b, int(e.length))
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress extent at %d: %v", e.offset, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestFullIndexRoundTrip(t *testing.T) {
+	tests := []struct {
+		index  *lclusterIndex
+		delta0 uint16
+	}{
+		{&lclusterIndex{lclusterType: lclusterHead1, clusterOffset: 100, blockAddr: 1234}, 0},
+		{&lclusterIndex{lclusterType: lclusterPlain, blockAddr: 7}, 0},
+		{&lclusterIndex{lclusterType: lclusterNonHead}, 3},
+		{&lclusterIndex{lclusterType: lclusterNonHead, blockCount: 4}, indexD0CblkCnt | 4},
+	}
+	for _, tt := range tests {
+		parsed := parseFullIndex(tt.index.fullIndexBytes(tt.delta0, 1))
+		if *parsed != *tt.index {
+			t.Errorf("mismatched index %+v instead of %+v", parsed, tt.index)
+		}
+	}
+}
+
+func TestMapHeaderRoundTrip(t *testing.T) {
+	h := &mapHeader{inlineSize: 300, advise: adviseInlinePcluster | adviseBigPcluster1, algorithms: 0x10, clusterBits: 1}
+	if parsed := parseMapHeader(h.toBytes()); *parsed != *h {
+		t.Errorf("mismatched header %+v instead of %+v", parsed, h)
+	}
+}
+
+func TestCompactIndex(t *testing.T) {
+	// a pack of two 4 byte indexes, a head and the rest of its extent, as the kernel decodes them: the block
+	// address of the pack is one before that of its first head
+	pack := make([]byte, 8)
+	binary.LittleEndian.PutUint16(pack[0:2], uint16(lclusterHead1)<<12|0x123)
+	binary.LittleEndian.PutUint16(pack[2:4], uint16(lclusterNonHead)<<12|1)
+	binary.LittleEndian.PutUint32(pack[4:8], 99)
+	r := newIndexReader(32, 2, true, &mapHeader{}, 12)
+	r.b = pack
+	if r.compact4bInitial != 0 || r.end() != 40 {
+		t.Fatalf("mismatched layout of %d initial indexes ending at %d", r.compact4bInitial, r.end())
+	}
+	head, err := r.index(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &lclusterIndex{lclusterType: lclusterHead1, clusterOffset: 0x123, blockAddr: 100}
+	if *head != *expected {
+		t.Errorf("mismatched head %+v instead of %+v", head, expected)
+	}
+	nonHead, err := r.index(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nonHead.lclusterType != lclusterNonHead || nonHead.blockCount != 0 {
+		t.Errorf("mismatched index %+v", nonHead)
+	}
+	if _, err := r.index(2); err == nil {
+		t.Errorf("read index past the end without error")
+	}
+}
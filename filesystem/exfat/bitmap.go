@@ -0,0 +1,79 @@
+package exfat
+
+// allocationBitmap records which clusters of the cluster heap are in use, one bit per cluster starting with
+// cluster 2 in the lowest bit of the first byte
+type allocationBitmap struct {
+	bits         []byte
+	clusterCount uint32
+}
+
+func newAllocationBitmap(clusterCount uint32) *allocationBitmap {
+	return &allocationBitmap{
+		bits:         make([]byte, (clusterCount+7)/8),
+		clusterCount: clusterCount,
+	}
+}
+
+// allocationBitmapFromBytes reads the bitmap for clusterCount clusters. Any bits past the last cluster are ignored.
+func allocationBitmapFromBytes(b []byte, clusterCount uint32) *allocationBitmap {
+	bm := newAllocationBitmap(clusterCount)
+	copy(bm.bits, b)
+	return bm
+}
+
+func (bm *allocationBitmap) toBytes() []byte {
+	b := make([]byte, len(bm.bits))
+	copy(b, bm.bits)
+	return b
+}
+
+// isAllocated reports whether a cluster is in use. Clusters outside the heap are reported as in use, so that
+// they are never allocated.
+func (bm *allocationBitmap) isAllocated(cluster uint32) bool {
+	if cluster < 2 || cluster >= bm.clusterCount+2 {
+		return true
+	}
+	i := cluster - 2
+	return bm.bits[i/8]&(1<<(i%8)) != 0
+}
+
+func (bm *allocationBitmap) set(cluster uint32) {
+	i := cluster - 2
+	bm.bits[i/8] |= 1 << (i % 8)
+}
+
+func (bm *allocationBitmap) clear(cluster uint32) {
+	i := cluster - 2
+	bm.bits[i/8] &^= 1 << (i % 8)
+}
+
+// free returns the number of clusters not in use
+func (bm *allocationBitmap) free() uint32 {
+	var count uint32
+	for c := uint32(2); c < bm.clusterCount+2; c++ {
+		if !bm.isAllocated(c) {
+			count++
+		}
+	}
+	return count
+}
+
+// findContiguous finds the first run of count free clusters, returning the first cluster of the run,
+// or 0 if there is none
+func (bm *allocationBitmap) findContiguous(count uint32) uint32 {
+	var start, run uint32
+	for c := uint32(2); c < bm.clusterCount+2; c++ {
+		if bm.isAllocated(c) {
+			run = 0
+			continue
+		}
+		if run == 0 {
+			start = c
+		}
+		run++
+		if run == count {
+			return start
+		}
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package exfat
+
+import "testing"
+
+func TestAllocationBitmap(t *testing.T) {
+	bm := newAllocationBitmap(20)
+	if len(bm.bits) != 3 {
+		t.Fatalf("bitmap for 20 clusters is %d bytes, expected 3", len(bm.bits))
+	}
+	for _, c := range []uint32{2, 3, 5, 9, 10} {
+		bm.set(c)
+	}
+	if free := bm.free(); free != 15 {
+		t.Errorf("free clusters %d, expected 15", free)
+	}
+	if b := bm.toBytes(); b[0] != 0x8b || b[1] != 0x01 {
+		t.Errorf("mismatched bitmap bytes % x", b)
+	}
+	tests := []struct {
+		count uint32
+		first uint32
+	}{
+		{1, 4},
+		{2, 6},
+		{3, 6},
+		{4, 11},
+		{11, 11},
+		{12, 0},
+	}
+	for _, tt := range tests {
+		if first := bm.findContiguous(tt.count); first != tt.first {
+			t.Errorf("first of %d contiguous clusters %d, expected %d", tt.count, first, tt.first)
+		}
+	}
+	bm.clear(3)
+	if bm.isAllocated(3) {
+		t.Errorf("cluster 3 still allocated after clear")
+	}
+	for _, c := range []uint32{0, 1, 22} {
+		if !bm.isAllocated(c) {
+			t.Errorf("cluster %d outside the heap not reported allocated", c)
+		}
+	}
+	read := allocationBitmapFromBytes(bm.toBytes(), 20)
+	if string(read.bits) != string(bm.bits) {
+		t.Errorf("mismatched bitmap after reading back")
+	}
+}
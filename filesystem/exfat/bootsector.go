@@ -0,0 +1,169 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// fileSystemName is the fixed name at the start of every exFAT boot sector
+	fileSystemName = "EXFAT   "
+	// fileSystemRevision is the version of the specification we write, 1.00
+	fileSystemRevision uint16 = 0x0100
+	// bootSignature ends the boot sector and each of the extended boot sectors
+	bootSignature uint16 = 0xaa55
+	// bootRegionSectors is the number of sectors in each of the main and backup boot regions
+	bootRegionSectors = 12
+	// extendedBootSectors is the number of extended boot sectors after the boot sector
+	extendedBootSectors = 8
+	// percentInUseUnknown marks the percent of the cluster heap in use as not available
+	percentInUseUnknown uint8 = 0xff
+	// driveSelectFixed is the drive number for a fixed disk
+	driveSelectFixed uint8 = 0x80
+)
+
+// bootSector is the main boot sector of an exFAT filesystem, the first sector of the boot region.
+// All offsets and lengths are in sectors, except where noted.
+type bootSector struct {
+	partitionOffset        uint64
+	volumeLength           uint64
+	fatOffset              uint32
+	fatLength              uint32
+	clusterHeapOffset      uint32
+	clusterCount           uint32
+	firstClusterOfRoot     uint32
+	volumeSerialNumber     uint32
+	fileSystemRevision     uint16
+	volumeFlags            uint16
+	bytesPerSectorShift    uint8
+	sectorsPerClusterShift uint8
+	numberOfFats           uint8
+	driveSelect            uint8
+	percentInUse           uint8
+}
+
+func (bs *bootSector) equal(a *bootSector) bool {
+	if (bs == nil && a != nil) || (a == nil && bs != nil) {
+		return false
+	}
+	if bs == nil && a == nil {
+		return true
+	}
+	return *bs == *a
+}
+
+func (bs *bootSector) bytesPerSector() int {
+	return 1 << bs.bytesPerSectorShift
+}
+
+func (bs *bootSector) bytesPerCluster() int {
+	return 1 << (bs.bytesPerSectorShift + bs.sectorsPerClusterShift)
+}
+
+// bootSectorFromBytes reads the boot sector from the first 512 bytes of the boot region
+func bootSectorFromBytes(b []byte) (*bootSector, error) {
+	if len(b) < 512 {
+		return nil, fmt.Errorf("cannot read exFAT boot sector from %d bytes, must be at least 512", len(b))
+	}
+	if string(b[3:11]) != fileSystemName {
+		return nil, fmt.Errorf("invalid file system name %q, not exFAT", b[3:11])
+	}
+	if signature := binary.LittleEndian.Uint16(b[510:512]); signature != bootSignature {
+		return nil, fmt.Errorf("invalid boot sector signature %#04x", signature)
+	}
+	for _, c := range b[11:64] {
+		if c != 0 {
+			return nil, fmt.Errorf("exFAT boot sector has non-zero bytes where a FAT BIOS Parameter Block would be")
+		}
+	}
+	bs := bootSector{
+		partitionOffset:        binary.LittleEndian.Uint64(b[64:72]),
+		volumeLength:           binary.LittleEndian.Uint64(b[72:80]),
+		fatOffset:              binary.LittleEndian.Uint32(b[80:84]),
+		fatLength:              binary.LittleEndian.Uint32(b[84:88]),
+		clusterHeapOffset:      binary.LittleEndian.Uint32(b[88:92]),
+		clusterCount:           binary.LittleEndian.Uint32(b[92:96]),
+		firstClusterOfRoot:     binary.LittleEndian.Uint32(b[96:100]),
+		volumeSerialNumber:     binary.LittleEndian.Uint32(b[100:104]),
+		fileSystemRevision:     binary.LittleEndian.Uint16(b[104:106]),
+		volumeFlags:            binary.LittleEndian.Uint16(b[106:108]),
+		bytesPerSectorShift:    b[108],
+		sectorsPerClusterShift: b[109],
+		numberOfFats:           b[110],
+		driveSelect:            b[111],
+		percentInUse:           b[112],
+	}
+	switch {
+	case bs.fileSystemRevision>>8 != 1:
+		return nil, fmt.Errorf("unsupported exFAT revision %d.%02d", bs.fileSystemRevision>>8, bs.fileSystemRevision&0xff)
+	case bs.bytesPerSectorShift < 9 || bs.bytesPerSectorShift > 12:
+		return nil, fmt.Errorf("invalid bytes per sector shift %d, must be between 9 and 12", bs.bytesPerSectorShift)
+	case bs.sectorsPerClusterShift > 25-bs.bytesPerSectorShift:
+		return nil, fmt.Errorf("invalid sectors per cluster shift %d, clusters cannot be larger than 32MB", bs.sectorsPerClusterShift)
+	case bs.numberOfFats != 1 && bs.numberOfFats != 2:
+		return nil, fmt.Errorf("invalid number of FATs %d, must be 1 or 2", bs.numberOfFats)
+	case bs.firstClusterOfRoot < 2 || bs.firstClusterOfRoot > bs.clusterCount+1:
+		return nil, fmt.Errorf("invalid first cluster of root directory %d", bs.firstClusterOfRoot)
+	}
+	return &bs, nil
+}
+
+// toBytes returns the boot sector as a single sector of bytesPerSector bytes ready to write to disk
+func (bs *bootSector) toBytes() []byte {
+	b := make([]byte, bs.bytesPerSector())
+	// jump instruction, then the name
+	copy(b[0:3], []byte{0xeb, 0x76, 0x90})
+	copy(b[3:11], fileSystemName)
+	binary.LittleEndian.PutUint64(b[64:72], bs.partitionOffset)
+	binary.LittleEndian.PutUint64(b[72:80], bs.volumeLength)
+	binary.LittleEndian.PutUint32(b[80:84], bs.fatOffset)
+	binary.LittleEndian.PutUint32(b[84:88], bs.fatLength)
+	binary.LittleEndian.PutUint32(b[88:92], bs.clusterHeapOffset)
+	binary.LittleEndian.PutUint32(b[92:96], bs.clusterCount)
+	binary.LittleEndian.PutUint32(b[96:100], bs.firstClusterOfRoot)
+	binary.LittleEndian.PutUint32(b[100:104], bs.volumeSerialNumber)
+	binary.LittleEndian.PutUint16(b[104:106], bs.fileSystemRevision)
+	binary.LittleEndian.PutUint16(b[106:108], bs.volumeFlags)
+	b[108] = bs.bytesPerSectorShift
+	b[109] = bs.sectorsPerClusterShift
+	b[110] = bs.numberOfFats
+	b[111] = bs.driveSelect
+	b[112] = bs.percentInUse
+	// the boot code just halts
+	for i := 120; i < 510; i++ {
+		b[i] = 0xf4
+	}
+	binary.LittleEndian.PutUint16(b[510:512], bootSignature)
+	return b
+}
+
+// bootRegion returns the whole boot region: the boot sector, the extended boot sectors, the OEM parameters,
+// a reserved sector and the boot checksum sector
+func (bs *bootSector) bootRegion() []byte {
+	sectorSize := bs.bytesPerSector()
+	b := make([]byte, bootRegionSectors*sectorSize)
+	copy(b, bs.toBytes())
+	for i := 1; i <= extendedBootSectors; i++ {
+		end := (i + 1) * sectorSize
+		binary.LittleEndian.PutUint16(b[end-2:end], bootSignature)
+	}
+	checksum := bootChecksum(b[:11*sectorSize])
+	for i := 11 * sectorSize; i < len(b); i += 4 {
+		binary.LittleEndian.PutUint32(b[i:i+4], checksum)
+	}
+	return b
+}
+
+// bootChecksum calculates the checksum of the first 11 sectors of the boot region, skipping the volume flags
+// and percent in use, which change without the checksum being recalculated
+func bootChecksum(b []byte) uint32 {
+	var checksum uint32
+	for i, c := range b {
+		if i == 106 || i == 107 || i == 112 {
+			continue
+		}
+		checksum = (checksum << 31) | (checksum >> 1)
+		checksum += uint32(c)
+	}
+	return checksum
+}
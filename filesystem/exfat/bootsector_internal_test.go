@@ -0,0 +1,89 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func getValidBootSector() *bootSector {
+	return &bootSector{
+		partitionOffset:        2048,
+		volumeLength:           20480,
+		fatOffset:              24,
+		fatLength:              20,
+		clusterHeapOffset:      48,
+		clusterCount:           2554,
+		firstClusterOfRoot:     4,
+		volumeSerialNumber:     0x12345678,
+		fileSystemRevision:     fileSystemRevision,
+		bytesPerSectorShift:    9,
+		sectorsPerClusterShift: 3,
+		numberOfFats:           1,
+		driveSelect:            driveSelectFixed,
+		percentInUse:           percentInUseUnknown,
+	}
+}
+
+func TestBootSectorFromBytes(t *testing.T) {
+	valid := getValidBootSector()
+	tests := []struct {
+		name   string
+		modify func(b []byte)
+		err    string
+	}{
+		{"valid", func(b []byte) {}, ""},
+		{"name", func(b []byte) { copy(b[3:11], "MSDOS5.0") }, "invalid file system name"},
+		{"signature", func(b []byte) { b[511] = 0 }, "invalid boot sector signature"},
+		{"bpb", func(b []byte) { b[11] = 2 }, "exFAT boot sector has non-zero bytes"},
+		{"revision", func(b []byte) { b[105] = 2 }, "unsupported exFAT revision"},
+		{"sector shift", func(b []byte) { b[108] = 13 }, "invalid bytes per sector shift"},
+		{"cluster shift", func(b []byte) { b[109] = 17 }, "invalid sectors per cluster shift"},
+		{"fats", func(b []byte) { b[110] = 0 }, "invalid number of FATs"},
+		{"root", func(b []byte) { binary.LittleEndian.PutUint32(b[96:100], 1) }, "invalid first cluster of root directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := valid.toBytes()
+			tt.modify(b)
+			bs, err := bootSectorFromBytes(b)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err == "" && !bs.equal(valid):
+				t.Errorf("mismatched boot sector, actual %#v expected %#v", bs, valid)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("mismatched error, actual %v expected prefix %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBootRegion(t *testing.T) {
+	bs := getValidBootSector()
+	b := bs.bootRegion()
+	if len(b) != bootRegionSectors*512 {
+		t.Fatalf("boot region is %d bytes, expected %d", len(b), bootRegionSectors*512)
+	}
+	for i := 1; i <= extendedBootSectors; i++ {
+		if signature := binary.LittleEndian.Uint32(b[(i+1)*512-4:]); signature != 0xaa550000 {
+			t.Errorf("extended boot sector %d has signature %#08x", i, signature)
+		}
+	}
+	checksum := bootChecksum(b[:11*512])
+	for i := 11 * 512; i < len(b); i += 4 {
+		if c := binary.LittleEndian.Uint32(b[i:]); c != checksum {
+			t.Fatalf("checksum sector has %#08x at %d, expected %#08x", c, i, checksum)
+		}
+	}
+	// the volume flags and percent in use can change without the checksum
+	bs.volumeFlags = 0x2
+	bs.percentInUse = 50
+	if c := bootChecksum(bs.bootRegion()[:11*512]); c != checksum {
+		t.Errorf("checksum changed to %#08x with the volume flags, expected %#08x", c, checksum)
+	}
+	bs.volumeSerialNumber++
+	if c := bootChecksum(bs.bootRegion()[:11*512]); c == checksum {
+		t.Errorf("checksum did not change with the serial number")
+	}
+}
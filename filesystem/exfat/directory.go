@@ -0,0 +1,13 @@
+package exfat
+
+// Directory represents a single directory in an exFAT filesystem
+type Directory struct {
+	entry   *directoryEntry // entry is the entry for the directory in its parent, nil for the root directory
+	parent  *Directory
+	entries []*directoryEntry
+	other   [][]byte // other holds any entry sets that are not files or directories, to be written back as they are
+}
+
+func (d *Directory) isRoot() bool {
+	return d.entry == nil
+}
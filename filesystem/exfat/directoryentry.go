@@ -0,0 +1,377 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// types of directory entries. The high bit marks an entry as in use; clearing it deletes the entry.
+const (
+	entryTypeEndOfDirectory   uint8 = 0x00
+	entryTypeAllocationBitmap uint8 = 0x81
+	entryTypeUpcaseTable      uint8 = 0x82
+	entryTypeVolumeLabel      uint8 = 0x83
+	entryTypeFile             uint8 = 0x85
+	entryTypeStreamExtension  uint8 = 0xc0
+	entryTypeFileName         uint8 = 0xc1
+	entryTypeInUse            uint8 = 0x80
+	// entryTypeSecondary is set for secondary entries, which belong to the primary entry before them
+	entryTypeSecondary uint8 = 0x40
+)
+
+// file attributes in a file directory entry
+const (
+	attributeReadOnly  uint16 = 0x01
+	attributeHidden    uint16 = 0x02
+	attributeSystem    uint16 = 0x04
+	attributeDirectory uint16 = 0x10
+	attributeArchive   uint16 = 0x20
+)
+
+// general secondary flags in a stream extension entry
+const (
+	flagAllocationPossible uint8 = 0x01
+	flagNoFatChain         uint8 = 0x02
+)
+
+const (
+	directoryEntrySize = 32
+	// fileNameEntryChars is the number of UTF-16 characters in each file name entry
+	fileNameEntryChars = 15
+	// maxFileNameLength is the longest a file name can be, in UTF-16 characters
+	maxFileNameLength = 255
+	// maxVolumeLabelLength is the longest a volume label can be, in UTF-16 characters
+	maxVolumeLabelLength = 11
+	// invalidFileNameChars are not allowed in file names, along with the control characters below 0x20
+	invalidFileNameChars = "\"*/:<>?\\|"
+)
+
+// directoryEntry is a single file or directory, stored in its parent as a set of directory entries: a file
+// entry, a stream extension entry, and as many file name entries as the name needs
+type directoryEntry struct {
+	name            string
+	isSubdirectory  bool
+	isReadOnly      bool
+	isHidden        bool
+	isSystem        bool
+	isArchive       bool
+	createTime      time.Time
+	modifyTime      time.Time
+	accessTime      time.Time
+	firstCluster    uint32
+	dataLength      uint64
+	validDataLength uint64
+	noFatChain      bool
+}
+
+func (de *directoryEntry) equal(a *directoryEntry) bool {
+	if (de == nil && a != nil) || (a == nil && de != nil) {
+		return false
+	}
+	if de == nil && a == nil {
+		return true
+	}
+	return de.name == a.name &&
+		de.isSubdirectory == a.isSubdirectory &&
+		de.isReadOnly == a.isReadOnly &&
+		de.isHidden == a.isHidden &&
+		de.isSystem == a.isSystem &&
+		de.isArchive == a.isArchive &&
+		de.createTime.Equal(a.createTime) &&
+		de.modifyTime.Equal(a.modifyTime) &&
+		de.accessTime.Equal(a.accessTime) &&
+		de.firstCluster == a.firstCluster &&
+		de.dataLength == a.dataLength &&
+		de.validDataLength == a.validDataLength &&
+		de.noFatChain == a.noFatChain
+}
+
+func (de *directoryEntry) attributes() uint16 {
+	var attrs uint16
+	if de.isReadOnly {
+		attrs |= attributeReadOnly
+	}
+	if de.isHidden {
+		attrs |= attributeHidden
+	}
+	if de.isSystem {
+		attrs |= attributeSystem
+	}
+	if de.isSubdirectory {
+		attrs |= attributeDirectory
+	}
+	if de.isArchive {
+		attrs |= attributeArchive
+	}
+	return attrs
+}
+
+// toBytes returns the whole entry set for the file, with its checksum, ready to write to its directory
+func (de *directoryEntry) toBytes(upcase *upcaseTable) ([]byte, error) {
+	if err := validateFileName(de.name); err != nil {
+		return nil, err
+	}
+	name := utf16.Encode([]rune(de.name))
+	nameEntries := (len(name) + fileNameEntryChars - 1) / fileNameEntryChars
+	b := make([]byte, (2+nameEntries)*directoryEntrySize)
+
+	// file entry
+	b[0] = entryTypeFile
+	b[1] = uint8(1 + nameEntries)
+	binary.LittleEndian.PutUint16(b[4:6], de.attributes())
+	ts, ms, offset := timestampFromTime(de.createTime)
+	binary.LittleEndian.PutUint32(b[8:12], ts)
+	b[20], b[22] = ms, offset
+	ts, ms, offset = timestampFromTime(de.modifyTime)
+	binary.LittleEndian.PutUint32(b[12:16], ts)
+	b[21], b[23] = ms, offset
+	ts, _, offset = timestampFromTime(de.accessTime)
+	binary.LittleEndian.PutUint32(b[16:20], ts)
+	b[24] = offset
+
+	// stream extension entry
+	stream := b[directoryEntrySize : 2*directoryEntrySize]
+	stream[0] = entryTypeStreamExtension
+	stream[1] = flagAllocationPossible
+	if de.noFatChain {
+		stream[1] |= flagNoFatChain
+	}
+	stream[3] = uint8(len(name))
+	binary.LittleEndian.PutUint16(stream[4:6], upcase.nameHash(de.name))
+	binary.LittleEndian.PutUint64(stream[8:16], de.validDataLength)
+	binary.LittleEndian.PutUint32(stream[20:24], de.firstCluster)
+	binary.LittleEndian.PutUint64(stream[24:32], de.dataLength)
+
+	// file name entries
+	for i := 0; i < nameEntries; i++ {
+		entry := b[(2+i)*directoryEntrySize : (3+i)*directoryEntrySize]
+		entry[0] = entryTypeFileName
+		for j := 0; j < fileNameEntryChars && i*fileNameEntryChars+j < len(name); j++ {
+			binary.LittleEndian.PutUint16(entry[2+2*j:], name[i*fileNameEntryChars+j])
+		}
+	}
+
+	binary.LittleEndian.PutUint16(b[2:4], entrySetChecksum(b))
+	return b, nil
+}
+
+// directoryEntryFromBytes reads a whole entry set for a file, starting with its file entry
+func directoryEntryFromBytes(b []byte) (*directoryEntry, error) {
+	if len(b) < 3*directoryEntrySize || len(b)%directoryEntrySize != 0 {
+		return nil, fmt.Errorf("invalid file directory entry set of %d bytes", len(b))
+	}
+	if b[0] != entryTypeFile {
+		return nil, fmt.Errorf("invalid file directory entry type %#02x", b[0])
+	}
+	if (int(b[1])+1)*directoryEntrySize != len(b) {
+		return nil, fmt.Errorf("file directory entry has %d secondary entries, but set is %d bytes", b[1], len(b))
+	}
+	if checksum, expected := entrySetChecksum(b), binary.LittleEndian.Uint16(b[2:4]); checksum != expected {
+		return nil, fmt.Errorf("file directory entry set checksum %#04x does not match expected %#04x", checksum, expected)
+	}
+	stream := b[directoryEntrySize : 2*directoryEntrySize]
+	if stream[0] != entryTypeStreamExtension {
+		return nil, fmt.Errorf("file directory entry is followed by entry type %#02x instead of a stream extension", stream[0])
+	}
+	attrs := binary.LittleEndian.Uint16(b[4:6])
+	de := directoryEntry{
+		isReadOnly:      attrs&attributeReadOnly != 0,
+		isHidden:        attrs&attributeHidden != 0,
+		isSystem:        attrs&attributeSystem != 0,
+		isSubdirectory:  attrs&attributeDirectory != 0,
+		isArchive:       attrs&attributeArchive != 0,
+		createTime:      timeFromTimestamp(binary.LittleEndian.Uint32(b[8:12]), b[20], b[22]),
+		modifyTime:      timeFromTimestamp(binary.LittleEndian.Uint32(b[12:16]), b[21], b[23]),
+		accessTime:      timeFromTimestamp(binary.LittleEndian.Uint32(b[16:20]), 0, b[24]),
+		noFatChain:      stream[1]&flagNoFatChain != 0,
+		validDataLength: binary.LittleEndian.Uint64(stream[8:16]),
+		firstCluster:    binary.LittleEndian.Uint32(stream[20:24]),
+		dataLength:      binary.LittleEndian.Uint64(stream[24:32]),
+	}
+
+	nameLength := int(stream[3])
+	name := make([]uint16, 0, nameLength)
+	for i := 2 * directoryEntrySize; i < len(b) && len(name) < nameLength; i += directoryEntrySize {
+		if b[i] != entryTypeFileName {
+			continue
+		}
+		for j := 0; j < fileNameEntryChars && len(name) < nameLength; j++ {
+			name = append(name, binary.LittleEndian.Uint16(b[i+2+2*j:]))
+		}
+	}
+	if len(name) != nameLength {
+		return nil, fmt.Errorf("file directory entry set has %d characters of a %d character name", len(name), nameLength)
+	}
+	de.name = string(utf16.Decode(name))
+	return &de, nil
+}
+
+// parseDirectoryEntries reads the entry sets in the contents of a directory, up to the end of directory marker.
+// Files and directories are returned as entries; any other entries in use, such as the allocation bitmap,
+// up-case table and volume label in the root directory, are returned as is, each with its secondary entries.
+func parseDirectoryEntries(b []byte) ([]*directoryEntry, [][]byte, error) {
+	var (
+		entries []*directoryEntry
+		other   [][]byte
+	)
+	for i := 0; i+directoryEntrySize <= len(b); {
+		entryType := b[i]
+		switch {
+		case entryType == entryTypeEndOfDirectory:
+			return entries, other, nil
+		case entryType&entryTypeInUse == 0 || entryType&entryTypeSecondary != 0:
+			// deleted entries, and secondary entries that have lost their primary
+			i += directoryEntrySize
+			continue
+		}
+		// every primary entry starts with its count of secondary entries, except for those defined without any
+		secondaries := int(b[i+1])
+		switch entryType {
+		case entryTypeAllocationBitmap, entryTypeUpcaseTable, entryTypeVolumeLabel:
+			secondaries = 0
+		}
+		end := i + (1+secondaries)*directoryEntrySize
+		if end > len(b) {
+			return nil, nil, fmt.Errorf("directory entry at %d has %d secondary entries, past the end of the directory", i, secondaries)
+		}
+		if entryType == entryTypeFile {
+			de, err := directoryEntryFromBytes(b[i:end])
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not read directory entry at %d: %v", i, err)
+			}
+			entries = append(entries, de)
+		} else {
+			raw := make([]byte, end-i)
+			copy(raw, b[i:end])
+			other = append(other, raw)
+		}
+		i = end
+	}
+	return entries, other, nil
+}
+
+// entrySetChecksum calculates the checksum of an entry set, skipping the checksum itself in the first entry
+func entrySetChecksum(b []byte) uint16 {
+	var checksum uint16
+	for i, c := range b {
+		if i == 2 || i == 3 {
+			continue
+		}
+		checksum = (checksum << 15) | (checksum >> 1)
+		checksum += uint16(c)
+	}
+	return checksum
+}
+
+// validateFileName checks that a name can be used for a file or directory
+func validateFileName(name string) error {
+	length := len(utf16.Encode([]rune(name)))
+	switch {
+	case length == 0:
+		return fmt.Errorf("file name cannot be empty")
+	case length > maxFileNameLength:
+		return fmt.Errorf("file name %s is %d characters, more than the maximum %d", name, length, maxFileNameLength)
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid file name %s", name)
+	}
+	for _, c := range name {
+		if c < 0x20 || strings.ContainsRune(invalidFileNameChars, c) {
+			return fmt.Errorf("invalid character %q in file name %s", c, name)
+		}
+	}
+	return nil
+}
+
+// allocationBitmapEntry returns the directory entry for the allocation bitmap, in the root directory
+func allocationBitmapEntry(firstCluster uint32, length uint64) []byte {
+	b := make([]byte, directoryEntrySize)
+	b[0] = entryTypeAllocationBitmap
+	binary.LittleEndian.PutUint32(b[20:24], firstCluster)
+	binary.LittleEndian.PutUint64(b[24:32], length)
+	return b
+}
+
+// upcaseTableEntry returns the directory entry for the up-case table, in the root directory
+func upcaseTableEntry(checksum, firstCluster uint32, length uint64) []byte {
+	b := make([]byte, directoryEntrySize)
+	b[0] = entryTypeUpcaseTable
+	binary.LittleEndian.PutUint32(b[4:8], checksum)
+	binary.LittleEndian.PutUint32(b[20:24], firstCluster)
+	binary.LittleEndian.PutUint64(b[24:32], length)
+	return b
+}
+
+// volumeLabelEntry returns the directory entry for the volume label, in the root directory
+func volumeLabelEntry(label string) ([]byte, error) {
+	chars := utf16.Encode([]rune(label))
+	if len(chars) > maxVolumeLabelLength {
+		return nil, fmt.Errorf("invalid volume label: too long at %d characters, maximum is %d", len(chars), maxVolumeLabelLength)
+	}
+	b := make([]byte, directoryEntrySize)
+	b[0] = entryTypeVolumeLabel
+	b[1] = uint8(len(chars))
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(b[2+2*i:], c)
+	}
+	return b, nil
+}
+
+// volumeLabelFromEntry reads the label from its directory entry
+func volumeLabelFromEntry(b []byte) string {
+	count := int(b[1])
+	if count > maxVolumeLabelLength {
+		count = maxVolumeLabelLength
+	}
+	chars := make([]uint16, count)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[2+2*i:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// timestampFromTime converts a time to an exFAT timestamp, with its 10ms increment and UTC offset. Times are
+// always stored in UTC, with an offset of 0 marked as valid.
+func timestampFromTime(t time.Time) (timestamp uint32, increment, utcOffset uint8) {
+	t = t.UTC()
+	switch {
+	case t.Year() < 1980:
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	case t.Year() > 2107:
+		t = time.Date(2107, 12, 31, 23, 59, 59, 990000000, time.UTC)
+	}
+	timestamp = uint32(t.Year()-1980)<<25 |
+		uint32(t.Month())<<21 |
+		uint32(t.Day())<<16 |
+		uint32(t.Hour())<<11 |
+		uint32(t.Minute())<<5 |
+		uint32(t.Second()/2)
+	increment = uint8((t.Second()%2)*100 + t.Nanosecond()/10000000)
+	return timestamp, increment, 0x80
+}
+
+// timeFromTimestamp converts an exFAT timestamp back to a time. A timestamp without a valid UTC offset is in
+// local time.
+func timeFromTimestamp(timestamp uint32, increment, utcOffset uint8) time.Time {
+	loc := time.Local
+	if utcOffset&0x80 != 0 {
+		// the offset is a signed 7-bit count of 15 minute intervals
+		offset := int(int8(utcOffset<<1) >> 1)
+		loc = time.FixedZone("", offset*15*60)
+	}
+	if increment > 199 {
+		increment = 0
+	}
+	return time.Date(
+		int(timestamp>>25)+1980,
+		time.Month(timestamp>>21&0x0f),
+		int(timestamp>>16&0x1f),
+		int(timestamp>>11&0x1f),
+		int(timestamp>>5&0x3f),
+		int(timestamp&0x1f)*2+int(increment)/100,
+		int(increment%100)*10000000,
+		loc,
+	)
+}
@@ -0,0 +1,134 @@
+package exfat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirectoryEntryRoundTrip(t *testing.T) {
+	u := defaultUpcaseTable()
+	modified := time.Date(2021, 6, 15, 13, 45, 31, 250000000, time.UTC)
+	tests := []*directoryEntry{
+		{name: "a", isArchive: true, createTime: modified, modifyTime: modified, accessTime: time.Date(2021, 6, 15, 13, 45, 30, 0, time.UTC)},
+		{name: "exactly15chars.", isSubdirectory: true, firstCluster: 10, dataLength: 4096, validDataLength: 4096, noFatChain: true},
+		{name: strings.Repeat("ü", 40), isReadOnly: true, isHidden: true, isSystem: true, firstCluster: 7, dataLength: 5000000000, validDataLength: 1000},
+		{name: "日本語のファイル名.txt", firstCluster: 0xfffffff6, dataLength: 1, validDataLength: 1},
+	}
+	for _, de := range tests {
+		t.Run(de.name, func(t *testing.T) {
+			// zero times come back as the earliest exFAT can store
+			for _, tm := range []*time.Time{&de.createTime, &de.modifyTime, &de.accessTime} {
+				if tm.IsZero() {
+					*tm = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+				}
+			}
+			b, err := de.toBytes(u)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			nameLength := len([]rune(de.name))
+			if expected := (2 + (nameLength+14)/15) * directoryEntrySize; len(b) != expected {
+				t.Errorf("entry set is %d bytes, expected %d", len(b), expected)
+			}
+			read, err := directoryEntryFromBytes(b)
+			if err != nil {
+				t.Fatalf("unexpected error reading back: %v", err)
+			}
+			if !read.equal(de) {
+				t.Errorf("mismatched entry, actual %#v expected %#v", read, de)
+			}
+			entries, other, err := parseDirectoryEntries(append(b, make([]byte, directoryEntrySize)...))
+			if err != nil || len(entries) != 1 || len(other) != 0 {
+				t.Errorf("parsed %d entries and %d others with error %v", len(entries), len(other), err)
+			}
+			b[40]++
+			if _, err := directoryEntryFromBytes(b); err == nil || !strings.Contains(err.Error(), "checksum") {
+				t.Errorf("mismatched error for bad checksum: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseDirectoryEntries(t *testing.T) {
+	u := defaultUpcaseTable()
+	file, err := (&directoryEntry{name: "file"}).toBytes(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deleted := make([]byte, len(file))
+	copy(deleted, file)
+	for i := 0; i < len(deleted); i += directoryEntrySize {
+		deleted[i] &^= entryTypeInUse
+	}
+	label, err := volumeLabelEntry("LABEL")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b []byte
+	b = append(b, allocationBitmapEntry(2, 100)...)
+	b = append(b, upcaseTableEntry(0xe619d30d, 3, 5836)...)
+	b = append(b, label...)
+	b = append(b, deleted...)
+	b = append(b, file...)
+	b = append(b, make([]byte, directoryEntrySize)...)
+	// anything after the end of the directory is ignored
+	b = append(b, file...)
+
+	entries, other, err := parseDirectoryEntries(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].name != "file" {
+		t.Errorf("mismatched entries %v", entries)
+	}
+	if len(other) != 3 {
+		t.Fatalf("%d other entries, expected 3", len(other))
+	}
+	if l := volumeLabelFromEntry(other[2]); l != "LABEL" {
+		t.Errorf("mismatched label %s", l)
+	}
+}
+
+func TestValidateFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"valid name.txt", ""},
+		{"", "file name cannot be empty"},
+		{"..", "invalid file name"},
+		{"a:b", "invalid character"},
+		{"a\x01", "invalid character"},
+		{strings.Repeat("a", 255), ""},
+		{strings.Repeat("a", 256), "file name"},
+	}
+	for _, tt := range tests {
+		err := validateFileName(tt.name)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("unexpected error for %q: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+			t.Errorf("mismatched error for %q, actual %v expected %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tm := time.Date(2023, 2, 28, 23, 59, 59, 990000000, time.UTC)
+	ts, inc, offset := timestampFromTime(tm)
+	if inc != 199 || offset != 0x80 {
+		t.Errorf("increment %d and offset %#02x, expected 199 and 0x80", inc, offset)
+	}
+	if back := timeFromTimestamp(ts, inc, offset); !back.Equal(tm) {
+		t.Errorf("mismatched time, actual %v expected %v", back, tm)
+	}
+	// UTC+10:00 is 40 15 minute intervals
+	if back := timeFromTimestamp(ts, inc, 0x80|40); !back.Equal(tm.Add(-10 * time.Hour)) {
+		t.Errorf("mismatched time with offset, actual %v", back)
+	}
+	// UTC-05:00 is -20 intervals, stored in 7 bits
+	if back := timeFromTimestamp(ts, inc, 0x80|(0x80-20)); !back.Equal(tm.Add(5 * time.Hour)) {
+		t.Errorf("mismatched time with negative offset, actual %v", back)
+	}
+}
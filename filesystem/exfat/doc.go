@@ -0,0 +1,11 @@
+// Package exfat provides utilities to interact with, manipulate and create an exFAT filesystem on a block device or
+// a disk image.
+//
+// Files and directories are allocated contiguously wherever there is room, and recorded with the NoFatChain flag,
+// so that the FAT is not needed for them at all; a file that cannot grow in place falls back to a FAT chain.
+//
+// references:
+//
+//	https://learn.microsoft.com/en-us/windows/win32/fileio/exfat-specification
+//	https://en.wikipedia.org/wiki/ExFAT
+package exfat
@@ -0,0 +1,1123 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// fatMediaType is the first entry of the FAT
+	fatMediaType uint32 = 0xfffffff8
+	// fatEOC marks the last cluster of a chain in the FAT
+	fatEOC uint32 = 0xffffffff
+	// maxClusterCount is the most clusters an exFAT cluster heap can have
+	maxClusterCount uint32 = 0xfffffff5
+	// minFatOffset is the first sector the FAT can start at, after the main and backup boot regions
+	minFatOffset uint32 = 2 * bootRegionSectors
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	bootSector      bootSector
+	fat             []uint32
+	bitmap          *allocationBitmap
+	bitmapCluster   uint32
+	upcase          *upcaseTable
+	upcaseCluster   uint32
+	upcaseLength    uint64
+	upcaseChecksum  uint32
+	label           string
+	bytesPerCluster int
+	dataStart       int64 // where the cluster heap starts, in bytes from the start of the filesystem
+	size            int64
+	start           int64
+	file            util.File
+}
+
+// Equal compare if two filesystems are equal
+func (fs *FileSystem) Equal(a *FileSystem) bool {
+	if len(fs.fat) != len(a.fat) {
+		return false
+	}
+	for i := range fs.fat {
+		if fs.fat[i] != a.fat[i] {
+			return false
+		}
+	}
+	return fs.file == a.file &&
+		fs.bootSector.equal(&a.bootSector) &&
+		string(fs.bitmap.bits) == string(a.bitmap.bits) &&
+		fs.bitmapCluster == a.bitmapCluster &&
+		fs.upcaseCluster == a.upcaseCluster &&
+		fs.upcaseChecksum == a.upcaseChecksum &&
+		fs.label == a.label
+}
+
+// Create creates an exFAT filesystem in a given file or device
+//
+// requires the util.File where to create the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File to create the filesystem,
+// and blocksize is is the logical blocksize to use for creating the filesystem
+//
+// note that you are *not* required to create the filesystem on the entire disk. You could have a disk of size
+// 20GB, and create a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for creating filesystems on disk partitions.
+//
+// If the provided blocksize is 0, it will use the default of 512 bytes. Otherwise it must be a power of 2
+// from 512 to 4096 bytes.
+//
+// The cluster size is picked by size, as Windows does: 4KB up to 256MB, 32KB up to 32GB and 128KB beyond.
+func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*FileSystem, error) {
+	if blocksize == 0 {
+		blocksize = 512
+	}
+	sectorShift := uint8(0)
+	for s := blocksize; s > 1; s >>= 1 {
+		sectorShift++
+	}
+	if blocksize != 1<<sectorShift || sectorShift < 9 || sectorShift > 12 {
+		return nil, fmt.Errorf("blocksize for exFAT must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	if size < MinSize {
+		return nil, fmt.Errorf("requested size is smaller than minimum allowed exFAT size, requested %d minimum %d", size, MinSize)
+	}
+	if len([]rune(volumeLabel)) > maxVolumeLabelLength {
+		return nil, fmt.Errorf("invalid volume label: too long at %d characters, maximum is %d", len([]rune(volumeLabel)), maxVolumeLabelLength)
+	}
+
+	clusterSize := defaultClusterSize(size)
+	if clusterSize < blocksize {
+		clusterSize = blocksize
+	}
+	clusterShift := uint8(0)
+	for s := clusterSize / blocksize; s > 1; s >>= 1 {
+		clusterShift++
+	}
+	sectorsPerCluster := uint32(1) << clusterShift
+	totalSectors := uint64(size / blocksize)
+
+	// size the FAT for as many clusters as could possibly fit, and then see how many actually do after it
+	fatOffset := minFatOffset
+	estimate := (totalSectors - uint64(fatOffset)) / uint64(sectorsPerCluster)
+	if estimate > uint64(maxClusterCount) {
+		estimate = uint64(maxClusterCount)
+	}
+	fatLength := uint32(((estimate+2)*4 + uint64(blocksize) - 1) / uint64(blocksize))
+	// keep the cluster heap aligned to the cluster size
+	clusterHeapOffset := (fatOffset + fatLength + sectorsPerCluster - 1) / sectorsPerCluster * sectorsPerCluster
+	if uint64(clusterHeapOffset) >= totalSectors {
+		return nil, fmt.Errorf("requested size %d is too small for the exFAT metadata", size)
+	}
+	clusterCount := (totalSectors - uint64(clusterHeapOffset)) / uint64(sectorsPerCluster)
+	if clusterCount > estimate {
+		clusterCount = estimate
+	}
+
+	now := time.Now()
+	volid := uint32(now.Unix()<<20 | (now.UnixNano() / 1000000))
+
+	fs := &FileSystem{
+		bootSector: bootSector{
+			partitionOffset:        uint64(start / blocksize),
+			volumeLength:           totalSectors,
+			fatOffset:              fatOffset,
+			fatLength:              fatLength,
+			clusterHeapOffset:      clusterHeapOffset,
+			clusterCount:           uint32(clusterCount),
+			volumeSerialNumber:     volid,
+			fileSystemRevision:     fileSystemRevision,
+			bytesPerSectorShift:    sectorShift,
+			sectorsPerClusterShift: clusterShift,
+			numberOfFats:           1,
+			driveSelect:            driveSelectFixed,
+			percentInUse:           percentInUseUnknown,
+		},
+		fat:             make([]uint32, clusterCount+2),
+		bitmap:          newAllocationBitmap(uint32(clusterCount)),
+		upcase:          defaultUpcaseTable(),
+		label:           volumeLabel,
+		bytesPerCluster: int(clusterSize),
+		dataStart:       int64(clusterHeapOffset) * blocksize,
+		size:            size,
+		start:           start,
+		file:            f,
+	}
+	fs.fat[0] = fatMediaType
+	fs.fat[1] = fatEOC
+
+	// the allocation bitmap, up-case table and root directory each take the next clusters, with a FAT chain,
+	// as their directory entries have no way to say they do not need one
+	bitmapBytes := fs.bitmap.toBytes()
+	upcaseBytes := fs.upcase.toBytes()
+	bitmapClusters, _, err := fs.allocateSpace(nil, false, fs.clustersFor(uint64(len(bitmapBytes))), true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate clusters for allocation bitmap: %v", err)
+	}
+	upcaseClusters, _, err := fs.allocateSpace(nil, false, fs.clustersFor(uint64(len(upcaseBytes))), true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate clusters for up-case table: %v", err)
+	}
+	rootClusters, _, err := fs.allocateSpace(nil, false, 1, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate clusters for root directory: %v", err)
+	}
+	fs.bitmapCluster = bitmapClusters[0]
+	fs.upcaseCluster = upcaseClusters[0]
+	fs.upcaseLength = uint64(len(upcaseBytes))
+	fs.upcaseChecksum = upcaseChecksum(upcaseBytes)
+	fs.bootSector.firstClusterOfRoot = rootClusters[0]
+
+	// write the boot regions, the FAT, the allocation bitmap and the up-case table
+	if err := fs.writeBootSector(); err != nil {
+		return nil, err
+	}
+	if err := fs.writeFat(); err != nil {
+		return nil, err
+	}
+	if err := fs.writeBitmap(); err != nil {
+		return nil, err
+	}
+	if err := fs.writeClusters(upcaseClusters, upcaseBytes); err != nil {
+		return nil, fmt.Errorf("unable to write up-case table: %v", err)
+	}
+	if err := fs.writeDirectoryEntries(&Directory{}); err != nil {
+		return nil, fmt.Errorf("unable to write root directory: %v", err)
+	}
+	return fs, nil
+}
+
+// defaultClusterSize the cluster size Windows uses for a volume of a given size
+func defaultClusterSize(size int64) int64 {
+	switch {
+	case size <= 256*MB:
+		return 4 * KB
+	case size <= 32*GB:
+		return 32 * KB
+	default:
+		return 128 * KB
+	}
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// The sector size is taken from the boot sector, so blocksize is only checked: it must be 0, or a power of 2
+// from 512 to 4096 bytes.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	switch blocksize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("blocksize for exFAT must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	if size < MinSize {
+		return nil, fmt.Errorf("requested size is smaller than minimum allowed exFAT size %d", MinSize)
+	}
+
+	b := make([]byte, 512)
+	n, err := file.ReadAt(b, start)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bytes from file: %v", err)
+	}
+	if n < len(b) {
+		return nil, fmt.Errorf("only could read %d bytes from file", n)
+	}
+	bs, err := bootSectorFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("error reading exFAT boot sector: %v", err)
+	}
+	sectorSize := int64(bs.bytesPerSector())
+	if int64(bs.volumeLength)*sectorSize > size {
+		return nil, fmt.Errorf("exFAT volume of %d bytes is larger than the %d bytes available", int64(bs.volumeLength)*sectorSize, size)
+	}
+
+	// the checksum sector repeats the checksum of the rest of the boot region
+	region := make([]byte, bootRegionSectors*sectorSize)
+	if _, err := file.ReadAt(region, start); err != nil {
+		return nil, fmt.Errorf("could not read boot region: %v", err)
+	}
+	checksum := bootChecksum(region[:11*sectorSize])
+	if expected := binary.LittleEndian.Uint32(region[11*sectorSize:]); checksum != expected {
+		return nil, fmt.Errorf("boot region checksum %#08x does not match expected %#08x", checksum, expected)
+	}
+
+	fs := &FileSystem{
+		bootSector:      *bs,
+		bytesPerCluster: bs.bytesPerCluster(),
+		dataStart:       int64(bs.clusterHeapOffset) * sectorSize,
+		size:            size,
+		start:           start,
+		file:            file,
+	}
+
+	// read the FAT
+	fatBytes := make([]byte, (uint64(bs.clusterCount)+2)*4)
+	if uint64(len(fatBytes)) > uint64(bs.fatLength)*uint64(sectorSize) {
+		return nil, fmt.Errorf("FAT of %d sectors is too small for %d clusters", bs.fatLength, bs.clusterCount)
+	}
+	if _, err := file.ReadAt(fatBytes, start+int64(bs.fatOffset)*sectorSize); err != nil {
+		return nil, fmt.Errorf("unable to read FAT: %v", err)
+	}
+	fs.fat = make([]uint32, bs.clusterCount+2)
+	for i := range fs.fat {
+		fs.fat[i] = binary.LittleEndian.Uint32(fatBytes[4*i:])
+	}
+
+	// the root directory holds the allocation bitmap, up-case table and volume label
+	root := &Directory{}
+	if err := fs.readDirectory(root); err != nil {
+		return nil, fmt.Errorf("unable to read root directory: %v", err)
+	}
+	var bitmapLength uint64
+	for _, e := range root.other {
+		switch e[0] {
+		case entryTypeAllocationBitmap:
+			// a second bitmap is only for TexFAT, which keeps a second FAT as well
+			if e[1]&0x1 == 0 {
+				fs.bitmapCluster = binary.LittleEndian.Uint32(e[20:24])
+				bitmapLength = binary.LittleEndian.Uint64(e[24:32])
+			}
+		case entryTypeUpcaseTable:
+			fs.upcaseChecksum = binary.LittleEndian.Uint32(e[4:8])
+			fs.upcaseCluster = binary.LittleEndian.Uint32(e[20:24])
+			fs.upcaseLength = binary.LittleEndian.Uint64(e[24:32])
+		case entryTypeVolumeLabel:
+			fs.label = volumeLabelFromEntry(e)
+		}
+	}
+	if fs.bitmapCluster == 0 {
+		return nil, fmt.Errorf("root directory has no allocation bitmap")
+	}
+	if fs.upcaseCluster == 0 {
+		return nil, fmt.Errorf("root directory has no up-case table")
+	}
+	if bitmapLength < (uint64(bs.clusterCount)+7)/8 {
+		return nil, fmt.Errorf("allocation bitmap of %d bytes is too small for %d clusters", bitmapLength, bs.clusterCount)
+	}
+	bitmapBytes, err := fs.readClusterChain(fs.bitmapCluster, bitmapLength, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read allocation bitmap: %v", err)
+	}
+	fs.bitmap = allocationBitmapFromBytes(bitmapBytes, bs.clusterCount)
+	upcaseBytes, err := fs.readClusterChain(fs.upcaseCluster, fs.upcaseLength, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read up-case table: %v", err)
+	}
+	if checksum := upcaseChecksum(upcaseBytes); checksum != fs.upcaseChecksum {
+		return nil, fmt.Errorf("up-case table checksum %#08x does not match expected %#08x", checksum, fs.upcaseChecksum)
+	}
+	if fs.upcase, err = upcaseTableFromBytes(upcaseBytes); err != nil {
+		return nil, fmt.Errorf("unable to read up-case table: %v", err)
+	}
+	return fs, nil
+}
+
+// writeBootSector writes the main and backup boot regions
+func (fs *FileSystem) writeBootSector() error {
+	region := fs.bootSector.bootRegion()
+	if _, err := fs.file.WriteAt(region, fs.start); err != nil {
+		return fmt.Errorf("unable to write boot region: %v", err)
+	}
+	if _, err := fs.file.WriteAt(region, fs.start+int64(len(region))); err != nil {
+		return fmt.Errorf("unable to write backup boot region: %v", err)
+	}
+	return nil
+}
+
+// writeFat writes the FAT, or both of them for TexFAT
+func (fs *FileSystem) writeFat() error {
+	b := make([]byte, 4*len(fs.fat))
+	for i, e := range fs.fat {
+		binary.LittleEndian.PutUint32(b[4*i:], e)
+	}
+	sectorSize := int64(fs.bootSector.bytesPerSector())
+	for i := int64(0); i < int64(fs.bootSector.numberOfFats); i++ {
+		offset := fs.start + (int64(fs.bootSector.fatOffset)+i*int64(fs.bootSector.fatLength))*sectorSize
+		if _, err := fs.file.WriteAt(b, offset); err != nil {
+			return fmt.Errorf("unable to write FAT: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeBitmap writes the allocation bitmap
+func (fs *FileSystem) writeBitmap() error {
+	b := fs.bitmap.toBytes()
+	clusters, err := fs.getClusterList(fs.bitmapCluster, uint64(len(b)), false)
+	if err != nil {
+		return fmt.Errorf("unable to get clusters of allocation bitmap: %v", err)
+	}
+	if err := fs.writeClusters(clusters, b); err != nil {
+		return fmt.Errorf("unable to write allocation bitmap: %v", err)
+	}
+	return nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeExFAT
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeExFAT
+}
+
+// Mkdir make a directory at the given path. It is equivalent to `mkdir -p`, i.e. idempotent, in that:
+//
+// * It will make the entire tree path if it does not exist
+// * It will not return an error if the path already exists
+func (fs *FileSystem) Mkdir(p string) error {
+	_, err := fs.readDirWithMkdir(p, true)
+	return err
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	dir, err := fs.readDirWithMkdir(p, false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+	}
+	ret := make([]os.FileInfo, len(dir.entries))
+	for i, e := range dir.entries {
+		ret[i] = e.fileInfo()
+	}
+	return ret, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+// or write contents to the file
+//
+// accepts normal os.OpenFile flags
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	if isRoot(p) {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	parentDir, err := fs.readDirWithMkdir(dir, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory entries for %s", dir)
+	}
+	targetEntry := fs.findInDirectory(parentDir, filename)
+	if targetEntry != nil && targetEntry.isSubdirectory {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+
+	// if the file does not exist, and is not opened for os.O_CREATE, return an error
+	if targetEntry == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, fmt.Errorf("target file %s does not exist and was not asked to create", p)
+		}
+		targetEntry, err = fs.mkFile(parentDir, filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file %s: %v", p, err)
+		}
+	}
+	offset := int64(0)
+
+	if flag&os.O_TRUNC == os.O_TRUNC && targetEntry.dataLength != 0 {
+		if err := fs.resizeEntry(targetEntry, 0); err != nil {
+			return nil, fmt.Errorf("unable to truncate %s: %v", p, err)
+		}
+		if err := fs.writeDirectoryEntries(parentDir); err != nil {
+			return nil, fmt.Errorf("error writing directory file %s to disk: %v", p, err)
+		}
+	}
+	if flag&os.O_APPEND == os.O_APPEND {
+		offset = int64(targetEntry.dataLength)
+	}
+	return &File{
+		directoryEntry: targetEntry,
+		isReadWrite:    flag&os.O_RDWR != 0,
+		isAppend:       flag&os.O_APPEND != 0,
+		offset:         offset,
+		filesystem:     fs,
+		parent:         parentDir,
+	}, nil
+}
+
+// Stat returns the FileInfo for a file or directory.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	if isRoot(p) {
+		return FileInfo{name: "/", mode: os.ModeDir, isDir: true}, nil
+	}
+	_, entry, err := fs.findEntry(p)
+	if err != nil {
+		return nil, err
+	}
+	return entry.fileInfo(), nil
+}
+
+// Remove removes a file or an empty directory, freeing its clusters and its directory entry set.
+func (fs *FileSystem) Remove(p string) error {
+	if isRoot(p) {
+		return fmt.Errorf("cannot remove root directory")
+	}
+	parentDir, entry, err := fs.findEntry(p)
+	if err != nil {
+		return err
+	}
+	if entry.isSubdirectory {
+		dir := &Directory{entry: entry, parent: parentDir}
+		if err := fs.readDirectory(dir); err != nil {
+			return fmt.Errorf("could not read directory entries for %s: %v", p, err)
+		}
+		if len(dir.entries) > 0 {
+			return fmt.Errorf("directory %s is not empty", p)
+		}
+	}
+	if err := removeDirectoryEntry(parentDir, entry); err != nil {
+		return err
+	}
+	if err := fs.writeDirectoryEntries(parentDir); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	if err := fs.resizeEntry(entry, 0); err != nil {
+		return fmt.Errorf("unable to remove %s: %v", p, err)
+	}
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it. It returns nil if the path does not exist.
+func (fs *FileSystem) RemoveAll(p string) error {
+	if isRoot(p) {
+		return fmt.Errorf("cannot remove root directory")
+	}
+	_, entry, err := fs.findEntry(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry.isSubdirectory {
+		children, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := fs.RemoveAll(path.Join(p, child.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fs.Remove(p)
+}
+
+// Rename renames or moves a file or directory. If newpath is an existing file, it is replaced, as long as
+// oldpath is not a directory. The parent directory of newpath must exist.
+//
+// As names are case-insensitive, a file can be renamed to a different case of its own name.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if isRoot(oldpath) || isRoot(newpath) {
+		return fmt.Errorf("cannot rename root directory")
+	}
+	_, entry, err := fs.findEntry(oldpath)
+	if err != nil {
+		return err
+	}
+	oldClean, newClean := path.Clean(oldpath), path.Clean(newpath)
+	if oldClean == newClean {
+		return nil
+	}
+	newName := path.Base(newClean)
+	if err := validateFileName(newName); err != nil {
+		return err
+	}
+	if entry.isSubdirectory && strings.HasPrefix(fs.upcaseString(newClean), fs.upcaseString(oldClean)+"/") {
+		return fmt.Errorf("cannot move directory %s into itself", oldpath)
+	}
+
+	// replace any existing file
+	_, target, err := fs.findEntry(newpath)
+	switch {
+	case err == nil && target.firstCluster == entry.firstCluster && target.name == entry.name:
+		// a different case of the same name, so nothing to replace
+	case err == nil && target.isSubdirectory:
+		return fmt.Errorf("cannot replace directory %s", newpath)
+	case err == nil && entry.isSubdirectory:
+		return fmt.Errorf("cannot replace file %s with directory %s", newpath, oldpath)
+	case err == nil:
+		if err := fs.Remove(newpath); err != nil {
+			return fmt.Errorf("unable to replace %s: %v", newpath, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	// read the directories afresh, as removing the target may have changed them
+	oldParent, entry, err := fs.findEntry(oldpath)
+	if err != nil {
+		return err
+	}
+	newParent, err := fs.readDirWithMkdir(path.Dir(newClean), false)
+	if err != nil {
+		return fmt.Errorf("could not read directory entries for %s: %v", path.Dir(newClean), err)
+	}
+
+	// rename in place if it stays in the same directory
+	if fs.upcaseString(path.Dir(oldClean)) == fs.upcaseString(path.Dir(newClean)) {
+		entry.name = newName
+		if err := fs.writeDirectoryEntries(oldParent); err != nil {
+			return fmt.Errorf("error writing directory entries to disk: %v", err)
+		}
+		return nil
+	}
+
+	moved := *entry
+	moved.name = newName
+	newParent.entries = append(newParent.entries, &moved)
+	if err := fs.writeDirectoryEntries(newParent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	// writing the new parent may have grown it, changing its entry in the directory above, which may be the
+	// old parent, so read that again before removing the old entry
+	oldParent, entry, err = fs.findEntry(oldpath)
+	if err != nil {
+		return err
+	}
+	if err := removeDirectoryEntry(oldParent, entry); err != nil {
+		return err
+	}
+	if err := fs.writeDirectoryEntries(oldParent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	return nil
+}
+
+// Truncate changes the size of a file. If it grows, the new bytes are all zeroes; if it shrinks,
+// any clusters no longer needed are freed.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid size %d for exFAT file", size)
+	}
+	if isRoot(p) {
+		return fmt.Errorf("cannot truncate directory %s", p)
+	}
+	parentDir, entry, err := fs.findEntry(p)
+	if err != nil {
+		return err
+	}
+	if entry.isSubdirectory {
+		return fmt.Errorf("cannot truncate directory %s", p)
+	}
+	oldSize := int64(entry.dataLength)
+	if size > oldSize {
+		// writing zeroes takes care of allocating space and of any old data in the clusters
+		f := &File{
+			directoryEntry: entry,
+			isReadWrite:    true,
+			offset:         oldSize,
+			filesystem:     fs,
+			parent:         parentDir,
+		}
+		zeroes := make([]byte, 64*fs.bytesPerCluster)
+		for remaining := size - oldSize; remaining > 0; {
+			chunk := zeroes
+			if remaining < int64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+			if _, err := f.Write(chunk); err != nil {
+				return fmt.Errorf("unable to extend %s: %v", p, err)
+			}
+			remaining -= int64(len(chunk))
+		}
+		return nil
+	}
+	if err := fs.resizeEntry(entry, uint64(size)); err != nil {
+		return fmt.Errorf("unable to resize cluster list: %v", err)
+	}
+	entry.modifyTime = time.Now()
+	if err := fs.writeDirectoryEntries(parentDir); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	return nil
+}
+
+// Statfs get the capacity of the filesystem, with free space counted from the allocation bitmap.
+// exFAT has no inodes, so Files and FreeFiles are 0.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	total, free := fs.bootSector.clusterCount, fs.bitmap.free()
+	clusterSize := int64(fs.bytesPerCluster)
+	return filesystem.Statfs{
+		BlockSize:  clusterSize,
+		TotalBytes: int64(total) * clusterSize,
+		FreeBytes:  int64(free) * clusterSize,
+		UsedBytes:  int64(total-free) * clusterSize,
+	}, nil
+}
+
+// Label get the label of the filesystem from its entry in the root directory, or "" if it has none
+func (fs *FileSystem) Label() string {
+	return fs.label
+}
+
+// SetLabel changes the filesystem label. It can be up to 11 characters; "" removes it.
+func (fs *FileSystem) SetLabel(volumeLabel string) error {
+	if _, err := volumeLabelEntry(volumeLabel); err != nil {
+		return err
+	}
+	root, err := fs.readDirWithMkdir("/", false)
+	if err != nil {
+		return fmt.Errorf("failed to locate root directory: %v", err)
+	}
+	fs.label = volumeLabel
+	if err := fs.writeDirectoryEntries(root); err != nil {
+		return fmt.Errorf("failed to save the root directory to disk: %v", err)
+	}
+	return nil
+}
+
+// clustersFor returns how many clusters are needed to hold size bytes
+func (fs *FileSystem) clustersFor(size uint64) int {
+	return int((size + uint64(fs.bytesPerCluster) - 1) / uint64(fs.bytesPerCluster))
+}
+
+// clusterOffset returns where a cluster starts, in bytes from the start of the file
+func (fs *FileSystem) clusterOffset(cluster uint32) int64 {
+	return fs.start + fs.dataStart + int64(cluster-2)*int64(fs.bytesPerCluster)
+}
+
+// getClusterList returns the clusters that hold length bytes starting at firstCluster. If noFatChain is set
+// they are contiguous; otherwise they are followed through the FAT, as far as the end of the chain if length is 0.
+func (fs *FileSystem) getClusterList(firstCluster uint32, length uint64, noFatChain bool) ([]uint32, error) {
+	if firstCluster == 0 {
+		return nil, nil
+	}
+	maxCluster := fs.bootSector.clusterCount + 1
+	if firstCluster < 2 || firstCluster > maxCluster {
+		return nil, fmt.Errorf("invalid first cluster %d", firstCluster)
+	}
+	count := fs.clustersFor(length)
+	if noFatChain {
+		if uint64(firstCluster)+uint64(count)-1 > uint64(maxCluster) {
+			return nil, fmt.Errorf("%d contiguous clusters from %d run past the end of the cluster heap", count, firstCluster)
+		}
+		clusters := make([]uint32, count)
+		for i := range clusters {
+			clusters[i] = firstCluster + uint32(i)
+		}
+		return clusters, nil
+	}
+	var clusters []uint32
+	for cluster := firstCluster; ; {
+		clusters = append(clusters, cluster)
+		if count > 0 && len(clusters) == count {
+			return clusters, nil
+		}
+		next := fs.fat[cluster]
+		switch {
+		case next == fatEOC:
+			if count > 0 {
+				return nil, fmt.Errorf("cluster chain from %d ends after %d of %d clusters", firstCluster, len(clusters), count)
+			}
+			return clusters, nil
+		case next < 2 || next > maxCluster:
+			return nil, fmt.Errorf("invalid cluster %#x in chain from %d", next, firstCluster)
+		case len(clusters) > int(fs.bootSector.clusterCount):
+			return nil, fmt.Errorf("cluster chain from %d loops", firstCluster)
+		}
+		cluster = next
+	}
+}
+
+// readClusterChain reads length bytes starting at firstCluster
+func (fs *FileSystem) readClusterChain(firstCluster uint32, length uint64, noFatChain bool) ([]byte, error) {
+	clusters, err := fs.getClusterList(firstCluster, length, noFatChain)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(clusters)*fs.bytesPerCluster)
+	for i, c := range clusters {
+		if _, err := fs.file.ReadAt(b[i*fs.bytesPerCluster:(i+1)*fs.bytesPerCluster], fs.clusterOffset(c)); err != nil {
+			return nil, fmt.Errorf("unable to read cluster %d: %v", c, err)
+		}
+	}
+	if length > 0 && length < uint64(len(b)) {
+		b = b[:length]
+	}
+	return b, nil
+}
+
+// writeClusters writes b across clusters, which must be able to hold it
+func (fs *FileSystem) writeClusters(clusters []uint32, b []byte) error {
+	for i, c := range clusters {
+		start := i * fs.bytesPerCluster
+		if start >= len(b) {
+			break
+		}
+		end := start + fs.bytesPerCluster
+		if end > len(b) {
+			end = len(b)
+		}
+		if _, err := fs.file.WriteAt(b[start:end], fs.clusterOffset(c)); err != nil {
+			return fmt.Errorf("unable to write cluster %d: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// allocateSpace grows or shrinks the clusters of a file or directory to count clusters, updating the
+// allocation bitmap and, for those that need it, the FAT.
+//
+// Clusters are kept contiguous wherever they can be, so they need no FAT chain. If they cannot be, because
+// whatever follows them is in use, the existing clusters are given a FAT chain and it is extended. useFat
+// forces a FAT chain, for those that have no way to record that they do not have one, like the root directory.
+//
+// Returns the new list of clusters, and whether they have no FAT chain.
+func (fs *FileSystem) allocateSpace(clusters []uint32, noFatChain bool, count int, useFat bool) ([]uint32, bool, error) {
+	old := len(clusters)
+	switch {
+	case count == old:
+		return clusters, noFatChain, nil
+	case count < old:
+		for _, c := range clusters[count:] {
+			fs.bitmap.clear(c)
+			if !noFatChain {
+				fs.fat[c] = 0
+			}
+		}
+		if err := fs.writeBitmap(); err != nil {
+			return nil, false, err
+		}
+		if !noFatChain {
+			if count > 0 {
+				fs.fat[clusters[count-1]] = fatEOC
+			}
+			if err := fs.writeFat(); err != nil {
+				return nil, false, err
+			}
+		}
+		return clusters[:count], noFatChain, nil
+	}
+
+	need := uint32(count - old)
+	if free := fs.bitmap.free(); free < need {
+		return nil, false, fmt.Errorf("no space left on device, need %d clusters but only %d are free", need, free)
+	}
+	// stay contiguous if we can
+	if !useFat && (old == 0 || noFatChain) {
+		var first uint32
+		if old == 0 {
+			first = fs.bitmap.findContiguous(need)
+		} else if fs.isFreeRun(clusters[old-1]+1, need) {
+			first = clusters[old-1] + 1
+		}
+		if first != 0 {
+			for i := uint32(0); i < need; i++ {
+				fs.bitmap.set(first + i)
+				clusters = append(clusters, first+i)
+			}
+			if err := fs.writeBitmap(); err != nil {
+				return nil, false, err
+			}
+			return clusters, true, nil
+		}
+	}
+
+	// fall back to a FAT chain, which has to include any clusters that had none
+	if noFatChain {
+		for i := 0; i+1 < old; i++ {
+			fs.fat[clusters[i]] = clusters[i+1]
+		}
+		if old > 0 {
+			fs.fat[clusters[old-1]] = fatEOC
+		}
+	}
+	// prefer a single run for the new clusters, or else take the first free ones
+	var newClusters []uint32
+	if first := fs.bitmap.findContiguous(need); first != 0 {
+		for i := uint32(0); i < need; i++ {
+			newClusters = append(newClusters, first+i)
+		}
+	} else {
+		for c := uint32(2); uint32(len(newClusters)) < need; c++ {
+			if !fs.bitmap.isAllocated(c) {
+				newClusters = append(newClusters, c)
+			}
+		}
+	}
+	for _, c := range newClusters {
+		fs.bitmap.set(c)
+		if len(clusters) > 0 {
+			fs.fat[clusters[len(clusters)-1]] = c
+		}
+		clusters = append(clusters, c)
+	}
+	fs.fat[clusters[len(clusters)-1]] = fatEOC
+	if err := fs.writeBitmap(); err != nil {
+		return nil, false, err
+	}
+	if err := fs.writeFat(); err != nil {
+		return nil, false, err
+	}
+	return clusters, false, nil
+}
+
+// isFreeRun reports whether count clusters starting at first are all free
+func (fs *FileSystem) isFreeRun(first, count uint32) bool {
+	for c := first; c < first+count; c++ {
+		if fs.bitmap.isAllocated(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// resizeEntry changes the clusters of a file to hold size bytes, and its length to size. If it grows, the new
+// bytes are whatever was in the clusters, so must be written.
+func (fs *FileSystem) resizeEntry(entry *directoryEntry, size uint64) error {
+	clusters, err := fs.getClusterList(entry.firstCluster, entry.dataLength, entry.noFatChain)
+	if err != nil {
+		return fmt.Errorf("unable to get list of clusters: %v", err)
+	}
+	clusters, noFatChain, err := fs.allocateSpace(clusters, entry.noFatChain, fs.clustersFor(size), false)
+	if err != nil {
+		return err
+	}
+	entry.firstCluster = 0
+	if len(clusters) > 0 {
+		entry.firstCluster = clusters[0]
+	}
+	entry.noFatChain = noFatChain
+	entry.dataLength = size
+	entry.validDataLength = size
+	return nil
+}
+
+// readDirectory reads the entries of a directory from disk
+func (fs *FileSystem) readDirectory(dir *Directory) error {
+	var (
+		b   []byte
+		err error
+	)
+	if dir.isRoot() {
+		b, err = fs.readClusterChain(fs.bootSector.firstClusterOfRoot, 0, false)
+	} else {
+		b, err = fs.readClusterChain(dir.entry.firstCluster, dir.entry.dataLength, dir.entry.noFatChain)
+	}
+	if err != nil {
+		return err
+	}
+	dir.entries, dir.other, err = parseDirectoryEntries(b)
+	return err
+}
+
+// writeDirectoryEntries writes all of the entries of a directory to disk, growing it if it needs more clusters.
+// A subdirectory that grows has its length changed in its parent, which is written too.
+func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
+	b, err := fs.directoryBytes(dir)
+	if err != nil {
+		return err
+	}
+	var clusters []uint32
+	if dir.isRoot() {
+		if clusters, err = fs.getClusterList(fs.bootSector.firstClusterOfRoot, 0, false); err != nil {
+			return fmt.Errorf("unable to get clusters of root directory: %v", err)
+		}
+	} else if clusters, err = fs.getClusterList(dir.entry.firstCluster, dir.entry.dataLength, dir.entry.noFatChain); err != nil {
+		return fmt.Errorf("unable to get clusters of directory %s: %v", dir.entry.name, err)
+	}
+
+	// directories only grow, and always have at least one cluster
+	count := fs.clustersFor(uint64(len(b)))
+	if count < len(clusters) {
+		count = len(clusters)
+	}
+	if count == 0 {
+		count = 1
+	}
+	grown := count != len(clusters)
+	if grown {
+		noFatChain := !dir.isRoot() && dir.entry.noFatChain
+		clusters, noFatChain, err = fs.allocateSpace(clusters, noFatChain, count, dir.isRoot())
+		if err != nil {
+			return fmt.Errorf("unable to allocate clusters for directory: %v", err)
+		}
+		if !dir.isRoot() {
+			dir.entry.firstCluster = clusters[0]
+			dir.entry.noFatChain = noFatChain
+			dir.entry.dataLength = uint64(count * fs.bytesPerCluster)
+			dir.entry.validDataLength = dir.entry.dataLength
+		}
+	}
+	// the rest of the directory is zeroes, which mark the end of it
+	padded := make([]byte, count*fs.bytesPerCluster)
+	copy(padded, b)
+	if err := fs.writeClusters(clusters, padded); err != nil {
+		return err
+	}
+	if grown && !dir.isRoot() {
+		return fs.writeDirectoryEntries(dir.parent)
+	}
+	return nil
+}
+
+// directoryBytes returns the contents of a directory. The root directory starts with the entries for the
+// allocation bitmap, up-case table and volume label, made afresh from the filesystem.
+func (fs *FileSystem) directoryBytes(dir *Directory) ([]byte, error) {
+	var b []byte
+	if dir.isRoot() {
+		b = append(b, allocationBitmapEntry(fs.bitmapCluster, uint64(len(fs.bitmap.bits)))...)
+		b = append(b, upcaseTableEntry(fs.upcaseChecksum, fs.upcaseCluster, fs.upcaseLength)...)
+		if fs.label != "" {
+			label, err := volumeLabelEntry(fs.label)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, label...)
+		}
+	}
+	for _, e := range dir.other {
+		if dir.isRoot() && (e[0] == entryTypeAllocationBitmap || e[0] == entryTypeUpcaseTable || e[0] == entryTypeVolumeLabel) {
+			continue
+		}
+		b = append(b, e...)
+	}
+	for _, e := range dir.entries {
+		set, err := e.toBytes(fs.upcase)
+		if err != nil {
+			return nil, fmt.Errorf("could not create directory entry for %s: %v", e.name, err)
+		}
+		b = append(b, set...)
+	}
+	return b, nil
+}
+
+// mkSubdir make a subdirectory in a directory, with a single empty cluster
+func (fs *FileSystem) mkSubdir(parent *Directory, name string) (*Directory, error) {
+	entry, err := fs.createEntry(parent, name, true)
+	if err != nil {
+		return nil, err
+	}
+	dir := &Directory{entry: entry, parent: parent}
+	// writing the new directory allocates its cluster and writes its parent
+	if err := fs.writeDirectoryEntries(dir); err != nil {
+		return nil, fmt.Errorf("error writing new directory entries to disk: %v", err)
+	}
+	return dir, nil
+}
+
+// mkFile make an empty file in a directory
+func (fs *FileSystem) mkFile(parent *Directory, name string) (*directoryEntry, error) {
+	entry, err := fs.createEntry(parent, name, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.writeDirectoryEntries(parent); err != nil {
+		return nil, fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	return entry, nil
+}
+
+// createEntry adds a new entry, with no clusters, to the in-memory list of entries for a directory
+func (fs *FileSystem) createEntry(parent *Directory, name string, isDir bool) (*directoryEntry, error) {
+	if err := validateFileName(name); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entry := &directoryEntry{
+		name:           name,
+		isSubdirectory: isDir,
+		isArchive:      !isDir,
+		createTime:     now,
+		modifyTime:     now,
+		accessTime:     now,
+	}
+	parent.entries = append(parent.entries, entry)
+	return entry, nil
+}
+
+// findInDirectory finds the entry with a name in a directory, ignoring case, or nil if there is none
+func (fs *FileSystem) findInDirectory(dir *Directory, name string) *directoryEntry {
+	for _, e := range dir.entries {
+		if fs.upcase.equalFold(e.name, name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// findEntry finds the directory entry for p, along with the directory it is in.
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) findEntry(p string) (*Directory, *directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	parentDir, err := fs.readDirWithMkdir(dir, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read directory entries for %s: %w", dir, err)
+	}
+	if e := fs.findInDirectory(parentDir, filename); e != nil {
+		return parentDir, e, nil
+	}
+	return nil, nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+}
+
+// removeDirectoryEntry removes an entry from the in-memory list of entries for a directory
+func removeDirectoryEntry(parent *Directory, entry *directoryEntry) error {
+	for i, e := range parent.entries {
+		if e == entry {
+			parent.entries = append(parent.entries[:i], parent.entries[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("entry %s not found in its directory", entry.name)
+}
+
+// isRoot reports whether p is the root directory
+func isRoot(p string) bool {
+	return path.Clean(strings.ReplaceAll(p, "\\", "/")) == "/"
+}
+
+// upcaseString returns a path up-cased, for comparing paths that differ only in case
+func (fs *FileSystem) upcaseString(p string) string {
+	return string(utf16.Decode(fs.upcase.upcase(p)))
+}
+
+// readDirWithMkdir - walks down a directory tree to the last entry
+// if it does not exist, it may or may not make it
+func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, error) {
+	paths, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	currentDir := &Directory{}
+	if err := fs.readDirectory(currentDir); err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %v", "/", err)
+	}
+	for i, subp := range paths {
+		current := "/" + strings.Join(paths[0:i+1], "/")
+		e := fs.findInDirectory(currentDir, subp)
+		switch {
+		case e != nil && !e.isSubdirectory:
+			return nil, fmt.Errorf("cannot create directory at %s since it is a file", current)
+		case e != nil:
+			currentDir = &Directory{entry: e, parent: currentDir}
+			if err := fs.readDirectory(currentDir); err != nil {
+				return nil, fmt.Errorf("failed to read directory %s: %v", current, err)
+			}
+		case doMake:
+			if currentDir, err = fs.mkSubdir(currentDir, subp); err != nil {
+				return nil, fmt.Errorf("failed to create subdirectory %s: %v", current, err)
+			}
+		default:
+			return nil, fmt.Errorf("path %s not found: %w", current, os.ErrNotExist)
+		}
+	}
+	return currentDir, nil
+}
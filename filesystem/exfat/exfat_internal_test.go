@@ -0,0 +1,106 @@
+package exfat
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestNoFatChain(t *testing.T) {
+	size := 10 * MB
+	f := util.NewMemFile(size)
+	fs, err := Create(f, size, 0, 512, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	write := func(p string, b []byte) {
+		t.Helper()
+		file, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_APPEND)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry := func(fs *FileSystem, p string) *directoryEntry {
+		t.Helper()
+		_, e, err := fs.findEntry(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	first := bytes.Repeat([]byte{'a'}, 3*fs.bytesPerCluster)
+	second := bytes.Repeat([]byte{'b'}, fs.bytesPerCluster)
+	more := bytes.Repeat([]byte{'c'}, 2*fs.bytesPerCluster)
+
+	// written alone, the file is contiguous with no FAT chain, and stays that way as it grows into free space
+	write("/first", first[:fs.bytesPerCluster])
+	write("/first", first[fs.bytesPerCluster:])
+	a := entry(fs, "/first")
+	if !a.noFatChain {
+		t.Errorf("file written into free space has a FAT chain")
+	}
+	for c := a.firstCluster; c < a.firstCluster+3; c++ {
+		if fs.fat[c] != 0 {
+			t.Errorf("FAT entry for cluster %d of contiguous file is %#x", c, fs.fat[c])
+		}
+	}
+
+	// once another file follows it, growing it needs a FAT chain
+	write("/second", second)
+	write("/first", more)
+	a = entry(fs, "/first")
+	if a.noFatChain {
+		t.Errorf("file that could not grow in place still has no FAT chain")
+	}
+	clusters, err := fs.getClusterList(a.firstCluster, a.dataLength, a.noFatChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 5 || clusters[2]+1 == clusters[3] {
+		t.Errorf("unexpected clusters %v", clusters)
+	}
+	if fs.fat[clusters[len(clusters)-1]] != fatEOC {
+		t.Errorf("chain does not end with EOC")
+	}
+	for i := 0; i+1 < len(clusters); i++ {
+		if fs.fat[clusters[i]] != clusters[i+1] {
+			t.Errorf("FAT entry for cluster %d is %#x, expected %d", clusters[i], fs.fat[clusters[i]], clusters[i+1])
+		}
+	}
+
+	read, err := Read(f, size, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for p, expected := range map[string][]byte{"/first": append(first, more...), "/second": second} {
+		file, err := read.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("mismatched contents of %s", p)
+		}
+	}
+
+	// shrinking it frees the clusters at the end of the chain
+	free := fs.bitmap.free()
+	if err := fs.Truncate("/first", 1); err != nil {
+		t.Fatal(err)
+	}
+	if freed := fs.bitmap.free() - free; freed != 4 {
+		t.Errorf("freed %d clusters, expected 4", freed)
+	}
+	if fs.fat[clusters[0]] != fatEOC || fs.fat[clusters[1]] != 0 {
+		t.Errorf("FAT not updated for truncated chain")
+	}
+}
@@ -0,0 +1,495 @@
+package exfat_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/exfat"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func memExfat(t *testing.T, contents map[string]string) (*exfat.FileSystem, *util.MemFile) {
+	t.Helper()
+	size := int64(40 * 1024 * 1024)
+	f := util.NewMemFile(size)
+	fs, err := exfat.Create(f, size, 0, 512, "")
+	if err != nil {
+		t.Fatalf("error creating exfat filesystem: %v", err)
+	}
+	for p, content := range contents {
+		if err := fs.Mkdir(path.Dir(p)); err != nil {
+			t.Fatalf("error making directory for %s: %v", p, err)
+		}
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	return fs, f
+}
+
+func readExfatFile(t *testing.T, fs *exfat.FileSystem, p string) string {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", p, err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func readDirNames(t *testing.T, fs filesystem.FileSystem, p string) []string {
+	t.Helper()
+	infos, err := fs.ReadDir(p)
+	if err != nil {
+		t.Fatalf("error reading directory %s: %v", p, err)
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestExfatType(t *testing.T) {
+	fs, _ := memExfat(t, nil)
+	if fs.Type() != filesystem.TypeExFAT {
+		t.Errorf("mismatched type %v", fs.Type())
+	}
+}
+
+func TestExfatCreate(t *testing.T) {
+	tests := []struct {
+		size      int64
+		blocksize int64
+		label     string
+		err       string
+	}{
+		{10 * exfat.MB, 0, "", ""},
+		{10 * exfat.MB, 4096, "DATA", ""},
+		{512 * exfat.MB, 512, "", ""},
+		{10 * exfat.MB, 1000, "", "blocksize for exFAT must be a power of 2"},
+		{10 * exfat.MB, 8192, "", "blocksize for exFAT must be a power of 2"},
+		{exfat.MB - 1, 512, "", "requested size is smaller than minimum"},
+		{10 * exfat.MB, 512, "A LONG LABEL", "invalid volume label"},
+	}
+	for _, tt := range tests {
+		f := util.NewMemFile(tt.size)
+		fs, err := exfat.Create(f, tt.size, 0, tt.blocksize, tt.label)
+		switch {
+		case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+			t.Errorf("size %d blocksize %d: mismatched error, actual %v expected %s", tt.size, tt.blocksize, err, tt.err)
+		case tt.err != "":
+		case err != nil:
+			t.Errorf("size %d blocksize %d: unexpected error: %v", tt.size, tt.blocksize, err)
+		default:
+			read, err := exfat.Read(f, tt.size, 0, 0)
+			if err != nil {
+				t.Fatalf("size %d blocksize %d: error reading created filesystem: %v", tt.size, tt.blocksize, err)
+			}
+			if !read.Equal(fs) {
+				t.Errorf("size %d blocksize %d: filesystem read back does not match", tt.size, tt.blocksize)
+			}
+			if read.Label() != tt.label {
+				t.Errorf("size %d blocksize %d: mismatched label %q", tt.size, tt.blocksize, read.Label())
+			}
+		}
+	}
+}
+
+func TestExfatRead(t *testing.T) {
+	t.Run("not exfat", func(t *testing.T) {
+		size := int64(10 * exfat.MB)
+		if _, err := exfat.Read(util.NewMemFile(size), size, 0, 512); err == nil {
+			t.Errorf("no error reading empty image")
+		}
+	})
+	t.Run("bad checksum", func(t *testing.T) {
+		_, f := memExfat(t, nil)
+		if _, err := f.WriteAt([]byte{0xff}, 11*512); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := exfat.Read(f, f.Size(), 0, 512); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("mismatched error %v", err)
+		}
+	})
+	t.Run("offset", func(t *testing.T) {
+		size := int64(10 * exfat.MB)
+		start := int64(exfat.MB)
+		f := util.NewMemFile(start + size)
+		fs, err := exfat.Create(f, size, start, 512, "OFFSET")
+		if err != nil {
+			t.Fatalf("error creating exfat filesystem: %v", err)
+		}
+		if err := fs.Mkdir("/a/b"); err != nil {
+			t.Fatal(err)
+		}
+		read, err := exfat.Read(f, size, start, 512)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		if names := readDirNames(t, read, "/a"); len(names) != 1 || names[0] != "b" {
+			t.Errorf("mismatched directory contents %v", names)
+		}
+	})
+}
+
+func TestExfatReadWrite(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 5000)
+	contents := map[string]string{
+		"/README.md":          "hello exfat",
+		"/empty":              "",
+		"/large.bin":          large,
+		"/dir/sub/nested.txt": "nested",
+		"/dir/a long file name with spaces and ünïcödé, more than fifteen characters.txt": "long",
+	}
+	fs, f := memExfat(t, contents)
+	check := func(t *testing.T, fs *exfat.FileSystem) {
+		for p, content := range contents {
+			if actual := readExfatFile(t, fs, p); actual != content {
+				t.Errorf("mismatched contents of %s, %d bytes, expected %d", p, len(actual), len(content))
+			}
+		}
+		if names := readDirNames(t, fs, "/"); strings.Join(names, ",") != "README.md,dir,empty,large.bin" {
+			t.Errorf("mismatched root directory %v", names)
+		}
+		info, err := fs.Stat("/large.bin")
+		if err != nil {
+			t.Fatalf("error getting info: %v", err)
+		}
+		if info.Size() != int64(len(large)) || info.IsDir() || info.Name() != "large.bin" {
+			t.Errorf("mismatched info %s %d %v", info.Name(), info.Size(), info.IsDir())
+		}
+	}
+	check(t, fs)
+	read, err := exfat.Read(f, f.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	check(t, read)
+
+	t.Run("seek and overwrite", func(t *testing.T) {
+		file, err := read.OpenFile("/README.md", os.O_RDWR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("EXFAT, and more")); err != nil {
+			t.Fatal(err)
+		}
+		if actual := readExfatFile(t, read, "/README.md"); actual != "hello EXFAT, and more" {
+			t.Errorf("mismatched contents %q", actual)
+		}
+	})
+	t.Run("write past end", func(t *testing.T) {
+		file, err := read.OpenFile("/empty", os.O_RDWR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Seek(5000, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if actual := readExfatFile(t, read, "/empty"); actual != strings.Repeat("\x00", 5000)+"x" {
+			t.Errorf("mismatched contents of %d bytes", len(actual))
+		}
+	})
+	t.Run("append and truncate flags", func(t *testing.T) {
+		file, err := read.OpenFile("/dir/sub/nested.txt", os.O_RDWR|os.O_APPEND)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(" more")); err != nil {
+			t.Fatal(err)
+		}
+		if actual := readExfatFile(t, read, "/dir/sub/nested.txt"); actual != "nested more" {
+			t.Errorf("mismatched contents after append %q", actual)
+		}
+		if _, err := read.OpenFile("/dir/sub/nested.txt", os.O_RDWR|os.O_TRUNC); err != nil {
+			t.Fatal(err)
+		}
+		if actual := readExfatFile(t, read, "/dir/sub/nested.txt"); actual != "" {
+			t.Errorf("mismatched contents after truncate %q", actual)
+		}
+	})
+	t.Run("read-only", func(t *testing.T) {
+		file, err := read.OpenFile("/README.md", os.O_RDONLY)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("x")); err == nil {
+			t.Errorf("no error writing to read-only file")
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		if _, err := read.OpenFile("/missing", os.O_RDONLY); err == nil {
+			t.Errorf("no error opening missing file")
+		}
+		if _, err := read.OpenFile("/dir", os.O_RDONLY); err == nil {
+			t.Errorf("no error opening directory")
+		}
+		if _, err := read.OpenFile("/bad:name", os.O_CREATE|os.O_RDWR); err == nil {
+			t.Errorf("no error creating file with invalid name")
+		}
+		if err := read.Mkdir("/README.md/sub"); err == nil {
+			t.Errorf("no error making directory under file")
+		}
+		if _, err := read.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("mismatched error for missing file: %v", err)
+		}
+	})
+}
+
+func TestExfatCaseInsensitive(t *testing.T) {
+	fs, _ := memExfat(t, map[string]string{"/Dir/ReadMe.TXT": "content"})
+	if actual := readExfatFile(t, fs, "/dir/README.txt"); actual != "content" {
+		t.Errorf("mismatched contents %q", actual)
+	}
+	// creating a file with a different case opens the existing one
+	f, err := fs.OpenFile("/DIR/readme.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if names := readDirNames(t, fs, "/dir"); len(names) != 1 || names[0] != "ReadMe.TXT" {
+		t.Errorf("mismatched directory contents %v", names)
+	}
+	// case is preserved, and can be changed by renaming
+	if err := fs.Rename("/dir/readme.txt", "/dir/README.TXT"); err != nil {
+		t.Fatal(err)
+	}
+	if names := readDirNames(t, fs, "/dir"); len(names) != 1 || names[0] != "README.TXT" {
+		t.Errorf("mismatched directory contents after rename %v", names)
+	}
+	if actual := readExfatFile(t, fs, "/Dir/ReadMe.TXT"); actual != "content!" {
+		t.Errorf("mismatched contents %q", actual)
+	}
+}
+
+func TestExfatManyEntries(t *testing.T) {
+	// enough entries to need several clusters in both the root and a subdirectory
+	contents := map[string]string{}
+	for i := 0; i < 200; i++ {
+		name := strings.Repeat(string(rune('a'+i%26)), 20+i%7) + string(rune('A'+i/26))
+		contents["/"+name] = name
+		contents["/sub/"+name] = name
+	}
+	_, f := memExfat(t, contents)
+	read, err := exfat.Read(f, f.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for p, content := range contents {
+		if actual := readExfatFile(t, read, p); actual != content {
+			t.Errorf("mismatched contents of %s %q", p, actual)
+		}
+	}
+	if names := readDirNames(t, read, "/sub"); len(names) != 200 {
+		t.Errorf("%d entries in /sub, expected 200", len(names))
+	}
+}
+
+func TestExfatRemove(t *testing.T) {
+	fs, _ := memExfat(t, map[string]string{
+		"/file":          strings.Repeat("x", 100000),
+		"/dir/file":      "a",
+		"/dir/sub/file2": "b",
+		"/empty/keep":    "",
+	})
+	before, err := fs.Statfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/dir"); err == nil {
+		t.Errorf("no error removing non-empty directory")
+	}
+	if err := fs.Remove("/file"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := fs.Statfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed := after.FreeBytes - before.FreeBytes; freed != 100000/4096*4096+4096 {
+		t.Errorf("removing file freed %d bytes", freed)
+	}
+	if err := fs.Remove("/empty/keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/missing"); err != nil {
+		t.Errorf("unexpected error removing missing path: %v", err)
+	}
+	if err := fs.Remove("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("mismatched error removing missing path: %v", err)
+	}
+	if names := readDirNames(t, fs, "/"); len(names) != 0 {
+		t.Errorf("root directory not empty: %v", names)
+	}
+	if err := fs.Remove("/"); err == nil {
+		t.Errorf("no error removing root directory")
+	}
+}
+
+func TestExfatRename(t *testing.T) {
+	fs, f := memExfat(t, map[string]string{
+		"/a/file":     "file",
+		"/a/other":    "other",
+		"/b/existing": "existing",
+		"/a/sub/deep": "deep",
+	})
+	tests := []struct {
+		oldpath, newpath string
+		err              string
+	}{
+		{"/a/file", "/a/renamed", ""},
+		{"/a/renamed", "/b/moved", ""},
+		{"/a/other", "/b/existing", ""},
+		{"/a/sub", "/b/sub", ""},
+		{"/b/sub", "/b/sub/inside", "cannot move directory"},
+		{"/b/moved", "/b/sub", "cannot replace directory"},
+		{"/b", "/a/b", ""},
+		{"/missing", "/x", "target file"},
+		{"/", "/x", "cannot rename root directory"},
+	}
+	for _, tt := range tests {
+		err := fs.Rename(tt.oldpath, tt.newpath)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("rename %s to %s: unexpected error: %v", tt.oldpath, tt.newpath, err)
+		case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+			t.Errorf("rename %s to %s: mismatched error, actual %v expected %s", tt.oldpath, tt.newpath, err, tt.err)
+		}
+	}
+	read, err := exfat.Read(f, f.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	expected := map[string]string{
+		"/a/b/moved":    "file",
+		"/a/b/existing": "other",
+		"/a/b/sub/deep": "deep",
+	}
+	for p, content := range expected {
+		if actual := readExfatFile(t, read, p); actual != content {
+			t.Errorf("mismatched contents of %s %q", p, actual)
+		}
+	}
+	if names := readDirNames(t, read, "/a"); strings.Join(names, ",") != "b" {
+		t.Errorf("mismatched contents of /a %v", names)
+	}
+}
+
+func TestExfatTruncate(t *testing.T) {
+	fs, _ := memExfat(t, map[string]string{"/file": strings.Repeat("x", 10000)})
+	tests := []struct {
+		size     int64
+		expected string
+	}{
+		{5000, strings.Repeat("x", 5000)},
+		{0, ""},
+		{3, "\x00\x00\x00"},
+		{9000, strings.Repeat("\x00", 9000)},
+	}
+	for _, tt := range tests {
+		if err := fs.Truncate("/file", tt.size); err != nil {
+			t.Fatalf("error truncating to %d: %v", tt.size, err)
+		}
+		if actual := readExfatFile(t, fs, "/file"); actual != tt.expected {
+			t.Errorf("mismatched contents after truncating to %d, %d bytes", tt.size, len(actual))
+		}
+	}
+	if err := fs.Truncate("/", 0); err == nil {
+		t.Errorf("no error truncating root directory")
+	}
+	if err := fs.Truncate("/file", -1); err == nil {
+		t.Errorf("no error truncating to negative size")
+	}
+}
+
+func TestExfatLabel(t *testing.T) {
+	fs, f := memExfat(t, nil)
+	if fs.Label() != "" {
+		t.Errorf("unexpected label %q", fs.Label())
+	}
+	if err := fs.SetLabel("Ünïcode"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetLabel("twelve chars"); err == nil {
+		t.Errorf("no error setting label that is too long")
+	}
+	read, err := exfat.Read(f, f.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if read.Label() != "Ünïcode" {
+		t.Errorf("mismatched label %q", read.Label())
+	}
+	if err := read.SetLabel(""); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = exfat.Read(f, f.Size(), 0, 512); err != nil || read.Label() != "" {
+		t.Errorf("label %q after removing, error %v", read.Label(), err)
+	}
+}
+
+func TestExfatStatfs(t *testing.T) {
+	fs, _ := memExfat(t, nil)
+	before, err := fs.Statfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.BlockSize != 4096 || before.TotalBytes <= 39*exfat.MB || before.TotalBytes > 40*exfat.MB {
+		t.Errorf("unexpected capacity %+v", before)
+	}
+	if before.UsedBytes != before.TotalBytes-before.FreeBytes || before.UsedBytes == 0 {
+		t.Errorf("unexpected used bytes %+v", before)
+	}
+	f, err := fs.OpenFile("/file", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(bytes.Repeat([]byte{1}, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	after, err := fs.Statfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := after.UsedBytes - before.UsedBytes; used != 3*4096 {
+		t.Errorf("writing 10000 bytes used %d bytes", used)
+	}
+	// fill it up
+	big, err := fs.OpenFile("/big", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := big.Write(make([]byte, after.FreeBytes+1)); err == nil || !strings.Contains(err.Error(), "no space left") {
+		t.Errorf("mismatched error writing more than the free space: %v", err)
+	}
+}
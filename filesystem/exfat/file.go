@@ -0,0 +1,172 @@
+package exfat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// File represents a single file in an exFAT filesystem
+type File struct {
+	*directoryEntry
+	isReadWrite bool
+	isAppend    bool
+	offset      int64
+	parent      *Directory
+	filesystem  *FileSystem
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// and increments the offset by the number of bytes read.
+// Use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	fs := fl.filesystem
+	// anything past the valid data length reads as zeroes
+	size := int64(fl.dataLength) - fl.offset
+	if size <= 0 {
+		return 0, io.EOF
+	}
+	maxRead := int64(len(b))
+	if maxRead > size {
+		maxRead = size
+	}
+	clusters, err := fs.getClusterList(fl.firstCluster, fl.dataLength, fl.noFatChain)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get list of clusters for file: %v", err)
+	}
+
+	bytesPerCluster := int64(fs.bytesPerCluster)
+	totalRead := int64(0)
+	for totalRead < maxRead {
+		pos := fl.offset + totalRead
+		within := pos % bytesPerCluster
+		toRead := bytesPerCluster - within
+		if toRead > maxRead-totalRead {
+			toRead = maxRead - totalRead
+		}
+		chunk := b[totalRead : totalRead+toRead]
+		valid := int64(fl.validDataLength) - pos
+		switch {
+		case valid <= 0:
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		default:
+			if valid < toRead {
+				for i := range chunk[valid:] {
+					chunk[valid+int64(i)] = 0
+				}
+				chunk = chunk[:valid]
+			}
+			if _, err := fs.file.ReadAt(chunk, fs.clusterOffset(clusters[pos/bytesPerCluster])+within); err != nil {
+				return int(totalRead), fmt.Errorf("unable to read from file: %v", err)
+			}
+		}
+		totalRead += toRead
+	}
+
+	fl.offset += totalRead
+	var retErr error
+	if fl.offset >= int64(fl.dataLength) {
+		retErr = io.EOF
+	}
+	return int(totalRead), retErr
+}
+
+// Write writes len(b) bytes to the File.
+// It returns the number of bytes written and an error, if any.
+// returns a non-nil error when n != len(b)
+// writes to the last known offset in the file from last read or write
+// and increments the offset by the number of bytes read.
+// Use Seek() to set at a particular point
+//
+// The file stays contiguous, with no FAT chain, for as long as the clusters after it are free.
+func (fl *File) Write(p []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	fs := fl.filesystem
+	if !fl.isReadWrite {
+		return 0, fmt.Errorf("cannot write to file opened read-only")
+	}
+	if fl.isAppend {
+		fl.offset = int64(fl.dataLength)
+	}
+	// a write past the end leaves a gap that must read as zeroes
+	oldSize := int64(fl.dataLength)
+	data, offset := p, fl.offset
+	if offset > oldSize {
+		data = append(make([]byte, offset-oldSize), p...)
+		offset = oldSize
+	}
+	newSize := offset + int64(len(data))
+	if newSize < oldSize {
+		newSize = oldSize
+	}
+	if newSize != oldSize {
+		if err := fs.resizeEntry(fl.directoryEntry, uint64(newSize)); err != nil {
+			return 0, fmt.Errorf("unable to allocate clusters for file: %v", err)
+		}
+	}
+	clusters, err := fs.getClusterList(fl.firstCluster, fl.dataLength, fl.noFatChain)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get list of clusters for file: %v", err)
+	}
+
+	bytesPerCluster := int64(fs.bytesPerCluster)
+	totalWritten := int64(0)
+	for totalWritten < int64(len(data)) {
+		pos := offset + totalWritten
+		within := pos % bytesPerCluster
+		toWrite := bytesPerCluster - within
+		if toWrite > int64(len(data))-totalWritten {
+			toWrite = int64(len(data)) - totalWritten
+		}
+		if _, err := fs.file.WriteAt(data[totalWritten:totalWritten+toWrite], fs.clusterOffset(clusters[pos/bytesPerCluster])+within); err != nil {
+			return 0, fmt.Errorf("unable to write to file: %v", err)
+		}
+		totalWritten += toWrite
+	}
+	fl.offset = offset + totalWritten
+	fl.modifyTime = time.Now()
+
+	// update the parent with the new size and clusters
+	if err := fs.writeDirectoryEntries(fl.parent); err != nil {
+		return 0, fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	return len(p), nil
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = int64(fl.dataLength) + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
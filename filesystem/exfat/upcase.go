@@ -0,0 +1,137 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+)
+
+const (
+	// upcaseCompressed marks the start of a run of characters that map to themselves in a compressed up-case
+	// table. It is followed by the length of the run.
+	upcaseCompressed uint16 = 0xffff
+	// upcaseMinRun is the shortest run of identity mappings worth compressing
+	upcaseMinRun = 3
+)
+
+// upcaseTable maps each UTF-16 code unit to its upper case, for comparing and hashing file names, which in
+// exFAT are case-insensitive but case-preserving
+type upcaseTable struct {
+	table []uint16
+}
+
+// defaultUpcaseTable generates an up-case table for the whole Basic Multilingual Plane from the unicode
+// package. Characters that have no upper case, or whose upper case is outside the plane, map to themselves.
+func defaultUpcaseTable() *upcaseTable {
+	table := make([]uint16, 0x10000)
+	for i := range table {
+		u := unicode.ToUpper(rune(i))
+		if u > 0xffff || utf16.IsSurrogate(rune(i)) {
+			u = rune(i)
+		}
+		table[i] = uint16(u)
+	}
+	return &upcaseTable{table: table}
+}
+
+// upcaseTableFromBytes reads an up-case table as stored on disk, compressed or not. Any characters past the
+// end of it map to themselves.
+func upcaseTableFromBytes(b []byte) (*upcaseTable, error) {
+	if len(b)%2 != 0 {
+		return nil, fmt.Errorf("up-case table has odd length %d", len(b))
+	}
+	table := make([]uint16, 0, 0x10000)
+	for i := 0; i < len(b); i += 2 {
+		v := binary.LittleEndian.Uint16(b[i : i+2])
+		if v != upcaseCompressed {
+			table = append(table, v)
+			continue
+		}
+		if i+4 > len(b) {
+			return nil, fmt.Errorf("up-case table ends in the middle of a compressed run")
+		}
+		run := int(binary.LittleEndian.Uint16(b[i+2 : i+4]))
+		for j := 0; j < run; j++ {
+			table = append(table, uint16(len(table)))
+		}
+		i += 2
+	}
+	if len(table) > 0x10000 {
+		return nil, fmt.Errorf("up-case table has %d entries, more than the %d possible", len(table), 0x10000)
+	}
+	for len(table) < 0x10000 {
+		table = append(table, uint16(len(table)))
+	}
+	return &upcaseTable{table: table}, nil
+}
+
+// toBytes returns the up-case table compressed, with each run of characters that map to themselves replaced
+// by its length
+func (u *upcaseTable) toBytes() []byte {
+	var words []uint16
+	for i := 0; i < len(u.table); {
+		run := 0
+		for i+run < len(u.table) && u.table[i+run] == uint16(i+run) && run < 0xffff {
+			run++
+		}
+		// the marker itself can only ever be written as part of a run
+		if run >= upcaseMinRun || (run > 0 && i+run-1 == int(upcaseCompressed)) {
+			words = append(words, upcaseCompressed, uint16(run))
+			i += run
+			continue
+		}
+		words = append(words, u.table[i])
+		i++
+	}
+	b := make([]byte, 2*len(words))
+	for i, w := range words {
+		binary.LittleEndian.PutUint16(b[2*i:], w)
+	}
+	return b
+}
+
+// upcase returns a name as up-cased UTF-16
+func (u *upcaseTable) upcase(name string) []uint16 {
+	units := utf16.Encode([]rune(name))
+	for i, c := range units {
+		units[i] = u.table[c]
+	}
+	return units
+}
+
+// equalFold reports whether two names are the same once up-cased
+func (u *upcaseTable) equalFold(a, b string) bool {
+	ua, ub := u.upcase(a), u.upcase(b)
+	if len(ua) != len(ub) {
+		return false
+	}
+	for i := range ua {
+		if ua[i] != ub[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nameHash calculates the hash of a name stored in its stream extension entry, to speed up lookups
+func (u *upcaseTable) nameHash(name string) uint16 {
+	var hash uint16
+	for _, c := range u.upcase(name) {
+		hash = (hash << 15) | (hash >> 1)
+		hash += c & 0xff
+		hash = (hash << 15) | (hash >> 1)
+		hash += c >> 8
+	}
+	return hash
+}
+
+// upcaseChecksum calculates the checksum of the up-case table as stored on disk
+func upcaseChecksum(b []byte) uint32 {
+	var checksum uint32
+	for _, c := range b {
+		checksum = (checksum << 31) | (checksum >> 1)
+		checksum += uint32(c)
+	}
+	return checksum
+}
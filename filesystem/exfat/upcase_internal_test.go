@@ -0,0 +1,60 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestUpcaseTable(t *testing.T) {
+	u := defaultUpcaseTable()
+	b := u.toBytes()
+	// compressed, it is far smaller than the 128KB it would be otherwise
+	if len(b) >= 0x10000 {
+		t.Errorf("compressed up-case table is %d bytes", len(b))
+	}
+	read, err := upcaseTableFromBytes(b)
+	if err != nil {
+		t.Fatalf("unexpected error reading up-case table: %v", err)
+	}
+	for i := range u.table {
+		if read.table[i] != u.table[i] {
+			t.Fatalf("mismatched up-case of %#04x, actual %#04x expected %#04x", i, read.table[i], u.table[i])
+		}
+	}
+	for _, tt := range []struct{ a, b string }{{"readme.txt", "README.TXT"}, {"straße", "STRAßE"}, {"ÉCOLE", "école"}} {
+		if !u.equalFold(tt.a, tt.b) {
+			t.Errorf("%s and %s not equal ignoring case", tt.a, tt.b)
+		}
+	}
+	if u.equalFold("a", "b") {
+		t.Errorf("a and b equal ignoring case")
+	}
+	if u.nameHash("readme.txt") != u.nameHash("README.TXT") {
+		t.Errorf("name hash depends on case")
+	}
+}
+
+func TestUpcaseTableFromBytes(t *testing.T) {
+	// a: A, b: B, then 3 unchanged
+	words := []uint16{0x41, 0x42, upcaseCompressed, 3, 0x45}
+	b := make([]byte, 2*len(words))
+	for i, w := range words {
+		binary.LittleEndian.PutUint16(b[2*i:], w)
+	}
+	u, err := upcaseTableFromBytes(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []uint16{0x41, 0x42, 2, 3, 4, 0x45, 6}
+	for i, e := range expected {
+		if u.table[i] != e {
+			t.Errorf("up-case of %d is %#04x, expected %#04x", i, u.table[i], e)
+		}
+	}
+	if _, err := upcaseTableFromBytes(b[:5]); err == nil {
+		t.Errorf("no error for odd length table")
+	}
+	if _, err := upcaseTableFromBytes(b[:6]); err == nil {
+		t.Errorf("no error for table ending in a compressed run marker")
+	}
+}
@@ -0,0 +1,398 @@
+package ext4
+
+import (
+	"fmt"
+)
+
+// bitmap is the block bitmap or the inode bitmap of a block group, one bit for each block or inode in the
+// group, with every bit past the last of them set
+type bitmap []byte
+
+func (bm bitmap) isSet(i uint32) bool {
+	return bm[i/8]&(1<<(i%8)) != 0
+}
+
+func (bm bitmap) set(i uint32) {
+	bm[i/8] |= 1 << (i % 8)
+}
+
+func (bm bitmap) clear(i uint32) {
+	bm[i/8] &^= 1 << (i % 8)
+}
+
+// setFrom sets every bit from i to the end, the padding that the kernel and e2fsck expect past the last
+// block or inode of a group
+func (bm bitmap) setFrom(i uint32) {
+	for ; i < uint32(len(bm))*8; i++ {
+		bm.set(i)
+	}
+}
+
+// checksum calculates the metadata_csum checksum of the first bits of the bitmap, those for the blocks or
+// inodes of a group
+func (bm bitmap) checksum(bits uint32, sb *superblock) uint32 {
+	return crc32c(sb.metadataChecksumSeed(), bm[:bits/8])
+}
+
+// groupFirstBlock is the first block of a group
+func (sb *superblock) groupFirstBlock(group uint32) uint64 {
+	return uint64(sb.firstDataBlock) + uint64(group)*uint64(sb.blocksPerGroup)
+}
+
+// groupBlockCount is the number of blocks in a group, which is less than blocksPerGroup for the last one
+func (sb *superblock) groupBlockCount(group uint32) uint32 {
+	first := sb.groupFirstBlock(group)
+	if remaining := sb.blocksCount - first; remaining < uint64(sb.blocksPerGroup) {
+		return uint32(remaining)
+	}
+	return sb.blocksPerGroup
+}
+
+// blockGroup returns the group a block is in, and its index in the group
+func (sb *superblock) blockGroup(block uint64) (uint32, uint32) {
+	block -= uint64(sb.firstDataBlock)
+	return uint32(block / uint64(sb.blocksPerGroup)), uint32(block % uint64(sb.blocksPerGroup))
+}
+
+// groupDescriptorBlocks is the number of blocks the group descriptors of all groups take
+func (sb *superblock) groupDescriptorBlocks() uint32 {
+	perBlock := uint32(sb.blockSize() / sb.groupDescriptorSize())
+	return (sb.blockGroups() + perBlock - 1) / perBlock
+}
+
+// groupBaseBlocks returns the number of blocks at the start of a group that hold a copy of the superblock
+// and group descriptors, including those reserved for the group descriptors to grow into, as
+// ext2fs_super_and_bgd_loc2 does
+func (sb *superblock) groupBaseBlocks(group uint32) uint32 {
+	var blocks uint32
+	hasSuper := sb.hasSuperblockBackup(group)
+	if hasSuper {
+		blocks++
+	}
+	perBlock := uint32(sb.blockSize() / sb.groupDescriptorSize())
+	metaBG := group / perBlock
+	if sb.featureIncompat&featureIncompatMetaBG == 0 {
+		if hasSuper {
+			blocks += sb.groupDescriptorBlocks() + uint32(sb.reservedGdtBlocks)
+		}
+		return blocks
+	}
+	// with meta_bg, the groups before the first meta group keep the old layout, and the first, second and
+	// last group of each meta group after them have a copy of its one block of descriptors
+	if metaBG < sb.firstMetaBG {
+		if hasSuper {
+			blocks += sb.firstMetaBG
+		}
+		return blocks
+	}
+	if index := group % perBlock; index == 0 || index == 1 || index == perBlock-1 {
+		blocks++
+	}
+	return blocks
+}
+
+// groupMetadataBlocks returns the blocks in a group that hold metadata: the copy of the superblock and
+// group descriptors at its start, and the bitmaps and inode table of the group if they are in it. With
+// flex_bg, those of other groups may be in it too, but then it never needs this, as its block bitmap is
+// never left uninitialized.
+func (sb *superblock) groupMetadataBlocks(gd *groupDescriptor) []extent {
+	first := sb.groupFirstBlock(gd.number)
+	end := first + uint64(sb.groupBlockCount(gd.number))
+	var blocks []extent
+	if base := sb.groupBaseBlocks(gd.number); base > 0 {
+		blocks = append(blocks, extent{startBlock: first, count: base})
+	}
+	inodeTableBlocks := (sb.inodesPerGroup*uint32(sb.inodeSize) + uint32(sb.blockSize()) - 1) / uint32(sb.blockSize())
+	for _, e := range []extent{
+		{startBlock: gd.blockBitmap, count: 1},
+		{startBlock: gd.inodeBitmap, count: 1},
+		{startBlock: gd.inodeTable, count: inodeTableBlocks},
+	} {
+		if e.startBlock >= first && e.startBlock < end {
+			blocks = append(blocks, e)
+		}
+	}
+	return blocks
+}
+
+// blockBitmap returns the block bitmap of a group, reading it the first time it is needed. A group whose
+// bitmap was never initialized has only its metadata in use.
+func (fs *FileSystem) blockBitmap(group uint32) (bitmap, error) {
+	if bm, ok := fs.blockBitmaps[group]; ok {
+		return bm, nil
+	}
+	sb := fs.superblock
+	gd := fs.groupDescriptors[group]
+	var bm bitmap
+	if gd.flags&bgBlockUninit != 0 {
+		bm = make(bitmap, fs.blockSize)
+		for _, e := range sb.groupMetadataBlocks(gd) {
+			for i := uint32(0); i < e.count; i++ {
+				_, index := sb.blockGroup(e.startBlock + uint64(i))
+				bm.set(index)
+			}
+		}
+		bm.setFrom(sb.groupBlockCount(group))
+	} else {
+		b, err := fs.readBlock(gd.blockBitmap)
+		if err != nil {
+			return nil, fmt.Errorf("could not read block bitmap of group %d: %v", group, err)
+		}
+		bm = bitmap(b)
+		if sb.hasMetadataCsum() {
+			if checksum := bm.checksum(sb.clustersPerGroup, sb); !bitmapChecksumMatches(checksum, gd.blockBitmapCsum, sb) {
+				return nil, fmt.Errorf("block bitmap of group %d checksum %#08x does not match expected %#08x", group, gd.blockBitmapCsum, checksum)
+			}
+		}
+	}
+	if fs.blockBitmaps == nil {
+		fs.blockBitmaps = map[uint32]bitmap{}
+	}
+	fs.blockBitmaps[group] = bm
+	return bm, nil
+}
+
+// inodeBitmap returns the inode bitmap of a group, reading it the first time it is needed. A group whose
+// bitmap was never initialized has no inodes in use.
+func (fs *FileSystem) inodeBitmap(group uint32) (bitmap, error) {
+	if bm, ok := fs.inodeBitmaps[group]; ok {
+		return bm, nil
+	}
+	sb := fs.superblock
+	gd := fs.groupDescriptors[group]
+	var bm bitmap
+	if gd.flags&bgInodeUninit != 0 {
+		bm = make(bitmap, fs.blockSize)
+		bm.setFrom(sb.inodesPerGroup)
+	} else {
+		b, err := fs.readBlock(gd.inodeBitmap)
+		if err != nil {
+			return nil, fmt.Errorf("could not read inode bitmap of group %d: %v", group, err)
+		}
+		bm = bitmap(b)
+		if sb.hasMetadataCsum() {
+			if checksum := bm.checksum(sb.inodesPerGroup, sb); !bitmapChecksumMatches(checksum, gd.inodeBitmapCsum, sb) {
+				return nil, fmt.Errorf("inode bitmap of group %d checksum %#08x does not match expected %#08x", group, gd.inodeBitmapCsum, checksum)
+			}
+		}
+	}
+	if fs.inodeBitmaps == nil {
+		fs.inodeBitmaps = map[uint32]bitmap{}
+	}
+	fs.inodeBitmaps[group] = bm
+	return bm, nil
+}
+
+// bitmapChecksumMatches compares the checksum of a bitmap with the one in its group descriptor, which only
+// has the low 16 bits of it unless the descriptors are 64 bytes
+func bitmapChecksumMatches(checksum, stored uint32, sb *superblock) bool {
+	if sb.groupDescriptorSize() < 64 {
+		checksum &= 0xffff
+	}
+	return checksum == stored
+}
+
+// writeBlockBitmap writes the block bitmap of a group, and its group descriptor with its new checksum
+func (fs *FileSystem) writeBlockBitmap(group uint32) error {
+	sb := fs.superblock
+	gd := fs.groupDescriptors[group]
+	bm := fs.blockBitmaps[group]
+	if sb.hasMetadataCsum() {
+		gd.blockBitmapCsum = bm.checksum(sb.clustersPerGroup, sb)
+	}
+	gd.flags &^= bgBlockUninit
+	if _, err := fs.file.WriteAt(bm, fs.start+int64(gd.blockBitmap)*fs.blockSize); err != nil {
+		return fmt.Errorf("could not write block bitmap of group %d: %v", group, err)
+	}
+	return fs.writeGroupDescriptor(group)
+}
+
+// writeInodeBitmap writes the inode bitmap of a group, and its group descriptor with its new checksum
+func (fs *FileSystem) writeInodeBitmap(group uint32) error {
+	sb := fs.superblock
+	gd := fs.groupDescriptors[group]
+	bm := fs.inodeBitmaps[group]
+	if sb.hasMetadataCsum() {
+		gd.inodeBitmapCsum = bm.checksum(sb.inodesPerGroup, sb)
+	}
+	gd.flags &^= bgInodeUninit
+	if _, err := fs.file.WriteAt(bm, fs.start+int64(gd.inodeBitmap)*fs.blockSize); err != nil {
+		return fmt.Errorf("could not write inode bitmap of group %d: %v", group, err)
+	}
+	return fs.writeGroupDescriptor(group)
+}
+
+// allocateBlocks allocates count blocks, taking the first free ones from goal on, and returns them as runs
+// of contiguous blocks. Starting from the block after the last one of a file keeps the file contiguous
+// for as long as the blocks after it are free.
+func (fs *FileSystem) allocateBlocks(goal, count uint64) ([]extent, error) {
+	sb := fs.superblock
+	if free := fs.freeBlockCount(); count > free {
+		return nil, fmt.Errorf("no space left on device, need %d blocks but only %d are free", count, free)
+	}
+	if goal < uint64(sb.firstDataBlock) || goal >= sb.blocksCount {
+		goal = uint64(sb.firstDataBlock)
+	}
+	var (
+		runs    []extent
+		changed []uint32
+		found   uint64
+	)
+	groups := sb.blockGroups()
+	goalGroup, goalIndex := sb.blockGroup(goal)
+	// go round every group once, starting with the goal, and back to the start of the goal group at the end
+	for i := uint32(0); i <= groups && found < count; i++ {
+		group := (goalGroup + i) % groups
+		if fs.groupDescriptors[group].freeBlocks == 0 {
+			continue
+		}
+		bm, err := fs.blockBitmap(group)
+		if err != nil {
+			return nil, err
+		}
+		var start uint32
+		if i == 0 {
+			start = goalIndex
+		}
+		used := uint32(0)
+		first := sb.groupFirstBlock(group)
+		for index := start; index < sb.groupBlockCount(group) && found < count; index++ {
+			if bm.isSet(index) {
+				continue
+			}
+			bm.set(index)
+			used++
+			found++
+			block := first + uint64(index)
+			if n := len(runs); n > 0 && runs[n-1].startBlock+uint64(runs[n-1].count) == block && runs[n-1].count < maxExtentLength {
+				runs[n-1].count++
+			} else {
+				runs = append(runs, extent{startBlock: block, count: 1})
+			}
+		}
+		if used > 0 {
+			fs.groupDescriptors[group].freeBlocks -= used
+			changed = append(changed, group)
+		}
+	}
+	for _, group := range changed {
+		if err := fs.writeBlockBitmap(group); err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.writeSuperblock(); err != nil {
+		return nil, err
+	}
+	if found < count {
+		return nil, fmt.Errorf("no space left on device, found only %d of %d free blocks", found, count)
+	}
+	return runs, nil
+}
+
+// freeBlocks frees runs of blocks, as returned by allocateBlocks or from the extents of a file
+func (fs *FileSystem) freeBlocks(runs []extent) error {
+	sb := fs.superblock
+	changed := map[uint32]bool{}
+	var order []uint32
+	for _, e := range runs {
+		if e.startBlock == 0 {
+			continue
+		}
+		for i := uint64(0); i < uint64(e.count); i++ {
+			group, index := sb.blockGroup(e.startBlock + i)
+			bm, err := fs.blockBitmap(group)
+			if err != nil {
+				return err
+			}
+			if !bm.isSet(index) {
+				return fmt.Errorf("cannot free block %d, it is already free", e.startBlock+i)
+			}
+			bm.clear(index)
+			fs.groupDescriptors[group].freeBlocks++
+			if !changed[group] {
+				changed[group] = true
+				order = append(order, group)
+			}
+		}
+	}
+	for _, group := range order {
+		if err := fs.writeBlockBitmap(group); err != nil {
+			return err
+		}
+	}
+	return fs.writeSuperblock()
+}
+
+// allocateInode allocates the first free inode, starting with the group of the goal inode, and returns its
+// number. The inode itself is left for the caller to write.
+func (fs *FileSystem) allocateInode(goal uint32, isDir bool) (uint32, error) {
+	sb := fs.superblock
+	groups := sb.blockGroups()
+	goalGroup := uint32(0)
+	if goal > 0 {
+		goalGroup = (goal - 1) / sb.inodesPerGroup
+	}
+	for i := uint32(0); i < groups; i++ {
+		group := (goalGroup + i) % groups
+		gd := fs.groupDescriptors[group]
+		if gd.freeInodes == 0 {
+			continue
+		}
+		bm, err := fs.inodeBitmap(group)
+		if err != nil {
+			return 0, err
+		}
+		for index := uint32(0); index < sb.inodesPerGroup; index++ {
+			number := group*sb.inodesPerGroup + index + 1
+			if bm.isSet(index) || number < sb.firstInode {
+				continue
+			}
+			bm.set(index)
+			gd.freeInodes--
+			if isDir {
+				gd.usedDirectories++
+			}
+			// the inode table is only read as far as the last inode ever used
+			if unused := sb.inodesPerGroup - index - 1; unused < gd.inodeTableUnused {
+				gd.inodeTableUnused = unused
+			}
+			if err := fs.writeInodeBitmap(group); err != nil {
+				return 0, err
+			}
+			return number, fs.writeSuperblock()
+		}
+	}
+	return 0, fmt.Errorf("no free inodes left on device")
+}
+
+// freeInode frees an inode in its bitmap. The inode itself is left for the caller to write.
+func (fs *FileSystem) freeInode(number uint32, isDir bool) error {
+	sb := fs.superblock
+	group, index := (number-1)/sb.inodesPerGroup, (number-1)%sb.inodesPerGroup
+	bm, err := fs.inodeBitmap(group)
+	if err != nil {
+		return err
+	}
+	if !bm.isSet(index) {
+		return fmt.Errorf("cannot free inode %d, it is already free", number)
+	}
+	bm.clear(index)
+	gd := fs.groupDescriptors[group]
+	gd.freeInodes++
+	if isDir && gd.usedDirectories > 0 {
+		gd.usedDirectories--
+	}
+	if err := fs.writeInodeBitmap(group); err != nil {
+		return err
+	}
+	return fs.writeSuperblock()
+}
+
+// freeBlockCount counts the free blocks in the group descriptors, like freeInodes
+func (fs *FileSystem) freeBlockCount() uint64 {
+	var free uint64
+	for _, gd := range fs.groupDescriptors {
+		free += uint64(gd.freeBlocks)
+	}
+	return free
+}
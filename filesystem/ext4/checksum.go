@@ -0,0 +1,27 @@
+package ext4
+
+import "hash/crc32"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32c continues a crc32c from seed the way the kernel does for ext4 metadata checksums, without inverting
+// it at the start or end. Start a new one with a seed of ^0.
+func crc32c(seed uint32, b []byte) uint32 {
+	return ^crc32.Update(^seed, crc32cTable, b)
+}
+
+// crc16 continues the crc16 used by the older gdt_csum feature for group descriptors, the ANSI polynomial
+// 0x8005 reflected. Start a new one with a seed of 0xffff.
+func crc16(crc uint16, b []byte) uint16 {
+	for _, c := range b {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
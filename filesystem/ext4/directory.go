@@ -0,0 +1,475 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// directoryEntryHeaderSize is the size of a directory entry before its name
+	directoryEntryHeaderSize = 8
+	// maxNameLength is the longest a name can be
+	maxNameLength = 255
+	// directoryTailSize is the size of the fake directory entry at the end of each directory block that holds
+	// its metadata_csum checksum
+	directoryTailSize = 12
+	// directoryTailFileType marks the fake directory entry that holds the checksum of a directory block
+	directoryTailFileType uint8 = 0xde
+)
+
+// file types in directory entries, with the filetype feature
+const (
+	fileTypeUnknown   uint8 = 0
+	fileTypeRegular   uint8 = 1
+	fileTypeDirectory uint8 = 2
+	fileTypeCharDev   uint8 = 3
+	fileTypeBlockDev  uint8 = 4
+	fileTypeFifo      uint8 = 5
+	fileTypeSocket    uint8 = 6
+	fileTypeSymlink   uint8 = 7
+)
+
+// directoryEntry is a single entry in a directory, which links a name to an inode
+type directoryEntry struct {
+	inode    uint32
+	name     string
+	fileType uint8
+}
+
+// directoryEntriesFromBytes reads the directory entries in one directory block, or in the inline data of a
+// directory, skipping empty ones
+func directoryEntriesFromBytes(b []byte, blockSize int, sb *superblock) ([]*directoryEntry, error) {
+	var entries []*directoryEntry
+	for i := 0; i+directoryEntryHeaderSize <= len(b); {
+		recLen := decodeRecordLength(binary.LittleEndian.Uint16(b[i+4:i+6]), blockSize)
+		nameLen := int(b[i+6])
+		fileType := b[i+7]
+		if sb.featureIncompat&featureIncompatFiletype == 0 {
+			nameLen |= int(b[i+7]) << 8
+			fileType = fileTypeUnknown
+		}
+		switch {
+		case recLen < directoryEntryHeaderSize || recLen%4 != 0:
+			return nil, fmt.Errorf("directory entry at %d has invalid record length %d", i, recLen)
+		case i+recLen > len(b):
+			return nil, fmt.Errorf("directory entry at %d with record length %d runs past the end of the block", i, recLen)
+		case directoryEntryHeaderSize+nameLen > recLen:
+			return nil, fmt.Errorf("directory entry at %d has name length %d longer than its record", i, nameLen)
+		}
+		if number := binary.LittleEndian.Uint32(b[i : i+4]); number != 0 {
+			entries = append(entries, &directoryEntry{
+				inode:    number,
+				name:     string(b[i+directoryEntryHeaderSize : i+directoryEntryHeaderSize+nameLen]),
+				fileType: fileType,
+			})
+		}
+		i += recLen
+	}
+	return entries, nil
+}
+
+// decodeRecordLength reads the length of a directory entry, which needs more than 16 bits in a 64KB block
+func decodeRecordLength(recLen uint16, blockSize int) int {
+	if blockSize < 65536 {
+		return int(recLen)
+	}
+	if recLen == 0xffff || recLen == 0 {
+		return blockSize
+	}
+	return int(recLen&0xfffc) | int(recLen&0x3)<<16
+}
+
+// hasDirectoryTail reports whether a directory block ends with the fake entry that holds its checksum
+func hasDirectoryTail(b []byte) bool {
+	if len(b) < directoryTailSize {
+		return false
+	}
+	t := b[len(b)-directoryTailSize:]
+	return binary.LittleEndian.Uint32(t[0:4]) == 0 && binary.LittleEndian.Uint16(t[4:6]) == directoryTailSize &&
+		t[6] == 0 && t[7] == directoryTailFileType
+}
+
+// verifyDirectoryTail checks the checksum of a directory block, in the fake entry at its end
+func verifyDirectoryTail(b []byte, in *inode, sb *superblock) error {
+	offset := len(b) - directoryTailSize
+	checksum := binary.LittleEndian.Uint32(b[offset+8 : offset+12])
+	if expected := crc32c(inodeChecksumSeed(in, sb), b[:offset]); expected != checksum {
+		return fmt.Errorf("directory block checksum %#08x does not match expected %#08x", checksum, expected)
+	}
+	return nil
+}
+
+// directoryRecord is where an entry is in a directory block, empty or not
+type directoryRecord struct {
+	offset     int
+	length     int
+	nameLength int
+	inode      uint32
+}
+
+// directoryRecords finds all of the entries in a directory block, including empty ones
+func directoryRecords(b []byte, blockSize int) ([]directoryRecord, error) {
+	var records []directoryRecord
+	for i := 0; i+directoryEntryHeaderSize <= len(b); {
+		r := directoryRecord{
+			offset:     i,
+			length:     decodeRecordLength(binary.LittleEndian.Uint16(b[i+4:i+6]), blockSize),
+			nameLength: int(b[i+6]),
+			inode:      binary.LittleEndian.Uint32(b[i : i+4]),
+		}
+		if r.length < directoryEntryHeaderSize || r.length%4 != 0 || i+r.length > len(b) {
+			return nil, fmt.Errorf("directory entry at %d has invalid record length %d", i, r.length)
+		}
+		records = append(records, r)
+		i += r.length
+	}
+	return records, nil
+}
+
+// directoryEntrySize is the space an entry with a name of nameLength bytes takes, rounded up to 4 bytes
+func directoryEntrySize(nameLength int) int {
+	return (directoryEntryHeaderSize + nameLength + 3) &^ 3
+}
+
+// encodeRecordLength is the reverse of decodeRecordLength
+func encodeRecordLength(length, blockSize int) uint16 {
+	if blockSize < 65536 {
+		return uint16(length)
+	}
+	if length == 65536 {
+		return 0xffff
+	}
+	return uint16(length&0xfffc) | uint16(length>>16&0x3)
+}
+
+// putDirectoryEntry writes a directory entry of recLen bytes at the start of b. It needs the filetype
+// feature, which every filesystem that can be written has.
+func putDirectoryEntry(b []byte, recLen, blockSize int, de *directoryEntry) {
+	binary.LittleEndian.PutUint32(b[0:4], de.inode)
+	binary.LittleEndian.PutUint16(b[4:6], encodeRecordLength(recLen, blockSize))
+	b[6] = uint8(len(de.name))
+	b[7] = de.fileType
+	copy(b[directoryEntryHeaderSize:directoryEntryHeaderSize+len(de.name)], de.name)
+}
+
+// newDirectoryBlock returns a directory block of blockSize bytes, space of which hold entries, the last
+// entry taking whatever is left. With no entries, it has one empty entry.
+func newDirectoryBlock(blockSize, space int, entries ...*directoryEntry) []byte {
+	b := make([]byte, blockSize)
+	if len(entries) == 0 {
+		binary.LittleEndian.PutUint16(b[4:6], encodeRecordLength(space, blockSize))
+		return b
+	}
+	offset := 0
+	for i, de := range entries {
+		recLen := directoryEntrySize(len(de.name))
+		if i == len(entries)-1 {
+			recLen = space - offset
+		}
+		putDirectoryEntry(b[offset:], recLen, blockSize, de)
+		offset += recLen
+	}
+	return b
+}
+
+// putDirectoryTail writes the fake entry at the end of a directory block that holds its checksum
+func putDirectoryTail(b []byte, in *inode, sb *superblock) {
+	t := b[len(b)-directoryTailSize:]
+	binary.LittleEndian.PutUint32(t[0:4], 0)
+	binary.LittleEndian.PutUint16(t[4:6], directoryTailSize)
+	t[6] = 0
+	t[7] = directoryTailFileType
+	binary.LittleEndian.PutUint32(t[8:12], crc32c(inodeChecksumSeed(in, sb), b[:len(b)-directoryTailSize]))
+}
+
+// fileTypeFromMode is the file type in a directory entry for an inode of the given mode
+func fileTypeFromMode(mode uint16) uint8 {
+	switch mode & modeTypeMask {
+	case modeRegular:
+		return fileTypeRegular
+	case modeDirectory:
+		return fileTypeDirectory
+	case modeCharDev:
+		return fileTypeCharDev
+	case modeBlockDev:
+		return fileTypeBlockDev
+	case modeFifo:
+		return fileTypeFifo
+	case modeSocket:
+		return fileTypeSocket
+	case modeSymlink:
+		return fileTypeSymlink
+	}
+	return fileTypeUnknown
+}
+
+// directorySpace is how much of each directory block holds entries, which is all of it but for the
+// checksum at the end with metadata_csum
+func (fs *FileSystem) directorySpace() int {
+	if fs.superblock.hasMetadataCsum() {
+		return int(fs.blockSize) - directoryTailSize
+	}
+	return int(fs.blockSize)
+}
+
+// writeDirectoryBlock writes a block of a directory that holds entries, with its checksum
+func (fs *FileSystem) writeDirectoryBlock(dir *inode, extents []extent, fileBlock uint32, b []byte) error {
+	e := findExtent(extents, fileBlock)
+	if e == nil || e.startBlock == 0 {
+		return fmt.Errorf("directory block %d is not allocated", fileBlock)
+	}
+	if fs.superblock.hasMetadataCsum() {
+		putDirectoryTail(b, dir, fs.superblock)
+	}
+	block := e.startBlock + uint64(fileBlock-e.fileBlock)
+	if _, err := fs.file.WriteAt(b, fs.start+int64(block)*fs.blockSize); err != nil {
+		return fmt.Errorf("could not write directory block %d: %v", block, err)
+	}
+	return nil
+}
+
+// addDirectoryEntry adds an entry to a directory, in the first block with room for it, or in a new block at
+// the end if none has, and writes the directory inode. The index of an indexed directory is dropped first,
+// as the entry goes wherever there is room rather than where its hash would put it; the directory can still
+// be read, only more slowly.
+func (fs *FileSystem) addDirectoryEntry(dir *inode, de *directoryEntry) error {
+	extents, err := fs.fileExtents(dir)
+	if err != nil {
+		return err
+	}
+	if dir.flags&inodeFlagIndex != 0 {
+		if err := fs.dropDirectoryIndex(dir, extents); err != nil {
+			return fmt.Errorf("could not remove index of directory: %v", err)
+		}
+	}
+	now := time.Now()
+	dir.modifyTime, dir.changeTime = now, now
+	need := directoryEntrySize(len(de.name))
+	space := fs.directorySpace()
+	blocks := uint32(int64(dir.size) / fs.blockSize)
+	for i := uint32(0); i < blocks; i++ {
+		b, err := fs.readFileBlock(extents, i)
+		if err != nil {
+			return err
+		}
+		if fs.superblock.hasMetadataCsum() && !hasDirectoryTail(b) {
+			continue
+		}
+		records, err := directoryRecords(b[:space], int(fs.blockSize))
+		if err != nil {
+			return fmt.Errorf("directory block %d: %v", i, err)
+		}
+		for _, r := range records {
+			used := 0
+			if r.inode != 0 {
+				used = directoryEntrySize(r.nameLength)
+			}
+			if r.length-used < need {
+				continue
+			}
+			if used > 0 {
+				binary.LittleEndian.PutUint16(b[r.offset+4:r.offset+6], encodeRecordLength(used, int(fs.blockSize)))
+			}
+			putDirectoryEntry(b[r.offset+used:], r.length-used, int(fs.blockSize), de)
+			if err := fs.writeDirectoryBlock(dir, extents, i, b); err != nil {
+				return err
+			}
+			return fs.writeInode(dir)
+		}
+	}
+
+	// no room, so it needs another block
+	return fs.growDirectory(dir, de)
+}
+
+// growDirectory adds a block to the end of a directory, with de in it or empty if de is nil, and writes the
+// directory inode
+func (fs *FileSystem) growDirectory(dir *inode, de *directoryEntry) error {
+	if !dir.hasExtents() {
+		return fmt.Errorf("cannot grow directory %d, it has a block map rather than extents", dir.number)
+	}
+	extents, err := fs.fileExtents(dir)
+	if err != nil {
+		return err
+	}
+	blocks := uint32(int64(dir.size) / fs.blockSize)
+	runs, err := fs.allocateBlocks(fs.blockGoal(dir, extents, blocks), 1)
+	if err != nil {
+		return err
+	}
+	extents = setExtent(extents, extent{fileBlock: blocks, startBlock: runs[0].startBlock, count: 1})
+	var entries []*directoryEntry
+	if de != nil {
+		entries = append(entries, de)
+	}
+	if err := fs.writeDirectoryBlock(dir, extents, blocks, newDirectoryBlock(int(fs.blockSize), fs.directorySpace(), entries...)); err != nil {
+		return err
+	}
+	dir.size += uint64(fs.blockSize)
+	fs.addInodeBlocks(dir, 1)
+	return fs.writeExtentTree(dir, extents)
+}
+
+// writeNewDirectory writes the first block of a new directory, with its . and .. entries and any others, and
+// the directory inode
+func (fs *FileSystem) writeNewDirectory(dir, parent *inode, entries ...*directoryEntry) error {
+	runs, err := fs.allocateBlocks(fs.inodeGoal(dir), 1)
+	if err != nil {
+		return err
+	}
+	extents := []extent{{startBlock: runs[0].startBlock, count: 1}}
+	entries = append([]*directoryEntry{
+		{inode: dir.number, name: ".", fileType: fileTypeDirectory},
+		{inode: parent.number, name: "..", fileType: fileTypeDirectory},
+	}, entries...)
+	if err := fs.writeDirectoryBlock(dir, extents, 0, newDirectoryBlock(int(fs.blockSize), fs.directorySpace(), entries...)); err != nil {
+		return err
+	}
+	dir.size = uint64(fs.blockSize)
+	fs.addInodeBlocks(dir, 1)
+	return fs.writeExtentTree(dir, extents)
+}
+
+// removeDirectoryEntry removes the entry for name from a directory, joining its space to the entry before it,
+// or leaving it empty if it is the first in its block, and writes the directory inode. It works the same for
+// the leaf blocks of an indexed directory, which keep their place in the index.
+func (fs *FileSystem) removeDirectoryEntry(dir *inode, name string) error {
+	extents, err := fs.fileExtents(dir)
+	if err != nil {
+		return err
+	}
+	blocks := uint32(int64(dir.size) / fs.blockSize)
+	for i := uint32(0); i < blocks; i++ {
+		b, err := fs.readFileBlock(extents, i)
+		if err != nil {
+			return err
+		}
+		// the root and other nodes of an index have no tail, but their names never match
+		end := len(b)
+		hasTail := fs.superblock.hasMetadataCsum() && hasDirectoryTail(b)
+		if hasTail {
+			end -= directoryTailSize
+		}
+		records, err := directoryRecords(b[:end], int(fs.blockSize))
+		if err != nil {
+			return fmt.Errorf("directory block %d: %v", i, err)
+		}
+		for j, r := range records {
+			start := r.offset + directoryEntryHeaderSize
+			if r.inode == 0 || r.nameLength != len(name) || string(b[start:start+r.nameLength]) != name {
+				continue
+			}
+			if j > 0 {
+				prev := records[j-1]
+				binary.LittleEndian.PutUint16(b[prev.offset+4:prev.offset+6], encodeRecordLength(prev.length+r.length, int(fs.blockSize)))
+			} else {
+				binary.LittleEndian.PutUint32(b[r.offset:r.offset+4], 0)
+			}
+			if hasTail {
+				err = fs.writeDirectoryBlock(dir, extents, i, b)
+			} else {
+				e := findExtent(extents, i)
+				_, err = fs.file.WriteAt(b, fs.start+int64(e.startBlock+uint64(i-e.fileBlock))*fs.blockSize)
+			}
+			if err != nil {
+				return fmt.Errorf("could not write directory block %d: %v", i, err)
+			}
+			now := time.Now()
+			dir.modifyTime, dir.changeTime = now, now
+			return fs.writeInode(dir)
+		}
+	}
+	return fmt.Errorf("%s does not exist in directory %d: %w", name, dir.number, os.ErrNotExist)
+}
+
+// dropDirectoryIndex turns an indexed directory into a linear one: the root block keeps only . and .., and
+// the other index blocks become empty blocks. The leaf blocks are already ordinary directory blocks. It
+// leaves the directory inode for the caller to write.
+func (fs *FileSystem) dropDirectoryIndex(dir *inode, extents []extent) error {
+	root, err := fs.readFileBlock(extents, 0)
+	if err != nil {
+		return err
+	}
+	info := root[dxRootInfoOffset:]
+	infoLength, levels := int(info[5]), int(info[6])
+	if levels >= maxHtreeLevels || dxRootInfoOffset+infoLength+4 > len(root) {
+		return fmt.Errorf("htree root has invalid info")
+	}
+	var nodes []uint32
+	var collect func(entries []byte, level int) error
+	collect = func(entries []byte, level int) error {
+		if level >= levels {
+			return nil
+		}
+		count := int(binary.LittleEndian.Uint16(entries[2:4]))
+		if count*dxEntrySize > len(entries) {
+			return fmt.Errorf("htree node has invalid count %d", count)
+		}
+		for i := 0; i < count; i++ {
+			block := binary.LittleEndian.Uint32(entries[i*dxEntrySize+4:])
+			node, err := fs.readFileBlock(extents, block)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, block)
+			if err := collect(node[dxNodeEntriesOffset:], level+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := collect(root[dxRootInfoOffset+infoLength:], 0); err != nil {
+		return err
+	}
+
+	space := fs.directorySpace()
+	parent := binary.LittleEndian.Uint32(root[12:16])
+	b := newDirectoryBlock(int(fs.blockSize), space,
+		&directoryEntry{inode: dir.number, name: ".", fileType: fileTypeDirectory},
+		&directoryEntry{inode: parent, name: "..", fileType: fileTypeDirectory},
+	)
+	if err := fs.writeDirectoryBlock(dir, extents, 0, b); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := fs.writeDirectoryBlock(dir, extents, node, newDirectoryBlock(int(fs.blockSize), space)); err != nil {
+			return err
+		}
+	}
+	dir.flags &^= inodeFlagIndex
+	return nil
+}
+
+// setParentEntry changes the .. entry of a directory that has moved to a new parent, and writes the
+// directory inode
+func (fs *FileSystem) setParentEntry(dir *inode, parent uint32) error {
+	extents, err := fs.fileExtents(dir)
+	if err != nil {
+		return err
+	}
+	if dir.flags&inodeFlagIndex != 0 {
+		// .. is covered by the checksum of the index root, so it is simplest to drop the index
+		if err := fs.dropDirectoryIndex(dir, extents); err != nil {
+			return fmt.Errorf("could not remove index of directory: %v", err)
+		}
+	}
+	b, err := fs.readFileBlock(extents, 0)
+	if err != nil {
+		return err
+	}
+	records, err := directoryRecords(b[:fs.directorySpace()], int(fs.blockSize))
+	if err != nil {
+		return err
+	}
+	if len(records) < 2 || records[1].nameLength != 2 || string(b[records[1].offset+directoryEntryHeaderSize:records[1].offset+directoryEntryHeaderSize+2]) != ".." {
+		return fmt.Errorf("directory %d has no .. entry", dir.number)
+	}
+	binary.LittleEndian.PutUint32(b[records[1].offset:], parent)
+	if err := fs.writeDirectoryBlock(dir, extents, 0, b); err != nil {
+		return err
+	}
+	dir.changeTime = time.Now()
+	return fs.writeInode(dir)
+}
@@ -0,0 +1,91 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeRecordLength(t *testing.T) {
+	tests := []struct {
+		recLen    uint16
+		blockSize int
+		expected  int
+	}{
+		{12, 4096, 12},
+		{4096 - 24, 4096, 4072},
+		{12, 65536, 12},
+		{0, 65536, 65536},
+		{0xffff, 65536, 65536},
+		// the low 2 bits hold bits 16 and 17
+		{0xfffc | 0x1, 65536, 0x1fffc},
+	}
+	for _, tt := range tests {
+		if got := decodeRecordLength(tt.recLen, tt.blockSize); got != tt.expected {
+			t.Errorf("record length %#x in block of %d: got %d, expected %d", tt.recLen, tt.blockSize, got, tt.expected)
+		}
+	}
+}
+
+func TestDirectoryEntriesFromBytes(t *testing.T) {
+	sb := &superblock{featureIncompat: featureIncompatFiletype}
+	entry := func(b []byte, inode uint32, recLen int, name string, fileType uint8) []byte {
+		e := make([]byte, recLen)
+		binary.LittleEndian.PutUint32(e[0:4], inode)
+		binary.LittleEndian.PutUint16(e[4:6], uint16(recLen))
+		e[6] = uint8(len(name))
+		e[7] = fileType
+		copy(e[8:], name)
+		return append(b, e...)
+	}
+	var b []byte
+	b = entry(b, 2, 12, ".", fileTypeDirectory)
+	b = entry(b, 2, 12, "..", fileTypeDirectory)
+	// a deleted entry
+	b = entry(b, 0, 16, "gone", fileTypeRegular)
+	b = entry(b, 12, 20, "hello.txt", fileTypeRegular)
+	b = entry(b, 13, 1024-len(b)-directoryTailSize, "link", fileTypeSymlink)
+	b = entry(b, 0, directoryTailSize, "", directoryTailFileType)
+
+	if !hasDirectoryTail(b) {
+		t.Errorf("block does not have a directory tail")
+	}
+	entries, err := directoryEntriesFromBytes(b, 1024, sb)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []directoryEntry{
+		{2, ".", fileTypeDirectory},
+		{2, "..", fileTypeDirectory},
+		{12, "hello.txt", fileTypeRegular},
+		{13, "link", fileTypeSymlink},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("got %d entries, expected %d", len(entries), len(expected))
+	}
+	for i, e := range entries {
+		if *e != expected[i] {
+			t.Errorf("entry %d: got %+v, expected %+v", i, *e, expected[i])
+		}
+	}
+
+	// without the filetype feature, there is no type and the name length has 16 bits
+	long := string(make([]byte, 300))
+	entries, err = directoryEntriesFromBytes(entry(nil, 14, 8+300, long, 300>>8), 1024, &superblock{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries[0].fileType != fileTypeUnknown || entries[0].name != long {
+		t.Errorf("entry without filetype feature has type %d and a name of %d bytes", entries[0].fileType, len(entries[0].name))
+	}
+
+	bad := entry(nil, 2, 12, ".", fileTypeDirectory)
+	binary.LittleEndian.PutUint16(bad[4:6], 6)
+	if _, err := directoryEntriesFromBytes(bad, 1024, sb); err == nil {
+		t.Errorf("entry with short record length returned no error")
+	}
+	bad = entry(nil, 2, 12, ".", fileTypeDirectory)
+	binary.LittleEndian.PutUint16(bad[4:6], 16)
+	if _, err := directoryEntriesFromBytes(bad, 1024, sb); err == nil {
+		t.Errorf("entry running past the end returned no error")
+	}
+}
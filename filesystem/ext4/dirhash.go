@@ -0,0 +1,165 @@
+package ext4
+
+import "math/bits"
+
+// directory hash versions, as stored in the root of an htree directory and as the superblock default. The
+// unsigned ones are never stored, they are the signed ones on a filesystem flagged as hashing unsigned chars.
+const (
+	hashLegacy           uint8  = 0
+	hashHalfMD4          uint8  = 1
+	hashTea              uint8  = 2
+	hashLegacyUnsigned   uint8  = 3
+	hashHalfMD4Unsigned  uint8  = 4
+	hashTeaUnsigned      uint8  = 5
+	hashEOF32Bit         uint32 = 0x7fffffff
+	teaDelta             uint32 = 0x9e3779b9
+	halfMD4Round2        uint32 = 013240474631
+	halfMD4Round3        uint32 = 015666365641
+	legacyHashMultiplier uint32 = 7152373
+)
+
+// defaultHashSeed is used when the superblock has no hash seed
+var defaultHashSeed = [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+
+// dirHash calculates the hash of a directory entry name used to index htree directories, and the minor hash
+// that orders names with the same hash. It returns false for hash versions we do not know.
+func dirHash(name []byte, version uint8, seed [4]uint32) (hash, minor uint32, ok bool) {
+	buf := defaultHashSeed
+	if seed != [4]uint32{} {
+		buf = seed
+	}
+	switch version {
+	case hashLegacy, hashLegacyUnsigned:
+		hash = legacyHash(name, version == hashLegacyUnsigned)
+	case hashHalfMD4, hashHalfMD4Unsigned:
+		var in [8]uint32
+		for p := name; len(p) > 0; {
+			str2hashbuf(p, in[:], version == hashHalfMD4Unsigned)
+			halfMD4Transform(&buf, &in)
+			if len(p) <= 32 {
+				break
+			}
+			p = p[32:]
+		}
+		hash, minor = buf[1], buf[2]
+	case hashTea, hashTeaUnsigned:
+		var in [4]uint32
+		for p := name; len(p) > 0; {
+			str2hashbuf(p, in[:], version == hashTeaUnsigned)
+			teaTransform(&buf, &in)
+			if len(p) <= 16 {
+				break
+			}
+			p = p[16:]
+		}
+		hash, minor = buf[0], buf[1]
+	default:
+		return 0, 0, false
+	}
+	hash &^= 1
+	if hash == hashEOF32Bit<<1 {
+		hash = (hashEOF32Bit - 1) << 1
+	}
+	return hash, minor, true
+}
+
+// char returns a byte of a name as the kernel sees it, a signed or an unsigned char
+func char(c byte, unsigned bool) uint32 {
+	if unsigned {
+		return uint32(c)
+	}
+	return uint32(int32(int8(c)))
+}
+
+func legacyHash(name []byte, unsigned bool) uint32 {
+	var hash, hash0, hash1 uint32 = 0, 0x12a3fe2d, 0x37abe8f9
+	for _, c := range name {
+		hash = hash1 + (hash0 ^ char(c, unsigned)*legacyHashMultiplier)
+		if hash&0x80000000 != 0 {
+			hash -= 0x7fffffff
+		}
+		hash1, hash0 = hash0, hash
+	}
+	return hash0 << 1
+}
+
+// str2hashbuf packs up to 4 bytes of a name per word into buf, padding with the length of what is left
+func str2hashbuf(msg []byte, buf []uint32, unsigned bool) {
+	pad := uint32(len(msg)) | uint32(len(msg))<<8
+	pad |= pad << 16
+	val := pad
+	if len(msg) > 4*len(buf) {
+		msg = msg[:4*len(buf)]
+	}
+	i := 0
+	for j, c := range msg {
+		val = char(c, unsigned) + val<<8
+		if j%4 == 3 {
+			buf[i] = val
+			i++
+			val = pad
+		}
+	}
+	if i < len(buf) {
+		buf[i] = val
+		i++
+	}
+	for ; i < len(buf); i++ {
+		buf[i] = pad
+	}
+}
+
+func teaTransform(buf *[4]uint32, in *[4]uint32) {
+	var sum uint32
+	b0, b1 := buf[0], buf[1]
+	a, b, c, d := in[0], in[1], in[2], in[3]
+	for n := 0; n < 16; n++ {
+		sum += teaDelta
+		b0 += ((b1 << 4) + a) ^ (b1 + sum) ^ ((b1 >> 5) + b)
+		b1 += ((b0 << 4) + c) ^ (b0 + sum) ^ ((b0 >> 5) + d)
+	}
+	buf[0] += b0
+	buf[1] += b1
+}
+
+func halfMD4Transform(buf *[4]uint32, in *[8]uint32) {
+	f := func(x, y, z uint32) uint32 { return z ^ (x & (y ^ z)) }
+	g := func(x, y, z uint32) uint32 { return (x & y) + ((x ^ y) & z) }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	round := func(fn func(x, y, z uint32) uint32, a *uint32, b, c, d, x uint32, s int) {
+		*a = bits.RotateLeft32(*a+fn(b, c, d)+x, s)
+	}
+	a, b, c, d := buf[0], buf[1], buf[2], buf[3]
+
+	round(f, &a, b, c, d, in[0], 3)
+	round(f, &d, a, b, c, in[1], 7)
+	round(f, &c, d, a, b, in[2], 11)
+	round(f, &b, c, d, a, in[3], 19)
+	round(f, &a, b, c, d, in[4], 3)
+	round(f, &d, a, b, c, in[5], 7)
+	round(f, &c, d, a, b, in[6], 11)
+	round(f, &b, c, d, a, in[7], 19)
+
+	round(g, &a, b, c, d, in[1]+halfMD4Round2, 3)
+	round(g, &d, a, b, c, in[3]+halfMD4Round2, 5)
+	round(g, &c, d, a, b, in[5]+halfMD4Round2, 9)
+	round(g, &b, c, d, a, in[7]+halfMD4Round2, 13)
+	round(g, &a, b, c, d, in[0]+halfMD4Round2, 3)
+	round(g, &d, a, b, c, in[2]+halfMD4Round2, 5)
+	round(g, &c, d, a, b, in[4]+halfMD4Round2, 9)
+	round(g, &b, c, d, a, in[6]+halfMD4Round2, 13)
+
+	round(h, &a, b, c, d, in[3]+halfMD4Round3, 3)
+	round(h, &d, a, b, c, in[7]+halfMD4Round3, 9)
+	round(h, &c, d, a, b, in[2]+halfMD4Round3, 11)
+	round(h, &b, c, d, a, in[6]+halfMD4Round3, 15)
+	round(h, &a, b, c, d, in[1]+halfMD4Round3, 3)
+	round(h, &d, a, b, c, in[5]+halfMD4Round3, 9)
+	round(h, &c, d, a, b, in[0]+halfMD4Round3, 11)
+	round(h, &b, c, d, a, in[4]+halfMD4Round3, 15)
+
+	buf[0] += a
+	buf[1] += b
+	buf[2] += c
+	buf[3] += d
+}
@@ -0,0 +1,62 @@
+package ext4
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDirHash(t *testing.T) {
+	// calculated with debugfs dx_hash
+	seed := [4]uint32{0x11111111, 0x33332222, 0x55554444, 0x55555555}
+	long := strings.Repeat("x", 40)
+	tests := []struct {
+		name    string
+		version uint8
+		seed    [4]uint32
+		hash    uint32
+		minor   uint32
+	}{
+		{"hello", hashLegacy, [4]uint32{}, 0x32252546, 0},
+		{"file_with_a_long_name_0001", hashLegacy, [4]uint32{}, 0x5910e27c, 0},
+		{long, hashLegacy, [4]uint32{}, 0x382d277e, 0},
+		{"é", hashLegacy, [4]uint32{}, 0x11083c86, 0},
+		{"hello", hashHalfMD4, [4]uint32{}, 0x1746da32, 0x420013b5},
+		{"file_with_a_long_name_0001", hashHalfMD4, [4]uint32{}, 0x8c4943d8, 0x0fab6dab},
+		{long, hashHalfMD4, [4]uint32{}, 0xa58368b6, 0x7a9f8ab7},
+		{"é", hashHalfMD4, [4]uint32{}, 0x89d4704e, 0x75d52d82},
+		{"hello", hashHalfMD4, seed, 0xe4a977aa, 0xb8f2ce63},
+		{"file_with_a_long_name_0001", hashHalfMD4, seed, 0x02d6a930, 0x64f55af5},
+		{long, hashHalfMD4, seed, 0x87e4a594, 0x88b91e04},
+		{"é", hashHalfMD4, seed, 0x7802026a, 0xd45cb9c3},
+		{"hello", hashTea, [4]uint32{}, 0x6f5bb1a8, 0x231917c2},
+		{"file_with_a_long_name_0001", hashTea, [4]uint32{}, 0x493b5af6, 0x55a224a4},
+		{long, hashTea, [4]uint32{}, 0xb3f4bbfc, 0xbfd548a9},
+		{"é", hashTea, [4]uint32{}, 0x591e9bd6, 0xf780721f},
+		{"hello", hashTea, seed, 0x4ad5910a, 0x413ecd8c},
+		{"é", hashTea, seed, 0x1e646a00, 0x78ecb023},
+		// unsigned only differs for bytes over 0x7f
+		{"hello", hashHalfMD4Unsigned, [4]uint32{}, 0x1746da32, 0x420013b5},
+		{"hello", hashTeaUnsigned, [4]uint32{}, 0x6f5bb1a8, 0x231917c2},
+		{"hello", hashLegacyUnsigned, [4]uint32{}, 0x32252546, 0},
+	}
+	for _, tt := range tests {
+		hash, minor, ok := dirHash([]byte(tt.name), tt.version, tt.seed)
+		if !ok {
+			t.Errorf("%q version %d: unknown hash version", tt.name, tt.version)
+			continue
+		}
+		if hash != tt.hash || minor != tt.minor {
+			t.Errorf("%q version %d: got %#08x minor %#08x, expected %#08x minor %#08x", tt.name, tt.version, hash, minor, tt.hash, tt.minor)
+		}
+	}
+	for _, version := range []uint8{hashLegacyUnsigned, hashHalfMD4Unsigned, hashTeaUnsigned} {
+		signed, _, _ := dirHash([]byte("é"), version-hashLegacyUnsigned, [4]uint32{})
+		unsigned, _, _ := dirHash([]byte("é"), version, [4]uint32{})
+		if signed == unsigned {
+			t.Errorf("version %d: unsigned hash of a name with high bytes is the same as the signed one", version)
+		}
+	}
+	if _, _, ok := dirHash([]byte("hello"), 6, [4]uint32{}); ok {
+		t.Errorf("unknown hash version returned ok")
+	}
+}
@@ -0,0 +1,9 @@
+// Package ext4 provides utilities to interact with an ext4 filesystem on a block device or a disk image.
+// It reads ext2 and ext3 as well, which are ext4 without some of its features, and creates and writes ext4.
+//
+// references:
+//
+//	https://www.kernel.org/doc/html/latest/filesystems/ext4/index.html
+//	https://ext4.wiki.kernel.org/index.php/Ext4_Disk_Layout
+//	https://github.com/torvalds/linux/tree/master/fs/ext4
+package ext4
@@ -0,0 +1,1779 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxSymlinkHops is how many symlinks are followed in a path before giving up, as Linux does
+	maxSymlinkHops = 40
+	// dxRootInfoOffset is where the htree information is in the first block of an indexed directory, after
+	// the entries for . and ..
+	dxRootInfoOffset = 24
+	// dxNodeEntriesOffset is where the index entries are in the other index blocks, after an empty directory
+	// entry that covers the whole block
+	dxNodeEntriesOffset = 8
+	// dxEntrySize is the size of each index entry
+	dxEntrySize = 8
+	// maxHtreeLevels is the most levels of index blocks under the root, with the largedir feature
+	maxHtreeLevels = 3
+	// inodeFlagCasefold marks a directory whose names are looked up without regard to case
+	inodeFlagCasefold uint32 = 0x40000000
+	// maxLinks is the most links an inode can have. A directory with more subdirectories than that has a
+	// link count of 1 instead, with the dir_nlink feature.
+	maxLinks = 65000
+	// maxVolumeLabelLength is the longest a label can be, in bytes
+	maxVolumeLabelLength = 16
+	// defaultInodeSize is the size of the inodes Create makes, big enough for nanosecond times and some
+	// extended attributes
+	defaultInodeSize = 256
+	// defaultExtraIsize is how much of each inode Create uses for the fields after the first 128 bytes
+	defaultExtraIsize = 32
+	// reservedBlocksPercent is how much of the filesystem Create reserves for root, as mke2fs does
+	reservedBlocksPercent = 5
+	// defaultMountOptions are the mount options Create sets, user_xattr and acl
+	defaultMountOptions uint32 = 0x000c
+	// lostAndFoundSize is the size mke2fs makes lost+found, so that e2fsck has room to put files in it
+	// without allocating blocks
+	lostAndFoundSize = 16 * KB
+	// MinSize is the smallest filesystem Create makes
+	MinSize = 64 * KB
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	superblock       *superblock
+	groupDescriptors []*groupDescriptor
+	blockSize        int64
+	size             int64
+	start            int64
+	file             util.File
+	// blockBitmaps and inodeBitmaps are those of the groups read so far, by group
+	blockBitmaps map[uint32]bitmap
+	inodeBitmaps map[uint32]bitmap
+}
+
+// Equal compare if two filesystems are equal
+func (fs *FileSystem) Equal(a *FileSystem) bool {
+	localMatch := fs.file == a.file && fs.size == a.size && fs.start == a.start
+	return localMatch && fs.superblock.uuid == a.superblock.uuid
+}
+
+// Create creates an ext4 filesystem in a given file or device
+//
+// requires the util.File where to create the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File to create the filesystem,
+// and blocksize is is the logical blocksize to use for creating the filesystem
+//
+// note that you are *not* required to create the filesystem on the entire disk. You could have a disk of size
+// 20GB, and create a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for creating filesystems on disk partitions.
+//
+// The filesystem block size is picked from the size, so blocksize is only checked: it must be 0, or a power
+// of 2 from 512 to 4096 bytes.
+//
+// The filesystem is laid out as mke2fs lays out one of type ext4, but without flex_bg or room to grow the group
+// descriptors: 1KB blocks below 512MB and 4KB ones above, 256 byte inodes, metadata_csum, and a journal, which
+// is empty, for any filesystem of 2048 blocks or more. It has a root directory and lost+found.
+func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*FileSystem, error) {
+	switch blocksize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("blocksize for ext4 must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	if size < MinSize {
+		return nil, fmt.Errorf("requested size is smaller than minimum allowed ext4 size, requested %d minimum %d", size, MinSize)
+	}
+	if len(volumeLabel) > maxVolumeLabelLength {
+		return nil, fmt.Errorf("invalid volume label: too long at %d bytes, maximum is %d", len(volumeLabel), maxVolumeLabelLength)
+	}
+
+	blockSize, inodeRatio := defaultBlockSize(size)
+	logBlockSize := uint32(0)
+	for s := blockSize; s > 1024; s >>= 1 {
+		logBlockSize++
+	}
+	now := time.Now()
+	fsUUID := uuid.New()
+	hashSeed := uuid.New()
+	sb := &superblock{
+		blocksCount:         uint64(size / blockSize),
+		logBlockSize:        logBlockSize,
+		logClusterSize:      logBlockSize,
+		blocksPerGroup:      uint32(blockSize * 8),
+		clustersPerGroup:    uint32(blockSize * 8),
+		writeTime:           now,
+		maxMountCount:       -1,
+		state:               1,
+		errors:              1,
+		lastCheck:           now,
+		revision:            1,
+		firstInode:          11,
+		inodeSize:           defaultInodeSize,
+		featureCompat:       featureCompatExtAttr | featureCompatDirIndex,
+		featureIncompat:     featureIncompatFiletype | featureIncompatExtents | featureIncompat64Bit,
+		featureROCompat:     featureROCompatSparseSuper | featureROCompatLargeFile | featureROCompatHugeFile | featureROCompatDirNlink | featureROCompatExtraIsize | featureROCompatMetadataCsum,
+		uuid:                fsUUID,
+		volumeName:          volumeLabel,
+		defaultHashVersion:  hashHalfMD4,
+		descSize:            64,
+		defaultMountOptions: defaultMountOptions,
+		mkfsTime:            now,
+		minExtraIsize:       defaultExtraIsize,
+		wantExtraIsize:      defaultExtraIsize,
+		flags:               flagSignedHash,
+		checksumType:        checksumTypeCrc32c,
+	}
+	for i := range sb.hashSeed {
+		sb.hashSeed[i] = binary.LittleEndian.Uint32(hashSeed[4*i:])
+	}
+	// with 1KB blocks, the superblock has the whole of block 1 to itself
+	if blockSize == 1024 {
+		sb.firstDataBlock = 1
+	}
+
+	// fill the inode tables of whole blocks, as many inodes as the inode ratio asks for, and drop a last group
+	// too small to be worth its metadata, as mke2fs does
+	inodesPerBlock := uint32(blockSize) / defaultInodeSize
+	var inodeTableBlocks uint32
+	for {
+		if sb.blocksCount <= uint64(sb.firstDataBlock) {
+			return nil, fmt.Errorf("requested size %d is too small for an ext4 filesystem", size)
+		}
+		groups := sb.blockGroups()
+		inodes := uint64(sb.blocksCount) * uint64(blockSize) / uint64(inodeRatio)
+		perGroup := uint32((inodes + uint64(groups) - 1) / uint64(groups))
+		if perGroup < 16 {
+			perGroup = 16
+		}
+		// a multiple of 8, so the inode bitmap is whole bytes
+		round := inodesPerBlock
+		if round < 8 {
+			round = 8
+		}
+		perGroup = (perGroup + round - 1) / round * round
+		if perGroup > sb.blocksPerGroup {
+			perGroup = sb.blocksPerGroup
+		}
+		sb.inodesPerGroup = perGroup
+		sb.inodesCount = perGroup * groups
+		inodeTableBlocks = perGroup / inodesPerBlock
+
+		last := groups - 1
+		overhead := uint64(sb.groupBaseBlocks(last)) + 2 + uint64(inodeTableBlocks)
+		remaining := (sb.blocksCount - uint64(sb.firstDataBlock)) % uint64(sb.blocksPerGroup)
+		if remaining == 0 || remaining >= overhead+50 {
+			break
+		}
+		if groups == 1 {
+			return nil, fmt.Errorf("requested size %d is too small for an ext4 filesystem", size)
+		}
+		sb.blocksCount -= remaining
+	}
+	sb.reservedBlocksCount = sb.blocksCount * reservedBlocksPercent / 100
+	journalBlocks := defaultJournalBlocks(sb.blocksCount)
+	if journalBlocks > 0 {
+		sb.featureCompat |= featureCompatHasJournal
+		sb.journalInode = journalInode
+		sb.journalBackupType = journalBackupTypeInode
+	}
+
+	// every group starts out with nothing but its metadata, so its bitmaps are worked out from that when
+	// they are needed
+	groups := sb.blockGroups()
+	gds := make([]*groupDescriptor, groups)
+	for i := uint32(0); i < groups; i++ {
+		first := sb.groupFirstBlock(i)
+		base := sb.groupBaseBlocks(i)
+		gds[i] = &groupDescriptor{
+			number:           i,
+			blockBitmap:      first + uint64(base),
+			inodeBitmap:      first + uint64(base) + 1,
+			inodeTable:       first + uint64(base) + 2,
+			freeBlocks:       sb.groupBlockCount(i) - base - 2 - inodeTableBlocks,
+			freeInodes:       sb.inodesPerGroup,
+			flags:            bgBlockUninit | bgInodeUninit,
+			inodeTableUnused: sb.inodesPerGroup,
+		}
+	}
+	fs := &FileSystem{
+		superblock:       sb,
+		groupDescriptors: gds,
+		blockSize:        blockSize,
+		size:             size,
+		start:            start,
+		file:             f,
+	}
+
+	// the reserved inodes before the first one for files are in use, and all zeros but for the root
+	// directory and the journal
+	inodeBitmap, err := fs.inodeBitmap(0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < sb.firstInode-1; i++ {
+		inodeBitmap.set(i)
+	}
+	gds[0].freeInodes -= sb.firstInode - 1
+	gds[0].inodeTableUnused -= sb.firstInode - 1
+	zero := make([]byte, sb.inodeSize)
+	for number := uint32(1); number < sb.firstInode; number++ {
+		if _, err := f.WriteAt(zero, start+int64(gds[0].inodeTable)*blockSize+int64(number-1)*int64(sb.inodeSize)); err != nil {
+			return nil, fmt.Errorf("could not write inode %d: %v", number, err)
+		}
+	}
+
+	root := fs.initInode(rootInode, modeDirectory|0o755, now)
+	root.linksCount = 3
+	gds[0].usedDirectories++
+	lostFound, err := fs.newInode(root, modeDirectory|0o700)
+	if err != nil {
+		return nil, fmt.Errorf("could not allocate inode for lost+found: %v", err)
+	}
+	lostFound.linksCount = 2
+	if err := fs.writeNewDirectory(root, root, &directoryEntry{inode: lostFound.number, name: "lost+found", fileType: fileTypeDirectory}); err != nil {
+		return nil, fmt.Errorf("could not write root directory: %v", err)
+	}
+	if err := fs.writeNewDirectory(lostFound, root); err != nil {
+		return nil, fmt.Errorf("could not write lost+found: %v", err)
+	}
+	// lost+found gets more blocks than it needs, so that e2fsck can put files in it without allocating any
+	lostFoundBlocks := uint32(lostAndFoundSize / blockSize)
+	switch {
+	case lostFoundBlocks < 2:
+		lostFoundBlocks = 2
+	case lostFoundBlocks > directBlocks:
+		lostFoundBlocks = directBlocks
+	}
+	for i := uint32(1); i < lostFoundBlocks; i++ {
+		if err := fs.growDirectory(lostFound, nil); err != nil {
+			return nil, fmt.Errorf("could not write lost+found: %v", err)
+		}
+	}
+
+	if journalBlocks > 0 {
+		// in the middle of the filesystem, as mke2fs puts it
+		journal := fs.initInode(journalInode, modeRegular|0o600, now)
+		runs, err := fs.allocateBlocks(sb.groupFirstBlock(groups/2), uint64(journalBlocks))
+		if err != nil {
+			return nil, fmt.Errorf("could not allocate blocks for journal: %v", err)
+		}
+		var extents []extent
+		fileBlock := uint32(0)
+		for _, r := range runs {
+			r.fileBlock = fileBlock
+			extents = setExtent(extents, r)
+			fileBlock += r.count
+		}
+		journal.size = uint64(journalBlocks) * uint64(blockSize)
+		fs.addInodeBlocks(journal, int64(journalBlocks))
+		if _, err := f.WriteAt(journalSuperblockBytes(int(blockSize), journalBlocks, sb.uuid), start+int64(runs[0].startBlock)*blockSize); err != nil {
+			return nil, fmt.Errorf("could not write journal superblock: %v", err)
+		}
+		if err := fs.writeExtentTree(journal, extents); err != nil {
+			return nil, fmt.Errorf("could not write journal inode: %v", err)
+		}
+		// a copy of where the journal is, in case its inode is lost
+		for i := 0; i < inodeBlockSize/4; i++ {
+			sb.journalBlocks[i] = binary.LittleEndian.Uint32(journal.block[4*i:])
+		}
+		sb.journalBlocks[15] = uint32(journal.size >> 32)
+		sb.journalBlocks[16] = uint32(journal.size)
+	}
+
+	// write every bitmap, though the inode bitmaps of groups with no inodes in use are still left
+	// uninitialized, so that the kernel knows it need not read their inode tables
+	for i := uint32(0); i < groups; i++ {
+		if _, err := fs.blockBitmap(i); err != nil {
+			return nil, err
+		}
+		if err := fs.writeBlockBitmap(i); err != nil {
+			return nil, err
+		}
+		bm, err := fs.inodeBitmap(i)
+		if err != nil {
+			return nil, err
+		}
+		gds[i].inodeBitmapCsum = bm.checksum(sb.inodesPerGroup, sb)
+		if _, err := f.WriteAt(bm, start+int64(gds[i].inodeBitmap)*blockSize); err != nil {
+			return nil, fmt.Errorf("could not write inode bitmap of group %d: %v", i, err)
+		}
+	}
+	if err := fs.writeSuperblock(); err != nil {
+		return nil, err
+	}
+	if err := fs.writeBackups(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// defaultBlockSize is the block size and the bytes per inode mke2fs picks for a filesystem of the given size
+func defaultBlockSize(size int64) (blockSize, inodeRatio int64) {
+	switch {
+	case size < 3*MB:
+		return 1024, 8192
+	case size < 512*MB:
+		return 1024, 4096
+	case size < 4*TB:
+		return 4096, 16384
+	case size < 16*TB:
+		return 4096, 32768
+	}
+	return 4096, 65536
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// The filesystem block size is taken from the superblock, so blocksize is only checked: it must be 0, or a
+// power of 2 from 512 to 4096 bytes.
+//
+// It reads ext2 and ext3 filesystems as well as ext4, as long as they do not use features it does not know.
+// It can write to those with extents, and without any features it cannot write, such as inline_data or
+// bigalloc, and with no journal waiting to be replayed; for any other, the methods that write return an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	switch blocksize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("blocksize for ext4 must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	if size < superblockOffset+superblockSize {
+		return nil, fmt.Errorf("requested size %d is too small to hold an ext4 superblock", size)
+	}
+
+	b := make([]byte, superblockSize)
+	n, err := file.ReadAt(b, start+superblockOffset)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bytes from file: %v", err)
+	}
+	if n < len(b) {
+		return nil, fmt.Errorf("only could read %d bytes from file", n)
+	}
+	sb, err := superblockFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ext4 superblock: %v", err)
+	}
+	blockSize := int64(sb.blockSize())
+	if total := int64(sb.blocksCount) * blockSize; total > size {
+		return nil, fmt.Errorf("ext4 filesystem of %d bytes is larger than the %d bytes available", total, size)
+	}
+
+	fs := &FileSystem{
+		superblock: sb,
+		blockSize:  blockSize,
+		size:       size,
+		start:      start,
+		file:       file,
+	}
+
+	// read the group descriptors, a block at a time as they need not be together with meta_bg
+	groups := sb.blockGroups()
+	fs.groupDescriptors = make([]*groupDescriptor, groups)
+	var (
+		gdtBlock      []byte
+		gdtBlockIndex uint64
+	)
+	for i := uint32(0); i < groups; i++ {
+		location, index := groupDescriptorLocation(i, sb)
+		if gdtBlock == nil || location != gdtBlockIndex {
+			if gdtBlock, err = fs.readBlock(location); err != nil {
+				return nil, fmt.Errorf("could not read group descriptors: %v", err)
+			}
+			gdtBlockIndex = location
+		}
+		descSize := sb.groupDescriptorSize()
+		gd, err := groupDescriptorFromBytes(gdtBlock[index*descSize:(index+1)*descSize], i, sb)
+		if err != nil {
+			return nil, fmt.Errorf("error reading group descriptor: %v", err)
+		}
+		fs.groupDescriptors[i] = gd
+	}
+
+	// make sure the root directory is there
+	root, err := fs.readInode(rootInode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read root directory: %v", err)
+	}
+	if !root.isDir() {
+		return nil, fmt.Errorf("root inode is not a directory")
+	}
+	return fs, nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeExt4
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeExt4
+}
+
+// Mkdir make a directory at the given path. It is equivalent to `mkdir -p`, i.e. idempotent, in that:
+//
+// * It will make the entire tree path if it does not exist
+// * It will not return an error if the path already exists
+func (fs *FileSystem) Mkdir(p string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot make directory %s: %w", p, err)
+	}
+	parts, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	current, err := fs.readInode(rootInode)
+	if err != nil {
+		return fmt.Errorf("unable to read root directory: %v", err)
+	}
+	for i, part := range parts {
+		dirPath := "/" + strings.Join(parts[:i+1], "/")
+		de, err := fs.lookup(current, part)
+		if err != nil {
+			return fmt.Errorf("could not read directory %s: %v", path.Dir(dirPath), err)
+		}
+		if de == nil {
+			if current, err = fs.mkdirIn(current, part); err != nil {
+				return fmt.Errorf("could not make directory %s: %v", dirPath, err)
+			}
+			continue
+		}
+		in, _, err := fs.resolvePath(dirPath, true)
+		if err != nil {
+			return fmt.Errorf("could not make directory %s: %w", dirPath, err)
+		}
+		if !in.isDir() {
+			return fmt.Errorf("cannot make directory %s: %s is not a directory", p, dirPath)
+		}
+		current = in
+	}
+	return nil
+}
+
+// mkdirIn makes a directory called name in parent, and returns it
+func (fs *FileSystem) mkdirIn(parent *inode, name string) (*inode, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	if parent.linksCount >= maxLinks && fs.superblock.featureROCompat&featureROCompatDirNlink == 0 {
+		return nil, fmt.Errorf("directory has too many subdirectories")
+	}
+	dir, err := fs.newInode(parent, modeDirectory|0o755)
+	if err != nil {
+		return nil, err
+	}
+	dir.linksCount = 2
+	if err := fs.writeNewDirectory(dir, parent); err != nil {
+		return nil, err
+	}
+	if err := fs.addDirectoryEntry(parent, &directoryEntry{inode: dir.number, name: name, fileType: fileTypeDirectory}); err != nil {
+		return nil, err
+	}
+	fs.incDirLinks(parent)
+	if err := fs.writeInode(parent); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// ReadDir return the contents of a given directory in a given filesystem, in the order they are stored in.
+// It does not include . and ..
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory, each a *FileInfo.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	dir, _, err := fs.resolvePath(p, true)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	if !dir.isDir() {
+		return nil, fmt.Errorf("cannot read directory %s: not a directory", p)
+	}
+	entries, err := fs.readDirectoryEntries(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+	}
+	ret := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.name == "." || e.name == ".." {
+			continue
+		}
+		in, err := fs.readInode(e.inode)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in directory %s: %v", e.name, p, err)
+		}
+		ret = append(ret, in.fileInfo(e.name))
+	}
+	return ret, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+// or write contents to the file. If the file is a symlink, it opens its target.
+//
+// accepts normal os.OpenFile flags. If the filesystem cannot be written, any that would write return an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+//
+// returns an error if the file does not exist and was not asked to create
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		if err := fs.checkWritable(); err != nil {
+			return nil, fmt.Errorf("cannot open %s for writing: %w", p, err)
+		}
+	}
+	in, _, err := fs.resolvePath(p, true)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0:
+		if in, err = fs.createFile(p); err != nil {
+			return nil, fmt.Errorf("failed to create file %s: %w", p, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("target file %s does not exist: %w", p, err)
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, fmt.Errorf("cannot create %s: %w", p, os.ErrExist)
+	}
+	if in.isDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	if in.fileType() != modeRegular {
+		return nil, fmt.Errorf("cannot open %s, it is not a regular file", p)
+	}
+	f := &File{
+		inode:       in,
+		isReadWrite: flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0,
+		isAppend:    flag&os.O_APPEND != 0,
+		filesystem:  fs,
+	}
+	if in.hasInlineData() {
+		if f.inlineData, err = fs.readInlineData(in); err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", p, err)
+		}
+	} else if f.extents, err = fs.fileExtents(in); err != nil {
+		return nil, fmt.Errorf("could not read blocks of %s: %v", p, err)
+	}
+	if flag&os.O_TRUNC != 0 && in.size != 0 {
+		if f.extents, err = fs.truncateInode(in, f.extents, 0); err != nil {
+			return nil, fmt.Errorf("unable to truncate %s: %v", p, err)
+		}
+	}
+	if f.isAppend {
+		f.offset = int64(in.size)
+	}
+	return f, nil
+}
+
+// createFile creates an empty regular file at p, whose directory must exist
+func (fs *FileSystem) createFile(p string) (*inode, error) {
+	parent, name, err := fs.resolveParent(p)
+	if err != nil {
+		return nil, err
+	}
+	in, err := fs.newInode(parent, modeRegular|0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.writeInode(in); err != nil {
+		return nil, err
+	}
+	if err := fs.addDirectoryEntry(parent, &directoryEntry{inode: in.number, name: name, fileType: fileTypeRegular}); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// Stat returns the FileInfo for a file or directory, a *FileInfo. If p is a symlink, Stat returns the
+// FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	in, name, err := fs.resolvePath(p, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat %s: %w", p, err)
+	}
+	return in.fileInfo(name), nil
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	in, name, err := fs.resolvePath(p, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat %s: %w", p, err)
+	}
+	return in.fileInfo(name), nil
+}
+
+// Readlink returns the target of a symlink
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	in, _, err := fs.resolvePath(p, false)
+	if err != nil {
+		return "", fmt.Errorf("could not read link %s: %w", p, err)
+	}
+	if !in.isSymlink() {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	target, err := fs.readSymlink(in)
+	if err != nil {
+		return "", fmt.Errorf("could not read link %s: %v", p, err)
+	}
+	return target, nil
+}
+
+// Symlink creates newname as a symlink to oldname. A target shorter than 60 bytes is kept in the inode
+// itself, and a longer one in a block.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot create symlink %s: %w", newname, err)
+	}
+	if oldname == "" || int64(len(oldname)) >= fs.blockSize {
+		return fmt.Errorf("invalid symlink target of %d bytes", len(oldname))
+	}
+	parent, name, err := fs.resolveParent(newname)
+	if err != nil {
+		return fmt.Errorf("cannot create symlink %s: %w", newname, err)
+	}
+	in, err := fs.newInode(parent, modeSymlink|0o777)
+	if err != nil {
+		return fmt.Errorf("cannot create symlink %s: %v", newname, err)
+	}
+	if len(oldname) < inodeBlockSize {
+		in.flags &^= inodeFlagExtents
+		in.block = [inodeBlockSize]byte{}
+		copy(in.block[:], oldname)
+		in.size = uint64(len(oldname))
+		err = fs.writeInode(in)
+	} else {
+		_, err = fs.writeData(in, nil, []byte(oldname), 0)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create symlink %s: %v", newname, err)
+	}
+	if err := fs.addDirectoryEntry(parent, &directoryEntry{inode: in.number, name: name, fileType: fileTypeSymlink}); err != nil {
+		return fmt.Errorf("cannot create symlink %s: %v", newname, err)
+	}
+	return nil
+}
+
+// Getxattr returns the value of the named extended attribute of p. Returns an error wrapping
+// filesystem.ErrXattrNotExist if p does not have it.
+//
+// POSIX ACLs are returned as the system.posix_acl_access and system.posix_acl_default attributes, in the
+// same format as Linux returns them.
+func (fs *FileSystem) Getxattr(p, name string) ([]byte, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	val, ok := xattrs[name]
+	if !ok || name == xattrInlineDataName {
+		return nil, fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	return val, nil
+}
+
+// Listxattr returns the sorted names of the extended attributes of p
+func (fs *FileSystem) Listxattr(p string) ([]string, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(xattrs))
+	for k := range xattrs {
+		// the rest of the inline data is kept as an xattr, but it is not one to anyone else
+		if k != xattrInlineDataName {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Setxattr sets the named extended attribute of p, replacing any value it had. The name must start with one
+// of the prefixes ext4 keeps extended attributes under: user., trusted., security. or system.
+//
+// POSIX ACLs are set as the system.posix_acl_access and system.posix_acl_default attributes, in the same
+// format as Linux takes them.
+func (fs *FileSystem) Setxattr(p, name string, value []byte) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot set xattr on %s: %w", p, err)
+	}
+	in, xattrs, err := fs.getInodeXattrs(p)
+	if err != nil {
+		return err
+	}
+	xattrs[name] = value
+	if err := fs.writeXattrs(in, xattrs); err != nil {
+		return fmt.Errorf("could not set xattr %s on %s: %v", name, p, err)
+	}
+	return nil
+}
+
+// Removexattr removes the named extended attribute of p. Returns an error wrapping
+// filesystem.ErrXattrNotExist if p does not have it.
+func (fs *FileSystem) Removexattr(p, name string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot remove xattr from %s: %w", p, err)
+	}
+	in, xattrs, err := fs.getInodeXattrs(p)
+	if err != nil {
+		return err
+	}
+	if _, ok := xattrs[name]; !ok {
+		return fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	delete(xattrs, name)
+	if err := fs.writeXattrs(in, xattrs); err != nil {
+		return fmt.Errorf("could not remove xattr %s from %s: %v", name, p, err)
+	}
+	return nil
+}
+
+// getXattrs returns the xattrs of p itself, not following a symlink
+func (fs *FileSystem) getXattrs(p string) (map[string][]byte, error) {
+	_, xattrs, err := fs.getInodeXattrs(p)
+	return xattrs, err
+}
+
+// getInodeXattrs returns the inode of p itself, not following a symlink, and its xattrs
+func (fs *FileSystem) getInodeXattrs(p string) (*inode, map[string][]byte, error) {
+	in, _, err := fs.resolvePath(p, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not stat %s: %w", p, err)
+	}
+	xattrs, err := fs.readXattrs(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read xattrs of %s: %v", p, err)
+	}
+	return in, xattrs, nil
+}
+
+// Remove removes a file, symlink or empty directory. A file with other links to it is only unlinked; its
+// blocks and inode are freed with the last link.
+func (fs *FileSystem) Remove(p string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot remove %s: %w", p, err)
+	}
+	parent, name, err := fs.resolveParent(p)
+	if err != nil {
+		return fmt.Errorf("cannot remove %s: %w", p, err)
+	}
+	de, err := fs.lookup(parent, name)
+	if err != nil {
+		return fmt.Errorf("could not read directory %s: %v", path.Dir(p), err)
+	}
+	if de == nil {
+		return fmt.Errorf("cannot remove %s: %w", p, os.ErrNotExist)
+	}
+	in, err := fs.readInode(de.inode)
+	if err != nil {
+		return err
+	}
+	if in.isDir() {
+		entries, err := fs.readDirectoryEntries(in)
+		if err != nil {
+			return fmt.Errorf("could not read directory %s: %v", p, err)
+		}
+		if len(entries) > 2 {
+			return fmt.Errorf("directory %s is not empty", p)
+		}
+	}
+	if err := fs.removeDirectoryEntry(parent, name); err != nil {
+		return fmt.Errorf("unable to remove %s: %v", p, err)
+	}
+	if in.isDir() {
+		fs.decDirLinks(parent)
+		if err := fs.writeInode(parent); err != nil {
+			return err
+		}
+		in.linksCount = 0
+	} else if in.linksCount > 0 {
+		in.linksCount--
+	}
+	if in.linksCount > 0 {
+		in.changeTime = time.Now()
+		return fs.writeInode(in)
+	}
+	if err := fs.releaseInode(in); err != nil {
+		return fmt.Errorf("unable to remove %s: %v", p, err)
+	}
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it. It returns nil if the path does not exist.
+func (fs *FileSystem) RemoveAll(p string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot remove %s: %w", p, err)
+	}
+	fi, err := fs.Lstat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		children, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := fs.RemoveAll(path.Join(p, child.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fs.Remove(p)
+}
+
+// Rename renames or moves a file or directory. If newpath is an existing file, it is replaced, as long as
+// oldpath is not a directory. The parent directory of newpath must exist.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot rename %s: %w", oldpath, err)
+	}
+	oldParent, oldName, err := fs.resolveParent(oldpath)
+	if err != nil {
+		return fmt.Errorf("cannot rename %s: %w", oldpath, err)
+	}
+	de, err := fs.lookup(oldParent, oldName)
+	if err != nil {
+		return fmt.Errorf("could not read directory %s: %v", path.Dir(oldpath), err)
+	}
+	if de == nil {
+		return fmt.Errorf("cannot rename %s: %w", oldpath, os.ErrNotExist)
+	}
+	newParent, newName, err := fs.resolveParent(newpath)
+	if err != nil {
+		return fmt.Errorf("cannot rename to %s: %w", newpath, err)
+	}
+	if oldParent.number == newParent.number && oldName == newName {
+		return nil
+	}
+	in, err := fs.readInode(de.inode)
+	if err != nil {
+		return err
+	}
+	if in.isDir() {
+		// walk up from the new parent to the root, to make sure the directory is not moving into itself
+		for dir := newParent.number; dir != rootInode; {
+			if dir == in.number {
+				return fmt.Errorf("cannot move directory %s into itself", oldpath)
+			}
+			current, err := fs.readInode(dir)
+			if err != nil {
+				return err
+			}
+			up, err := fs.lookup(current, "..")
+			if err != nil || up == nil {
+				return fmt.Errorf("could not find parent of directory %d: %v", dir, err)
+			}
+			dir = up.inode
+		}
+	}
+
+	// replace any existing file
+	target, err := fs.lookup(newParent, newName)
+	if err != nil {
+		return fmt.Errorf("could not read directory %s: %v", path.Dir(newpath), err)
+	}
+	if target != nil {
+		targetInode, err := fs.readInode(target.inode)
+		switch {
+		case err != nil:
+			return err
+		case target.inode == de.inode:
+			// another link to the same file
+			return nil
+		case targetInode.isDir():
+			return fmt.Errorf("cannot replace directory %s", newpath)
+		case in.isDir():
+			return fmt.Errorf("cannot replace file %s with directory %s", newpath, oldpath)
+		}
+		if err := fs.Remove(newpath); err != nil {
+			return fmt.Errorf("unable to replace %s: %v", newpath, err)
+		}
+		// removing the target changed the new parent, which may be the old one too
+		if newParent, err = fs.readInode(newParent.number); err != nil {
+			return err
+		}
+		if oldParent, err = fs.readInode(oldParent.number); err != nil {
+			return err
+		}
+	}
+	if oldParent.number == newParent.number {
+		newParent = oldParent
+	}
+
+	if err := validateName(newName); err != nil {
+		return err
+	}
+	if err := fs.addDirectoryEntry(newParent, &directoryEntry{inode: de.inode, name: newName, fileType: fileTypeFromMode(in.mode)}); err != nil {
+		return fmt.Errorf("unable to rename %s: %v", oldpath, err)
+	}
+	if err := fs.removeDirectoryEntry(oldParent, oldName); err != nil {
+		return fmt.Errorf("unable to rename %s: %v", oldpath, err)
+	}
+	if in.isDir() && oldParent != newParent {
+		if err := fs.setParentEntry(in, newParent.number); err != nil {
+			return fmt.Errorf("unable to move directory %s: %v", oldpath, err)
+		}
+		fs.decDirLinks(oldParent)
+		if err := fs.writeInode(oldParent); err != nil {
+			return err
+		}
+		fs.incDirLinks(newParent)
+		if err := fs.writeInode(newParent); err != nil {
+			return err
+		}
+	}
+	in.changeTime = time.Now()
+	return fs.writeInode(in)
+}
+
+// Truncate changes the size of a file. If it grows, the new bytes are all zeroes, but take no blocks until
+// they are written; if it shrinks, any blocks no longer needed are freed.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot truncate %s: %w", p, err)
+	}
+	if size < 0 {
+		return fmt.Errorf("invalid size %d for ext4 file", size)
+	}
+	in, _, err := fs.resolvePath(p, true)
+	if err != nil {
+		return fmt.Errorf("cannot truncate %s: %w", p, err)
+	}
+	if in.isDir() {
+		return fmt.Errorf("cannot truncate directory %s", p)
+	}
+	if in.fileType() != modeRegular {
+		return fmt.Errorf("cannot truncate %s, it is not a regular file", p)
+	}
+	extents, err := fs.fileExtents(in)
+	if err != nil {
+		return fmt.Errorf("could not read blocks of %s: %v", p, err)
+	}
+	if _, err := fs.truncateInode(in, extents, uint64(size)); err != nil {
+		return fmt.Errorf("unable to truncate %s: %v", p, err)
+	}
+	return nil
+}
+
+// Label return the filesystem label
+func (fs *FileSystem) Label() string {
+	return fs.superblock.volumeName
+}
+
+// SetLabel changes the filesystem label. It can be up to 16 bytes; "" removes it.
+func (fs *FileSystem) SetLabel(volumeLabel string) error {
+	if err := fs.checkWritable(); err != nil {
+		return fmt.Errorf("cannot set label on ext4 filesystem: %w", err)
+	}
+	if len(volumeLabel) > maxVolumeLabelLength {
+		return fmt.Errorf("invalid volume label: too long at %d bytes, maximum is %d", len(volumeLabel), maxVolumeLabelLength)
+	}
+	fs.superblock.volumeName = volumeLabel
+	return fs.writeSuperblock()
+}
+
+// Statfs get the capacity of the filesystem, with the free blocks and inodes counted from the group
+// descriptors. One that cannot be written has no free space.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	sb := fs.superblock
+	total := int64(sb.blocksCount) * fs.blockSize
+	st := filesystem.Statfs{
+		BlockSize:  fs.blockSize,
+		TotalBytes: total,
+		UsedBytes:  total,
+		Files:      uint64(sb.inodesCount - fs.freeInodes()),
+	}
+	if fs.checkWritable() == nil {
+		st.FreeBytes = int64(fs.freeBlockCount()) * fs.blockSize
+		st.UsedBytes = total - st.FreeBytes
+		st.FreeFiles = uint64(fs.freeInodes())
+	}
+	return st, nil
+}
+
+// freeInodes counts the free inodes in the group descriptors, which are kept up to date when the superblock
+// count is not
+func (fs *FileSystem) freeInodes() uint32 {
+	var free uint32
+	for _, gd := range fs.groupDescriptors {
+		free += gd.freeInodes
+	}
+	return free
+}
+
+// resolvePath finds the inode at p, following symlinks in the directories on the way, and the one at the end
+// as well if followLast is set. It returns the inode and its name, which is "/" for the root.
+func (fs *FileSystem) resolvePath(p string, followLast bool) (*inode, string, error) {
+	parts, err := splitPath(p)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := fs.readInode(rootInode)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read root directory: %v", err)
+	}
+	var (
+		current = root
+		dirPath = "/"
+		hops    int
+	)
+	for i := 0; i < len(parts); i++ {
+		if !current.isDir() {
+			return nil, "", fmt.Errorf("%s is not a directory", dirPath)
+		}
+		de, err := fs.lookup(current, parts[i])
+		if err != nil {
+			return nil, "", fmt.Errorf("could not read directory %s: %v", dirPath, err)
+		}
+		if de == nil {
+			return nil, "", fmt.Errorf("%s does not exist in %s: %w", parts[i], dirPath, os.ErrNotExist)
+		}
+		in, err := fs.readInode(de.inode)
+		if err != nil {
+			return nil, "", err
+		}
+		if in.isSymlink() && (followLast || i < len(parts)-1) {
+			hops++
+			if hops > maxSymlinkHops {
+				return nil, "", fmt.Errorf("too many levels of symlinks at %s", path.Join(dirPath, parts[i]))
+			}
+			target, err := fs.readSymlink(in)
+			if err != nil {
+				return nil, "", fmt.Errorf("could not read link %s: %v", path.Join(dirPath, parts[i]), err)
+			}
+			if !path.IsAbs(target) {
+				target = path.Join(dirPath, target)
+			}
+			targetParts, err := splitPath(target)
+			if err != nil {
+				return nil, "", err
+			}
+			// start again from the root with the target and whatever was left after the symlink
+			parts = append(targetParts, parts[i+1:]...)
+			current, dirPath, i = root, "/", -1
+			continue
+		}
+		current = in
+		dirPath = path.Join(dirPath, parts[i])
+	}
+	return current, path.Base(dirPath), nil
+}
+
+// lookup finds the entry for name in a directory, using its htree index if it has one. It returns nil if
+// there is no such entry.
+func (fs *FileSystem) lookup(dir *inode, name string) (*directoryEntry, error) {
+	if dir.flags&inodeFlagIndex != 0 && dir.flags&inodeFlagCasefold == 0 && !dir.hasInlineData() {
+		// a broken or unknown index can still be read linearly, as the kernel does
+		if de, err := fs.htreeLookup(dir, name); err == nil {
+			return de, nil
+		}
+	}
+	entries, err := fs.readDirectoryEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+// htreeLookup finds the entry for name in an indexed directory by hashing it and following the index down
+// to the one leaf block where it would be, and the blocks after that with the same hash
+func (fs *FileSystem) htreeLookup(dir *inode, name string) (*directoryEntry, error) {
+	extents, err := fs.fileExtents(dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fs.readFileBlock(extents, 0)
+	if err != nil {
+		return nil, err
+	}
+	info := b[dxRootInfoOffset:]
+	version, infoLength, levels := info[4], int(info[5]), int(info[6])
+	switch {
+	case binary.LittleEndian.Uint32(info[0:4]) != 0:
+		return nil, fmt.Errorf("htree root has non-zero reserved field")
+	case levels >= maxHtreeLevels || (levels == maxHtreeLevels-1 && fs.superblock.featureIncompat&featureIncompatLargeDir == 0):
+		return nil, fmt.Errorf("htree has too many levels %d", levels+1)
+	case dxRootInfoOffset+infoLength+dxEntrySize > len(b):
+		return nil, fmt.Errorf("htree root has invalid info length %d", infoLength)
+	}
+	if version <= hashTea && fs.superblock.flags&flagUnsignedHash != 0 {
+		version += hashLegacyUnsigned
+	}
+	hash, _, ok := dirHash([]byte(name), version, fs.superblock.hashSeed)
+	if !ok {
+		return nil, fmt.Errorf("unknown htree hash version %d", version)
+	}
+
+	entries := b[dxRootInfoOffset+infoLength:]
+	for level := 0; ; level++ {
+		limit := int(binary.LittleEndian.Uint16(entries[0:2]))
+		count := int(binary.LittleEndian.Uint16(entries[2:4]))
+		if count == 0 || count > limit || count*dxEntrySize > len(entries) {
+			return nil, fmt.Errorf("htree node has invalid count %d and limit %d", count, limit)
+		}
+		// the first entry has no hash, it takes everything less than the second
+		index := sort.Search(count-1, func(i int) bool {
+			return binary.LittleEndian.Uint32(entries[(i+1)*dxEntrySize:]) > hash
+		})
+		if level < levels {
+			block := binary.LittleEndian.Uint32(entries[index*dxEntrySize+4:])
+			node, err := fs.readFileBlock(extents, block)
+			if err != nil {
+				return nil, err
+			}
+			entries = node[dxNodeEntriesOffset:]
+			continue
+		}
+		for {
+			block := binary.LittleEndian.Uint32(entries[index*dxEntrySize+4:])
+			leaf, err := fs.readFileBlock(extents, block)
+			if err != nil {
+				return nil, err
+			}
+			if fs.superblock.hasMetadataCsum() && hasDirectoryTail(leaf) {
+				if err := verifyDirectoryTail(leaf, dir, fs.superblock); err != nil {
+					return nil, err
+				}
+			}
+			des, err := directoryEntriesFromBytes(leaf, int(fs.blockSize), fs.superblock)
+			if err != nil {
+				return nil, err
+			}
+			for _, de := range des {
+				if de.name == name {
+					return de, nil
+				}
+			}
+			// names with the same hash can go on in the next block, which then has the low bit of its hash set
+			index++
+			if index >= count || binary.LittleEndian.Uint32(entries[index*dxEntrySize:]) != hash|1 {
+				return nil, nil
+			}
+		}
+	}
+}
+
+// readDirectoryEntries reads all of the entries in a directory, including . and ..
+func (fs *FileSystem) readDirectoryEntries(dir *inode) ([]*directoryEntry, error) {
+	if dir.hasInlineData() {
+		b, err := fs.readInlineData(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 4 {
+			return nil, fmt.Errorf("inline directory of %d bytes is too short", len(b))
+		}
+		// inline directories keep only the inode of .. at the start, and no entry for . at all
+		entries := []*directoryEntry{
+			{inode: dir.number, name: ".", fileType: fileTypeDirectory},
+			{inode: binary.LittleEndian.Uint32(b[0:4]), name: "..", fileType: fileTypeDirectory},
+		}
+		// the entries in i_block and those in the system.data xattr are separate runs of entries
+		first := inodeBlockSize
+		if first > len(b) {
+			first = len(b)
+		}
+		for _, run := range [][]byte{b[4:first], b[first:]} {
+			des, err := directoryEntriesFromBytes(run, len(run), fs.superblock)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, des...)
+		}
+		return entries, nil
+	}
+
+	extents, err := fs.fileExtents(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*directoryEntry
+	blocks := (int64(dir.size) + fs.blockSize - 1) / fs.blockSize
+	for i := int64(0); i < blocks; i++ {
+		b, err := fs.readFileBlock(extents, uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		if fs.superblock.hasMetadataCsum() && hasDirectoryTail(b) {
+			if err := verifyDirectoryTail(b, dir, fs.superblock); err != nil {
+				return nil, fmt.Errorf("directory block %d: %v", i, err)
+			}
+		}
+		// index blocks of an htree look like a block with one empty entry, so they are skipped
+		des, err := directoryEntriesFromBytes(b, int(fs.blockSize), fs.superblock)
+		if err != nil {
+			return nil, fmt.Errorf("directory block %d: %v", i, err)
+		}
+		entries = append(entries, des...)
+	}
+	return entries, nil
+}
+
+// readSymlink reads the target of a symlink, which is in i_block for short ones
+func (fs *FileSystem) readSymlink(in *inode) (string, error) {
+	if in.isFastSymlink() {
+		return string(in.block[:in.size]), nil
+	}
+	b, err := fs.readFileContents(in)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readInlineData reads the contents of an inode with inline data, which are in i_block and then in the
+// system.data extended attribute
+func (fs *FileSystem) readInlineData(in *inode) ([]byte, error) {
+	b := make([]byte, inodeBlockSize, int(in.size)+inodeBlockSize)
+	copy(b, in.block[:])
+	xattrs, err := fs.readXattrs(in)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, xattrs[xattrInlineDataName]...)
+	if in.isDir() {
+		// a directory may have room for more entries than its size
+		return b, nil
+	}
+	if uint64(len(b)) < in.size {
+		return nil, fmt.Errorf("inline data of %d bytes is shorter than size %d", len(b), in.size)
+	}
+	return b[:in.size], nil
+}
+
+// readFileContents reads all of the contents of an inode
+func (fs *FileSystem) readFileContents(in *inode) ([]byte, error) {
+	if in.hasInlineData() {
+		return fs.readInlineData(in)
+	}
+	extents, err := fs.fileExtents(in)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, in.size)
+	if _, err := fs.readExtents(extents, b, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readExtents reads len(b) bytes of a file at offset, given its extents. Holes and uninitialized extents
+// read as zeros.
+func (fs *FileSystem) readExtents(extents []extent, b []byte, offset int64) (int, error) {
+	read := 0
+	for read < len(b) {
+		pos := offset + int64(read)
+		fileBlock := uint32(pos / fs.blockSize)
+		within := pos % fs.blockSize
+		toRead := fs.blockSize - within
+		if toRead > int64(len(b)-read) {
+			toRead = int64(len(b) - read)
+		}
+		chunk := b[read : read+int(toRead)]
+		e := findExtent(extents, fileBlock)
+		if e == nil || e.startBlock == 0 || e.uninitialized {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+			read += len(chunk)
+			continue
+		}
+		// read as much of the extent as we can at once
+		extentEnd := (int64(e.fileBlock)+int64(e.count))*fs.blockSize - pos
+		if extentEnd < int64(len(b)-read) {
+			chunk = b[read : read+int(extentEnd)]
+		} else {
+			chunk = b[read:]
+		}
+		location := int64(e.startBlock+uint64(fileBlock-e.fileBlock))*fs.blockSize + within
+		if _, err := fs.file.ReadAt(chunk, fs.start+location); err != nil {
+			return read, fmt.Errorf("could not read file data at block %d: %v", e.startBlock+uint64(fileBlock-e.fileBlock), err)
+		}
+		read += len(chunk)
+	}
+	return read, nil
+}
+
+// findExtent finds the extent that holds a block of a file, or nil if it is in a hole
+func findExtent(extents []extent, fileBlock uint32) *extent {
+	i := sort.Search(len(extents), func(i int) bool {
+		return extents[i].fileBlock+extents[i].count > fileBlock
+	})
+	if i < len(extents) && extents[i].fileBlock <= fileBlock {
+		return &extents[i]
+	}
+	return nil
+}
+
+// readFileBlock reads one block of a file, given its extents
+func (fs *FileSystem) readFileBlock(extents []extent, fileBlock uint32) ([]byte, error) {
+	b := make([]byte, fs.blockSize)
+	if _, err := fs.readExtents(extents, b, int64(fileBlock)*fs.blockSize); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readBlock reads one block of the filesystem
+func (fs *FileSystem) readBlock(block uint64) ([]byte, error) {
+	if block >= fs.superblock.blocksCount {
+		return nil, fmt.Errorf("block %d is past the end of the filesystem at %d", block, fs.superblock.blocksCount)
+	}
+	b := make([]byte, fs.blockSize)
+	if _, err := fs.file.ReadAt(b, fs.start+int64(block)*fs.blockSize); err != nil {
+		return nil, fmt.Errorf("could not read block %d: %v", block, err)
+	}
+	return b, nil
+}
+
+// readInode reads an inode from the inode table of its block group
+func (fs *FileSystem) readInode(number uint32) (*inode, error) {
+	sb := fs.superblock
+	if number == 0 || number > sb.inodesCount {
+		return nil, fmt.Errorf("invalid inode number %d", number)
+	}
+	group := (number - 1) / sb.inodesPerGroup
+	index := (number - 1) % sb.inodesPerGroup
+	if group >= uint32(len(fs.groupDescriptors)) {
+		return nil, fmt.Errorf("inode %d is in group %d past the last", number, group)
+	}
+	location := int64(fs.groupDescriptors[group].inodeTable)*fs.blockSize + int64(index)*int64(sb.inodeSize)
+	b := make([]byte, sb.inodeSize)
+	if _, err := fs.file.ReadAt(b, fs.start+location); err != nil {
+		return nil, fmt.Errorf("could not read inode %d: %v", number, err)
+	}
+	in, err := inodeFromBytes(b, number, sb)
+	if err != nil {
+		return nil, fmt.Errorf("error reading inode %d: %v", number, err)
+	}
+	return in, nil
+}
+
+// writeInode writes an inode to its slot in the inode table of its block group
+func (fs *FileSystem) writeInode(in *inode) error {
+	sb := fs.superblock
+	group := (in.number - 1) / sb.inodesPerGroup
+	index := (in.number - 1) % sb.inodesPerGroup
+	location := int64(fs.groupDescriptors[group].inodeTable)*fs.blockSize + int64(index)*int64(sb.inodeSize)
+	if _, err := fs.file.WriteAt(in.toBytes(sb), fs.start+location); err != nil {
+		return fmt.Errorf("could not write inode %d: %v", in.number, err)
+	}
+	return nil
+}
+
+// writeGroupDescriptor writes the group descriptor of a group to the primary copy of the group descriptors.
+// The backups are only written by writeBackups.
+func (fs *FileSystem) writeGroupDescriptor(group uint32) error {
+	sb := fs.superblock
+	location, index := groupDescriptorLocation(group, sb)
+	size := sb.groupDescriptorSize()
+	b := fs.groupDescriptors[group].toBytes(sb)
+	if _, err := fs.file.WriteAt(b, fs.start+int64(location)*fs.blockSize+int64(index*size)); err != nil {
+		return fmt.Errorf("could not write group descriptor %d: %v", group, err)
+	}
+	return nil
+}
+
+// writeSuperblock writes the primary superblock, with the free counts from the group descriptors
+func (fs *FileSystem) writeSuperblock() error {
+	sb := fs.superblock
+	sb.freeBlocksCount = fs.freeBlockCount()
+	sb.freeInodesCount = fs.freeInodes()
+	sb.writeTime = time.Now()
+	if _, err := fs.file.WriteAt(sb.toBytes(), fs.start+superblockOffset); err != nil {
+		return fmt.Errorf("could not write superblock: %v", err)
+	}
+	return nil
+}
+
+// writeBackups writes all of the group descriptors, and the copies of them and of the superblock in the
+// groups that keep a backup. The kernel and e2fsck only ever read the backups when the primary copies are
+// damaged, so they need only be written when the filesystem is created.
+func (fs *FileSystem) writeBackups() error {
+	sb := fs.superblock
+	if sb.featureIncompat&featureIncompatMetaBG != 0 {
+		return fmt.Errorf("cannot write backups of group descriptors with meta_bg")
+	}
+	size := sb.groupDescriptorSize()
+	gdt := make([]byte, int64(sb.groupDescriptorBlocks())*fs.blockSize)
+	for i, gd := range fs.groupDescriptors {
+		copy(gdt[i*size:], gd.toBytes(sb))
+	}
+	for group := uint32(0); group < sb.blockGroups(); group++ {
+		if !sb.hasSuperblockBackup(group) {
+			continue
+		}
+		first := sb.groupFirstBlock(group)
+		if _, err := fs.file.WriteAt(gdt, fs.start+int64(first+1)*fs.blockSize); err != nil {
+			return fmt.Errorf("could not write group descriptors in group %d: %v", group, err)
+		}
+		if group == 0 {
+			continue
+		}
+		// each backup superblock records the group it is in
+		backup := *sb
+		backup.blockGroupNumber = uint16(group)
+		if _, err := fs.file.WriteAt(backup.toBytes(), fs.start+int64(first)*fs.blockSize); err != nil {
+			return fmt.Errorf("could not write backup superblock in group %d: %v", group, err)
+		}
+	}
+	return nil
+}
+
+// checkWritable returns an error wrapping filesystem.ErrReadonlyFilesystem if the filesystem has any
+// features that cannot be written, or nil if it can be
+func (fs *FileSystem) checkWritable() error {
+	sb := fs.superblock
+	switch {
+	case sb.featureIncompat&featureIncompatRecover != 0:
+		return fmt.Errorf("journal needs to be replayed first: %w", filesystem.ErrReadonlyFilesystem)
+	case sb.featureIncompat&featureIncompatExtents == 0:
+		return fmt.Errorf("filesystem without extents: %w", filesystem.ErrReadonlyFilesystem)
+	case sb.featureIncompat&^featureIncompatWrite != 0:
+		return fmt.Errorf("incompatible features %#x cannot be written: %w", sb.featureIncompat&^featureIncompatWrite, filesystem.ErrReadonlyFilesystem)
+	case sb.featureROCompat&^featureROCompatWrite != 0:
+		return fmt.Errorf("read-only compatible features %#x cannot be written: %w", sb.featureROCompat&^featureROCompatWrite, filesystem.ErrReadonlyFilesystem)
+	}
+	return nil
+}
+
+// initInode returns a new inode with no blocks, an empty extent tree and all of its times set to now, which
+// the caller sets up further and writes
+func (fs *FileSystem) initInode(number uint32, mode uint16, now time.Time) *inode {
+	sb := fs.superblock
+	in := &inode{
+		number:     number,
+		mode:       mode,
+		accessTime: now,
+		changeTime: now,
+		modifyTime: now,
+		createTime: now,
+		linksCount: 1,
+		flags:      inodeFlagExtents,
+	}
+	putExtentHeader(in.block[:], 0, inodeExtentEntries, 0)
+	if sb.inodeSize > goodOldInodeSize {
+		extra := sb.wantExtraIsize
+		if extra == 0 {
+			extra = defaultExtraIsize
+		}
+		if int(extra) > int(sb.inodeSize)-goodOldInodeSize {
+			extra = sb.inodeSize - goodOldInodeSize
+		}
+		in.extraIsize = extra
+		in.inlineXattrs = make([]byte, int(sb.inodeSize)-goodOldInodeSize-int(extra))
+	}
+	return in
+}
+
+// newInode allocates an inode, close to its parent directory, and returns it as initInode sets it up
+func (fs *FileSystem) newInode(parent *inode, mode uint16) (*inode, error) {
+	number, err := fs.allocateInode(parent.number, mode&modeTypeMask == modeDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return fs.initInode(number, mode, time.Now()), nil
+}
+
+// releaseInode frees the blocks of an inode that has no links left, its extended attribute block unless
+// other inodes share it, and the inode itself, which is written as deleted
+func (fs *FileSystem) releaseInode(in *inode) error {
+	if !in.isFastSymlink() && !in.hasInlineData() {
+		extents, err := fs.fileExtents(in)
+		if err != nil {
+			return err
+		}
+		if err := fs.freeBlocks(extents); err != nil {
+			return err
+		}
+		var tree []extent
+		if in.hasExtents() {
+			blocks, err := fs.extentTreeBlocks(in.block[:])
+			if err != nil {
+				return err
+			}
+			for _, block := range blocks {
+				tree = append(tree, extent{startBlock: block, count: 1})
+			}
+		} else if tree, err = fs.blockMapIndirectBlocks(in); err != nil {
+			return err
+		}
+		if err := fs.freeBlocks(tree); err != nil {
+			return err
+		}
+	}
+	if in.fileACL != 0 {
+		// with no xattrs left, writeXattrs lets go of the block, freeing it if this was its last user
+		if err := fs.writeXattrs(in, nil); err != nil {
+			return err
+		}
+	}
+	if err := fs.freeInode(in.number, in.isDir()); err != nil {
+		return err
+	}
+	now := time.Now()
+	in.deleteTime = uint32(now.Unix())
+	in.changeTime, in.modifyTime = now, now
+	in.linksCount = 0
+	in.size = 0
+	in.blocks = 0
+	in.flags &^= inodeFlagHugeFile | inodeFlagIndex
+	in.block = [inodeBlockSize]byte{}
+	if in.hasExtents() {
+		putExtentHeader(in.block[:], 0, inodeExtentEntries, 0)
+	}
+	return fs.writeInode(in)
+}
+
+// addInodeBlocks adds delta blocks of the filesystem, or takes them away if it is negative, to the count of
+// blocks an inode uses, which is in 512-byte sectors unless it has the huge_file flag
+func (fs *FileSystem) addInodeBlocks(in *inode, delta int64) {
+	if in.flags&inodeFlagHugeFile == 0 {
+		delta *= fs.blockSize / 512
+	}
+	in.blocks = uint64(int64(in.blocks) + delta)
+}
+
+// inodeGoal is where to start looking for free blocks for an inode with none yet, the start of its group
+func (fs *FileSystem) inodeGoal(in *inode) uint64 {
+	return fs.superblock.groupFirstBlock((in.number - 1) / fs.superblock.inodesPerGroup)
+}
+
+// blockGoal is where to start looking for free blocks for block fileBlock of a file: just after the blocks
+// before it, so that the file stays contiguous, or where inodeGoal says if it has none before
+func (fs *FileSystem) blockGoal(in *inode, extents []extent, fileBlock uint32) uint64 {
+	for i := len(extents) - 1; i >= 0; i-- {
+		e := extents[i]
+		if e.fileBlock < fileBlock && e.startBlock != 0 {
+			return e.startBlock + uint64(fileBlock-e.fileBlock)
+		}
+	}
+	return fs.inodeGoal(in)
+}
+
+// writeData writes p to a file at offset, given its extents, allocating blocks for any holes it covers, and
+// writes the inode with its new size and extent tree. It returns the new extents.
+func (fs *FileSystem) writeData(in *inode, extents []extent, p []byte, offset int64) ([]extent, error) {
+	if !in.hasExtents() {
+		return nil, fmt.Errorf("cannot write to inode %d, it has a block map rather than extents", in.number)
+	}
+	end := offset + int64(len(p))
+	if (end+fs.blockSize-1)/fs.blockSize > int64(^uint32(0)) {
+		return nil, fmt.Errorf("cannot write past the largest file size")
+	}
+	if len(p) == 0 {
+		return extents, nil
+	}
+	// anything left in the last block past the end of the file must read as zeros once the file is longer
+	if size := int64(in.size); offset > size && size%fs.blockSize != 0 {
+		tail := size / fs.blockSize
+		if e := findExtent(extents, uint32(tail)); e != nil && e.startBlock != 0 && !e.uninitialized {
+			zeroTo := (tail + 1) * fs.blockSize
+			if offset < zeroTo {
+				zeroTo = offset
+			}
+			location := int64(e.startBlock+uint64(uint32(tail)-e.fileBlock))*fs.blockSize + size%fs.blockSize
+			if _, err := fs.file.WriteAt(make([]byte, zeroTo-size), fs.start+location); err != nil {
+				return nil, fmt.Errorf("could not write file data: %v", err)
+			}
+		}
+	}
+
+	// map the blocks that are not yet, allocating those in holes and taking over uninitialized ones, which
+	// are fresh in that their old contents do not count
+	first, last := uint32(offset/fs.blockSize), uint32((end-1)/fs.blockSize)
+	var (
+		allocated []extent
+		fresh     = map[uint32]bool{}
+	)
+	fail := func(err error) ([]extent, error) {
+		_ = fs.freeBlocks(allocated)
+		return nil, err
+	}
+	for fileBlock := first; fileBlock <= last; {
+		e := findExtent(extents, fileBlock)
+		runEnd := last + 1
+		switch {
+		case e != nil && e.startBlock != 0 && !e.uninitialized:
+			fileBlock = e.fileBlock + e.count
+			continue
+		case e != nil:
+			if e.fileBlock+e.count < runEnd {
+				runEnd = e.fileBlock + e.count
+			}
+		default:
+			for _, x := range extents {
+				if x.fileBlock > fileBlock && x.fileBlock < runEnd {
+					runEnd = x.fileBlock
+				}
+			}
+		}
+		fresh[fileBlock], fresh[runEnd-1] = true, true
+		if e != nil && e.startBlock != 0 {
+			extents = setExtent(extents, extent{fileBlock: fileBlock, startBlock: e.startBlock + uint64(fileBlock-e.fileBlock), count: runEnd - fileBlock})
+			fileBlock = runEnd
+			continue
+		}
+		runs, err := fs.allocateBlocks(fs.blockGoal(in, extents, fileBlock), uint64(runEnd-fileBlock))
+		if err != nil {
+			return fail(err)
+		}
+		allocated = append(allocated, runs...)
+		fs.addInodeBlocks(in, int64(runEnd-fileBlock))
+		for _, r := range runs {
+			r.fileBlock = fileBlock
+			extents = setExtent(extents, r)
+			fileBlock += r.count
+		}
+	}
+
+	written := 0
+	for written < len(p) {
+		pos := offset + int64(written)
+		fileBlock := uint32(pos / fs.blockSize)
+		e := findExtent(extents, fileBlock)
+		location := int64(e.startBlock+uint64(fileBlock-e.fileBlock))*fs.blockSize + pos%fs.blockSize
+		// write as much of the extent as we can at once
+		chunk := p[written:]
+		if extentEnd := (int64(e.fileBlock)+int64(e.count))*fs.blockSize - pos; extentEnd < int64(len(chunk)) {
+			chunk = chunk[:extentEnd]
+		}
+		if _, err := fs.file.WriteAt(chunk, fs.start+location); err != nil {
+			return fail(fmt.Errorf("could not write file data: %v", err))
+		}
+		written += len(chunk)
+	}
+	// a fresh block only partly written needs zeros in the rest of it
+	if within := offset % fs.blockSize; fresh[first] && within != 0 {
+		e := findExtent(extents, first)
+		location := int64(e.startBlock+uint64(first-e.fileBlock)) * fs.blockSize
+		if _, err := fs.file.WriteAt(make([]byte, within), fs.start+location); err != nil {
+			return fail(fmt.Errorf("could not write file data: %v", err))
+		}
+	}
+	if within := end % fs.blockSize; fresh[last] && within != 0 {
+		e := findExtent(extents, last)
+		location := int64(e.startBlock+uint64(last-e.fileBlock))*fs.blockSize + within
+		if _, err := fs.file.WriteAt(make([]byte, fs.blockSize-within), fs.start+location); err != nil {
+			return fail(fmt.Errorf("could not write file data: %v", err))
+		}
+	}
+
+	if uint64(end) > in.size {
+		in.size = uint64(end)
+	}
+	now := time.Now()
+	in.modifyTime, in.changeTime = now, now
+	if err := fs.writeExtentTree(in, extents); err != nil {
+		return fail(err)
+	}
+	return extents, nil
+}
+
+// truncateInode changes the size of a file, given its extents, freeing the blocks past its new end, and
+// writes the inode. It returns the new extents.
+func (fs *FileSystem) truncateInode(in *inode, extents []extent, size uint64) ([]extent, error) {
+	if !in.hasExtents() {
+		return nil, fmt.Errorf("cannot truncate inode %d, it has a block map rather than extents", in.number)
+	}
+	blocks := uint32((int64(size) + fs.blockSize - 1) / fs.blockSize)
+	kept, dropped := truncateExtents(extents, blocks)
+	var freed int64
+	for _, e := range dropped {
+		if e.startBlock != 0 {
+			freed += int64(e.count)
+		}
+	}
+	if err := fs.freeBlocks(dropped); err != nil {
+		return nil, err
+	}
+	fs.addInodeBlocks(in, -freed)
+	// what is left of the last block past the new end must read as zeros if the file grows again
+	if within := int64(size) % fs.blockSize; within != 0 && size < in.size {
+		if e := findExtent(kept, blocks-1); e != nil && e.startBlock != 0 && !e.uninitialized {
+			location := int64(e.startBlock+uint64(blocks-1-e.fileBlock))*fs.blockSize + within
+			if _, err := fs.file.WriteAt(make([]byte, fs.blockSize-within), fs.start+location); err != nil {
+				return nil, fmt.Errorf("could not write file data: %v", err)
+			}
+		}
+	}
+	in.size = size
+	now := time.Now()
+	in.modifyTime, in.changeTime = now, now
+	if err := fs.writeExtentTree(in, kept); err != nil {
+		return nil, err
+	}
+	return kept, nil
+}
+
+// resolveParent finds the directory that holds p, and returns it with the name of p in it
+func (fs *FileSystem) resolveParent(p string) (*inode, string, error) {
+	parts, err := splitPath(p)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(parts) == 0 {
+		return nil, "", fmt.Errorf("cannot use root directory")
+	}
+	dirPath := "/" + strings.Join(parts[:len(parts)-1], "/")
+	dir, _, err := fs.resolvePath(dirPath, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.isDir() {
+		return nil, "", fmt.Errorf("%s is not a directory", dirPath)
+	}
+	return dir, parts[len(parts)-1], nil
+}
+
+// validateName checks that a name can be a directory entry
+func validateName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("invalid name %q", name)
+	case len(name) > maxNameLength:
+		return fmt.Errorf("name %s is too long at %d bytes, maximum is %d", name, len(name), maxNameLength)
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("name %q contains a NUL", name)
+	}
+	return nil
+}
+
+// incDirLinks counts another subdirectory in the links of a directory. Past maxLinks, which only dir_nlink
+// allows, the count becomes 1, meaning unknown.
+func (fs *FileSystem) incDirLinks(dir *inode) {
+	if dir.linksCount == 1 {
+		return
+	}
+	dir.linksCount++
+	if dir.linksCount >= maxLinks {
+		dir.linksCount = 1
+	}
+}
+
+// decDirLinks counts one subdirectory less in the links of a directory, unless its count is unknown
+func (fs *FileSystem) decDirLinks(dir *inode) {
+	if dir.linksCount > 2 {
+		dir.linksCount--
+	}
+}
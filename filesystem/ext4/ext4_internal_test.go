@@ -0,0 +1,190 @@
+package ext4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// mkfsDir makes an ext4 image with mke2fs from a directory holding count files, has e2fsck index it, and
+// reads it
+func mkfsDir(t *testing.T, count int, args ...string) *FileSystem {
+	t.Helper()
+	for _, tool := range []string{"mke2fs", "e2fsck"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "dir")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("entry-%06d", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := 64 * MB
+	img := filepath.Join(dir, "ext4.img")
+	cmdArgs := append([]string{"-q", "-F", "-t", "ext4", "-d", filepath.Join(dir, "src")}, args...)
+	cmdArgs = append(cmdArgs, img, fmt.Sprintf("%dk", size/KB))
+	if out, err := exec.Command("mke2fs", cmdArgs...).CombinedOutput(); err != nil {
+		t.Fatalf("mke2fs failed: %v\n%s", err, out)
+	}
+	// e2fsck exits 1 when it changed the filesystem
+	if out, err := exec.Command("e2fsck", "-fyD", img).CombinedOutput(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			t.Fatalf("e2fsck failed: %v\n%s", err, out)
+		}
+	}
+	f, err := os.Open(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	fs, err := Read(f, size, 0, 0)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	return fs
+}
+
+func mustExtents(t *testing.T, fs *FileSystem, in *inode) []extent {
+	t.Helper()
+	extents, err := fs.fileExtents(in)
+	if err != nil {
+		t.Fatalf("unable to read extents of inode %d: %v", in.number, err)
+	}
+	return extents
+}
+
+func TestHtreeLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		count  int
+		args   []string
+		levels uint8
+	}{
+		{"one level", 2000, []string{"-b", "4096"}, 0},
+		// a 1KB block holds few entries, so this needs another level of index
+		{"two levels", 10000, []string{"-b", "1024", "-N", "16384"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := mkfsDir(t, tt.count, tt.args...)
+			dir, _, err := fs.resolvePath("/dir", true)
+			if err != nil {
+				t.Fatalf("unable to find directory: %v", err)
+			}
+			if dir.flags&inodeFlagIndex == 0 {
+				t.Fatalf("directory with %d entries is not indexed", tt.count)
+			}
+			root, err := fs.readFileBlock(mustExtents(t, fs, dir), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if levels := root[dxRootInfoOffset+6]; levels != tt.levels {
+				t.Fatalf("directory index has %d levels under the root, expected %d", levels, tt.levels)
+			}
+			for i := 0; i < tt.count; i += 7 {
+				name := fmt.Sprintf("entry-%06d", i)
+				de, err := fs.htreeLookup(dir, name)
+				if err != nil {
+					t.Fatalf("unable to look up %s: %v", name, err)
+				}
+				if de == nil || de.name != name {
+					t.Fatalf("lookup of %s found %v", name, de)
+				}
+			}
+			if de, err := fs.htreeLookup(dir, "missing"); err != nil || de != nil {
+				t.Errorf("lookup of missing entry returned %v, %v", de, err)
+			}
+			entries, err := fs.readDirectoryEntries(dir)
+			if err != nil {
+				t.Fatalf("unable to read directory: %v", err)
+			}
+			// with . and ..
+			if len(entries) != tt.count+2 {
+				t.Errorf("directory has %d entries, expected %d", len(entries), tt.count+2)
+			}
+		})
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	fs := mkfsDir(t, 1, "-b", "4096")
+	data, err := os.ReadFile(fs.file.(*os.File).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a changed label breaks the superblock checksum
+	data[superblockOffset+120]++
+	corrupt := filepath.Join(t.TempDir(), "corrupt.img")
+	if err := os.WriteFile(corrupt, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := Read(f, fs.size, 0, 0); err == nil {
+		t.Errorf("read of filesystem with corrupt superblock returned no error")
+	}
+}
+
+func TestToBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"ext4", []string{"-b", "4096"}},
+		{"ext4 1k", []string{"-b", "1024"}},
+		{"ext4 gdt_csum", []string{"-O", "^metadata_csum,uninit_bg"}},
+		{"ext2", []string{"-O", "none", "-I", "128"}},
+		{"revision 0", []string{"-O", "none", "-r", "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := mkfsDir(t, 20, tt.args...)
+			sb := make([]byte, superblockSize)
+			if _, err := fs.file.ReadAt(sb, superblockOffset); err != nil {
+				t.Fatal(err)
+			}
+			if b := fs.superblock.toBytes(); !bytes.Equal(b, sb) {
+				t.Errorf("superblock does not match as written by mke2fs")
+			}
+			for _, gd := range fs.groupDescriptors {
+				location, index := groupDescriptorLocation(gd.number, fs.superblock)
+				size := fs.superblock.groupDescriptorSize()
+				expected := make([]byte, size)
+				if _, err := fs.file.ReadAt(expected, int64(location)*fs.blockSize+int64(index*size)); err != nil {
+					t.Fatal(err)
+				}
+				if b := gd.toBytes(fs.superblock); !bytes.Equal(b, expected) {
+					t.Errorf("group descriptor %d does not match as written by mke2fs", gd.number)
+				}
+			}
+			for number := uint32(1); number < fs.superblock.firstInode+20; number++ {
+				in, err := fs.readInode(number)
+				if err != nil {
+					t.Fatalf("unable to read inode %d: %v", number, err)
+				}
+				expected := make([]byte, fs.superblock.inodeSize)
+				location := int64(fs.groupDescriptors[0].inodeTable)*fs.blockSize + int64(number-1)*int64(fs.superblock.inodeSize)
+				if _, err := fs.file.ReadAt(expected, location); err != nil {
+					t.Fatal(err)
+				}
+				if b := in.toBytes(fs.superblock); !bytes.Equal(b, expected) {
+					t.Errorf("inode %d does not match as written by mke2fs\n%x\n%x", number, b, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,774 @@
+package ext4_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/ext4"
+)
+
+const imageSize = 64 * ext4.MB
+
+// tree is what the test images are made from, written to a directory for mke2fs to copy in
+type tree struct {
+	big      []byte
+	manyDirs int
+}
+
+func newTree(t *testing.T) *tree {
+	t.Helper()
+	big := make([]byte, 300*1024+17)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatalf("unable to generate random data: %v", err)
+	}
+	return &tree{big: big, manyDirs: 600}
+}
+
+func (tr *tree) write(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string][]byte{
+		"hello.txt":          []byte("hello world\n"),
+		"big.bin":            tr.big,
+		"empty":              nil,
+		"dir/sub/nested.txt": bytes.Repeat([]byte("nested "), 100),
+	}
+	for i := 0; i < tr.manyDirs; i++ {
+		files[fmt.Sprintf("many/file_with_a_long_name_%04d", i)] = []byte(fmt.Sprintf("%d\n", i))
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"link":     "hello.txt",
+		"dirlink":  "dir/sub",
+		"abslink":  "/dir/sub/nested.txt",
+		"longlink": strings.Repeat("a", 100),
+		"loop1":    "loop2",
+		"loop2":    "loop1",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mkfs makes an image with mke2fs from the tree, with the given extra arguments, and runs any debugfs
+// commands on it. As mke2fs does not index directories, it then has e2fsck index any large ones. It skips the
+// test if the tools are not available.
+func mkfs(t *testing.T, tr *tree, args []string, debugfs ...string) string {
+	t.Helper()
+	for _, tool := range []string{"mke2fs", "debugfs", "e2fsck"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	tr.write(t, src)
+	img := filepath.Join(dir, "ext4.img")
+	cmdArgs := append([]string{"-q", "-F", "-L", "go-diskfs", "-d", src}, args...)
+	cmdArgs = append(cmdArgs, img, fmt.Sprintf("%dk", imageSize/ext4.KB))
+	if out, err := exec.Command("mke2fs", cmdArgs...).CombinedOutput(); err != nil {
+		t.Fatalf("mke2fs %v failed: %v\n%s", cmdArgs, err, out)
+	}
+	for _, c := range debugfs {
+		if out, err := exec.Command("debugfs", "-w", "-R", c, img).CombinedOutput(); err != nil {
+			t.Fatalf("debugfs %s failed: %v\n%s", c, err, out)
+		}
+	}
+	// e2fsck exits 1 when it changed the filesystem, as it does when it indexes directories
+	if out, err := exec.Command("e2fsck", "-fyD", img).CombinedOutput(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			t.Fatalf("e2fsck failed: %v\n%s", err, out)
+		}
+	}
+	return img
+}
+
+func readImage(t *testing.T, img string) *ext4.FileSystem {
+	t.Helper()
+	return openImage(t, img, os.O_RDONLY)
+}
+
+func openImage(t *testing.T, img string, flag int) *ext4.FileSystem {
+	t.Helper()
+	f, err := os.OpenFile(img, flag, 0)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", img, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	fs, err := ext4.Read(f, imageSize, 0, 512)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	return fs
+}
+
+// fsck checks an image with e2fsck, without changing it
+func fsck(t *testing.T, img string) {
+	t.Helper()
+	if out, err := exec.Command("e2fsck", "-fn", img).CombinedOutput(); err != nil {
+		t.Fatalf("e2fsck found errors: %v\n%s", err, out)
+	}
+}
+
+// createImage makes an image with Create
+func createImage(t *testing.T, size int64, label string) (*ext4.FileSystem, string) {
+	t.Helper()
+	img := filepath.Join(t.TempDir(), "ext4.img")
+	f, err := os.Create(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := ext4.Create(f, size, 0, 512, label)
+	if err != nil {
+		t.Fatalf("unable to create filesystem: %v", err)
+	}
+	return fs, img
+}
+
+func writeFile(t *testing.T, fs *ext4.FileSystem, p string, flag int, b []byte) {
+	t.Helper()
+	f, err := fs.OpenFile(p, flag)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", p, err)
+	}
+	defer f.Close()
+	if n, err := f.Write(b); err != nil || n != len(b) {
+		t.Fatalf("unable to write %s: wrote %d of %d bytes: %v", p, n, len(b), err)
+	}
+}
+
+func readFile(t *testing.T, fs *ext4.FileSystem, p string) []byte {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", p, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unable to read %s: %v", p, err)
+	}
+	return b
+}
+
+func TestRead(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name    string
+		args    []string
+		debugfs []string
+	}{
+		{"ext2", []string{"-t", "ext2"}, nil},
+		{"ext2 without dir_index", []string{"-t", "ext2", "-O", "^dir_index"}, nil},
+		{"ext3", []string{"-t", "ext3"}, nil},
+		{"ext4", []string{"-t", "ext4"}, nil},
+		{"ext4 1k blocks", []string{"-t", "ext4", "-b", "1024"}, nil},
+		{"ext4 64k blocks", []string{"-t", "ext4", "-b", "65536", "-N", "1024"}, nil},
+		{"ext4 inline data", []string{"-t", "ext4", "-O", "inline_data"}, nil},
+		{"ext4 without metadata_csum", []string{"-t", "ext4", "-O", "^metadata_csum"}, nil},
+		{"ext4 64bit meta_bg", []string{"-t", "ext4", "-O", "64bit,meta_bg,^resize_inode"}, nil},
+		{"ext4 128 byte inodes", []string{"-t", "ext4", "-I", "128"}, nil},
+		{"ext4 tea hash", []string{"-t", "ext4"}, []string{"ssv def_hash_version tea"}},
+		{"ext4 legacy hash", []string{"-t", "ext4"}, []string{"ssv def_hash_version legacy"}},
+		{"ext4 unsigned hash", []string{"-t", "ext4"}, []string{"ssv flags 0x2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := readImage(t, mkfs(t, tr, tt.args, tt.debugfs...))
+			if fs.Type() != filesystem.TypeExt4 {
+				t.Errorf("type %v, expected %v", fs.Type(), filesystem.TypeExt4)
+			}
+			if label := fs.Label(); label != "go-diskfs" {
+				t.Errorf("label %q, expected %q", label, "go-diskfs")
+			}
+
+			entries, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatalf("unable to read root directory: %v", err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			sort.Strings(names)
+			expected := []string{"abslink", "big.bin", "dir", "dirlink", "empty", "hello.txt", "link", "longlink", "loop1", "loop2", "lost+found", "many"}
+			if strings.Join(names, ",") != strings.Join(expected, ",") {
+				t.Errorf("root directory has %v, expected %v", names, expected)
+			}
+
+			if b := readFile(t, fs, "/hello.txt"); string(b) != "hello world\n" {
+				t.Errorf("hello.txt has %q", b)
+			}
+			if b := readFile(t, fs, "/big.bin"); !bytes.Equal(b, tr.big) {
+				t.Errorf("big.bin does not match what was written")
+			}
+			if b := readFile(t, fs, "/empty"); len(b) != 0 {
+				t.Errorf("empty has %d bytes", len(b))
+			}
+			if b := readFile(t, fs, "/dirlink/nested.txt"); !bytes.Equal(b, bytes.Repeat([]byte("nested "), 100)) {
+				t.Errorf("nested.txt through a symlink has %q", b)
+			}
+
+			many, err := fs.ReadDir("/many")
+			if err != nil {
+				t.Fatalf("unable to read /many: %v", err)
+			}
+			if len(many) != tr.manyDirs {
+				t.Errorf("/many has %d entries, expected %d", len(many), tr.manyDirs)
+			}
+			for _, i := range []int{0, 1, 299, 599} {
+				p := fmt.Sprintf("/many/file_with_a_long_name_%04d", i)
+				if b := readFile(t, fs, p); string(b) != fmt.Sprintf("%d\n", i) {
+					t.Errorf("%s has %q", p, b)
+				}
+			}
+			if _, err := fs.Stat("/many/file_with_a_long_name_9999"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("stat of missing file returned %v, expected os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStat(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), []string{"-t", "ext4", "-b", "4096"}))
+	tests := []struct {
+		path string
+		name string
+		size int64
+		mode os.FileMode
+		err  error
+	}{
+		{"/", "/", 4096, os.ModeDir | 0o755, nil},
+		{"/hello.txt", "hello.txt", 12, 0o644, nil},
+		{"/dir/sub", "sub", 4096, os.ModeDir | 0o755, nil},
+		{"/dir/../hello.txt", "hello.txt", 12, 0o644, nil},
+		{"/link", "hello.txt", 12, 0o644, nil},
+		{"/dirlink", "sub", 4096, os.ModeDir | 0o755, nil},
+		{"/abslink", "nested.txt", 700, 0o644, nil},
+		{"/missing", "", 0, 0, os.ErrNotExist},
+		{"/hello.txt/foo", "", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			fi, err := fs.Stat(tt.path)
+			switch {
+			case tt.name == "" && err == nil:
+				t.Fatalf("expected error, got none")
+			case tt.name == "" && tt.err != nil && !errors.Is(err, tt.err):
+				t.Fatalf("error %v, expected %v", err, tt.err)
+			case tt.name == "":
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Name() != tt.name || fi.Size() != tt.size || fi.Mode() != tt.mode {
+				t.Errorf("got %s size %d mode %v, expected %s size %d mode %v", fi.Name(), fi.Size(), fi.Mode(), tt.name, tt.size, tt.mode)
+			}
+			if _, ok := fi.Sys().(*ext4.FileStat); !ok {
+				t.Errorf("Sys() is %T, expected *ext4.FileStat", fi.Sys())
+			}
+		})
+	}
+	if _, err := fs.Stat("/loop1"); err == nil {
+		t.Errorf("stat of symlink loop returned no error")
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"extents", []string{"-t", "ext4"}},
+		{"block map", []string{"-t", "ext2"}},
+		{"inline data", []string{"-t", "ext4", "-O", "inline_data"}},
+	}
+	links := map[string]string{
+		"/link":     "hello.txt",
+		"/dirlink":  "dir/sub",
+		"/abslink":  "/dir/sub/nested.txt",
+		"/longlink": strings.Repeat("a", 100),
+	}
+	tr := newTree(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := readImage(t, mkfs(t, tr, tt.args))
+			for p, expected := range links {
+				target, err := fs.Readlink(p)
+				if err != nil {
+					t.Fatalf("unable to read link %s: %v", p, err)
+				}
+				if target != expected {
+					t.Errorf("%s links to %q, expected %q", p, target, expected)
+				}
+				fi, err := fs.Lstat(p)
+				if err != nil {
+					t.Fatalf("unable to lstat %s: %v", p, err)
+				}
+				if fi.Mode()&os.ModeSymlink == 0 || fi.Size() != int64(len(expected)) {
+					t.Errorf("lstat %s has mode %v size %d", p, fi.Mode(), fi.Size())
+				}
+			}
+			if _, err := fs.Readlink("/hello.txt"); err == nil {
+				t.Errorf("readlink of a regular file returned no error")
+			}
+		})
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		long int
+	}{
+		{"in inode and block", []string{"-t", "ext4", "-b", "4096"}, 1000},
+		{"in block", []string{"-t", "ext4", "-b", "4096", "-I", "128"}, 1000},
+		{"without metadata_csum", []string{"-t", "ext4", "-b", "4096", "-O", "^metadata_csum"}, 1000},
+		{"in ea inode", []string{"-t", "ext4", "-b", "4096", "-O", "ea_inode"}, 4096},
+	}
+	tr := newTree(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			long := strings.Repeat("v", tt.long)
+			longFile := filepath.Join(t.TempDir(), "long")
+			if err := os.WriteFile(longFile, []byte(long), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			fs := readImage(t, mkfs(t, tr, tt.args,
+				"ea_set /hello.txt user.foo bar",
+				"ea_set -f "+longFile+" /hello.txt trusted.long",
+				"ea_set /dir security.selinux system_u:object_r:etc_t:s0",
+			))
+			tests := map[string]map[string]string{
+				"/hello.txt": {"user.foo": "bar", "trusted.long": long},
+				"/dir":       {"security.selinux": "system_u:object_r:etc_t:s0"},
+				"/empty":     {},
+			}
+			for p, xattrs := range tests {
+				names, err := fs.Listxattr(p)
+				if err != nil {
+					t.Fatalf("unable to list xattrs of %s: %v", p, err)
+				}
+				var expected []string
+				for k := range xattrs {
+					expected = append(expected, k)
+				}
+				sort.Strings(expected)
+				if strings.Join(names, ",") != strings.Join(expected, ",") {
+					t.Errorf("%s has xattrs %v, expected %v", p, names, expected)
+				}
+				for k, v := range xattrs {
+					val, err := fs.Getxattr(p, k)
+					if err != nil {
+						t.Fatalf("unable to get xattr %s of %s: %v", k, p, err)
+					}
+					if string(val) != v {
+						t.Errorf("xattr %s of %s is %d bytes %.20q, expected %d bytes %.20q", k, p, len(val), val, len(v), v)
+					}
+				}
+			}
+			if _, err := fs.Getxattr("/hello.txt", "user.missing"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+				t.Errorf("missing xattr returned %v, expected filesystem.ErrXattrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	if _, err := exec.LookPath("e2fsck"); err != nil {
+		t.Skip("e2fsck not available")
+	}
+	tests := []struct {
+		name      string
+		size      int64
+		blockSize int64
+		journal   bool
+	}{
+		{"tiny", 200 * ext4.KB, 1024, false},
+		{"small", 2 * ext4.MB, 1024, true},
+		{"1k blocks", 64 * ext4.MB, 1024, true},
+		{"partial last group", 64*ext4.MB + 300*ext4.KB, 1024, true},
+		{"4k blocks", 600 * ext4.MB, 4096, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, img := createImage(t, tt.size, "go-diskfs")
+			fsck(t, img)
+			if fs.Type() != filesystem.TypeExt4 {
+				t.Errorf("type %v, expected %v", fs.Type(), filesystem.TypeExt4)
+			}
+			f, err := os.Open(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			fs, err = ext4.Read(f, tt.size, 0, 512)
+			if err != nil {
+				t.Fatalf("unable to read created filesystem: %v", err)
+			}
+			if label := fs.Label(); label != "go-diskfs" {
+				t.Errorf("label %q, expected %q", label, "go-diskfs")
+			}
+			entries, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatalf("unable to read root directory: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "lost+found" || !entries[0].IsDir() {
+				t.Errorf("root directory of new filesystem has %v", entries)
+			}
+			st, err := fs.Statfs()
+			if err != nil {
+				t.Fatalf("unable to get statfs: %v", err)
+			}
+			if st.BlockSize != tt.blockSize || st.FreeBytes <= 0 || st.FreeBytes+st.UsedBytes != st.TotalBytes || st.Files != 11 {
+				t.Errorf("unexpected statfs %+v", st)
+			}
+			if out, err := exec.Command("dumpe2fs", "-h", img).CombinedOutput(); err == nil {
+				if hasJournal := strings.Contains(string(out), "has_journal"); hasJournal != tt.journal {
+					t.Errorf("filesystem has journal %v, expected %v", hasJournal, tt.journal)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "ext4.img")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := ext4.Create(f, ext4.MinSize-1, 0, 512, ""); err == nil {
+			t.Errorf("create of too small filesystem returned no error")
+		}
+		if _, err := ext4.Create(f, imageSize, 0, 333, ""); err == nil {
+			t.Errorf("create with invalid blocksize returned no error")
+		}
+		if _, err := ext4.Create(f, imageSize, 0, 512, strings.Repeat("x", 17)); err == nil {
+			t.Errorf("create with too long label returned no error")
+		}
+	})
+}
+
+func TestWrite(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name string
+		img  func(t *testing.T) string
+	}{
+		{"created", func(t *testing.T) string {
+			_, img := createImage(t, imageSize, "go-diskfs")
+			return img
+		}},
+		{"ext4", func(t *testing.T) string { return mkfs(t, tr, []string{"-t", "ext4"}) }},
+		{"ext4 1k blocks", func(t *testing.T) string { return mkfs(t, tr, []string{"-t", "ext4", "-b", "1024"}) }},
+		{"ext4 without metadata_csum", func(t *testing.T) string {
+			return mkfs(t, tr, []string{"-t", "ext4", "-O", "^metadata_csum"})
+		}},
+		{"ext4 64bit meta_bg", func(t *testing.T) string {
+			return mkfs(t, tr, []string{"-t", "ext4", "-O", "64bit,meta_bg,^resize_inode"})
+		}},
+	}
+	big := make([]byte, 5*ext4.MB+123)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := tt.img(t)
+			fs := openImage(t, img, os.O_RDWR)
+
+			if err := fs.Mkdir("/a/b/c"); err != nil {
+				t.Fatalf("unable to make directories: %v", err)
+			}
+			if err := fs.Mkdir("/a/b"); err != nil {
+				t.Errorf("mkdir of existing directory returned %v", err)
+			}
+			writeFile(t, fs, "/a/b/c/small.txt", os.O_CREATE|os.O_RDWR, []byte("small file\n"))
+			writeFile(t, fs, "/a/big.bin", os.O_CREATE|os.O_RDWR, big)
+			writeFile(t, fs, "/a/appended", os.O_CREATE|os.O_RDWR, []byte("one "))
+			writeFile(t, fs, "/a/appended", os.O_APPEND|os.O_RDWR, []byte("two"))
+			writeFile(t, fs, "/a/truncated", os.O_CREATE|os.O_RDWR, bytes.Repeat([]byte("x"), 10000))
+			writeFile(t, fs, "/a/truncated", os.O_TRUNC|os.O_RDWR, []byte("short"))
+			if _, err := fs.OpenFile("/a/truncated", os.O_CREATE|os.O_EXCL|os.O_RDWR); !errors.Is(err, os.ErrExist) {
+				t.Errorf("exclusive create of existing file returned %v, expected os.ErrExist", err)
+			}
+			if _, err := fs.OpenFile("/missing/file", os.O_CREATE|os.O_RDWR); err == nil {
+				t.Errorf("create in missing directory returned no error")
+			}
+
+			// a sparse file, with a hole and data in the middle of a block
+			sparse, err := fs.OpenFile("/a/sparse", os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("unable to create sparse file: %v", err)
+			}
+			if _, err := sparse.Seek(1000000, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sparse.Write([]byte("middle")); err != nil {
+				t.Fatalf("unable to write sparse file: %v", err)
+			}
+			sparse.Close()
+
+			// enough entries that the directory needs more blocks, and that an indexed one loses its index
+			for i := 0; i < 300; i++ {
+				writeFile(t, fs, fmt.Sprintf("/a/b/entry-%04d", i), os.O_CREATE|os.O_RDWR, []byte(fmt.Sprintf("%d", i)))
+			}
+			if _, err := fs.Stat("/many"); err == nil {
+				writeFile(t, fs, "/many/added", os.O_CREATE|os.O_RDWR, []byte("added"))
+				if err := fs.Remove("/many/file_with_a_long_name_0007"); err != nil {
+					t.Errorf("unable to remove file from indexed directory: %v", err)
+				}
+			}
+
+			if err := fs.Symlink("c/small.txt", "/a/b/short"); err != nil {
+				t.Errorf("unable to make symlink: %v", err)
+			}
+			longTarget := "/a/" + strings.Repeat("b/../", 20) + "truncated"
+			if err := fs.Symlink(longTarget, "/a/long"); err != nil {
+				t.Errorf("unable to make long symlink: %v", err)
+			}
+
+			if err := fs.Rename("/a/b/entry-0001", "/a/renamed"); err != nil {
+				t.Errorf("unable to rename file: %v", err)
+			}
+			if err := fs.Rename("/a/b/entry-0002", "/a/renamed"); err != nil {
+				t.Errorf("unable to replace file: %v", err)
+			}
+			if err := fs.Mkdir("/moved"); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Rename("/a/b/c", "/moved/c"); err != nil {
+				t.Errorf("unable to move directory: %v", err)
+			}
+			if err := fs.Rename("/moved", "/moved/c/inside"); err == nil {
+				t.Errorf("move of directory into itself returned no error")
+			}
+			if err := fs.Rename("/a/renamed", "/moved"); err == nil {
+				t.Errorf("replacing a directory with a file returned no error")
+			}
+
+			if err := fs.Remove("/a/b/entry-0003"); err != nil {
+				t.Errorf("unable to remove file: %v", err)
+			}
+			if err := fs.Remove("/a"); err == nil {
+				t.Errorf("remove of non-empty directory returned no error")
+			}
+			if err := fs.Mkdir("/gone/deep/er"); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, fs, "/gone/deep/file", os.O_CREATE|os.O_RDWR, big[:100000])
+			if err := fs.RemoveAll("/gone"); err != nil {
+				t.Errorf("unable to remove directory tree: %v", err)
+			}
+
+			// two files written a block at a time in turn are fragmented enough to need extent tree blocks
+			var fragmented [2][]byte
+			files := make([]filesystem.File, 2)
+			for i := range files {
+				if files[i], err = fs.OpenFile(fmt.Sprintf("/a/fragmented%d", i), os.O_CREATE|os.O_RDWR); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for j := 0; j < 50; j++ {
+				for i, f := range files {
+					chunk := bytes.Repeat([]byte{byte(i*50 + j)}, 4096)
+					if _, err := f.Write(chunk); err != nil {
+						t.Fatalf("unable to write fragmented file: %v", err)
+					}
+					fragmented[i] = append(fragmented[i], chunk...)
+				}
+			}
+			if err := fs.Truncate("/a/fragmented1", 5000); err != nil {
+				t.Errorf("unable to shrink fragmented file: %v", err)
+			}
+			fragmented[1] = fragmented[1][:5000]
+
+			if err := fs.Truncate("/a/big.bin", 3*ext4.MB+5); err != nil {
+				t.Errorf("unable to shrink file: %v", err)
+			}
+			if err := fs.Truncate("/a/appended", 20); err != nil {
+				t.Errorf("unable to grow file: %v", err)
+			}
+
+			if err := fs.Setxattr("/a/appended", "user.small", []byte("value")); err != nil {
+				t.Errorf("unable to set xattr: %v", err)
+			}
+			if err := fs.Setxattr("/a/appended", "trusted.large", bytes.Repeat([]byte("v"), 500)); err != nil {
+				t.Errorf("unable to set large xattr: %v", err)
+			}
+			if err := fs.Setxattr("/a/sparse", "user.gone", []byte("soon")); err != nil {
+				t.Errorf("unable to set xattr: %v", err)
+			}
+			if err := fs.Removexattr("/a/sparse", "user.gone"); err != nil {
+				t.Errorf("unable to remove xattr: %v", err)
+			}
+			if err := fs.Removexattr("/a/sparse", "user.gone"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+				t.Errorf("remove of missing xattr returned %v, expected filesystem.ErrXattrNotExist", err)
+			}
+			if err := fs.SetLabel("relabelled"); err != nil {
+				t.Errorf("unable to set label: %v", err)
+			}
+
+			fsck(t, img)
+
+			fs = readImage(t, img)
+			expected := map[string][]byte{
+				"/moved/c/small.txt": []byte("small file\n"),
+				"/a/big.bin":         big[:3*ext4.MB+5],
+				"/a/appended":        append([]byte("one two"), make([]byte, 13)...),
+				"/a/truncated":       []byte("short"),
+				"/a/sparse":          append(make([]byte, 1000000), []byte("middle")...),
+				"/a/renamed":         []byte("2"),
+				"/a/b/entry-0299":    []byte("299"),
+				"/a/long":            []byte("short"),
+				"/a/fragmented0":     fragmented[0],
+				"/a/fragmented1":     fragmented[1],
+			}
+			for p, b := range expected {
+				if actual := readFile(t, fs, p); !bytes.Equal(actual, b) {
+					t.Errorf("%s has %d bytes %.20q, expected %d bytes %.20q", p, len(actual), actual, len(b), b)
+				}
+			}
+			for _, p := range []string{"/gone", "/a/b/entry-0001", "/a/b/entry-0002", "/a/b/entry-0003", "/a/b/c"} {
+				if _, err := fs.Lstat(p); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("stat of removed %s returned %v", p, err)
+				}
+			}
+			entries, err := fs.ReadDir("/a/b")
+			if err != nil {
+				t.Fatalf("unable to read directory: %v", err)
+			}
+			// 300 entries, less the three renamed or removed, and the symlink
+			if len(entries) != 298 {
+				t.Errorf("/a/b has %d entries, expected %d", len(entries), 298)
+			}
+			if target, err := fs.Readlink("/a/b/short"); err != nil || target != "c/small.txt" {
+				t.Errorf("symlink has target %q: %v", target, err)
+			}
+			if v, err := fs.Getxattr("/a/appended", "trusted.large"); err != nil || len(v) != 500 {
+				t.Errorf("large xattr has %d bytes: %v", len(v), err)
+			}
+			if fs.Label() != "relabelled" {
+				t.Errorf("label %q, expected %q", fs.Label(), "relabelled")
+			}
+		})
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"ext2", []string{"-t", "ext2"}},
+		{"ext4 inline data", []string{"-t", "ext4", "-O", "inline_data"}},
+	}
+	tr := newTree(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testReadonly(t, openImage(t, mkfs(t, tr, tt.args), os.O_RDWR))
+		})
+	}
+}
+
+func testReadonly(t *testing.T, fs *ext4.FileSystem) {
+	errs := map[string]error{
+		"Mkdir":       fs.Mkdir("/new"),
+		"Remove":      fs.Remove("/hello.txt"),
+		"RemoveAll":   fs.RemoveAll("/dir"),
+		"Rename":      fs.Rename("/hello.txt", "/bye.txt"),
+		"Truncate":    fs.Truncate("/hello.txt", 0),
+		"SetLabel":    fs.SetLabel("label"),
+		"Symlink":     fs.Symlink("/hello.txt", "/new"),
+		"Setxattr":    fs.Setxattr("/hello.txt", "user.foo", nil),
+		"Removexattr": fs.Removexattr("/hello.txt", "user.foo"),
+	}
+	_, errs["OpenFile"] = fs.OpenFile("/hello.txt", os.O_RDWR)
+	for name, err := range errs {
+		if !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("%s returned %v, expected filesystem.ErrReadonlyFilesystem", name, err)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, []string{"-t", "ext4"}))
+	f, err := fs.OpenFile("/big.bin", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open file: %v", err)
+	}
+	for _, offset := range []int64{0, 1, 4095, 4096, 100000, int64(len(tr.big)) - 10} {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("unable to seek to %d: %v", offset, err)
+		}
+		b := make([]byte, 5000)
+		n, err := f.Read(b)
+		if err != nil {
+			t.Fatalf("unable to read at %d: %v", offset, err)
+		}
+		if !bytes.Equal(b[:n], tr.big[offset:offset+int64(n)]) {
+			t.Errorf("read at %d does not match", offset)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("unable to seek to end: %v", err)
+	}
+	if _, err := f.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read at end returned %v, expected io.EOF", err)
+	}
+}
+
+func TestStatfs(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), []string{"-t", "ext4", "-b", "4096", "-N", "2048"}))
+	st, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unable to get statfs: %v", err)
+	}
+	if st.BlockSize != 4096 || st.TotalBytes != imageSize || st.FreeBytes <= 0 || st.FreeBytes+st.UsedBytes != imageSize || st.FreeFiles == 0 {
+		t.Errorf("unexpected statfs %+v", st)
+	}
+	// the reserved inodes, lost+found and everything in the tree
+	if st.Files < 600 || st.Files > 2048 {
+		t.Errorf("statfs has %d files", st.Files)
+	}
+}
+
+func TestReadNotExt4(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "zero.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(imageSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ext4.Read(f, imageSize, 0, 512); err == nil {
+		t.Errorf("read of empty image returned no error")
+	}
+}
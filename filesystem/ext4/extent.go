@@ -0,0 +1,456 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	// extentHeaderMagic starts every node of an extent tree
+	extentHeaderMagic uint16 = 0xf30a
+	// extentHeaderSize is the size of the header of an extent tree node, and of each entry in one
+	extentHeaderSize = 12
+	// extentTailSize is the size of the checksum after the entries of an extent tree node outside the inode
+	extentTailSize = 4
+	// maxExtentLength is the longest an initialized extent can be. Longer lengths mark uninitialized extents,
+	// which read as zeros.
+	maxExtentLength = 32768
+	// maxExtentDepth is the deepest an extent tree can be
+	maxExtentDepth = 5
+	// directBlocks is the number of blocks in the block map of an inode before the indirect blocks
+	directBlocks = 12
+	// inodeExtentEntries is the number of entries in the root of an extent tree, in i_block
+	inodeExtentEntries = (inodeBlockSize - extentHeaderSize) / extentHeaderSize
+)
+
+// extent maps a run of blocks of a file to a run of blocks on disk. A startBlock of 0 is a hole.
+type extent struct {
+	fileBlock     uint32
+	startBlock    uint64
+	count         uint32
+	uninitialized bool
+}
+
+// extentHeader is the header of a node of an extent tree
+type extentHeader struct {
+	entries uint16
+	max     uint16
+	depth   uint16
+}
+
+func extentHeaderFromBytes(b []byte) (*extentHeader, error) {
+	if len(b) < extentHeaderSize {
+		return nil, fmt.Errorf("cannot read extent header from %d bytes", len(b))
+	}
+	if magic := binary.LittleEndian.Uint16(b[0:2]); magic != extentHeaderMagic {
+		return nil, fmt.Errorf("invalid extent header magic %#04x", magic)
+	}
+	eh := extentHeader{
+		entries: binary.LittleEndian.Uint16(b[2:4]),
+		max:     binary.LittleEndian.Uint16(b[4:6]),
+		depth:   binary.LittleEndian.Uint16(b[6:8]),
+	}
+	switch {
+	case eh.entries > eh.max:
+		return nil, fmt.Errorf("extent node has %d entries, more than its maximum %d", eh.entries, eh.max)
+	case extentHeaderSize*(int(eh.max)+1) > len(b):
+		return nil, fmt.Errorf("extent node maximum %d entries does not fit in %d bytes", eh.max, len(b))
+	case eh.depth > maxExtentDepth:
+		return nil, fmt.Errorf("extent tree depth %d is more than maximum %d", eh.depth, maxExtentDepth)
+	}
+	return &eh, nil
+}
+
+// extentsFromBytes reads a node of an extent tree, from i_block for the root or from a block for any other
+// node, and returns the extents of it and all of the nodes under it in order
+func (fs *FileSystem) extentsFromBytes(b []byte, in *inode, depth int) ([]extent, error) {
+	eh, err := extentHeaderFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if depth >= 0 && int(eh.depth) != depth {
+		return nil, fmt.Errorf("extent node has depth %d, expected %d", eh.depth, depth)
+	}
+	var extents []extent
+	for i := 0; i < int(eh.entries); i++ {
+		e := b[extentHeaderSize*(i+1) : extentHeaderSize*(i+2)]
+		if eh.depth == 0 {
+			length := uint32(binary.LittleEndian.Uint16(e[4:6]))
+			ext := extent{
+				fileBlock:  binary.LittleEndian.Uint32(e[0:4]),
+				startBlock: uint64(binary.LittleEndian.Uint16(e[6:8]))<<32 | uint64(binary.LittleEndian.Uint32(e[8:12])),
+				count:      length,
+			}
+			if length > maxExtentLength {
+				ext.count = length - maxExtentLength
+				ext.uninitialized = true
+			}
+			extents = append(extents, ext)
+			continue
+		}
+		leaf := uint64(binary.LittleEndian.Uint16(e[8:10]))<<32 | uint64(binary.LittleEndian.Uint32(e[4:8]))
+		node, err := fs.readBlock(leaf)
+		if err != nil {
+			return nil, fmt.Errorf("could not read extent tree node at block %d: %v", leaf, err)
+		}
+		if fs.superblock.hasMetadataCsum() {
+			if err := verifyExtentTail(node, in, fs.superblock); err != nil {
+				return nil, err
+			}
+		}
+		children, err := fs.extentsFromBytes(node, in, int(eh.depth)-1)
+		if err != nil {
+			return nil, err
+		}
+		extents = append(extents, children...)
+	}
+	return extents, nil
+}
+
+// verifyExtentTail checks the checksum that follows the maximum number of entries in an extent tree node
+func verifyExtentTail(b []byte, in *inode, sb *superblock) error {
+	eh, err := extentHeaderFromBytes(b)
+	if err != nil {
+		return err
+	}
+	offset := extentHeaderSize * (int(eh.max) + 1)
+	if offset+extentTailSize > len(b) {
+		return fmt.Errorf("extent node has no room for its checksum")
+	}
+	checksum := binary.LittleEndian.Uint32(b[offset : offset+extentTailSize])
+	if expected := crc32c(inodeChecksumSeed(in, sb), b[:offset]); expected != checksum {
+		return fmt.Errorf("extent node checksum %#08x does not match expected %#08x", checksum, expected)
+	}
+	return nil
+}
+
+// inodeChecksumSeed is the seed for the checksums of the metadata blocks that belong to an inode
+func inodeChecksumSeed(in *inode, sb *superblock) uint32 {
+	le := make([]byte, 4)
+	binary.LittleEndian.PutUint32(le, in.number)
+	crc := crc32c(sb.metadataChecksumSeed(), le)
+	binary.LittleEndian.PutUint32(le, in.generation)
+	return crc32c(crc, le)
+}
+
+// blockMapExtents reads the block map of an inode without extents, the direct blocks and the single, double
+// and triple indirect blocks, and returns it as extents of contiguous blocks
+func (fs *FileSystem) blockMapExtents(in *inode) ([]extent, error) {
+	blockSize := uint64(fs.superblock.blockSize())
+	fileBlocks := (in.size + blockSize - 1) / blockSize
+	var (
+		extents []extent
+		next    uint32
+	)
+	// add appends count blocks starting at block, or a hole of count blocks if block is 0
+	add := func(block uint32, count uint32) {
+		next += count
+		if n := len(extents); n > 0 {
+			last := &extents[n-1]
+			if (last.startBlock == 0 && block == 0) || (last.startBlock != 0 && block != 0 && last.startBlock+uint64(last.count) == uint64(block)) {
+				last.count += count
+				return
+			}
+		}
+		extents = append(extents, extent{fileBlock: next - count, startBlock: uint64(block), count: count})
+	}
+	// walk reads an indirect block of the given level, 0 being the data block itself
+	var walk func(block uint32, level int) error
+	walk = func(block uint32, level int) error {
+		if uint64(next) >= fileBlocks {
+			return nil
+		}
+		if level == 0 {
+			add(block, 1)
+			return nil
+		}
+		perBlock := uint32(blockSize / 4)
+		if block == 0 {
+			// a hole the size of everything under this indirect block
+			span := uint64(1)
+			for i := 0; i < level; i++ {
+				span *= uint64(perBlock)
+			}
+			if remaining := fileBlocks - uint64(next); span > remaining {
+				span = remaining
+			}
+			add(0, uint32(span))
+			return nil
+		}
+		b, err := fs.readBlock(uint64(block))
+		if err != nil {
+			return fmt.Errorf("could not read indirect block %d: %v", block, err)
+		}
+		for i := uint32(0); i < perBlock; i++ {
+			if err := walk(binary.LittleEndian.Uint32(b[4*i:]), level-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < directBlocks+3; i++ {
+		level := 0
+		if i >= directBlocks {
+			level = i - directBlocks + 1
+		}
+		if err := walk(binary.LittleEndian.Uint32(in.block[4*i:]), level); err != nil {
+			return nil, err
+		}
+	}
+	return extents, nil
+}
+
+// fileExtents returns the extents of an inode, whether it has an extent tree or a block map
+func (fs *FileSystem) fileExtents(in *inode) ([]extent, error) {
+	if in.hasExtents() {
+		return fs.extentsFromBytes(in.block[:], in, -1)
+	}
+	return fs.blockMapExtents(in)
+}
+
+// setExtent maps the blocks of a file in e, replacing whatever mapped them before, and returns the extents in
+// order with any that can be joined joined. Extents of any length are kept here, as writeExtentTree splits
+// those too long for the tree.
+func setExtent(extents []extent, e extent) []extent {
+	end := e.fileBlock + e.count
+	out := make([]extent, 0, len(extents)+2)
+	for _, x := range extents {
+		xEnd := x.fileBlock + x.count
+		if xEnd <= e.fileBlock || x.fileBlock >= end {
+			out = append(out, x)
+			continue
+		}
+		// keep whatever of x is before or after e
+		if x.fileBlock < e.fileBlock {
+			out = append(out, extent{fileBlock: x.fileBlock, startBlock: x.startBlock, count: e.fileBlock - x.fileBlock, uninitialized: x.uninitialized})
+		}
+		if xEnd > end {
+			out = append(out, extent{fileBlock: end, startBlock: x.startBlock + uint64(end-x.fileBlock), count: xEnd - end, uninitialized: x.uninitialized})
+		}
+	}
+	out = append(out, e)
+	sort.Slice(out, func(i, j int) bool { return out[i].fileBlock < out[j].fileBlock })
+	merged := out[:1]
+	for _, x := range out[1:] {
+		last := &merged[len(merged)-1]
+		if last.fileBlock+last.count == x.fileBlock && last.startBlock+uint64(last.count) == x.startBlock && last.uninitialized == x.uninitialized {
+			last.count += x.count
+			continue
+		}
+		merged = append(merged, x)
+	}
+	return merged
+}
+
+// truncateExtents cuts the extents of a file down to its first blocks, and returns the extents kept and those
+// no longer needed
+func truncateExtents(extents []extent, blocks uint32) (kept, dropped []extent) {
+	for _, e := range extents {
+		switch {
+		case e.fileBlock+e.count <= blocks:
+			kept = append(kept, e)
+		case e.fileBlock >= blocks:
+			dropped = append(dropped, e)
+		default:
+			keep := blocks - e.fileBlock
+			kept = append(kept, extent{fileBlock: e.fileBlock, startBlock: e.startBlock, count: keep, uninitialized: e.uninitialized})
+			dropped = append(dropped, extent{fileBlock: blocks, startBlock: e.startBlock + uint64(keep), count: e.count - keep, uninitialized: e.uninitialized})
+		}
+	}
+	return kept, dropped
+}
+
+// extentTreeBlocks returns the blocks that hold the nodes of an extent tree under the node in b, which is
+// i_block for the whole tree
+func (fs *FileSystem) extentTreeBlocks(b []byte) ([]uint64, error) {
+	eh, err := extentHeaderFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if eh.depth == 0 {
+		return nil, nil
+	}
+	var blocks []uint64
+	for i := 0; i < int(eh.entries); i++ {
+		e := b[extentHeaderSize*(i+1) : extentHeaderSize*(i+2)]
+		leaf := uint64(binary.LittleEndian.Uint16(e[8:10]))<<32 | uint64(binary.LittleEndian.Uint32(e[4:8]))
+		node, err := fs.readBlock(leaf)
+		if err != nil {
+			return nil, fmt.Errorf("could not read extent tree node at block %d: %v", leaf, err)
+		}
+		children, err := fs.extentTreeBlocks(node)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, leaf)
+		blocks = append(blocks, children...)
+	}
+	return blocks, nil
+}
+
+// writeExtentTree replaces the extent tree of an inode with one for extents, and writes the inode. The root
+// in i_block holds up to 4 entries; beyond that, the extents go in leaf blocks, with as many levels of index
+// blocks above them as it takes for the top level to fit in the root. The blocks of the old tree are used
+// again, with any more it needs allocated and any it no longer needs freed.
+func (fs *FileSystem) writeExtentTree(in *inode, extents []extent) error {
+	// split extents too long for a single entry
+	var entries [][]byte
+	var firstBlocks []uint32
+	for _, e := range extents {
+		maxLength := uint32(maxExtentLength)
+		if e.uninitialized {
+			maxLength--
+		}
+		for done := uint32(0); done < e.count; {
+			length := e.count - done
+			if length > maxLength {
+				length = maxLength
+			}
+			start := e.startBlock + uint64(done)
+			b := make([]byte, extentHeaderSize)
+			binary.LittleEndian.PutUint32(b[0:4], e.fileBlock+done)
+			encoded := uint16(length)
+			if e.uninitialized {
+				encoded += maxExtentLength
+			}
+			binary.LittleEndian.PutUint16(b[4:6], encoded)
+			binary.LittleEndian.PutUint16(b[6:8], uint16(start>>32))
+			binary.LittleEndian.PutUint32(b[8:12], uint32(start))
+			entries = append(entries, b)
+			firstBlocks = append(firstBlocks, e.fileBlock+done)
+			done += length
+		}
+	}
+
+	perNode := (int(fs.blockSize) - extentHeaderSize) / extentHeaderSize
+	var levels []int
+	for n := len(entries); n > inodeExtentEntries; {
+		n = (n + perNode - 1) / perNode
+		levels = append(levels, n)
+	}
+	if len(levels) > maxExtentDepth {
+		return fmt.Errorf("extent tree of inode %d would be deeper than %d", in.number, maxExtentDepth)
+	}
+	needed := 0
+	for _, n := range levels {
+		needed += n
+	}
+
+	blocks, err := fs.extentTreeBlocks(in.block[:])
+	if err != nil {
+		return fmt.Errorf("could not read extent tree of inode %d: %v", in.number, err)
+	}
+	switch {
+	case needed > len(blocks):
+		goal := fs.inodeGoal(in)
+		if len(extents) > 0 {
+			goal = extents[0].startBlock
+		}
+		more := needed - len(blocks)
+		runs, err := fs.allocateBlocks(goal, uint64(more))
+		if err != nil {
+			return fmt.Errorf("could not allocate blocks for extent tree of inode %d: %v", in.number, err)
+		}
+		for _, r := range runs {
+			for i := uint64(0); i < uint64(r.count); i++ {
+				blocks = append(blocks, r.startBlock+i)
+			}
+		}
+		fs.addInodeBlocks(in, int64(more))
+	case needed < len(blocks):
+		var unused []extent
+		for _, block := range blocks[needed:] {
+			unused = append(unused, extent{startBlock: block, count: 1})
+		}
+		if err := fs.freeBlocks(unused); err != nil {
+			return err
+		}
+		fs.addInodeBlocks(in, -int64(len(blocks)-needed))
+		blocks = blocks[:needed]
+	}
+
+	seed := inodeChecksumSeed(in, fs.superblock)
+	next := 0
+	for depth, nodes := range levels {
+		var (
+			parentEntries [][]byte
+			parentFirst   []uint32
+		)
+		for i := 0; i < nodes; i++ {
+			end := (i + 1) * perNode
+			if end > len(entries) {
+				end = len(entries)
+			}
+			chunk := entries[i*perNode : end]
+			block := blocks[next]
+			next++
+			b := make([]byte, fs.blockSize)
+			putExtentHeader(b, len(chunk), perNode, depth)
+			for j, e := range chunk {
+				copy(b[extentHeaderSize*(j+1):], e)
+			}
+			if fs.superblock.hasMetadataCsum() {
+				offset := extentHeaderSize * (perNode + 1)
+				binary.LittleEndian.PutUint32(b[offset:offset+extentTailSize], crc32c(seed, b[:offset]))
+			}
+			if _, err := fs.file.WriteAt(b, fs.start+int64(block)*fs.blockSize); err != nil {
+				return fmt.Errorf("could not write extent tree node at block %d: %v", block, err)
+			}
+			index := make([]byte, extentHeaderSize)
+			binary.LittleEndian.PutUint32(index[0:4], firstBlocks[i*perNode])
+			binary.LittleEndian.PutUint32(index[4:8], uint32(block))
+			binary.LittleEndian.PutUint16(index[8:10], uint16(block>>32))
+			parentEntries = append(parentEntries, index)
+			parentFirst = append(parentFirst, firstBlocks[i*perNode])
+		}
+		entries, firstBlocks = parentEntries, parentFirst
+	}
+	in.block = [inodeBlockSize]byte{}
+	putExtentHeader(in.block[:], len(entries), inodeExtentEntries, len(levels))
+	for j, e := range entries {
+		copy(in.block[extentHeaderSize*(j+1):], e)
+	}
+	return fs.writeInode(in)
+}
+
+// putExtentHeader writes the header of an extent tree node
+func putExtentHeader(b []byte, entries, max, depth int) {
+	binary.LittleEndian.PutUint16(b[0:2], extentHeaderMagic)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(entries))
+	binary.LittleEndian.PutUint16(b[4:6], uint16(max))
+	binary.LittleEndian.PutUint16(b[6:8], uint16(depth))
+}
+
+// blockMapIndirectBlocks returns the indirect blocks of an inode without extents, which it owns as well as
+// its data blocks
+func (fs *FileSystem) blockMapIndirectBlocks(in *inode) ([]extent, error) {
+	perBlock := fs.blockSize / 4
+	var blocks []extent
+	var walk func(block uint32, level int) error
+	walk = func(block uint32, level int) error {
+		if block == 0 {
+			return nil
+		}
+		blocks = append(blocks, extent{startBlock: uint64(block), count: 1})
+		if level == 1 {
+			return nil
+		}
+		b, err := fs.readBlock(uint64(block))
+		if err != nil {
+			return fmt.Errorf("could not read indirect block %d: %v", block, err)
+		}
+		for i := int64(0); i < perBlock; i++ {
+			if err := walk(binary.LittleEndian.Uint32(b[4*i:]), level-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for level := 1; level <= 3; level++ {
+		if err := walk(binary.LittleEndian.Uint32(in.block[4*(directBlocks+level-1):]), level); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
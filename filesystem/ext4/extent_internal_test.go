@@ -0,0 +1,196 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// memFileSystem is just enough of a filesystem of 1KB blocks in memory to read extents and block maps
+func memFileSystem(blocks int) (*FileSystem, util.File) {
+	f := util.NewMemFile(int64(blocks) * KB)
+	return &FileSystem{
+		superblock: &superblock{blocksCount: uint64(blocks)},
+		blockSize:  KB,
+		size:       int64(blocks) * KB,
+		file:       f,
+	}, f
+}
+
+func extentNode(depth uint16, max uint16, entries ...[3]uint64) []byte {
+	b := make([]byte, extentHeaderSize*(int(max)+1))
+	binary.LittleEndian.PutUint16(b[0:2], extentHeaderMagic)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(len(entries)))
+	binary.LittleEndian.PutUint16(b[4:6], max)
+	binary.LittleEndian.PutUint16(b[6:8], depth)
+	for i, e := range entries {
+		x := b[extentHeaderSize*(i+1):]
+		binary.LittleEndian.PutUint32(x[0:4], uint32(e[0]))
+		if depth == 0 {
+			// file block, length, start
+			binary.LittleEndian.PutUint16(x[4:6], uint16(e[1]))
+			binary.LittleEndian.PutUint16(x[6:8], uint16(e[2]>>32))
+			binary.LittleEndian.PutUint32(x[8:12], uint32(e[2]))
+		} else {
+			// file block, child block
+			binary.LittleEndian.PutUint32(x[4:8], uint32(e[1]))
+			binary.LittleEndian.PutUint16(x[8:10], uint16(e[1]>>32))
+		}
+	}
+	return b
+}
+
+func TestExtentsFromBytes(t *testing.T) {
+	fs, f := memFileSystem(64)
+	// a tree of depth 1, with two leaves in blocks 10 and 11
+	leaf1 := extentNode(0, 4, [3]uint64{0, 3, 20}, [3]uint64{3, maxExtentLength + 2, 30})
+	leaf2 := extentNode(0, 4, [3]uint64{10, 1, 40})
+	if _, err := f.WriteAt(leaf1, 10*KB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(leaf2, 11*KB); err != nil {
+		t.Fatal(err)
+	}
+	in := &inode{flags: inodeFlagExtents}
+	copy(in.block[:], extentNode(1, 4, [3]uint64{0, 10}, [3]uint64{10, 11}))
+
+	extents, err := fs.fileExtents(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []extent{
+		{fileBlock: 0, startBlock: 20, count: 3},
+		{fileBlock: 3, startBlock: 30, count: 2, uninitialized: true},
+		{fileBlock: 10, startBlock: 40, count: 1},
+	}
+	if len(extents) != len(expected) {
+		t.Fatalf("got %d extents, expected %d", len(extents), len(expected))
+	}
+	for i := range extents {
+		if extents[i] != expected[i] {
+			t.Errorf("extent %d: got %+v, expected %+v", i, extents[i], expected[i])
+		}
+	}
+	for block, e := range map[uint32]*extent{0: &extents[0], 2: &extents[0], 4: &extents[1], 5: nil, 9: nil, 10: &extents[2], 11: nil} {
+		if got := findExtent(extents, block); got != e {
+			t.Errorf("block %d: found %v, expected %v", block, got, e)
+		}
+	}
+
+	// a leaf at the wrong depth
+	copy(in.block[:], extentNode(2, 4, [3]uint64{0, 10}))
+	if _, err := fs.fileExtents(in); err == nil {
+		t.Errorf("extent tree with leaf at wrong depth returned no error")
+	}
+	// a bad magic
+	in.block[0] = 0
+	if _, err := fs.fileExtents(in); err == nil {
+		t.Errorf("extent tree with bad magic returned no error")
+	}
+}
+
+func TestBlockMapExtents(t *testing.T) {
+	fs, f := memFileSystem(2048)
+	in := &inode{}
+	put := func(b []byte, i int, v uint32) {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	// 12 direct blocks, with a hole at 5
+	for i := 0; i < directBlocks; i++ {
+		if i != 5 {
+			put(in.block[:], i, uint32(100+i))
+		}
+	}
+	// the single indirect block holds 256 blocks, contiguous from 200
+	indirect := make([]byte, KB)
+	for i := 0; i < 256; i++ {
+		put(indirect, i, uint32(200+i))
+	}
+	if _, err := f.WriteAt(indirect, 50*KB); err != nil {
+		t.Fatal(err)
+	}
+	put(in.block[:], directBlocks, 50)
+	// the double indirect block is a hole, then there are 3 more blocks in the triple indirect
+	triple, double, single := make([]byte, KB), make([]byte, KB), make([]byte, KB)
+	put(triple, 0, 52)
+	put(double, 0, 53)
+	for i := 0; i < 3; i++ {
+		put(single, i, uint32(1000+i))
+	}
+	for block, b := range map[int64][]byte{51: triple, 52: double, 53: single} {
+		if _, err := f.WriteAt(b, block*KB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(in.block[:], directBlocks+2, 51)
+	fileBlocks := uint64(directBlocks + 256 + 256*256 + 3)
+	in.size = fileBlocks * uint64(KB)
+
+	extents, err := fs.fileExtents(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []extent{
+		{fileBlock: 0, startBlock: 100, count: 5},
+		{fileBlock: 5, startBlock: 0, count: 1},
+		{fileBlock: 6, startBlock: 106, count: 6},
+		{fileBlock: 12, startBlock: 200, count: 256},
+		{fileBlock: 268, startBlock: 0, count: 256 * 256},
+		{fileBlock: 268 + 256*256, startBlock: 1000, count: 3},
+	}
+	if len(extents) != len(expected) {
+		t.Fatalf("got %d extents %+v, expected %d", len(extents), extents, len(expected))
+	}
+	for i := range extents {
+		if extents[i] != expected[i] {
+			t.Errorf("extent %d: got %+v, expected %+v", i, extents[i], expected[i])
+		}
+	}
+}
+
+func TestSetExtent(t *testing.T) {
+	tests := []struct {
+		name     string
+		extents  []extent
+		e        extent
+		expected []extent
+	}{
+		{"into empty", nil, extent{fileBlock: 0, startBlock: 100, count: 5}, []extent{{0, 100, 5, false}}},
+		{"joins after", []extent{{0, 100, 5, false}}, extent{fileBlock: 5, startBlock: 105, count: 3}, []extent{{0, 100, 8, false}}},
+		{"not contiguous on disk", []extent{{0, 100, 5, false}}, extent{fileBlock: 5, startBlock: 200, count: 3}, []extent{{0, 100, 5, false}, {5, 200, 3, false}}},
+		{"into hole", []extent{{0, 100, 2, false}, {10, 300, 2, false}}, extent{fileBlock: 4, startBlock: 200, count: 2}, []extent{{0, 100, 2, false}, {4, 200, 2, false}, {10, 300, 2, false}}},
+		{"splits", []extent{{0, 100, 10, true}}, extent{fileBlock: 3, startBlock: 103, count: 2}, []extent{{0, 100, 3, true}, {3, 103, 2, false}, {5, 105, 5, true}}},
+		{"replaces", []extent{{0, 100, 4, false}, {4, 200, 4, false}}, extent{fileBlock: 2, startBlock: 500, count: 4}, []extent{{0, 100, 2, false}, {2, 500, 4, false}, {6, 202, 2, false}}},
+		{"joins both", []extent{{0, 100, 2, false}, {3, 103, 2, false}}, extent{fileBlock: 2, startBlock: 102, count: 1}, []extent{{0, 100, 5, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := setExtent(tt.extents, tt.e)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("got %v, expected %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTruncateExtents(t *testing.T) {
+	extents := []extent{{0, 100, 4, false}, {4, 200, 4, false}, {10, 300, 2, false}}
+	tests := []struct {
+		blocks  uint32
+		kept    []extent
+		dropped []extent
+	}{
+		{12, extents, nil},
+		{6, []extent{{0, 100, 4, false}, {4, 200, 2, false}}, []extent{{6, 202, 2, false}, {10, 300, 2, false}}},
+		{4, extents[:1], extents[1:]},
+		{0, nil, extents},
+	}
+	for _, tt := range tests {
+		kept, dropped := truncateExtents(extents, tt.blocks)
+		if !reflect.DeepEqual(kept, tt.kept) || !reflect.DeepEqual(dropped, tt.dropped) {
+			t.Errorf("truncate to %d blocks kept %v dropped %v, expected %v and %v", tt.blocks, kept, dropped, tt.kept, tt.dropped)
+		}
+	}
+}
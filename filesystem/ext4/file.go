@@ -0,0 +1,104 @@
+package ext4
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in an ext4 filesystem
+type File struct {
+	*inode
+	// extents are the blocks of the file, unless it has inline data
+	extents []extent
+	// inlineData is the contents of the file, if it has inline data
+	inlineData  []byte
+	isReadWrite bool
+	isAppend    bool
+	offset      int64
+	filesystem  *FileSystem
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// and increments the offset by the number of bytes read.
+// Use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	remaining := int64(fl.size) - fl.offset
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > remaining {
+		b = b[:remaining]
+	}
+	if fl.hasInlineData() {
+		n := copy(b, fl.inlineData[fl.offset:])
+		fl.offset += int64(n)
+		return n, nil
+	}
+	n, err := fl.filesystem.readExtents(fl.extents, b, fl.offset)
+	fl.offset += int64(n)
+	return n, err
+}
+
+// Write writes len(b) bytes to the File.
+// It returns the number of bytes written and an error, if any.
+// returns a non-nil error when n != len(b)
+// writes to the last known offset in the file from last read or write
+// and increments the offset by the number of bytes written.
+// Use Seek() to set at a particular point
+func (fl *File) Write(p []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	if !fl.isReadWrite {
+		return 0, fmt.Errorf("cannot write to file opened read-only")
+	}
+	if fl.hasInlineData() {
+		return 0, fmt.Errorf("cannot write to file with inline data: %w", filesystem.ErrReadonlyFilesystem)
+	}
+	if fl.isAppend {
+		fl.offset = int64(fl.size)
+	}
+	extents, err := fl.filesystem.writeData(fl.inode, fl.extents, p, fl.offset)
+	if err != nil {
+		return 0, fmt.Errorf("unable to write to file: %v", err)
+	}
+	fl.extents = extents
+	fl.offset += int64(len(p))
+	return len(p), nil
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = int64(fl.size) + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
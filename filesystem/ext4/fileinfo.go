@@ -0,0 +1,111 @@
+package ext4
+
+import (
+	"os"
+	"time"
+)
+
+// FileStat is the extended data underlying a single file, similar to https://golang.org/pkg/syscall/#Stat_t
+type FileStat struct {
+	inode      uint32
+	links      uint16
+	uid        uint32
+	gid        uint32
+	accessTime time.Time
+	changeTime time.Time
+	createTime time.Time
+}
+
+// Inode get the inode number of file
+func (f *FileStat) Inode() uint32 {
+	return f.inode
+}
+
+// Nlink get the number of hard links to file
+func (f *FileStat) Nlink() uint16 {
+	return f.links
+}
+
+// UID get uid of file
+func (f *FileStat) UID() uint32 {
+	return f.uid
+}
+
+// GID get gid of file
+func (f *FileStat) GID() uint32 {
+	return f.gid
+}
+
+// AccessTime get last access time of file
+func (f *FileStat) AccessTime() time.Time {
+	return f.accessTime
+}
+
+// ChangeTime get last time the inode of file changed
+func (f *FileStat) ChangeTime() time.Time {
+	return f.changeTime
+}
+
+// CreateTime get creation time of file, or the zero time if the inode is too small to have one
+func (f *FileStat) CreateTime() time.Time {
+	return f.createTime
+}
+
+// FileInfo represents the information for an individual file
+// it fulfills os.FileInfo interface
+type FileInfo struct {
+	modTime time.Time
+	mode    os.FileMode
+	name    string
+	size    int64
+	sys     FileStat
+}
+
+// IsDir abbreviation for Mode().IsDir()
+func (fi *FileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+// ModTime modification time
+func (fi *FileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+// Mode returns file mode
+func (fi *FileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+// Name base name of the file
+func (fi *FileInfo) Name() string {
+	return fi.name
+}
+
+// Size length in bytes for regular files
+func (fi *FileInfo) Size() int64 {
+	return fi.size
+}
+
+// Sys underlying data source, a *FileStat
+func (fi *FileInfo) Sys() interface{} {
+	return &fi.sys
+}
+
+// fileInfo returns the FileInfo for an inode with the given name
+func (in *inode) fileInfo(name string) *FileInfo {
+	return &FileInfo{
+		modTime: in.modifyTime,
+		mode:    in.fileMode(),
+		name:    name,
+		size:    int64(in.size),
+		sys: FileStat{
+			inode:      in.number,
+			links:      in.linksCount,
+			uid:        in.uid,
+			gid:        in.gid,
+			accessTime: in.accessTime,
+			changeTime: in.changeTime,
+			createTime: in.createTime,
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// block group flags
+const (
+	bgInodeUninit uint16 = 0x1
+	bgBlockUninit uint16 = 0x2
+	bgInodeZeroed uint16 = 0x4
+)
+
+// groupDescriptor describes one block group: where its bitmaps and inode table are, and how much of it is free
+type groupDescriptor struct {
+	number           uint32
+	blockBitmap      uint64
+	inodeBitmap      uint64
+	inodeTable       uint64
+	freeBlocks       uint32
+	freeInodes       uint32
+	usedDirectories  uint32
+	flags            uint16
+	inodeTableUnused uint32
+	excludeBitmap    uint64
+	blockBitmapCsum  uint32
+	inodeBitmapCsum  uint32
+	checksum         uint16
+}
+
+// groupDescriptorFromBytes reads one group descriptor of 32 bytes, or of 64 or more on a 64-bit filesystem
+func groupDescriptorFromBytes(b []byte, number uint32, sb *superblock) (*groupDescriptor, error) {
+	size := sb.groupDescriptorSize()
+	if len(b) < size {
+		return nil, fmt.Errorf("cannot read group descriptor from %d bytes, must be %d", len(b), size)
+	}
+	gd := groupDescriptor{
+		number:           number,
+		blockBitmap:      uint64(binary.LittleEndian.Uint32(b[0:4])),
+		inodeBitmap:      uint64(binary.LittleEndian.Uint32(b[4:8])),
+		inodeTable:       uint64(binary.LittleEndian.Uint32(b[8:12])),
+		freeBlocks:       uint32(binary.LittleEndian.Uint16(b[12:14])),
+		freeInodes:       uint32(binary.LittleEndian.Uint16(b[14:16])),
+		usedDirectories:  uint32(binary.LittleEndian.Uint16(b[16:18])),
+		flags:            binary.LittleEndian.Uint16(b[18:20]),
+		excludeBitmap:    uint64(binary.LittleEndian.Uint32(b[20:24])),
+		blockBitmapCsum:  uint32(binary.LittleEndian.Uint16(b[24:26])),
+		inodeBitmapCsum:  uint32(binary.LittleEndian.Uint16(b[26:28])),
+		inodeTableUnused: uint32(binary.LittleEndian.Uint16(b[28:30])),
+		checksum:         binary.LittleEndian.Uint16(b[30:32]),
+	}
+	if size >= 64 {
+		gd.blockBitmap |= uint64(binary.LittleEndian.Uint32(b[32:36])) << 32
+		gd.inodeBitmap |= uint64(binary.LittleEndian.Uint32(b[36:40])) << 32
+		gd.inodeTable |= uint64(binary.LittleEndian.Uint32(b[40:44])) << 32
+		gd.freeBlocks |= uint32(binary.LittleEndian.Uint16(b[44:46])) << 16
+		gd.freeInodes |= uint32(binary.LittleEndian.Uint16(b[46:48])) << 16
+		gd.usedDirectories |= uint32(binary.LittleEndian.Uint16(b[48:50])) << 16
+		gd.inodeTableUnused |= uint32(binary.LittleEndian.Uint16(b[50:52])) << 16
+		gd.excludeBitmap |= uint64(binary.LittleEndian.Uint32(b[52:56])) << 32
+		gd.blockBitmapCsum |= uint32(binary.LittleEndian.Uint16(b[56:58])) << 16
+		gd.inodeBitmapCsum |= uint32(binary.LittleEndian.Uint16(b[58:60])) << 16
+	}
+	if checksum, ok := groupDescriptorChecksum(b[:size], number, sb); ok && checksum != gd.checksum {
+		return nil, fmt.Errorf("group descriptor %d checksum %#04x does not match expected %#04x", number, checksum, gd.checksum)
+	}
+	return &gd, nil
+}
+
+// toBytes returns the group descriptor as stored on disk, with its checksum calculated afresh
+func (gd *groupDescriptor) toBytes(sb *superblock) []byte {
+	b := make([]byte, sb.groupDescriptorSize())
+	binary.LittleEndian.PutUint32(b[0:4], uint32(gd.blockBitmap))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(gd.inodeBitmap))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(gd.inodeTable))
+	binary.LittleEndian.PutUint16(b[12:14], uint16(gd.freeBlocks))
+	binary.LittleEndian.PutUint16(b[14:16], uint16(gd.freeInodes))
+	binary.LittleEndian.PutUint16(b[16:18], uint16(gd.usedDirectories))
+	binary.LittleEndian.PutUint16(b[18:20], gd.flags)
+	binary.LittleEndian.PutUint32(b[20:24], uint32(gd.excludeBitmap))
+	binary.LittleEndian.PutUint16(b[24:26], uint16(gd.blockBitmapCsum))
+	binary.LittleEndian.PutUint16(b[26:28], uint16(gd.inodeBitmapCsum))
+	binary.LittleEndian.PutUint16(b[28:30], uint16(gd.inodeTableUnused))
+	if len(b) >= 64 {
+		binary.LittleEndian.PutUint32(b[32:36], uint32(gd.blockBitmap>>32))
+		binary.LittleEndian.PutUint32(b[36:40], uint32(gd.inodeBitmap>>32))
+		binary.LittleEndian.PutUint32(b[40:44], uint32(gd.inodeTable>>32))
+		binary.LittleEndian.PutUint16(b[44:46], uint16(gd.freeBlocks>>16))
+		binary.LittleEndian.PutUint16(b[46:48], uint16(gd.freeInodes>>16))
+		binary.LittleEndian.PutUint16(b[48:50], uint16(gd.usedDirectories>>16))
+		binary.LittleEndian.PutUint16(b[50:52], uint16(gd.inodeTableUnused>>16))
+		binary.LittleEndian.PutUint32(b[52:56], uint32(gd.excludeBitmap>>32))
+		binary.LittleEndian.PutUint16(b[56:58], uint16(gd.blockBitmapCsum>>16))
+		binary.LittleEndian.PutUint16(b[58:60], uint16(gd.inodeBitmapCsum>>16))
+	}
+	if checksum, ok := groupDescriptorChecksum(b, gd.number, sb); ok {
+		gd.checksum = checksum
+	}
+	binary.LittleEndian.PutUint16(b[30:32], gd.checksum)
+	return b
+}
+
+// groupDescriptorChecksum calculates the checksum of a group descriptor as stored on disk, with either
+// metadata_csum or the older gdt_csum. If the filesystem has neither, it returns false.
+func groupDescriptorChecksum(b []byte, number uint32, sb *superblock) (uint16, bool) {
+	groupNumber := make([]byte, 4)
+	binary.LittleEndian.PutUint32(groupNumber, number)
+	switch {
+	case sb.hasMetadataCsum():
+		crc := crc32c(sb.metadataChecksumSeed(), groupNumber)
+		crc = crc32c(crc, b[:30])
+		crc = crc32c(crc, []byte{0, 0})
+		crc = crc32c(crc, b[32:])
+		return uint16(crc), true
+	case sb.featureROCompat&featureROCompatGdtCsum != 0:
+		crc := crc16(0xffff, sb.uuid[:])
+		crc = crc16(crc, groupNumber)
+		crc = crc16(crc, b[:30])
+		if len(b) > 32 {
+			crc = crc16(crc, b[32:])
+		}
+		return crc, true
+	}
+	return 0, false
+}
+
+// groupDescriptorLocation returns the block that holds the group descriptor of a group, and its index in
+// that block
+func groupDescriptorLocation(group uint32, sb *superblock) (uint64, int) {
+	perBlock := uint32(sb.blockSize() / sb.groupDescriptorSize())
+	block, index := group/perBlock, int(group%perBlock)
+	if sb.featureIncompat&featureIncompatMetaBG == 0 || block < sb.firstMetaBG {
+		return uint64(sb.firstDataBlock) + 1 + uint64(block), index
+	}
+	// with meta_bg, each meta group of block groups keeps its own descriptors in its first block group
+	first := block * perBlock
+	location := uint64(first)*uint64(sb.blocksPerGroup) + uint64(sb.firstDataBlock)
+	if sb.hasSuperblockBackup(first) {
+		location++
+	}
+	return location, index
+}
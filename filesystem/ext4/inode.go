@@ -0,0 +1,275 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// goodOldInodeSize is the size of an inode before the extra fields, and of every inode in revision 0
+	goodOldInodeSize = 128
+	// inodeBlockSize is the size of i_block, the 60 bytes of an inode that hold the block map, the root of
+	// the extent tree, a fast symlink target or inline data
+	inodeBlockSize = 60
+)
+
+// inode flags
+const (
+	inodeFlagIndex      uint32 = 0x1000
+	inodeFlagHugeFile   uint32 = 0x40000
+	inodeFlagExtents    uint32 = 0x80000
+	inodeFlagEAInode    uint32 = 0x200000
+	inodeFlagInlineData uint32 = 0x10000000
+)
+
+// file types in the mode of an inode
+const (
+	modeTypeMask   uint16 = 0xf000
+	modeSocket     uint16 = 0xc000
+	modeSymlink    uint16 = 0xa000
+	modeRegular    uint16 = 0x8000
+	modeBlockDev   uint16 = 0x6000
+	modeDirectory  uint16 = 0x4000
+	modeCharDev    uint16 = 0x2000
+	modeFifo       uint16 = 0x1000
+	modeSetuid     uint16 = 0x800
+	modeSetgid     uint16 = 0x400
+	modeSticky     uint16 = 0x200
+	modePermission uint16 = 0x1ff
+)
+
+// inode is an ext4 inode, with the fields we use
+type inode struct {
+	number       uint32
+	mode         uint16
+	uid          uint32
+	gid          uint32
+	size         uint64
+	accessTime   time.Time
+	changeTime   time.Time
+	modifyTime   time.Time
+	createTime   time.Time
+	deleteTime   uint32
+	linksCount   uint16
+	blocks       uint64
+	flags        uint32
+	block        [inodeBlockSize]byte
+	generation   uint32
+	fileACL      uint64
+	version      uint64
+	extraIsize   uint16
+	projectID    uint32
+	inlineXattrs []byte
+}
+
+// inodeFromBytes reads an inode from the inodeSize bytes of its slot in the inode table
+func inodeFromBytes(b []byte, number uint32, sb *superblock) (*inode, error) {
+	if len(b) < goodOldInodeSize {
+		return nil, fmt.Errorf("cannot read inode from %d bytes, must be at least %d", len(b), goodOldInodeSize)
+	}
+	in := inode{
+		number:     number,
+		mode:       binary.LittleEndian.Uint16(b[0:2]),
+		uid:        uint32(binary.LittleEndian.Uint16(b[2:4])) | uint32(binary.LittleEndian.Uint16(b[120:122]))<<16,
+		size:       uint64(binary.LittleEndian.Uint32(b[4:8])) | uint64(binary.LittleEndian.Uint32(b[108:112]))<<32,
+		deleteTime: binary.LittleEndian.Uint32(b[20:24]),
+		gid:        uint32(binary.LittleEndian.Uint16(b[24:26])) | uint32(binary.LittleEndian.Uint16(b[122:124]))<<16,
+		linksCount: binary.LittleEndian.Uint16(b[26:28]),
+		blocks:     uint64(binary.LittleEndian.Uint32(b[28:32])) | uint64(binary.LittleEndian.Uint16(b[116:118]))<<32,
+		flags:      binary.LittleEndian.Uint32(b[32:36]),
+		generation: binary.LittleEndian.Uint32(b[100:104]),
+		fileACL:    uint64(binary.LittleEndian.Uint32(b[104:108])) | uint64(binary.LittleEndian.Uint16(b[118:120]))<<32,
+		version:    uint64(binary.LittleEndian.Uint32(b[36:40])),
+	}
+	copy(in.block[:], b[40:40+inodeBlockSize])
+	var extra []byte
+	if len(b) > goodOldInodeSize {
+		in.extraIsize = binary.LittleEndian.Uint16(b[128:130])
+		if goodOldInodeSize+int(in.extraIsize) > len(b) || in.extraIsize%4 != 0 {
+			return nil, fmt.Errorf("inode %d has invalid extra size %d", number, in.extraIsize)
+		}
+		extra = b[goodOldInodeSize : goodOldInodeSize+int(in.extraIsize)]
+		in.inlineXattrs = b[goodOldInodeSize+int(in.extraIsize):]
+	}
+	in.changeTime = inodeTime(b[12:16], extra, 4)
+	in.modifyTime = inodeTime(b[16:20], extra, 8)
+	in.accessTime = inodeTime(b[8:12], extra, 12)
+	if len(extra) >= 24 {
+		in.createTime = inodeTime(extra[16:20], extra, 20)
+	}
+	if len(extra) >= 28 {
+		in.version |= uint64(binary.LittleEndian.Uint32(extra[24:28])) << 32
+	}
+	if len(extra) >= 32 {
+		in.projectID = binary.LittleEndian.Uint32(extra[28:32])
+	}
+
+	if sb.hasMetadataCsum() {
+		checksum := uint32(binary.LittleEndian.Uint16(b[124:126]))
+		if len(extra) >= 4 {
+			checksum |= uint32(binary.LittleEndian.Uint16(extra[2:4])) << 16
+		}
+		if expected := inodeChecksum(b, number, sb); expected != checksum {
+			return nil, fmt.Errorf("inode %d checksum %#08x does not match expected %#08x", number, checksum, expected)
+		}
+	}
+	return &in, nil
+}
+
+// inodeTime reads a time stored as 32 bits of seconds in the inode, with nanoseconds and 2 more high bits of
+// seconds in the extra fields at offset, if the inode is big enough to have them
+func inodeTime(seconds, extra []byte, offset int) time.Time {
+	sec := int64(int32(binary.LittleEndian.Uint32(seconds)))
+	var nsec int64
+	if len(extra) >= offset+4 {
+		e := binary.LittleEndian.Uint32(extra[offset : offset+4])
+		sec += int64(e&0x3) << 32
+		nsec = int64(e >> 2)
+	}
+	return time.Unix(sec, nsec)
+}
+
+// toBytes returns the inode as stored in its slot in the inode table, with its checksum calculated afresh
+func (in *inode) toBytes(sb *superblock) []byte {
+	b := make([]byte, sb.inodeSize)
+	binary.LittleEndian.PutUint16(b[0:2], in.mode)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(in.uid))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(in.size))
+	binary.LittleEndian.PutUint32(b[20:24], in.deleteTime)
+	binary.LittleEndian.PutUint16(b[24:26], uint16(in.gid))
+	binary.LittleEndian.PutUint16(b[26:28], in.linksCount)
+	binary.LittleEndian.PutUint32(b[28:32], uint32(in.blocks))
+	binary.LittleEndian.PutUint32(b[32:36], in.flags)
+	binary.LittleEndian.PutUint32(b[36:40], uint32(in.version))
+	copy(b[40:40+inodeBlockSize], in.block[:])
+	binary.LittleEndian.PutUint32(b[100:104], in.generation)
+	binary.LittleEndian.PutUint32(b[104:108], uint32(in.fileACL))
+	binary.LittleEndian.PutUint32(b[108:112], uint32(in.size>>32))
+	binary.LittleEndian.PutUint16(b[116:118], uint16(in.blocks>>32))
+	binary.LittleEndian.PutUint16(b[118:120], uint16(in.fileACL>>32))
+	binary.LittleEndian.PutUint16(b[120:122], uint16(in.uid>>16))
+	binary.LittleEndian.PutUint16(b[122:124], uint16(in.gid>>16))
+
+	var extra []byte
+	if len(b) > goodOldInodeSize {
+		binary.LittleEndian.PutUint16(b[128:130], in.extraIsize)
+		extra = b[goodOldInodeSize : goodOldInodeSize+int(in.extraIsize)]
+		copy(b[goodOldInodeSize+int(in.extraIsize):], in.inlineXattrs)
+	}
+	putInodeTime(b[12:16], extra, 4, in.changeTime)
+	putInodeTime(b[16:20], extra, 8, in.modifyTime)
+	putInodeTime(b[8:12], extra, 12, in.accessTime)
+	if len(extra) >= 24 {
+		putInodeTime(extra[16:20], extra, 20, in.createTime)
+	}
+	if len(extra) >= 28 {
+		binary.LittleEndian.PutUint32(extra[24:28], uint32(in.version>>32))
+	}
+	if len(extra) >= 32 {
+		binary.LittleEndian.PutUint32(extra[28:32], in.projectID)
+	}
+
+	if sb.hasMetadataCsum() {
+		checksum := inodeChecksum(b, in.number, sb)
+		binary.LittleEndian.PutUint16(b[124:126], uint16(checksum))
+		if len(extra) >= 4 {
+			binary.LittleEndian.PutUint16(extra[2:4], uint16(checksum>>16))
+		}
+	}
+	return b
+}
+
+// putInodeTime writes a time as 32 bits of seconds in the inode, with nanoseconds and 2 more high bits of
+// seconds in the extra fields at offset, if the inode is big enough to have them. The zero time is written
+// as 0.
+func putInodeTime(seconds, extra []byte, offset int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	sec := t.Unix()
+	binary.LittleEndian.PutUint32(seconds, uint32(sec))
+	if len(extra) >= offset+4 {
+		epoch := uint32((sec-int64(int32(sec)))>>32) & 0x3
+		binary.LittleEndian.PutUint32(extra[offset:offset+4], epoch|uint32(t.Nanosecond())<<2)
+	}
+}
+
+// inodeChecksum calculates the metadata_csum checksum of an inode as stored on disk, with the checksum fields
+// left out. Inodes with no room for the high 16 bits only keep the low 16.
+func inodeChecksum(b []byte, number uint32, sb *superblock) uint32 {
+	le := make([]byte, 4)
+	binary.LittleEndian.PutUint32(le, number)
+	crc := crc32c(sb.metadataChecksumSeed(), le)
+	crc = crc32c(crc, b[100:104])
+	crc = crc32c(crc, b[:124])
+	crc = crc32c(crc, []byte{0, 0})
+	if len(b) <= goodOldInodeSize {
+		crc = crc32c(crc, b[126:])
+		return crc & 0xffff
+	}
+	crc = crc32c(crc, b[126:130])
+	extraIsize := binary.LittleEndian.Uint16(b[128:130])
+	if extraIsize < 4 {
+		crc = crc32c(crc, b[130:])
+		return crc & 0xffff
+	}
+	crc = crc32c(crc, []byte{0, 0})
+	crc = crc32c(crc, b[132:])
+	return crc
+}
+
+func (in *inode) fileType() uint16 {
+	return in.mode & modeTypeMask
+}
+
+func (in *inode) isDir() bool {
+	return in.fileType() == modeDirectory
+}
+
+func (in *inode) isSymlink() bool {
+	return in.fileType() == modeSymlink
+}
+
+func (in *inode) hasExtents() bool {
+	return in.flags&inodeFlagExtents != 0
+}
+
+func (in *inode) hasInlineData() bool {
+	return in.flags&inodeFlagInlineData != 0
+}
+
+// isFastSymlink reports whether an inode is a symlink with its target in i_block rather than in a data block
+func (in *inode) isFastSymlink() bool {
+	return in.isSymlink() && !in.hasExtents() && !in.hasInlineData() && in.size < inodeBlockSize
+}
+
+// fileMode converts the mode of an inode to an os.FileMode
+func (in *inode) fileMode() os.FileMode {
+	mode := os.FileMode(in.mode & modePermission)
+	switch in.fileType() {
+	case modeDirectory:
+		mode |= os.ModeDir
+	case modeSymlink:
+		mode |= os.ModeSymlink
+	case modeBlockDev:
+		mode |= os.ModeDevice
+	case modeCharDev:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case modeFifo:
+		mode |= os.ModeNamedPipe
+	case modeSocket:
+		mode |= os.ModeSocket
+	}
+	if in.mode&modeSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if in.mode&modeSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if in.mode&modeSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
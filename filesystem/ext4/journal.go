@@ -0,0 +1,59 @@
+package ext4
+
+import "encoding/binary"
+
+const (
+	// journalInode is the inode of the journal, for a filesystem that has one inside it
+	journalInode uint32 = 8
+	// journalMagic starts every block of the journal that has a header, big-endian like everything in it
+	journalMagic uint32 = 0xc03b3998
+	// journalSuperblockV2 is the type of the journal superblock, in its first block
+	journalSuperblockV2 uint32 = 4
+	// journalBackupTypeInode marks journalBlocks in the superblock as a copy of the i_block of the journal
+	journalBackupTypeInode uint8 = 1
+	// minJournalBlocks is how big a filesystem must be, in blocks, to have a journal
+	minJournalBlocks = 2048
+)
+
+// defaultJournalBlocks is how many blocks mke2fs makes the journal of a filesystem of the given number of
+// blocks, or 0 if it is too small for one
+func defaultJournalBlocks(blocks uint64) uint32 {
+	switch {
+	case blocks < minJournalBlocks:
+		return 0
+	case blocks < 32768:
+		return 1024
+	case blocks < 256*1024:
+		return 4096
+	case blocks < 512*1024:
+		return 8192
+	case blocks < 4096*1024:
+		return 16384
+	case blocks < 8192*1024:
+		return 32768
+	case blocks < 16384*1024:
+		return 65536
+	case blocks < 32768*1024:
+		return 131072
+	}
+	return 262144
+}
+
+// journalSuperblockBytes returns the first block of an empty journal of length blocks, which says that there
+// is nothing in it to replay. The rest of the journal need not be zeroed, as it is only read as far as the
+// superblock says there are transactions.
+func journalSuperblockBytes(blockSize int, length uint32, uuid [16]byte) []byte {
+	b := make([]byte, blockSize)
+	binary.BigEndian.PutUint32(b[0:4], journalMagic)
+	binary.BigEndian.PutUint32(b[4:8], journalSuperblockV2)
+	binary.BigEndian.PutUint32(b[12:16], uint32(blockSize))
+	binary.BigEndian.PutUint32(b[16:20], length)
+	// the first block of the log, after this one
+	binary.BigEndian.PutUint32(b[20:24], 1)
+	// the sequence number of the first transaction there will be
+	binary.BigEndian.PutUint32(b[24:28], 1)
+	copy(b[48:64], uuid[:])
+	// it is used by one filesystem, this one
+	binary.BigEndian.PutUint32(b[64:68], 1)
+	return b
+}
@@ -0,0 +1,507 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// superblockOffset is where the superblock is, in bytes from the start of the filesystem, whatever the block size
+	superblockOffset = 1024
+	// superblockSize is the size of the superblock in bytes
+	superblockSize = 1024
+	// superblockMagic identifies an ext2, ext3 or ext4 superblock
+	superblockMagic uint16 = 0xef53
+	// rootInode is the inode number of the root directory
+	rootInode uint32 = 2
+	// checksumTypeCrc32c is the only type of metadata checksum there is
+	checksumTypeCrc32c uint8 = 1
+)
+
+// compatible features: a filesystem with any we do not know about can still be read and written
+const (
+	featureCompatDirPrealloc  uint32 = 0x1
+	featureCompatHasJournal   uint32 = 0x4
+	featureCompatExtAttr      uint32 = 0x8
+	featureCompatResizeInode  uint32 = 0x10
+	featureCompatDirIndex     uint32 = 0x20
+	featureCompatSparseSuper2 uint32 = 0x200
+)
+
+// incompatible features: a filesystem with any we do not know about cannot be read
+const (
+	featureIncompatCompression uint32 = 0x1
+	featureIncompatFiletype    uint32 = 0x2
+	featureIncompatRecover     uint32 = 0x4
+	featureIncompatJournalDev  uint32 = 0x8
+	featureIncompatMetaBG      uint32 = 0x10
+	featureIncompatExtents     uint32 = 0x40
+	featureIncompat64Bit       uint32 = 0x80
+	featureIncompatMMP         uint32 = 0x100
+	featureIncompatFlexBG      uint32 = 0x200
+	featureIncompatEAInode     uint32 = 0x400
+	featureIncompatDirData     uint32 = 0x1000
+	featureIncompatCsumSeed    uint32 = 0x2000
+	featureIncompatLargeDir    uint32 = 0x4000
+	featureIncompatInlineData  uint32 = 0x8000
+	featureIncompatEncrypt     uint32 = 0x10000
+	featureIncompatCasefold    uint32 = 0x20000
+
+	// featureIncompatRead are the incompatible features we can read
+	featureIncompatRead = featureIncompatFiletype | featureIncompatRecover | featureIncompatMetaBG |
+		featureIncompatExtents | featureIncompat64Bit | featureIncompatMMP | featureIncompatFlexBG |
+		featureIncompatEAInode | featureIncompatCsumSeed | featureIncompatLargeDir | featureIncompatInlineData |
+		featureIncompatEncrypt | featureIncompatCasefold
+	// featureIncompatWrite are the incompatible features we can write, but for recover, which only means the
+	// journal needs replaying first
+	featureIncompatWrite = featureIncompatFiletype | featureIncompatMetaBG | featureIncompatExtents |
+		featureIncompat64Bit | featureIncompatFlexBG | featureIncompatCsumSeed | featureIncompatLargeDir
+)
+
+// read-only compatible features: a filesystem with any we do not know about can be read but not written
+const (
+	featureROCompatSparseSuper  uint32 = 0x1
+	featureROCompatLargeFile    uint32 = 0x2
+	featureROCompatHugeFile     uint32 = 0x8
+	featureROCompatGdtCsum      uint32 = 0x10
+	featureROCompatDirNlink     uint32 = 0x20
+	featureROCompatExtraIsize   uint32 = 0x40
+	featureROCompatBigalloc     uint32 = 0x200
+	featureROCompatMetadataCsum uint32 = 0x400
+
+	// featureROCompatWrite are the read-only compatible features we can write
+	featureROCompatWrite = featureROCompatSparseSuper | featureROCompatLargeFile | featureROCompatHugeFile |
+		featureROCompatGdtCsum | featureROCompatDirNlink | featureROCompatExtraIsize | featureROCompatMetadataCsum
+)
+
+// superblock flags
+const (
+	flagSignedHash   uint32 = 0x1
+	flagUnsignedHash uint32 = 0x2
+)
+
+// superblock is the ext4 superblock. Block counts are in blocks, not clusters, even with bigalloc.
+type superblock struct {
+	inodesCount            uint32
+	blocksCount            uint64
+	reservedBlocksCount    uint64
+	freeBlocksCount        uint64
+	freeInodesCount        uint32
+	firstDataBlock         uint32
+	logBlockSize           uint32
+	logClusterSize         uint32
+	blocksPerGroup         uint32
+	clustersPerGroup       uint32
+	inodesPerGroup         uint32
+	mountTime              time.Time
+	writeTime              time.Time
+	mountCount             uint16
+	maxMountCount          int16
+	state                  uint16
+	errors                 uint16
+	minorRevision          uint16
+	lastCheck              time.Time
+	checkInterval          uint32
+	creatorOS              uint32
+	revision               uint32
+	reservedUID            uint16
+	reservedGID            uint16
+	firstInode             uint32
+	inodeSize              uint16
+	blockGroupNumber       uint16
+	featureCompat          uint32
+	featureIncompat        uint32
+	featureROCompat        uint32
+	uuid                   [16]byte
+	volumeName             string
+	lastMounted            string
+	algorithmUsageBitmap   uint32
+	preallocBlocks         uint8
+	preallocDirBlocks      uint8
+	reservedGdtBlocks      uint16
+	journalUUID            [16]byte
+	journalInode           uint32
+	journalDevice          uint32
+	lastOrphan             uint32
+	hashSeed               [4]uint32
+	defaultHashVersion     uint8
+	journalBackupType      uint8
+	descSize               uint16
+	defaultMountOptions    uint32
+	firstMetaBG            uint32
+	mkfsTime               time.Time
+	journalBlocks          [17]uint32
+	minExtraIsize          uint16
+	wantExtraIsize         uint16
+	flags                  uint32
+	raidStride             uint16
+	mmpInterval            uint16
+	mmpBlock               uint64
+	raidStripeWidth        uint32
+	logGroupsPerFlex       uint8
+	checksumType           uint8
+	encryptionLevel        uint8
+	kbytesWritten          uint64
+	snapshotInode          uint32
+	snapshotID             uint32
+	snapshotReservedBlocks uint64
+	snapshotList           uint32
+	errorCount             uint32
+	firstError             superblockError
+	lastError              superblockError
+	mountOptions           string
+	userQuotaInode         uint32
+	groupQuotaInode        uint32
+	overheadClusters       uint32
+	backupBGs              [2]uint32
+	encryptAlgorithms      [4]uint8
+	encryptPasswordSalt    [16]byte
+	lostFoundInode         uint32
+	projectQuotaInode      uint32
+	checksumSeed           uint32
+	encoding               uint16
+	encodingFlags          uint16
+	orphanFileInode        uint32
+	checksum               uint32
+}
+
+// superblockError is what the kernel recorded about the first or the last error it found
+type superblockError struct {
+	time     time.Time
+	inode    uint32
+	block    uint64
+	function string
+	line     uint32
+	code     uint8
+}
+
+// superblockFromBytes reads the superblock from its 1024 bytes
+func superblockFromBytes(b []byte) (*superblock, error) {
+	if len(b) != superblockSize {
+		return nil, fmt.Errorf("cannot read superblock from %d bytes, must be %d", len(b), superblockSize)
+	}
+	if magic := binary.LittleEndian.Uint16(b[56:58]); magic != superblockMagic {
+		return nil, fmt.Errorf("invalid superblock magic %#04x, expected %#04x", magic, superblockMagic)
+	}
+	sb := superblock{
+		inodesCount:         binary.LittleEndian.Uint32(b[0:4]),
+		blocksCount:         uint64(binary.LittleEndian.Uint32(b[4:8])),
+		reservedBlocksCount: uint64(binary.LittleEndian.Uint32(b[8:12])),
+		freeBlocksCount:     uint64(binary.LittleEndian.Uint32(b[12:16])),
+		freeInodesCount:     binary.LittleEndian.Uint32(b[16:20]),
+		firstDataBlock:      binary.LittleEndian.Uint32(b[20:24]),
+		logBlockSize:        binary.LittleEndian.Uint32(b[24:28]),
+		logClusterSize:      binary.LittleEndian.Uint32(b[28:32]),
+		blocksPerGroup:      binary.LittleEndian.Uint32(b[32:36]),
+		clustersPerGroup:    binary.LittleEndian.Uint32(b[36:40]),
+		inodesPerGroup:      binary.LittleEndian.Uint32(b[40:44]),
+		mountTime:           superblockTime(b[44:48], b[629]),
+		writeTime:           superblockTime(b[48:52], b[628]),
+		mountCount:          binary.LittleEndian.Uint16(b[52:54]),
+		maxMountCount:       int16(binary.LittleEndian.Uint16(b[54:56])),
+		state:               binary.LittleEndian.Uint16(b[58:60]),
+		errors:              binary.LittleEndian.Uint16(b[60:62]),
+		minorRevision:       binary.LittleEndian.Uint16(b[62:64]),
+		lastCheck:           superblockTime(b[64:68], b[631]),
+		checkInterval:       binary.LittleEndian.Uint32(b[68:72]),
+		creatorOS:           binary.LittleEndian.Uint32(b[72:76]),
+		revision:            binary.LittleEndian.Uint32(b[76:80]),
+		reservedUID:         binary.LittleEndian.Uint16(b[80:82]),
+		reservedGID:         binary.LittleEndian.Uint16(b[82:84]),
+		// revision 0 filesystems have fixed values for these
+		firstInode:             11,
+		inodeSize:              goodOldInodeSize,
+		blockGroupNumber:       binary.LittleEndian.Uint16(b[90:92]),
+		volumeName:             strings.TrimRight(string(b[120:136]), "\x00"),
+		lastMounted:            strings.TrimRight(string(b[136:200]), "\x00"),
+		algorithmUsageBitmap:   binary.LittleEndian.Uint32(b[200:204]),
+		preallocBlocks:         b[204],
+		preallocDirBlocks:      b[205],
+		reservedGdtBlocks:      binary.LittleEndian.Uint16(b[206:208]),
+		journalInode:           binary.LittleEndian.Uint32(b[224:228]),
+		journalDevice:          binary.LittleEndian.Uint32(b[228:232]),
+		lastOrphan:             binary.LittleEndian.Uint32(b[232:236]),
+		defaultHashVersion:     b[252],
+		journalBackupType:      b[253],
+		descSize:               binary.LittleEndian.Uint16(b[254:256]),
+		defaultMountOptions:    binary.LittleEndian.Uint32(b[256:260]),
+		firstMetaBG:            binary.LittleEndian.Uint32(b[260:264]),
+		mkfsTime:               superblockTime(b[264:268], b[630]),
+		minExtraIsize:          binary.LittleEndian.Uint16(b[348:350]),
+		wantExtraIsize:         binary.LittleEndian.Uint16(b[350:352]),
+		flags:                  binary.LittleEndian.Uint32(b[352:356]),
+		raidStride:             binary.LittleEndian.Uint16(b[356:358]),
+		mmpInterval:            binary.LittleEndian.Uint16(b[358:360]),
+		mmpBlock:               binary.LittleEndian.Uint64(b[360:368]),
+		raidStripeWidth:        binary.LittleEndian.Uint32(b[368:372]),
+		logGroupsPerFlex:       b[372],
+		checksumType:           b[373],
+		encryptionLevel:        b[374],
+		kbytesWritten:          binary.LittleEndian.Uint64(b[376:384]),
+		snapshotInode:          binary.LittleEndian.Uint32(b[384:388]),
+		snapshotID:             binary.LittleEndian.Uint32(b[388:392]),
+		snapshotReservedBlocks: binary.LittleEndian.Uint64(b[392:400]),
+		snapshotList:           binary.LittleEndian.Uint32(b[400:404]),
+		errorCount:             binary.LittleEndian.Uint32(b[404:408]),
+		firstError: superblockError{
+			time:     superblockTime(b[408:412], b[632]),
+			inode:    binary.LittleEndian.Uint32(b[412:416]),
+			block:    binary.LittleEndian.Uint64(b[416:424]),
+			function: strings.TrimRight(string(b[424:456]), "\x00"),
+			line:     binary.LittleEndian.Uint32(b[456:460]),
+			code:     b[634],
+		},
+		lastError: superblockError{
+			time:     superblockTime(b[460:464], b[633]),
+			inode:    binary.LittleEndian.Uint32(b[464:468]),
+			line:     binary.LittleEndian.Uint32(b[468:472]),
+			block:    binary.LittleEndian.Uint64(b[472:480]),
+			function: strings.TrimRight(string(b[480:512]), "\x00"),
+			code:     b[635],
+		},
+		mountOptions:      strings.TrimRight(string(b[512:576]), "\x00"),
+		userQuotaInode:    binary.LittleEndian.Uint32(b[576:580]),
+		groupQuotaInode:   binary.LittleEndian.Uint32(b[580:584]),
+		overheadClusters:  binary.LittleEndian.Uint32(b[584:588]),
+		lostFoundInode:    binary.LittleEndian.Uint32(b[616:620]),
+		projectQuotaInode: binary.LittleEndian.Uint32(b[620:624]),
+		checksumSeed:      binary.LittleEndian.Uint32(b[624:628]),
+		encoding:          binary.LittleEndian.Uint16(b[636:638]),
+		encodingFlags:     binary.LittleEndian.Uint16(b[638:640]),
+		orphanFileInode:   binary.LittleEndian.Uint32(b[640:644]),
+		checksum:          binary.LittleEndian.Uint32(b[1020:1024]),
+	}
+	if sb.revision > 0 {
+		sb.firstInode = binary.LittleEndian.Uint32(b[84:88])
+		sb.inodeSize = binary.LittleEndian.Uint16(b[88:90])
+		sb.featureCompat = binary.LittleEndian.Uint32(b[92:96])
+		sb.featureIncompat = binary.LittleEndian.Uint32(b[96:100])
+		sb.featureROCompat = binary.LittleEndian.Uint32(b[100:104])
+	}
+	copy(sb.uuid[:], b[104:120])
+	copy(sb.journalUUID[:], b[208:224])
+	copy(sb.encryptAlgorithms[:], b[596:600])
+	copy(sb.encryptPasswordSalt[:], b[600:616])
+	for i := range sb.hashSeed {
+		sb.hashSeed[i] = binary.LittleEndian.Uint32(b[236+4*i:])
+	}
+	for i := range sb.journalBlocks {
+		sb.journalBlocks[i] = binary.LittleEndian.Uint32(b[268+4*i:])
+	}
+	for i := range sb.backupBGs {
+		sb.backupBGs[i] = binary.LittleEndian.Uint32(b[588+4*i:])
+	}
+	if sb.is64Bit() {
+		sb.blocksCount |= uint64(binary.LittleEndian.Uint32(b[336:340])) << 32
+		sb.reservedBlocksCount |= uint64(binary.LittleEndian.Uint32(b[340:344])) << 32
+		sb.freeBlocksCount |= uint64(binary.LittleEndian.Uint32(b[344:348])) << 32
+	}
+
+	switch {
+	case sb.featureIncompat&^featureIncompatRead != 0:
+		return nil, fmt.Errorf("unsupported incompatible features %#x", sb.featureIncompat&^featureIncompatRead)
+	case sb.logBlockSize > 6:
+		return nil, fmt.Errorf("invalid block size 2^%d KB, maximum is 64KB", sb.logBlockSize)
+	case sb.blocksPerGroup == 0 || sb.inodesPerGroup == 0:
+		return nil, fmt.Errorf("invalid %d blocks and %d inodes per group", sb.blocksPerGroup, sb.inodesPerGroup)
+	case sb.inodeSize < 128 || int(sb.inodeSize) > sb.blockSize() || sb.inodeSize&(sb.inodeSize-1) != 0:
+		return nil, fmt.Errorf("invalid inode size %d", sb.inodeSize)
+	case sb.is64Bit() && (sb.descSize < 64 || sb.descSize&(sb.descSize-1) != 0):
+		return nil, fmt.Errorf("invalid group descriptor size %d for 64-bit filesystem", sb.descSize)
+	}
+	if sb.hasMetadataCsum() {
+		if sb.checksumType != checksumTypeCrc32c {
+			return nil, fmt.Errorf("unknown checksum type %d", sb.checksumType)
+		}
+		if checksum := crc32c(^uint32(0), b[:1020]); checksum != sb.checksum {
+			return nil, fmt.Errorf("superblock checksum %#08x does not match expected %#08x", checksum, sb.checksum)
+		}
+	}
+	return &sb, nil
+}
+
+// toBytes returns the 1024 bytes of the superblock, with its checksum calculated afresh if the filesystem has
+// metadata_csum
+func (sb *superblock) toBytes() []byte {
+	b := make([]byte, superblockSize)
+	binary.LittleEndian.PutUint32(b[0:4], sb.inodesCount)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(sb.blocksCount))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(sb.reservedBlocksCount))
+	binary.LittleEndian.PutUint32(b[12:16], uint32(sb.freeBlocksCount))
+	binary.LittleEndian.PutUint32(b[16:20], sb.freeInodesCount)
+	binary.LittleEndian.PutUint32(b[20:24], sb.firstDataBlock)
+	binary.LittleEndian.PutUint32(b[24:28], sb.logBlockSize)
+	binary.LittleEndian.PutUint32(b[28:32], sb.logClusterSize)
+	binary.LittleEndian.PutUint32(b[32:36], sb.blocksPerGroup)
+	binary.LittleEndian.PutUint32(b[36:40], sb.clustersPerGroup)
+	binary.LittleEndian.PutUint32(b[40:44], sb.inodesPerGroup)
+	putSuperblockTime(b[44:48], &b[629], sb.mountTime)
+	putSuperblockTime(b[48:52], &b[628], sb.writeTime)
+	binary.LittleEndian.PutUint16(b[52:54], sb.mountCount)
+	binary.LittleEndian.PutUint16(b[54:56], uint16(sb.maxMountCount))
+	binary.LittleEndian.PutUint16(b[56:58], superblockMagic)
+	binary.LittleEndian.PutUint16(b[58:60], sb.state)
+	binary.LittleEndian.PutUint16(b[60:62], sb.errors)
+	binary.LittleEndian.PutUint16(b[62:64], sb.minorRevision)
+	putSuperblockTime(b[64:68], &b[631], sb.lastCheck)
+	binary.LittleEndian.PutUint32(b[68:72], sb.checkInterval)
+	binary.LittleEndian.PutUint32(b[72:76], sb.creatorOS)
+	binary.LittleEndian.PutUint32(b[76:80], sb.revision)
+	binary.LittleEndian.PutUint16(b[80:82], sb.reservedUID)
+	binary.LittleEndian.PutUint16(b[82:84], sb.reservedGID)
+	// revision 0 filesystems have no features, and fixed values for the others, which mke2fs writes anyway
+	binary.LittleEndian.PutUint32(b[84:88], sb.firstInode)
+	binary.LittleEndian.PutUint16(b[88:90], sb.inodeSize)
+	binary.LittleEndian.PutUint16(b[90:92], sb.blockGroupNumber)
+	binary.LittleEndian.PutUint32(b[92:96], sb.featureCompat)
+	binary.LittleEndian.PutUint32(b[96:100], sb.featureIncompat)
+	binary.LittleEndian.PutUint32(b[100:104], sb.featureROCompat)
+	copy(b[104:120], sb.uuid[:])
+	copy(b[120:136], sb.volumeName)
+	copy(b[136:200], sb.lastMounted)
+	binary.LittleEndian.PutUint32(b[200:204], sb.algorithmUsageBitmap)
+	b[204] = sb.preallocBlocks
+	b[205] = sb.preallocDirBlocks
+	binary.LittleEndian.PutUint16(b[206:208], sb.reservedGdtBlocks)
+	copy(b[208:224], sb.journalUUID[:])
+	binary.LittleEndian.PutUint32(b[224:228], sb.journalInode)
+	binary.LittleEndian.PutUint32(b[228:232], sb.journalDevice)
+	binary.LittleEndian.PutUint32(b[232:236], sb.lastOrphan)
+	for i, seed := range sb.hashSeed {
+		binary.LittleEndian.PutUint32(b[236+4*i:], seed)
+	}
+	b[252] = sb.defaultHashVersion
+	b[253] = sb.journalBackupType
+	binary.LittleEndian.PutUint16(b[254:256], sb.descSize)
+	binary.LittleEndian.PutUint32(b[256:260], sb.defaultMountOptions)
+	binary.LittleEndian.PutUint32(b[260:264], sb.firstMetaBG)
+	putSuperblockTime(b[264:268], &b[630], sb.mkfsTime)
+	for i, block := range sb.journalBlocks {
+		binary.LittleEndian.PutUint32(b[268+4*i:], block)
+	}
+	if sb.is64Bit() {
+		binary.LittleEndian.PutUint32(b[336:340], uint32(sb.blocksCount>>32))
+		binary.LittleEndian.PutUint32(b[340:344], uint32(sb.reservedBlocksCount>>32))
+		binary.LittleEndian.PutUint32(b[344:348], uint32(sb.freeBlocksCount>>32))
+	}
+	binary.LittleEndian.PutUint16(b[348:350], sb.minExtraIsize)
+	binary.LittleEndian.PutUint16(b[350:352], sb.wantExtraIsize)
+	binary.LittleEndian.PutUint32(b[352:356], sb.flags)
+	binary.LittleEndian.PutUint16(b[356:358], sb.raidStride)
+	binary.LittleEndian.PutUint16(b[358:360], sb.mmpInterval)
+	binary.LittleEndian.PutUint64(b[360:368], sb.mmpBlock)
+	binary.LittleEndian.PutUint32(b[368:372], sb.raidStripeWidth)
+	b[372] = sb.logGroupsPerFlex
+	b[373] = sb.checksumType
+	b[374] = sb.encryptionLevel
+	binary.LittleEndian.PutUint64(b[376:384], sb.kbytesWritten)
+	binary.LittleEndian.PutUint32(b[384:388], sb.snapshotInode)
+	binary.LittleEndian.PutUint32(b[388:392], sb.snapshotID)
+	binary.LittleEndian.PutUint64(b[392:400], sb.snapshotReservedBlocks)
+	binary.LittleEndian.PutUint32(b[400:404], sb.snapshotList)
+	binary.LittleEndian.PutUint32(b[404:408], sb.errorCount)
+	putSuperblockTime(b[408:412], &b[632], sb.firstError.time)
+	binary.LittleEndian.PutUint32(b[412:416], sb.firstError.inode)
+	binary.LittleEndian.PutUint64(b[416:424], sb.firstError.block)
+	copy(b[424:456], sb.firstError.function)
+	binary.LittleEndian.PutUint32(b[456:460], sb.firstError.line)
+	b[634] = sb.firstError.code
+	putSuperblockTime(b[460:464], &b[633], sb.lastError.time)
+	binary.LittleEndian.PutUint32(b[464:468], sb.lastError.inode)
+	binary.LittleEndian.PutUint32(b[468:472], sb.lastError.line)
+	binary.LittleEndian.PutUint64(b[472:480], sb.lastError.block)
+	copy(b[480:512], sb.lastError.function)
+	b[635] = sb.lastError.code
+	copy(b[512:576], sb.mountOptions)
+	binary.LittleEndian.PutUint32(b[576:580], sb.userQuotaInode)
+	binary.LittleEndian.PutUint32(b[580:584], sb.groupQuotaInode)
+	binary.LittleEndian.PutUint32(b[584:588], sb.overheadClusters)
+	for i, group := range sb.backupBGs {
+		binary.LittleEndian.PutUint32(b[588+4*i:], group)
+	}
+	copy(b[596:600], sb.encryptAlgorithms[:])
+	copy(b[600:616], sb.encryptPasswordSalt[:])
+	binary.LittleEndian.PutUint32(b[616:620], sb.lostFoundInode)
+	binary.LittleEndian.PutUint32(b[620:624], sb.projectQuotaInode)
+	binary.LittleEndian.PutUint32(b[624:628], sb.checksumSeed)
+	binary.LittleEndian.PutUint16(b[636:638], sb.encoding)
+	binary.LittleEndian.PutUint16(b[638:640], sb.encodingFlags)
+	binary.LittleEndian.PutUint32(b[640:644], sb.orphanFileInode)
+	if sb.hasMetadataCsum() {
+		sb.checksum = crc32c(^uint32(0), b[:1020])
+	}
+	binary.LittleEndian.PutUint32(b[1020:1024], sb.checksum)
+	return b
+}
+
+// superblockTime reads a time stored as 32 bits of seconds, with 8 more high bits elsewhere
+func superblockTime(lo []byte, hi uint8) time.Time {
+	return time.Unix(int64(binary.LittleEndian.Uint32(lo))|int64(hi)<<32, 0)
+}
+
+// putSuperblockTime writes a time as 32 bits of seconds, with 8 more high bits in hi. The zero time is
+// written as 0, for never.
+func putSuperblockTime(lo []byte, hi *uint8, t time.Time) {
+	var seconds int64
+	if !t.IsZero() {
+		seconds = t.Unix()
+	}
+	binary.LittleEndian.PutUint32(lo, uint32(seconds))
+	*hi = uint8(seconds >> 32)
+}
+
+func (sb *superblock) blockSize() int {
+	return 1024 << sb.logBlockSize
+}
+
+func (sb *superblock) is64Bit() bool {
+	return sb.featureIncompat&featureIncompat64Bit != 0
+}
+
+func (sb *superblock) hasMetadataCsum() bool {
+	return sb.featureROCompat&featureROCompatMetadataCsum != 0
+}
+
+// groupDescriptorSize is the size of each group descriptor in bytes
+func (sb *superblock) groupDescriptorSize() int {
+	if sb.is64Bit() {
+		return int(sb.descSize)
+	}
+	return 32
+}
+
+// blockGroups is the number of block groups
+func (sb *superblock) blockGroups() uint32 {
+	return uint32((sb.blocksCount - uint64(sb.firstDataBlock) + uint64(sb.blocksPerGroup) - 1) / uint64(sb.blocksPerGroup))
+}
+
+// metadataChecksumSeed is the seed for all metadata checksums, from the uuid unless it is stored separately
+// so that the uuid can change
+func (sb *superblock) metadataChecksumSeed() uint32 {
+	if sb.featureIncompat&featureIncompatCsumSeed != 0 {
+		return sb.checksumSeed
+	}
+	return crc32c(^uint32(0), sb.uuid[:])
+}
+
+// hasSuperblockBackup reports whether a block group has a copy of the superblock and group descriptors
+func (sb *superblock) hasSuperblockBackup(group uint32) bool {
+	switch {
+	case group == 0:
+		return true
+	case sb.featureCompat&featureCompatSparseSuper2 != 0:
+		return group == sb.backupBGs[0] || group == sb.backupBGs[1]
+	case sb.featureROCompat&featureROCompatSparseSuper == 0 || group == 1:
+		return true
+	}
+	return isPowerOf(group, 3) || isPowerOf(group, 5) || isPowerOf(group, 7)
+}
+
+func isPowerOf(n, base uint32) bool {
+	for n > 1 && n%base == 0 {
+		n /= base
+	}
+	return n == 1
+}
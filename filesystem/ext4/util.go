@@ -0,0 +1,33 @@
+package ext4
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+const (
+	// KB represents one KB
+	KB int64 = 1024
+	// MB represents one MB
+	MB int64 = 1024 * KB
+	// GB represents one GB
+	GB int64 = 1024 * MB
+	// TB represents one TB
+	TB int64 = 1024 * GB
+)
+
+// splitPath splits an absolute path into its parts, with any . and .. already resolved
+func splitPath(p string) ([]string, error) {
+	ps := strings.ReplaceAll(p, "\\", "/")
+	if ps == "" || ps[0] != '/' {
+		return nil, errors.New("must use absolute paths")
+	}
+	ret := make([]string, 0)
+	for _, sub := range strings.Split(path.Clean(ps), "/") {
+		if sub != "" {
+			ret = append(ret, sub)
+		}
+	}
+	return ret, nil
+}
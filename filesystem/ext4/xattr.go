@@ -0,0 +1,439 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// xattrMagic starts the extended attributes in an inode, and the header of an extended attribute block
+	xattrMagic uint32 = 0xea020000
+	// xattrBlockHeaderSize is the size of the header of an extended attribute block
+	xattrBlockHeaderSize = 32
+	// xattrEntryHeaderSize is the size of an extended attribute entry before its name
+	xattrEntryHeaderSize = 16
+	// xattrInlineDataName is the extended attribute that holds whatever inline data does not fit in i_block
+	xattrInlineDataName = "system.data"
+)
+
+// name indexes of extended attributes, each standing for a prefix of the names
+const (
+	xattrIndexUser            uint8 = 1
+	xattrIndexPosixACLAccess  uint8 = 2
+	xattrIndexPosixACLDefault uint8 = 3
+	xattrIndexTrusted         uint8 = 4
+	xattrIndexSecurity        uint8 = 6
+	xattrIndexSystem          uint8 = 7
+	xattrIndexRichACL         uint8 = 8
+)
+
+// ACLs, which ext4 stores more compactly than they are in the extended attributes seen by users
+const (
+	ext4ACLVersion          uint32 = 1
+	ext4ACLHeaderSize              = 4
+	ext4ACLEntrySize               = 8
+	ext4ACLShortEntrySize          = 4
+	posixACLXattrVersion    uint32 = 2
+	posixACLXattrHeaderSize        = 4
+	posixACLXattrEntrySize         = 8
+	posixACLUndefinedID     uint32 = 0xffffffff
+
+	aclUserObj  uint16 = 0x1
+	aclUser     uint16 = 0x2
+	aclGroupObj uint16 = 0x4
+	aclGroup    uint16 = 0x8
+	aclMask     uint16 = 0x10
+	aclOther    uint16 = 0x20
+)
+
+// xattrPrefixes are the prefixes that name indexes stand for
+var xattrPrefixes = map[uint8]string{
+	xattrIndexUser:            "user.",
+	xattrIndexPosixACLAccess:  "system.posix_acl_access",
+	xattrIndexPosixACLDefault: "system.posix_acl_default",
+	xattrIndexTrusted:         "trusted.",
+	xattrIndexSecurity:        "security.",
+	xattrIndexSystem:          "system.",
+	xattrIndexRichACL:         "system.richacl",
+}
+
+// xattrsFromBytes reads the extended attribute entries in b, stopping at the 4 zero bytes that end them. The
+// values are at valueBase plus their offset. Values stored in their own inodes are read with readInode.
+func (fs *FileSystem) xattrsFromBytes(b []byte, entries, valueBase int, xattrs map[string][]byte) error {
+	for i := entries; i+4 <= len(b) && binary.LittleEndian.Uint32(b[i:i+4]) != 0; {
+		if i+xattrEntryHeaderSize > len(b) {
+			return fmt.Errorf("extended attribute entry at %d runs past the end", i)
+		}
+		nameLen := int(b[i])
+		index := b[i+1]
+		valueOffset := int(binary.LittleEndian.Uint16(b[i+2 : i+4]))
+		valueInode := binary.LittleEndian.Uint32(b[i+4 : i+8])
+		valueSize := int(binary.LittleEndian.Uint32(b[i+8 : i+12]))
+		if i+xattrEntryHeaderSize+nameLen > len(b) {
+			return fmt.Errorf("extended attribute name at %d runs past the end", i)
+		}
+		prefix, ok := xattrPrefixes[index]
+		if !ok {
+			return fmt.Errorf("unknown extended attribute name index %d", index)
+		}
+		name := prefix + string(b[i+xattrEntryHeaderSize:i+xattrEntryHeaderSize+nameLen])
+
+		var value []byte
+		if valueInode != 0 {
+			if fs.superblock.featureIncompat&featureIncompatEAInode == 0 {
+				return fmt.Errorf("extended attribute %s is in inode %d without the ea_inode feature", name, valueInode)
+			}
+			v, err := fs.readEAInode(valueInode, valueSize)
+			if err != nil {
+				return fmt.Errorf("could not read extended attribute %s: %v", name, err)
+			}
+			value = v
+		} else {
+			start := valueBase + valueOffset
+			if start+valueSize > len(b) || start < 0 {
+				return fmt.Errorf("extended attribute %s value runs past the end", name)
+			}
+			value = make([]byte, valueSize)
+			copy(value, b[start:start+valueSize])
+		}
+		switch index {
+		case xattrIndexPosixACLAccess, xattrIndexPosixACLDefault:
+			v, err := posixACLFromExt4(value)
+			if err != nil {
+				return fmt.Errorf("invalid acl in %s: %v", name, err)
+			}
+			value = v
+		}
+		xattrs[name] = value
+		i += (xattrEntryHeaderSize + nameLen + 3) &^ 3
+	}
+	return nil
+}
+
+// readEAInode reads the value of an extended attribute stored as the contents of its own inode
+func (fs *FileSystem) readEAInode(number uint32, size int) ([]byte, error) {
+	in, err := fs.readInode(number)
+	if err != nil {
+		return nil, err
+	}
+	if in.flags&inodeFlagEAInode == 0 {
+		return nil, fmt.Errorf("inode %d does not hold an extended attribute", number)
+	}
+	if uint64(size) != in.size {
+		return nil, fmt.Errorf("extended attribute inode %d has size %d, expected %d", number, in.size, size)
+	}
+	return fs.readFileContents(in)
+}
+
+// readXattrs reads all of the extended attributes of an inode, those in the inode itself and those in its
+// extended attribute block
+func (fs *FileSystem) readXattrs(in *inode) (map[string][]byte, error) {
+	xattrs := map[string][]byte{}
+	if b := in.inlineXattrs; len(b) >= 4 && binary.LittleEndian.Uint32(b[0:4]) == xattrMagic {
+		if err := fs.xattrsFromBytes(b, 4, 4, xattrs); err != nil {
+			return nil, fmt.Errorf("could not read extended attributes in inode %d: %v", in.number, err)
+		}
+	}
+	if in.fileACL != 0 {
+		b, err := fs.readBlock(in.fileACL)
+		if err != nil {
+			return nil, fmt.Errorf("could not read extended attribute block %d: %v", in.fileACL, err)
+		}
+		if magic := binary.LittleEndian.Uint32(b[0:4]); magic != xattrMagic {
+			return nil, fmt.Errorf("extended attribute block %d has invalid magic %#08x", in.fileACL, magic)
+		}
+		if fs.superblock.hasMetadataCsum() {
+			checksum := binary.LittleEndian.Uint32(b[16:20])
+			if expected := xattrBlockChecksum(b, in.fileACL, fs.superblock); expected != checksum {
+				return nil, fmt.Errorf("extended attribute block %d checksum %#08x does not match expected %#08x", in.fileACL, checksum, expected)
+			}
+		}
+		if err := fs.xattrsFromBytes(b, xattrBlockHeaderSize, 0, xattrs); err != nil {
+			return nil, fmt.Errorf("could not read extended attribute block %d: %v", in.fileACL, err)
+		}
+	}
+	return xattrs, nil
+}
+
+// xattrBlockChecksum calculates the metadata_csum checksum of an extended attribute block, which depends on
+// where it is rather than on the inode, as blocks can be shared
+func xattrBlockChecksum(b []byte, block uint64, sb *superblock) uint32 {
+	le := make([]byte, 8)
+	binary.LittleEndian.PutUint64(le, block)
+	crc := crc32c(sb.metadataChecksumSeed(), le)
+	crc = crc32c(crc, b[:16])
+	crc = crc32c(crc, []byte{0, 0, 0, 0})
+	return crc32c(crc, b[20:])
+}
+
+// posixACLFromExt4 converts an ACL as ext4 stores it, with the ids left out of entries that do not need one, to
+// the format of the system.posix_acl_access and system.posix_acl_default extended attributes
+func posixACLFromExt4(b []byte) ([]byte, error) {
+	if len(b) < ext4ACLHeaderSize {
+		return nil, fmt.Errorf("acl of %d bytes is too short", len(b))
+	}
+	if version := binary.LittleEndian.Uint32(b[0:4]); version != ext4ACLVersion {
+		return nil, fmt.Errorf("unknown acl version %d", version)
+	}
+	out := make([]byte, posixACLXattrHeaderSize, len(b)*2)
+	binary.LittleEndian.PutUint32(out[0:4], posixACLXattrVersion)
+	for i := ext4ACLHeaderSize; i < len(b); {
+		if i+ext4ACLShortEntrySize > len(b) {
+			return nil, fmt.Errorf("acl entry at %d runs past the end", i)
+		}
+		tag := binary.LittleEndian.Uint16(b[i : i+2])
+		entry := make([]byte, posixACLXattrEntrySize)
+		copy(entry[0:4], b[i:i+4])
+		switch tag {
+		case aclUser, aclGroup:
+			if i+ext4ACLEntrySize > len(b) {
+				return nil, fmt.Errorf("acl entry at %d runs past the end", i)
+			}
+			copy(entry[4:8], b[i+4:i+8])
+			i += ext4ACLEntrySize
+		case aclUserObj, aclGroupObj, aclMask, aclOther:
+			binary.LittleEndian.PutUint32(entry[4:8], posixACLUndefinedID)
+			i += ext4ACLShortEntrySize
+		default:
+			return nil, fmt.Errorf("unknown acl tag %#x", tag)
+		}
+		out = append(out, entry...)
+	}
+	return out, nil
+}
+
+// xattrEntry is an extended attribute as it is stored, with its name index and the rest of its name
+type xattrEntry struct {
+	index uint8
+	name  string
+	value []byte
+}
+
+// size is the space the entry takes before the values, with its name padded to 4 bytes
+func (e *xattrEntry) size() int {
+	return (xattrEntryHeaderSize + len(e.name) + 3) &^ 3
+}
+
+// valueSize is the space the value takes, padded to 4 bytes
+func (e *xattrEntry) valueSize() int {
+	return (len(e.value) + 3) &^ 3
+}
+
+// hash is the hash of an entry in an extended attribute block, from its name and value
+func (e *xattrEntry) hash() uint32 {
+	var hash uint32
+	for _, c := range []byte(e.name) {
+		// names are hashed as signed chars, as they are on x86
+		hash = (hash << 5) ^ (hash >> 27) ^ uint32(int32(int8(c)))
+	}
+	value := make([]byte, e.valueSize())
+	copy(value, e.value)
+	for i := 0; i < len(value); i += 4 {
+		hash = (hash << 16) ^ (hash >> 16) ^ binary.LittleEndian.Uint32(value[i:i+4])
+	}
+	return hash
+}
+
+// put writes the entry at the start of b, with its value at valueOffset
+func (e *xattrEntry) put(b []byte, valueOffset int, hash uint32) {
+	b[0] = uint8(len(e.name))
+	b[1] = e.index
+	binary.LittleEndian.PutUint16(b[2:4], uint16(valueOffset))
+	binary.LittleEndian.PutUint32(b[4:8], 0)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(e.value)))
+	binary.LittleEndian.PutUint32(b[12:16], hash)
+	copy(b[xattrEntryHeaderSize:], e.name)
+}
+
+// xattrEntryFromName finds the name index with the longest prefix of name, and returns the entry for it
+func xattrEntryFromName(name string, value []byte) (*xattrEntry, error) {
+	var (
+		index  uint8
+		prefix string
+	)
+	for i, p := range xattrPrefixes {
+		if strings.HasPrefix(name, p) && len(p) > len(prefix) {
+			index, prefix = i, p
+		}
+	}
+	switch {
+	case prefix == "":
+		return nil, fmt.Errorf("unsupported extended attribute name %s", name)
+	case len(name)-len(prefix) > maxNameLength:
+		return nil, fmt.Errorf("extended attribute name %s is too long", name)
+	case (index == xattrIndexPosixACLAccess || index == xattrIndexPosixACLDefault || index == xattrIndexRichACL) && name != prefix:
+		return nil, fmt.Errorf("unsupported extended attribute name %s", name)
+	}
+	if index == xattrIndexPosixACLAccess || index == xattrIndexPosixACLDefault {
+		v, err := posixACLToExt4(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl in %s: %v", name, err)
+		}
+		value = v
+	}
+	return &xattrEntry{index: index, name: name[len(prefix):], value: value}, nil
+}
+
+// writeXattrs replaces the extended attributes of an inode with xattrs, and writes the inode. They go in the
+// inode for as long as they fit, in the order the kernel keeps them in, and the rest in an extended attribute
+// block. A block shared with other inodes is left to them, and the inode gets a block of its own.
+func (fs *FileSystem) writeXattrs(in *inode, xattrs map[string][]byte) error {
+	if fs.superblock.featureCompat&featureCompatExtAttr == 0 {
+		return fmt.Errorf("filesystem does not have the ext_attr feature for extended attributes")
+	}
+	entries := make([]*xattrEntry, 0, len(xattrs))
+	for name, value := range xattrs {
+		e, err := xattrEntryFromName(name, value)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		if len(a.name) != len(b.name) {
+			return len(a.name) < len(b.name)
+		}
+		return a.name < b.name
+	})
+
+	// the space after the extra fields of the inode, with 4 bytes of magic at the start and 4 zero bytes to
+	// end the entries
+	var inInode, inBlock []*xattrEntry
+	inodeSpace := int(fs.superblock.inodeSize) - goodOldInodeSize - int(in.extraIsize) - 8
+	blockSpace := int(fs.blockSize) - xattrBlockHeaderSize - 4
+	for _, e := range entries {
+		if need := e.size() + e.valueSize(); need <= inodeSpace {
+			inInode = append(inInode, e)
+			inodeSpace -= need
+			continue
+		}
+		inBlock = append(inBlock, e)
+		blockSpace -= e.size() + e.valueSize()
+	}
+	if blockSpace < 0 {
+		return fmt.Errorf("extended attributes do not fit in inode %d and one block", in.number)
+	}
+
+	if len(in.inlineXattrs) > 0 {
+		b := make([]byte, len(in.inlineXattrs))
+		if len(inInode) > 0 {
+			binary.LittleEndian.PutUint32(b[0:4], xattrMagic)
+			// value offsets in the inode are from the first entry
+			entryOffset, valueEnd := 4, len(b)
+			for _, e := range inInode {
+				valueOffset := 0
+				if len(e.value) > 0 {
+					valueEnd -= e.valueSize()
+					copy(b[valueEnd:], e.value)
+					valueOffset = valueEnd - 4
+				}
+				e.put(b[entryOffset:], valueOffset, 0)
+				entryOffset += e.size()
+			}
+		}
+		in.inlineXattrs = b
+	}
+
+	// a block with more than one reference is left to the other inodes that have it
+	var block uint64
+	if in.fileACL != 0 {
+		old, err := fs.readBlock(in.fileACL)
+		if err != nil {
+			return fmt.Errorf("could not read extended attribute block %d: %v", in.fileACL, err)
+		}
+		refcount := binary.LittleEndian.Uint32(old[4:8])
+		switch {
+		case refcount > 1:
+			binary.LittleEndian.PutUint32(old[4:8], refcount-1)
+			if err := fs.writeXattrBlock(in.fileACL, old); err != nil {
+				return err
+			}
+			fs.addInodeBlocks(in, -1)
+		case len(inBlock) == 0:
+			if err := fs.freeBlocks([]extent{{startBlock: in.fileACL, count: 1}}); err != nil {
+				return err
+			}
+			fs.addInodeBlocks(in, -1)
+		default:
+			block = in.fileACL
+		}
+		in.fileACL = 0
+	}
+	if len(inBlock) > 0 {
+		if block == 0 {
+			runs, err := fs.allocateBlocks(fs.inodeGoal(in), 1)
+			if err != nil {
+				return fmt.Errorf("could not allocate extended attribute block: %v", err)
+			}
+			block = runs[0].startBlock
+			fs.addInodeBlocks(in, 1)
+		}
+		b := make([]byte, fs.blockSize)
+		binary.LittleEndian.PutUint32(b[0:4], xattrMagic)
+		binary.LittleEndian.PutUint32(b[4:8], 1)
+		binary.LittleEndian.PutUint32(b[8:12], 1)
+		var blockHash uint32
+		entryOffset, valueEnd := xattrBlockHeaderSize, len(b)
+		for _, e := range inBlock {
+			valueOffset := 0
+			if len(e.value) > 0 {
+				valueEnd -= e.valueSize()
+				copy(b[valueEnd:], e.value)
+				valueOffset = valueEnd
+			}
+			hash := e.hash()
+			e.put(b[entryOffset:], valueOffset, hash)
+			entryOffset += e.size()
+			blockHash = (blockHash << 16) ^ (blockHash >> 16) ^ hash
+		}
+		binary.LittleEndian.PutUint32(b[12:16], blockHash)
+		if err := fs.writeXattrBlock(block, b); err != nil {
+			return err
+		}
+		in.fileACL = block
+	}
+	in.changeTime = time.Now()
+	return fs.writeInode(in)
+}
+
+// writeXattrBlock writes an extended attribute block, with its checksum
+func (fs *FileSystem) writeXattrBlock(block uint64, b []byte) error {
+	if fs.superblock.hasMetadataCsum() {
+		binary.LittleEndian.PutUint32(b[16:20], xattrBlockChecksum(b, block, fs.superblock))
+	}
+	if _, err := fs.file.WriteAt(b, fs.start+int64(block)*fs.blockSize); err != nil {
+		return fmt.Errorf("could not write extended attribute block %d: %v", block, err)
+	}
+	return nil
+}
+
+// posixACLToExt4 converts an ACL in the format of the system.posix_acl_access and system.posix_acl_default
+// extended attributes to the more compact one ext4 stores, the reverse of posixACLFromExt4
+func posixACLToExt4(b []byte) ([]byte, error) {
+	if len(b) < posixACLXattrHeaderSize || (len(b)-posixACLXattrHeaderSize)%posixACLXattrEntrySize != 0 {
+		return nil, fmt.Errorf("acl of %d bytes has an invalid size", len(b))
+	}
+	if version := binary.LittleEndian.Uint32(b[0:4]); version != posixACLXattrVersion {
+		return nil, fmt.Errorf("unknown acl version %d", version)
+	}
+	out := make([]byte, ext4ACLHeaderSize, len(b))
+	binary.LittleEndian.PutUint32(out[0:4], ext4ACLVersion)
+	for i := posixACLXattrHeaderSize; i < len(b); i += posixACLXattrEntrySize {
+		switch tag := binary.LittleEndian.Uint16(b[i : i+2]); tag {
+		case aclUser, aclGroup:
+			out = append(out, b[i:i+ext4ACLEntrySize]...)
+		case aclUserObj, aclGroupObj, aclMask, aclOther:
+			out = append(out, b[i:i+ext4ACLShortEntrySize]...)
+		default:
+			return nil, fmt.Errorf("unknown acl tag %#x", tag)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package fat32
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// shortDos40EBPB indicates that a DOS 4.0 EBPB is of the short 32-byte format, without label or type
+	shortDos40EBPB uint8 = 0x28
+	// longDos40EBPB indicates that a DOS 4.0 EBPB is of the long 51-byte format
+	longDos40EBPB uint8 = 0x29
+)
+
+const (
+	// fileSystemTypeFAT12 is the fixed string representation for the FAT12 filesystem type
+	fileSystemTypeFAT12 string = "FAT12   "
+	// fileSystemTypeFAT16 is the fixed string representation for the FAT16 filesystem type
+	fileSystemTypeFAT16 string = "FAT16   "
+)
+
+// dos40EBPB is the DOS 4.0 Extended BIOS Parameter Block, used by FAT12 and FAT16. It is the same as the
+// end of the DOS 7.1 EBPB, without the FAT32 fields before it.
+type dos40EBPB struct {
+	dos331BPB             *dos331BPB // Dos331BPB holds the embedded DOS 3.31 BIOS Parameter BLock
+	driveNumber           uint8      // DriveNumber is the code for the relative position and type of this drive in the system
+	reservedFlags         uint8      // ReservedFlags are flags used by the operating system and/or BIOS for various purposes
+	extendedBootSignature uint8      // ExtendedBootSignature contains the flag as to whether this is a short (32-byte) or long (51-byte) DOS 4.0 EBPB
+	volumeSerialNumber    uint32     // VolumeSerialNumber usually generated by some form of date and time
+	volumeLabel           string     // VolumeLabel, an arbitrary 11-byte string
+	fileSystemType        string     // FileSystemType is the 8-byte string holding the name of the file system type
+}
+
+func (bpb *dos40EBPB) equal(a *dos40EBPB) bool {
+	if (bpb == nil && a != nil) || (a == nil && bpb != nil) {
+		return false
+	}
+	if bpb == nil && a == nil {
+		return true
+	}
+	return bpb.dos331BPB.equal(a.dos331BPB) &&
+		bpb.driveNumber == a.driveNumber &&
+		bpb.reservedFlags == a.reservedFlags &&
+		bpb.extendedBootSignature == a.extendedBootSignature &&
+		bpb.volumeSerialNumber == a.volumeSerialNumber &&
+		bpb.volumeLabel == a.volumeLabel &&
+		bpb.fileSystemType == a.fileSystemType
+}
+
+// dos40EBPBFromBytes reads the FAT12/FAT16 Extended BIOS Parameter Block from a slice of bytes
+// these bytes are assumed to start at the beginning of the BPB, and be long enough for the long format.
+// Returns the size of the EBPB actually used.
+func dos40EBPBFromBytes(b []byte) (*dos40EBPB, int, error) {
+	if b == nil || len(b) != 51 {
+		return nil, 0, errors.New("cannot read DOS 4.0 EBPB from invalid byte slice, must be precisely 51 bytes ")
+	}
+	bpb := dos40EBPB{}
+	size := 0
+
+	// extract the embedded DOS 3.31 BPB
+	dos331bpb, err := dos331BPBFromBytes(b[0:25])
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not read embedded DOS 3.31 BPB: %v", err)
+	}
+	bpb.dos331BPB = dos331bpb
+
+	bpb.driveNumber = b[25]
+	bpb.reservedFlags = b[26]
+	extendedSignature := b[27]
+	bpb.extendedBootSignature = extendedSignature
+	bpb.volumeSerialNumber = binary.LittleEndian.Uint32(b[28:32])
+
+	switch extendedSignature {
+	case shortDos40EBPB:
+		size = 32
+	case longDos40EBPB:
+		size = 51
+		// remove padding from each
+		re := regexp.MustCompile(" +$")
+		bpb.volumeLabel = re.ReplaceAllString(string(b[32:43]), "")
+		bpb.fileSystemType = re.ReplaceAllString(string(b[43:51]), "")
+	default:
+		return nil, size, fmt.Errorf("unknown DOS 4.0 EBPB Signature: %v", extendedSignature)
+	}
+
+	return &bpb, size, nil
+}
+
+// toBytes returns the Extended BIOS Parameter Block in a slice of bytes directly ready to
+// write to disk
+func (bpb *dos40EBPB) toBytes() ([]byte, error) {
+	var b []byte
+	switch bpb.extendedBootSignature {
+	case shortDos40EBPB:
+		b = make([]byte, 32)
+	case longDos40EBPB:
+		b = make([]byte, 51)
+		label := bpb.volumeLabel
+		if len(label) > 11 {
+			return nil, fmt.Errorf("invalid volume label: too long at %d characters, maximum is %d", len(label), 11)
+		}
+		if len(label) != len([]rune(label)) {
+			return nil, fmt.Errorf("invalid volume label: non-ascii characters")
+		}
+		// pad with 0x20 = " "
+		copy(b[32:43], fmt.Sprintf("%-11s", label))
+		fstype := bpb.fileSystemType
+		if len(fstype) > 8 {
+			return nil, fmt.Errorf("invalid filesystem type: too long at %d characters, maximum is %d", len(fstype), 8)
+		}
+		if len(fstype) != len([]rune(fstype)) {
+			return nil, fmt.Errorf("invalid filesystem type: non-ascii characters")
+		}
+		copy(b[43:51], fmt.Sprintf("%-8s", fstype))
+	default:
+		return nil, fmt.Errorf("unknown DOS 4.0 EBPB Signature: %v", bpb.extendedBootSignature)
+	}
+	copy(b[0:25], bpb.dos331BPB.toBytes())
+	b[25] = bpb.driveNumber
+	b[26] = bpb.reservedFlags
+	b[27] = bpb.extendedBootSignature
+	binary.LittleEndian.PutUint32(b[28:32], bpb.volumeSerialNumber)
+
+	return b, nil
+}
@@ -0,0 +1,87 @@
+package fat32
+
+import (
+	"strings"
+	"testing"
+)
+
+func getValidDos40EBPB() *dos40EBPB {
+	return &dos40EBPB{
+		dos331BPB:             getValidDos331BPB(),
+		driveNumber:           128,
+		reservedFlags:         0x00,
+		extendedBootSignature: 0x29,
+		volumeSerialNumber:    2712131608,
+		volumeLabel:           "go-diskfs",
+		fileSystemType:        "FAT16",
+	}
+}
+
+func TestDos40EBPBFromBytes(t *testing.T) {
+	t.Run("mismatched length", func(t *testing.T) {
+		bpb, size, err := dos40EBPBFromBytes(make([]byte, 50))
+		if err == nil || !strings.HasPrefix(err.Error(), "cannot read DOS 4.0 EBPB from invalid byte slice") {
+			t.Errorf("unexpected error %v", err)
+		}
+		if bpb != nil || size != 0 {
+			t.Errorf("returned bpb %v of size %d", bpb, size)
+		}
+	})
+	t.Run("invalid signature", func(t *testing.T) {
+		b, err := getValidDos40EBPB().toBytes()
+		if err != nil {
+			t.Fatalf("error converting to bytes: %v", err)
+		}
+		b[27] = 0x30
+		if _, _, err := dos40EBPBFromBytes(b); err == nil || !strings.HasPrefix(err.Error(), "unknown DOS 4.0 EBPB Signature") {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	tests := []struct {
+		name string
+		bpb  *dos40EBPB
+		size int
+	}{
+		{"long", getValidDos40EBPB(), 51},
+		{"short", &dos40EBPB{dos331BPB: getValidDos331BPB(), extendedBootSignature: 0x28, volumeSerialNumber: 1234}, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.bpb.toBytes()
+			if err != nil {
+				t.Fatalf("error converting to bytes: %v", err)
+			}
+			if len(b) != tt.size {
+				t.Errorf("%d bytes instead of %d", len(b), tt.size)
+			}
+			// always parsed from the full length
+			b = append(b, make([]byte, 51-len(b))...)
+			bpb, size, err := dos40EBPBFromBytes(b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size != tt.size {
+				t.Errorf("size %d instead of %d", size, tt.size)
+			}
+			if !bpb.equal(tt.bpb) {
+				t.Errorf("mismatched bpb, actual %#v expected %#v", bpb, tt.bpb)
+			}
+		})
+	}
+}
+
+func TestDos40EBPBToBytes(t *testing.T) {
+	bpb := getValidDos40EBPB()
+	bpb.volumeLabel = "ABCDEFGHIJKL"
+	if _, err := bpb.toBytes(); err == nil {
+		t.Errorf("expected error for too long volume label")
+	}
+	bpb = getValidDos40EBPB()
+	b, err := bpb.toBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if label, fsType := string(b[32:43]), string(b[43:51]); label != "go-diskfs  " || fsType != "FAT16   " {
+		t.Errorf("label %q and type %q not padded", label, fsType)
+	}
+}
@@ -1,8 +1,10 @@
 package fat32
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"sort"
@@ -45,6 +47,29 @@ const (
 	maxCharsLongFilename int        = 13
 )
 
+// FatType is the variant of FAT filesystem, by the width in bits of the entries in its file allocation table
+type FatType uint8
+
+const (
+	// FatTypeAuto picks the type by the size of the filesystem: FAT32 whenever it is big enough to have the
+	// 65525 clusters FAT32 needs, otherwise FAT16, or FAT12 for the smallest
+	FatTypeAuto FatType = 0
+	// FatType12 is FAT12, with at most 4084 clusters, as used on floppy disks
+	FatType12 FatType = 12
+	// FatType16 is FAT16, with 4085 to 65524 clusters
+	FatType16 FatType = 16
+	// FatType32 is FAT32
+	FatType32 FatType = 32
+)
+
+const (
+	fat12MaxClusters uint32 = 4084
+	fat16MaxClusters uint32 = 65524
+	fat32MaxClusters uint32 = 0x0ffffff5
+	// rootDirectoryEntries16 is the number of entries in the fixed root directory region of FAT12 and FAT16
+	rootDirectoryEntries16 uint16 = 512
+)
+
 //nolint:deadcode,varcheck,unused // we need these references in the future
 const (
 	minClusterSize int = 128
@@ -57,6 +82,8 @@ type FileSystem struct {
 	fsis            FSInformationSector
 	table           table
 	dataStart       uint32
+	rootDirStart    uint32 // where the fixed root directory region of FAT12 and FAT16 starts, in bytes
+	rootDirSize     uint32 // size of the fixed root directory region in bytes, 0 for FAT32 which keeps it in clusters
 	bytesPerCluster int
 	size            int64
 	start           int64
@@ -72,7 +99,7 @@ func (fs *FileSystem) Equal(a *FileSystem) bool {
 	return localMatch && tableMatch && bsMatch && fsisMatch
 }
 
-// Create creates a FAT32 filesystem in a given file or device
+// Create creates a FAT12, FAT16 or FAT32 filesystem in a given file or device
 //
 // requires the util.File where to create the filesystem, size is the size of the filesystem in bytes,
 // start is how far in bytes from the beginning of the util.File to create the filesystem,
@@ -88,7 +115,19 @@ func (fs *FileSystem) Equal(a *FileSystem) bool {
 //
 // If the provided blocksize is 0, it will use the default of 512 bytes. If it is any number other than 0
 // or 512, it will return an error.
+//
+// The type of FAT is picked by size, as with FatTypeAuto: FAT32 if it is big enough, otherwise FAT16 or FAT12.
+// Use CreateWithType to pick it.
 func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*FileSystem, error) {
+	return CreateWithType(f, size, start, blocksize, volumeLabel, FatTypeAuto)
+}
+
+// CreateWithType creates a FAT filesystem of the given type in a given file or device, as Create does.
+//
+// FAT12 and FAT16 have a fixed root directory region of 512 entries, and use the smallest clusters that
+// keep the number of clusters within what the type allows. Returns an error if the filesystem is too small
+// or too big for the type.
+func CreateWithType(f util.File, size, start, blocksize int64, volumeLabel string, fatType FatType) (*FileSystem, error) {
 	// blocksize must be <=0 or exactly SectorSize512 or error
 	if blocksize != int64(SectorSize512) && blocksize > 0 {
 		return nil, fmt.Errorf("blocksize for FAT32 must be either 512 bytes or 0, not %d", blocksize)
@@ -104,6 +143,87 @@ func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*Fil
 	// because we like the fudges other people did for uniqueness
 	volid := uint32(now.Unix()<<20 | (now.UnixNano() / 1000000))
 
+	if fatType == FatTypeAuto {
+		fatType = autoFatType(size)
+	}
+	var (
+		fs  *FileSystem
+		err error
+	)
+	switch fatType {
+	case FatType32:
+		fs = newFat32(size, volid)
+	case FatType12, FatType16:
+		fs, err = newFat1216(size, fatType, volid)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown FAT type %d", fatType)
+	}
+	fs.start = start
+	fs.size = size
+	fs.file = f
+
+	// write the boot sector
+	if err := fs.writeBootSector(); err != nil {
+		return nil, fmt.Errorf("failed to write the boot sector: %v", err)
+	}
+
+	// write the fsis
+	if err := fs.writeFsis(); err != nil {
+		return nil, fmt.Errorf("failed to write the file system information sector: %v", err)
+	}
+
+	// write the FAT tables
+	if err := fs.writeFat(); err != nil {
+		return nil, fmt.Errorf("failed to write the file allocation table: %v", err)
+	}
+
+	// create root directory
+	// be sure to zero out the root cluster, or the fixed root directory region of FAT12 and FAT16,
+	// so we do not pick up phantom entries.
+	clusterStart := fs.start + int64(fs.dataStart)
+	// length of cluster in bytes
+	tmpb := make([]byte, fs.bytesPerCluster)
+	if fs.rootDirSize > 0 {
+		clusterStart = fs.start + int64(fs.rootDirStart)
+		tmpb = make([]byte, fs.rootDirSize)
+	}
+	// zero out the root directory cluster
+	written, err := f.WriteAt(tmpb, clusterStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to zero out root directory: %v", err)
+	}
+	if written != len(tmpb) {
+		return nil, fmt.Errorf("incomplete zero out of root directory, wrote %d bytes instead of expected %d", written, len(tmpb))
+	}
+
+	// create a volumelabel entry in the root directory
+	rootDir := &Directory{
+		directoryEntry: directoryEntry{
+			clusterLocation: fs.table.rootDirCluster,
+			isSubdirectory:  true,
+			filesystem:      fs,
+		},
+	}
+	// write the root directory entries to disk
+	err = fs.writeDirectoryEntries(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("error writing root directory to disk: %v", err)
+	}
+
+	// set the volume label
+	err = fs.SetLabel(volumeLabel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set volume label to '%s': %v", volumeLabel, err)
+	}
+
+	return fs, nil
+}
+
+// newFat32 lays out a FAT32 filesystem of the given size, ready to be written
+func newFat32(size int64, volid uint32) *FileSystem {
 	fsisPrimarySector := uint16(1)
 	backupBootSector := uint16(6)
 
@@ -211,8 +331,8 @@ func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*Fil
 
 	// create and allocate FAT32 FSInformationSector
 	fsis := FSInformationSector{
-		lastAllocatedCluster:  0xffffffff,
-		freeDataClustersCount: 0xffffffff,
+		lastAllocatedCluster:  unknownlastAllocatedCluster,
+		freeDataClustersCount: unknownFreeDataClusterCount,
 	}
 
 	// create and allocate the FAT tables
@@ -224,6 +344,7 @@ func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*Fil
 	maxCluster := fatSize / 4
 	rootDirCluster := uint32(2)
 	fat := table{
+		fatType:        FatType32,
 		fatID:          fatID,
 		eocMarker:      eocMarker,
 		unusedMarker:   unusedMarker,
@@ -239,69 +360,155 @@ func Create(f util.File, size, start, blocksize int64, volumeLabel string) (*Fil
 	// where does our data start?
 	dataStart := uint32(fatSecondaryStart) + fatSize
 
-	// create the filesystem
-	fs := &FileSystem{
+	return &FileSystem{
 		bootSector:      bs,
 		fsis:            fsis,
 		table:           fat,
 		dataStart:       dataStart,
 		bytesPerCluster: int(sectorsPerCluster) * int(SectorSize512),
-		start:           start,
-		size:            size,
-		file:            f,
 	}
+}
 
-	// write the boot sector
-	if err := fs.writeBootSector(); err != nil {
-		return nil, fmt.Errorf("failed to write the boot sector: %v", err)
+// newFat1216 lays out a FAT12 or FAT16 filesystem of the given size, ready to be written
+func newFat1216(size int64, fatType FatType, volid uint32) (*FileSystem, error) {
+	totalSectors := uint32(size / int64(SectorSize512))
+	reservedSectors := uint16(1)
+	rootDirSectors := uint32(rootDirectoryEntries16) * uint32(bytesPerSlot) / uint32(SectorSize512)
+	sectorsPerCluster, sectorsPerFat, err := fatGeometry(totalSectors, uint32(reservedSectors)+rootDirSectors, fatType)
+	if err != nil {
+		return nil, err
 	}
 
-	// write the fsis
-	if err := fs.writeFsis(); err != nil {
-		return nil, fmt.Errorf("failed to write the file system information sector: %v", err)
+	mediaType := uint8(MediaFixedDisk)
+	dos20bpb := dos20BPB{
+		sectorsPerCluster:    sectorsPerCluster,
+		reservedSectors:      reservedSectors,
+		fatCount:             2,
+		mediaType:            mediaType,
+		bytesPerSector:       SectorSize512,
+		rootDirectoryEntries: rootDirectoryEntries16,
+		sectorsPerFat:        uint16(sectorsPerFat),
 	}
-
-	// write the FAT tables
-	if err := fs.writeFat(); err != nil {
-		return nil, fmt.Errorf("failed to write the file allocation table: %v", err)
+	dos331bpb := dos331BPB{
+		dos20BPB:        &dos20bpb,
+		heads:           1,
+		sectorsPerTrack: 1,
+		hiddenSectors:   0,
 	}
-
-	// create root directory
-	// be sure to zero out the root cluster, so we do not pick up phantom
-	// entries.
-	clusterStart := fs.start + int64(fs.dataStart)
-	// length of cluster in bytes
-	tmpb := make([]byte, fs.bytesPerCluster)
-	// zero out the root directory cluster
-	written, err := f.WriteAt(tmpb, clusterStart)
-	if err != nil {
-		return nil, fmt.Errorf("failed to zero out root directory: %v", err)
+	// the total goes in the DOS 2.0 BPB if it fits
+	if totalSectors <= math.MaxUint16 {
+		dos20bpb.totalSectors = uint16(totalSectors)
+	} else {
+		dos331bpb.totalSectors = totalSectors
+	}
+	fsType, fatID, eocMarker := fileSystemTypeFAT16, uint32(0xff00)|uint32(mediaType), uint32(0xffff)
+	if fatType == FatType12 {
+		fsType, fatID, eocMarker = fileSystemTypeFAT12, uint32(0xf00)|uint32(mediaType), uint32(0xfff)
+	}
+	ebpb := dos40EBPB{
+		dos331BPB:             &dos331bpb,
+		driveNumber:           FirstFixedDrive,
+		extendedBootSignature: longDos40EBPB,
+		volumeSerialNumber:    volid,
+		volumeLabel:           "NO NAME    ",
+		fileSystemType:        fsType,
 	}
-	if written != len(tmpb) || written != fs.bytesPerCluster {
-		return nil, fmt.Errorf("incomplete zero out of root directory, wrote %d bytes instead of expected %d for cluster size %d", written, len(tmpb), fs.bytesPerCluster)
+	bs := msDosBootSector{
+		oemName:              "godiskfs",
+		jumpInstruction:      [3]byte{0xeb, 0x3c, 0x90},
+		bootCode:             []byte{},
+		biosParameterBlock16: &ebpb,
 	}
 
-	// create a volumelabel entry in the root directory
-	rootDir := &Directory{
-		directoryEntry: directoryEntry{
-			clusterLocation: fs.table.rootDirCluster,
-			isSubdirectory:  true,
-			filesystem:      fs,
+	fatSize := sectorsPerFat * uint32(SectorSize512)
+	fat := table{
+		fatType:      fatType,
+		fatID:        fatID,
+		eocMarker:    eocMarker,
+		unusedMarker: 0,
+		size:         fatSize,
+		clusters:     map[uint32]uint32{},
+		maxCluster:   fatSize * 8 / uint32(fatType),
+	}
+	rootDirStart := uint32(reservedSectors)*uint32(SectorSize512) + 2*fatSize
+	rootDirSize := rootDirSectors * uint32(SectorSize512)
+	return &FileSystem{
+		bootSector: bs,
+		// there is no FS Information Sector, but it keeps the free cluster count in memory
+		fsis: FSInformationSector{
+			lastAllocatedCluster:  unknownlastAllocatedCluster,
+			freeDataClustersCount: unknownFreeDataClusterCount,
 		},
+		table:           fat,
+		rootDirStart:    rootDirStart,
+		rootDirSize:     rootDirSize,
+		dataStart:       rootDirStart + rootDirSize,
+		bytesPerCluster: int(sectorsPerCluster) * int(SectorSize512),
+	}, nil
+}
+
+// autoFatType picks FAT32 if the filesystem is big enough to have the clusters it needs with the smallest
+// clusters, otherwise FAT16 if it is, otherwise FAT12
+func autoFatType(size int64) FatType {
+	totalSectors := uint32(size / int64(SectorSize512))
+	if _, _, err := fatGeometry(totalSectors, 32, FatType32); err == nil {
+		return FatType32
 	}
-	// write the root directory entries to disk
-	err = fs.writeDirectoryEntries(rootDir)
-	if err != nil {
-		return nil, fmt.Errorf("error writing root directory to disk: %v", err)
+	rootDirSectors := uint32(rootDirectoryEntries16) * uint32(bytesPerSlot) / uint32(SectorSize512)
+	if _, _, err := fatGeometry(totalSectors, 1+rootDirSectors, FatType16); err == nil {
+		return FatType16
 	}
+	return FatType12
+}
 
-	// set the volume label
-	err = fs.SetLabel(volumeLabel)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set volume label to '%s': %v", volumeLabel, err)
+// fatGeometry picks the smallest cluster size that keeps the number of clusters within what fatType allows,
+// and returns it with the sectors in each of the two FATs. overhead is the number of sectors that are
+// neither FAT nor data: the reserved sectors and, for FAT12 and FAT16, the fixed root directory.
+func fatGeometry(totalSectors, overhead uint32, fatType FatType) (sectorsPerCluster uint8, sectorsPerFat uint32, err error) {
+	var minClusters, maxClusters uint32
+	switch fatType {
+	case FatType12:
+		minClusters, maxClusters = 1, fat12MaxClusters
+	case FatType16:
+		minClusters, maxClusters = fat12MaxClusters+1, fat16MaxClusters
+	default:
+		minClusters, maxClusters = fat16MaxClusters+1, fat32MaxClusters
+	}
+	for spc := uint32(1); spc <= 128; spc *= 2 {
+		var clusters uint32
+		sectorsPerFat, clusters = fatSectors(totalSectors, overhead, spc, fatType)
+		// bigger clusters only make fewer of them
+		if clusters < minClusters {
+			return 0, 0, fmt.Errorf("requested size is too small for FAT%d, which needs at least %d clusters", fatType, minClusters)
+		}
+		if clusters <= maxClusters {
+			return uint8(spc), sectorsPerFat, nil
+		}
 	}
+	return 0, 0, fmt.Errorf("requested size is too large for FAT%d, which can have at most %d clusters", fatType, maxClusters)
+}
 
-	return fs, nil
+// fatSectors returns how many sectors each of the two FATs needs for the clusters that are left
+// once they are taken out, and how many clusters that is
+func fatSectors(totalSectors, overhead, sectorsPerCluster uint32, fatType FatType) (sectorsPerFat, clusters uint32) {
+	if totalSectors <= overhead {
+		return 0, 0
+	}
+	available := totalSectors - overhead
+	sectorsPerFat = 1
+	for {
+		if available <= 2*sectorsPerFat {
+			return sectorsPerFat, 0
+		}
+		clusters = (available - 2*sectorsPerFat) / sectorsPerCluster
+		// the first 2 entries are reserved
+		fatBytes := (uint64(clusters+2)*uint64(fatType) + 7) / 8
+		needed := uint32((fatBytes + uint64(SectorSize512) - 1) / uint64(SectorSize512))
+		if needed <= sectorsPerFat {
+			return sectorsPerFat, clusters
+		}
+		sectorsPerFat = needed
+	}
 }
 
 // Read reads a filesystem from a given disk.
@@ -348,41 +555,82 @@ func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 		return nil, fmt.Errorf("error reading MS-DOS Boot Sector: %v", err)
 	}
 
-	sectorsPerFat := bs.biosParameterBlock.sectorsPerFat
-	fatSize := sectorsPerFat * uint32(SectorSize512)
-	reservedSectors := bs.biosParameterBlock.dos331BPB.dos20BPB.reservedSectors
-	sectorsPerCluster := bs.biosParameterBlock.dos331BPB.dos20BPB.sectorsPerCluster
+	bpb := bs.dos331BPB()
+	reservedSectors := bpb.dos20BPB.reservedSectors
+	sectorsPerCluster := bpb.dos20BPB.sectorsPerCluster
+	fatCount := uint32(bpb.dos20BPB.fatCount)
+	if sectorsPerCluster == 0 {
+		return nil, errors.New("invalid 0 sectors per cluster in MS-DOS Boot Sector")
+	}
 	fatPrimaryStart := uint64(reservedSectors) * uint64(SectorSize512)
-	fatSecondaryStart := fatPrimaryStart + uint64(fatSize)
 
-	fsisBytes := make([]byte, 512)
-	read, err := file.ReadAt(fsisBytes, int64(bs.biosParameterBlock.fsInformationSector)*blocksize+start)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read bytes for FSInformationSector: %v", err)
-	}
-	if read != 512 {
-		return nil, fmt.Errorf("read %d bytes instead of expected %d for FS Information Sector", read, 512)
-	}
-	fsis, err := fsInformationSectorFromBytes(fsisBytes)
-	if err != nil {
-		return nil, fmt.Errorf("error reading FileSystem Information Sector: %v", err)
+	var (
+		fatType       FatType
+		sectorsPerFat uint32
+		rootDirSize   uint32
+		// FAT12 and FAT16 have no FS Information Sector, so what it would hold is unknown
+		fsis = &FSInformationSector{
+			freeDataClustersCount: unknownFreeDataClusterCount,
+			lastAllocatedCluster:  unknownlastAllocatedCluster,
+		}
+	)
+	if bs.biosParameterBlock16 != nil {
+		// FAT12 and FAT16 are told apart only by the number of clusters
+		sectorsPerFat = uint32(bpb.dos20BPB.sectorsPerFat)
+		rootDirSectors := (uint32(bpb.dos20BPB.rootDirectoryEntries)*uint32(bytesPerSlot) + uint32(SectorSize512) - 1) / uint32(SectorSize512)
+		rootDirSize = rootDirSectors * uint32(SectorSize512)
+		totalSectors := uint32(bpb.dos20BPB.totalSectors)
+		if totalSectors == 0 {
+			totalSectors = bpb.totalSectors
+		}
+		overhead := uint32(reservedSectors) + fatCount*sectorsPerFat + rootDirSectors
+		if totalSectors <= overhead {
+			return nil, fmt.Errorf("MS-DOS Boot Sector has %d sectors, too few for its %d FAT and root directory sectors", totalSectors, overhead)
+		}
+		fatType = FatType16
+		if (totalSectors-overhead)/uint32(sectorsPerCluster) <= fat12MaxClusters {
+			fatType = FatType12
+		}
+	} else {
+		fatType = FatType32
+		sectorsPerFat = bs.biosParameterBlock.sectorsPerFat
+
+		fsisBytes := make([]byte, 512)
+		read, err := file.ReadAt(fsisBytes, int64(bs.biosParameterBlock.fsInformationSector)*int64(SectorSize512)+start)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bytes for FSInformationSector: %v", err)
+		}
+		if read != 512 {
+			return nil, fmt.Errorf("read %d bytes instead of expected %d for FS Information Sector", read, 512)
+		}
+		fsis, err = fsInformationSectorFromBytes(fsisBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error reading FileSystem Information Sector: %v", err)
+		}
 	}
+	fatSize := sectorsPerFat * uint32(SectorSize512)
+	fatSecondaryStart := fatPrimaryStart + uint64(fatSize)
 
 	b := make([]byte, fatSize)
 	_, _ = file.ReadAt(b, int64(fatPrimaryStart)+start)
-	fat := tableFromBytes(b)
+	fat := tableFromBytes(b, fatType)
 
-	_, _ = file.ReadAt(b, int64(fatSecondaryStart)+start)
-	fat2 := tableFromBytes(b)
-	if !fat.equal(fat2) {
-		return nil, errors.New("fat tables did not much")
+	if fatCount > 1 {
+		_, _ = file.ReadAt(b, int64(fatSecondaryStart)+start)
+		fat2 := tableFromBytes(b, fatType)
+		if !fat.equal(fat2) {
+			return nil, errors.New("fat tables did not much")
+		}
 	}
-	dataStart := uint32(fatSecondaryStart) + fat.size
+	rootDirStart := uint32(fatPrimaryStart) + fatCount*fatSize
+	dataStart := rootDirStart + rootDirSize
 
 	return &FileSystem{
 		bootSector:      *bs,
 		fsis:            *fsis,
 		table:           *fat,
+		rootDirStart:    rootDirStart,
+		rootDirSize:     rootDirSize,
 		dataStart:       dataStart,
 		bytesPerCluster: int(sectorsPerCluster) * int(SectorSize512),
 		start:           start,
@@ -414,8 +662,8 @@ func (fs *FileSystem) writeBootSector() error {
 		return fmt.Errorf("wrote %d bytes of MS-DOS Boot Sector to disk instead of expected %d", count, SectorSize512)
 	}
 
-	// write backup boot sector to the file
-	if fs.bootSector.biosParameterBlock.backupBootSector > 0 {
+	// write backup boot sector to the file; only FAT32 has one
+	if fs.bootSector.biosParameterBlock != nil && fs.bootSector.biosParameterBlock.backupBootSector > 0 {
 		count, err = fs.file.WriteAt(b, int64(fs.bootSector.biosParameterBlock.backupBootSector)*int64(SectorSize512)+fs.start)
 		if err != nil {
 			return fmt.Errorf("error writing MS-DOS Boot Sector to disk: %v", err)
@@ -429,6 +677,10 @@ func (fs *FileSystem) writeBootSector() error {
 }
 
 func (fs *FileSystem) writeFsis() error {
+	// only FAT32 has an FS Information Sector
+	if fs.bootSector.biosParameterBlock == nil {
+		return nil
+	}
 	fsInformationSector := fs.bootSector.biosParameterBlock.fsInformationSector
 	backupBootSector := fs.bootSector.biosParameterBlock.backupBootSector
 	fsisPrimary := int64(fsInformationSector * uint16(SectorSize512))
@@ -449,8 +701,8 @@ func (fs *FileSystem) writeFsis() error {
 }
 
 func (fs *FileSystem) writeFat() error {
-	reservedSectors := fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.reservedSectors
-	fatPrimaryStart := uint64(reservedSectors) * uint64(SectorSize512)
+	dos20bpb := fs.bootSector.dos331BPB().dos20BPB
+	fatPrimaryStart := uint64(dos20bpb.reservedSectors) * uint64(SectorSize512)
 	fatSecondaryStart := fatPrimaryStart + uint64(fs.table.size)
 
 	fatBytes := fs.table.bytes()
@@ -459,6 +711,9 @@ func (fs *FileSystem) writeFat() error {
 		return fmt.Errorf("unable to write primary FAT table: %v", err)
 	}
 
+	if dos20bpb.fatCount == 1 {
+		return nil
+	}
 	if _, err := fs.file.WriteAt(fatBytes, int64(fatSecondaryStart)+fs.start); err != nil {
 		return fmt.Errorf("unable to write backup FAT table: %v", err)
 	}
@@ -466,11 +721,17 @@ func (fs *FileSystem) writeFat() error {
 	return nil
 }
 
-// Type returns the type code for the filesystem. Always returns filesystem.TypeFat32
+// Type returns the type code for the filesystem. Always returns filesystem.TypeFat32, even for FAT12 and FAT16;
+// use FatType to tell them apart.
 func (fs *FileSystem) Type() filesystem.Type {
 	return filesystem.TypeFat32
 }
 
+// FatType returns which FAT the filesystem is: FatType12, FatType16 or FatType32
+func (fs *FileSystem) FatType() FatType {
+	return fs.table.fatType
+}
+
 // Mkdir make a directory at the given path. It is equivalent to `mkdir -p`, i.e. idempotent, in that:
 //
 // * It will make the entire tree path if it does not exist
@@ -493,27 +754,9 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 	}
 	// once we have made it here, looping is done. We have found the final entry
 	// we need to return all of the file info
-	count := len(entries)
-	ret := make([]os.FileInfo, count)
+	ret := make([]os.FileInfo, len(entries))
 	for i, e := range entries {
-		shortName := e.filenameShort
-		if e.lowercaseShortname {
-			shortName = strings.ToLower(shortName)
-		}
-		fileExtension := e.fileExtension
-		if e.lowercaseExtension {
-			shortName = strings.ToLower(fileExtension)
-		}
-		if fileExtension != "" {
-			shortName = fmt.Sprintf("%s.%s", shortName, fileExtension)
-		}
-		ret[i] = FileInfo{
-			modTime:   e.modifyTime,
-			name:      e.filenameLong,
-			shortName: shortName,
-			size:      int64(e.fileSize),
-			isDir:     e.isSubdirectory,
-		}
+		ret[i] = e.fileInfo()
 	}
 	return ret, nil
 }
@@ -599,6 +842,245 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	}, nil
 }
 
+// Stat returns the FileInfo for a file or directory.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	if isRoot(p) {
+		return FileInfo{name: "/", mode: os.ModeDir, isDir: true}, nil
+	}
+	_, entry, err := fs.findEntry(p)
+	if err != nil {
+		return nil, err
+	}
+	return entry.fileInfo(), nil
+}
+
+// Remove removes a file or an empty directory, freeing its clusters and its directory entry,
+// including any long filename entries.
+func (fs *FileSystem) Remove(p string) error {
+	if isRoot(p) {
+		return fmt.Errorf("cannot remove root directory")
+	}
+	parentDir, entry, err := fs.findEntry(p)
+	if err != nil {
+		return err
+	}
+	if entry.isSubdirectory {
+		entries, err := fs.readDirectory(&Directory{directoryEntry: *entry})
+		if err != nil {
+			return fmt.Errorf("could not read directory entries for %s: %v", p, err)
+		}
+		for _, e := range entries {
+			if e.filenameShort != "." && e.filenameShort != ".." {
+				return fmt.Errorf("directory %s is not empty", p)
+			}
+		}
+	}
+	if err := fs.removeEntry(parentDir, entry); err != nil {
+		return fmt.Errorf("unable to remove %s: %v", p, err)
+	}
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it. It returns nil if the path does not exist.
+func (fs *FileSystem) RemoveAll(p string) error {
+	if isRoot(p) {
+		return fmt.Errorf("cannot remove root directory")
+	}
+	_, entry, err := fs.findEntry(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry.isSubdirectory {
+		children, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if name := child.Name(); name != "." && name != ".." {
+				if err := fs.RemoveAll(path.Join(p, name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return fs.Remove(p)
+}
+
+// Rename renames or moves a file or directory. If newpath is an existing file, it is replaced, as long as
+// oldpath is not a directory. The parent directory of newpath must exist.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if isRoot(oldpath) || isRoot(newpath) {
+		return fmt.Errorf("cannot rename root directory")
+	}
+	oldParent, entry, err := fs.findEntry(oldpath)
+	if err != nil {
+		return err
+	}
+	oldClean, newClean := path.Clean(oldpath), path.Clean(newpath)
+	if oldClean == newClean {
+		return nil
+	}
+	if entry.isSubdirectory && strings.HasPrefix(newClean, oldClean+"/") {
+		return fmt.Errorf("cannot move directory %s into itself", oldpath)
+	}
+
+	// replace any existing file
+	targetParent, target, err := fs.findEntry(newpath)
+	switch {
+	case err == nil && targetParent.clusterLocation == oldParent.clusterLocation && target.filenameShort == entry.filenameShort && target.fileExtension == entry.fileExtension:
+		// a different name for the same entry, as short names are unique in a directory, so nothing to replace
+	case err == nil && target.isSubdirectory:
+		return fmt.Errorf("cannot replace directory %s", newpath)
+	case err == nil && entry.isSubdirectory:
+		return fmt.Errorf("cannot replace file %s with directory %s", newpath, oldpath)
+	case err == nil:
+		if err := fs.Remove(newpath); err != nil {
+			return fmt.Errorf("unable to replace %s: %v", newpath, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	// read the directories afresh, as removing the target may have changed them
+	oldParent, entry, err = fs.findEntry(oldpath)
+	if err != nil {
+		return err
+	}
+	newParent, _, err := fs.readDirWithMkdir(path.Dir(newClean), false)
+	if err != nil {
+		return fmt.Errorf("could not read directory entries for %s: %v", path.Dir(newClean), err)
+	}
+
+	// give the entry its new name, in place if it stays in the same directory
+	shortName, extension, isLFN, _ := convertLfnSfn(path.Base(newClean))
+	lfn := ""
+	if isLFN {
+		lfn = path.Base(newClean)
+	}
+	moved := *entry
+	moved.filenameLong = lfn
+	moved.filenameShort = shortName
+	moved.fileExtension = extension
+	moved.lowercaseShortname = false
+	moved.lowercaseExtension = false
+	moved.longFilenameSlots = calculateSlots(lfn)
+	if oldParent.clusterLocation == newParent.clusterLocation {
+		*entry = moved
+		if err := fs.writeDirectoryEntries(oldParent); err != nil {
+			return fmt.Errorf("error writing directory entries to disk: %v", err)
+		}
+		return nil
+	}
+
+	newParent.entries = append(newParent.entries, &moved)
+	if err := fs.writeDirectoryEntries(newParent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	if err := removeDirectoryEntry(oldParent, entry); err != nil {
+		return err
+	}
+	if err := fs.writeDirectoryEntries(oldParent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	// a directory must point its .. entry at its new parent
+	if moved.isSubdirectory {
+		dir := &Directory{directoryEntry: moved}
+		entries, err := fs.readDirectory(dir)
+		if err != nil {
+			return fmt.Errorf("could not read directory entries for %s: %v", newpath, err)
+		}
+		parentCluster := newParent.clusterLocation
+		if parentCluster == fs.table.rootDirCluster {
+			// references to the root directory must be stored as 0
+			parentCluster = 0
+		}
+		for _, e := range entries {
+			if e.filenameShort == ".." {
+				e.clusterLocation = parentCluster
+			}
+		}
+		if err := fs.writeDirectoryEntries(dir); err != nil {
+			return fmt.Errorf("error writing directory entries to disk: %v", err)
+		}
+	}
+	return nil
+}
+
+// Truncate changes the size of a file. If it grows, the new bytes are all zeroes; if it shrinks,
+// any clusters no longer needed are freed.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	if size < 0 || size > math.MaxUint32 {
+		return fmt.Errorf("invalid size %d for FAT32 file", size)
+	}
+	if isRoot(p) {
+		return fmt.Errorf("cannot truncate directory %s", p)
+	}
+	parentDir, entry, err := fs.findEntry(p)
+	if err != nil {
+		return err
+	}
+	if entry.isSubdirectory {
+		return fmt.Errorf("cannot truncate directory %s", p)
+	}
+	oldSize := int64(entry.fileSize)
+	if size > oldSize {
+		// writing zeroes takes care of allocating space and of any old data in the clusters
+		f := &File{
+			directoryEntry: entry,
+			isReadWrite:    true,
+			offset:         oldSize,
+			filesystem:     fs,
+			parent:         parentDir,
+		}
+		zeroes := make([]byte, 64*fs.bytesPerCluster)
+		for remaining := size - oldSize; remaining > 0; {
+			chunk := zeroes
+			if remaining < int64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+			if _, err := f.Write(chunk); err != nil {
+				return fmt.Errorf("unable to extend %s: %v", p, err)
+			}
+			remaining -= int64(len(chunk))
+		}
+		return nil
+	}
+	// a file always keeps at least its first cluster
+	allocSize := uint64(size)
+	if allocSize == 0 {
+		allocSize = 1
+	}
+	if _, err := fs.allocateSpace(allocSize, entry.clusterLocation); err != nil {
+		return fmt.Errorf("unable to resize cluster list: %v", err)
+	}
+	entry.fileSize = uint32(size)
+	entry.modifyTime = time.Now()
+	if err := fs.writeDirectoryEntries(parentDir); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	return nil
+}
+
+// Statfs get the capacity of the filesystem. Free space is the free cluster count of the FS Information
+// Sector, which is kept up to date whenever clusters are allocated or freed. Only when it is unknown, as it
+// is for a filesystem that was never written by something that counts it, or always for FAT12 and FAT16, is
+// free space counted from the FAT, once. FAT has no inodes, so Files and FreeFiles are 0.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	total, free := fs.totalClusters(), fs.freeClusterCount()
+	clusterSize := int64(fs.bytesPerCluster)
+	return filesystem.Statfs{
+		BlockSize:  clusterSize,
+		TotalBytes: int64(total) * clusterSize,
+		FreeBytes:  int64(free) * clusterSize,
+		UsedBytes:  int64(total-free) * clusterSize,
+	}, nil
+}
+
 // Label get the label of the filesystem from the secial file in the root directory.
 // The label stored in the boot sector is ignored to mimic Windows behavior which
 // only stores and reads the label from the special file in the root directory.
@@ -636,11 +1118,14 @@ func (fs *FileSystem) SetLabel(volumeLabel string) error {
 	volumeLabel = fmt.Sprintf("%-11.11s", volumeLabel)
 
 	// set the label in the superblock
-	bpb := fs.bootSector.biosParameterBlock
-	if bpb == nil {
+	switch {
+	case fs.bootSector.biosParameterBlock != nil:
+		fs.bootSector.biosParameterBlock.volumeLabel = volumeLabel
+	case fs.bootSector.biosParameterBlock16 != nil:
+		fs.bootSector.biosParameterBlock16.volumeLabel = volumeLabel
+	default:
 		return fmt.Errorf("failed to load the boot sector")
 	}
-	bpb.volumeLabel = volumeLabel
 
 	// write the boot sector
 	if err := fs.writeBootSector(); err != nil {
@@ -713,6 +1198,14 @@ func (fs *FileSystem) getClusterList(firstCluster uint32) ([]uint32, error) {
 
 // read directory entries for a given cluster
 func (fs *FileSystem) readDirectory(dir *Directory) ([]*directoryEntry, error) {
+	if fs.isFixedRootDir(dir) {
+		b := make([]byte, fs.rootDirSize)
+		_, _ = fs.file.ReadAt(b, fs.start+int64(fs.rootDirStart))
+		if err := dir.entriesFromBytes(b); err != nil {
+			return nil, err
+		}
+		return dir.entries, nil
+	}
 	clusterList, err := fs.getClusterList(dir.clusterLocation)
 	if err != nil {
 		return nil, fmt.Errorf("could not read cluster list: %v", err)
@@ -748,6 +1241,9 @@ func (fs *FileSystem) mkSubdir(parent *Directory, name string) (*directoryEntry,
 }
 
 func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
+	if fs.isFixedRootDir(dir) {
+		return fs.writeFixedRootDirEntries(dir)
+	}
 	// we need to save the entries of theparent
 	b, err := dir.entriesToBytes(fs.bytesPerCluster)
 	if err != nil {
@@ -767,6 +1263,10 @@ func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
 		}
 		clusterList = clusters
 	}
+	// if entries were removed, clear the clusters they no longer fill
+	if len(b) < len(clusterList)*fs.bytesPerCluster {
+		b = append(b, make([]byte, len(clusterList)*fs.bytesPerCluster-len(b))...)
+	}
 	// now write everything out to the cluster list
 	// read the data from all of the cluster entries in the list
 	for i, cluster := range clusterList {
@@ -784,6 +1284,31 @@ func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
 	return nil
 }
 
+// isFixedRootDir reports whether dir is the root directory of FAT12 or FAT16, which is in a fixed region
+// before the clusters rather than in a cluster chain
+func (fs *FileSystem) isFixedRootDir(dir *Directory) bool {
+	return fs.rootDirSize > 0 && dir.clusterLocation == 0
+}
+
+// writeFixedRootDirEntries writes the root directory of FAT12 or FAT16, which cannot grow
+func (fs *FileSystem) writeFixedRootDirEntries(dir *Directory) error {
+	b, err := dir.entriesToBytes(int(fs.rootDirSize))
+	if err != nil {
+		return fmt.Errorf("could not create a valid byte stream for root directory entries: %v", err)
+	}
+	// entriesToBytes always leaves room for an empty entry at the end, which a full root directory does not need
+	if len(b) > int(fs.rootDirSize) {
+		if !bytes.Equal(b[fs.rootDirSize:], make([]byte, len(b)-int(fs.rootDirSize))) || len(b) > 2*int(fs.rootDirSize) {
+			return fmt.Errorf("root directory is full, it can have at most %d entries", fs.rootDirSize/uint32(bytesPerSlot))
+		}
+		b = b[:fs.rootDirSize]
+	}
+	if _, err := fs.file.WriteAt(b, fs.start+int64(fs.rootDirStart)); err != nil {
+		return fmt.Errorf("error writing root directory entries: %v", err)
+	}
+	return nil
+}
+
 // mkFile make a file in a directory
 func (fs *FileSystem) mkFile(parent *Directory, name string) (*directoryEntry, error) {
 	// get a cluster chain for the file
@@ -795,6 +1320,64 @@ func (fs *FileSystem) mkFile(parent *Directory, name string) (*directoryEntry, e
 	return parent.createEntry(name, clusters[0], false)
 }
 
+// findEntry finds the directory entry for p, along with the directory it is in.
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) findEntry(p string) (*Directory, *directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	parentDir, entries, err := fs.readDirWithMkdir(dir, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read directory entries for %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		if e.isVolumeLabel || e.filenameShort == "." || e.filenameShort == ".." {
+			continue
+		}
+		shortName := e.filenameShort
+		if e.fileExtension != "" {
+			shortName += "." + e.fileExtension
+		}
+		if e.filenameLong == filename || shortName == filename {
+			return parentDir, e, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+}
+
+// removeEntry removes an entry from its directory on disk and frees its clusters
+func (fs *FileSystem) removeEntry(parent *Directory, entry *directoryEntry) error {
+	if err := removeDirectoryEntry(parent, entry); err != nil {
+		return err
+	}
+	if err := fs.writeDirectoryEntries(parent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	// files written by other implementations may have no clusters at all if they are empty
+	if entry.clusterLocation >= 2 {
+		if err := fs.freeClusters(entry.clusterLocation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDirectoryEntry removes an entry from the in-memory list of entries for a directory. The long filename
+// entries go with it, as they are generated from the entry whenever the directory is written.
+func removeDirectoryEntry(parent *Directory, entry *directoryEntry) error {
+	for i, e := range parent.entries {
+		if e == entry {
+			parent.entries = append(parent.entries[:i], parent.entries[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("entry %s not found in its directory", entry.filenameShort)
+}
+
+// isRoot reports whether p is the root directory
+func isRoot(p string) bool {
+	return path.Clean(strings.ReplaceAll(p, "\\", "/")) == "/"
+}
+
 // mkLabel make a volume label in a directory
 func (fs *FileSystem) mkLabel(parent *Directory, name string) (*directoryEntry, error) {
 	// create a directory entry for the file
@@ -852,8 +1435,8 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 				}
 				// make a basic entry for the new subdir
 				parentDirectoryCluster := currentDir.clusterLocation
-				if parentDirectoryCluster == 2 {
-					// references to the root directory (cluster 2) must be stored as 0
+				if parentDirectoryCluster == fs.table.rootDirCluster {
+					// references to the root directory must be stored as 0
 					parentDirectoryCluster = 0
 				}
 				dir := &Directory{
@@ -878,7 +1461,7 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 					directoryEntry: *subdirEntry,
 				}
 			} else {
-				return nil, nil, fmt.Errorf("path %s not found", "/"+strings.Join(paths[0:i+1], "/"))
+				return nil, nil, fmt.Errorf("path %s not found: %w", "/"+strings.Join(paths[0:i+1], "/"), os.ErrNotExist)
 			}
 		}
 		// get all of the entries in this directory
@@ -934,19 +1517,32 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 		return clusters, nil
 	}
 
-	// get a list of allocated clusters, so we can know which ones are unallocated and therefore allocatable
+	// the free cluster count is adjusted by what changes, so it must be known before anything does
+	fs.freeClusterCount()
+
+	// get a list of allocated clusters, so we can know which ones are unallocated and therefore allocatable,
+	// which are only the ones that fit in the filesystem, even if the FAT has room for more
 	allClusters := fs.table.clusters
-	maxCluster := fs.table.maxCluster
+	totalClusters := fs.totalClusters()
+	maxCluster := totalClusters + 2
 	for k := range allClusters {
 		keys = append(keys, k)
 	}
 	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
 
 	if extraClusterCount > 0 {
-		for i := uint32(2); i < maxCluster && len(allocated) < extraClusterCount; i++ {
-			if _, ok := allClusters[i]; !ok {
+		// look from the cluster after the one allocated most recently, wrapping around to the first
+		next := uint32(2)
+		if last := fs.fsis.lastAllocatedCluster; last >= 2 && last < maxCluster-1 {
+			next = last + 1
+		}
+		for n := uint32(0); n < totalClusters && len(allocated) < extraClusterCount; n++ {
+			if _, ok := allClusters[next]; !ok {
 				// these become the same at this point
-				allocated = append(allocated, i)
+				allocated = append(allocated, next)
+			}
+			if next++; next == maxCluster {
+				next = 2
 			}
 		}
 
@@ -969,6 +1565,7 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 
 		// update the FSIS
 		lastAllocatedCluster = allocated[len(allocated)-1]
+		fs.fsis.freeDataClustersCount -= uint32(len(allocated))
 	} else {
 		var (
 			lastAlloc   int
@@ -984,14 +1581,16 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 		// mark last allocated one as EOC
 		allClusters[clusters[lastAlloc]] = fs.table.eocMarker
 
-		// unmark all of the unused ones
+		// unmark all of the unused ones; a cluster is free when it is not in the table at all
 		lastAllocatedCluster = fs.fsis.lastAllocatedCluster
 		for _, cl := range deallocated {
-			allClusters[cl] = fs.table.unusedMarker
+			delete(allClusters, cl)
 			if cl == lastAllocatedCluster {
 				lastAllocatedCluster--
 			}
 		}
+		fs.fsis.freeDataClustersCount += uint32(len(deallocated))
+		clusters = clusters[:lastAlloc+1]
 	}
 
 	// update the FSIS
@@ -1009,6 +1608,55 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 	return append(clusters, allocated...), nil
 }
 
+// freeClusters frees the entire cluster chain starting at first
+func (fs *FileSystem) freeClusters(first uint32) error {
+	clusters, err := fs.getClusterList(first)
+	if err != nil {
+		return fmt.Errorf("unable to get cluster list: %v", err)
+	}
+	fs.freeClusterCount()
+	for _, cl := range clusters {
+		delete(fs.table.clusters, cl)
+	}
+	fs.fsis.freeDataClustersCount += uint32(len(clusters))
+	if err := fs.writeFsis(); err != nil {
+		return fmt.Errorf("failed to write the file system information sector: %v", err)
+	}
+	if err := fs.writeFat(); err != nil {
+		return fmt.Errorf("failed to write the file allocation table: %v", err)
+	}
+	return nil
+}
+
+// totalClusters returns the number of data clusters in the filesystem. The FAT can have entries for more
+// clusters than fit in the filesystem, which are not counted.
+func (fs *FileSystem) totalClusters() uint32 {
+	total := fs.table.maxCluster - 2
+	if fs.size > int64(fs.dataStart) {
+		if n := uint32((fs.size - int64(fs.dataStart)) / int64(fs.bytesPerCluster)); n < total {
+			total = n
+		}
+	}
+	return total
+}
+
+// freeClusterCount returns the number of free data clusters, from the FS Information Sector. If it is unknown
+// there, or more than there are clusters at all, they are counted from the FAT, and it is set.
+func (fs *FileSystem) freeClusterCount() uint32 {
+	total := fs.totalClusters()
+	if fs.fsis.freeDataClustersCount != unknownFreeDataClusterCount && fs.fsis.freeDataClustersCount <= total {
+		return fs.fsis.freeDataClustersCount
+	}
+	free := total
+	for cl := range fs.table.clusters {
+		if cl >= 2 && cl < total+2 {
+			free--
+		}
+	}
+	fs.fsis.freeDataClustersCount = free
+	return free
+}
+
 func abs(x int) int {
 	if x < 0 {
 		return -x
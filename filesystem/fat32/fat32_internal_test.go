@@ -26,6 +26,7 @@ func getValidFat32FSSmall() *FileSystem {
 	eoc := uint32(0xffffffff)
 	fs := &FileSystem{
 		table: table{
+			fatType:        FatType32,
 			rootDirCluster: 2,
 			size:           512,
 			maxCluster:     128,
@@ -189,6 +190,8 @@ func TestFat32AllocateSpace(t *testing.T) {
 		{600, 2, []uint32{2, 12}, nil},
 		{2000, 2, []uint32{2, 12, 13, 14}, nil},
 		{2000, 0, []uint32{12, 13, 14, 17}, nil},
+		{500, 3, []uint32{3}, nil},
+		{1000, 3, []uint32{3, 4}, nil},
 		{200000000000, 0, nil, fmt.Errorf("no space left on device")},
 		{200000000000, 2, nil, fmt.Errorf("no space left on device")},
 	}
@@ -209,6 +212,42 @@ func TestFat32AllocateSpace(t *testing.T) {
 	}
 }
 
+func TestFat32FreeSpace(t *testing.T) {
+	t.Run("shrink", func(t *testing.T) {
+		fs := getValidFat32FSSmall()
+		if _, err := fs.allocateSpace(500, 3); err != nil {
+			t.Fatalf("unable to shrink chain: %v", err)
+		}
+		for _, cl := range []uint32{4, 5, 6} {
+			if _, ok := fs.table.clusters[cl]; ok {
+				t.Errorf("cluster %d still allocated after shrinking", cl)
+			}
+		}
+		// the freed clusters are the first free ones, so they should be used again
+		clusters, err := fs.allocateSpace(1500, 0)
+		if err != nil {
+			t.Fatalf("unable to allocate: %v", err)
+		}
+		if !reflect.DeepEqual(clusters, []uint32{4, 5, 6}) {
+			t.Errorf("allocated %v instead of freed clusters", clusters)
+		}
+	})
+	t.Run("free chain", func(t *testing.T) {
+		fs := getValidFat32FSSmall()
+		if err := fs.freeClusters(8); err != nil {
+			t.Fatalf("unable to free chain: %v", err)
+		}
+		for _, cl := range []uint32{8, 9, 11} {
+			if _, ok := fs.table.clusters[cl]; ok {
+				t.Errorf("cluster %d still allocated after freeing", cl)
+			}
+		}
+		if _, ok := fs.table.clusters[7]; !ok {
+			t.Errorf("cluster 7 freed though not in chain")
+		}
+	})
+}
+
 func TestFat32MkSubdir(t *testing.T) {
 	fs := getValidFat32FSSmall()
 	d := &Directory{
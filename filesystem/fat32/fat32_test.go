@@ -8,9 +8,13 @@ package fat32_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path"
 	"strings"
 	"testing"
 
@@ -947,3 +951,561 @@ func TestFat32Label(t *testing.T) {
 		}
 	})
 }
+
+// memFat32 creates an empty FAT32 filesystem in memory, with the files in contents
+func memFat32(t *testing.T, contents map[string]string) *fat32.FileSystem {
+	t.Helper()
+	size := int64(40 * 1024 * 1024)
+	fs, err := fat32.Create(util.NewMemFile(size), size, 0, 512, "")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	for p, content := range contents {
+		if err := fs.Mkdir(path.Dir(p)); err != nil {
+			t.Fatalf("error making directory for %s: %v", p, err)
+		}
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	return fs
+}
+
+func readFat32File(t *testing.T, fs *fat32.FileSystem, p string) string {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", p, err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func TestFat32Stat(t *testing.T) {
+	fs := memFat32(t, map[string]string{
+		"/A Long File Name.txt": "long",
+		"/DIR/SHORT.TXT":        "short",
+	})
+	tests := []struct {
+		path  string
+		name  string
+		size  int64
+		isDir bool
+		err   error
+	}{
+		{"/", "/", 0, true, nil},
+		{"/A Long File Name.txt", "A Long File Name.txt", 4, false, nil},
+		{"/DIR", "DIR", 0, true, nil},
+		{"/DIR/SHORT.TXT", "SHORT.TXT", 5, false, nil},
+		{"/MISSING.TXT", "", 0, false, os.ErrNotExist},
+		{"/MISSING/SHORT.TXT", "", 0, false, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		info, err := fs.Stat(tt.path)
+		switch {
+		case tt.err != nil && !errors.Is(err, tt.err):
+			t.Errorf("Stat(%s): error %v instead of %v", tt.path, err, tt.err)
+		case tt.err == nil && err != nil:
+			t.Errorf("Stat(%s): unexpected error: %v", tt.path, err)
+		case err == nil && (info.Name() != tt.name || info.Size() != tt.size || info.IsDir() != tt.isDir || info.Mode().IsDir() != tt.isDir):
+			t.Errorf("Stat(%s): got name %q size %d dir %t mode %v", tt.path, info.Name(), info.Size(), info.IsDir(), info.Mode())
+		}
+	}
+}
+
+func TestFat32Remove(t *testing.T) {
+	contents := map[string]string{
+		"/A Long File Name.txt": strings.Repeat("long", 1000),
+		"/DIR/SHORT.TXT":        "short",
+		"/DIR/SUB/DEEP.TXT":     "deep",
+		"/KEEP.TXT":             "keep",
+	}
+	t.Run("remove", func(t *testing.T) {
+		fs := memFat32(t, contents)
+		before, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatalf("error reading /: %v", err)
+		}
+		if err := fs.Remove("/A Long File Name.txt"); err != nil {
+			t.Fatalf("error removing file: %v", err)
+		}
+		if _, err := fs.Stat("/A Long File Name.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("file still exists after remove: %v", err)
+		}
+		after, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatalf("error reading /: %v", err)
+		}
+		// no leftover long filename entries may turn up as a separate entry
+		if len(after) != len(before)-1 {
+			t.Errorf("%d entries after remove instead of %d", len(after), len(before)-1)
+		}
+		if err := fs.Remove("/DIR"); err == nil {
+			t.Errorf("expected error removing non-empty directory")
+		}
+		if err := fs.Remove("/MISSING"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("remove of missing file returned %v", err)
+		}
+		if err := fs.Remove("/"); err == nil {
+			t.Errorf("expected error removing root")
+		}
+		if got := readFat32File(t, fs, "/KEEP.TXT"); got != "keep" {
+			t.Errorf("other file changed to %q", got)
+		}
+	})
+	t.Run("remove all frees space", func(t *testing.T) {
+		fs := memFat32(t, contents)
+		if err := fs.RemoveAll("/DIR"); err != nil {
+			t.Fatalf("error removing directory tree: %v", err)
+		}
+		if _, err := fs.Stat("/DIR"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("directory still exists after remove: %v", err)
+		}
+		if err := fs.RemoveAll("/DIR"); err != nil {
+			t.Errorf("remove all of missing path returned %v", err)
+		}
+		// fill the filesystem, remove, and fill it again, which only works if the space was freed
+		big := bytes.Repeat([]byte{1}, 10*1024*1024)
+		for i := 0; i < 3; i++ {
+			for _, name := range []string{"/BIG1.DAT", "/BIG2.DAT", "/BIG3.DAT"} {
+				f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("round %d: error creating %s: %v", i, name, err)
+				}
+				if _, err := f.Write(big); err != nil {
+					t.Fatalf("round %d: error writing %s: %v", i, name, err)
+				}
+			}
+			for _, name := range []string{"/BIG1.DAT", "/BIG2.DAT", "/BIG3.DAT"} {
+				if err := fs.Remove(name); err != nil {
+					t.Fatalf("round %d: error removing %s: %v", i, name, err)
+				}
+			}
+		}
+		if got := readFat32File(t, fs, "/KEEP.TXT"); got != "keep" {
+			t.Errorf("other file changed to %q", got)
+		}
+	})
+}
+
+func TestFat32Rename(t *testing.T) {
+	contents := map[string]string{
+		"/A Long File Name.txt": "long",
+		"/DIR/SHORT.TXT":        "short",
+		"/DIR/SUB/DEEP.TXT":     "deep",
+		"/OTHER/EXISTING.TXT":   "existing",
+	}
+	tests := []struct {
+		name     string
+		old, new string
+		content  string
+		err      bool
+	}{
+		{"same directory long to short", "/A Long File Name.txt", "/RENAMED.TXT", "long", false},
+		{"same directory short to long", "/DIR/SHORT.TXT", "/DIR/Now A Long Name.txt", "short", false},
+		{"same entry by its short name", "/A Long File Name.txt", "/ALONGF~1.TXT", "long", false},
+		{"other directory", "/DIR/SHORT.TXT", "/OTHER/MOVED.TXT", "short", false},
+		{"replace file", "/DIR/SHORT.TXT", "/OTHER/EXISTING.TXT", "short", false},
+		{"directory", "/DIR/SUB", "/OTHER/SUB2", "", false},
+		{"directory into itself", "/DIR", "/DIR/SUB/DIR", "", true},
+		{"replace directory", "/A Long File Name.txt", "/DIR", "", true},
+		{"missing parent", "/A Long File Name.txt", "/MISSING/FILE.TXT", "", true},
+		{"missing", "/MISSING.TXT", "/FILE.TXT", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := memFat32(t, contents)
+			err := fs.Rename(tt.old, tt.new)
+			switch {
+			case tt.err && err == nil:
+				t.Fatalf("expected error renaming %s to %s", tt.old, tt.new)
+			case tt.err:
+				return
+			case err != nil:
+				t.Fatalf("error renaming %s to %s: %v", tt.old, tt.new, err)
+			}
+			if _, err := fs.Stat(tt.old); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("old path still exists: %v", err)
+			}
+			info, err := fs.Stat(tt.new)
+			if err != nil {
+				t.Fatalf("error on stat of new path: %v", err)
+			}
+			if info.Name() != path.Base(tt.new) {
+				t.Errorf("new name %q instead of %q", info.Name(), path.Base(tt.new))
+			}
+			if info.IsDir() {
+				if got := readFat32File(t, fs, path.Join(tt.new, "DEEP.TXT")); got != "deep" {
+					t.Errorf("moved directory has content %q", got)
+				}
+				// the moved directory must point back to its new parent
+				if err := fs.Mkdir(path.Join(tt.new, "NEWDIR")); err != nil {
+					t.Errorf("unable to make directory in moved directory: %v", err)
+				}
+				return
+			}
+			if got := readFat32File(t, fs, tt.new); got != tt.content {
+				t.Errorf("renamed file has content %q instead of %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestFat32RenameEmpty(t *testing.T) {
+	// empty files written by other implementations have no clusters, so that all of them have cluster 0
+	size := int64(40 * 1024 * 1024)
+	m := util.NewMemFile(size)
+	fs, err := fat32.Create(m, size, 0, 512, "")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	names := []string{"EMPTY1  TXT", "EMPTY2  TXT"}
+	for _, name := range names {
+		p := "/" + strings.TrimSpace(name[:8]) + "." + name[8:]
+		if _, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR); err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+	}
+	b := m.Bytes()
+	for _, name := range names {
+		i := bytes.Index(b, []byte(name))
+		if i < 0 {
+			t.Fatalf("unable to find the directory entry of %s", name)
+		}
+		// the high and low words of the first cluster
+		if _, err := m.WriteAt([]byte{0, 0}, int64(i+20)); err != nil {
+			t.Fatalf("error writing directory entry: %v", err)
+		}
+		if _, err := m.WriteAt([]byte{0, 0}, int64(i+26)); err != nil {
+			t.Fatalf("error writing directory entry: %v", err)
+		}
+	}
+	fs, err = fat32.Read(m, size, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+
+	if err := fs.Rename("/EMPTY1.TXT", "/EMPTY2.TXT"); err != nil {
+		t.Fatalf("error renaming an empty file onto another: %v", err)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	var found []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "EMPTY") {
+			found = append(found, e.Name())
+		}
+	}
+	if len(found) != 1 || found[0] != "EMPTY2.TXT" {
+		t.Errorf("directory has %v instead of the one EMPTY2.TXT", found)
+	}
+}
+
+func TestFat32Truncate(t *testing.T) {
+	fs := memFat32(t, map[string]string{"/FILE.DAT": strings.Repeat("x", 3000)})
+	tests := []struct {
+		size    int64
+		content string
+	}{
+		{1000, strings.Repeat("x", 1000)},
+		{0, ""},
+		// old data in freed clusters must not come back
+		{5000, strings.Repeat("\x00", 5000)},
+		{5100, strings.Repeat("\x00", 5100)},
+	}
+	for _, tt := range tests {
+		if err := fs.Truncate("/FILE.DAT", tt.size); err != nil {
+			t.Fatalf("error truncating to %d: %v", tt.size, err)
+		}
+		info, err := fs.Stat("/FILE.DAT")
+		if err != nil {
+			t.Fatalf("error on stat: %v", err)
+		}
+		if info.Size() != tt.size {
+			t.Errorf("size %d instead of %d", info.Size(), tt.size)
+		}
+		if got := readFat32File(t, fs, "/FILE.DAT"); got != tt.content {
+			t.Errorf("truncate to %d: mismatched content of length %d", tt.size, len(got))
+		}
+	}
+	if err := fs.Truncate("/MISSING.DAT", 10); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("truncate of missing file returned %v", err)
+	}
+	if err := fs.Truncate("/FILE.DAT", -1); err == nil {
+		t.Errorf("expected error truncating to negative size")
+	}
+}
+
+func TestFat32Statfs(t *testing.T) {
+	size := int64(40 * 1024 * 1024)
+	m := util.NewMemFile(size)
+	fs, err := fat32.Create(m, size, 0, 512, "")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	before, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if before.BlockSize <= 0 || before.TotalBytes <= 0 || before.TotalBytes > size {
+		t.Fatalf("implausible capacity %+v for %d byte filesystem", before, size)
+	}
+	// only the root directory is in use
+	if before.UsedBytes != before.BlockSize || before.FreeBytes != before.TotalBytes-before.UsedBytes {
+		t.Errorf("new filesystem used %d and free %d bytes of %d", before.UsedBytes, before.FreeBytes, before.TotalBytes)
+	}
+
+	// 2.5 clusters of data takes 3
+	f, err := fs.OpenFile("/KERNEL", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 5*before.BlockSize/2)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	after, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after.FreeBytes != before.FreeBytes-3*before.BlockSize || after.TotalBytes != before.TotalBytes {
+		t.Errorf("after writing, free %d of %d instead of %d of %d", after.FreeBytes, after.TotalBytes, before.FreeBytes-3*before.BlockSize, before.TotalBytes)
+	}
+
+	// the same capacity is read back from the FAT on disk
+	fs, err = fat32.Read(m, size, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if reread, err := fs.Statfs(); err != nil || reread != after {
+		t.Errorf("read back %+v, %v instead of %+v", reread, err, after)
+	}
+	if err := fs.Remove("/KERNEL"); err != nil {
+		t.Fatalf("error removing file: %v", err)
+	}
+	if removed, err := fs.Statfs(); err != nil || removed != before {
+		t.Errorf("after removing, %+v, %v instead of %+v", removed, err, before)
+	}
+}
+
+func TestFat32StatfsInformationSector(t *testing.T) {
+	size := int64(40 * 1024 * 1024)
+	m := util.NewMemFile(size)
+	fs, err := fat32.Create(m, size, 0, 512, "")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/KERNEL", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 100000)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	counted, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the free cluster count is at 488 in the FS Information Sector, which is sector 1
+	tests := []struct {
+		name  string
+		count uint32
+		free  int64
+	}{
+		{"stored", 1000, 1000 * counted.BlockSize},
+		{"unknown", 0xffffffff, counted.FreeBytes},
+		{"more than there are clusters", uint32(counted.TotalBytes/counted.BlockSize) + 1, counted.FreeBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, tt.count)
+			if _, err := m.WriteAt(b, 512+488); err != nil {
+				t.Fatalf("error writing free cluster count: %v", err)
+			}
+			fs, err := fat32.Read(m, size, 0, 512)
+			if err != nil {
+				t.Fatalf("error reading filesystem: %v", err)
+			}
+			stat, err := fs.Statfs()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stat.FreeBytes != tt.free {
+				t.Errorf("free %d bytes instead of %d", stat.FreeBytes, tt.free)
+			}
+		})
+	}
+}
+
+// TestFat32Fsck has fsck.fat check a filesystem after files are written, truncated and removed, including the
+// free cluster count and next free cluster in the FS Information Sector
+func TestFat32Fsck(t *testing.T) {
+	if _, err := exec.LookPath("fsck.fat"); err != nil {
+		t.Skip("fsck.fat not available")
+	}
+	for _, fatType := range []fat32.FatType{fat32.FatType16, fat32.FatType32} {
+		fatType := fatType
+		t.Run(fmt.Sprintf("FAT%d", fatType), func(t *testing.T) {
+			size := int64(64 * 1024 * 1024)
+			f := testhelper.TempFile(t, "fat32_fsck")
+			if err := f.Truncate(size); err != nil {
+				t.Fatalf("error sizing image: %v", err)
+			}
+			fs, err := fat32.CreateWithType(f, size, 0, 512, "FSCK", fatType)
+			if err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			if err := fs.Mkdir("/DIR/SUB"); err != nil {
+				t.Fatalf("error making directory: %v", err)
+			}
+			for i, n := range []int{0, 100, 5000, 300000, 2000000} {
+				rw, err := fs.OpenFile(fmt.Sprintf("/DIR/FILE%d.BIN", i), os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+				content := make([]byte, n)
+				_, _ = rand.Read(content)
+				if _, err := rw.Write(content); err != nil {
+					t.Fatalf("error writing file: %v", err)
+				}
+			}
+			if err := fs.Truncate("/DIR/FILE4.BIN", 70000); err != nil {
+				t.Fatalf("error truncating file: %v", err)
+			}
+			if err := fs.Remove("/DIR/FILE3.BIN"); err != nil {
+				t.Fatalf("error removing file: %v", err)
+			}
+			rw, err := fs.OpenFile("/DIR/SUB/LAST.BIN", os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			if _, err := rw.Write(make([]byte, 500000)); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+
+			if out, err := exec.Command("fsck.fat", "-n", f.Name()).CombinedOutput(); err != nil || bytes.Contains(out, []byte("wrong")) {
+				t.Errorf("fsck.fat found errors: %v\n%s", err, out)
+			}
+		})
+	}
+}
+
+func TestFat32CreateWithType(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int64
+		fatType  fat32.FatType
+		expected fat32.FatType
+		err      string
+	}{
+		{"floppy auto", 1440 * fat32.KB, fat32.FatTypeAuto, fat32.FatType12, ""},
+		{"small auto", 20 * fat32.MB, fat32.FatTypeAuto, fat32.FatType16, ""},
+		{"large auto", 64 * fat32.MB, fat32.FatTypeAuto, fat32.FatType32, ""},
+		{"FAT16 explicit", 300 * fat32.MB, fat32.FatType16, fat32.FatType16, ""},
+		{"FAT12 explicit", 8 * fat32.MB, fat32.FatType12, fat32.FatType12, ""},
+		{"FAT12 too large", 300 * fat32.MB, fat32.FatType12, 0, "requested size is too large for FAT12"},
+		{"FAT16 too small", 1 * fat32.MB, fat32.FatType16, 0, "requested size is too small for FAT16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := util.NewMemFile(tt.size)
+			fs, err := fat32.CreateWithType(m, tt.size, 0, 512, "SMALL", tt.fatType)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Errorf("error %v instead of %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error creating: %v", err)
+			}
+			if fs.FatType() != tt.expected {
+				t.Errorf("created FAT%d instead of FAT%d", fs.FatType(), tt.expected)
+			}
+			// a file spanning clusters, a subdirectory and a long name
+			contents := map[string]string{
+				"/FIRMWARE.BIN":             strings.Repeat("firmware", 2000),
+				"/EFI/BOOT/BOOTX64.EFI":     "bootloader",
+				"/a long file name.txt":     "long",
+				"/EFI/BOOT/another one.txt": strings.Repeat("x", 70000),
+			}
+			for p, content := range contents {
+				if err := fs.Mkdir(path.Dir(p)); err != nil {
+					t.Fatalf("error making directory for %s: %v", p, err)
+				}
+				f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("error creating %s: %v", p, err)
+				}
+				if _, err := f.Write([]byte(content)); err != nil {
+					t.Fatalf("error writing %s: %v", p, err)
+				}
+			}
+
+			fs, err = fat32.Read(m, tt.size, 0, 512)
+			if err != nil {
+				t.Fatalf("error reading filesystem: %v", err)
+			}
+			if fs.FatType() != tt.expected {
+				t.Errorf("read FAT%d instead of FAT%d", fs.FatType(), tt.expected)
+			}
+			if label := strings.TrimSpace(fs.Label()); label != "SMALL" {
+				t.Errorf("label %q instead of SMALL", label)
+			}
+			for p, content := range contents {
+				if got := readFat32File(t, fs, p); got != content {
+					t.Errorf("%s has %d bytes of mismatched content", p, len(got))
+				}
+			}
+			entries, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatalf("error reading root directory: %v", err)
+			}
+			// the label, FIRMWARE.BIN, EFI and the long name
+			if len(entries) != 4 {
+				t.Errorf("root directory has %d entries instead of 4", len(entries))
+			}
+			stat, err := fs.Statfs()
+			if err != nil {
+				t.Fatalf("unexpected error on Statfs: %v", err)
+			}
+			if stat.TotalBytes > tt.size || stat.UsedBytes < 16000+70000 {
+				t.Errorf("implausible capacity %+v", stat)
+			}
+		})
+	}
+}
+
+func TestFat32FixedRootDirectoryFull(t *testing.T) {
+	size := 20 * fat32.MB
+	fs, err := fat32.CreateWithType(util.NewMemFile(size), size, 0, 512, "", fat32.FatType16)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	// 512 entries, one of which is the label
+	for i := 1; i < 512; i++ {
+		if _, err := fs.OpenFile(fmt.Sprintf("/FILE%d.TXT", i), os.O_CREATE|os.O_RDWR); err != nil {
+			t.Fatalf("error creating file %d: %v", i, err)
+		}
+	}
+	if _, err := fs.OpenFile("/ONEMORE.TXT", os.O_CREATE|os.O_RDWR); err == nil {
+		t.Errorf("expected error creating file in full root directory")
+	}
+	// making room lets it be created
+	if err := fs.Remove("/FILE1.TXT"); err != nil {
+		t.Fatalf("error removing file: %v", err)
+	}
+	if _, err := fs.OpenFile("/ONEMORE.TXT", os.O_CREATE|os.O_RDWR); err != nil {
+		t.Errorf("error creating file after removing one: %v", err)
+	}
+}
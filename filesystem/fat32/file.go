@@ -36,17 +36,17 @@ func (fl *File) Read(b []byte) (int, error) {
 	size := int(fl.fileSize) - int(fl.offset)
 	maxRead := size
 	file := fs.file
+	// if there is nothing left to read, just return EOF
+	if size <= 0 {
+		return totalRead, io.EOF
+	}
+
 	clusters, err := fs.getClusterList(fl.clusterLocation)
 	if err != nil {
 		return totalRead, fmt.Errorf("unable to get list of clusters for file: %v", err)
 	}
 	clusterIndex := 0
 
-	// if there is nothing left to read, just return EOF
-	if size <= 0 {
-		return totalRead, io.EOF
-	}
-
 	// we stop when we hit the lesser of
 	//   1- len(b)
 	//   2- file end
@@ -63,8 +63,8 @@ func (fl *File) Read(b []byte) (int, error) {
 		if remainder != 0 {
 			offset := int64(start) + int64(lastCluster-2)*int64(bytesPerCluster) + remainder
 			toRead := int64(bytesPerCluster) - remainder
-			if toRead > int64(len(b)) {
-				toRead = int64(len(b))
+			if toRead > int64(maxRead) {
+				toRead = int64(maxRead)
 			}
 			_, _ = file.ReadAt(b[0:toRead], offset+fs.start)
 			totalRead += int(toRead)
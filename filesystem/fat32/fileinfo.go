@@ -1,7 +1,9 @@
 package fat32
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -55,3 +57,34 @@ func (fi FileInfo) Size() int64 {
 func (fi FileInfo) Sys() interface{} {
 	return nil
 }
+
+// fileInfo returns the FileInfo for a directory entry
+func (de *directoryEntry) fileInfo() FileInfo {
+	shortName := de.filenameShort
+	if de.lowercaseShortname {
+		shortName = strings.ToLower(shortName)
+	}
+	fileExtension := de.fileExtension
+	if de.lowercaseExtension {
+		fileExtension = strings.ToLower(fileExtension)
+	}
+	if fileExtension != "" {
+		shortName = fmt.Sprintf("%s.%s", shortName, fileExtension)
+	}
+	var mode os.FileMode
+	switch {
+	case de.isSubdirectory:
+		mode = os.ModeDir
+	case de.isVolumeLabel:
+		// the volume label is not a file at all
+		mode = os.ModeIrregular
+	}
+	return FileInfo{
+		modTime:   de.modifyTime,
+		mode:      mode,
+		name:      de.filenameLong,
+		shortName: shortName,
+		size:      int64(de.fileSize),
+		isDir:     de.isSubdirectory,
+	}
+}
@@ -11,10 +11,11 @@ const msDosBootSectorSignature uint16 = 0x55aa
 
 // MsDosBootSector is the structure representing an msdos boot structure
 type msDosBootSector struct {
-	jumpInstruction    [3]byte    // JumpInstruction is the instruction set to jump to for booting
-	oemName            string     // OEMName is the 8-byte OEM Name
-	biosParameterBlock *dos71EBPB // BIOSParameterBlock is the FAT32 Extended BIOS Parameter Block
-	bootCode           []byte     // BootCode represents the actual boot code
+	jumpInstruction      [3]byte    // JumpInstruction is the instruction set to jump to for booting
+	oemName              string     // OEMName is the 8-byte OEM Name
+	biosParameterBlock   *dos71EBPB // BIOSParameterBlock is the FAT32 Extended BIOS Parameter Block
+	biosParameterBlock16 *dos40EBPB // BIOSParameterBlock16 is the FAT12 or FAT16 Extended BIOS Parameter Block, used instead of BIOSParameterBlock
+	bootCode             []byte     // BootCode represents the actual boot code
 }
 
 func (m *msDosBootSector) equal(a *msDosBootSector) bool {
@@ -25,6 +26,7 @@ func (m *msDosBootSector) equal(a *msDosBootSector) bool {
 		return true
 	}
 	return m.biosParameterBlock.equal(a.biosParameterBlock) &&
+		m.biosParameterBlock16.equal(a.biosParameterBlock16) &&
 		m.oemName == a.oemName &&
 		m.jumpInstruction == a.jumpInstruction &&
 		bytes.Equal(m.bootCode, a.bootCode)
@@ -40,12 +42,24 @@ func msDosBootSectorFromBytes(b []byte) (*msDosBootSector, error) {
 	copy(bs.jumpInstruction[:], b[0:3])
 	// extract the OEM name
 	bs.oemName = string(b[3:11])
-	// extract the EBPB and its size
-	bpb, bpbSize, err := dos71EBPBFromBytes(b[11:90])
-	if err != nil {
-		return nil, fmt.Errorf("could not read FAT32 BIOS Parameter Block from boot sector: %v", err)
+	// extract the EBPB and its size. Only FAT12 and FAT16 have the sectors per FAT in the DOS 2.0 BPB,
+	// as FAT32 has it in its own EBPB
+	var bpbSize int
+	if binary.LittleEndian.Uint16(b[22:24]) != 0 {
+		bpb, size, err := dos40EBPBFromBytes(b[11:62])
+		if err != nil {
+			return nil, fmt.Errorf("could not read FAT12/FAT16 BIOS Parameter Block from boot sector: %v", err)
+		}
+		bs.biosParameterBlock16 = bpb
+		bpbSize = size
+	} else {
+		bpb, size, err := dos71EBPBFromBytes(b[11:90])
+		if err != nil {
+			return nil, fmt.Errorf("could not read FAT32 BIOS Parameter Block from boot sector: %v", err)
+		}
+		bs.biosParameterBlock = bpb
+		bpbSize = size
 	}
-	bs.biosParameterBlock = bpb
 
 	// we have the size of the EBPB, we can figure out the size of the boot code
 	bootSectorStart := 11 + bpbSize
@@ -81,9 +95,17 @@ func (m *msDosBootSector) toBytes() ([]byte, error) {
 	copy(b[3:11], oemName)
 
 	// bytes for the EBPB
-	bpbBytes, err := m.biosParameterBlock.toBytes()
+	var (
+		bpbBytes []byte
+		err      error
+	)
+	if m.biosParameterBlock16 != nil {
+		bpbBytes, err = m.biosParameterBlock16.toBytes()
+	} else {
+		bpbBytes, err = m.biosParameterBlock.toBytes()
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error getting FAT32 EBPB: %v", err)
+		return nil, fmt.Errorf("error getting EBPB: %v", err)
 	}
 	copy(b[11:], bpbBytes)
 	bpbLen := len(bpbBytes)
@@ -99,3 +121,11 @@ func (m *msDosBootSector) toBytes() ([]byte, error) {
 
 	return b, nil
 }
+
+// dos331BPB returns the DOS 3.31 BPB embedded in whichever EBPB the boot sector has
+func (m *msDosBootSector) dos331BPB() *dos331BPB {
+	if m.biosParameterBlock16 != nil {
+		return m.biosParameterBlock16.dos331BPB
+	}
+	return m.biosParameterBlock.dos331BPB
+}
@@ -5,8 +5,10 @@ import (
 	"reflect"
 )
 
-// table a FAT32 table
+// table a FAT12, FAT16 or FAT32 table. Entries are kept as they are on disk, so the end of chain and other
+// special values depend on the width of the entries
 type table struct {
+	fatType        FatType
 	fatID          uint32
 	eocMarker      uint32
 	unusedMarker   uint32
@@ -23,7 +25,8 @@ func (t *table) equal(a *table) bool {
 	if t == nil && a == nil {
 		return true
 	}
-	return t.fatID == a.fatID &&
+	return t.fatType == a.fatType &&
+		t.fatID == a.fatID &&
 		t.eocMarker == a.eocMarker &&
 		t.rootDirCluster == a.rootDirCluster &&
 		t.size == a.size &&
@@ -33,23 +36,28 @@ func (t *table) equal(a *table) bool {
 
 /*
   when reading from disk, remember that *any* of the following is a valid eocMarker:
-  0x?ffffff8 - 0x?fffffff
+  0x?ffffff8 - 0x?fffffff for FAT32
+  0xfff8 - 0xffff for FAT16
+  0xff8 - 0xfff for FAT12
 */
 
-func tableFromBytes(b []byte) *table {
+func tableFromBytes(b []byte, fatType FatType) *table {
 	t := table{
-		fatID:          binary.LittleEndian.Uint32(b[0:4]),
-		eocMarker:      binary.LittleEndian.Uint32(b[4:8]),
-		size:           uint32(len(b)),
-		clusters:       map[uint32]uint32{},
-		maxCluster:     uint32(len(b) / 4),
-		rootDirCluster: 2, // always 2 for FAT32
+		fatType:    fatType,
+		size:       uint32(len(b)),
+		clusters:   map[uint32]uint32{},
+		maxCluster: uint32(len(b)) * 8 / uint32(fatType),
+	}
+	t.fatID = t.entry(b, 0)
+	t.eocMarker = t.entry(b, 1)
+	// FAT32 keeps its root directory in a cluster, normally 2; FAT12 and FAT16 have a fixed root directory
+	// region, which is referred to as cluster 0
+	if fatType == FatType32 {
+		t.rootDirCluster = 2
 	}
 	// just need to map the clusters in
 	for i := uint32(2); i < t.maxCluster; i++ {
-		bStart := i * 4
-		bEnd := bStart + 4
-		val := binary.LittleEndian.Uint32(b[bStart:bEnd])
+		val := t.entry(b, i)
 		// 0 indicates an empty cluster, so we can ignore
 		if val != 0 {
 			t.clusters[i] = val
@@ -58,29 +66,69 @@ func tableFromBytes(b []byte) *table {
 	return &t
 }
 
-// bytes returns a FAT32 table as bytes ready to be written to disk
+// bytes returns a FAT table as bytes ready to be written to disk
 func (t *table) bytes() []byte {
 	b := make([]byte, t.size)
 
 	// FAT ID and fixed values
-	binary.LittleEndian.PutUint32(b[0:4], t.fatID)
+	t.putEntry(b, 0, t.fatID)
 	// End-of-Cluster marker
-	binary.LittleEndian.PutUint32(b[4:8], t.eocMarker)
+	t.putEntry(b, 1, t.eocMarker)
 	// now just clusters
 	numClusters := t.maxCluster
 	for i := uint32(2); i < numClusters; i++ {
-		bStart := i * 4
-		bEnd := bStart + 4
 		val := uint32(0)
 		if cluster, ok := t.clusters[i]; ok {
 			val = cluster
 		}
-		binary.LittleEndian.PutUint32(b[bStart:bEnd], val)
+		t.putEntry(b, i, val)
 	}
 
 	return b
 }
 
+// entry reads entry i of the table from its bytes. FAT12 packs two 12-bit entries into every 3 bytes.
+func (t *table) entry(b []byte, i uint32) uint32 {
+	switch t.fatType {
+	case FatType12:
+		val := uint32(binary.LittleEndian.Uint16(b[i*3/2:]))
+		if i%2 == 1 {
+			return val >> 4
+		}
+		return val & 0xfff
+	case FatType16:
+		return uint32(binary.LittleEndian.Uint16(b[i*2:]))
+	default:
+		return binary.LittleEndian.Uint32(b[i*4:])
+	}
+}
+
+// putEntry writes entry i of the table into its bytes, leaving any entry sharing a byte with it as it is
+func (t *table) putEntry(b []byte, i, val uint32) {
+	switch t.fatType {
+	case FatType12:
+		offset := i * 3 / 2
+		current := binary.LittleEndian.Uint16(b[offset:])
+		if i%2 == 1 {
+			current = current&0x000f | uint16(val&0xfff)<<4
+		} else {
+			current = current&0xf000 | uint16(val&0xfff)
+		}
+		binary.LittleEndian.PutUint16(b[offset:], current)
+	case FatType16:
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(val))
+	default:
+		binary.LittleEndian.PutUint32(b[i*4:], val)
+	}
+}
+
 func (t *table) isEoc(cluster uint32) bool {
-	return cluster&0xFFFFFF8 == 0xFFFFFF8
+	switch t.fatType {
+	case FatType12:
+		return cluster&0xff8 == 0xff8
+	case FatType16:
+		return cluster&0xfff8 == 0xfff8
+	default:
+		return cluster&0xFFFFFF8 == 0xFFFFFF8
+	}
 }
@@ -2,6 +2,7 @@ package fat32
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"sort"
 	"testing"
@@ -21,6 +22,7 @@ func getValidFat32Table() *table {
 	// directory "\" is at cluster 2 (first data cluster) at byte 0x02b800 = 178176
 	// directory "\foo" is at cluster 3 (second data cluster)
 	return &table{
+		fatType:   FatType32,
 		fatID:     268435448, // 0x0ffffff8
 		eocMarker: eoc,       // 0x0fffffff
 		clusters: map[uint32]uint32{
@@ -163,7 +165,7 @@ func TestFat32TableFromBytes(t *testing.T) {
 			t.Fatalf("error reading test fixture data from %s: %v", Fat32File, err)
 		}
 		b := input[16384 : 158*512+16384]
-		table := tableFromBytes(b)
+		table := tableFromBytes(b, FatType32)
 		if table == nil {
 			t.Fatalf("returned FAT32 Table was nil unexpectedly")
 		}
@@ -231,3 +233,47 @@ func TestFat32TableIsEoc(t *testing.T) {
 		}
 	}
 }
+
+func TestFat1216TableBytes(t *testing.T) {
+	tests := []struct {
+		fatType FatType
+		eoc     uint32
+		// bytes for entries 0 to 4, with 2 pointing to 4 and 4 at the end of chain
+		b []byte
+	}{
+		{FatType12, 0xfff, []byte{0xf8, 0xff, 0xff, 0x04, 0x00, 0x00, 0xff, 0x0f}},
+		{FatType16, 0xffff, []byte{0xf8, 0xff, 0xff, 0xff, 0x04, 0x00, 0x00, 0x00, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("FAT%d", tt.fatType), func(t *testing.T) {
+			b := make([]byte, 512)
+			copy(b, tt.b)
+			tab := tableFromBytes(b, tt.fatType)
+			expected := &table{
+				fatType:    tt.fatType,
+				fatID:      tt.eoc&0xff00 | 0xf8,
+				eocMarker:  tt.eoc,
+				clusters:   map[uint32]uint32{2: 4, 4: tt.eoc},
+				size:       512,
+				maxCluster: 512 * 8 / uint32(tt.fatType),
+			}
+			if !tab.equal(expected) {
+				t.Errorf("mismatched table, actual %#v expected %#v", tab, expected)
+			}
+			if !tab.isEoc(tab.clusters[4]) || tab.isEoc(tab.clusters[2]) {
+				t.Errorf("end of chain not recognized")
+			}
+			if out := tab.bytes(); !bytes.Equal(out, b) {
+				t.Errorf("mismatched bytes % x", out[:len(tt.b)])
+			}
+			// the last entry must fit, and setting it must not disturb its neighbour
+			last := tab.maxCluster - 1
+			tab.clusters[last-1] = tt.eoc
+			tab.clusters[last] = 2
+			reread := tableFromBytes(tab.bytes(), tt.fatType)
+			if reread.clusters[last-1] != tt.eoc || reread.clusters[last] != 2 {
+				t.Errorf("last entries %x, %x instead of %x, 2", reread.clusters[last-1], reread.clusters[last], tt.eoc)
+			}
+		})
+	}
+}
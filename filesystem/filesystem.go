@@ -3,9 +3,18 @@
 package filesystem
 
 import (
+	"errors"
 	"os"
 )
 
+// ErrReadonlyFilesystem is returned, wrapped, for any attempt to change a filesystem that cannot be changed,
+// such as a finalized iso9660 or squashfs filesystem. Check for it with errors.Is.
+var ErrReadonlyFilesystem = errors.New("read-only filesystem")
+
+// ErrXattrNotExist is returned, wrapped, when getting or removing an extended attribute that a file does not have.
+// Check for it with errors.Is.
+var ErrXattrNotExist = errors.New("extended attribute does not exist")
+
 // FileSystem is a reference to a single filesystem on a disk
 type FileSystem interface {
 	// Type return the type of filesystem
@@ -16,12 +25,81 @@ type FileSystem interface {
 	ReadDir(string) ([]os.FileInfo, error)
 	// OpenFile open a handle to read or write to a file
 	OpenFile(string, int) (File, error)
+	// Stat get the information for a file or directory
+	Stat(string) (os.FileInfo, error)
+	// Remove remove a file or empty directory
+	Remove(string) error
+	// RemoveAll remove a file or directory and everything in it. It does not return an error if the path
+	// does not exist.
+	RemoveAll(string) error
+	// Rename rename or move a file or directory from the first path to the second, replacing any file
+	// already there
+	Rename(string, string) error
+	// Truncate change the size of a file, filling with zeroes if it grows
+	Truncate(string, int64) error
 	// Label get the label for the filesystem, or "" if none. Be careful to trim it, as it may contain
 	// leading or following whitespace. The label is passed as-is and not cleaned up at all.
 	Label() string
 	// SetLabel changes the label on the writable filesystem. Different file system may hav different
 	// length constraints.
 	SetLabel(string) error
+	// Statfs get the capacity of the filesystem and how much of it is used
+	Statfs() (Statfs, error)
+}
+
+// Statfs is the capacity of a filesystem, as returned by FileSystem.Statfs(). Sizes are in bytes.
+type Statfs struct {
+	// BlockSize the size of the unit in which space is allocated, such as a FAT cluster
+	BlockSize int64
+	// TotalBytes the size of the filesystem, or 0 for one that is built in a workspace and has no size until it is finalized
+	TotalBytes int64
+	// FreeBytes how much more can be written. Always 0 for a read-only filesystem.
+	FreeBytes int64
+	// UsedBytes how much is used, which is TotalBytes less FreeBytes for a filesystem that has a size
+	UsedBytes int64
+	// Files the number of files, directories and other inodes, or 0 for filesystems that do not count them
+	Files uint64
+	// FreeFiles how many more files can be created, or 0 for filesystems that do not limit them
+	FreeFiles uint64
+}
+
+// SymlinkFileSystem is a FileSystem that supports symbolic links. Not every filesystem does, so check for it
+// with a type assertion:
+//
+//	if sfs, ok := fs.(filesystem.SymlinkFileSystem); ok {
+//		target, err := sfs.Readlink("/boot/vmlinuz")
+//	}
+//
+// Stat follows a symlink at the end of the path; Lstat does not.
+type SymlinkFileSystem interface {
+	FileSystem
+	// Symlink create newname as a symbolic link to oldname. oldname is stored as given, and need
+	// not exist.
+	Symlink(oldname, newname string) error
+	// Readlink get the target of a symbolic link
+	Readlink(string) (string, error)
+	// Lstat get the information for a file, directory or symbolic link, without following the link
+	Lstat(string) (os.FileInfo, error)
+}
+
+// XattrFileSystem is a FileSystem that supports extended attributes, such as security.capability or
+// security.selinux. Not every filesystem does, so check for it with a type assertion:
+//
+//	if xfs, ok := fs.(filesystem.XattrFileSystem); ok {
+//		err := xfs.Setxattr("/usr/bin/ping", "security.capability", capability)
+//	}
+//
+// Extended attributes are those of the path itself; a symlink is not followed.
+type XattrFileSystem interface {
+	FileSystem
+	// Getxattr get the value of the named extended attribute
+	Getxattr(path, name string) ([]byte, error)
+	// Listxattr get the names of all of the extended attributes, sorted
+	Listxattr(path string) ([]string, error)
+	// Setxattr set the named extended attribute, replacing any existing value
+	Setxattr(path, name string, value []byte) error
+	// Removexattr remove the named extended attribute
+	Removexattr(path, name string) error
 }
 
 // Type represents the type of disk this is
@@ -34,4 +112,18 @@ const (
 	TypeISO9660
 	// TypeSquashfs is a squashfs filesystem
 	TypeSquashfs
+	// TypeExFAT is an exFAT filesystem
+	TypeExFAT
+	// TypeExt4 is an ext4 filesystem, or an ext2 or ext3 one
+	TypeExt4
+	// TypeEROFS is an EROFS filesystem
+	TypeEROFS
+	// TypeNTFS is an NTFS filesystem
+	TypeNTFS
+	// TypeBtrfs is a btrfs filesystem
+	TypeBtrfs
+	// TypeXFS is an XFS filesystem
+	TypeXFS
+	// TypeUDF is a UDF filesystem
+	TypeUDF
 )
@@ -0,0 +1,211 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS is a read-only view of a FileSystem as an io/fs.FS, so it can be used with anything in the
+// standard library that accepts one, like http.FS, template.ParseFS, fs.WalkDir and fs.Glob.
+//
+// As with every io/fs.FS, paths are unrooted and slash-separated, e.g. "boot/grub/grub.cfg",
+// with "." for the root of the filesystem. Names are looked up with Stat of the FileSystem, so they match
+// as it matches them.
+//
+// FS implements fs.ReadDirFS and fs.StatFS as well. Files opened from it implement io.Seeker.
+type FS struct {
+	fsys FileSystem
+}
+
+// NewFS returns an FS that reads from fsys
+func NewFS(fsys FileSystem) *FS {
+	return &FS{fsys: fsys}
+}
+
+// Open opens the named file or directory for reading
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dir{fsys: f, name: name, info: info}, nil
+	}
+	file, err := f.fsys.OpenFile(absPath(name), os.O_RDONLY)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &openFile{file: file, info: info}, nil
+}
+
+// Stat returns the fs.FileInfo for the named file or directory
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return f.stat("stat", name)
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := f.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return f.readDir("readdir", name)
+}
+
+// stat looks up name with Stat of the FileSystem. Filesystems take a backslash as a separator as well, which
+// io/fs does not, so a name with one is not valid.
+func (f *FS) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) || strings.Contains(name, `\`) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return rootInfo{}, nil
+	}
+	info, err := f.fsys.Stat(absPath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fileInfo{info}, nil
+}
+
+// readDir reads the entries of the directory name, which must exist
+func (f *FS) readDir(op, name string) ([]fs.DirEntry, error) {
+	infos, err := f.fsys.ReadDir(absPath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		// some filesystems list the current and parent directories, or entries that are not
+		// files at all, like the FAT volume label
+		if n := info.Name(); n == "." || n == ".." || n == "" || info.Mode()&fs.ModeIrregular != 0 {
+			continue
+		}
+		entries = append(entries, dirEntry{fileInfo{info}})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// absPath converts an io/fs path to the rooted path a FileSystem expects
+func absPath(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+// fileInfo makes sure the mode of a directory has fs.ModeDir set, as some filesystems only
+// report it from IsDir
+type fileInfo struct {
+	os.FileInfo
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	mode := fi.FileInfo.Mode()
+	if fi.FileInfo.IsDir() {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
+
+// rootInfo is the fs.FileInfo for ".", which no FileSystem lists
+type rootInfo struct{}
+
+func (rootInfo) Name() string       { return "." }
+func (rootInfo) Size() int64        { return 0 }
+func (rootInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (rootInfo) ModTime() time.Time { return time.Time{} }
+func (rootInfo) IsDir() bool        { return true }
+func (rootInfo) Sys() interface{}   { return nil }
+
+// dirEntry is an fs.DirEntry built from the os.FileInfo returned by ReadDir
+type dirEntry struct {
+	info fs.FileInfo
+}
+
+func (d dirEntry) Name() string               { return d.info.Name() }
+func (d dirEntry) IsDir() bool                { return d.info.IsDir() }
+func (d dirEntry) Type() fs.FileMode          { return d.info.Mode().Type() }
+func (d dirEntry) Info() (fs.FileInfo, error) { return d.info, nil }
+
+// openFile is a regular file opened from an FS
+type openFile struct {
+	file File
+	info fs.FileInfo
+}
+
+func (o *openFile) Stat() (fs.FileInfo, error) {
+	return o.info, nil
+}
+
+func (o *openFile) Read(b []byte) (int, error) {
+	return o.file.Read(b)
+}
+
+func (o *openFile) Seek(offset int64, whence int) (int64, error) {
+	// filesystems do not all agree on which way an offset from the end goes, so resolve it here
+	if whence == io.SeekEnd {
+		offset, whence = o.info.Size()+offset, io.SeekStart
+	}
+	return o.file.Seek(offset, whence)
+}
+
+func (o *openFile) Close() error {
+	return o.file.Close()
+}
+
+// dir is a directory opened from an FS
+type dir struct {
+	fsys    *FS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	// read is true once entries has been loaded
+	read bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries in the directory, or all remaining ones if n <= 0
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.readDir("readdir", d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,151 @@
+package filesystem_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// populate creates the files in contents, with their directories, on fsys
+func populate(t *testing.T, fsys filesystem.FileSystem, contents map[string]string) {
+	t.Helper()
+	for p, content := range contents {
+		if err := fsys.Mkdir(path.Dir(p)); err != nil {
+			t.Fatalf("unable to make directory for %s: %v", p, err)
+		}
+		f, err := fsys.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("unable to create %s: %v", p, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("unable to write %s: %v", p, err)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	contents := map[string]string{
+		"/README.TXT":         "read me",
+		"/BOOT/GRUB/GRUB.CFG": "menuentry",
+		"/BOOT/VMLINUZ":       "kernel",
+		"/EMPTY.TXT":          "",
+	}
+	expected := []string{"BOOT", "BOOT/GRUB", "BOOT/GRUB/GRUB.CFG", "BOOT/VMLINUZ", "EMPTY.TXT", "README.TXT"}
+
+	tests := []struct {
+		name   string
+		create func(t *testing.T) filesystem.FileSystem
+	}{
+		{"fat32", func(t *testing.T) filesystem.FileSystem {
+			size := int64(40 * 1024 * 1024)
+			// with a label, which is an entry in the root directory that is not a file
+			fsys, err := fat32.Create(util.NewMemFile(size), size, 0, 512, "DISKFS")
+			if err != nil {
+				t.Fatalf("unable to create filesystem: %v", err)
+			}
+			populate(t, fsys, contents)
+			return fsys
+		}},
+		{"squashfs", func(t *testing.T) filesystem.FileSystem {
+			m := util.NewMemFile(0)
+			fsys, err := squashfs.Create(m, 0, 0, 4096)
+			if err != nil {
+				t.Fatalf("unable to create filesystem: %v", err)
+			}
+			populate(t, fsys, contents)
+			if err := fsys.Finalize(squashfs.FinalizeOptions{}); err != nil {
+				t.Fatalf("unable to finalize: %v", err)
+			}
+			fsys, err = squashfs.Read(m, m.Size(), 0, 4096)
+			if err != nil {
+				t.Fatalf("unable to read filesystem: %v", err)
+			}
+			return fsys
+		}},
+		{"iso9660", func(t *testing.T) filesystem.FileSystem {
+			m := util.NewMemFile(0)
+			fsys, err := iso9660.Create(m, 0, 0, 2048, "")
+			if err != nil {
+				t.Fatalf("unable to create filesystem: %v", err)
+			}
+			populate(t, fsys, contents)
+			if err := fsys.Finalize(iso9660.FinalizeOptions{}); err != nil {
+				t.Fatalf("unable to finalize: %v", err)
+			}
+			fsys, err = iso9660.Read(m, m.Size(), 0, 2048)
+			if err != nil {
+				t.Fatalf("unable to read filesystem: %v", err)
+			}
+			return fsys
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := filesystem.NewFS(tt.create(t))
+			if err := fstest.TestFS(fsys, expected...); err != nil {
+				t.Fatal(err)
+			}
+
+			var walked []string
+			err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if p != "." {
+					walked = append(walked, p)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unable to walk: %v", err)
+			}
+			sort.Strings(walked)
+			if len(walked) != len(expected) {
+				t.Errorf("walked %v instead of %v", walked, expected)
+			}
+
+			matches, err := fs.Glob(fsys, "BOOT/*")
+			if err != nil {
+				t.Fatalf("unable to glob: %v", err)
+			}
+			if len(matches) != 2 || matches[0] != "BOOT/GRUB" || matches[1] != "BOOT/VMLINUZ" {
+				t.Errorf("glob matched %v", matches)
+			}
+
+			b, err := fs.ReadFile(fsys, "BOOT/GRUB/GRUB.CFG")
+			if err != nil {
+				t.Fatalf("unable to read file: %v", err)
+			}
+			if string(b) != "menuentry" {
+				t.Errorf("read %q instead of %q", b, "menuentry")
+			}
+
+			for _, name := range []string{"MISSING", "BOOT/MISSING", "README.TXT/FOO", "MISSING/FOO"} {
+				if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("open %s returned %v instead of ErrNotExist", name, err)
+				}
+				if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("stat %s returned %v instead of ErrNotExist", name, err)
+				}
+			}
+			for _, name := range []string{"/README.TXT", "BOOT/", "../BOOT"} {
+				if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+					t.Errorf("open %s returned %v instead of ErrInvalid", name, err)
+				}
+			}
+			if _, err := fsys.ReadDir("README.TXT"); err == nil {
+				t.Errorf("expected error reading a file as a directory")
+			}
+		})
+	}
+}
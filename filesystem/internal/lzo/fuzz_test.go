@@ -0,0 +1,39 @@
+//go:build go1.18
+// +build go1.18
+
+package lzo
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzDecompress checks that no input makes Decompress panic, or return more than it was asked for
+func FuzzDecompress(f *testing.F) {
+	for _, data := range testLZOData() {
+		f.Add(lzo1xCompress(data), uint32(len(data)))
+	}
+	f.Fuzz(func(t *testing.T, in []byte, size uint32) {
+		size %= 1024 * 1024
+		out, err := Decompress(in, int(size))
+		if err == nil && len(out) > int(size) {
+			t.Errorf("decompressed %d bytes, more than the %d asked for", len(out), size)
+		}
+	})
+}
+
+// FuzzRoundTrip checks that Decompress restores what the test compressor compresses
+func FuzzRoundTrip(f *testing.F) {
+	for _, data := range testLZOData() {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := Decompress(lzo1xCompress(data), len(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("decompressed %d bytes did not match the original %d", len(out), len(data))
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package lzo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// This is a decompressor for LZO1X, as the kernel compresses it, which btrfs uses for each sector of an extent
+// compressed with lzo. It follows the description in the kernel of the format:
+// https://docs.kernel.org/staging/lzo.html
+
+var ErrCorrupt = errors.New("corrupt lzo data")
+
+// lzo1xDecompress decompresses a block of LZO1X data, which must decompress to at most size bytes
+func Decompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	pos := 0
+	// state is the number of literals copied by the last instruction, or 4 for 4 or more
+	state := 0
+
+	// literals copies n literals from the input to the output
+	literals := func(n int) error {
+		if pos+n > len(in) {
+			return fmt.Errorf("lzo literals overflow the input: %w", ErrCorrupt)
+		}
+		if len(out)+n > size {
+			return fmt.Errorf("lzo literals overflow the output of %d bytes: %w", size, ErrCorrupt)
+		}
+		out = append(out, in[pos:pos+n]...)
+		pos += n
+		return nil
+	}
+	// length reads a length that continues in the next bytes, as a count of zero bytes worth 255 each and then a
+	// byte that is not zero
+	length := func(base int) (int, error) {
+		n := base
+		for pos < len(in) && in[pos] == 0 {
+			n += 255
+			pos++
+		}
+		if pos >= len(in) {
+			return 0, fmt.Errorf("lzo length overflows the input: %w", ErrCorrupt)
+		}
+		n += int(in[pos])
+		pos++
+		return n, nil
+	}
+
+	if len(in) < 3 {
+		return nil, fmt.Errorf("lzo data of %d bytes is too short: %w", len(in), ErrCorrupt)
+	}
+	// the first byte may be a run of literals
+	if in[0] > 17 {
+		n := int(in[0]) - 17
+		pos++
+		if err := literals(n); err != nil {
+			return nil, err
+		}
+		state = n
+		if n > 3 {
+			state = 4
+		}
+	}
+
+	for {
+		if pos >= len(in) {
+			return nil, fmt.Errorf("lzo data ends without an end marker: %w", ErrCorrupt)
+		}
+		t := int(in[pos])
+		pos++
+		var distance, count, next int
+		switch {
+		case t >= 64:
+			// M2: 3 to 8 bytes within 2KB
+			if pos >= len(in) {
+				return nil, fmt.Errorf("lzo match overflows the input: %w", ErrCorrupt)
+			}
+			distance = int(in[pos])<<3 + (t>>2)&7 + 1
+			pos++
+			count = t>>5 + 1
+			next = t & 3
+		case t >= 32:
+			// M3: within 16KB
+			count = t&31 + 2
+			if count == 2 {
+				n, err := length(31)
+				if err != nil {
+					return nil, err
+				}
+				count += n
+			}
+			if pos+2 > len(in) {
+				return nil, fmt.Errorf("lzo match overflows the input: %w", ErrCorrupt)
+			}
+			v := int(binary.LittleEndian.Uint16(in[pos : pos+2]))
+			pos += 2
+			distance = v>>2 + 1
+			next = v & 3
+		case t >= 16:
+			// M4: within 16KB to 48KB, or the end of the data
+			count = t&7 + 2
+			if count == 2 {
+				n, err := length(7)
+				if err != nil {
+					return nil, err
+				}
+				count += n
+			}
+			if pos+2 > len(in) {
+				return nil, fmt.Errorf("lzo match overflows the input: %w", ErrCorrupt)
+			}
+			v := int(binary.LittleEndian.Uint16(in[pos : pos+2]))
+			pos += 2
+			distance = (t&8)<<11 + v>>2
+			next = v & 3
+			if distance == 0 {
+				if count != 3 {
+					return nil, fmt.Errorf("lzo end marker has length %d: %w", count, ErrCorrupt)
+				}
+				if pos != len(in) {
+					return nil, fmt.Errorf("lzo data has %d bytes after the end marker: %w", len(in)-pos, ErrCorrupt)
+				}
+				return out, nil
+			}
+			distance += 16384
+		case state == 0:
+			// a long run of literals
+			count = t + 3
+			if count == 3 {
+				n, err := length(15)
+				if err != nil {
+					return nil, err
+				}
+				count += n
+			}
+			if err := literals(count); err != nil {
+				return nil, err
+			}
+			state = 4
+			continue
+		default:
+			// M1: 2 bytes within 1KB after a few literals, or 3 bytes within 2KB to 3KB after more
+			if pos >= len(in) {
+				return nil, fmt.Errorf("lzo match overflows the input: %w", ErrCorrupt)
+			}
+			distance = t>>2 + int(in[pos])<<2 + 1
+			pos++
+			count = 2
+			if state == 4 {
+				distance += 2048
+				count = 3
+			}
+			next = t & 3
+		}
+
+		if distance > len(out) {
+			return nil, fmt.Errorf("lzo match at distance %d is before the start of the output: %w", distance, ErrCorrupt)
+		}
+		if len(out)+count > size {
+			return nil, fmt.Errorf("lzo match overflows the output of %d bytes: %w", size, ErrCorrupt)
+		}
+		start := len(out) - distance
+		for i := 0; i < count; i++ {
+			out = append(out, out[start+i])
+		}
+		if err := literals(next); err != nil {
+			return nil, err
+		}
+		state = next
+	}
+}
@@ -0,0 +1,188 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// lzo1xCompress is a simple LZO1X compressor for tests, which finds matches of at least 4 bytes with a hash of
+// the last place each 4 bytes were seen
+func lzo1xCompress(in []byte) []byte {
+	var (
+		out    []byte
+		last   = map[uint32]int{}
+		lit    = 0
+		sIndex = -1
+	)
+	// extra writes a length that does not fit in its instruction
+	extra := func(n int) {
+		for n > 255 {
+			out = append(out, 0)
+			n -= 255
+		}
+		out = append(out, byte(n))
+	}
+	// literals writes the literals from lit to end
+	literals := func(end int) {
+		n := end - lit
+		switch {
+		case n == 0:
+		case len(out) == 0 && n <= 238:
+			out = append(out, byte(17+n))
+		case n <= 3:
+			out[sIndex] |= byte(n)
+		case n-3 <= 15:
+			out = append(out, byte(n-3))
+		default:
+			out = append(out, 0)
+			extra(n - 3 - 15)
+		}
+		out = append(out, in[lit:end]...)
+	}
+	i := 0
+	for i+4 <= len(in) {
+		key := binary.LittleEndian.Uint32(in[i : i+4])
+		prev, ok := last[key]
+		last[key] = i
+		distance := i - prev
+		if !ok || distance > 49151 {
+			i++
+			continue
+		}
+		length := 4
+		for i+length < len(in) && in[prev+length] == in[i+length] {
+			length++
+		}
+		literals(i)
+		switch {
+		case distance <= 2048 && length <= 8:
+			sIndex = len(out)
+			out = append(out, byte((length-1)<<5)|byte((distance-1)&7)<<2, byte((distance-1)>>3))
+		case distance <= 16384:
+			if length-2 <= 31 {
+				out = append(out, 32|byte(length-2))
+			} else {
+				out = append(out, 32)
+				extra(length - 2 - 31)
+			}
+			sIndex = len(out)
+			out = appendUint16(out, uint16((distance-1)<<2))
+		default:
+			d := distance - 16384
+			h := byte(d>>14) << 3
+			if length-2 <= 7 {
+				out = append(out, 16|h|byte(length-2))
+			} else {
+				out = append(out, 16|h)
+				extra(length - 2 - 7)
+			}
+			sIndex = len(out)
+			out = appendUint16(out, uint16((d&0x3fff)<<2))
+		}
+		i += length
+		lit = i
+	}
+	literals(len(in))
+	return append(out, 0x11, 0, 0)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func testLZOData() map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 20000)
+	r.Read(random)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 1000)
+	// matches far enough apart for each kind of match
+	far := make([]byte, 60000)
+	r.Read(far)
+	copy(far[20000:], far[0:100])
+	copy(far[40000:], far[30000:30300])
+	copy(far[50000:], far[49000:49006])
+	return map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abc"),
+		"text":   text,
+		"random": random,
+		"zeros":  make([]byte, 10000),
+		"far":    far,
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	for name, data := range testLZOData() {
+		t.Run(name, func(t *testing.T) {
+			out, err := Decompress(lzo1xCompress(data), len(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Errorf("decompressed %d bytes did not match the original %d", len(out), len(data))
+			}
+		})
+	}
+	// instructions the test compressor does not use
+	tests := []struct {
+		name string
+		in   []byte
+		out  []byte
+	}{
+		// 2 literals, then M1 copying 2 bytes from 2 back and 1 literal
+		{"short M1", []byte{19, 'a', 'b', 0x05, 0x00, 'c', 0x11, 0, 0}, []byte("ababc")},
+		// a run of 4 literals with state 0 at the start
+		{"long literals", []byte{0x01, 'a', 'b', 'c', 'd', 0x11, 0, 0}, []byte("abcd")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Decompress(tt.in, len(tt.out))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(out, tt.out) {
+				t.Errorf("mismatched output, actual %q expected %q", out, tt.out)
+			}
+		})
+	}
+	// a 3 byte M1 after a long run of 2049 literals, copying from 2049 back
+	long := bytes.Repeat([]byte{'x'}, 2049)
+	long[0] = 'y'
+	in := append([]byte{0}, make([]byte, 7)...)
+	in = append(in, 2049-3-15-255*7)
+	in = append(in, long...)
+	in = append(in, 0x00, 0x00, 0x11, 0, 0)
+	out, err := Decompress(in, 2052)
+	if err != nil {
+		t.Fatalf("long M1: unexpected error: %v", err)
+	}
+	if !bytes.Equal(out[2049:], []byte("yxx")) {
+		t.Errorf("long M1: mismatched output %q", out[2049:])
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		size int
+	}{
+		{"too short", []byte{0x11, 0}, 10},
+		{"no end marker", []byte{21, 'a', 'b', 'c', 'd'}, 10},
+		{"literals past the input", []byte{30, 'a', 'b', 0x11, 0, 0}, 20},
+		{"literals past the output", []byte{21, 'a', 'b', 'c', 'd', 0x11, 0, 0}, 2},
+		{"match before the start", []byte{19, 'a', 'b', 0x41, 0x10, 0x11, 0, 0}, 20},
+		{"data after the end", []byte{19, 'a', 'b', 0x11, 0, 0, 0}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decompress(tt.in, tt.size)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("mismatched error, actual %v expected %v", err, ErrCorrupt)
+			}
+		})
+	}
+}
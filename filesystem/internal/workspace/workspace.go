@@ -0,0 +1,126 @@
+// Package workspace is the workspace of a filesystem that is built in a directory and then finalized into an
+// image, such as iso9660, squashfs, erofs and udf. Until it is finalized, its files are read and changed in that
+// directory; once it is, it has no workspace, which these functions are given as "", and it is read-only.
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// Path returns the path of p in the workspace ws. p is cleaned as a rooted path first, so that however
+// many ".." it has, it never leaves the workspace.
+func Path(ws, p string) string {
+	return path.Join(ws, path.Clean("/"+p))
+}
+
+// Stat returns the FileInfo for p in the workspace ws, following a symlink at the end of p.
+func Stat(ws, p string) (os.FileInfo, error) {
+	return os.Stat(Path(ws, p))
+}
+
+// Lstat returns the FileInfo for p in the workspace ws, without following a symlink at the end of p.
+func Lstat(ws, p string) (os.FileInfo, error) {
+	return os.Lstat(Path(ws, p))
+}
+
+// Readlink returns the target of the symlink p in the workspace ws.
+func Readlink(ws, p string) (string, error) {
+	return os.Readlink(Path(ws, p))
+}
+
+// Symlink creates newname in the workspace ws as a symlink to oldname.
+func Symlink(ws, oldname, newname string) error {
+	if ws == "" {
+		return fmt.Errorf("cannot create symlink %s: %w", newname, filesystem.ErrReadonlyFilesystem)
+	}
+	if err := os.Symlink(oldname, Path(ws, newname)); err != nil {
+		return fmt.Errorf("could not create symlink %s: %w", newname, err)
+	}
+	return nil
+}
+
+// Remove removes a file or empty directory from the workspace ws.
+func Remove(ws, p string) error {
+	if ws == "" {
+		return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	// never remove the workspace itself
+	if path.Clean("/"+p) == "/" {
+		return fmt.Errorf("cannot remove root directory")
+	}
+	if err := os.Remove(Path(ws, p)); err != nil {
+		return fmt.Errorf("could not remove %s: %w", p, err)
+	}
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it, from the workspace ws. It returns nil if the
+// path does not exist.
+func RemoveAll(ws, p string) error {
+	if ws == "" {
+		return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	// never remove the workspace itself
+	if path.Clean("/"+p) == "/" {
+		return fmt.Errorf("cannot remove root directory")
+	}
+	if err := os.RemoveAll(Path(ws, p)); err != nil {
+		return fmt.Errorf("could not remove %s: %w", p, err)
+	}
+	return nil
+}
+
+// Rename renames or moves a file or directory in the workspace ws.
+func Rename(ws, oldpath, newpath string) error {
+	if ws == "" {
+		return fmt.Errorf("cannot rename %s: %w", oldpath, filesystem.ErrReadonlyFilesystem)
+	}
+	if err := os.Rename(Path(ws, oldpath), Path(ws, newpath)); err != nil {
+		return fmt.Errorf("could not rename %s to %s: %w", oldpath, newpath, err)
+	}
+	return nil
+}
+
+// Truncate changes the size of a file in the workspace ws.
+func Truncate(ws, p string, size int64) error {
+	if ws == "" {
+		return fmt.Errorf("cannot truncate %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	if err := os.Truncate(Path(ws, p), size); err != nil {
+		return fmt.Errorf("could not truncate %s: %w", p, err)
+	}
+	return nil
+}
+
+// Statfs returns the capacity of a filesystem that is still in the workspace ws. It has no capacity of its own
+// until it is finalized, as the image is sized to fit whatever is in the workspace then, so TotalBytes and
+// FreeBytes are 0. UsedBytes is the size of the files in the workspace, each rounded up to a whole block of
+// blocksize, without the metadata that finalizing adds or any compression, and Files counts every file,
+// directory and symlink, including the root directory.
+func Statfs(ws string, blocksize int64) (filesystem.Statfs, error) {
+	var used int64
+	var files uint64
+	err := filepath.Walk(ws, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files++
+		if info.Mode().IsRegular() {
+			used += (info.Size() + blocksize - 1) / blocksize * blocksize
+		}
+		return nil
+	})
+	if err != nil {
+		return filesystem.Statfs{}, fmt.Errorf("could not walk workspace %s: %w", ws, err)
+	}
+	return filesystem.Statfs{
+		BlockSize: blocksize,
+		UsedBytes: used,
+		Files:     files,
+	}, nil
+}
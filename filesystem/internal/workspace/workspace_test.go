@@ -0,0 +1,92 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		p        string
+		expected string
+	}{
+		{"/", "/ws"},
+		{"", "/ws"},
+		{"/a/b", "/ws/a/b"},
+		{"a/b", "/ws/a/b"},
+		{"/../../etc/passwd", "/ws/etc/passwd"},
+		{"a/../../b", "/ws/b"},
+	}
+	for _, tt := range tests {
+		if p := Path("/ws", tt.p); p != tt.expected {
+			t.Errorf("Path(%q) = %q instead of %q", tt.p, p, tt.expected)
+		}
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"Symlink", func() error { return Symlink("", "a", "/b") }},
+		{"Remove", func() error { return Remove("", "/a") }},
+		{"RemoveAll", func() error { return RemoveAll("", "/a") }},
+		{"Rename", func() error { return Rename("", "/a", "/b") }},
+		{"Truncate", func() error { return Truncate("", "/a", 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+				t.Errorf("returned %v instead of an error wrapping ErrReadonlyFilesystem", err)
+			}
+		})
+	}
+}
+
+func TestRemoveRoot(t *testing.T) {
+	ws := t.TempDir()
+	for _, p := range []string{"/", "", "/a/..", "/.."} {
+		if err := Remove(ws, p); err == nil {
+			t.Errorf("Remove(%q) did not return an error", p)
+		}
+		if err := RemoveAll(ws, p); err == nil {
+			t.Errorf("RemoveAll(%q) did not return an error", p)
+		}
+	}
+	if _, err := os.Stat(ws); err != nil {
+		t.Errorf("workspace was removed: %v", err)
+	}
+}
+
+func TestStatfs(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.Mkdir(filepath.Join(ws, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "dir", "file"), make([]byte, 5000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "empty"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Symlink(ws, "dir/file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := Statfs(ws, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the root, the directory, both files and the symlink; only the file with data takes blocks, 3 of them
+	expected := filesystem.Statfs{BlockSize: 2048, UsedBytes: 6144, Files: 5}
+	if stat != expected {
+		t.Errorf("%+v instead of %+v", stat, expected)
+	}
+	if _, err := Statfs(filepath.Join(ws, "missing"), 2048); err == nil {
+		t.Errorf("did not return an error for a missing workspace")
+	}
+}
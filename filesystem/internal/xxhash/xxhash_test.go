@@ -0,0 +1,20 @@
+package xxhash
+
+import "testing"
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		in  string
+		sum uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range tests {
+		if sum := Sum64([]byte(tt.in)); sum != tt.sum {
+			t.Errorf("%q: mismatched sum, actual %#x expected %#x", tt.in, sum, tt.sum)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package zstd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzDecompress checks that no input makes Decompress panic, or return more than it was asked for
+func FuzzDecompress(f *testing.F) {
+	seeds, err := filepath.Glob("testdata/*.zst")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, p := range seeds {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b, uint32(len(b)*8))
+	}
+	f.Fuzz(func(t *testing.T, in []byte, size uint32) {
+		size %= 1024 * 1024
+		out, err := Decompress(in, int(size))
+		if err == nil && len(out) > int(size) {
+			t.Errorf("decompressed %d bytes, more than the %d asked for", len(out), size)
+		}
+	})
+}
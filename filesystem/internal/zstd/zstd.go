@@ -0,0 +1,839 @@
+// Package zstd is a decoder for the zstd format, as described in RFC 8878, enough to read the data btrfs
+// compresses with zstd: single frames without a dictionary, whose window is at most 128 KB.
+//
+// references:
+//
+//	https://www.rfc-editor.org/rfc/rfc8878
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/diskfs/go-diskfs/filesystem/internal/xxhash"
+)
+
+const (
+	zstdMagic         uint32 = 0xfd2fb528
+	zstdSkippableMask uint32 = 0xfffffff0
+	zstdSkippableBase uint32 = 0x184d2a50
+	zstdMaxBlockSize         = 128 * 1024
+	// the largest accuracy of the tables of each kind of symbol
+	zstdMaxLiteralsLog    = 9
+	zstdMaxMatchLog       = 9
+	zstdMaxOffsetLog      = 8
+	zstdMaxHuffmanBits    = 11
+	zstdMaxHuffmanWeights = 255
+	zstdMaxWeightsLog     = 6
+)
+
+// block types
+const (
+	zstdBlockRaw        = 0
+	zstdBlockRLE        = 1
+	zstdBlockCompressed = 2
+)
+
+// literals section types
+const (
+	zstdLiteralsRaw        = 0
+	zstdLiteralsRLE        = 1
+	zstdLiteralsCompressed = 2
+	zstdLiteralsTreeless   = 3
+)
+
+// modes of the tables for sequences
+const (
+	zstdModePredefined = 0
+	zstdModeRLE        = 1
+	zstdModeCompressed = 2
+	zstdModeRepeat     = 3
+)
+
+// the baselines and numbers of extra bits of literal length and match length codes
+var (
+	zstdLiteralsBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLiteralsBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMatchBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMatchBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// the distributions of the predefined tables
+var (
+	zstdPredefinedLiterals = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdPredefinedMatch = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdPredefinedOffset = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+// ErrCorrupt is wrapped by the errors for data that is not valid zstd
+var ErrCorrupt = errors.New("corrupt zstd data")
+
+// Decompress decompresses the zstd frames in in until it has size bytes. Anything after that, such as the
+// padding of the rest of a sector, is ignored.
+func Decompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for len(out) < size {
+		if len(in) < 4 {
+			return nil, fmt.Errorf("zstd data ends after %d of %d bytes", len(out), size)
+		}
+		magic := binary.LittleEndian.Uint32(in[0:4])
+		if magic&zstdSkippableMask == zstdSkippableBase {
+			if len(in) < 8 {
+				return nil, fmt.Errorf("skippable zstd frame is cut short: %w", ErrCorrupt)
+			}
+			skip := 8 + int64(binary.LittleEndian.Uint32(in[4:8]))
+			if skip > int64(len(in)) {
+				return nil, fmt.Errorf("skippable zstd frame of %d bytes overflows the data: %w", skip, ErrCorrupt)
+			}
+			in = in[skip:]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, fmt.Errorf("zstd frame had magic %#x instead of expected %#x", magic, zstdMagic)
+		}
+		d := &zstdDecoder{out: out, frameStart: len(out)}
+		n, err := d.decodeFrame(in[4:])
+		if err != nil {
+			return nil, err
+		}
+		out = d.out
+		in = in[4+n:]
+	}
+	if len(out) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// zstdDecoder holds the state of a frame as it is decoded, which carries over from one block to the next
+type zstdDecoder struct {
+	out        []byte
+	frameStart int
+	reps       [3]int
+	huffman    *huffmanTable
+	literals   *fseTable
+	offsets    *fseTable
+	matches    *fseTable
+}
+
+// decodeFrame decodes a frame after its magic number and returns how many bytes it took
+func (d *zstdDecoder) decodeFrame(in []byte) (int, error) {
+	if len(in) < 1 {
+		return 0, fmt.Errorf("zstd frame has no header: %w", ErrCorrupt)
+	}
+	descriptor := in[0]
+	pos := 1
+	sizeFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	hasChecksum := descriptor&0x4 != 0
+	dictFlag := descriptor & 0x3
+	if descriptor&0x8 != 0 {
+		return 0, fmt.Errorf("zstd frame header has reserved bit set: %w", ErrCorrupt)
+	}
+	if !singleSegment {
+		pos++
+	}
+	dictSize := [4]int{0, 1, 2, 4}[dictFlag]
+	sizeSize := [4]int{0, 2, 4, 8}[sizeFlag]
+	if sizeFlag == 0 && singleSegment {
+		sizeSize = 1
+	}
+	if pos+dictSize+sizeSize > len(in) {
+		return 0, fmt.Errorf("zstd frame header is cut short: %w", ErrCorrupt)
+	}
+	var dictID uint32
+	for i := 0; i < dictSize; i++ {
+		dictID |= uint32(in[pos+i]) << (8 * i)
+	}
+	if dictID != 0 {
+		return 0, fmt.Errorf("zstd frame needs dictionary %d, and dictionaries are not supported", dictID)
+	}
+	pos += dictSize + sizeSize
+	d.reps = [3]int{1, 4, 8}
+
+	for {
+		if pos+3 > len(in) {
+			return 0, fmt.Errorf("zstd block header is cut short: %w", ErrCorrupt)
+		}
+		header := uint32(in[pos]) | uint32(in[pos+1])<<8 | uint32(in[pos+2])<<16
+		pos += 3
+		last := header&1 != 0
+		blockType := (header >> 1) & 3
+		blockSize := int(header >> 3)
+		switch blockType {
+		case zstdBlockRaw:
+			if pos+blockSize > len(in) {
+				return 0, fmt.Errorf("raw zstd block of %d bytes is cut short: %w", blockSize, ErrCorrupt)
+			}
+			d.out = append(d.out, in[pos:pos+blockSize]...)
+			pos += blockSize
+		case zstdBlockRLE:
+			if pos >= len(in) {
+				return 0, fmt.Errorf("RLE zstd block is cut short: %w", ErrCorrupt)
+			}
+			for i := 0; i < blockSize; i++ {
+				d.out = append(d.out, in[pos])
+			}
+			pos++
+		case zstdBlockCompressed:
+			if blockSize > zstdMaxBlockSize || pos+blockSize > len(in) {
+				return 0, fmt.Errorf("compressed zstd block of %d bytes is too large or cut short: %w", blockSize, ErrCorrupt)
+			}
+			if err := d.decodeBlock(in[pos : pos+blockSize]); err != nil {
+				return 0, err
+			}
+			pos += blockSize
+		default:
+			return 0, fmt.Errorf("zstd block has reserved type: %w", ErrCorrupt)
+		}
+		if last {
+			break
+		}
+	}
+	if hasChecksum {
+		if pos+4 > len(in) {
+			return 0, fmt.Errorf("zstd frame checksum is cut short: %w", ErrCorrupt)
+		}
+		expected := binary.LittleEndian.Uint32(in[pos : pos+4])
+		if actual := uint32(xxhash.Sum64(d.out[d.frameStart:])); actual != expected {
+			return 0, fmt.Errorf("zstd frame checksum %#x does not match the expected %#x", actual, expected)
+		}
+		pos += 4
+	}
+	return pos, nil
+}
+
+// decodeBlock decodes a compressed block, which is its literals and then the sequences that put them together
+// with matches of what came before
+func (d *zstdDecoder) decodeBlock(in []byte) error {
+	literals, n, err := d.decodeLiterals(in)
+	if err != nil {
+		return err
+	}
+	return d.decodeSequences(in[n:], literals)
+}
+
+// decodeLiterals decodes the literals section of a block, and returns the literals and how many bytes it took
+func (d *zstdDecoder) decodeLiterals(in []byte) ([]byte, int, error) {
+	if len(in) < 1 {
+		return nil, 0, fmt.Errorf("zstd literals section is missing: %w", ErrCorrupt)
+	}
+	literalsType := in[0] & 3
+	sizeFormat := (in[0] >> 2) & 3
+	if literalsType == zstdLiteralsRaw || literalsType == zstdLiteralsRLE {
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, headerSize = int(in[0]>>3), 1
+		case 1:
+			if len(in) < 2 {
+				return nil, 0, fmt.Errorf("zstd literals header is cut short: %w", ErrCorrupt)
+			}
+			size, headerSize = int(in[0]>>4)|int(in[1])<<4, 2
+		case 3:
+			if len(in) < 3 {
+				return nil, 0, fmt.Errorf("zstd literals header is cut short: %w", ErrCorrupt)
+			}
+			size, headerSize = int(in[0]>>4)|int(in[1])<<4|int(in[2])<<12, 3
+		}
+		if literalsType == zstdLiteralsRLE {
+			if headerSize >= len(in) {
+				return nil, 0, fmt.Errorf("zstd RLE literals are cut short: %w", ErrCorrupt)
+			}
+			literals := make([]byte, size)
+			for i := range literals {
+				literals[i] = in[headerSize]
+			}
+			return literals, headerSize + 1, nil
+		}
+		if headerSize+size > len(in) {
+			return nil, 0, fmt.Errorf("zstd raw literals of %d bytes are cut short: %w", size, ErrCorrupt)
+		}
+		return in[headerSize : headerSize+size], headerSize + size, nil
+	}
+
+	// compressed with huffman coding, with a new table or the one before
+	headerSize, sizeBits, streams := 3, uint(10), 4
+	switch sizeFormat {
+	case 0:
+		streams = 1
+	case 2:
+		headerSize, sizeBits = 4, 14
+	case 3:
+		headerSize, sizeBits = 5, 18
+	}
+	if len(in) < headerSize {
+		return nil, 0, fmt.Errorf("zstd literals header is cut short: %w", ErrCorrupt)
+	}
+	var header uint64
+	for i := 0; i < headerSize; i++ {
+		header |= uint64(in[i]) << (8 * i)
+	}
+	regenerated := int(header>>4) & (1<<sizeBits - 1)
+	compressed := int(header>>(4+sizeBits)) & (1<<sizeBits - 1)
+	if headerSize+compressed > len(in) {
+		return nil, 0, fmt.Errorf("zstd literals of %d bytes are cut short: %w", compressed, ErrCorrupt)
+	}
+	data := in[headerSize : headerSize+compressed]
+	if literalsType == zstdLiteralsCompressed {
+		table, n, err := readHuffmanTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman = table
+		data = data[n:]
+	} else if d.huffman == nil {
+		return nil, 0, fmt.Errorf("zstd literals reuse a huffman table that does not exist: %w", ErrCorrupt)
+	}
+	literals := make([]byte, regenerated)
+	if streams == 1 {
+		if err := d.huffman.decode(literals, data); err != nil {
+			return nil, 0, err
+		}
+		return literals, headerSize + compressed, nil
+	}
+	if len(data) < 6 {
+		return nil, 0, fmt.Errorf("zstd literals jump table is cut short: %w", ErrCorrupt)
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(data[0:2])),
+		int(binary.LittleEndian.Uint16(data[2:4])),
+		int(binary.LittleEndian.Uint16(data[4:6])),
+	}
+	sizes[3] = len(data) - 6 - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return nil, 0, fmt.Errorf("zstd literals streams overflow their %d bytes: %w", len(data), ErrCorrupt)
+	}
+	segment := (regenerated + 3) / 4
+	data = data[6:]
+	for i, size := range sizes {
+		start, end := i*segment, (i+1)*segment
+		if end > regenerated || i == 3 {
+			end = regenerated
+		}
+		if start > end {
+			start = end
+		}
+		if err := d.huffman.decode(literals[start:end], data[:size]); err != nil {
+			return nil, 0, err
+		}
+		data = data[size:]
+	}
+	return literals, headerSize + compressed, nil
+}
+
+// decodeSequences decodes the sequences section of a block and executes the sequences, each of which copies some
+// literals and then a match from earlier output, and then copies any literals that are left
+func (d *zstdDecoder) decodeSequences(in, literals []byte) error {
+	if len(in) < 1 {
+		return fmt.Errorf("zstd sequences section is missing: %w", ErrCorrupt)
+	}
+	count, pos := int(in[0]), 1
+	switch {
+	case count == 0:
+		d.out = append(d.out, literals...)
+		return nil
+	case count == 255:
+		if len(in) < 3 {
+			return fmt.Errorf("zstd sequences header is cut short: %w", ErrCorrupt)
+		}
+		count, pos = int(in[1])+int(in[2])<<8+0x7f00, 3
+	case count >= 128:
+		if len(in) < 2 {
+			return fmt.Errorf("zstd sequences header is cut short: %w", ErrCorrupt)
+		}
+		count, pos = (count-128)<<8+int(in[1]), 2
+	}
+	if pos >= len(in) {
+		return fmt.Errorf("zstd sequences header is cut short: %w", ErrCorrupt)
+	}
+	modes := in[pos]
+	pos++
+	if modes&3 != 0 {
+		return fmt.Errorf("zstd sequences header has reserved bits set: %w", ErrCorrupt)
+	}
+	var err error
+	var n int
+	if d.literals, n, err = d.sequenceTable(in[pos:], modes>>6, d.literals, zstdPredefinedLiterals, 6, 35, zstdMaxLiteralsLog); err != nil {
+		return fmt.Errorf("invalid literal lengths table: %v", err)
+	}
+	pos += n
+	if d.offsets, n, err = d.sequenceTable(in[pos:], (modes>>4)&3, d.offsets, zstdPredefinedOffset, 5, 31, zstdMaxOffsetLog); err != nil {
+		return fmt.Errorf("invalid offsets table: %v", err)
+	}
+	pos += n
+	if d.matches, n, err = d.sequenceTable(in[pos:], (modes>>2)&3, d.matches, zstdPredefinedMatch, 6, 52, zstdMaxMatchLog); err != nil {
+		return fmt.Errorf("invalid match lengths table: %v", err)
+	}
+	pos += n
+
+	r, err := newReverseBitReader(in[pos:])
+	if err != nil {
+		return err
+	}
+	literalsState := d.literals.init(r)
+	offsetState := d.offsets.init(r)
+	matchState := d.matches.init(r)
+	for i := 0; i < count; i++ {
+		offsetCode := d.offsets.entries[offsetState].symbol
+		matchCode := d.matches.entries[matchState].symbol
+		literalsCode := d.literals.entries[literalsState].symbol
+		if offsetCode > 31 || matchCode > 52 || literalsCode > 35 {
+			return fmt.Errorf("invalid zstd sequence codes: %w", ErrCorrupt)
+		}
+		offsetValue := int(1)<<offsetCode + int(r.read(uint(offsetCode)))
+		matchLength := int(zstdMatchBase[matchCode]) + int(r.read(uint(zstdMatchBits[matchCode])))
+		literalsLength := int(zstdLiteralsBase[literalsCode]) + int(r.read(uint(zstdLiteralsBits[literalsCode])))
+		if i != count-1 {
+			literalsState = d.literals.update(literalsState, r)
+			matchState = d.matches.update(matchState, r)
+			offsetState = d.offsets.update(offsetState, r)
+		}
+		if r.overflowed() {
+			return fmt.Errorf("zstd sequences overflow their bitstream: %w", ErrCorrupt)
+		}
+
+		offset := d.offset(offsetValue, literalsLength)
+		if literalsLength > len(literals) {
+			return fmt.Errorf("zstd sequence needs %d literals with only %d left: %w", literalsLength, len(literals), ErrCorrupt)
+		}
+		d.out = append(d.out, literals[:literalsLength]...)
+		literals = literals[literalsLength:]
+		if offset <= 0 || offset > len(d.out)-d.frameStart {
+			return fmt.Errorf("zstd match offset %d is before the start of the frame: %w", offset, ErrCorrupt)
+		}
+		start := len(d.out) - offset
+		for j := 0; j < matchLength; j++ {
+			d.out = append(d.out, d.out[start+j])
+		}
+	}
+	if r.pos != 0 {
+		return fmt.Errorf("zstd sequences did not use all of their bitstream: %w", ErrCorrupt)
+	}
+	d.out = append(d.out, literals...)
+	return nil
+}
+
+// offset turns the offset value of a sequence into the offset of its match, which for 1 to 3 is one of the
+// offsets used most recently, and keeps track of those
+func (d *zstdDecoder) offset(value, literalsLength int) int {
+	if value > 3 {
+		d.reps[2], d.reps[1], d.reps[0] = d.reps[1], d.reps[0], value-3
+		return d.reps[0]
+	}
+	// without literals, the repeated offsets are shifted by one
+	if literalsLength == 0 {
+		value++
+	}
+	var offset int
+	switch value {
+	case 1:
+		return d.reps[0]
+	case 2:
+		offset = d.reps[1]
+	case 3:
+		offset = d.reps[2]
+		d.reps[2] = d.reps[1]
+	default:
+		offset = d.reps[0] - 1
+		d.reps[2] = d.reps[1]
+	}
+	d.reps[1], d.reps[0] = d.reps[0], offset
+	return offset
+}
+
+// sequenceTable reads the table for one kind of symbol in the sequences, given its mode, and returns it and how many
+// bytes it took
+func (d *zstdDecoder) sequenceTable(in []byte, mode uint8, previous *fseTable, predefined []int16, predefinedLog uint8, maxSymbol int, maxLog uint8) (*fseTable, int, error) {
+	switch mode {
+	case zstdModePredefined:
+		table, err := buildFSETable(predefined, predefinedLog)
+		return table, 0, err
+	case zstdModeRLE:
+		if len(in) < 1 {
+			return nil, 0, fmt.Errorf("RLE symbol is missing: %w", ErrCorrupt)
+		}
+		if int(in[0]) > maxSymbol {
+			return nil, 0, fmt.Errorf("RLE symbol %d is more than the maximum %d: %w", in[0], maxSymbol, ErrCorrupt)
+		}
+		return &fseTable{entries: []fseEntry{{symbol: in[0]}}}, 1, nil
+	case zstdModeCompressed:
+		counts, log, n, err := readFSECounts(in, maxSymbol, maxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := buildFSETable(counts, log)
+		return table, n, err
+	default:
+		if previous == nil {
+			return nil, 0, fmt.Errorf("repeats a table that does not exist: %w", ErrCorrupt)
+		}
+		return previous, 0, nil
+	}
+}
+
+// reverseBitReader reads a bitstream backwards from its end, where the highest bit set in the last byte marks
+// where it starts. It reads zeros once it is past the beginning, and overflowed says whether it has.
+type reverseBitReader struct {
+	b []byte
+	// pos is the number of bits left to read
+	pos int
+}
+
+func newReverseBitReader(b []byte) (*reverseBitReader, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, fmt.Errorf("zstd bitstream has no start marker: %w", ErrCorrupt)
+	}
+	return &reverseBitReader{
+		b:   b,
+		pos: (len(b)-1)*8 + bits.Len8(b[len(b)-1]) - 1,
+	}, nil
+}
+
+// peek returns the next n bits, at most 56, without reading them
+func (r *reverseBitReader) peek(n uint) uint64 {
+	start := r.pos - int(n)
+	if start < 0 {
+		if r.pos <= 0 {
+			return 0
+		}
+		return r.bitsAt(0, uint(r.pos)) << uint(-start)
+	}
+	return r.bitsAt(start, n)
+}
+
+// bitsAt returns the n bits from bit start
+func (r *reverseBitReader) bitsAt(start int, n uint) uint64 {
+	var v uint64
+	first := start / 8
+	for i := 0; i < 8 && first+i < len(r.b); i++ {
+		v |= uint64(r.b[first+i]) << (8 * i)
+	}
+	return v >> (uint(start) % 8) & (1<<n - 1)
+}
+
+func (r *reverseBitReader) read(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	v := r.peek(n)
+	r.pos -= int(n)
+	return v
+}
+
+func (r *reverseBitReader) overflowed() bool {
+	return r.pos < 0
+}
+
+// fseEntry is a state of an FSE table: the symbol it decodes, and how to get the next state
+type fseEntry struct {
+	symbol uint8
+	nbBits uint8
+	base   uint16
+}
+
+// fseTable is a table for decoding finite state entropy, which has 2^log states
+type fseTable struct {
+	log     uint8
+	entries []fseEntry
+}
+
+func (t *fseTable) init(r *reverseBitReader) uint16 {
+	return uint16(r.read(uint(t.log)))
+}
+
+func (t *fseTable) update(state uint16, r *reverseBitReader) uint16 {
+	e := t.entries[state]
+	return e.base + uint16(r.read(uint(e.nbBits)))
+}
+
+// readFSECounts reads the normalized counts of the symbols of an FSE table, and returns them, the accuracy log of
+// the table, and how many bytes they took. A count of -1 is for a symbol that is less likely than 1 in 2^log.
+func readFSECounts(in []byte, maxSymbol int, maxLog uint8) ([]int16, uint8, int, error) {
+	if len(in) < 1 {
+		return nil, 0, 0, fmt.Errorf("FSE table header is missing: %w", ErrCorrupt)
+	}
+	log := in[0]&0xf + 5
+	if log > maxLog {
+		return nil, 0, 0, fmt.Errorf("FSE accuracy log %d is more than the maximum %d: %w", log, maxLog, ErrCorrupt)
+	}
+	bitPos := 4
+	readBits := func(n int) (int, error) {
+		var v int
+		for i := 0; i < n; i++ {
+			p := bitPos + i
+			if p/8 >= len(in) {
+				return 0, fmt.Errorf("FSE table header is cut short: %w", ErrCorrupt)
+			}
+			v |= int(in[p/8]>>(p%8)&1) << i
+		}
+		return v, nil
+	}
+	var counts []int16
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := int(log) + 1
+	for remaining > 1 && len(counts) <= maxSymbol {
+		v, err := readBits(nbBits)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		max := 2*threshold - 1 - remaining
+		var count int
+		if v&(threshold-1) < max {
+			count = v & (threshold - 1)
+			bitPos += nbBits - 1
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+			bitPos += nbBits
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		counts = append(counts, int16(count))
+		if count == 0 {
+			// a 0 is followed by 2 bits at a time of how many more 0s there are, 3 meaning there are more
+			for {
+				repeat, err := readBits(2)
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				bitPos += 2
+				for i := 0; i < repeat; i++ {
+					counts = append(counts, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(counts) > maxSymbol+1 {
+		return nil, 0, 0, fmt.Errorf("FSE counts do not add up: %w", ErrCorrupt)
+	}
+	return counts, log, (bitPos + 7) / 8, nil
+}
+
+// buildFSETable builds the decoding table for normalized counts
+func buildFSETable(counts []int16, log uint8) (*fseTable, error) {
+	size := 1 << log
+	entries := make([]fseEntry, size)
+	next := make([]uint16, len(counts))
+	high := size - 1
+	for s, c := range counts {
+		if c == -1 {
+			entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+	position, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, c := range counts {
+		for i := 0; i < int(c); i++ {
+			entries[position].symbol = uint8(s)
+			position = (position + step) & mask
+			for position > high {
+				position = (position + step) & mask
+			}
+		}
+	}
+	if position != 0 {
+		return nil, fmt.Errorf("FSE counts do not fill the table: %w", ErrCorrupt)
+	}
+	for i := range entries {
+		s := entries[i].symbol
+		state := next[s]
+		next[s]++
+		nbBits := log - uint8(bits.Len16(state)-1)
+		entries[i].nbBits = nbBits
+		entries[i].base = state<<nbBits - uint16(size)
+	}
+	return &fseTable{log: log, entries: entries}, nil
+}
+
+// huffmanEntry is an entry in a huffman decoding table, for every code that starts with the bits of the symbol
+type huffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+type huffmanTable struct {
+	maxBits uint8
+	entries []huffmanEntry
+}
+
+// readHuffmanTable reads the description of a huffman table, which is the weight of each symbol, either FSE
+// compressed or 4 bits each, and returns the table and how many bytes it took
+func readHuffmanTable(in []byte) (*huffmanTable, int, error) {
+	if len(in) < 1 {
+		return nil, 0, fmt.Errorf("huffman table header is missing: %w", ErrCorrupt)
+	}
+	var weights []uint8
+	size := int(in[0])
+	if size >= 128 {
+		count := size - 127
+		size = (count + 1) / 2
+		if 1+size > len(in) {
+			return nil, 0, fmt.Errorf("huffman weights are cut short: %w", ErrCorrupt)
+		}
+		for i := 0; i < count; i++ {
+			w := in[1+i/2]
+			if i%2 == 0 {
+				w >>= 4
+			}
+			weights = append(weights, w&0xf)
+		}
+	} else {
+		if 1+size > len(in) {
+			return nil, 0, fmt.Errorf("huffman weights are cut short: %w", ErrCorrupt)
+		}
+		var err error
+		if weights, err = decodeHuffmanWeights(in[1 : 1+size]); err != nil {
+			return nil, 0, err
+		}
+	}
+	table, err := buildHuffmanTable(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return table, 1 + size, nil
+}
+
+// decodeHuffmanWeights decodes FSE compressed weights, which interleave two states on the same table
+func decodeHuffmanWeights(in []byte) ([]uint8, error) {
+	counts, log, n, err := readFSECounts(in, zstdMaxHuffmanWeights, zstdMaxWeightsLog)
+	if err != nil {
+		return nil, fmt.Errorf("invalid huffman weights table: %v", err)
+	}
+	table, err := buildFSETable(counts, log)
+	if err != nil {
+		return nil, fmt.Errorf("invalid huffman weights table: %v", err)
+	}
+	r, err := newReverseBitReader(in[n:])
+	if err != nil {
+		return nil, err
+	}
+	states := [2]uint16{table.init(r), table.init(r)}
+	var weights []uint8
+	for i := 0; ; i = 1 - i {
+		if len(weights) >= zstdMaxHuffmanWeights {
+			return nil, fmt.Errorf("too many huffman weights: %w", ErrCorrupt)
+		}
+		weights = append(weights, table.entries[states[i]].symbol)
+		states[i] = table.update(states[i], r)
+		if r.overflowed() {
+			weights = append(weights, table.entries[states[1-i]].symbol)
+			return weights, nil
+		}
+	}
+}
+
+// buildHuffmanTable builds the decoding table from the weights of all but the last symbol, whose weight is what
+// makes the total a power of 2
+func buildHuffmanTable(weights []uint8) (*huffmanTable, error) {
+	var total uint32
+	for _, w := range weights {
+		if w > zstdMaxHuffmanBits {
+			return nil, fmt.Errorf("huffman weight %d is more than the maximum %d: %w", w, zstdMaxHuffmanBits, ErrCorrupt)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("huffman weights are all 0: %w", ErrCorrupt)
+	}
+	maxBits := uint8(bits.Len32(total))
+	left := uint32(1)<<maxBits - total
+	if left&(left-1) != 0 || maxBits > zstdMaxHuffmanBits {
+		return nil, fmt.Errorf("huffman weights do not add up: %w", ErrCorrupt)
+	}
+	weights = append(weights, uint8(bits.Len32(left)))
+
+	// each symbol of weight w has 2^(w-1) entries, those of the lowest weight first
+	var rankStart [zstdMaxHuffmanBits + 2]uint32
+	for _, w := range weights {
+		if w > 0 {
+			rankStart[w] += 1 << (w - 1)
+		}
+	}
+	var next uint32
+	for w := 1; w < len(rankStart); w++ {
+		count := rankStart[w]
+		rankStart[w] = next
+		next += count
+	}
+	entries := make([]huffmanEntry, 1<<maxBits)
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		length := uint32(1) << (w - 1)
+		for i := rankStart[w]; i < rankStart[w]+length; i++ {
+			entries[i] = huffmanEntry{symbol: uint8(s), nbBits: maxBits + 1 - w}
+		}
+		rankStart[w] += length
+	}
+	return &huffmanTable{maxBits: maxBits, entries: entries}, nil
+}
+
+// decode decodes a huffman coded stream into all of out, which must use all of the stream
+func (t *huffmanTable) decode(out, in []byte) error {
+	r, err := newReverseBitReader(in)
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		e := t.entries[r.peek(uint(t.maxBits))]
+		out[i] = e.symbol
+		r.pos -= int(e.nbBits)
+	}
+	if r.pos != 0 {
+		return fmt.Errorf("huffman stream of %d bytes decoded to %d with %d bits left: %w", len(in), len(out), r.pos, ErrCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	read := func(name string) []byte {
+		t.Helper()
+		b, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatalf("could not read %s: %v", name, err)
+		}
+		return b
+	}
+	words := read("words.txt")
+	// compressed by the zstd CLI, as in testdata/README.md
+	tests := []struct {
+		name     string
+		expected []byte
+		checksum bool
+	}{
+		{"words.txt.1.zst", words, true},
+		{"words.txt.3.nocheck.zst", words, false},
+		{"words.txt.19.zst", words, true},
+		{"words.txt.22.zst", words, true},
+		{"words.txt.twice.zst", append(append([]byte{}, words...), words...), true},
+		{"random.bin.zst", read("random.bin"), true},
+		{"zeros.bin.zst", make([]byte, 300000), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := read(tt.name)
+			out, err := Decompress(in, len(tt.expected))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(out, tt.expected) {
+				t.Errorf("decompressed %d bytes did not match the original %d", len(out), len(tt.expected))
+			}
+			if !tt.checksum {
+				return
+			}
+			// a bad checksum
+			in = append([]byte{}, in...)
+			in[len(in)-1] ^= 0xff
+			if _, err := Decompress(in, len(tt.expected)); err == nil {
+				t.Errorf("mismatched checksum did not return an error")
+			}
+		})
+	}
+
+	// frames that are raw and RLE blocks, after a skippable frame, and with padding after them
+	frames := []byte{
+		0x50, 0x2a, 0x4d, 0x18, 0x02, 0x00, 0x00, 0x00, 0xaa, 0xbb,
+		0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x05, 0x29, 0x00, 0x00, 'h', 'e', 'l', 'l', 'o',
+		0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x04, 0x23, 0x00, 0x00, 'o',
+		0x00, 0x00, 0x00,
+	}
+	out, err := Decompress(frames, 9)
+	if err != nil {
+		t.Fatalf("raw and RLE blocks: unexpected error: %v", err)
+	}
+	if string(out) != "hellooooo" {
+		t.Errorf("raw and RLE blocks: mismatched output %q", out)
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"cut short", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x05, 0x29, 0x00, 0x00, 'h'}},
+		{"reserved block type", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x05, 0x07, 0x00, 0x00}},
+		{"reserved header bit", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x28, 0x05, 0x28, 0x00, 0x00, 'h', 'e', 'l', 'l', 'o'}},
+		// a compressed block whose one sequence matches before the start of the frame
+		{"match before start", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x05, 0x45, 0x00, 0x00, 0x08, 'a', 0x01, 0x54, 0x00, 0x00, 0x00, 0x01}},
+		{"skippable frame too long", []byte{0x50, 0x2a, 0x4d, 0x18, 0x10, 0x00, 0x00, 0x00, 0xaa}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decompress(tt.in, 5)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("mismatched error, actual %v expected %v", err, ErrCorrupt)
+			}
+		})
+	}
+	if _, err := Decompress([]byte{1, 2, 3, 4, 5}, 5); err == nil {
+		t.Errorf("bad magic did not return an error")
+	}
+}
@@ -0,0 +1,209 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AAIP, the Arbitrary Attribute Interchange Protocol, records extended attributes in AL entries in the
+// System Use area, alongside Rock Ridge. It is described in doc/susp_aaip_2_0.txt in libisofs.
+//
+// The attributes of a file are a list of component records, each with a flags byte, a length byte and up to
+// 255 bytes. Records with the continue flag set are joined with the next one to make a component. Components
+// alternate between a name and its value. The list is split across as many AL entries as it needs, each
+// but the last with its own continue flag set.
+const (
+	aaipSignature  = "AL"
+	aaipID         = "AAIP_0200"
+	aaipDescriptor = "AL PROVIDES VIA AAIP 2.0 SUPPORT FOR ARBITRARY FILE ATTRIBUTES IN ISO 9660 IMAGES"
+	aaipSource     = "PLEASE CONTACT THE LIBBURNIA PROJECT VIA LIBBURNIA-PROJECT.ORG"
+	// aaipMaxEntry most bytes of component records in a single AL entry, after its 5 byte header
+	aaipMaxEntry = 250
+	// aaipMaxComponentRecord most bytes written in a single component record, not counting its flags and
+	// length, so that a whole record always fits in an entry. Records read can have up to 255.
+	aaipMaxComponentRecord = aaipMaxEntry - 2
+	// aaipNamespaceLiteral marks a name recorded as-is, needed when it starts with a byte that would
+	// otherwise be read as a namespace
+	aaipNamespaceLiteral = 0x01
+)
+
+// aaipNamespaces are the name prefixes recorded as a single byte at the start of a name, by that byte
+var aaipNamespaces = []string{"", "", "system.", "user.", "isofs.", "trusted.", "security."}
+
+// aaipAttributes is a single AL entry. The attributes of a file can be split across entries anywhere, even
+// in the middle of a name or value, so each only keeps its component records; they are decoded from all of
+// the entries of a file together by directoryEntry.xattrs()
+type aaipAttributes struct {
+	continued  bool
+	components []byte
+}
+
+func (d aaipAttributes) Equal(o directoryEntrySystemUseExtension) bool {
+	t, ok := o.(aaipAttributes)
+	return ok && t.continued == d.continued && bytes.Equal(t.components, d.components)
+}
+func (d aaipAttributes) Signature() string {
+	return aaipSignature
+}
+func (d aaipAttributes) Length() int {
+	return 5 + len(d.components)
+}
+func (d aaipAttributes) Version() uint8 {
+	return 1
+}
+func (d aaipAttributes) Data() []byte {
+	return d.components
+}
+func (d aaipAttributes) Bytes() []byte {
+	b := make([]byte, 5, 5+len(d.components))
+	copy(b[0:2], aaipSignature)
+	b[2] = uint8(d.Length())
+	b[3] = d.Version()
+	if d.continued {
+		b[4] = 1
+	}
+	return append(b, d.components...)
+}
+
+// Continuable is false even for a continued entry, as it is decoded with the others by directoryEntry.xattrs()
+// rather than merged
+func (d aaipAttributes) Continuable() bool {
+	return false
+}
+func (d aaipAttributes) Merge([]directoryEntrySystemUseExtension) directoryEntrySystemUseExtension {
+	return d
+}
+
+func parseAAIPAttributes(b []byte) (directoryEntrySystemUseExtension, error) {
+	if len(b) < 5 {
+		return nil, fmt.Errorf("AAIP AL extension received %d bytes, fewer than minimum of 5", len(b))
+	}
+	size := int(b[2])
+	if size != len(b) {
+		return nil, fmt.Errorf("AAIP AL extension received %d bytes, but byte 2 indicated %d", len(b), size)
+	}
+	version := b[3]
+	if version != 1 {
+		return nil, fmt.Errorf("AAIP AL extension must be version 1, was %d", version)
+	}
+	components := make([]byte, len(b)-5)
+	copy(components, b[5:])
+	return aaipAttributes{
+		continued:  b[4]&0x1 == 0x1,
+		components: components,
+	}, nil
+}
+
+// aaipEntries encodes xattrs as AL entries, with the names sorted
+func aaipEntries(xattrs map[string][]byte) []directoryEntrySystemUseExtension {
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// each record is kept whole, so that an entry never ends partway through one
+	var records [][]byte
+	for _, name := range names {
+		records = append(records, aaipComponentRecords(aaipEncodeName(name))...)
+		records = append(records, aaipComponentRecords(xattrs[name])...)
+	}
+
+	var (
+		entries []directoryEntrySystemUseExtension
+		current []byte
+	)
+	for _, r := range records {
+		if len(current)+len(r) > aaipMaxEntry {
+			entries = append(entries, aaipAttributes{continued: true, components: current})
+			current = nil
+		}
+		current = append(current, r...)
+	}
+	if len(current) > 0 {
+		entries = append(entries, aaipAttributes{components: current})
+	}
+	return entries
+}
+
+// aaipComponentRecords splits a single name or value into component records
+func aaipComponentRecords(b []byte) [][]byte {
+	var records [][]byte
+	for {
+		n := len(b)
+		var flags byte
+		if n > aaipMaxComponentRecord {
+			n = aaipMaxComponentRecord
+			flags = 0x1
+		}
+		records = append(records, append([]byte{flags, byte(n)}, b[:n]...))
+		b = b[n:]
+		if flags == 0 {
+			return records
+		}
+	}
+}
+
+// aaipEncodeName records a name with its namespace as a single byte, where there is one for it
+func aaipEncodeName(name string) []byte {
+	for i, ns := range aaipNamespaces {
+		if ns != "" && strings.HasPrefix(name, ns) && len(name) > len(ns) {
+			return append([]byte{byte(i)}, name[len(ns):]...)
+		}
+	}
+	if name != "" && name[0] < 0x20 {
+		return append([]byte{aaipNamespaceLiteral}, name...)
+	}
+	return []byte(name)
+}
+
+// aaipDecodeName returns the name recorded in b, or false if it is not one that can be used as an
+// xattr, like the empty name used for ACLs
+func aaipDecodeName(b []byte) (string, bool) {
+	switch {
+	case len(b) == 0:
+		return "", false
+	case b[0] == aaipNamespaceLiteral:
+		return string(b[1:]), len(b) > 1
+	case int(b[0]) < len(aaipNamespaces) && aaipNamespaces[b[0]] != "":
+		return aaipNamespaces[b[0]] + string(b[1:]), true
+	case b[0] < 0x20:
+		// reserved namespaces
+		return "", false
+	}
+	return string(b), true
+}
+
+// aaipDecode decodes the component records of all of the AL entries of a file
+func aaipDecode(records []byte) (map[string][]byte, error) {
+	var (
+		components [][]byte
+		current    []byte
+	)
+	for i := 0; i < len(records); {
+		if i+2 > len(records) {
+			return nil, fmt.Errorf("AAIP component record at %d is truncated", i)
+		}
+		flags, size := records[i], int(records[i+1])
+		if i+2+size > len(records) {
+			return nil, fmt.Errorf("AAIP component record at %d has %d bytes, but only %d remain", i, size, len(records)-i-2)
+		}
+		current = append(current, records[i+2:i+2+size]...)
+		if flags&0x1 == 0 {
+			components = append(components, current)
+			current = nil
+		}
+		i += 2 + size
+	}
+	if current != nil || len(components)%2 != 0 {
+		return nil, fmt.Errorf("AAIP attributes end partway through a name or value")
+	}
+	xattrs := map[string][]byte{}
+	for i := 0; i < len(components); i += 2 {
+		if name, ok := aaipDecodeName(components[i]); ok {
+			xattrs[name] = components[i+1]
+		}
+	}
+	return xattrs, nil
+}
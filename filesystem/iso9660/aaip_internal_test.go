@@ -0,0 +1,79 @@
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAAIPRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		xattrs map[string][]byte
+	}{
+		{"empty value", map[string][]byte{"user.empty": {}}},
+		{"namespaces", map[string][]byte{
+			"user.a":              []byte("1"),
+			"trusted.b":           []byte("2"),
+			"security.capability": {0x00, 0x00, 0x00, 0x02},
+			"system.posix_acl":    []byte("4"),
+			"isofs.di":            []byte("5"),
+			"other":               []byte("6"),
+			"\x02literal":         []byte("7"),
+		}},
+		{"long value", map[string][]byte{"user.long": []byte(strings.Repeat("v", 1000)), "user.next": []byte("n")}},
+		{"long name", map[string][]byte{"user." + strings.Repeat("n", 400): []byte("v")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []byte
+			entries := aaipEntries(tt.xattrs)
+			for i, e := range entries {
+				b := e.Bytes()
+				if len(b) > 255 {
+					t.Fatalf("entry %d is %d bytes, more than the 255 that fit", i, len(b))
+				}
+				parsed, err := parseAAIPAttributes(b)
+				if err != nil {
+					t.Fatalf("entry %d: unexpected error parsing: %v", i, err)
+				}
+				al := parsed.(aaipAttributes)
+				if al.continued != (i < len(entries)-1) {
+					t.Errorf("entry %d: continued %v", i, al.continued)
+				}
+				records = append(records, al.components...)
+			}
+			xattrs, err := aaipDecode(records)
+			if err != nil {
+				t.Fatalf("unexpected error decoding: %v", err)
+			}
+			if len(xattrs) != len(tt.xattrs) {
+				t.Errorf("decoded %d xattrs instead of %d", len(xattrs), len(tt.xattrs))
+			}
+			for name, value := range tt.xattrs {
+				if actual, ok := xattrs[name]; !ok || !bytes.Equal(actual, value) {
+					t.Errorf("xattr %q: %v instead of %v", name, actual, value)
+				}
+			}
+		})
+	}
+}
+
+func TestAAIPDecodeTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []byte
+	}{
+		{"short header", []byte{0x00}},
+		{"short record", []byte{0x00, 0x05, 'a'}},
+		{"name without value", []byte{0x00, 0x01, 'a'}},
+		{"continued at end", []byte{0x00, 0x01, 'a', 0x01, 0x01, 'b'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := aaipDecode(tt.records); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -36,8 +36,10 @@ func (d *Directory) entriesToBytes(ceBlockLocations []uint32) ([][]byte, error)
 			b = append(b, make([]byte, left)...)
 		}
 		b = append(b, recBytes...)
+		// each continuation area gets its own block, so the next entry starts after the ones this used
 		if len(b2) > 1 {
 			ceBlocks = append(ceBlocks, b2[1:]...)
+			ceBlockLocations = ceBlockLocations[len(b2)-1:]
 		}
 	}
 	// in the end, must pad to exact blocks
@@ -14,6 +14,8 @@ import (
 const (
 	directoryEntryMinSize uint8 = 34  // min size is all the required fields (33 bytes) plus 1 byte for the filename
 	directoryEntryMaxSize int   = 254 // max size allowed
+	// jolietMaxNameLength is how many UCS-2 characters a Joliet name has at most, not including the version
+	jolietMaxNameLength = 64
 )
 
 // directoryEntry is a single directory entry
@@ -41,7 +43,10 @@ type directoryEntry struct {
 	volumeSequence           uint16
 	filesystem               *FileSystem
 	filename                 string
+	joliet                   bool // is the filename in UCS-2, as in a Joliet directory?
 	extensions               []directoryEntrySystemUseExtension
+	// more holds the records of the following extents of a multi-extent file, in order
+	more []*directoryEntry
 }
 
 func (de *directoryEntry) countNamelenBytes() int {
@@ -52,6 +57,8 @@ func (de *directoryEntry) countNamelenBytes() int {
 		namelen = 1
 	case de.isParent:
 		namelen = 1
+	case de.joliet:
+		namelen = len(ucs2StringToBytes(de.filename))
 	default:
 		namelen = len(de.filename)
 	}
@@ -117,6 +124,12 @@ func (de *directoryEntry) toBytes(skipExt bool, ceBlocks []uint32) ([][]byte, er
 		filenameBytes = []byte{0x00}
 	case de.isParent:
 		filenameBytes = []byte{0x01}
+	case de.joliet:
+		err = validateJolietFilename(de.filename, de.isSubdirectory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Joliet name %s: %v", de.filename, err)
+		}
+		filenameBytes = ucs2StringToBytes(de.filename)
 	default:
 		// first validate the filename
 		err = validateFilename(de.filename, de.isSubdirectory)
@@ -294,6 +307,12 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 		return nil, fmt.Errorf("invalid directory entry : %v", err)
 	}
 	de.filesystem = f
+	if f.joliet {
+		de.joliet = true
+		if !de.isSelf && !de.isParent {
+			de.filename = bytesToUCS2String([]byte(de.filename))
+		}
+	}
 
 	if f.suspEnabled && len(de.extensions) > 0 {
 		// if the last entry is a continuation SUSP entry and SUSP is enabled, we need to follow and parse them
@@ -334,6 +353,8 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 func parseDirEntries(b []byte, f *FileSystem) ([]*directoryEntry, error) {
 	dirEntries := make([]*directoryEntry, 0, 20)
 	count := 0
+	// the first record of a multi-extent file whose following records are being read
+	var multi *directoryEntry
 	for i := 0; i < len(b); count++ {
 		// empty entry means nothing more to read - this might not actually be accurate, but work with it for now
 		entryLen := int(b[i+0])
@@ -355,8 +376,18 @@ func parseDirEntries(b []byte, f *FileSystem) ([]*directoryEntry, error) {
 			}
 		}
 
-		if de != nil {
+		switch {
+		case de == nil:
+		case multi != nil:
+			multi.more = append(multi.more, de)
+			if !de.hasMoreEntries {
+				multi = nil
+			}
+		default:
 			dirEntries = append(dirEntries, de)
+			if de.hasMoreEntries {
+				multi = de
+			}
 		}
 		i += entryLen
 	}
@@ -466,22 +497,34 @@ func (de *directoryEntry) Name() string {
 		}
 	}
 	// check if we have an extension that overrides it
-	// filenames should have the ';1' stripped off, as well as the leading or trailing '.'
+	// filenames should have the ';1' stripped off, as well as the leading or trailing '.', which Joliet names keep
 	if !de.IsDir() {
 		name = strings.TrimSuffix(name, ";1")
-		name = strings.TrimSuffix(name, ".")
-		name = strings.TrimPrefix(name, ".")
+		if !de.joliet {
+			name = strings.TrimSuffix(name, ".")
+			name = strings.TrimPrefix(name, ".")
+		}
 	}
 	return name
 }
 
 // Size() int64        // length in bytes for regular files; system-dependent for others
 func (de *directoryEntry) Size() int64 {
-	return int64(de.size)
+	size := int64(de.size)
+	for _, e := range de.more {
+		size += int64(e.size)
+	}
+	return size
 }
 
 // Mode() FileMode     // file mode bits
 func (de *directoryEntry) Mode() os.FileMode {
+	// Rock Ridge keeps the real mode, including whether it is a symlink
+	for _, e := range de.extensions {
+		if px, ok := e.(rockRidgePosixAttributes); ok {
+			return px.mode
+		}
+	}
 	return 0o755
 }
 
@@ -495,6 +538,35 @@ func (de *directoryEntry) IsDir() bool {
 	return de.isSubdirectory
 }
 
+// symlinkTarget returns the target of the entry if it is a Rock Ridge symlink
+func (de *directoryEntry) symlinkTarget() (string, bool) {
+	var links []directoryEntrySystemUseExtension
+	for _, e := range de.extensions {
+		if l, ok := e.(rockRidgeSymlink); ok {
+			links = append(links, l)
+		}
+	}
+	if len(links) == 0 {
+		return "", false
+	}
+	link := links[0].(rockRidgeSymlink)
+	if len(links) > 1 {
+		link = link.Merge(links[1:]).(rockRidgeSymlink)
+	}
+	return link.name, true
+}
+
+// xattrs returns the extended attributes of the entry, from its AAIP entries
+func (de *directoryEntry) xattrs() (map[string][]byte, error) {
+	var records []byte
+	for _, e := range de.extensions {
+		if al, ok := e.(aaipAttributes); ok {
+			records = append(records, al.components...)
+		}
+	}
+	return aaipDecode(records)
+}
+
 // Sys() interface{}   // underlying data source (can return nil)
 func (de *directoryEntry) Sys() interface{} {
 	return nil
@@ -555,6 +627,31 @@ func validateFilename(s string, isDir bool) error {
 	return err
 }
 
+// validateJolietFilename checks a name for a Joliet directory, which has up to 64 characters of UCS-2 other than
+// the characters * / : ; ? and \, and for a file is followed by the version ";1"
+func validateJolietFilename(s string, isDir bool) error {
+	name := s
+	if !isDir {
+		if !strings.HasSuffix(s, ";1") {
+			return fmt.Errorf("file name must end with the version ;1")
+		}
+		name = strings.TrimSuffix(s, ";1")
+	}
+	r := []rune(name)
+	switch {
+	case len(r) == 0:
+		return fmt.Errorf("name must not be empty")
+	case len(r) > jolietMaxNameLength:
+		return fmt.Errorf("name must be at most %d characters", jolietMaxNameLength)
+	}
+	for _, c := range r {
+		if c < 0x20 || c > 0xffff || strings.ContainsRune(`*/:;?\`, c) {
+			return fmt.Errorf("character %q is not allowed", c)
+		}
+	}
+	return nil
+}
+
 // convert a string to a byte array, if all characters are valid ascii
 func stringToASCIIBytes(s string) ([]byte, error) {
 	length := len(s)
@@ -717,6 +717,62 @@ func TestDirectoryEntryStringToASCIIBytes(t *testing.T) {
 	}
 }
 
+func TestValidateJolietFilename(t *testing.T) {
+	tests := []struct {
+		name  string
+		isDir bool
+		err   error
+	}{
+		{"Read Me.txt;1", false, nil},
+		{"Ünïcode Notes.md;1", false, nil},
+		{".hidden;1", false, nil},
+		{"Program Files", true, nil},
+		{strings.Repeat("a", 64) + ";1", false, nil},
+		{"Read Me.txt", false, fmt.Errorf("file name must end with the version ;1")},
+		{";1", false, fmt.Errorf("name must not be empty")},
+		{strings.Repeat("a", 65), true, fmt.Errorf("name must be at most 64 characters")},
+		{"what?;1", false, fmt.Errorf("character '?' is not allowed")},
+		{"a:b", true, fmt.Errorf("character ':' is not allowed")},
+		{"smile\U0001F600", true, fmt.Errorf("character '\U0001f600' is not allowed")},
+	}
+	for _, tt := range tests {
+		err := validateJolietFilename(tt.name, tt.isDir)
+		if (err != nil && tt.err == nil) || (err == nil && tt.err != nil) || (err != nil && tt.err != nil && err.Error() != tt.err.Error()) {
+			t.Errorf("validateJolietFilename(%q, %v) mismatched err expected, actual: %v, %v", tt.name, tt.isDir, tt.err, err)
+		}
+	}
+}
+
+func TestDirectoryEntryJolietRoundTrip(t *testing.T) {
+	fs := &FileSystem{blocksize: 2048, joliet: true}
+	de := &directoryEntry{
+		location:   22,
+		size:       4,
+		creation:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		filesystem: fs,
+		filename:   "Read Me.txt;1",
+		joliet:     true,
+	}
+	b, err := de.toBytes(false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error converting to bytes: %v", err)
+	}
+	// each of the 13 characters in 2 bytes, plus one byte of padding
+	if expected := 33 + 26 + 1; len(b[0]) != expected {
+		t.Errorf("entry has %d bytes instead of expected %d", len(b[0]), expected)
+	}
+	parsed, err := parseDirEntry(b[0], fs)
+	if err != nil {
+		t.Fatalf("unexpected error parsing bytes: %v", err)
+	}
+	if parsed.filename != de.filename {
+		t.Errorf("parsed filename %q instead of expected %q", parsed.filename, de.filename)
+	}
+	if name := parsed.Name(); name != "Read Me.txt" {
+		t.Errorf("Name() returned %q instead of expected %q", name, "Read Me.txt")
+	}
+}
+
 func TestDirectoryEntryUCaseValid(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -16,6 +16,20 @@ const (
 	elToritoDefaultBlocks = 4
 )
 
+const (
+	// elToritoRecordSize is the size of each record of the boot catalog
+	elToritoRecordSize = 0x20
+	// header IDs and boot indicators at the start of the records of the boot catalog
+	elToritoValidationHeader  = 0x01
+	elToritoBootable          = 0x88
+	elToritoNotBootable       = 0x00
+	elToritoSectionHeader     = 0x90
+	elToritoLastSectionHeader = 0x91
+	elToritoEntryExtension    = 0x44
+	// elToritoEmulationMask is the bits of the media type of an entry that are its emulation
+	elToritoEmulationMask = 0x0f
+)
+
 // Platform target booting system for a bootable iso
 type Platform uint8
 
@@ -76,7 +90,7 @@ type ElToritoEntry struct {
 	SystemType mbr.Type
 	// LoadSize how many blocks of BootFile to load, equivalent to genisoimage option `-boot-load-size`
 	LoadSize uint16
-	size     uint16
+	size     uint32
 	location uint32
 }
 
@@ -95,8 +109,8 @@ func (et *ElTorito) generateCatalog() []byte {
 }
 
 func (et *ElTorito) validationEntry() []byte {
-	b := make([]byte, 0x20)
-	b[0] = 1
+	b := make([]byte, elToritoRecordSize)
+	b[0] = elToritoValidationHeader
 	b[1] = byte(et.Platform)
 	copy(b[4:0x1c], util.AppNameVersion)
 	b[0x1e] = 0x55
@@ -110,12 +124,116 @@ func (et *ElTorito) validationEntry() []byte {
 	return b
 }
 
+// parseCatalog parses an el torito boot catalog, with the locations of its boot images but not their paths. Entries
+// that are not bootable are left out. The first entry has the platform of the validation entry.
+func parseCatalog(b []byte) (*ElTorito, error) {
+	if len(b) < 2*elToritoRecordSize {
+		return nil, fmt.Errorf("catalog of %d bytes is too short for a validation entry and initial entry", len(b))
+	}
+	v := b[:elToritoRecordSize]
+	if v[0] != elToritoValidationHeader || v[0x1e] != 0x55 || v[0x1f] != 0xaa {
+		return nil, fmt.Errorf("invalid validation entry % x", v)
+	}
+	checksum := uint16(0x0)
+	for i := 0; i < len(v); i += 2 {
+		checksum += binary.LittleEndian.Uint16(v[i : i+2])
+	}
+	if checksum != 0 {
+		return nil, fmt.Errorf("validation entry checksum does not add up to 0 but %#04x", checksum)
+	}
+	et := &ElTorito{
+		Platform: Platform(v[1]),
+	}
+
+	offset := elToritoRecordSize
+	// the initial entry, then the sections, each with a header and its entries, the last with a different header
+	var (
+		platform = et.Platform
+		count    = 1
+		last     = false
+	)
+	for {
+		for i := 0; i < count; i++ {
+			if offset+elToritoRecordSize > len(b) {
+				return nil, fmt.Errorf("catalog ends before entry %d of section at %d", i, offset)
+			}
+			r := b[offset : offset+elToritoRecordSize]
+			offset += elToritoRecordSize
+			switch r[0] {
+			case elToritoBootable:
+				et.Entries = append(et.Entries, parseCatalogEntry(r, platform))
+			case elToritoNotBootable:
+			default:
+				return nil, fmt.Errorf("invalid boot indicator %#02x of entry at %d", r[0], offset-elToritoRecordSize)
+			}
+			// skip the extensions of its selection criteria
+			for offset < len(b) && b[offset] == elToritoEntryExtension {
+				offset += elToritoRecordSize
+			}
+		}
+		if last || offset+elToritoRecordSize > len(b) {
+			break
+		}
+		h := b[offset : offset+elToritoRecordSize]
+		if h[0] != elToritoSectionHeader && h[0] != elToritoLastSectionHeader {
+			// catalogs with no more sections often just end
+			break
+		}
+		offset += elToritoRecordSize
+		platform = Platform(h[1])
+		count = int(binary.LittleEndian.Uint16(h[2:4]))
+		last = h[0] == elToritoLastSectionHeader
+	}
+	return et, nil
+}
+
+// parseCatalogEntry parses a bootable initial or section entry r of a catalog, for platform
+func parseCatalogEntry(r []byte, platform Platform) *ElToritoEntry {
+	return &ElToritoEntry{
+		Platform:    platform,
+		Emulation:   Emulation(r[1] & elToritoEmulationMask),
+		LoadSegment: binary.LittleEndian.Uint16(r[2:4]),
+		SystemType:  mbr.Type(r[4]),
+		LoadSize:    binary.LittleEndian.Uint16(r[6:8]),
+		location:    binary.LittleEndian.Uint32(r[8:12]),
+	}
+}
+
+// emulatedSize returns the size in bytes of the boot image of the entry, as its emulation has it, from the image
+// itself for hard disk emulation. Without emulation, it is only as much as is loaded.
+func (e *ElToritoEntry) emulatedSize(file util.File, blocksize int64) (uint32, error) {
+	switch e.Emulation {
+	case Floppy12Emulation:
+		return 1200 * 1024, nil
+	case Floppy144Emulation:
+		return 1440 * 1024, nil
+	case Floppy288Emulation:
+		return 2880 * 1024, nil
+	case HardDiskEmulation:
+		// the emulated disk ends with the end of its partitions
+		b := make([]byte, 512)
+		if _, err := file.ReadAt(b, int64(e.location)*blocksize); err != nil {
+			return 0, fmt.Errorf("could not read MBR of hard disk boot image at block %d: %v", e.location, err)
+		}
+		var end uint32
+		for i := 0; i < 4; i++ {
+			p := b[446+16*i : 446+16*(i+1)]
+			if pend := binary.LittleEndian.Uint32(p[8:12]) + binary.LittleEndian.Uint32(p[12:16]); pend > end {
+				end = pend
+			}
+		}
+		return end * 512, nil
+	default:
+		return uint32(e.LoadSize) * 512, nil
+	}
+}
+
 // toHeaderBytes provide header bytes
 func (e *ElToritoEntry) headerBytes(last bool, entries uint16) []byte {
-	b := make([]byte, 0x20)
-	b[0] = 0x90
+	b := make([]byte, elToritoRecordSize)
+	b[0] = elToritoSectionHeader
 	if last {
-		b[0] = 0x91
+		b[0] = elToritoLastSectionHeader
 	}
 	b[1] = byte(e.Platform)
 	binary.LittleEndian.PutUint16(b[2:4], entries)
@@ -127,13 +245,18 @@ func (e *ElToritoEntry) headerBytes(last bool, entries uint16) []byte {
 func (e *ElToritoEntry) entryBytes() []byte {
 	blocks := e.LoadSize
 	if blocks == 0 {
-		blocks = e.size / 512
+		sectors := e.size / 512
 		if e.size%512 > 1 {
-			blocks++
+			sectors++
+		}
+		// the count has only 16 bits, which is enough for what firmware loads of an EFI image
+		blocks = 0xffff
+		if sectors < uint32(blocks) {
+			blocks = uint16(sectors)
 		}
 	}
-	b := make([]byte, 0x20)
-	b[0] = 0x88
+	b := make([]byte, elToritoRecordSize)
+	b[0] = elToritoBootable
 	b[1] = byte(e.Emulation)
 	binary.LittleEndian.PutUint16(b[2:4], e.LoadSegment)
 	// b[4] is system type, taken from byte 5 in the partition table in the boot image
@@ -153,7 +276,7 @@ func (e *ElToritoEntry) generateBootTable(pvdSector uint32, p string) ([]byte, e
 	b := make([]byte, 56)
 	binary.LittleEndian.PutUint32(b[0:4], pvdSector)
 	binary.LittleEndian.PutUint32(b[4:8], e.location)
-	binary.LittleEndian.PutUint32(b[8:12], uint32(e.size))
+	binary.LittleEndian.PutUint32(b[8:12], e.size)
 	// Checksum - simply add up all 32-bit words beginning at byte position 64
 	f, err := os.Open(p)
 	if err != nil {
@@ -113,3 +113,85 @@ func TestElToritoEntryBytes(t *testing.T) {
 		t.Errorf("Mismatched bytes, actual then expected\n% x\n% x\n", b, expected)
 	}
 }
+
+func TestElToritoParseCatalog(t *testing.T) {
+	et := &ElTorito{
+		Platform: BIOS,
+		Entries: []*ElToritoEntry{
+			{Platform: BIOS, Emulation: NoEmulation, LoadSegment: 0x7c0, LoadSize: 4, location: 100},
+			{Platform: EFI, Emulation: NoEmulation, SystemType: mbr.Fat16, LoadSize: 2880, location: 200},
+			{Platform: Mac, Emulation: Floppy144Emulation, LoadSize: 1, location: 300},
+		},
+	}
+	b := make([]byte, 2048)
+	copy(b, et.generateCatalog())
+	parsed, err := parseCatalog(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Platform != et.Platform || len(parsed.Entries) != len(et.Entries) {
+		t.Fatalf("parsed platform %d and %d entries instead of %d and %d", parsed.Platform, len(parsed.Entries), et.Platform, len(et.Entries))
+	}
+	for i, e := range parsed.Entries {
+		if *e != *et.Entries[i] {
+			t.Errorf("entry %d: parsed %+v instead of %+v", i, *e, *et.Entries[i])
+		}
+	}
+
+	t.Run("sections", func(t *testing.T) {
+		// one section with two entries, an extension of the first, and a non-bootable entry
+		record := func(b ...byte) []byte {
+			r := make([]byte, elToritoRecordSize)
+			copy(r, b)
+			return r
+		}
+		c := (&ElTorito{Platform: BIOS}).validationEntry()
+		c = append(c, record(elToritoBootable, 0, 0, 0, 0, 0, 4, 0, 10)...)
+		c = append(c, record(elToritoLastSectionHeader, byte(EFI), 3)...)
+		c = append(c, record(elToritoBootable, 0, 0, 0, 0, 0, 8, 0, 20)...)
+		c = append(c, record(elToritoEntryExtension)...)
+		c = append(c, record(elToritoNotBootable, 0, 0, 0, 0, 0, 8, 0, 30)...)
+		c = append(c, record(elToritoBootable, byte(HardDiskEmulation)|0x40, 0, 0, 0, 0, 1, 0, 40)...)
+		// anything after the last section is ignored
+		c = append(c, record(elToritoBootable, 0, 0, 0, 0, 0, 1, 0, 50)...)
+		parsed, err := parseCatalog(c)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []ElToritoEntry{
+			{Platform: BIOS, LoadSize: 4, location: 10},
+			{Platform: EFI, LoadSize: 8, location: 20},
+			{Platform: EFI, Emulation: HardDiskEmulation, LoadSize: 1, location: 40},
+		}
+		if len(parsed.Entries) != len(expected) {
+			t.Fatalf("%d entries instead of %d", len(parsed.Entries), len(expected))
+		}
+		for i, e := range parsed.Entries {
+			if *e != expected[i] {
+				t.Errorf("entry %d: parsed %+v instead of %+v", i, *e, expected[i])
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(b []byte) []byte
+		}{
+			{"short", func(b []byte) []byte { return b[:0x30] }},
+			{"header", func(b []byte) []byte { b[0] = 2; return b }},
+			{"key", func(b []byte) []byte { b[0x1f] = 0; return b }},
+			{"checksum", func(b []byte) []byte { b[0x1c]++; return b }},
+			{"boot indicator", func(b []byte) []byte { b[0x20] = 0x77; return b }},
+			{"truncated section", func(b []byte) []byte { return b[:0x70] }},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c := tt.modify(append([]byte{}, b...))
+				if _, err := parseCatalog(c); err == nil {
+					t.Errorf("no error")
+				}
+			})
+		}
+	})
+}
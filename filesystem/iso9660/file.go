@@ -26,39 +26,45 @@ func (fl *File) Read(b []byte) (int, error) {
 	if fl == nil || fl.closed {
 		return 0, os.ErrClosed
 	}
-	// we have the DirectoryEntry, so we can get the starting location and size
-	// since iso9660 files are contiguous, we only need the starting location and size
-	//   to get the entire file
 	fs := fl.filesystem
-	size := int(fl.size) - int(fl.offset)
-	location := int(fl.location)
-	maxRead := size
+	size := fl.Size()
 	file := fs.file
 
 	// if there is nothing left to read, just return EOF
-	if size <= 0 {
+	if fl.offset >= size {
 		return 0, io.EOF
 	}
 
+	// iso9660 files are contiguous in each extent, so we only need the location and size of each; a file has
+	//   several extents only if it is too large for the size of one record
 	// we stop when we hit the lesser of
 	//   1- len(b)
 	//   2- file end
-	if len(b) < maxRead {
-		maxRead = len(b)
+	read := 0
+	start := int64(0)
+	for _, e := range append([]*directoryEntry{fl.directoryEntry}, fl.more...) {
+		end := start + int64(e.size)
+		if fl.offset < end && read < len(b) {
+			maxRead := len(b) - read
+			if left := end - fl.offset; left < int64(maxRead) {
+				maxRead = int(left)
+			}
+			// just read the requested number of bytes and change our offset
+			_, err := file.ReadAt(b[read:read+maxRead], int64(e.location)*fs.blocksize+fl.offset-start)
+			if err != nil && err != io.EOF {
+				return read, err
+			}
+			read += maxRead
+			fl.offset += int64(maxRead)
+		}
+		start = end
 	}
 
-	// just read the requested number of bytes and change our offset
-	_, err := file.ReadAt(b[0:maxRead], int64(location)*fs.blocksize+fl.offset)
-	if err != nil && err != io.EOF {
-		return 0, err
-	}
-
-	fl.offset += int64(maxRead)
 	var retErr error
-	if fl.offset >= int64(fl.size) {
+	if fl.offset >= size {
 		retErr = io.EOF
 	}
-	return maxRead, retErr
+	return read, retErr
 }
 
 // Write writes len(b) bytes to the File.
@@ -78,7 +84,7 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekStart:
 		newOffset = offset
 	case io.SeekEnd:
-		newOffset = int64(fl.size) + offset
+		newOffset = fl.Size() + offset
 	case io.SeekCurrent:
 		newOffset = fl.offset + offset
 	}
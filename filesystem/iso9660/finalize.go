@@ -3,6 +3,7 @@ package iso9660
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/diskfs/go-diskfs/filesystem/udf"
 	"github.com/diskfs/go-diskfs/util"
 )
 
@@ -19,6 +21,10 @@ const (
 	defaultVolumeIdentifier = "ISOIMAGE"
 )
 
+// maxExtentSize is the most bytes of a file in one extent, as the size in a directory record has 32 bits. A larger
+// file has several extents, each with a record of its own, as ISO 9660 level 3 allows.
+const maxExtentSize int64 = math.MaxUint32
+
 // FinalizeOptions options to pass to finalize
 type FinalizeOptions struct {
 	// RockRidge enable Rock Ridge extensions
@@ -29,6 +35,16 @@ type FinalizeOptions struct {
 	ElTorito *ElTorito
 	// VolumeIdentifier custom volume name, defaults to "ISOIMAGE"
 	VolumeIdentifier string
+	// Joliet add a Joliet supplementary volume descriptor and directory tree, which keep the case and length of
+	// names, up to 64 characters, for Windows. With Joliet or Rock Ridge, names that are too long for the primary
+	// tree, or the same there as another, are cut short and numbered in it, as in LONGNA_1.TXT
+	Joliet bool
+	// UDF also write the structures of a UDF filesystem with these options, for an iso9660/UDF bridge image, in
+	// which both filesystems have the same files, sharing their data, and UDF can have files of more than 4 GB
+	UDF *udf.FinalizeOptions
+	// Hybrid also make the image bootable from a USB stick or hard disk, with a partition table in the system
+	// area; it needs ElTorito
+	Hybrid *Hybrid
 }
 
 // finalizeFileInfo is a file info useful for finalization
@@ -48,6 +64,8 @@ type finalizeFileInfo struct {
 	shortname          string
 	extension          string
 	location           uint32
+	jolietLocation     uint32 // location of the directory in the Joliet tree
+	jolietSize         int64  // size of the directory in the Joliet tree
 	blocks             uint32 // blocks for the directory itself and its entries
 	continuationBlocks uint32 // blocks for CE entries
 	recordSize         uint8
@@ -65,6 +83,8 @@ type finalizeFileInfo struct {
 	trueChild          *finalizeFileInfo
 	elToritoEntry      *ElToritoEntry
 	content            []byte
+	xattrs             map[string][]byte
+	posix              *posixAttributes
 }
 
 func (fi *finalizeFileInfo) Name() string {
@@ -76,6 +96,14 @@ func (fi *finalizeFileInfo) Name() string {
 	// shortname already is ucased
 	return ret
 }
+
+// jolietName is the name of the entry in the Joliet tree, which is the original one, with the version for a file
+func (fi *finalizeFileInfo) jolietName() string {
+	if fi.isDir {
+		return fi.name
+	}
+	return fi.name + ";1"
+}
 func (fi *finalizeFileInfo) Size() int64 {
 	return fi.size
 }
@@ -92,6 +120,15 @@ func (fi *finalizeFileInfo) Sys() interface{} {
 	return nil
 }
 
+// setStaged sets the xattrs and POSIX attributes staged for the entry in fs
+func (fi *finalizeFileInfo) setStaged(fs *FileSystem) {
+	p := path.Join("/", fi.path)
+	fi.xattrs = fs.stagedXattrs[p]
+	if posix, ok := fs.stagedPosix[p]; ok {
+		fi.posix = &posix
+	}
+}
+
 func (fi *finalizeFileInfo) updateDepth(depth int) {
 	fi.depth = depth
 	if fi.isDir {
@@ -101,7 +138,8 @@ func (fi *finalizeFileInfo) updateDepth(depth int) {
 	}
 }
 
-func (fi *finalizeFileInfo) toDirectoryEntry(fs *FileSystem, isSelf, isParent bool) (*directoryEntry, error) {
+// toDirectoryEntry converts the entry to a directory entry, in the primary tree or, if joliet, in the Joliet one
+func (fi *finalizeFileInfo) toDirectoryEntry(fs *FileSystem, isSelf, isParent, joliet bool) (*directoryEntry, error) {
 	de := &directoryEntry{
 		extAttrSize:              0,
 		location:                 fi.location,
@@ -120,6 +158,16 @@ func (fi *finalizeFileInfo) toDirectoryEntry(fs *FileSystem, isSelf, isParent bo
 		// we keep the full filename until after processing
 		filename: fi.Name(),
 	}
+	// the Joliet tree has its own directories, and no SUSP
+	if joliet {
+		de.filename = fi.jolietName()
+		de.joliet = true
+		if fi.isDir {
+			de.location = fi.jolietLocation
+			de.size = uint32(fi.jolietSize)
+		}
+		return de, nil
+	}
 	// if it is root, and we have susp enabled, add the necessary entries
 	if fs.suspEnabled {
 		if fi.isRoot && isSelf {
@@ -136,27 +184,72 @@ func (fi *finalizeFileInfo) toDirectoryEntry(fs *FileSystem, isSelf, isParent bo
 				return nil, fmt.Errorf("error getting finalize extensions for %s at path %s: %v", e.ID(), fi.path, err)
 			}
 			ext = append(ext, ext2...)
+			// staged permissions and owner replace those in the workspace
+			for i, x := range ext {
+				if px, ok := x.(rockRidgePosixAttributes); ok && fi.posix != nil {
+					px.mode = px.mode&^posixPermMask | fi.posix.perm
+					px.uid, px.gid = fi.posix.uid, fi.posix.gid
+					ext[i] = px
+				}
+			}
 			de.extensions = append(de.extensions, ext...)
 		}
+		// AL, for the entry of the file itself, which for the root is its self entry
+		if !isParent && (!isSelf || fi.isRoot) && len(fi.xattrs) > 0 {
+			de.extensions = append(de.extensions, aaipEntries(fi.xattrs)...)
+		}
 
 		if fi.isRoot && isSelf {
 			for _, e := range fs.suspExtensions {
 				de.extensions = append(de.extensions, directoryEntrySystemUseExtensionReference{id: e.ID(), descriptor: e.Descriptor(), source: e.Source(), extensionVersion: e.Version()})
 			}
+			if len(fs.stagedXattrs) > 0 {
+				de.extensions = append(de.extensions, directoryEntrySystemUseExtensionReference{id: aaipID, descriptor: aaipDescriptor, source: aaipSource, extensionVersion: 1})
+			}
 		}
 	}
 	return de, nil
 }
-func (fi *finalizeFileInfo) toDirectory(fs *FileSystem) (*Directory, error) {
+
+// toDirectoryEntries converts a child entry to its directory records: one, or for a file that is too large for
+// one extent, one for each of its contiguous extents, all but the last flagged as having more. Only the first
+// has the extensions.
+func (fi *finalizeFileInfo) toDirectoryEntries(fs *FileSystem, joliet bool) ([]*directoryEntry, error) {
+	de, err := fi.toDirectoryEntry(fs, false, false, joliet)
+	if err != nil {
+		return nil, err
+	}
+	extent := fs.maxExtentSize / fs.blocksize * fs.blocksize
+	if fi.isDir || fi.size <= extent {
+		return []*directoryEntry{de}, nil
+	}
+	var records []*directoryEntry
+	for offset := int64(0); offset < fi.size; offset += extent {
+		record := *de
+		if offset > 0 {
+			record.extensions = nil
+		}
+		record.location = fi.location + uint32(offset/fs.blocksize)
+		record.size = uint32(extent)
+		if left := fi.size - offset; left < extent {
+			record.size = uint32(left)
+		}
+		record.hasMoreEntries = offset+extent < fi.size
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+func (fi *finalizeFileInfo) toDirectory(fs *FileSystem, joliet bool) (*Directory, error) {
 	// also need to add self and parent to it
 	var (
-		self, parent, dirEntry *directoryEntry
-		err                    error
+		self, parent *directoryEntry
+		err          error
 	)
 	if !fi.IsDir() {
 		return nil, fmt.Errorf("cannot convert a file entry to a directtory")
 	}
-	self, err = fi.toDirectoryEntry(fs, true, false)
+	self, err = fi.toDirectoryEntry(fs, true, false, joliet)
 	if err != nil {
 		return nil, fmt.Errorf("could not convert self entry %s to dirEntry: %v", fi.path, err)
 	}
@@ -167,18 +260,18 @@ func (fi *finalizeFileInfo) toDirectory(fs *FileSystem) (*Directory, error) {
 	if fi.isRoot {
 		parentEntry = fi
 	}
-	parent, err = parentEntry.toDirectoryEntry(fs, false, true)
+	parent, err = parentEntry.toDirectoryEntry(fs, false, true, joliet)
 	if err != nil {
 		return nil, fmt.Errorf("could not convert parent entry %s to dirEntry: %v", fi.parent.path, err)
 	}
 
 	entries := []*directoryEntry{self, parent}
 	for _, child := range fi.children {
-		dirEntry, err = child.toDirectoryEntry(fs, false, false)
+		records, err := child.toDirectoryEntries(fs, joliet)
 		if err != nil {
 			return nil, fmt.Errorf("could not convert child entry %s to dirEntry: %v", child.path, err)
 		}
-		entries = append(entries, dirEntry)
+		entries = append(entries, records...)
 	}
 	d := &Directory{
 		directoryEntry: *self,
@@ -188,13 +281,18 @@ func (fi *finalizeFileInfo) toDirectory(fs *FileSystem) (*Directory, error) {
 }
 
 // calculate the size of a directory entry single record
-func (fi *finalizeFileInfo) calculateRecordSize(fs *FileSystem, isSelf, isParent bool) (dirEntrySize, continuationBlocksSize int, err error) {
-	// we do not actually need the the continuation blocks to calculate size, just length, so use an empty slice
-	extTmpBlocks := make([]uint32, 100)
-	dirEntry, err := fi.toDirectoryEntry(fs, isSelf, isParent)
+func (fi *finalizeFileInfo) calculateRecordSize(fs *FileSystem, isSelf, isParent, joliet bool) (dirEntrySize, continuationBlocksSize int, err error) {
+	dirEntry, err := fi.toDirectoryEntry(fs, isSelf, isParent, joliet)
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not convert to dirEntry: %v", err)
 	}
+	return recordSize(dirEntry)
+}
+
+// recordSize calculates the size of a directory record and how many continuation blocks it has
+func recordSize(dirEntry *directoryEntry) (dirEntrySize, continuationBlocksSize int, err error) {
+	// we do not actually need the the continuation blocks to calculate size, just length, so use an empty slice
+	extTmpBlocks := make([]uint32, 100)
 	dirBytes, err := dirEntry.toBytes(false, extTmpBlocks)
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not convert dirEntry to bytes: %v", err)
@@ -205,21 +303,21 @@ func (fi *finalizeFileInfo) calculateRecordSize(fs *FileSystem, isSelf, isParent
 }
 
 // calculate the size of a directory, similar to a file size
-func (fi *finalizeFileInfo) calculateDirectorySize(fs *FileSystem) (dirEntrySize, continuationBlocksSize int, err error) {
+func (fi *finalizeFileInfo) calculateDirectorySize(fs *FileSystem, joliet bool) (dirEntrySize, continuationBlocksSize int, err error) {
 	var (
 		recSize, recCE int
 	)
 	if !fi.IsDir() {
 		return 0, 0, fmt.Errorf("cannot convert a file entry to a directtory")
 	}
-	recSize, recCE, err = fi.calculateRecordSize(fs, true, false)
+	recSize, recCE, err = fi.calculateRecordSize(fs, true, false, joliet)
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not calculate self entry size %s: %v", fi.path, err)
 	}
 	dirEntrySize += recSize
 	continuationBlocksSize += recCE
 
-	recSize, recCE, err = fi.calculateRecordSize(fs, false, true)
+	recSize, recCE, err = fi.calculateRecordSize(fs, false, true, joliet)
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not calculate parent entry size %s: %v", fi.path, err)
 	}
@@ -227,20 +325,26 @@ func (fi *finalizeFileInfo) calculateDirectorySize(fs *FileSystem) (dirEntrySize
 	continuationBlocksSize += recCE
 
 	for _, e := range fi.children {
-		// get size of data and CE blocks
-		recSize, recCE, err = e.calculateRecordSize(fs, false, false)
+		records, err := e.toDirectoryEntries(fs, joliet)
 		if err != nil {
-			return 0, 0, fmt.Errorf("could not calculate child %s entry size %s: %v", e.path, fi.path, err)
+			return 0, 0, fmt.Errorf("could not convert child %s entry of %s: %v", e.path, fi.path, err)
 		}
-		// do not go over a block boundary; pad if necessary
-		newSize := dirEntrySize + recSize
-		blocksize := int(fs.blocksize)
-		left := blocksize - dirEntrySize%blocksize
-		if left != 0 && newSize/blocksize > dirEntrySize/blocksize {
-			dirEntrySize += left
+		for _, record := range records {
+			// get size of data and CE blocks
+			recSize, recCE, err = recordSize(record)
+			if err != nil {
+				return 0, 0, fmt.Errorf("could not calculate child %s entry size %s: %v", e.path, fi.path, err)
+			}
+			// do not go over a block boundary; pad if necessary
+			newSize := dirEntrySize + recSize
+			blocksize := int(fs.blocksize)
+			left := blocksize - dirEntrySize%blocksize
+			if left != 0 && newSize/blocksize > dirEntrySize/blocksize {
+				dirEntrySize += left
+			}
+			continuationBlocksSize += recCE
+			dirEntrySize += recSize
 		}
-		continuationBlocksSize += recCE
-		dirEntrySize += recSize
 	}
 	return dirEntrySize, continuationBlocksSize, nil
 }
@@ -338,6 +442,42 @@ func (fi *finalizeFileInfo) addChild(entry *finalizeFileInfo) {
 	fi.children = append(fi.children, entry)
 }
 
+// uniqueShortnames makes the primary names of the children of the directory, and of theirs, legal and unique in
+// their directory, for when Joliet or Rock Ridge has the real names
+func (fi *finalizeFileInfo) uniqueShortnames() {
+	used := make(map[string]bool)
+	for _, e := range fi.children {
+		e.uniqueShortname(used)
+		if e.isDir {
+			e.uniqueShortnames()
+		}
+	}
+}
+
+// uniqueShortname makes the primary name of the entry legal, if it is not, and different from the names in used,
+// which it is added to. A name that has to change is cut short, with an extension of at most 3 characters, and
+// numbered, as in LONGNA_1.TXT, as ~ is not one of the characters a primary name can have.
+func (fi *finalizeFileInfo) uniqueShortname(used map[string]bool) {
+	if validateFilename(fi.Name(), fi.isDir) != nil || used[fi.Name()] {
+		if len(fi.extension) > 3 {
+			fi.extension = fi.extension[:3]
+		}
+		base := fi.shortname
+		for n := 1; ; n++ {
+			suffix := fmt.Sprintf("_%d", n)
+			fi.shortname = base + suffix
+			for validateFilename(fi.Name(), fi.isDir) != nil && base != "" {
+				base = base[:len(base)-1]
+				fi.shortname = base + suffix
+			}
+			if !used[fi.Name()] {
+				break
+			}
+		}
+	}
+	used[fi.Name()] = true
+}
+
 // Finalize finalize a read-only filesystem by writing it out to a read-only format
 //
 //nolint:gocyclo // this finalize function is complex and needs to be. We might be better off refactoring it to multiple functions, but it does not buy all that much.
@@ -345,6 +485,15 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if fs.workspace == "" {
 		return fmt.Errorf("cannot finalize an already finalized filesystem")
 	}
+	// a workspace from Remaster is written as the image it was loaded from, unless the options are another
+	if options == (FinalizeOptions{}) && fs.remasterOptions != nil {
+		options = *fs.remasterOptions
+	}
+	if options.Hybrid != nil {
+		if err := options.Hybrid.validate(options.ElTorito); err != nil {
+			return fmt.Errorf("cannot make hybrid image: %v", err)
+		}
+	}
 
 	// did we ask for susp?
 	if options.RockRidge {
@@ -378,6 +527,14 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		 8- repeat steps 6&7 for all other directories
 		 9- write PVD
 		 10- write volume descriptor set terminator
+
+		with Joliet, its directories follow the primary ones, and its path tables the primary ones, while the files
+		are shared by both trees; its supplementary volume descriptor follows the PVD and any boot volume descriptor
+
+		with UDF, its volume recognition sequence follows the terminator, its volume descriptors and file entries
+		come before the root directory, which is after block 256, and its closing anchor follows the data
+
+		a hybrid image has an MBR, or a GPT, in the system area, and a GPT has its backup in the last blocks
 	*/
 
 	f := fs.file
@@ -399,6 +556,30 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		return fmt.Errorf("error walking tree: %v", err)
 	}
 
+	// xattrs and staged POSIX attributes are only kept with Rock Ridge
+	if fs.suspEnabled {
+		for _, fi := range fileList {
+			fi.setStaged(fs)
+		}
+		for _, fi := range dirList {
+			fi.setStaged(fs)
+		}
+	}
+
+	volIdentifier := defaultVolumeIdentifier
+	if options.VolumeIdentifier != "" {
+		volIdentifier = options.VolumeIdentifier
+	}
+
+	// lay out the UDF structures while the workspace is still there
+	var bridge *udf.Bridge
+	if options.UDF != nil {
+		bridge, err = udf.NewBridge(f, fs.workspace, fs.blocksize, volIdentifier, *options.UDF)
+		if err != nil {
+			return fmt.Errorf("could not create UDF structures: %v", err)
+		}
+	}
+
 	// starting point
 	root := dirList["."]
 	root.addProperties(1)
@@ -429,6 +610,11 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		}
 	}
 
+	// with Joliet or Rock Ridge, which have the real names, the primary ones need only be legal and unique
+	if options.Joliet || options.RockRidge {
+		root.uniqueShortnames()
+	}
+
 	// convert sizes to required blocks for files
 	for _, e := range fileList {
 		e.blocks = calculateBlocks(e.size, fs.blocksize)
@@ -447,6 +633,14 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if options.ElTorito != nil {
 		rootLocation++
 	}
+	// and one for the Joliet supplementary volume descriptor
+	if options.Joliet {
+		rootLocation++
+	}
+	// and with UDF, its structures come first
+	if bridge != nil {
+		rootLocation = bridge.DataStart()
+	}
 	location := rootLocation
 
 	var (
@@ -486,7 +680,18 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 			if err != nil {
 				return fmt.Errorf("error finding parent for boot catalog %s: %v", catname, err)
 			}
+			if options.Joliet || options.RockRidge {
+				used := make(map[string]bool)
+				for _, e := range parent.children {
+					used[e.Name()] = true
+				}
+				catEntry.uniqueShortname(used)
+			}
 			parent.addChild(catEntry)
+			// Rock Ridge reads the attributes of the catalog from the workspace, as for any other file
+			if err := os.WriteFile(path.Join(fs.workspace, catname), bootcat, 0o644); err != nil {
+				return fmt.Errorf("could not write boot catalog %s to workspace: %v", catname, err)
+			}
 		}
 		for _, e := range options.ElTorito.Entries {
 			var parent, child *finalizeFileInfo
@@ -504,7 +709,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 				}
 			}
 			// save the child so we can add location late
-			e.size = uint16(child.size)
+			e.size = uint32(child.size)
 			child.elToritoEntry = e
 		}
 	}
@@ -512,7 +717,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	var size, ceBlocks int
 	for _, dir := range dirs {
 		dir.location = location
-		size, ceBlocks, err = dir.calculateDirectorySize(fs)
+		size, ceBlocks, err = dir.calculateDirectorySize(fs, false)
 		if err != nil {
 			return fmt.Errorf("unable to calculate size of directory for %s: %v", dir.path, err)
 		}
@@ -521,13 +726,24 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		dir.continuationBlocks = uint32(ceBlocks)
 		location += dir.blocks + dir.continuationBlocks
 	}
+	if options.Joliet {
+		for _, dir := range dirs {
+			dir.jolietLocation = location
+			size, _, err = dir.calculateDirectorySize(fs, true)
+			if err != nil {
+				return fmt.Errorf("unable to calculate size of Joliet directory for %s: %v", dir.path, err)
+			}
+			dir.jolietSize = int64(size)
+			location += calculateBlocks(int64(size), int64(blocksize))
+		}
+	}
 
 	// we now have sorted list of block order, with sizes and number of blocks on each
 	// next assign the blocks to each, and then we can enter the data in the directory entries
 
 	// create the pathtables (L & M)
 	// with the list of directories, we can make a path table
-	pathTable := createPathTable(dirs)
+	pathTable := createPathTable(dirs, false)
 	// how big is the path table? we will take LSB for now, because they are the same size
 	pathTableLBytes := pathTable.toLBytes()
 	pathTableMBytes := pathTable.toMBytes()
@@ -542,12 +758,22 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	pathTableMLocation := location
 	location += pathTableBlocks
 
-	// if we asked for ElTorito, need to generate the boot catalog and save it
-	volIdentifier := defaultVolumeIdentifier
-	if options.VolumeIdentifier != "" {
-		volIdentifier = options.VolumeIdentifier
+	var (
+		jolietPathTableLBytes, jolietPathTableMBytes       []byte
+		jolietPathTableLLocation, jolietPathTableMLocation uint32
+	)
+	if options.Joliet {
+		jolietPathTable := createPathTable(dirs, true)
+		jolietPathTableLBytes = jolietPathTable.toLBytes()
+		jolietPathTableMBytes = jolietPathTable.toMBytes()
+		jolietPathTableBlocks := calculateBlocks(int64(len(jolietPathTableLBytes)), int64(blocksize))
+		jolietPathTableLLocation = location
+		location += jolietPathTableBlocks
+		jolietPathTableMLocation = location
+		location += jolietPathTableBlocks
 	}
 
+	// if we asked for ElTorito, need to generate the boot catalog and save it
 	for _, e := range files {
 		e.location = location
 		location += e.blocks
@@ -566,7 +792,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	for _, e := range dirs {
 		writeAt := int64(e.location) * int64(blocksize)
 		var d *Directory
-		d, err = e.toDirectory(fs)
+		d, err = e.toDirectory(fs, false)
 		if err != nil {
 			return fmt.Errorf("unable to convert entry to directory: %v", err)
 		}
@@ -582,8 +808,26 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		if err != nil {
 			return fmt.Errorf("could not convert directory to bytes: %v", err)
 		}
-		for i, e := range p {
-			_, _ = f.WriteAt(e, writeAt+int64(i*blocksize))
+		_, _ = f.WriteAt(p[0], writeAt)
+		for i, e := range p[1:] {
+			_, _ = f.WriteAt(e, int64(ceLocations[i])*int64(blocksize))
+		}
+	}
+
+	// the Joliet directories have no continuation areas
+	if options.Joliet {
+		for _, e := range dirs {
+			var d *Directory
+			d, err = e.toDirectory(fs, true)
+			if err != nil {
+				return fmt.Errorf("unable to convert entry to Joliet directory: %v", err)
+			}
+			var p [][]byte
+			p, err = d.entriesToBytes(nil)
+			if err != nil {
+				return fmt.Errorf("could not convert Joliet directory to bytes: %v", err)
+			}
+			_, _ = f.WriteAt(p[0], int64(e.jolietLocation)*int64(blocksize))
 		}
 	}
 
@@ -592,6 +836,10 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	_, _ = f.WriteAt(pathTableLBytes, writeAt)
 	writeAt = int64(pathTableMLocation) * int64(blocksize)
 	_, _ = f.WriteAt(pathTableMBytes, writeAt)
+	if options.Joliet {
+		_, _ = f.WriteAt(jolietPathTableLBytes, int64(jolietPathTableLLocation)*int64(blocksize))
+		_, _ = f.WriteAt(jolietPathTableMBytes, int64(jolietPathTableMLocation)*int64(blocksize))
+	}
 
 	var closeFiles []*os.File
 	defer func() {
@@ -605,6 +853,9 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 			copied int
 		)
 		writeAt := int64(e.location) * int64(blocksize)
+		if e.mode&os.ModeSymlink == os.ModeSymlink {
+			continue
+		}
 		if e.content == nil {
 			// for file, just copy the data across
 			from, err = os.Open(path.Join(fs.workspace, e.path))
@@ -653,19 +904,28 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 				return fmt.Errorf("failed to write content of %s to disk: %v", e.path, err)
 			}
 		}
-		// fill in
+		// fill in the rest of the last block, if it is not full, so that the image ends with it
 		left := blocksize - (copied % blocksize)
-		if left > 0 {
+		if left > 0 && left < blocksize {
 			b2 := make([]byte, left)
 			_, _ = f.WriteAt(b2, writeAt+int64(copied))
 		}
 	}
 
 	totalSize := location
+	// the closing anchor of UDF takes another block
+	udfAnchor := totalSize
+	if bridge != nil {
+		totalSize++
+	}
+	// as does the backup GPT of a hybrid image
+	if options.Hybrid != nil && options.Hybrid.GPT {
+		totalSize += calculateBlocks(gptBackupSize, fs.blocksize)
+	}
 	location = dataStartSector
 	// create and write the primary volume descriptor, supplementary and boot, and volume descriptor set terminator
 	now := time.Now()
-	rootDE, err := root.toDirectoryEntry(fs, true, false)
+	rootDE, err := root.toDirectoryEntry(fs, true, false, false)
 	if err != nil {
 		return fmt.Errorf("could not convert root entry for primary volume descriptor to dirEntry: %v", err)
 	}
@@ -706,13 +966,64 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
 		location++
 	}
+	if options.Joliet {
+		var jolietRootDE *directoryEntry
+		jolietRootDE, err = root.toDirectoryEntry(fs, true, false, true)
+		if err != nil {
+			return fmt.Errorf("could not convert root entry for Joliet volume descriptor to dirEntry: %v", err)
+		}
+		svd := &supplementaryVolumeDescriptor{
+			volumeIdentifier:   volIdentifier,
+			volumeSize:         uint64(totalSize) * uint64(fs.blocksize),
+			escapeSequences:    jolietEscapeSequences[2],
+			setSize:            1,
+			sequenceNumber:     1,
+			blocksize:          uint16(fs.blocksize),
+			pathTableSize:      uint32(len(jolietPathTableLBytes)),
+			pathTableLLocation: jolietPathTableLLocation,
+			pathTableMLocation: jolietPathTableMLocation,
+			preparerIdentifier: util.AppNameVersion,
+			creation:           now,
+			modification:       now,
+			expiration:         now,
+			effective:          now,
+			rootDirectoryEntry: jolietRootDE,
+		}
+		b = svd.toBytes()
+		_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
+		location++
+	}
 	terminator := &terminatorVolumeDescriptor{}
 	b = terminator.toBytes()
 	_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
 
+	if bridge != nil {
+		locations := make(map[string]uint32, len(files))
+		for _, e := range files {
+			locations[filepath.ToSlash(e.path)] = e.location
+		}
+		err = bridge.Write(location+1, udfAnchor, func(p string) (uint32, error) {
+			l, ok := locations[p]
+			if !ok {
+				return 0, fmt.Errorf("not in the iso9660 filesystem")
+			}
+			return l, nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not write UDF structures: %v", err)
+		}
+	}
+
+	if options.Hybrid != nil {
+		if err := options.Hybrid.write(f, options.ElTorito, fs.blocksize, int64(totalSize)*fs.blocksize); err != nil {
+			return fmt.Errorf("could not write hybrid partition table: %v", err)
+		}
+	}
+
 	_ = os.RemoveAll(fs.workspace)
 
 	// finish by setting as finalized
+	fs.volumes.primary = pvd
 	fs.workspace = ""
 	return nil
 }
@@ -769,8 +1080,9 @@ func sortFinalizeFileInfoPathTable(left, right *finalizeFileInfo) bool {
 	}
 }
 
-// create a path table from a slice of *finalizeFileInfo that are directories
-func createPathTable(fi []*finalizeFileInfo) *pathTable {
+// create a path table from a slice of *finalizeFileInfo that are directories, for the primary tree or, if joliet,
+// the Joliet one
+func createPathTable(fi []*finalizeFileInfo, joliet bool) *pathTable {
 	// copy so we do not modify the original
 	fs := make([]*finalizeFileInfo, len(fi))
 	copy(fs, fi)
@@ -783,6 +1095,14 @@ func createPathTable(fi []*finalizeFileInfo) *pathTable {
 	entries := make([]*pathTableEntry, 0)
 	for i, e := range fs {
 		name := e.Name()
+		location := e.location
+		if joliet {
+			// the root keeps its single 0x00 byte
+			if !e.isRoot {
+				name = string(ucs2StringToBytes(e.jolietName()))
+			}
+			location = e.jolietLocation
+		}
 		nameSize := len(name)
 		size := 8 + uint16(nameSize)
 		if nameSize%2 != 0 {
@@ -799,7 +1119,7 @@ func createPathTable(fi []*finalizeFileInfo) *pathTable {
 			nameSize:      uint8(nameSize),
 			size:          size,
 			extAttrLength: 0,
-			location:      e.location,
+			location:      location,
 			parentIndex:   uint16(parentIndex),
 			dirname:       name,
 		}
@@ -846,8 +1166,11 @@ func walkTree(workspace string) ([]*finalizeFileInfo, map[string]*finalizeFileIn
 				dirList[parentDir] = parentDirInfo
 			}
 		} else {
-			// calculate blocks
+			// calculate blocks; a symlink has no content of its own, just the target in its Rock Ridge entry
 			entry.size = fi.Size()
+			if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+				entry.size = 0
+			}
 			entry.extension = extension
 			parentDirInfo.children = append(parentDirInfo.children, entry)
 			dirList[parentDir] = parentDirInfo
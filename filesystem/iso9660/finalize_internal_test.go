@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"testing"
+
+	"github.com/diskfs/go-diskfs/util"
 )
 
 func TestCopyFileData(t *testing.T) {
@@ -107,8 +109,8 @@ func TestCreatePathTable(t *testing.T) {
 			{nameSize: 5, size: 14, extAttrLength: 0, location: 32, parentIndex: 3, dirname: "SHORT"},
 		},
 	}
-	pt := createPathTable(input)
-	// createPathTable(fi []*finalizeFileInfo) *pathTable
+	pt := createPathTable(input, false)
+	// createPathTable(fi []*finalizeFileInfo, joliet bool) *pathTable
 	if !pt.equal(expected) {
 		t.Errorf("pathTable not as expected, actual then expected\n%#v\n%#v", pt.names(), expected.names())
 	}
@@ -200,3 +202,150 @@ func TestCollapseAndSortChildren(t *testing.T) {
 		t.Log(output)
 	}
 }
+
+func TestUniqueShortnames(t *testing.T) {
+	names := []struct {
+		name  string
+		isDir bool
+	}{
+		{"README.TXT", false},
+		{"readme.txt", false},
+		{"A name of more than thirty characters.txt", false},
+		{"Quarterly report for the first half of the year.txt", false},
+		{"Quarterly report for the second half of the year.txt", false},
+		{"archive.tar.gz", false},
+		{".profile", false},
+		{"docs.old", true},
+		{"docs.new", true},
+		{"A directory of more than thirty characters", true},
+	}
+	expected := []string{
+		"README.TXT;1",
+		"README_1.TXT;1",
+		"A_NAME_OF_MORE_THAN_THI_1.TXT;1",
+		"QUARTERLY_REPORT_FOR_TH_1.TXT;1",
+		"QUARTERLY_REPORT_FOR_TH_2.TXT;1",
+		"ARCHIVE.TAR_GZ;1",
+		"_1.PRO;1",
+		"DOCS",
+		"DOCS_1",
+		"A_DIRECTORY_OF_MORE_THAN_THI_1",
+	}
+	root := &finalizeFileInfo{name: ".", isDir: true, isRoot: true}
+	for _, n := range names {
+		shortname, extension := calculateShortnameExtension(n.name)
+		if n.isDir {
+			extension = ""
+		}
+		root.addChild(&finalizeFileInfo{name: n.name, shortname: shortname, extension: extension, isDir: n.isDir})
+	}
+	root.uniqueShortnames()
+	for i, e := range root.children {
+		if e.Name() != expected[i] {
+			t.Errorf("%s has primary name %s instead of %s", e.name, e.Name(), expected[i])
+		}
+		if err := validateFilename(e.Name(), e.isDir); err != nil {
+			t.Errorf("%s has invalid primary name %s: %v", e.name, e.Name(), err)
+		}
+	}
+}
+
+func TestFinalizeMultiExtent(t *testing.T) {
+	content := make([]byte, 5*2048+1000)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("error getting random bytes: %v", err)
+	}
+	tests := []struct {
+		name    string
+		options FinalizeOptions
+		file    string
+	}{
+		{"plain", FinalizeOptions{}, "/LARGE.BIN"},
+		{"rock ridge", FinalizeOptions{RockRidge: true}, "/large.bin"},
+		{"joliet", FinalizeOptions{Joliet: true}, "/large.bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := util.NewMemFile(0)
+			fs, err := Create(m, 0, 0, 2048, "")
+			if err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			// a small extent size, rather than files of more than 4 GB
+			fs.maxExtentSize = 2*2048 + 100
+			for _, p := range []string{tt.file, "/SMALL.TXT"} {
+				f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("error creating %s: %v", p, err)
+				}
+				b := content
+				if p == "/SMALL.TXT" {
+					b = []byte("small")
+				}
+				if _, err := f.Write(b); err != nil {
+					t.Fatalf("error writing %s: %v", p, err)
+				}
+			}
+			if err := fs.Finalize(tt.options); err != nil {
+				t.Fatalf("error finalizing: %v", err)
+			}
+
+			fs, err = Read(m, m.Size(), 0, 2048)
+			if err != nil {
+				t.Fatalf("error reading filesystem: %v", err)
+			}
+			de, err := fs.lstat(tt.file)
+			if err != nil {
+				t.Fatalf("error finding %s: %v", tt.file, err)
+			}
+			// extents of 2 blocks, the last of the rest
+			records := append([]*directoryEntry{de}, de.more...)
+			sizes := []uint32{4096, 4096, 3048}
+			if len(records) != len(sizes) {
+				t.Fatalf("%d records instead of %d", len(records), len(sizes))
+			}
+			for i, r := range records {
+				if r.size != sizes[i] || r.hasMoreEntries != (i < len(sizes)-1) || r.location != de.location+uint32(2*i) {
+					t.Errorf("record %d: size %d, more %v at %d", i, r.size, r.hasMoreEntries, r.location)
+				}
+			}
+			if de.Size() != int64(len(content)) {
+				t.Errorf("size %d instead of %d", de.Size(), len(content))
+			}
+			// the records are one entry
+			infos, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatalf("error reading root directory: %v", err)
+			}
+			if len(infos) != 2 {
+				t.Errorf("%d entries in root directory instead of 2", len(infos))
+			}
+			f, err := fs.OpenFile(tt.file, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("error opening %s: %v", tt.file, err)
+			}
+			// small reads cross the ends of the extents
+			var b []byte
+			buf := make([]byte, 1000)
+			for {
+				n, err := f.Read(buf)
+				b = append(b, buf[:n]...)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("error reading %s: %v", tt.file, err)
+				}
+			}
+			if !bytes.Equal(b, content) {
+				t.Errorf("mismatched content of %d bytes instead of %d", len(b), len(content))
+			}
+			if _, err := f.Seek(4000, io.SeekStart); err != nil {
+				t.Fatalf("error seeking: %v", err)
+			}
+			if _, err := io.ReadFull(f, buf); err != nil || !bytes.Equal(buf, content[4000:5000]) {
+				t.Errorf("mismatched content at 4000, %v", err)
+			}
+		})
+	}
+}
@@ -3,15 +3,19 @@ package iso9660_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"testing"
 
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/testhelper"
 )
@@ -96,6 +100,135 @@ func TestFinalizeElTorito(t *testing.T) {
 	}
 }
 
+// test creating a hybrid iso that boots from a disk
+func TestFinalizeHybrid(t *testing.T) {
+	bootCode := bytes.Repeat([]byte{0xfa}, 432)
+	sizes := map[string]int{"/isolinux.bin": 24 * 1024, "/efiboot.img": 1440 * 1024}
+	create := func(t *testing.T, hybrid *iso9660.Hybrid) (*os.File, *iso9660.ElTorito) {
+		t.Helper()
+		f, err := os.CreateTemp("", "iso_finalize_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		t.Cleanup(func() {
+			f.Close()
+			os.Remove(f.Name())
+		})
+		fs, err := iso9660.Create(f, 0, 0, 2048, "")
+		if err != nil {
+			t.Fatalf("Failed to iso9660.Create: %v", err)
+		}
+		for filename, size := range sizes {
+			isofile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("Failed to iso9660.OpenFile(%s): %v", filename, err)
+			}
+			if _, err := isofile.Write(make([]byte, size)); err != nil {
+				t.Fatalf("error writing to %s: %v", filename, err)
+			}
+		}
+		et := &iso9660.ElTorito{
+			Platform:        iso9660.BIOS,
+			HideBootCatalog: true,
+			Entries: []*iso9660.ElToritoEntry{
+				{Emulation: iso9660.NoEmulation, BootFile: "/isolinux.bin", LoadSize: 4, BootTable: true},
+				{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/efiboot.img"},
+			},
+		}
+		if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true, ElTorito: et, Hybrid: hybrid}); err != nil {
+			t.Fatalf("unexpected error fs.Finalize(): %v", err)
+		}
+		// it is still an iso
+		if _, err := iso9660.Read(f, 0, 0, 2048); err != nil {
+			t.Fatalf("error reading the tmpfile as iso: %v", err)
+		}
+		return f, et
+	}
+	// location finds the sector of 512 bytes where the data of a file is
+	location := func(t *testing.T, f *os.File, p string) uint32 {
+		t.Helper()
+		fs, err := iso9660.Read(f, 0, 0, 2048)
+		if err != nil {
+			t.Fatalf("error reading the tmpfile as iso: %v", err)
+		}
+		fl, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		return fl.(*iso9660.File).Location() * 4
+	}
+
+	t.Run("mbr", func(t *testing.T) {
+		f, _ := create(t, &iso9660.Hybrid{MBRBootCode: bootCode})
+		b := make([]byte, 512)
+		if _, err := f.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading MBR: %v", err)
+		}
+		if !bytes.Equal(b[:432], bootCode) {
+			t.Errorf("MBR does not start with the boot code")
+		}
+		if lba := binary.LittleEndian.Uint32(b[432:436]); lba != location(t, f, "/isolinux.bin") {
+			t.Errorf("MBR loads boot image from sector %d instead of %d", lba, location(t, f, "/isolinux.bin"))
+		}
+		table, err := mbr.Read(f, 512, 512)
+		if err != nil {
+			t.Fatalf("error reading MBR: %v", err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(table.Partitions) < 2 {
+			t.Fatalf("%d MBR partitions instead of 2", len(table.Partitions))
+		}
+		image, efi := table.Partitions[0], table.Partitions[1]
+		if !image.Bootable || image.Start != 0 || int64(image.Size)*512 != fi.Size() {
+			t.Errorf("mismatched partition for image %+v", image)
+		}
+		if efi.Type != mbr.EFISystem || efi.Start != location(t, f, "/efiboot.img") || int(efi.Size)*512 != sizes["/efiboot.img"] {
+			t.Errorf("mismatched EFI System Partition %+v", efi)
+		}
+	})
+	t.Run("gpt", func(t *testing.T) {
+		f, _ := create(t, &iso9660.Hybrid{GPT: true})
+		table, err := gpt.Read(f, 512, 512)
+		if err != nil {
+			t.Fatalf("error reading GPT: %v", err)
+		}
+		if len(table.Partitions) < 1 {
+			t.Fatalf("no GPT partitions")
+		}
+		efi := table.Partitions[0]
+		start := uint64(location(t, f, "/efiboot.img"))
+		if efi.Type != gpt.EFISystemPartition || efi.Start != start || (efi.End-start+1)*512 != uint64(sizes["/efiboot.img"]) {
+			t.Errorf("mismatched EFI System Partition %+v", efi)
+		}
+		if !table.ProtectiveMBR {
+			t.Errorf("no protective MBR")
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		f, err := os.CreateTemp("", "iso_finalize_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer os.Remove(f.Name())
+		fs, err := iso9660.Create(f, 0, 0, 2048, "")
+		if err != nil {
+			t.Fatalf("Failed to iso9660.Create: %v", err)
+		}
+		if err := fs.Finalize(iso9660.FinalizeOptions{Hybrid: &iso9660.Hybrid{}}); err == nil {
+			t.Errorf("finalized hybrid image without El Torito without error")
+		}
+		if err := fs.Finalize(iso9660.FinalizeOptions{
+			ElTorito: &iso9660.ElTorito{},
+			Hybrid:   &iso9660.Hybrid{MBRBootCode: make([]byte, 440)},
+		}); err == nil {
+			t.Errorf("finalized hybrid image with 440 bytes of boot code without error")
+		}
+	})
+}
+
 // full test - create some files, finalize, check the output
 //
 //nolint:gocyclo // we really do not care about the cyclomatic complexity of a test function. Maybe someday we will improve it.
@@ -488,6 +621,146 @@ func TestFinalizeRockRidge(t *testing.T) {
 	})
 }
 
+func TestFinalizeJoliet(t *testing.T) {
+	blocksize := int64(2048)
+	files := map[string]string{
+		"/Documents/Read Me.txt":      "read me\n",
+		"/Documents/Ünïcode Notes.md": "notes\n",
+		"/setup.exe":                  "setup\n",
+	}
+	// names too long for the primary tree, the last two the same once cut short, which only Joliet keeps
+	longFiles := map[string]string{
+		"/Documents/A name of more than thirty characters.txt":            "long\n",
+		"/Documents/Quarterly report for the first half of the year.txt":  "first\n",
+		"/Documents/Quarterly report for the second half of the year.txt": "second\n",
+	}
+	jolietDocuments := []string{
+		"A name of more than thirty characters.txt",
+		"Quarterly report for the first half of the year.txt",
+		"Quarterly report for the second half of the year.txt",
+		"Read Me.txt",
+		"Ünïcode Notes.md",
+	}
+	tests := []struct {
+		name     string
+		options  iso9660.FinalizeOptions
+		expected map[string][]string
+	}{
+		{"primary only", iso9660.FinalizeOptions{}, map[string][]string{
+			"/":          {"DOCUMENTS", "SETUP.EXE"},
+			"/DOCUMENTS": {"READ_ME.TXT", "_N_CODE_NOTES.MD"},
+		}},
+		{"joliet", iso9660.FinalizeOptions{Joliet: true}, map[string][]string{
+			"/":          {"Documents", "setup.exe"},
+			"/Documents": jolietDocuments,
+		}},
+		// Rock Ridge, when it is there, is preferred over Joliet
+		{"joliet and rock ridge", iso9660.FinalizeOptions{Joliet: true, RockRidge: true}, map[string][]string{
+			"/":          {"Documents", "setup.exe"},
+			"/Documents": jolietDocuments,
+		}},
+		{"joliet and el torito", iso9660.FinalizeOptions{Joliet: true, ElTorito: &iso9660.ElTorito{
+			BootCatalog: "/BOOT.CAT",
+			Entries: []*iso9660.ElToritoEntry{
+				{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/setup.exe"},
+			},
+		}}, map[string][]string{
+			"/":          {"BOOT.CAT", "Documents", "setup.exe"},
+			"/Documents": jolietDocuments,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := make(map[string]string)
+			for name, contents := range files {
+				all[name] = contents
+			}
+			if tt.options.Joliet {
+				for name, contents := range longFiles {
+					all[name] = contents
+				}
+			}
+			f, err := os.CreateTemp(t.TempDir(), "iso_finalize_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer f.Close()
+			fs, err := iso9660.Create(f, 0, 0, blocksize, "")
+			if err != nil {
+				t.Fatalf("Failed to iso9660.Create: %v", err)
+			}
+			if err := fs.Mkdir("/Documents"); err != nil {
+				t.Fatalf("Failed to iso9660.Mkdir: %v", err)
+			}
+			for name, contents := range all {
+				isofile, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("Failed to iso9660.OpenFile(%s): %v", name, err)
+				}
+				if _, err := isofile.Write([]byte(contents)); err != nil {
+					t.Fatalf("error writing to tmpfile %s: %v", name, err)
+				}
+			}
+			if err := fs.Finalize(tt.options); err != nil {
+				t.Fatalf("unexpected error fs.Finalize(%+v): %v", tt.options, err)
+			}
+
+			fs, err = iso9660.Read(f, 0, 0, blocksize)
+			if err != nil {
+				t.Fatalf("error reading the tmpfile as iso: %v", err)
+			}
+			for dir, expected := range tt.expected {
+				entries, err := fs.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("error reading directory %s: %v", dir, err)
+				}
+				names := make([]string, 0, len(entries))
+				for _, e := range entries {
+					names = append(names, e.Name())
+				}
+				sort.Strings(names)
+				if !reflect.DeepEqual(names, expected) {
+					t.Errorf("directory %s has %v instead of expected %v", dir, names, expected)
+				}
+			}
+			if !tt.options.Joliet {
+				return
+			}
+			for name, contents := range all {
+				isofile, err := fs.OpenFile(name, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("error opening file %s: %v", name, err)
+				}
+				b, err := io.ReadAll(isofile)
+				if err != nil {
+					t.Fatalf("error reading file %s: %v", name, err)
+				}
+				if string(b) != contents {
+					t.Errorf("file %s has %q instead of expected %q", name, b, contents)
+				}
+			}
+		})
+	}
+	t.Run("invalid name", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "iso_finalize_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer f.Close()
+		fs, err := iso9660.Create(f, 0, 0, blocksize, "")
+		if err != nil {
+			t.Fatalf("Failed to iso9660.Create: %v", err)
+		}
+		// the primary tree replaces the character, but it is outside of UCS-2
+		if err := fs.Mkdir("/smile\U0001F600"); err != nil {
+			t.Fatalf("Failed to iso9660.Mkdir: %v", err)
+		}
+		if err := fs.Finalize(iso9660.FinalizeOptions{Joliet: true}); err == nil {
+			t.Fatal("unexpected lack of error fs.Finalize()")
+		}
+	})
+}
+
 //nolint:thelper // this is not a helper function
 func validateIso(t *testing.T, f *os.File) {
 	// only do this test if os.Getenv("TEST_IMAGE") contains a real image for integration testing
@@ -0,0 +1,153 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// hybridSectorSize is the sector size of the disks that a hybrid image is written to
+	hybridSectorSize = 512
+	// maxMBRBootCode is how much boot code the MBR of a hybrid image can have, before the location of the boot
+	// image that it loads
+	maxMBRBootCode = 432
+	// gptBackupSize is the size of the backup GPT at the end of a hybrid image, its partition array and header
+	gptBackupSize = 33 * hybridSectorSize
+	// mbrTypeISOHybrid is the MBR partition type of the whole image, as isohybrid uses
+	mbrTypeISOHybrid mbr.Type = 0x17
+	// mbrPartitionTypeStart is where the type of the first partition is in an MBR
+	mbrPartitionTypeStart = 446 + 4
+	// mbrSignatureStart is where the 0x55 0xaa signature of an MBR is
+	mbrSignatureStart = 510
+)
+
+// Hybrid makes an image that also boots when written to a USB stick or hard disk, as isohybrid does, by writing
+// a partition table in the system area, the first 32 KB of the image, which iso9660 leaves to the system. It needs
+// El Torito boot entries.
+type Hybrid struct {
+	// MBRBootCode BIOS boot code for the start of the MBR, of at most 432 bytes, such as isohdpfx.bin of syslinux.
+	// It is followed by the location of the El Torito BIOS boot image, which it loads, and which must be able to
+	// boot from a disk, as isolinux.bin is. Without it, the image boots from a disk only with UEFI.
+	MBRBootCode []byte
+	// GPT write a GPT with an EFI System Partition for the El Torito EFI boot image, with a protective MBR. Without
+	// it, the MBR has a bootable partition for the whole image and, if there is an EFI boot image, an EFI System
+	// Partition for it.
+	GPT bool
+}
+
+// validate checks that a hybrid image can be made with the El Torito entries et
+func (h *Hybrid) validate(et *ElTorito) error {
+	if et == nil {
+		return fmt.Errorf("a hybrid image needs El Torito boot entries")
+	}
+	if len(h.MBRBootCode) > maxMBRBootCode {
+		return fmt.Errorf("MBR boot code of %d bytes is more than the maximum %d", len(h.MBRBootCode), maxMBRBootCode)
+	}
+	if h.GPT && bootImage(et, EFI) == nil {
+		return fmt.Errorf("a hybrid image with a GPT needs an El Torito EFI boot entry")
+	}
+	return nil
+}
+
+// bootImage returns the first El Torito entry for platform, nil if there is none. The platform of the first
+// entry is that of the catalog, as it has no section header of its own.
+func bootImage(et *ElTorito, platform Platform) *ElToritoEntry {
+	for i, e := range et.Entries {
+		p := e.Platform
+		if i == 0 {
+			p = et.Platform
+		}
+		if p == platform {
+			return e
+		}
+	}
+	return nil
+}
+
+// readHybrid returns how an image that was read is hybrid, from the partition table in its system area, for the
+// El Torito entries et. It is nil if the image has no partition table, or if it cannot be made again with et.
+func (fs *FileSystem) readHybrid(et *ElTorito) (*Hybrid, error) {
+	b := make([]byte, hybridSectorSize)
+	if _, err := fs.file.ReadAt(b, fs.start); err != nil {
+		return nil, fmt.Errorf("could not read system area: %v", err)
+	}
+	if b[mbrSignatureStart] != 0x55 || b[mbrSignatureStart+1] != 0xaa {
+		return nil, nil
+	}
+	h := &Hybrid{GPT: mbr.Type(b[mbrPartitionTypeStart]) == mbr.GPTProtective}
+	// without boot code, it is all zeros
+	for _, c := range b[:maxMBRBootCode] {
+		if c != 0 {
+			h.MBRBootCode = b[:maxMBRBootCode]
+			break
+		}
+	}
+	if h.validate(et) != nil {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// write writes the MBR, and the GPT if asked, of an image of size bytes and blocks of blocksize bytes, whose El
+// Torito boot images have already been written
+func (h *Hybrid) write(f util.File, et *ElTorito, blocksize, size int64) error {
+	sectorsPerBlock := uint32(blocksize / hybridSectorSize)
+	code := make([]byte, maxMBRBootCode+8)
+	copy(code, h.MBRBootCode)
+	if bios := bootImage(et, BIOS); bios != nil && len(h.MBRBootCode) > 0 {
+		binary.LittleEndian.PutUint32(code[maxMBRBootCode:], bios.location*sectorsPerBlock)
+	}
+	if _, err := f.WriteAt(code, 0); err != nil {
+		return fmt.Errorf("could not write MBR boot code: %v", err)
+	}
+
+	efi := bootImage(et, EFI)
+	if h.GPT {
+		start := uint64(efi.location * sectorsPerBlock)
+		table := &gpt.Table{
+			LogicalSectorSize:  hybridSectorSize,
+			PhysicalSectorSize: hybridSectorSize,
+			ProtectiveMBR:      true,
+			Partitions: []*gpt.Partition{
+				{
+					Start: start,
+					End:   start + uint64((efi.size+hybridSectorSize-1)/hybridSectorSize) - 1,
+					Type:  gpt.EFISystemPartition,
+					Name:  "EFI System Partition",
+				},
+			},
+		}
+		if err := table.Write(f, size); err != nil {
+			return fmt.Errorf("could not write GPT: %v", err)
+		}
+		return nil
+	}
+
+	table := &mbr.Table{
+		LogicalSectorSize:  hybridSectorSize,
+		PhysicalSectorSize: hybridSectorSize,
+		Partitions: []*mbr.Partition{
+			{
+				Bootable: true,
+				Type:     mbrTypeISOHybrid,
+				Start:    0,
+				Size:     uint32(size / hybridSectorSize),
+			},
+		},
+	}
+	if efi != nil {
+		table.Partitions = append(table.Partitions, &mbr.Partition{
+			Type:  mbr.EFISystem,
+			Start: efi.location * sectorsPerBlock,
+			Size:  (efi.size + hybridSectorSize - 1) / hybridSectorSize,
+		})
+	}
+	if err := table.Write(f, size); err != nil {
+		return fmt.Errorf("could not write MBR: %v", err)
+	}
+	return nil
+}
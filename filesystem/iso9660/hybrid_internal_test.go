@@ -0,0 +1,58 @@
+package iso9660
+
+import (
+	"testing"
+)
+
+func TestBootImage(t *testing.T) {
+	bios := &ElToritoEntry{BootFile: "/isolinux.bin"}
+	efi := &ElToritoEntry{Platform: EFI, BootFile: "/efiboot.img"}
+	tests := []struct {
+		name string
+		et   *ElTorito
+		bios *ElToritoEntry
+		efi  *ElToritoEntry
+	}{
+		{"bios then efi", &ElTorito{Platform: BIOS, Entries: []*ElToritoEntry{bios, efi}}, bios, efi},
+		// the first entry has the platform of the catalog, whatever its own
+		{"efi catalog", &ElTorito{Platform: EFI, Entries: []*ElToritoEntry{bios}}, nil, bios},
+		{"efi only", &ElTorito{Platform: EFI, Entries: []*ElToritoEntry{efi}}, nil, efi},
+		{"empty", &ElTorito{}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if e := bootImage(tt.et, BIOS); e != tt.bios {
+				t.Errorf("BIOS boot image %+v instead of %+v", e, tt.bios)
+			}
+			if e := bootImage(tt.et, EFI); e != tt.efi {
+				t.Errorf("EFI boot image %+v instead of %+v", e, tt.efi)
+			}
+		})
+	}
+}
+
+func TestHybridValidate(t *testing.T) {
+	bios := &ElTorito{Entries: []*ElToritoEntry{{BootFile: "/isolinux.bin"}}}
+	tests := []struct {
+		name   string
+		hybrid *Hybrid
+		et     *ElTorito
+		valid  bool
+	}{
+		{"mbr", &Hybrid{MBRBootCode: make([]byte, 432)}, bios, true},
+		{"no el torito", &Hybrid{}, nil, false},
+		{"boot code too long", &Hybrid{MBRBootCode: make([]byte, 433)}, bios, false},
+		{"gpt without efi", &Hybrid{GPT: true}, bios, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.hybrid.validate(tt.et)
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("no error")
+			}
+		})
+	}
+}
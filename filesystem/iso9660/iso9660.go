@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strings"
 
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/internal/workspace"
 	"github.com/diskfs/go-diskfs/util"
 )
 
@@ -14,6 +17,8 @@ const (
 	volumeDescriptorSize int64 = 2 * KB  // each volume descriptor is 2KB
 	systemAreaSize       int64 = 32 * KB // 32KB system area size
 	defaultSectorSize    int64 = 2 * KB
+	// maxSymlinkHops is how many symlinks Stat follows before giving up, as Linux does
+	maxSymlinkHops = 40
 	// MaxBlocks maximum number of blocks allowed in an iso9660 filesystem
 	MaxBlocks int64 = 4.294967296e+09 // 2^32
 )
@@ -31,6 +36,26 @@ type FileSystem struct {
 	suspEnabled    bool  // is the SUSP in use?
 	suspSkip       uint8 // how many bytes to skip in each directory record
 	suspExtensions []suspExtension
+	joliet         bool // are the directories read from the Joliet tree, with names in UCS-2?
+	// elTorito is the boot catalog read from the image, without paths, nil if it is not bootable; in a workspace
+	// from Remaster, it is the boot setup of the image it was loaded from
+	elTorito *ElTorito
+	// remasterOptions are the options of the image a workspace from Remaster was loaded from, which Finalize uses
+	// if it is given none; nil for other workspaces
+	remasterOptions *FinalizeOptions
+	// maxExtentSize is the most bytes of a file in one extent that Finalize writes: maxExtentSize, or less in tests
+	maxExtentSize int64
+	// stagedXattrs holds the xattrs set in the workspace, by path
+	stagedXattrs map[string]map[string][]byte
+	// stagedPosix holds the permissions and owners of the files that Remaster loaded into the workspace, by path,
+	// which Rock Ridge uses instead of those in the workspace
+	stagedPosix map[string]posixAttributes
+}
+
+// posixAttributes are the permissions, with the setuid, setgid and sticky bits, and owner of a file
+type posixAttributes struct {
+	perm     os.FileMode
+	uid, gid uint32
 }
 
 // Equal compare if two filesystems are equal
@@ -100,12 +125,13 @@ func Create(f util.File, size, start, blocksize int64, workspace string) (*FileS
 	// create root directory
 	// there is nothing in there
 	return &FileSystem{
-		workspace: workdir,
-		start:     start,
-		size:      size,
-		file:      f,
-		volumes:   volumeDescriptors{},
-		blocksize: blocksize,
+		workspace:     workdir,
+		start:         start,
+		size:          size,
+		file:          f,
+		volumes:       volumeDescriptors{},
+		blocksize:     blocksize,
+		maxExtentSize: maxExtentSize,
 	}, nil
 }
 
@@ -123,6 +149,9 @@ func Create(f util.File, size, start, blocksize int64, workspace string) (*FileS
 // which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
 // where a partition starts and ends.
 //
+// If the filesystem has a Joliet supplementary volume descriptor and no Rock Ridge extensions, its directories
+// are read from the Joliet tree, so that names keep their case and length.
+//
 // If the provided blocksize is 0, it will use the default of 2K bytes
 func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 	var read int
@@ -160,6 +189,8 @@ func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 	terminated := false
 	var (
 		pvd *primaryVolumeDescriptor
+		svd *supplementaryVolumeDescriptor
+		bvd *bootVolumeDescriptor
 		vd  volumeDescriptor
 	)
 	for i := 0; !terminated; i++ {
@@ -185,6 +216,14 @@ func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 		case volumeDescriptorPrimary:
 			vds = append(vds, vd)
 			pvd, _ = vd.(*primaryVolumeDescriptor)
+		case volumeDescriptorSupplementary:
+			vds = append(vds, vd)
+			if s, ok := vd.(*supplementaryVolumeDescriptor); ok && s.joliet() && svd == nil {
+				svd = s
+			}
+		case volumeDescriptorBoot:
+			vds = append(vds, vd)
+			bvd, _ = vd.(*bootVolumeDescriptor)
 		default:
 			vds = append(vds, vd)
 		}
@@ -197,14 +236,9 @@ func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 	)
 	if pvd != nil {
 		rootDirEntry = pvd.rootDirectoryEntry
-		pathTableBytes := make([]byte, pvd.pathTableSize)
-		pathTableLocation := pvd.pathTableLLocation * uint32(pvd.blocksize)
-		read, err = file.ReadAt(pathTableBytes, int64(pathTableLocation))
+		pathTableBytes, err := readPathTable(file, pvd.pathTableLLocation, pvd.pathTableSize, pvd.blocksize)
 		if err != nil {
-			return nil, fmt.Errorf("unable to read path table of size %d at location %d: %v", pvd.pathTableSize, pathTableLocation, err)
-		}
-		if read != len(pathTableBytes) {
-			return nil, fmt.Errorf("read %d bytes of path table instead of expected %d at location %d", read, pvd.pathTableSize, pathTableLocation)
+			return nil, err
 		}
 		pt = parsePathTable(pathTableBytes)
 	}
@@ -262,6 +296,38 @@ func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 		}
 	}
 
+	// the boot catalog fits in a block for all but the most unusual images
+	var et *ElTorito
+	if bvd != nil {
+		b := make([]byte, blocksize)
+		if _, err := file.ReadAt(b, int64(bvd.location)*blocksize); err != nil {
+			return nil, fmt.Errorf("unable to read El Torito boot catalog at block %d: %v", bvd.location, err)
+		}
+		et, err = parseCatalog(b)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse El Torito boot catalog: %v", err)
+		}
+		for _, e := range et.Entries {
+			if e.size, err = e.emulatedSize(file, blocksize); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// without Rock Ridge, the Joliet tree has names closer to the originals than the primary one
+	joliet := svd != nil && len(suspHandlers) == 0
+	if joliet {
+		rootDirEntry = svd.rootDirectoryEntry
+		pathTableBytes, err := readPathTable(file, svd.pathTableLLocation, svd.pathTableSize, svd.blocksize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read Joliet path table: %v", err)
+		}
+		pt = parseJolietPathTable(pathTableBytes)
+		// the Joliet tree does not use the SUSP
+		suspEnabled = false
+		skipBytes = 0
+	}
+
 	fs := &FileSystem{
 		workspace: "", // no workspace when we do nothing with it
 		start:     start,
@@ -277,11 +343,28 @@ func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
 		suspEnabled:    suspEnabled,
 		suspSkip:       skipBytes,
 		suspExtensions: suspHandlers,
+		joliet:         joliet,
+		elTorito:       et,
 	}
 	rootDirEntry.filesystem = fs
+	rootDirEntry.joliet = joliet
 	return fs, nil
 }
 
+// readPathTable reads the bytes of the little-endian path table of the given size at block location
+func readPathTable(file util.File, location, size uint32, blocksize uint16) ([]byte, error) {
+	b := make([]byte, size)
+	offset := int64(location) * int64(blocksize)
+	read, err := file.ReadAt(b, offset)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read path table of size %d at location %d: %v", size, offset, err)
+	}
+	if read != len(b) {
+		return nil, fmt.Errorf("read %d bytes of path table instead of expected %d at location %d", read, size, offset)
+	}
+	return b, nil
+}
+
 // Type returns the type code for the filesystem. Always returns filesystem.TypeFat32
 func (fs *FileSystem) Type() filesystem.Type {
 	return filesystem.TypeISO9660
@@ -295,7 +378,7 @@ func (fs *FileSystem) Type() filesystem.Type {
 // if readonly and not in workspace, will return an error
 func (fs *FileSystem) Mkdir(p string) error {
 	if fs.workspace == "" {
-		return fmt.Errorf("cannot write to read-only filesystem")
+		return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
 	}
 	err := os.MkdirAll(path.Join(fs.workspace, p), 0o755)
 	if err != nil {
@@ -368,7 +451,7 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
 	if fs.workspace == "" {
 		if writeMode {
-			return nil, fmt.Errorf("cannot write to read-only filesystem")
+			return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
 		}
 
 		// get the directory entries
@@ -414,6 +497,351 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	return f, nil
 }
 
+// ElTorito returns the El Torito boot catalog of an image that was read, nil if it is not bootable. The
+// BootCatalog and the BootFile of each entry are the paths of the files at their locations; they are hidden if
+// there are none. It searches the whole directory tree each time it is called.
+//
+// For a workspace from Remaster, it returns the boot setup of its RemasterOptions, which can be changed.
+func (fs *FileSystem) ElTorito() (*ElTorito, error) {
+	if fs.elTorito == nil || fs.workspace != "" {
+		return fs.elTorito, nil
+	}
+	// the first file of those that share their data
+	files := map[uint32]string{}
+	sizes := map[uint32]uint32{}
+	err := fs.walkFiles("/", func(p string, de *directoryEntry) {
+		if _, ok := files[de.location]; !ok {
+			files[de.location] = p
+			sizes[de.location] = de.size
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not find El Torito boot files: %v", err)
+	}
+	et := &ElTorito{
+		Platform:        fs.elTorito.Platform,
+		HideBootCatalog: true,
+	}
+	for _, vd := range fs.volumes.descriptors {
+		if bvd, ok := vd.(*bootVolumeDescriptor); ok {
+			if p, ok := files[bvd.location]; ok {
+				et.BootCatalog, et.HideBootCatalog = p, false
+			}
+		}
+	}
+	for _, e := range fs.elTorito.Entries {
+		entry := *e
+		entry.HideBootFile = true
+		if p, ok := files[e.location]; ok {
+			entry.BootFile, entry.HideBootFile = p, false
+			entry.size = sizes[e.location]
+		}
+		et.Entries = append(et.Entries, &entry)
+	}
+	return et, nil
+}
+
+// OpenBootImage opens the boot image of an El Torito entry returned by ElTorito, whether or not it is a file in
+// the directory tree. Without emulation, the image of a hidden boot file is only as much as is loaded.
+func (fs *FileSystem) OpenBootImage(e *ElToritoEntry) (filesystem.File, error) {
+	if fs.workspace != "" {
+		return nil, fmt.Errorf("cannot open boot images before the filesystem is finalized")
+	}
+	if e == nil || e.location == 0 {
+		return nil, fmt.Errorf("entry is not from the El Torito boot catalog of the image")
+	}
+	return &File{
+		directoryEntry: &directoryEntry{
+			location:   e.location,
+			size:       e.size,
+			filesystem: fs,
+			filename:   path.Base(e.BootFile),
+		},
+	}, nil
+}
+
+// walkFiles calls found with the path and entry of each regular file with data in directory p and below
+func (fs *FileSystem) walkFiles(p string, found func(p string, de *directoryEntry)) error {
+	entries, err := fs.readDirectory(p)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch {
+		case e.isSelf || e.isParent:
+		case e.IsDir():
+			if err := fs.walkFiles(path.Join(p, e.Name()), found); err != nil {
+				return err
+			}
+		case e.Mode().IsRegular() && e.size > 0:
+			found(path.Join(p, e.Name()), e)
+		}
+	}
+	return nil
+}
+
+// Stat returns the FileInfo for a file or directory, from the workspace if the filesystem has not been finalized.
+// If p is a symlink, Stat returns the FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Stat(fs.workspace, p)
+	}
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := de.symlinkTarget()
+		if !ok {
+			return de, nil
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, fmt.Errorf("too many levels of symlinks at %s", p)
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+// It reads from the workspace if the filesystem has not been finalized.
+//
+// Symlinks in a finalized filesystem are only seen if it was finalized with Rock Ridge extensions.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Lstat(fs.workspace, p)
+	}
+	return fs.lstat(p)
+}
+
+// Readlink returns the target of a symlink, from the workspace if the filesystem has not been finalized.
+//
+// Symlinks in a finalized filesystem are only seen if it was finalized with Rock Ridge extensions.
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	if fs.workspace != "" {
+		return workspace.Readlink(fs.workspace, p)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	target, ok := de.symlinkTarget()
+	if !ok {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	return target, nil
+}
+
+// Symlink creates newname as a symlink to oldname in the workspace. The symlink is only kept in the finalized
+// filesystem if it is finalized with Rock Ridge extensions. Once the filesystem is finalized, it is read-only
+// and Symlink returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return workspace.Symlink(fs.workspace, oldname, newname)
+}
+
+// Getxattr returns the value of the named extended attribute of p, from the workspace if the filesystem has not
+// been finalized. Returns an error wrapping filesystem.ErrXattrNotExist if p does not have it.
+//
+// Extended attributes in a finalized filesystem are only seen if it was finalized with Rock Ridge extensions,
+// in which case they are read from its AAIP entries.
+func (fs *FileSystem) Getxattr(p, name string) ([]byte, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	val, ok := xattrs[name]
+	if !ok {
+		return nil, fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	return val, nil
+}
+
+// Listxattr returns the sorted names of the extended attributes of p, from the workspace if the filesystem
+// has not been finalized
+func (fs *FileSystem) Listxattr(p string) ([]string, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(xattrs))
+	for k := range xattrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Setxattr sets the named extended attribute of p in the workspace. Extended attributes are kept with the
+// filesystem rather than set on the workspace, so they need no privileges, and only those set with Setxattr
+// are used. They are only stored in the finalized filesystem if it is finalized with Rock Ridge extensions.
+// Once the filesystem is finalized, it is read-only and Setxattr returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Setxattr(p, name string, value []byte) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot set xattr on %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	if name == "" {
+		return fmt.Errorf("xattr name must not be empty")
+	}
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return err
+	}
+	staged := make(map[string][]byte, len(xattrs)+1)
+	for k, v := range xattrs {
+		staged[k] = v
+	}
+	staged[name] = append([]byte{}, value...)
+	if fs.stagedXattrs == nil {
+		fs.stagedXattrs = map[string]map[string][]byte{}
+	}
+	fs.stagedXattrs[path.Join("/", p)] = staged
+	return nil
+}
+
+// Removexattr removes the named extended attribute of p in the workspace. Returns an error wrapping
+// filesystem.ErrXattrNotExist if p does not have it. Once the filesystem is finalized, it is read-only
+// and Removexattr returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Removexattr(p, name string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot remove xattr from %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return err
+	}
+	if _, ok := xattrs[name]; !ok {
+		return fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	delete(xattrs, name)
+	return nil
+}
+
+// getXattrs returns the xattrs of p: those staged for it before the filesystem is finalized, and the
+// ones in its AAIP entries after
+func (fs *FileSystem) getXattrs(p string) (map[string][]byte, error) {
+	if fs.workspace != "" {
+		if _, err := os.Lstat(workspace.Path(fs.workspace, p)); err != nil {
+			return nil, fmt.Errorf("could not stat %s: %w", p, err)
+		}
+		return fs.stagedXattrs[path.Join("/", p)], nil
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	// the root entry comes from the volume descriptor, which has no extensions, so use its self entry
+	if de == fs.rootDir {
+		if de, err = fs.rootSelf(); err != nil {
+			return nil, err
+		}
+	}
+	xattrs, err := de.xattrs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read xattrs of %s: %v", p, err)
+	}
+	return xattrs, nil
+}
+
+// moveStaged moves the staged xattrs and POSIX attributes of oldpath, and everything under it, to newpath,
+// or drops them if newpath is ""
+func (fs *FileSystem) moveStaged(oldpath, newpath string) {
+	oldpath = path.Join("/", oldpath)
+	under := func(k string) bool {
+		return k == oldpath || strings.HasPrefix(k, oldpath+"/")
+	}
+	for k, v := range fs.stagedXattrs {
+		if !under(k) {
+			continue
+		}
+		delete(fs.stagedXattrs, k)
+		if newpath != "" {
+			fs.stagedXattrs[path.Join("/", newpath, strings.TrimPrefix(k, oldpath))] = v
+		}
+	}
+	for k, v := range fs.stagedPosix {
+		if !under(k) {
+			continue
+		}
+		delete(fs.stagedPosix, k)
+		if newpath != "" {
+			fs.stagedPosix[path.Join("/", newpath, strings.TrimPrefix(k, oldpath))] = v
+		}
+	}
+}
+
+// lstat finds the directory entry for p in a finalized filesystem
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	// if the dir == filename, then it is just /
+	if dir == filename {
+		return fs.rootDir, nil
+	}
+	entries, err := fs.readDirectory(dir)
+	if err != nil {
+		// nothing can be in a directory that does not exist, or is not a directory at all
+		parent, serr := fs.Stat(dir)
+		switch {
+		case serr != nil:
+			return nil, serr
+		case !parent.IsDir():
+			return nil, fmt.Errorf("target file %s does not exist, as %s is not a directory: %w", p, dir, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("could not read directory entries for %s: %v", dir, err)
+	}
+	for _, e := range entries {
+		// ignore any entry that is current directory or parent
+		if e.isSelf || e.isParent {
+			continue
+		}
+		if e.Name() == filename {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+}
+
+// Remove removes a file or empty directory from the workspace. Once the filesystem is finalized, it is read-only
+// and Remove returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	if err := workspace.Remove(fs.workspace, p); err != nil {
+		return err
+	}
+	fs.moveStaged(p, "")
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it, from the workspace. It returns nil if the path
+// does not exist. Once the filesystem is finalized, it is read-only and RemoveAll returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	if err := workspace.RemoveAll(fs.workspace, p); err != nil {
+		return err
+	}
+	fs.moveStaged(p, "")
+	return nil
+}
+
+// Rename renames or moves a file or directory in the workspace. Once the filesystem is finalized, it is read-only
+// and Rename returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if err := workspace.Rename(fs.workspace, oldpath, newpath); err != nil {
+		return err
+	}
+	fs.moveStaged(newpath, "")
+	fs.moveStaged(oldpath, newpath)
+	return nil
+}
+
+// Truncate changes the size of a file in the workspace. Once the filesystem is finalized, it is read-only
+// and Truncate returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return workspace.Truncate(fs.workspace, p, size)
+}
+
 // readDirectory - read directory entry on iso only (not workspace)
 func (fs *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 	var (
@@ -496,5 +924,27 @@ func (fs *FileSystem) Label() string {
 }
 
 func (fs *FileSystem) SetLabel(string) error {
-	return fmt.Errorf("ISO9660 filesystem is read-only")
+	return fmt.Errorf("cannot set label on ISO9660 filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Statfs get the capacity of the filesystem, from its primary volume descriptor. As it is read-only,
+// it has no free space, and it does not count its files.
+//
+// Before it is finalized, it has no size of its own, so TotalBytes is 0, and UsedBytes is the size of the files
+// in the workspace, each rounded up to a whole block, which is less than the finalized size as it does not
+// include the directories and volume descriptors.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	if fs.workspace != "" {
+		return workspace.Statfs(fs.workspace, fs.blocksize)
+	}
+	if fs.volumes.primary == nil {
+		return filesystem.Statfs{}, fmt.Errorf("filesystem has no primary volume descriptor")
+	}
+	blocksize := int64(fs.volumes.primary.blocksize)
+	total := int64(fs.volumes.primary.volumeSize) * blocksize
+	return filesystem.Statfs{
+		BlockSize:  blocksize,
+		TotalBytes: total,
+		UsedBytes:  total,
+	}, nil
 }
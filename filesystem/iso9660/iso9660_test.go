@@ -6,6 +6,9 @@ package iso9660_test
 */
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -15,6 +18,8 @@ import (
 
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 func getOpenMode(mode int) string {
@@ -539,3 +544,682 @@ func TestIso9660OpenFile(t *testing.T) {
 func TestIso9660Finalize(t *testing.T) {
 
 }
+
+func TestIso9660Change(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for _, p := range []string{"/DIR/FILE.TXT", "/DIR/SUB/FILE.TXT", "/GONE/FILE.TXT"} {
+		if err := fs.Mkdir(path.Dir(p)); err != nil {
+			t.Fatalf("error making directory for %s: %v", p, err)
+		}
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write([]byte("hello")); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+
+	// change the workspace before finalizing
+	if err := fs.Truncate("/DIR/FILE.TXT", 2); err != nil {
+		t.Errorf("error truncating: %v", err)
+	}
+	if err := fs.Rename("/DIR/FILE.TXT", "/DIR/MOVED.TXT"); err != nil {
+		t.Errorf("error renaming: %v", err)
+	}
+	if err := fs.Remove("/DIR/SUB/FILE.TXT"); err != nil {
+		t.Errorf("error removing: %v", err)
+	}
+	if err := fs.RemoveAll("/GONE"); err != nil {
+		t.Errorf("error removing tree: %v", err)
+	}
+	if _, err := fs.Stat("/GONE"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of removed directory returned %v", err)
+	}
+	info, err := fs.Stat("/DIR/MOVED.TXT")
+	if err != nil {
+		t.Fatalf("error on stat: %v", err)
+	}
+	if info.Size() != 2 {
+		t.Errorf("size %d instead of 2 after truncate", info.Size())
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	// the finalized filesystem has the changes, and cannot be changed again
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	tests := []struct {
+		path  string
+		size  int64
+		isDir bool
+		err   error
+	}{
+		{"/", 0, true, nil},
+		{"/DIR", 0, true, nil},
+		{"/DIR/MOVED.TXT", 2, false, nil},
+		{"/DIR/SUB", 0, true, nil},
+		{"/DIR/FILE.TXT", 0, false, os.ErrNotExist},
+		{"/DIR/SUB/FILE.TXT", 0, false, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		info, err := fs.Stat(tt.path)
+		switch {
+		case tt.err != nil && !errors.Is(err, tt.err):
+			t.Errorf("Stat(%s): error %v instead of %v", tt.path, err, tt.err)
+		case tt.err == nil && err != nil:
+			t.Errorf("Stat(%s): unexpected error: %v", tt.path, err)
+		case err == nil && info.IsDir() != tt.isDir:
+			t.Errorf("Stat(%s): directory %t instead of %t", tt.path, info.IsDir(), tt.isDir)
+		case err == nil && !tt.isDir && info.Size() != tt.size:
+			t.Errorf("Stat(%s): size %d instead of %d", tt.path, info.Size(), tt.size)
+		}
+	}
+	changes := map[string]error{
+		"Remove":    fs.Remove("/DIR/MOVED.TXT"),
+		"RemoveAll": fs.RemoveAll("/DIR"),
+		"Rename":    fs.Rename("/DIR/MOVED.TXT", "/DIR/OTHER.TXT"),
+		"Truncate":  fs.Truncate("/DIR/MOVED.TXT", 0),
+		"Mkdir":     fs.Mkdir("/NEW"),
+		"SetLabel":  fs.SetLabel("label"),
+	}
+	for name, err := range changes {
+		if !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("%s on finalized filesystem returned %v", name, err)
+		}
+	}
+}
+
+func TestIso9660ChangeOutsideWorkspace(t *testing.T) {
+	fs, err := iso9660.Create(util.NewMemFile(0), 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	defer os.RemoveAll(fs.Workspace())
+	outside, err := os.MkdirTemp(path.Dir(fs.Workspace()), "outside")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.WriteFile(path.Join(outside, "victim.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	f, err := fs.OpenFile("/IN.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	// whether these fail, or change something in the workspace, none may touch anything outside it
+	rel := path.Join("..", path.Base(outside))
+	_ = fs.Truncate(rel+"/victim.txt", 0)
+	_ = fs.Rename(rel+"/victim.txt", "/STOLEN.TXT")
+	_ = fs.Rename("/IN.TXT", rel+"/IN.TXT")
+	_ = fs.Symlink("/IN.TXT", rel+"/LINK")
+	_ = fs.Remove(rel + "/victim.txt")
+	_ = fs.RemoveAll(rel)
+	if err := fs.Remove("/"); err == nil {
+		t.Errorf("removed root directory")
+	}
+	if err := fs.RemoveAll("../.."); err == nil {
+		t.Errorf("removed root directory")
+	}
+
+	b, err := os.ReadFile(path.Join(outside, "victim.txt"))
+	if err != nil || string(b) != "hello" {
+		t.Errorf("file outside the workspace was changed: %q, %v", b, err)
+	}
+	entries, err := os.ReadDir(outside)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory outside the workspace was changed: %v, %v", entries, err)
+	}
+	if _, err := os.Stat(fs.Workspace()); err != nil {
+		t.Errorf("workspace was removed: %v", err)
+	}
+}
+
+func TestIso9660Symlink(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/DIR"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	f, err := fs.OpenFile("/DIR/FILE.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	links := map[string]string{
+		"/DIR/relative": "FILE.TXT",
+		"/DIR/parent":   "../DIR/FILE.TXT",
+		"/absolute":     "/DIR/FILE.TXT",
+		"/dangling":     "missing",
+	}
+	var sfs filesystem.SymlinkFileSystem = fs
+	for link, target := range links {
+		if err := sfs.Symlink(target, link); err != nil {
+			t.Fatalf("error creating symlink %s: %v", link, err)
+		}
+	}
+	if target, err := fs.Readlink("/absolute"); err != nil || target != links["/absolute"] {
+		t.Errorf("workspace Readlink returned %q, %v", target, err)
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for link, target := range links {
+		actual, err := fs.Readlink(link)
+		if err != nil {
+			t.Errorf("Readlink(%s): unexpected error: %v", link, err)
+		} else if actual != target {
+			t.Errorf("Readlink(%s): %q instead of %q", link, actual, target)
+		}
+		info, err := fs.Lstat(link)
+		if err != nil {
+			t.Errorf("Lstat(%s): unexpected error: %v", link, err)
+		} else if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Lstat(%s): mode %v is not a symlink", link, info.Mode())
+		}
+		info, err = fs.Stat(link)
+		switch {
+		case link == "/dangling":
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Stat(%s): error %v instead of %v", link, err, os.ErrNotExist)
+			}
+		case err != nil:
+			t.Errorf("Stat(%s): unexpected error: %v", link, err)
+		case info.Mode()&os.ModeSymlink != 0 || info.Size() != 5:
+			t.Errorf("Stat(%s): did not follow symlink, mode %v size %d", link, info.Mode(), info.Size())
+		}
+	}
+	if _, err := fs.Readlink("/DIR/FILE.TXT"); err == nil {
+		t.Errorf("expected error on Readlink of a regular file")
+	}
+	if err := fs.Symlink("/DIR", "/new"); !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+		t.Errorf("Symlink on finalized filesystem returned %v", err)
+	}
+}
+
+func TestIso9660Xattrs(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/DIR"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	for _, p := range []string{"/DIR/PING", "/DIR/PLAIN", "/MOVED"} {
+		if _, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR); err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+	}
+	capability := []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x20, 0x00, 0x00}
+	long := strings.Repeat("x", 600)
+	var xfs filesystem.XattrFileSystem = fs
+	sets := []struct {
+		path, name, value string
+	}{
+		{"/", "user.root", "top"},
+		{"/DIR", "user.dir", "d"},
+		{"/DIR/PING", "security.capability", string(capability)},
+		{"/DIR/PING", "trusted.long", long},
+		{"/DIR/PING", "user.gone", "x"},
+		{"/MOVED", "user.moved", "m"},
+	}
+	for _, s := range sets {
+		if err := xfs.Setxattr(s.path, s.name, []byte(s.value)); err != nil {
+			t.Fatalf("Setxattr(%s, %s): unexpected error: %v", s.path, s.name, err)
+		}
+	}
+	if err := fs.Removexattr("/DIR/PING", "user.gone"); err != nil {
+		t.Fatalf("Removexattr: unexpected error: %v", err)
+	}
+	if err := fs.Removexattr("/DIR/PING", "user.gone"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+		t.Errorf("Removexattr of missing xattr returned %v", err)
+	}
+	if err := fs.Rename("/MOVED", "/DIR/MOVED"); err != nil {
+		t.Fatalf("error renaming: %v", err)
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	expected := map[string]map[string]string{
+		"/":          {"user.root": "top"},
+		"/DIR":       {"user.dir": "d"},
+		"/DIR/PING":  {"security.capability": string(capability), "trusted.long": long},
+		"/DIR/PLAIN": {},
+		"/DIR/MOVED": {"user.moved": "m"},
+	}
+	for p, xattrs := range expected {
+		names, err := fs.Listxattr(p)
+		if err != nil {
+			t.Errorf("Listxattr(%s): unexpected error: %v", p, err)
+			continue
+		}
+		if len(names) != len(xattrs) {
+			t.Errorf("Listxattr(%s): %v, expected %d names", p, names, len(xattrs))
+		}
+		for name, value := range xattrs {
+			actual, err := fs.Getxattr(p, name)
+			if err != nil {
+				t.Errorf("Getxattr(%s, %s): unexpected error: %v", p, name, err)
+			} else if string(actual) != value {
+				t.Errorf("Getxattr(%s, %s): %q instead of %q", p, name, actual, value)
+			}
+		}
+	}
+	if _, err := fs.Getxattr("/DIR/PLAIN", "user.none"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+		t.Errorf("Getxattr of missing xattr returned %v", err)
+	}
+	if err := fs.Setxattr("/DIR/PLAIN", "user.new", nil); !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+		t.Errorf("Setxattr on finalized filesystem returned %v", err)
+	}
+}
+
+func TestIso9660Statfs(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/DIR"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	f, err := fs.OpenFile("/DIR/FILE.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 3000)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	stat, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unexpected error on workspace: %v", err)
+	}
+	// the root, the directory and the file, which takes 2 blocks
+	expected := filesystem.Statfs{BlockSize: 2048, UsedBytes: 4096, Files: 3}
+	if stat != expected {
+		t.Errorf("workspace %+v instead of %+v", stat, expected)
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+	expected = filesystem.Statfs{BlockSize: 2048, TotalBytes: m.Size(), UsedBytes: m.Size()}
+	if stat, err := fs.Statfs(); err != nil || stat != expected {
+		t.Errorf("finalized %+v, %v instead of %+v", stat, err, expected)
+	}
+
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if stat, err := fs.Statfs(); err != nil || stat != expected {
+		t.Errorf("read %+v, %v instead of %+v", stat, err, expected)
+	}
+}
+
+func TestIso9660ElTorito(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/boot"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	contents := map[string][]byte{
+		"/boot/isolinux.bin": make([]byte, 10000),
+		"/boot/efiboot.img":  make([]byte, 100000),
+	}
+	for p, b := range contents {
+		for i := range b {
+			b[i] = byte(i * len(p))
+		}
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write(b); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	if et, err := fs.ElTorito(); err != nil || et != nil {
+		t.Errorf("workspace has El Torito %+v, %v", et, err)
+	}
+	err = fs.Finalize(iso9660.FinalizeOptions{Joliet: true, ElTorito: &iso9660.ElTorito{
+		BootCatalog: "/boot/boot.cat",
+		Platform:    iso9660.BIOS,
+		Entries: []*iso9660.ElToritoEntry{
+			{Platform: iso9660.BIOS, Emulation: iso9660.NoEmulation, BootFile: "/boot/isolinux.bin", LoadSize: 4, LoadSegment: 0x7c0},
+			{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/boot/efiboot.img", HideBootFile: true},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	et, err := fs.ElTorito()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if et == nil {
+		t.Fatalf("no El Torito boot catalog")
+	}
+	if et.BootCatalog != "/boot/boot.cat" || et.HideBootCatalog || et.Platform != iso9660.BIOS || len(et.Entries) != 2 {
+		t.Fatalf("mismatched El Torito %+v", et)
+	}
+	tests := []struct {
+		bootFile    string
+		hidden      bool
+		platform    iso9660.Platform
+		loadSize    uint16
+		loadSegment uint16
+		contents    []byte
+	}{
+		{"/boot/isolinux.bin", false, iso9660.BIOS, 4, 0x7c0, contents["/boot/isolinux.bin"]},
+		// the hidden image is only as much as is loaded, which is all of it
+		{"", true, iso9660.EFI, 196, 0, append(contents["/boot/efiboot.img"], make([]byte, 196*512-100000)...)},
+	}
+	for i, tt := range tests {
+		e := et.Entries[i]
+		if e.BootFile != tt.bootFile || e.HideBootFile != tt.hidden || e.Platform != tt.platform || e.LoadSize != tt.loadSize || e.LoadSegment != tt.loadSegment {
+			t.Errorf("entry %d: mismatched %+v", i, e)
+		}
+		f, err := fs.OpenBootImage(e)
+		if err != nil {
+			t.Fatalf("entry %d: error opening boot image: %v", i, err)
+		}
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatalf("entry %d: error reading boot image: %v", i, err)
+		}
+		if !bytes.Equal(b, tt.contents) {
+			t.Errorf("entry %d: mismatched boot image of %d bytes instead of %d", i, len(b), len(tt.contents))
+		}
+	}
+	if _, err := fs.OpenBootImage(&iso9660.ElToritoEntry{}); err == nil {
+		t.Errorf("no error opening boot image of an entry that was not read")
+	}
+}
+
+func TestIso9660Remaster(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for _, p := range []string{"/isolinux", "/docs", "/docs/old"} {
+		if err := fs.Mkdir(p); err != nil {
+			t.Fatalf("error making directory %s: %v", p, err)
+		}
+	}
+	contents := map[string][]byte{
+		"/isolinux/isolinux.bin": make([]byte, 3000),
+		"/efiboot.img":           make([]byte, 8192),
+		"/docs/readme.txt":       []byte("hello"),
+		"/docs/old/notes.txt":    []byte("old notes"),
+	}
+	for p, b := range contents {
+		for i := range b {
+			if len(b) > 100 {
+				b[i] = byte(i * len(p))
+			}
+		}
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write(b); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	if err := os.Chmod(path.Join(fs.Workspace(), "/docs/readme.txt"), 0o600); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+	if err := fs.Symlink("docs/readme.txt", "/readme"); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+	if err := fs.Setxattr("/docs/readme.txt", "user.origin", []byte("test")); err != nil {
+		t.Fatalf("error setting xattr: %v", err)
+	}
+	err = fs.Finalize(iso9660.FinalizeOptions{RockRidge: true, ElTorito: &iso9660.ElTorito{
+		BootCatalog: "/isolinux/boot.cat",
+		Platform:    iso9660.BIOS,
+		Entries: []*iso9660.ElToritoEntry{
+			{Emulation: iso9660.NoEmulation, BootFile: "/isolinux/isolinux.bin", LoadSize: 4, BootTable: true},
+			{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/efiboot.img", HideBootFile: true},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+
+	out := util.NewMemFile(0)
+	remastered, err := fs.Remaster(out, 0, 0, "")
+	if err != nil {
+		t.Fatalf("error remastering: %v", err)
+	}
+	defer os.RemoveAll(remastered.Workspace())
+	if err := remastered.RemoveAll("/docs/old"); err != nil {
+		t.Fatalf("error removing directory: %v", err)
+	}
+	if err := remastered.Mkdir("/new"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	f, err := remastered.OpenFile("/new/added.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("added")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := remastered.Finalize(iso9660.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing remastered filesystem: %v", err)
+	}
+
+	fs, err = iso9660.Read(out, out.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading remastered filesystem: %v", err)
+	}
+	for p, b := range map[string][]byte{"/docs/readme.txt": contents["/docs/readme.txt"], "/new/added.txt": []byte("added")} {
+		f, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		if actual, err := io.ReadAll(f); err != nil || !bytes.Equal(actual, b) {
+			t.Errorf("%s: read %q, %v instead of %q", p, actual, err, b)
+		}
+	}
+	if _, err := fs.Stat("/docs/old"); err == nil {
+		t.Errorf("removed directory is in remastered filesystem")
+	}
+	if info, err := fs.Stat("/docs/readme.txt"); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("mode of /docs/readme.txt %v, %v instead of 0600", info.Mode(), err)
+	}
+	if target, err := fs.Readlink("/readme"); err != nil || target != "docs/readme.txt" {
+		t.Errorf("Readlink returned %q, %v", target, err)
+	}
+	if value, err := fs.Getxattr("/docs/readme.txt", "user.origin"); err != nil || string(value) != "test" {
+		t.Errorf("Getxattr returned %q, %v", value, err)
+	}
+
+	et, err := fs.ElTorito()
+	if err != nil || et == nil {
+		t.Fatalf("no El Torito boot catalog: %v", err)
+	}
+	if et.BootCatalog != "/isolinux/boot.cat" || et.HideBootCatalog || len(et.Entries) != 2 {
+		t.Fatalf("mismatched El Torito %+v", et)
+	}
+	bios, efi := et.Entries[0], et.Entries[1]
+	if bios.BootFile != "/isolinux/isolinux.bin" || bios.LoadSize != 4 || bios.Platform != iso9660.BIOS {
+		t.Errorf("mismatched BIOS entry %+v", bios)
+	}
+	if !efi.HideBootFile || efi.Platform != iso9660.EFI {
+		t.Errorf("mismatched EFI entry %+v", efi)
+	}
+	// the boot information table has the new location of the image
+	image, err := fs.OpenBootImage(bios)
+	if err != nil {
+		t.Fatalf("error opening boot image: %v", err)
+	}
+	b, err := io.ReadAll(image)
+	if err != nil {
+		t.Fatalf("error reading boot image: %v", err)
+	}
+	if location := image.(*iso9660.File).Location(); binary.LittleEndian.Uint32(b[12:16]) != location {
+		t.Errorf("boot information table has location %d instead of %d", binary.LittleEndian.Uint32(b[12:16]), location)
+	}
+	if !bytes.Equal(b[64:], contents["/isolinux/isolinux.bin"][64:]) {
+		t.Errorf("mismatched BIOS boot image")
+	}
+	if image, err = fs.OpenBootImage(efi); err != nil {
+		t.Fatalf("error opening boot image: %v", err)
+	}
+	if b, err = io.ReadAll(image); err != nil || !bytes.Equal(b, contents["/efiboot.img"]) {
+		t.Errorf("mismatched EFI boot image of %d bytes, %v", len(b), err)
+	}
+	if _, err := fs.Stat("/boot-image-1.img"); err == nil {
+		t.Errorf("hidden boot image is in the directory tree")
+	}
+}
+
+func TestIso9660RemasterJolietHybrid(t *testing.T) {
+	bootCode := bytes.Repeat([]byte{0xfa}, 432)
+	jolietName := "/Mixed-Case Name.txt"
+	m := util.NewMemFile(0)
+	fs, err := iso9660.Create(m, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for p, size := range map[string]int{"/isolinux.bin": 4096, "/efiboot.img": 8192, jolietName: 5} {
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write(make([]byte, size)); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	err = fs.Finalize(iso9660.FinalizeOptions{
+		Joliet: true,
+		ElTorito: &iso9660.ElTorito{
+			Platform:        iso9660.BIOS,
+			HideBootCatalog: true,
+			Entries: []*iso9660.ElToritoEntry{
+				{Emulation: iso9660.NoEmulation, BootFile: "/isolinux.bin", LoadSize: 4, BootTable: true},
+				{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/efiboot.img"},
+			},
+		},
+		Hybrid: &iso9660.Hybrid{MBRBootCode: bootCode},
+	})
+	if err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+	fs, err = iso9660.Read(m, m.Size(), 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+
+	// remaster reads the setup back, and writes it again without options
+	remaster := func(t *testing.T, options *iso9660.FinalizeOptions) *util.MemFile {
+		t.Helper()
+		out := util.NewMemFile(0)
+		remastered, err := fs.Remaster(out, 0, 0, "")
+		if err != nil {
+			t.Fatalf("error remastering: %v", err)
+		}
+		defer os.RemoveAll(remastered.Workspace())
+		defaults := remastered.RemasterOptions()
+		if !defaults.Joliet || defaults.RockRidge || defaults.ElTorito == nil || defaults.Hybrid == nil {
+			t.Fatalf("mismatched remaster options %+v", defaults)
+		}
+		if !bytes.Equal(defaults.Hybrid.MBRBootCode, bootCode) || defaults.Hybrid.GPT {
+			t.Errorf("mismatched hybrid %+v", defaults.Hybrid)
+		}
+		if options == nil {
+			options = &iso9660.FinalizeOptions{}
+		}
+		if err := remastered.Finalize(*options); err != nil {
+			t.Fatalf("error finalizing remastered filesystem: %v", err)
+		}
+		return out
+	}
+
+	t.Run("restored", func(t *testing.T) {
+		out := remaster(t, nil)
+		remastered, err := iso9660.Read(out, out.Size(), 0, 2048)
+		if err != nil {
+			t.Fatalf("error reading remastered filesystem: %v", err)
+		}
+		if _, err := remastered.Stat(jolietName); err != nil {
+			t.Errorf("Joliet name not in remastered filesystem: %v", err)
+		}
+		if et, err := remastered.ElTorito(); err != nil || et == nil || len(et.Entries) != 2 {
+			t.Errorf("mismatched El Torito %+v, %v", et, err)
+		}
+		b := make([]byte, 512)
+		if _, err := out.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading MBR: %v", err)
+		}
+		if !bytes.Equal(b[:432], bootCode) {
+			t.Errorf("MBR does not start with the boot code")
+		}
+		table, err := mbr.Read(out, 512, 512)
+		if err != nil {
+			t.Fatalf("error reading MBR: %v", err)
+		}
+		if len(table.Partitions) < 2 || !table.Partitions[0].Bootable || table.Partitions[1].Type != mbr.EFISystem {
+			t.Errorf("mismatched MBR partitions %+v", table.Partitions)
+		}
+	})
+	t.Run("overridden", func(t *testing.T) {
+		out := remaster(t, &iso9660.FinalizeOptions{RockRidge: true})
+		remastered, err := iso9660.Read(out, out.Size(), 0, 2048)
+		if err != nil {
+			t.Fatalf("error reading remastered filesystem: %v", err)
+		}
+		// Rock Ridge keeps the name, without Joliet
+		if _, err := remastered.Stat(jolietName); err != nil {
+			t.Errorf("Rock Ridge name not in remastered filesystem: %v", err)
+		}
+		if et, err := remastered.ElTorito(); err != nil || et != nil {
+			t.Errorf("El Torito %+v, %v instead of none", et, err)
+		}
+		if _, err := mbr.Read(out, 512, 512); err == nil {
+			t.Errorf("MBR in remastered filesystem that is not hybrid")
+		}
+	})
+}
@@ -153,3 +153,15 @@ func parsePathTable(b []byte) *pathTable {
 		records: entries,
 	}
 }
+
+// parseJolietPathTable load the bytes of a Joliet path table, whose names are in UCS-2, into structures
+func parseJolietPathTable(b []byte) *pathTable {
+	pt := parsePathTable(b)
+	for _, e := range pt.records {
+		// the root is a single 0x00 byte, as in any other path table
+		if e.nameSize > 1 {
+			e.dirname = bytesToUCS2String([]byte(e.dirname))
+		}
+	}
+	return pt
+}
@@ -0,0 +1,255 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// posixPermMask is the bits of a mode that are staged with its permissions
+const posixPermMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// hiddenBootImageName is the name in the root of the workspace of a boot image that is hidden from the directory
+// tree of the image, numbered by its entry
+const hiddenBootImageName = "boot-image-%d.img"
+
+// Remaster loads the files of an image that was read, with their Rock Ridge metadata, into a new workspace, for a
+// new image in f. size, start and workspace are as for Create; the blocksize is that of the image.
+//
+// The new filesystem is edited as one from Create. Given no options, Finalize writes it as the image was: with
+// Rock Ridge extensions if it had them, with the modes, owners, times, symlinks and xattrs of its files, with a
+// Joliet tree if it had one, and with the same El Torito boot setup and hybrid partition table. Those options are
+// returned by RemasterOptions, to be changed and given to Finalize instead. Boot images that are hidden from the
+// directory tree are loaded into the root of the workspace, and hidden again; without emulation, they are only as
+// much as their entries load. An El Torito boot information table in a boot image is detected, and inserted again.
+//
+// Only directories, regular files and symlinks can be loaded.
+func (fs *FileSystem) Remaster(f util.File, size, start int64, workspace string) (*FileSystem, error) {
+	if fs.workspace != "" {
+		return nil, fmt.Errorf("cannot remaster a filesystem that has not been finalized")
+	}
+	et, err := fs.ElTorito()
+	if err != nil {
+		return nil, fmt.Errorf("could not read El Torito boot catalog: %v", err)
+	}
+	hybrid, err := fs.readHybrid(et)
+	if err != nil {
+		return nil, err
+	}
+	remastered, err := Create(f, size, start, fs.blocksize, workspace)
+	if err != nil {
+		return nil, err
+	}
+	options := &FinalizeOptions{ElTorito: et, Hybrid: hybrid}
+	for _, e := range fs.suspExtensions {
+		if _, ok := e.(*rockRidgeExtension); ok {
+			options.RockRidge = true
+		}
+	}
+	for _, vd := range fs.volumes.descriptors {
+		if svd, ok := vd.(*supplementaryVolumeDescriptor); ok && svd.joliet() {
+			options.Joliet = true
+		}
+	}
+	remastered.remasterOptions = options
+
+	// a visible catalog is not loaded, as Finalize writes a new one in its place
+	var catalog string
+	if et != nil && !et.HideBootCatalog {
+		catalog = path.Clean(et.BootCatalog)
+	}
+	if err := fs.remasterDirectory(remastered, "/", catalog); err != nil {
+		return nil, err
+	}
+
+	if et != nil {
+		for i, e := range et.Entries {
+			if err := fs.remasterBootImage(remastered, i, e); err != nil {
+				return nil, err
+			}
+		}
+		remastered.elTorito = et
+	}
+
+	// the root last, as loading everything else changes its times
+	root, err := fs.rootSelf()
+	if err != nil {
+		return nil, err
+	}
+	if err := remastered.setLoadedMetadata("/", root); err != nil {
+		return nil, err
+	}
+	return remastered, nil
+}
+
+// RemasterOptions returns the options with which Finalize writes a workspace from Remaster if it is given none,
+// which are those of the image it was loaded from. To write it otherwise, change them and give them to Finalize.
+// For other filesystems, they are empty.
+func (fs *FileSystem) RemasterOptions() FinalizeOptions {
+	if fs.remasterOptions == nil {
+		return FinalizeOptions{}
+	}
+	return *fs.remasterOptions
+}
+
+// remasterDirectory loads the entries of directory p, and everything under them, into the workspace of
+// remastered, apart from the boot catalog
+func (fs *FileSystem) remasterDirectory(remastered *FileSystem, p, catalog string) error {
+	entries, err := fs.readDirectory(p)
+	if err != nil {
+		return fmt.Errorf("could not read directory %s: %v", p, err)
+	}
+	for _, e := range entries {
+		if e.isSelf || e.isParent {
+			continue
+		}
+		ep := path.Join(p, e.Name())
+		wp := path.Join(remastered.workspace, ep)
+		mode := e.Mode()
+		switch {
+		case ep == catalog:
+			continue
+		case e.IsDir():
+			if err := os.Mkdir(wp, 0o755); err != nil {
+				return fmt.Errorf("could not create directory %s: %v", ep, err)
+			}
+			if err := fs.remasterDirectory(remastered, ep, catalog); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			target, _ := e.symlinkTarget()
+			if err := os.Symlink(target, wp); err != nil {
+				return fmt.Errorf("could not create symlink %s: %v", ep, err)
+			}
+		case mode.IsRegular():
+			if err := writeWorkspaceFile(wp, &File{directoryEntry: e}); err != nil {
+				return fmt.Errorf("could not load file %s: %v", ep, err)
+			}
+		default:
+			return fmt.Errorf("cannot load %s of type %s", ep, mode.Type())
+		}
+		if err := remastered.setLoadedMetadata(ep, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remasterBootImage sets up El Torito entry i, from the catalog of fs, for the workspace of remastered, loading
+// its image if it is hidden
+func (fs *FileSystem) remasterBootImage(remastered *FileSystem, i int, e *ElToritoEntry) error {
+	image, err := fs.OpenBootImage(e)
+	if err != nil {
+		return fmt.Errorf("could not open boot image %d: %v", i, err)
+	}
+	// the boot information table is at 8 bytes, and starts with the locations of the PVD and of the image itself
+	b := make([]byte, 16)
+	if _, err := image.Read(b); err != nil && err != io.EOF {
+		return fmt.Errorf("could not read boot image %d: %v", i, err)
+	}
+	e.BootTable = binary.LittleEndian.Uint32(b[8:12]) == dataStartSector && binary.LittleEndian.Uint32(b[12:16]) == e.location
+
+	if !e.HideBootFile {
+		return nil
+	}
+	name := fmt.Sprintf(hiddenBootImageName, i)
+	for {
+		if _, err := os.Lstat(path.Join(remastered.workspace, name)); os.IsNotExist(err) {
+			break
+		}
+		name = "_" + name
+	}
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not read boot image %d: %v", i, err)
+	}
+	if err := writeWorkspaceFile(path.Join(remastered.workspace, name), image); err != nil {
+		return fmt.Errorf("could not load boot image %d: %v", i, err)
+	}
+	e.BootFile = "/" + name
+	return nil
+}
+
+// rootSelf returns the self entry of the root directory, which has its extensions, as the entry in the volume
+// descriptor does not
+func (fs *FileSystem) rootSelf() (*directoryEntry, error) {
+	entries, err := fs.readDirectory("/")
+	if err != nil {
+		return nil, fmt.Errorf("could not read root directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.isSelf {
+			return e, nil
+		}
+	}
+	return fs.rootDir, nil
+}
+
+// setLoadedMetadata sets the Rock Ridge metadata of entry e, loaded into the workspace at p: its times on the
+// workspace, and its permissions, owner and xattrs staged. Without Rock Ridge, there is none.
+func (fs *FileSystem) setLoadedMetadata(p string, e *directoryEntry) error {
+	var (
+		px    *rockRidgePosixAttributes
+		mtime = e.creation
+		atime = e.creation
+	)
+	for _, x := range e.extensions {
+		switch ext := x.(type) {
+		case rockRidgePosixAttributes:
+			px = &ext
+		case rockRidgeTimestamps:
+			for _, stamp := range ext.stamps {
+				switch stamp.timestampType {
+				case rockRidgeTimestampModify:
+					mtime = stamp.time
+				case rockRidgeTimestampAccess:
+					atime = stamp.time
+				}
+			}
+		}
+	}
+	if px == nil {
+		return nil
+	}
+	p = path.Join("/", p)
+
+	if fs.stagedPosix == nil {
+		fs.stagedPosix = map[string]posixAttributes{}
+	}
+	fs.stagedPosix[p] = posixAttributes{perm: px.mode & posixPermMask, uid: px.uid, gid: px.gid}
+	xattrs, err := e.xattrs()
+	if err != nil {
+		return fmt.Errorf("could not read xattrs of %s: %v", p, err)
+	}
+	if len(xattrs) > 0 {
+		if fs.stagedXattrs == nil {
+			fs.stagedXattrs = map[string]map[string][]byte{}
+		}
+		fs.stagedXattrs[p] = xattrs
+	}
+
+	// setting the times of a symlink would follow it
+	if px.mode&os.ModeSymlink != 0 {
+		return nil
+	}
+	if err := os.Chtimes(path.Join(fs.workspace, p), atime, mtime); err != nil {
+		return fmt.Errorf("could not set times of %s: %v", p, err)
+	}
+	return nil
+}
+
+// writeWorkspaceFile writes the contents of r to a new file at wp in a workspace
+func writeWorkspaceFile(wp string, r io.Reader) error {
+	f, err := os.OpenFile(wp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
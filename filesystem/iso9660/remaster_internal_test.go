@@ -0,0 +1,58 @@
+package iso9660
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStagedPosixAttributes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "a"), []byte("a"), 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	fs := &FileSystem{
+		workspace:      dir,
+		suspEnabled:    true,
+		suspExtensions: []suspExtension{getRockRidgeExtension(rockRidge112)},
+		stagedPosix:    map[string]posixAttributes{"/a": {perm: 0o711 | os.ModeSetuid, uid: 1234, gid: 5678}},
+	}
+	tests := []struct {
+		name     string
+		oldpath  string
+		newpath  string
+		expected bool
+	}{
+		{"staged", "", "", true},
+		{"renamed", "/a", "/b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.oldpath != "" {
+				fs.moveStaged(tt.oldpath, tt.newpath)
+				if _, ok := fs.stagedPosix[tt.newpath]; !ok {
+					t.Errorf("attributes not moved to %s", tt.newpath)
+				}
+			}
+			fi := &finalizeFileInfo{path: "a", name: "a", shortname: "A", size: 1, mode: 0o644}
+			fi.setStaged(fs)
+			de, err := fi.toDirectoryEntry(fs, false, false, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var px *rockRidgePosixAttributes
+			for _, e := range de.extensions {
+				if p, ok := e.(rockRidgePosixAttributes); ok {
+					px = &p
+				}
+			}
+			if px == nil {
+				t.Fatalf("no PX entry")
+			}
+			staged := px.mode == 0o711|os.ModeSetuid && px.uid == 1234 && px.gid == 5678
+			if staged != tt.expected {
+				t.Errorf("PX entry %+v, staged %v instead of %v", *px, staged, tt.expected)
+			}
+		})
+	}
+}
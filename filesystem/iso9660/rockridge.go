@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	"gopkg.in/djherbis/times.v1"
@@ -71,6 +72,9 @@ func (r *rockRidgeExtension) Process(signature string, b []byte) (directoryEntry
 		entry, err = r.parseTimestamps(b)
 	case rockRidgeSignatureSparseFile:
 		entry, err = r.parseSparseFile(b)
+	case aaipSignature:
+		// AAIP is its own extension, but is only ever used along with Rock Ridge
+		entry, err = parseAAIPAttributes(b)
 	default:
 		return nil, ErrSuspNoHandler
 	}
@@ -602,6 +606,10 @@ func (d rockRidgeSymlink) Continuable() bool {
 func (d rockRidgeSymlink) Merge(links []directoryEntrySystemUseExtension) directoryEntrySystemUseExtension {
 	for _, e := range links {
 		if l, ok := e.(rockRidgeSymlink); ok {
+			// each entry holds whole components, so they need a separator between them
+			if d.name != "" && !strings.HasSuffix(d.name, "/") && !strings.HasPrefix(l.name, "/") {
+				d.name += "/"
+			}
 			d.name += l.name
 		}
 	}
@@ -621,25 +629,45 @@ func (r *rockRidgeExtension) parseSymlink(b []byte) (directoryEntrySystemUseExte
 		return nil, fmt.Errorf("Rock Ridge SL extension must be version 1, was %d", version)
 	}
 	continued := b[4] == 1
-	name := ""
-	for i := 5; i < len(b); {
+	// each component record has flags, a length and the component itself; a component may be split
+	// across several records, in which case all but the last have the continue flag set
+	var (
+		root       bool
+		components []string
+		current    string
+	)
+	for i := 5; i+2 <= len(b); {
 		// make it easier to work with
 		b2 := b[i:]
 		// find out how many bytes we will read
 		flags := b2[0]
-		size := b2[1]
+		size := int(b2[1])
+		if 2+size > len(b2) {
+			//nolint:stylecheck // "Rock Ridge" is a proper noun
+			return nil, fmt.Errorf("Rock Ridge SL component at %d has %d bytes, but only %d remain", i, size, len(b2)-2)
+		}
 		switch {
-		case flags&0x1 == 0x1:
-			name += "."
+		case flags&0x8 == 0x8:
+			root = true
+		case flags&0x4 == 0x4:
+			current += ".."
 		case flags&0x2 == 0x2:
-			name += ".."
-		case flags&0x3 == 0x3:
-			name = "/"
-		case size > 0:
-			name += "/" + string(b2[2:2+size])
+			current += "."
+		default:
+			current += string(b2[2 : 2+size])
 		}
-
-		i += 2 + int(size)
+		if flags&0x1 == 0 && current != "" {
+			components = append(components, current)
+			current = ""
+		}
+		i += 2 + size
+	}
+	if current != "" {
+		components = append(components, current)
+	}
+	name := strings.Join(components, "/")
+	if root {
+		name = "/" + name
 	}
 	return rockRidgeSymlink{
 		continued: continued,
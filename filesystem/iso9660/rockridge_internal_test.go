@@ -83,6 +83,21 @@ func TestRockRidgeSymlinkMerge(t *testing.T) {
 	}
 }
 
+func TestRockRidgeSymlinkParse(t *testing.T) {
+	r := getRockRidgeExtension(rockRidge112)
+	for _, target := range []string{"/a/b/c", "a/b", "../a/./b", "/", "a"} {
+		b := rockRidgeSymlink{name: target}.Bytes()
+		ext, err := r.parseSymlink(b)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", target, err)
+			continue
+		}
+		if name := ext.(rockRidgeSymlink).name; name != target {
+			t.Errorf("%s: parsed as %s", target, name)
+		}
+	}
+}
+
 func TestRockRidgeNameMerge(t *testing.T) {
 	tests := []struct {
 		first        rockRidgeName
@@ -24,6 +24,14 @@ const (
 	bootSystemIdentifier        = "EL TORITO SPECIFICATION"
 )
 
+// jolietEscapeSequences are the escape sequences of a Joliet supplementary volume descriptor, for UCS-2 levels 1, 2
+// and 3; we write level 3
+var jolietEscapeSequences = [][]byte{
+	{0x25, 0x2f, 0x40}, // "%/@"
+	{0x25, 0x2f, 0x43}, // "%/C"
+	{0x25, 0x2f, 0x45}, // "%/E"
+}
+
 // volumeDescriptor interface for any given type of volume descriptor
 type volumeDescriptor interface {
 	Type() volumeDescriptorType
@@ -356,10 +364,18 @@ func parseSupplementaryVolumeDescriptor(b []byte) (*supplementaryVolumeDescripto
 		return nil, fmt.Errorf("unable to read root directory entry: %v", err)
 	}
 
+	escapeSequences := bytes.TrimRight(b[88:120], "\x00")
+	systemIdentifier, volumeIdentifier := string(b[8:40]), string(b[40:72])
+	if isJolietEscapeSequences(escapeSequences) {
+		systemIdentifier, volumeIdentifier = bytesToUCS2String(b[8:40]), bytesToUCS2String(b[40:72])
+	}
+
 	return &supplementaryVolumeDescriptor{
-		systemIdentifier:           string(b[8:40]),
-		volumeIdentifier:           string(b[40:72]),
+		volumeFlags:                b[7],
+		systemIdentifier:           systemIdentifier,
+		volumeIdentifier:           volumeIdentifier,
 		volumeSize:                 volumesizeBytes,
+		escapeSequences:            append([]byte{}, escapeSequences...),
 		setSize:                    binary.LittleEndian.Uint16(b[120:122]),
 		sequenceNumber:             binary.LittleEndian.Uint16(b[124:126]),
 		blocksize:                  blocksize,
@@ -391,11 +407,18 @@ func (v *supplementaryVolumeDescriptor) equal(a volumeDescriptor) bool {
 func (v *supplementaryVolumeDescriptor) toBytes() []byte {
 	b := volumeDescriptorFirstBytes(volumeDescriptorSupplementary)
 
-	copy(b[8:40], v.systemIdentifier)
-	copy(b[40:72], v.volumeIdentifier)
+	b[7] = v.volumeFlags
+	if v.joliet() {
+		copy(b[8:40], ucs2StringToBytes(v.systemIdentifier))
+		copy(b[40:72], ucs2StringToBytes(v.volumeIdentifier))
+	} else {
+		copy(b[8:40], v.systemIdentifier)
+		copy(b[40:72], v.volumeIdentifier)
+	}
 	blockcount := uint32(v.volumeSize / uint64(v.blocksize))
 	binary.LittleEndian.PutUint32(b[80:84], blockcount)
 	binary.BigEndian.PutUint32(b[84:88], blockcount)
+	copy(b[88:120], v.escapeSequences)
 	binary.LittleEndian.PutUint16(b[120:122], v.setSize)
 	binary.BigEndian.PutUint16(b[122:124], v.setSize)
 	binary.LittleEndian.PutUint16(b[124:126], v.sequenceNumber)
@@ -429,9 +452,26 @@ func (v *supplementaryVolumeDescriptor) toBytes() []byte {
 	copy(b[847:847+17], timeToDecBytes(v.expiration))
 	copy(b[864:864+17], timeToDecBytes(v.effective))
 
+	// set by the standard, as for the primary volume descriptor
+	b[881] = 1
+
 	return b
 }
 
+// joliet reports if this is a Joliet supplementary volume descriptor, whose names are in UCS-2
+func (v *supplementaryVolumeDescriptor) joliet() bool {
+	return isJolietEscapeSequences(v.escapeSequences)
+}
+
+func isJolietEscapeSequences(b []byte) bool {
+	for _, e := range jolietEscapeSequences {
+		if bytes.Equal(b, e) {
+			return true
+		}
+	}
+	return false
+}
+
 // partitionVolumeDescriptor
 func (v *partitionVolumeDescriptor) Type() volumeDescriptorType {
 	return volumeDescriptorPartition
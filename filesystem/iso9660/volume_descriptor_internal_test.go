@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -286,3 +287,57 @@ func TestPrimaryVolumeDescriptorType(t *testing.T) {
 		t.Errorf("Primary Volume Descriptor type was %v instead of expected %v", pvd.Type(), volumeDescriptorPrimary)
 	}
 }
+
+func TestSupplementaryVolumeDescriptorJoliet(t *testing.T) {
+	t1 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		escapeSequences []byte
+		joliet          bool
+	}{
+		{[]byte{0x25, 0x2f, 0x40}, true},
+		{[]byte{0x25, 0x2f, 0x43}, true},
+		{[]byte{0x25, 0x2f, 0x45}, true},
+		{[]byte{0x25, 0x2f, 0x46}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		svd := &supplementaryVolumeDescriptor{
+			volumeFlags:        0,
+			volumeIdentifier:   "Ünïcode",
+			volumeSize:         40 * 2048,
+			escapeSequences:    tt.escapeSequences,
+			setSize:            1,
+			sequenceNumber:     1,
+			blocksize:          2048,
+			pathTableSize:      10,
+			pathTableLLocation: 30,
+			pathTableMLocation: 31,
+			rootDirectoryEntry: &directoryEntry{isSelf: true, isSubdirectory: true, location: 22, size: 2048, creation: t1},
+			creation:           t1,
+			modification:       t1,
+			expiration:         t1,
+			effective:          t1,
+		}
+		if svd.joliet() != tt.joliet {
+			t.Errorf("escape sequences %x: joliet() was %v instead of expected %v", tt.escapeSequences, svd.joliet(), tt.joliet)
+		}
+		b := svd.toBytes()
+		vd, err := volumeDescriptorFromBytes(b)
+		if err != nil {
+			t.Fatalf("escape sequences %x: unexpected error parsing bytes: %v", tt.escapeSequences, err)
+		}
+		parsed, ok := vd.(*supplementaryVolumeDescriptor)
+		if !ok {
+			t.Fatalf("escape sequences %x: parsed %T instead of supplementary volume descriptor", tt.escapeSequences, vd)
+		}
+		if parsed.joliet() != tt.joliet {
+			t.Errorf("escape sequences %x: parsed joliet() was %v instead of expected %v", tt.escapeSequences, parsed.joliet(), tt.joliet)
+		}
+		if !bytes.Equal(parsed.toBytes(), b) {
+			t.Errorf("escape sequences %x: bytes changed after parsing", tt.escapeSequences)
+		}
+		if tt.joliet && strings.TrimRight(parsed.volumeIdentifier, "\x00") != svd.volumeIdentifier {
+			t.Errorf("escape sequences %x: parsed volume identifier %q instead of expected %q", tt.escapeSequences, parsed.volumeIdentifier, svd.volumeIdentifier)
+		}
+	}
+}
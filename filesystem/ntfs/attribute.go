@@ -0,0 +1,232 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+type attributeType uint32
+
+const (
+	attrStandardInformation attributeType = 0x10
+	attrAttributeList       attributeType = 0x20
+	attrFileName            attributeType = 0x30
+	attrVolumeName          attributeType = 0x60
+	attrData                attributeType = 0x80
+	attrIndexRoot           attributeType = 0x90
+	attrIndexAllocation     attributeType = 0xa0
+	attrBitmap              attributeType = 0xb0
+	attrEnd                 attributeType = 0xffffffff
+)
+
+// flags in an attribute header
+const (
+	attrFlagCompressionMask uint16 = 0x00ff
+	attrFlagEncrypted       uint16 = 0x4000
+	attrFlagSparse          uint16 = 0x8000
+)
+
+const (
+	residentHeaderSize    = 0x18
+	nonResidentHeaderSize = 0x40
+)
+
+// attribute is a single attribute of a file. A resident attribute has its value in the file record; a
+// non-resident one has a run list of where its value is, or for a large one, part of it.
+type attribute struct {
+	attrType    attributeType
+	name        string
+	nonResident bool
+	flags       uint16
+	// value of a resident attribute
+	value []byte
+	// for a non-resident attribute, the runs from startVCN to lastVCN, and the sizes of the whole value, which
+	// are only given in the first part
+	startVCN        int64
+	lastVCN         int64
+	runs            []run
+	compressionUnit uint8
+	dataSize        int64
+	initializedSize int64
+}
+
+// parseAttribute parses the attribute at the start of b, and returns how many bytes it took
+func parseAttribute(b []byte) (*attribute, int, error) {
+	if len(b) < residentHeaderSize {
+		return nil, 0, fmt.Errorf("attribute of %d bytes is too small for its header", len(b))
+	}
+	length := int(binary.LittleEndian.Uint32(b[4:8]))
+	if length < residentHeaderSize || length > len(b) || length%8 != 0 {
+		return nil, 0, fmt.Errorf("invalid attribute length %d", length)
+	}
+	b = b[:length]
+	a := &attribute{
+		attrType:    attributeType(binary.LittleEndian.Uint32(b[0:4])),
+		nonResident: b[8] != 0,
+		flags:       binary.LittleEndian.Uint16(b[12:14]),
+	}
+	if nameLen, nameOffset := int(b[9]), int(binary.LittleEndian.Uint16(b[10:12])); nameLen > 0 {
+		if nameOffset+nameLen*2 > length {
+			return nil, 0, fmt.Errorf("attribute name of %d characters at %d overflows it", nameLen, nameOffset)
+		}
+		a.name = decodeUTF16(b[nameOffset:], nameLen)
+	}
+	if !a.nonResident {
+		size := int(binary.LittleEndian.Uint32(b[16:20]))
+		offset := int(binary.LittleEndian.Uint16(b[20:22]))
+		if offset+size > length {
+			return nil, 0, fmt.Errorf("resident value of %d bytes at %d overflows the attribute", size, offset)
+		}
+		a.value = b[offset : offset+size]
+		a.dataSize = int64(size)
+		a.initializedSize = a.dataSize
+		return a, length, nil
+	}
+	if length < nonResidentHeaderSize {
+		return nil, 0, fmt.Errorf("non-resident attribute of %d bytes is too small for its header", length)
+	}
+	a.startVCN = int64(binary.LittleEndian.Uint64(b[16:24]))
+	a.lastVCN = int64(binary.LittleEndian.Uint64(b[24:32]))
+	runsOffset := int(binary.LittleEndian.Uint16(b[32:34]))
+	a.compressionUnit = uint8(binary.LittleEndian.Uint16(b[34:36]))
+	a.dataSize = int64(binary.LittleEndian.Uint64(b[48:56]))
+	a.initializedSize = int64(binary.LittleEndian.Uint64(b[56:64]))
+	if runsOffset > length {
+		return nil, 0, fmt.Errorf("run list at %d is past the end of the attribute", runsOffset)
+	}
+	runs, err := parseRunList(b[runsOffset:], a.startVCN)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid run list: %v", err)
+	}
+	a.runs = runs
+	return a, length, nil
+}
+
+// run is a run of clusters of a non-resident attribute, from vcn in the attribute, at lcn on the filesystem,
+// or with no clusters at all if it is sparse
+type run struct {
+	vcn    int64
+	lcn    int64
+	length int64
+	sparse bool
+}
+
+// parseRunList parses a run list starting at vcn. Each run has a header byte, with the size of its length in the
+// low nibble and of its offset in the high one, then the length, and then the offset of its first cluster from
+// that of the run before, signed. A run with no offset is sparse. A 0 header ends the list.
+func parseRunList(b []byte, vcn int64) ([]run, error) {
+	var (
+		runs []run
+		lcn  int64
+	)
+	for i := 0; i < len(b) && b[i] != 0; {
+		lengthSize, offsetSize := int(b[i]&0xf), int(b[i]>>4)
+		i++
+		if lengthSize == 0 || lengthSize > 8 || offsetSize > 8 || i+lengthSize+offsetSize > len(b) {
+			return nil, fmt.Errorf("invalid run header %#x", b[i-1])
+		}
+		var length int64
+		for j := lengthSize - 1; j >= 0; j-- {
+			length = length<<8 | int64(b[i+j])
+		}
+		i += lengthSize
+		if length <= 0 {
+			return nil, fmt.Errorf("invalid run length %d", length)
+		}
+		r := run{vcn: vcn, length: length, sparse: offsetSize == 0}
+		if offsetSize > 0 {
+			// sign-extend from the top byte
+			delta := int64(int8(b[i+offsetSize-1]))
+			for j := offsetSize - 2; j >= 0; j-- {
+				delta = delta<<8 | int64(b[i+j])
+			}
+			lcn += delta
+			if lcn < 0 {
+				return nil, fmt.Errorf("run at vcn %d starts at negative cluster %d", vcn, lcn)
+			}
+			r.lcn = lcn
+		}
+		i += offsetSize
+		runs = append(runs, r)
+		vcn += length
+	}
+	return runs, nil
+}
+
+// file attributes, as in $STANDARD_INFORMATION and $FILE_NAME
+const (
+	fileAttributeReadonly   uint32 = 0x1
+	fileAttributeHidden     uint32 = 0x2
+	fileAttributeSystem     uint32 = 0x4
+	fileAttributeDirectory  uint32 = 0x10000000
+	fileAttributeCompressed uint32 = 0x800
+)
+
+// standardInformation is the value of a $STANDARD_INFORMATION attribute
+type standardInformation struct {
+	creationTime     time.Time
+	modificationTime time.Time
+	changeTime       time.Time
+	accessTime       time.Time
+	fileAttributes   uint32
+}
+
+func parseStandardInformation(b []byte) (*standardInformation, error) {
+	if len(b) < 0x24 {
+		return nil, fmt.Errorf("standard information of %d bytes instead of at least %d", len(b), 0x24)
+	}
+	return &standardInformation{
+		creationTime:     ntfsTime(binary.LittleEndian.Uint64(b[0:8])),
+		modificationTime: ntfsTime(binary.LittleEndian.Uint64(b[8:16])),
+		changeTime:       ntfsTime(binary.LittleEndian.Uint64(b[16:24])),
+		accessTime:       ntfsTime(binary.LittleEndian.Uint64(b[24:32])),
+		fileAttributes:   binary.LittleEndian.Uint32(b[32:36]),
+	}, nil
+}
+
+// namespaces of a $FILE_NAME
+const (
+	namespaceWin32 uint8 = 1
+	namespaceDOS   uint8 = 2
+)
+
+const (
+	fileNameHeaderSize     = 0x42
+	attributeListEntrySize = 0x1a
+)
+
+// fileName is the value of a $FILE_NAME attribute, which is also the key of each entry in a directory index
+type fileName struct {
+	namespace uint8
+	name      string
+}
+
+func parseFileName(b []byte) (*fileName, error) {
+	if len(b) < fileNameHeaderSize {
+		return nil, fmt.Errorf("file name of %d bytes instead of at least %d", len(b), fileNameHeaderSize)
+	}
+	nameLen := int(b[64])
+	if fileNameHeaderSize+nameLen*2 > len(b) {
+		return nil, fmt.Errorf("file name of %d characters overflows its %d bytes", nameLen, len(b))
+	}
+	return &fileName{
+		namespace: b[65],
+		name:      decodeUTF16(b[fileNameHeaderSize:], nameLen),
+	}, nil
+}
+
+// parseAttributeList parses an $ATTRIBUTE_LIST, which has an entry for each attribute of a file saying which file
+// record it is in, and returns those records
+func parseAttributeList(b []byte) ([]mftReference, error) {
+	var records []mftReference
+	for pos := 0; pos+attributeListEntrySize <= len(b); {
+		length := int(binary.LittleEndian.Uint16(b[pos+4 : pos+6]))
+		if length < attributeListEntrySize || pos+length > len(b) {
+			return nil, fmt.Errorf("invalid attribute list entry length %d at %d", length, pos)
+		}
+		records = append(records, mftReference(binary.LittleEndian.Uint64(b[pos+16:pos+24])))
+		pos += length
+	}
+	return records, nil
+}
@@ -0,0 +1,98 @@
+package ntfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRunList(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		vcn  int64
+		runs []run
+	}{
+		{"empty", []byte{0}, 0, nil},
+		{"single", []byte{0x21, 0x18, 0x34, 0x56, 0}, 0, []run{{vcn: 0, lcn: 0x5634, length: 0x18}}},
+		{"negative delta", []byte{0x21, 0x30, 0x00, 0x02, 0x11, 0x10, 0xf0, 0}, 0, []run{
+			{vcn: 0, lcn: 0x200, length: 0x30},
+			{vcn: 0x30, lcn: 0x1f0, length: 0x10},
+		}},
+		{"sparse", []byte{0x11, 0x04, 0x10, 0x01, 0x0c, 0x11, 0x04, 0x08, 0}, 0, []run{
+			{vcn: 0, lcn: 0x10, length: 4},
+			{vcn: 4, length: 12, sparse: true},
+			{vcn: 16, lcn: 0x18, length: 4},
+		}},
+		{"later part", []byte{0x11, 0x08, 0x20, 0}, 100, []run{{vcn: 100, lcn: 0x20, length: 8}}},
+		{"no end", []byte{0x11, 0x08, 0x20}, 0, []run{{vcn: 0, lcn: 0x20, length: 8}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs, err := parseRunList(tt.b, tt.vcn)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(runs, tt.runs) {
+				t.Errorf("runs %+v instead of %+v", runs, tt.runs)
+			}
+		})
+	}
+	// no length, cut short, a 0 length, and a run before the start of the filesystem
+	for _, b := range [][]byte{{0x10, 0x05, 0}, {0x31, 0x05, 0x01, 0}, {0x11, 0x00, 0x05, 0}, {0x11, 0x30, 0x60, 0x21, 0x10, 0x00, 0xff, 0}} {
+		if _, err := parseRunList(b, 0); err == nil {
+			t.Errorf("no error for invalid run list % x", b)
+		}
+	}
+}
+
+func TestRunListRoundTrip(t *testing.T) {
+	runs := []run{
+		{vcn: 0, lcn: 1000, length: 300},
+		{vcn: 300, length: 70000, sparse: true},
+		{vcn: 70300, lcn: 20, length: 1},
+		{vcn: 70301, lcn: 5000000, length: 128},
+	}
+	parsed, err := parseRunList(encodeRunList(runs), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, runs) {
+		t.Errorf("runs %+v instead of %+v", parsed, runs)
+	}
+}
+
+func TestParseAttribute(t *testing.T) {
+	b := residentAttribute(attrIndexRoot, directoryIndex, []byte{1, 2, 3})
+	a, n, err := parseAttribute(append(b, 0xff, 0xff, 0xff, 0xff))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != len(b) || a.attrType != attrIndexRoot || a.name != directoryIndex || a.nonResident || string(a.value) != "\x01\x02\x03" {
+		t.Errorf("mismatched resident attribute %+v of %d bytes", a, n)
+	}
+
+	runs := []run{{vcn: 0, lcn: 50, length: 10}}
+	b = nonResidentAttribute(attrData, "", attrFlagSparse, 0, runs, 5000, 4000)
+	if a, _, err = parseAttribute(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !a.nonResident || a.flags != attrFlagSparse || a.lastVCN != 9 || a.dataSize != 5000 || a.initializedSize != 4000 || !reflect.DeepEqual(a.runs, runs) {
+		t.Errorf("mismatched non-resident attribute %+v", a)
+	}
+
+	b = nonResidentAttribute(attrData, "", 1, 0, runs, 5000, 5000)
+	if a, _, err = parseAttribute(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.compressionUnit != 4 || !reflect.DeepEqual(a.runs, runs) {
+		t.Errorf("mismatched compressed attribute %+v", a)
+	}
+
+	for _, length := range []uint32{0, 20, 4096} {
+		bad := residentAttribute(attrData, "", []byte{1})
+		bad[4], bad[5] = byte(length), byte(length>>8)
+		if _, _, err := parseAttribute(bad); err == nil {
+			t.Errorf("no error for attribute length %d", length)
+		}
+	}
+}
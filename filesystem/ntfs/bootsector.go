@@ -0,0 +1,89 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	bootSectorSize = 512
+	oemName        = "NTFS    "
+	// maxClusterSize is the largest cluster Windows creates
+	maxClusterSize int64 = 2 * MB
+)
+
+// bootSector is the part of the boot sector that describes the filesystem
+type bootSector struct {
+	bytesPerSector  int64
+	clusterSize     int64
+	totalSectors    uint64
+	mftCluster      uint64
+	mftMirrCluster  uint64
+	recordSize      int64
+	indexRecordSize int64
+	serialNumber    uint64
+}
+
+// parseBootSector parses the boot sector from b
+func parseBootSector(b []byte) (*bootSector, error) {
+	if len(b) < bootSectorSize {
+		return nil, fmt.Errorf("boot sector was %d bytes instead of %d", len(b), bootSectorSize)
+	}
+	if name := string(b[3:11]); name != oemName {
+		return nil, fmt.Errorf("boot sector had OEM name %q instead of expected %q", name, oemName)
+	}
+	if signature := binary.LittleEndian.Uint16(b[510:512]); signature != 0xaa55 {
+		return nil, fmt.Errorf("boot sector had signature %#x instead of expected 0xaa55", signature)
+	}
+	bs := &bootSector{
+		bytesPerSector: int64(binary.LittleEndian.Uint16(b[11:13])),
+		totalSectors:   binary.LittleEndian.Uint64(b[40:48]),
+		mftCluster:     binary.LittleEndian.Uint64(b[48:56]),
+		mftMirrCluster: binary.LittleEndian.Uint64(b[56:64]),
+		serialNumber:   binary.LittleEndian.Uint64(b[72:80]),
+	}
+	if bs.bytesPerSector < 256 || bs.bytesPerSector > 4096 || bs.bytesPerSector&(bs.bytesPerSector-1) != 0 {
+		return nil, fmt.Errorf("invalid bytes per sector %d", bs.bytesPerSector)
+	}
+	// more than 128 sectors per cluster is given as a negative power of 2
+	sectorsPerCluster := int64(b[13])
+	if sectorsPerCluster > 0x80 {
+		sectorsPerCluster = 1 << (256 - sectorsPerCluster)
+	}
+	bs.clusterSize = sectorsPerCluster * bs.bytesPerSector
+	if sectorsPerCluster == 0 || sectorsPerCluster&(sectorsPerCluster-1) != 0 || bs.clusterSize > maxClusterSize {
+		return nil, fmt.Errorf("invalid sectors per cluster %d", b[13])
+	}
+	var err error
+	if bs.recordSize, err = recordSize(int8(b[64]), bs.clusterSize); err != nil {
+		return nil, fmt.Errorf("invalid MFT record size: %v", err)
+	}
+	if bs.indexRecordSize, err = recordSize(int8(b[68]), bs.clusterSize); err != nil {
+		return nil, fmt.Errorf("invalid index record size: %v", err)
+	}
+	if bs.mftCluster*uint64(bs.clusterSize) >= bs.totalSectors*uint64(bs.bytesPerSector) {
+		return nil, fmt.Errorf("MFT at cluster %d is past the end of the filesystem", bs.mftCluster)
+	}
+	return bs, nil
+}
+
+// recordSize is the size of an MFT or index record, given in the boot sector as a number of clusters, or if
+// negative, as a power of 2 bytes
+func recordSize(v int8, clusterSize int64) (int64, error) {
+	var size int64
+	switch {
+	case v > 0:
+		size = int64(v) * clusterSize
+	case v < 0 && v > -32:
+		size = 1 << -v
+	}
+	if size < 512 || size > 64*KB || size&(size-1) != 0 {
+		return 0, fmt.Errorf("record size %d is not a power of 2 from 512 bytes to 64 KB", size)
+	}
+	return size, nil
+}
+
+// size is the size of the filesystem in bytes
+func (bs *bootSector) size() int64 {
+	return int64(bs.totalSectors) * bs.bytesPerSector
+}
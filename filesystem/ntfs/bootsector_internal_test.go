@@ -0,0 +1,80 @@
+package ntfs
+
+import (
+	"testing"
+)
+
+func TestParseBootSector(t *testing.T) {
+	valid := bootSectorBytes()
+	bs, err := parseBootSector(valid)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := bootSector{
+		bytesPerSector:  512,
+		clusterSize:     testClusterSize,
+		totalSectors:    testClusters*testClusterSize/512 - 1,
+		mftCluster:      16,
+		mftMirrCluster:  2,
+		recordSize:      testRecordSize,
+		indexRecordSize: testIndexRecordSize,
+		serialNumber:    0x1234567890abcdef,
+	}
+	if *bs != expected {
+		t.Errorf("boot sector %+v instead of %+v", *bs, expected)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte)
+	}{
+		{"bad OEM name", func(b []byte) { copy(b[3:11], "MSDOS5.0") }},
+		{"bad signature", func(b []byte) { b[510] = 0 }},
+		{"bad bytes per sector", func(b []byte) { b[11], b[12] = 0, 3 }},
+		{"no sectors per cluster", func(b []byte) { b[13] = 0 }},
+		{"sectors per cluster not a power of 2", func(b []byte) { b[13] = 3 }},
+		{"cluster too large", func(b []byte) { b[13] = 0xf0 }},
+		{"record size too small", func(b []byte) { b[64] = 0xf8 }},
+		{"no index record size", func(b []byte) { b[68] = 0 }},
+		{"MFT past the end", func(b []byte) { b[48], b[49] = 0, 0x10 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, bootSectorSize)
+			copy(b, valid)
+			tt.modify(b)
+			if _, err := parseBootSector(b); err == nil {
+				t.Errorf("no error for invalid boot sector")
+			}
+		})
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	tests := []struct {
+		v           int8
+		clusterSize int64
+		size        int64
+		valid       bool
+	}{
+		{-10, 4096, 1024, true},
+		{-12, 512, 4096, true},
+		{1, 4096, 4096, true},
+		{2, 512, 1024, true},
+		{0, 4096, 0, false},
+		{-8, 4096, 0, false},
+		{1, 128 * KB, 0, false},
+		{3, 512, 0, false},
+	}
+	for _, tt := range tests {
+		size, err := recordSize(tt.v, tt.clusterSize)
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("unexpected error for %d with cluster %d: %v", tt.v, tt.clusterSize, err)
+		case !tt.valid && err == nil:
+			t.Errorf("no error for %d with cluster %d", tt.v, tt.clusterSize)
+		case size != tt.size:
+			t.Errorf("size %d instead of %d for %d with cluster %d", size, tt.size, tt.v, tt.clusterSize)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ntfs
+
+import (
+	"os"
+	"time"
+)
+
+// FileStat is the extended data underlying a single file, similar to https://golang.org/pkg/syscall/#Stat_t
+type FileStat struct {
+	record       uint64
+	links        uint32
+	creationTime time.Time
+	accessTime   time.Time
+	changeTime   time.Time
+	attributes   uint32
+}
+
+// Record get the number of the MFT record of file, which is its inode number on Linux
+func (f *FileStat) Record() uint64 {
+	return f.record
+}
+
+// Nlink get the number of hard links to file
+func (f *FileStat) Nlink() uint32 {
+	return f.links
+}
+
+// CreationTime get the time file was created
+func (f *FileStat) CreationTime() time.Time {
+	return f.creationTime
+}
+
+// AccessTime get the time file was last read
+func (f *FileStat) AccessTime() time.Time {
+	return f.accessTime
+}
+
+// ChangeTime get the time the MFT record of file last changed
+func (f *FileStat) ChangeTime() time.Time {
+	return f.changeTime
+}
+
+// Attributes get the Windows file attributes of file, such as FILE_ATTRIBUTE_READONLY (0x1) and
+// FILE_ATTRIBUTE_HIDDEN (0x2)
+func (f *FileStat) Attributes() uint32 {
+	return f.attributes
+}
+
+// directoryEntry is a single directory entry
+// it combines information from the file record and the name it was found by
+// also fulfills os.FileInfo
+//
+//	Name() string       // base name of the file
+//	Size() int64        // length in bytes for regular files; system-dependent for others
+//	Mode() FileMode     // file mode bits
+//	ModTime() time.Time // modification time
+//	IsDir() bool        // abbreviation for Mode().IsDir()
+//	Sys() interface{}   // underlying data source (can return nil)
+type directoryEntry struct {
+	name    string
+	record  *fileRecord
+	size    int64
+	modTime time.Time
+	sys     FileStat
+}
+
+// Name string       // base name of the file
+func (d *directoryEntry) Name() string {
+	return d.name
+}
+
+// Size int64        // length in bytes for regular files; system-dependent for others
+func (d *directoryEntry) Size() int64 {
+	return d.size
+}
+
+// IsDir bool        // abbreviation for Mode().IsDir()
+func (d *directoryEntry) IsDir() bool {
+	return d.record.isDir()
+}
+
+// ModTime time.Time // modification time
+func (d *directoryEntry) ModTime() time.Time {
+	return d.modTime
+}
+
+// Mode FileMode     // file mode bits
+//
+// NTFS has no Unix permissions, so these are 0755 for a directory and 0644 for a file, without the write
+// bits if it is read-only.
+func (d *directoryEntry) Mode() os.FileMode {
+	mode := os.FileMode(0o644)
+	if d.IsDir() {
+		mode = os.ModeDir | 0o755
+	}
+	if d.sys.attributes&fileAttributeReadonly != 0 {
+		mode &^= 0o222
+	}
+	return mode
+}
+
+// Sys interface{}   // underlying data source (can return nil)
+func (d *directoryEntry) Sys() interface{} {
+	return d.sys
+}
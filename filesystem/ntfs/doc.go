@@ -0,0 +1,13 @@
+// Package ntfs provides read-only access to an NTFS filesystem on a block device or a disk image, such as
+// the system partition of a Windows disk image.
+//
+// It reads files and directories, including those whose attributes are spread over several MFT records with
+// an attribute list, fragmented and sparse files, and files compressed with LZNT1. Encrypted files, alternate
+// data streams and reparse points such as symbolic links are not supported, and nothing can be written.
+//
+// references:
+//
+//	https://flatcap.github.io/linux-ntfs/ntfs/
+//	https://github.com/torvalds/linux/tree/master/fs/ntfs3
+//	https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-xca/
+package ntfs
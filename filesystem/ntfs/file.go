@@ -0,0 +1,77 @@
+package ntfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in an NTFS filesystem, whose data is its unnamed $DATA attribute
+type File struct {
+	stream     *stream
+	offset     int64
+	filesystem *FileSystem
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	size := fl.stream.size
+	if fl.offset >= size {
+		return 0, io.EOF
+	}
+	if remaining := size - fl.offset; remaining < int64(len(b)) {
+		b = b[:remaining]
+	}
+	if err := fl.stream.readAt(b, fl.offset); err != nil {
+		return 0, err
+	}
+	fl.offset += int64(len(b))
+	var retErr error
+	if fl.offset >= size {
+		retErr = io.EOF
+	}
+	return len(b), retErr
+}
+
+// Write writes len(b) bytes to the File.
+//
+//	you cannot write to an NTFS filesystem, so this returns an error
+func (fl *File) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("cannot write to a read-only NTFS filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = fl.stream.size + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
@@ -0,0 +1,181 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// directoryIndex is the name of the index of file names in a directory
+	directoryIndex = "$I30"
+	// indexRootHeaderSize is the size of the header of an $INDEX_ROOT, before its node header
+	indexRootHeaderSize = 0x10
+	// indexRecordHeaderSize is the size of the header of an INDX record, before its node header
+	indexRecordHeaderSize = 0x18
+	indexEntryHeaderSize  = 0x10
+	// maxIndexDepth is how deep an index B-tree is allowed to be, well beyond any real one, to stop a loop
+	maxIndexDepth = 32
+)
+
+// flags in an index entry
+const (
+	indexEntrySubnode uint32 = 0x1
+	indexEntryLast    uint32 = 0x2
+)
+
+// indexEntry is an entry in a directory index: the file a name links to
+type indexEntry struct {
+	ref  mftReference
+	name *fileName
+}
+
+// nodeEntry is an entry in a node of an index, with the node below it, if there is one. The last entry in each node
+// has no key, only the node below it with the keys after those of all the other entries.
+type nodeEntry struct {
+	ref      mftReference
+	key      []byte
+	last     bool
+	hasChild bool
+	childVCN int64
+}
+
+// parseNode parses the entries of an index node, whose node header is at the start of b
+func parseNode(b []byte) ([]*nodeEntry, error) {
+	if len(b) < 0x10 {
+		return nil, fmt.Errorf("index node of %d bytes is too small for its header", len(b))
+	}
+	start := int(binary.LittleEndian.Uint32(b[0:4]))
+	end := int(binary.LittleEndian.Uint32(b[4:8]))
+	if end > len(b) || start > end {
+		return nil, fmt.Errorf("index node entries from %d to %d overflow its %d bytes", start, end, len(b))
+	}
+	var entries []*nodeEntry
+	for pos := start; pos+indexEntryHeaderSize <= end; {
+		length := int(binary.LittleEndian.Uint16(b[pos+8 : pos+10]))
+		keyLength := int(binary.LittleEndian.Uint16(b[pos+10 : pos+12]))
+		flags := binary.LittleEndian.Uint32(b[pos+12 : pos+16])
+		if length < indexEntryHeaderSize || pos+length > end || indexEntryHeaderSize+keyLength > length {
+			return nil, fmt.Errorf("invalid index entry of %d bytes at %d", length, pos)
+		}
+		e := &nodeEntry{
+			ref:      mftReference(binary.LittleEndian.Uint64(b[pos : pos+8])),
+			last:     flags&indexEntryLast != 0,
+			hasChild: flags&indexEntrySubnode != 0,
+		}
+		if !e.last {
+			e.key = b[pos+indexEntryHeaderSize : pos+indexEntryHeaderSize+keyLength]
+		}
+		if e.hasChild {
+			if length < indexEntryHeaderSize+keyLength+8 {
+				return nil, fmt.Errorf("index entry at %d has no room for its subnode", pos)
+			}
+			e.childVCN = int64(binary.LittleEndian.Uint64(b[pos+length-8 : pos+length]))
+		}
+		entries = append(entries, e)
+		if e.last {
+			return entries, nil
+		}
+		pos += length
+	}
+	return nil, fmt.Errorf("index node has no last entry")
+}
+
+// index is the directory index of a file record, which is a B-tree with its root in the $INDEX_ROOT and any other
+// nodes in INDX records in the $INDEX_ALLOCATION
+type index struct {
+	fs         *FileSystem
+	root       []*nodeEntry
+	allocation *stream
+	recordSize int64
+	// vcnSize is the size of the units in which the VCN of a node is given, which are clusters unless the
+	// records are smaller than a cluster
+	vcnSize int64
+}
+
+// readIndex reads the directory index of a file record
+func (fs *FileSystem) readIndex(r *fileRecord) (*index, error) {
+	root := r.findFirst(attrIndexRoot, directoryIndex)
+	if root == nil {
+		return nil, fmt.Errorf("record %d has no directory index", r.number)
+	}
+	if root.nonResident || len(root.value) < indexRootHeaderSize {
+		return nil, fmt.Errorf("invalid index root in record %d", r.number)
+	}
+	if t := attributeType(binary.LittleEndian.Uint32(root.value[0:4])); t != attrFileName {
+		return nil, fmt.Errorf("record %d indexes attribute type %#x instead of file names", r.number, t)
+	}
+	entries, err := parseNode(root.value[indexRootHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid index root in record %d: %v", r.number, err)
+	}
+	idx := &index{
+		fs:         fs,
+		root:       entries,
+		recordSize: int64(binary.LittleEndian.Uint32(root.value[8:12])),
+		vcnSize:    fs.bootSector.clusterSize,
+	}
+	if idx.recordSize < idx.vcnSize {
+		idx.vcnSize = fixupStride
+	}
+	if parts := r.find(attrIndexAllocation, directoryIndex); len(parts) > 0 {
+		if idx.allocation, err = fs.newStream(parts); err != nil {
+			return nil, fmt.Errorf("invalid index allocation in record %d: %v", r.number, err)
+		}
+	}
+	return idx, nil
+}
+
+// readNode reads the entries of the INDX record at vcn
+func (idx *index) readNode(vcn int64) ([]*nodeEntry, error) {
+	if idx.allocation == nil {
+		return nil, fmt.Errorf("index has a subnode at vcn %d but no index allocation", vcn)
+	}
+	b := make([]byte, idx.recordSize)
+	if err := idx.allocation.readAt(b, vcn*idx.vcnSize); err != nil {
+		return nil, fmt.Errorf("could not read index record at vcn %d: %v", vcn, err)
+	}
+	if err := applyFixups(b, indexMagic); err != nil {
+		return nil, fmt.Errorf("invalid index record at vcn %d: %v", vcn, err)
+	}
+	entries, err := parseNode(b[indexRecordHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid index record at vcn %d: %v", vcn, err)
+	}
+	return entries, nil
+}
+
+// entries returns all of the entries in the index, in order
+func (idx *index) entries() ([]*indexEntry, error) {
+	var all []*indexEntry
+	if err := idx.walk(idx.root, 0, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// walk adds the entries of a node to all, each after the entries of the node below it
+func (idx *index) walk(node []*nodeEntry, depth int, all *[]*indexEntry) error {
+	if depth > maxIndexDepth {
+		return fmt.Errorf("index is more than %d levels deep", maxIndexDepth)
+	}
+	for _, e := range node {
+		if e.hasChild {
+			child, err := idx.readNode(e.childVCN)
+			if err != nil {
+				return err
+			}
+			if err := idx.walk(child, depth+1, all); err != nil {
+				return err
+			}
+		}
+		if e.last {
+			break
+		}
+		name, err := parseFileName(e.key)
+		if err != nil {
+			return fmt.Errorf("invalid index entry for record %d: %v", e.ref.record(), err)
+		}
+		*all = append(*all, &indexEntry{ref: e.ref, name: name})
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// lznt1ChunkSize is how much data each LZNT1 chunk holds once decompressed
+	lznt1ChunkSize = 4096
+	// lznt1Compressed is set in the header of a chunk that is compressed, not stored as-is
+	lznt1Compressed uint16 = 0x8000
+)
+
+// lznt1Decompress decompresses LZNT1 data from src into dst, which is the size of the whole compression unit. The
+// data is a series of chunks, each of which holds 4 KB of dst, either compressed or stored as-is, and a 0 header
+// ends the data early. A chunk that decompresses to less than 4 KB is followed by zeros, and if the data ends
+// early, so is the rest of dst.
+func lznt1Decompress(dst, src []byte) error {
+	for i := range dst {
+		dst[i] = 0
+	}
+	pos := 0
+	for start := 0; start < len(dst) && pos+2 <= len(src); start += lznt1ChunkSize {
+		header := binary.LittleEndian.Uint16(src[pos : pos+2])
+		if header == 0 {
+			break
+		}
+		length := int(header&0xfff) + 1
+		pos += 2
+		if pos+length > len(src) {
+			return fmt.Errorf("chunk of %d bytes at %d overflows the %d bytes of data", length, pos-2, len(src))
+		}
+		end := start + lznt1ChunkSize
+		if end > len(dst) {
+			end = len(dst)
+		}
+		chunk := src[pos : pos+length]
+		pos += length
+		if header&lznt1Compressed == 0 {
+			copy(dst[start:end], chunk)
+			continue
+		}
+		if err := lznt1DecompressChunk(dst[start:end], chunk); err != nil {
+			return fmt.Errorf("invalid chunk at %d: %v", pos-length-2, err)
+		}
+	}
+	return nil
+}
+
+// lznt1DecompressChunk decompresses a single chunk into dst. Each flag byte says which of the next 8 tokens are
+// literal bytes, with a 0 bit, and which are 2 byte back references, with a 1 bit. How many bits of a back reference
+// are its offset and how many its length depends on how far into the chunk it is.
+func lznt1DecompressChunk(dst, src []byte) error {
+	out := 0
+	for i := 0; i < len(src); {
+		flags := src[i]
+		i++
+		for bit := 0; bit < 8 && i < len(src); bit++ {
+			if out >= len(dst) {
+				return nil
+			}
+			if flags&(1<<bit) == 0 {
+				dst[out] = src[i]
+				out++
+				i++
+				continue
+			}
+			if i+2 > len(src) {
+				return fmt.Errorf("back reference at %d is cut short", i)
+			}
+			token := binary.LittleEndian.Uint16(src[i : i+2])
+			i += 2
+			lengthBits := uint(12)
+			for p := out - 1; p >= 0x10; p >>= 1 {
+				lengthBits--
+			}
+			offset := int(token>>lengthBits) + 1
+			length := int(token&(1<<lengthBits-1)) + 3
+			if offset > out {
+				return fmt.Errorf("back reference to %d bytes back at %d is before the start of the chunk", offset, out)
+			}
+			for j := 0; j < length && out < len(dst); j++ {
+				dst[out] = dst[out-offset]
+				out++
+			}
+		}
+	}
+	return nil
+}
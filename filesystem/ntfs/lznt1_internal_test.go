@@ -0,0 +1,131 @@
+package ntfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// lznt1Compress compresses src with LZNT1, greedily taking the longest match at each point, storing any chunk that
+// does not get smaller as-is
+func lznt1Compress(src []byte) []byte {
+	var out []byte
+	for start := 0; start < len(src); start += lznt1ChunkSize {
+		end := start + lznt1ChunkSize
+		if end > len(src) {
+			end = len(src)
+		}
+		chunk := src[start:end]
+		compressed := lznt1CompressChunk(chunk)
+		header := make([]byte, 2)
+		if len(compressed) < len(chunk) {
+			binary.LittleEndian.PutUint16(header, lznt1Compressed|0x3000|uint16(len(compressed)-1))
+			out = append(out, header...)
+			out = append(out, compressed...)
+			continue
+		}
+		binary.LittleEndian.PutUint16(header, 0x3000|uint16(len(chunk)-1))
+		out = append(out, header...)
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func lznt1CompressChunk(chunk []byte) []byte {
+	var out []byte
+	for pos := 0; pos < len(chunk); {
+		flagsAt := len(out)
+		out = append(out, 0)
+		for bit := 0; bit < 8 && pos < len(chunk); bit++ {
+			lengthBits := uint(12)
+			for p := pos - 1; p >= 0x10; p >>= 1 {
+				lengthBits--
+			}
+			maxOffset, maxLength := 1<<(16-lengthBits), 1<<lengthBits-1+3
+			bestOffset, bestLength := 0, 0
+			for offset := 1; offset <= pos && offset <= maxOffset; offset++ {
+				length := 0
+				for length < maxLength && pos+length < len(chunk) && chunk[pos+length-offset] == chunk[pos+length] {
+					length++
+				}
+				if length > bestLength {
+					bestOffset, bestLength = offset, length
+				}
+			}
+			if bestLength < 3 {
+				out = append(out, chunk[pos])
+				pos++
+				continue
+			}
+			out[flagsAt] |= 1 << bit
+			token := make([]byte, 2)
+			binary.LittleEndian.PutUint16(token, uint16((bestOffset-1)<<lengthBits|(bestLength-3)))
+			out = append(out, token...)
+			pos += bestLength
+		}
+	}
+	return out
+}
+
+func TestLZNT1Decompress(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 300)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"literals", []byte("abcdefgh12345")},
+		{"repeated byte", bytes.Repeat([]byte{'a'}, 5000)},
+		{"text", text},
+		{"random", random},
+		{"text then random", append(append([]byte{}, text[:6000]...), random...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := lznt1Compress(tt.data)
+			// decompress into a whole unit, which must be padded with zeros
+			dst := bytes.Repeat([]byte{0xff}, 16384)
+			if err := lznt1Decompress(dst, compressed); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(dst[:len(tt.data)], tt.data) {
+				t.Errorf("decompressed data does not match")
+			}
+			if !bytes.Equal(dst[len(tt.data):], make([]byte, len(dst)-len(tt.data))) {
+				t.Errorf("rest of unit is not zeros")
+			}
+		})
+	}
+}
+
+func TestLZNT1DecompressKnown(t *testing.T) {
+	// "abcabcabcabc": 3 literals then a back reference 3 back of 9 bytes, with 12 bits of length this early
+	src := []byte{0x05, 0xb0, 0x08, 'a', 'b', 'c', 0x06, 0x20}
+	dst := make([]byte, 12)
+	if err := lznt1Decompress(dst, src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(dst) != "abcabcabcabc" {
+		t.Errorf("decompressed %q instead of %q", dst, "abcabcabcabc")
+	}
+}
+
+func TestLZNT1DecompressInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"chunk overflows", []byte{0x10, 0xb0, 0x00, 'a'}},
+		{"reference before start", []byte{0x02, 0xb0, 0x01, 0x00, 0x00}},
+		{"reference cut short", []byte{0x02, 0xb0, 0x02, 'a', 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := lznt1Decompress(make([]byte, 4096), tt.src); err == nil {
+				t.Errorf("no error for invalid data")
+			}
+		})
+	}
+}
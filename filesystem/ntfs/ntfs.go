@@ -0,0 +1,425 @@
+package ntfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	bootSector *bootSector
+	size       int64
+	start      int64
+	file       util.File
+	// mft is the $DATA of the MFT, which holds all of the file records
+	mft   *stream
+	root  *fileRecord
+	label string
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// note that you are *not* required to read a filesystem on the entire disk. You could have a disk of size
+// 20GB, and a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for working with filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// The sector and cluster sizes are taken from the boot sector, so blocksize is only checked: it must be 0, or a
+// power of 2 from 512 to 4096 bytes.
+//
+// The filesystem is read-only: every method that would change it returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	switch blocksize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("blocksize for ntfs must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	b := make([]byte, bootSectorSize)
+	if _, err := file.ReadAt(b, start); err != nil {
+		return nil, fmt.Errorf("unable to read bytes for boot sector: %v", err)
+	}
+	bs, err := parseBootSector(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing boot sector: %v", err)
+	}
+	if size > 0 && bs.size() > size {
+		return nil, fmt.Errorf("filesystem of %d bytes is larger than the %d bytes given", bs.size(), size)
+	}
+	fs := &FileSystem{
+		bootSector: bs,
+		size:       size,
+		start:      start,
+		file:       file,
+	}
+
+	// the MFT holds its own record first, so start with the runs in that, which are enough to read any
+	// extension records it has, and then read all of it
+	b = make([]byte, bs.recordSize)
+	if _, err := fs.readFull(b, int64(bs.mftCluster)*bs.clusterSize); err != nil {
+		return nil, fmt.Errorf("unable to read MFT record: %v", err)
+	}
+	mftRecord, err := parseFileRecord(b, recordMFT)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MFT record: %v", err)
+	}
+	if fs.mft, err = fs.newStream(mftRecord.find(attrData, "")); err != nil {
+		return nil, fmt.Errorf("invalid MFT data: %v", err)
+	}
+	if mftRecord, err = fs.readRecord(recordMFT); err != nil {
+		return nil, fmt.Errorf("unable to read MFT record: %v", err)
+	}
+	if fs.mft, err = fs.newStream(mftRecord.find(attrData, "")); err != nil {
+		return nil, fmt.Errorf("invalid MFT data: %v", err)
+	}
+
+	volume, err := fs.readRecord(recordVolume)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read volume record: %v", err)
+	}
+	if name := volume.findFirst(attrVolumeName, ""); name != nil && !name.nonResident {
+		fs.label = decodeUTF16(name.value, len(name.value)/2)
+	}
+	if fs.root, err = fs.readRecord(recordRoot); err != nil {
+		return nil, fmt.Errorf("unable to read root directory: %v", err)
+	}
+	if !fs.root.isDir() {
+		return nil, fmt.Errorf("root record %d is not a directory", recordRoot)
+	}
+	return fs, nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeNTFS
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeNTFS
+}
+
+// Label return the filesystem label, which is the name of the volume
+func (fs *FileSystem) Label() string {
+	return fs.label
+}
+
+// SetLabel changes the label on the filesystem, but NTFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) SetLabel(label string) error {
+	return fmt.Errorf("cannot set label on NTFS filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Statfs get the capacity of the filesystem, from its boot sector, and the number of file records in use. As it
+// is read-only, it has no free space.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	mftRecord, err := fs.readRecord(recordMFT)
+	if err != nil {
+		return filesystem.Statfs{}, fmt.Errorf("unable to read MFT record: %v", err)
+	}
+	var files uint64
+	if parts := mftRecord.find(attrBitmap, ""); len(parts) > 0 {
+		s, err := fs.newStream(parts)
+		if err != nil {
+			return filesystem.Statfs{}, fmt.Errorf("invalid MFT bitmap: %v", err)
+		}
+		bitmap, err := s.readAll()
+		if err != nil {
+			return filesystem.Statfs{}, fmt.Errorf("unable to read MFT bitmap: %v", err)
+		}
+		for _, c := range bitmap {
+			files += uint64(bits.OnesCount8(c))
+		}
+	}
+	total := fs.bootSector.size()
+	return filesystem.Statfs{
+		BlockSize:  fs.bootSector.clusterSize,
+		TotalBytes: total,
+		UsedBytes:  total,
+		Files:      files,
+	}, nil
+}
+
+// Mkdir make a directory at the given path, but NTFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Mkdir(p string) error {
+	return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory, in the order of the directory index.
+// The files NTFS keeps for itself in the root, such as $MFT, are not included, nor are short DOS names.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	if !de.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	entries, err := fs.readDirectory(de.record)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+	}
+	fi := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		r, err := fs.readEntry(e)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+		}
+		child, err := fs.hydrate(e.name.name, r)
+		if err != nil {
+			return nil, err
+		}
+		fi = append(fi, child)
+	}
+	return fi, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+//
+// accepts normal os.OpenFile flags, but as NTFS is read-only, any that would write return an error wrapping
+// filesystem.ErrReadonlyFilesystem
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if de.IsDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	parts := de.record.find(attrData, "")
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("cannot open %s, which has no data", p)
+	}
+	s, err := fs.newStream(parts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %v", p, err)
+	}
+	return &File{
+		stream:     s,
+		filesystem: fs,
+	}, nil
+}
+
+// Stat returns the FileInfo for a file or directory. Names are matched as Windows does, ignoring case if there is
+// no exact match.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	return fs.lstat(p)
+}
+
+// Remove remove a file or empty directory, but NTFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// RemoveAll remove a file or directory and everything in it, but NTFS is read-only, so this always returns an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// Rename rename a file or directory, but NTFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	return fmt.Errorf("cannot rename %s: %w", oldpath, filesystem.ErrReadonlyFilesystem)
+}
+
+// Truncate change the size of a file, but NTFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return fmt.Errorf("cannot truncate %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// lstat finds the file at p by walking the directory indexes from the root
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	r, name := fs.root, "/"
+	for _, part := range splitPath(p) {
+		if !r.isDir() {
+			return nil, fmt.Errorf("%s in path %s is not a directory", name, p)
+		}
+		entries, err := fs.readDirectory(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %v", name, err)
+		}
+		e := findEntry(entries, part)
+		if e == nil {
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+		}
+		if r, err = fs.readEntry(e); err != nil {
+			return nil, fmt.Errorf("error finding %s: %v", p, err)
+		}
+		name = e.name.name
+	}
+	return fs.hydrate(name, r)
+}
+
+// findEntry finds the entry called name, or if there is none, the first whose name only differs in case
+func findEntry(entries []*indexEntry, name string) *indexEntry {
+	for _, e := range entries {
+		if e.name.name == name {
+			return e
+		}
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.name.name, name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// readDirectory returns the entries in the index of a directory, without the files NTFS keeps for itself, which
+// includes the root directory itself, or short DOS names
+func (fs *FileSystem) readDirectory(r *fileRecord) ([]*indexEntry, error) {
+	idx, err := fs.readIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	all, err := idx.entries()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*indexEntry, 0, len(all))
+	for _, e := range all {
+		if e.name.namespace == namespaceDOS || e.ref.record() < firstUserRecord {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// readEntry reads the file record a directory entry links to, which must still be the same file
+func (fs *FileSystem) readEntry(e *indexEntry) (*fileRecord, error) {
+	r, err := fs.readRecord(e.ref.record())
+	if err != nil {
+		return nil, err
+	}
+	if seq := e.ref.sequence(); seq != 0 && seq != r.sequence {
+		return nil, fmt.Errorf("%s links to record %d with sequence %d, but it is now %d", e.name.name, r.number, seq, r.sequence)
+	}
+	return r, nil
+}
+
+// hydrate makes the directory entry for the file record r, found by name
+func (fs *FileSystem) hydrate(name string, r *fileRecord) (*directoryEntry, error) {
+	de := &directoryEntry{
+		name:   name,
+		record: r,
+		sys: FileStat{
+			record: r.number,
+		},
+	}
+	if a := r.findFirst(attrStandardInformation, ""); a != nil && !a.nonResident {
+		si, err := parseStandardInformation(a.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid standard information of %s: %v", name, err)
+		}
+		de.modTime = si.modificationTime
+		de.sys.creationTime = si.creationTime
+		de.sys.accessTime = si.accessTime
+		de.sys.changeTime = si.changeTime
+		de.sys.attributes = si.fileAttributes
+	}
+	// a file has a $FILE_NAME for each hard link, and another for its short name if that is different
+	for _, a := range r.find(attrFileName, "") {
+		if fn, err := parseFileName(a.value); err == nil && fn.namespace != namespaceDOS {
+			de.sys.links++
+		}
+	}
+	if parts := r.find(attrData, ""); len(parts) > 0 && !r.isDir() {
+		de.size = parts[0].dataSize
+	}
+	return de, nil
+}
+
+// readRecord reads a file record, with the attributes in any extension records listed in its attribute list
+func (fs *FileSystem) readRecord(number uint64) (*fileRecord, error) {
+	r, err := fs.readRecordPart(number)
+	if err != nil {
+		return nil, err
+	}
+	if r.baseRecord != 0 {
+		return nil, fmt.Errorf("record %d is an extension of record %d", number, r.baseRecord.record())
+	}
+	parts := r.find(attrAttributeList, "")
+	if len(parts) == 0 {
+		return r, nil
+	}
+	s, err := fs.newStream(parts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attribute list of record %d: %v", number, err)
+	}
+	b, err := s.readAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read attribute list of record %d: %v", number, err)
+	}
+	list, err := parseAttributeList(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attribute list of record %d: %v", number, err)
+	}
+	seen := map[uint64]bool{number: true}
+	for _, ref := range list {
+		n := ref.record()
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		ext, err := fs.readRecordPart(n)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read extension of record %d: %v", number, err)
+		}
+		if ext.baseRecord.record() != number {
+			return nil, fmt.Errorf("record %d listed as an extension of record %d is not", n, number)
+		}
+		r.attributes = append(r.attributes, ext.attributes...)
+	}
+	return r, nil
+}
+
+// readRecordPart reads a single file record from the MFT
+func (fs *FileSystem) readRecordPart(number uint64) (*fileRecord, error) {
+	recordSize := fs.bootSector.recordSize
+	if int64(number) >= fs.mft.size/recordSize {
+		return nil, fmt.Errorf("record %d is past the end of the MFT", number)
+	}
+	b := make([]byte, recordSize)
+	if err := fs.mft.readAt(b, int64(number)*recordSize); err != nil {
+		return nil, fmt.Errorf("unable to read record %d: %v", number, err)
+	}
+	return parseFileRecord(b, number)
+}
+
+// readFull reads all of b from pos on the filesystem
+func (fs *FileSystem) readFull(b []byte, pos int64) (int, error) {
+	if fs.size > 0 && pos+int64(len(b)) > fs.size {
+		return 0, fmt.Errorf("cannot read %d bytes at %d, past the end of the filesystem at %d", len(b), pos, fs.size)
+	}
+	n, err := fs.file.ReadAt(b, fs.start+pos)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(b)) {
+		return n, fmt.Errorf("error reading %d bytes at %d: %v", len(b), pos, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,242 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+)
+
+// the structures the unit tests parse are encoded here, for 512 byte clusters, 1 KB file records and 4 KB index records
+const (
+	testClusterSize     = 512
+	testRecordSize      = 1024
+	testIndexRecordSize = 4096
+	testClusters        = 1024
+)
+
+var testTime = time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+
+func bootSectorBytes() []byte {
+	b := make([]byte, bootSectorSize)
+	copy(b[0:3], []byte{0xeb, 0x52, 0x90})
+	copy(b[3:11], oemName)
+	binary.LittleEndian.PutUint16(b[11:13], 512)
+	b[13] = testClusterSize / 512
+	b[21] = 0xf8
+	// the last sector has the backup boot sector
+	binary.LittleEndian.PutUint64(b[40:48], testClusters*testClusterSize/512-1)
+	binary.LittleEndian.PutUint64(b[48:56], 16)
+	binary.LittleEndian.PutUint64(b[56:64], 2)
+	b[64] = 0xf6 // 2^10
+	b[68] = 0xf4 // 2^12
+	binary.LittleEndian.PutUint64(b[72:80], 0x1234567890abcdef)
+	binary.LittleEndian.PutUint16(b[510:512], 0xaa55)
+	return b
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+func encodeUTF16(s string) []byte {
+	chars := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(chars))
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+func testNTFSTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + ntfsEpochOffset)
+}
+
+func residentAttribute(t attributeType, name string, value []byte) []byte {
+	nameBytes := encodeUTF16(name)
+	valueOffset := align8(residentHeaderSize + len(nameBytes))
+	b := make([]byte, align8(valueOffset+len(value)))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(t))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)))
+	b[9] = uint8(len(nameBytes) / 2)
+	binary.LittleEndian.PutUint16(b[10:12], residentHeaderSize)
+	binary.LittleEndian.PutUint32(b[16:20], uint32(len(value)))
+	binary.LittleEndian.PutUint16(b[20:22], uint16(valueOffset))
+	copy(b[residentHeaderSize:], nameBytes)
+	copy(b[valueOffset:], value)
+	return b
+}
+
+// signedSize is how many bytes v takes as a signed little-endian number
+func signedSize(v int64) int {
+	n := 1
+	for v < -128 || v > 127 {
+		v >>= 8
+		n++
+	}
+	return n
+}
+
+func encodeRunList(runs []run) []byte {
+	var (
+		b   []byte
+		lcn int64
+	)
+	for _, r := range runs {
+		lengthSize, offsetSize := signedSize(r.length), 0
+		delta := r.lcn - lcn
+		if !r.sparse {
+			offsetSize = signedSize(delta)
+			lcn = r.lcn
+		}
+		b = append(b, byte(offsetSize<<4|lengthSize))
+		for i := 0; i < lengthSize; i++ {
+			b = append(b, byte(r.length>>(8*i)))
+		}
+		for i := 0; i < offsetSize; i++ {
+			b = append(b, byte(delta>>(8*i)))
+		}
+	}
+	return append(b, 0)
+}
+
+// nonResidentAttribute makes a non-resident attribute from runs starting at vcn. Only the first part of an
+// attribute, at vcn 0, has its sizes.
+func nonResidentAttribute(t attributeType, name string, flags uint16, vcn int64, runs []run, size, initialized int64) []byte {
+	headerSize := nonResidentHeaderSize
+	if flags&attrFlagCompressionMask != 0 {
+		headerSize += 8
+	}
+	nameBytes := encodeUTF16(name)
+	runsOffset := align8(headerSize + len(nameBytes))
+	runList := encodeRunList(runs)
+	b := make([]byte, align8(runsOffset+len(runList)))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(t))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)))
+	b[8] = 1
+	b[9] = uint8(len(nameBytes) / 2)
+	binary.LittleEndian.PutUint16(b[10:12], uint16(headerSize))
+	binary.LittleEndian.PutUint16(b[12:14], flags)
+	var clusters int64
+	for _, r := range runs {
+		clusters += r.length
+	}
+	binary.LittleEndian.PutUint64(b[16:24], uint64(vcn))
+	binary.LittleEndian.PutUint64(b[24:32], uint64(vcn+clusters-1))
+	binary.LittleEndian.PutUint16(b[32:34], uint16(runsOffset))
+	if flags&attrFlagCompressionMask != 0 {
+		binary.LittleEndian.PutUint16(b[34:36], 4)
+	}
+	if vcn == 0 {
+		binary.LittleEndian.PutUint64(b[40:48], uint64(clusters*testClusterSize))
+		binary.LittleEndian.PutUint64(b[48:56], uint64(size))
+		binary.LittleEndian.PutUint64(b[56:64], uint64(initialized))
+	}
+	copy(b[headerSize:], nameBytes)
+	copy(b[runsOffset:], runList)
+	return b
+}
+
+func standardInformationValue(attributes uint32) []byte {
+	b := make([]byte, 0x48)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(b[i*8:], testNTFSTime(testTime.Add(time.Duration(i)*time.Hour)))
+	}
+	binary.LittleEndian.PutUint32(b[32:36], attributes)
+	return b
+}
+
+func fileNameValue(parent uint64, name string, namespace uint8, size int64) []byte {
+	nameBytes := encodeUTF16(name)
+	b := make([]byte, fileNameHeaderSize+len(nameBytes))
+	binary.LittleEndian.PutUint64(b[0:8], parent|1<<48)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(b[8+i*8:], testNTFSTime(testTime))
+	}
+	binary.LittleEndian.PutUint64(b[40:48], uint64(size))
+	binary.LittleEndian.PutUint64(b[48:56], uint64(size))
+	b[64] = uint8(len(nameBytes) / 2)
+	b[65] = namespace
+	copy(b[fileNameHeaderSize:], nameBytes)
+	return b
+}
+
+// protect puts the update sequence number at the end of each 512 bytes of a record, keeping what was there in the
+// update sequence array
+func protect(b []byte, usaOffset int) {
+	binary.LittleEndian.PutUint16(b[4:6], uint16(usaOffset))
+	binary.LittleEndian.PutUint16(b[6:8], uint16(len(b)/fixupStride+1))
+	binary.LittleEndian.PutUint16(b[usaOffset:], 0x0007)
+	for i := 1; i <= len(b)/fixupStride; i++ {
+		end := i * fixupStride
+		copy(b[usaOffset+2*i:], b[end-2:end])
+		copy(b[end-2:end], b[usaOffset:usaOffset+2])
+	}
+}
+
+func fileRecordBytes(flags uint16, base uint64, attrs ...[]byte) []byte {
+	b := make([]byte, testRecordSize)
+	copy(b[0:4], recordMagic)
+	binary.LittleEndian.PutUint16(b[16:18], 1)
+	binary.LittleEndian.PutUint16(b[20:22], 0x38)
+	binary.LittleEndian.PutUint16(b[22:24], recordInUse|flags)
+	if base != 0 {
+		binary.LittleEndian.PutUint64(b[32:40], base|1<<48)
+	}
+	pos := 0x38
+	for _, a := range attrs {
+		pos += copy(b[pos:], a)
+	}
+	binary.LittleEndian.PutUint32(b[pos:], uint32(attrEnd))
+	binary.LittleEndian.PutUint32(b[24:28], uint32(pos+8))
+	binary.LittleEndian.PutUint32(b[28:32], testRecordSize)
+	protect(b, 0x30)
+	return b
+}
+
+func indexEntryBytes(ref uint64, key []byte, child int64) []byte {
+	length := align8(indexEntryHeaderSize + len(key))
+	var flags uint32
+	if child >= 0 {
+		flags |= indexEntrySubnode
+		length += 8
+	}
+	if key == nil {
+		flags |= indexEntryLast
+	}
+	b := make([]byte, length)
+	if key != nil {
+		binary.LittleEndian.PutUint64(b[0:8], ref|1<<48)
+	}
+	binary.LittleEndian.PutUint16(b[8:10], uint16(length))
+	binary.LittleEndian.PutUint16(b[10:12], uint16(len(key)))
+	binary.LittleEndian.PutUint32(b[12:16], flags)
+	copy(b[indexEntryHeaderSize:], key)
+	if child >= 0 {
+		binary.LittleEndian.PutUint64(b[length-8:], uint64(child))
+	}
+	return b
+}
+
+// nodeBytes makes a node header and its entries, the entries starting at offset from the header
+func nodeBytes(offset int, entries [][]byte, hasChildren bool) []byte {
+	b := make([]byte, offset)
+	for _, e := range entries {
+		b = append(b, e...)
+	}
+	binary.LittleEndian.PutUint32(b[0:4], uint32(offset))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(b)))
+	if hasChildren {
+		b[12] = 1
+	}
+	return b
+}
+
+func indexRootValue(entries [][]byte, hasChildren bool) []byte {
+	b := make([]byte, indexRootHeaderSize)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(attrFileName))
+	binary.LittleEndian.PutUint32(b[4:8], 1)
+	binary.LittleEndian.PutUint32(b[8:12], testIndexRecordSize)
+	b[12] = testIndexRecordSize / testClusterSize
+	return append(b, nodeBytes(0x10, entries, hasChildren)...)
+}
@@ -0,0 +1,475 @@
+package ntfs_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/ntfs"
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+const (
+	imageSize = 64 * ntfs.MB
+	label     = "go-diskfs"
+	// hardLinks is enough links to one file for their names not to fit in its file record, so that ntfs-3g gives it
+	// an attribute list
+	hardLinks = 40
+	// the attributes testdata/mkntfs.sh gives a read-only file and a compressed directory
+	attrReadonly   = 0x00000021
+	attrCompressed = 0x00000810
+)
+
+var modTime = time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+
+// tree is what the test images are made from, copied in through ntfs-3g
+type tree struct {
+	long       []byte
+	big        []byte
+	compressed []byte
+	frag1      []byte
+	frag2      []byte
+	manyFiles  int
+}
+
+func newTree(t *testing.T) *tree {
+	t.Helper()
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := rand.Read(b); err != nil {
+			t.Fatalf("unable to generate random data: %v", err)
+		}
+		return b
+	}
+	// compressible text with random runs, so that some compression units are compressed and some are not
+	var compressed []byte
+	for i := 0; i < 20; i++ {
+		compressed = append(compressed, bytes.Repeat([]byte(fmt.Sprintf("line %d of the compressed file\n", i)), 300)...)
+		compressed = append(compressed, random(5000+i)...)
+	}
+	return &tree{
+		long:       random(5000),
+		big:        random(300*1024 + 17),
+		compressed: compressed,
+		frag1:      random(256 * 1024),
+		frag2:      random(256 * 1024),
+		manyFiles:  300,
+	}
+}
+
+// files returns the regular files of the tree and their contents, apart from the fragmented and sparse ones,
+// which are written a piece at a time
+func (tr *tree) files() map[string][]byte {
+	files := map[string][]byte{
+		"hello.txt":                  []byte("hello world\n"),
+		"LongFileName.txt":           tr.long,
+		"big.bin":                    tr.big,
+		"empty":                      nil,
+		"readonly.txt":               []byte("read only\n"),
+		"Documents/report.txt":       bytes.Repeat([]byte("report "), 100),
+		"compressed/compressed.bin":  tr.compressed,
+		"compressed/small.txt":       []byte("too small to compress\n"),
+		"Documents/sub/nested.txt":   []byte("nested\n"),
+		"links/target.bin":           tr.big[:20000],
+		"compressed/zeros.bin":       make([]byte, 200*1024),
+		"compressed/compressed2.bin": tr.compressed[:70000],
+	}
+	for i := 0; i < tr.manyFiles; i++ {
+		files[fmt.Sprintf("many/file_with_a_long_name_%04d", i)] = []byte(fmt.Sprintf("%d\n", i))
+	}
+	return files
+}
+
+// sparse is the size of the sparse file, which has sparseData at sparseOffset and is otherwise empty
+const (
+	sparseSize   = 4 * ntfs.MB
+	sparseOffset = 2*ntfs.MB + 1000
+)
+
+var sparseData = []byte("in the middle of nothing")
+
+func (tr *tree) sparse() []byte {
+	b := make([]byte, sparseSize)
+	copy(b[sparseOffset:], sparseData)
+	return b
+}
+
+// write writes the tree to src, for testdata/mkntfs.sh to copy in, apart from the fragmented files, which it writes
+// to frag
+func (tr *tree) write(t *testing.T, src, frag string) {
+	t.Helper()
+	for name, content := range tr.files() {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(src, "LongFileName.txt"), filepath.Join(src, "Documents", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < hardLinks; i++ {
+		if err := os.Link(filepath.Join(src, "links", "target.bin"), filepath.Join(src, "links", fmt.Sprintf("link_%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// written as a hole and a little data, rather than as all of its bytes
+	sparse, err := os.Create(filepath.Join(src, "sparse.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sparse.Close()
+	if err := sparse.Truncate(sparseSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sparse.WriteAt(sparseData, sparseOffset); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(frag, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{"frag1.bin": tr.frag1, "frag2.bin": tr.frag2} {
+		if err := os.WriteFile(filepath.Join(frag, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mkfs makes an image with testdata/mkntfs.sh, which runs mkfs.ntfs with the given extra arguments, and copies the
+// tree in with ntfs-3g. It skips the test if the tools are not available, or ntfs-3g cannot mount the image.
+func mkfs(t *testing.T, tr *tree, args []string) string {
+	t.Helper()
+	dir := t.TempDir()
+	tr.write(t, filepath.Join(dir, "src"), filepath.Join(dir, "frag"))
+	img := filepath.Join(dir, "ntfs.img")
+	f, err := os.Create(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(imageSize); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	script, err := filepath.Abs(filepath.Join("testdata", "mkntfs.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools := []string{"mkfs.ntfs", "ntfs-3g", "setfattr", "mountpoint"}
+	cmdArgs := append([]string{"sh", script, img, label, "src", "frag"}, args...)
+	if out, err := testhelper.RunTools(t, dir, tools, cmdArgs...); err != nil {
+		if bytes.Contains(out, []byte("ntfs-3g did not mount")) {
+			t.Skipf("unable to mount with ntfs-3g: %v\n%s", err, out)
+		}
+		t.Fatalf("%v failed: %v\n%s", cmdArgs, err, out)
+	}
+	return img
+}
+
+func readImage(t *testing.T, img string) *ntfs.FileSystem {
+	t.Helper()
+	f, err := os.Open(img)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", img, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	fs, err := ntfs.Read(f, imageSize, 0, 512)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	return fs
+}
+
+func readFile(t *testing.T, fs *ntfs.FileSystem, p string) []byte {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", p, err)
+	}
+	defer f.Close()
+	// read in pieces that do not line up with clusters
+	var data []byte
+	buf := make([]byte, 777)
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read %s: %v", p, err)
+		}
+	}
+	return data
+}
+
+func TestRead(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name        string
+		args        []string
+		clusterSize int64
+	}{
+		{"default", nil, 4096},
+		{"512 byte clusters", []string{"-c", "512"}, 512},
+		{"1k clusters", []string{"-c", "1024"}, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := mkfs(t, tr, tt.args)
+			fs := readImage(t, img)
+			if fs.Type() != filesystem.TypeNTFS {
+				t.Errorf("type %v instead of %v", fs.Type(), filesystem.TypeNTFS)
+			}
+			if fs.Label() != label {
+				t.Errorf("label %q instead of %q", fs.Label(), label)
+			}
+			stat, err := fs.Statfs()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// mkfs.ntfs leaves the last sector for the backup boot sector
+			if stat.BlockSize != tt.clusterSize || stat.TotalBytes > imageSize || stat.TotalBytes < imageSize-tt.clusterSize-512 || stat.UsedBytes != stat.TotalBytes {
+				t.Errorf("mismatched statfs %+v", stat)
+			}
+			if files := uint64(len(tr.files())); stat.Files < files {
+				t.Errorf("%d files in use, fewer than the %d in the tree", stat.Files, files)
+			}
+
+			for name, content := range tr.files() {
+				if b := readFile(t, fs, "/"+name); !bytes.Equal(b, content) {
+					t.Errorf("read %d bytes of %s that do not match the expected %d", len(b), name, len(content))
+				}
+			}
+			for p, content := range map[string][]byte{
+				"/frag1.bin":            tr.frag1,
+				"/frag2.bin":            tr.frag2,
+				"/sparse.bin":           tr.sparse(),
+				"/Documents/link.txt":   tr.long,
+				"/DOCUMENTS/Report.TXT": tr.files()["Documents/report.txt"],
+				"/links/link_39":        tr.big[:20000],
+			} {
+				if b := readFile(t, fs, p); !bytes.Equal(b, content) {
+					t.Errorf("read %d bytes of %s that do not match the expected %d", len(b), p, len(content))
+				}
+			}
+		})
+	}
+}
+
+func TestReadNotNTFS(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "zero.img")
+	if err != nil {
+		t.Fatalf("unable to create image file: %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(ntfs.MB); err != nil {
+		t.Fatalf("unable to size image file: %v", err)
+	}
+	if _, err := ntfs.Read(f, ntfs.MB, 0, 512); err == nil {
+		t.Errorf("no error reading a filesystem that is not NTFS")
+	}
+	if _, err := ntfs.Read(f, ntfs.MB, 0, 3000); err == nil {
+		t.Errorf("no error for invalid blocksize")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	many := make([]string, tr.manyFiles)
+	for i := range many {
+		many[i] = fmt.Sprintf("file_with_a_long_name_%04d", i)
+	}
+	links := []string{"target.bin"}
+	for i := 0; i < hardLinks; i++ {
+		links = append(links, fmt.Sprintf("link_%02d", i))
+	}
+	tests := []struct {
+		path  string
+		names []string
+	}{
+		{"/", []string{"big.bin", "compressed", "Documents", "empty", "frag1.bin", "frag2.bin", "hello.txt", "links", "LongFileName.txt", "many", "readonly.txt", "sparse.bin"}},
+		{"/Documents", []string{"link.txt", "report.txt", "sub"}},
+		{"/documents/", []string{"link.txt", "report.txt", "sub"}},
+		{"/compressed", []string{"compressed.bin", "compressed2.bin", "small.txt", "zeros.bin"}},
+		{"/many", many},
+		{"/links", links},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			fi, err := fs.ReadDir(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			names := make([]string, 0, len(fi))
+			for _, f := range fi {
+				names = append(names, f.Name())
+				if f.IsDir() {
+					continue
+				}
+				p := path.Join(tt.path, f.Name())
+				if b := readFile(t, fs, p); int64(len(b)) != f.Size() {
+					t.Errorf("size of %s is %d, but %d bytes were read", p, f.Size(), len(b))
+				}
+			}
+			// the entries are in the order of the index, which ignores case
+			expected := append([]string(nil), tt.names...)
+			sort.Slice(expected, func(i, j int) bool { return strings.ToUpper(expected[i]) < strings.ToUpper(expected[j]) })
+			if strings.Join(names, ",") != strings.Join(expected, ",") {
+				t.Errorf("names %v instead of %v", names, expected)
+			}
+		})
+	}
+	if _, err := fs.ReadDir("/readonly.txt"); err == nil {
+		t.Errorf("no error reading a file as a directory")
+	}
+	if _, err := fs.ReadDir("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v instead of one wrapping os.ErrNotExist", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	for _, p := range []string{"/missing", "/Documents", "/hello.txt/x"} {
+		if _, err := fs.OpenFile(p, os.O_RDONLY); err == nil {
+			t.Errorf("no error opening %s", p)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	for p, content := range map[string][]byte{
+		"/compressed/compressed.bin": tr.compressed,
+		"/frag2.bin":                 tr.frag2,
+		"/sparse.bin":                tr.sparse(),
+	} {
+		t.Run(p, func(t *testing.T) {
+			f, err := fs.OpenFile(p, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer f.Close()
+			size := int64(len(content))
+			for _, off := range []int64{size / 2, 100, 65530, size - 20, sparseOffset - 3} {
+				if off+20 > size {
+					continue
+				}
+				if _, err := f.Seek(off, io.SeekStart); err != nil {
+					t.Fatalf("unexpected error seeking to %d: %v", off, err)
+				}
+				b := make([]byte, 20)
+				if _, err := io.ReadFull(f, b); err != nil {
+					t.Fatalf("unexpected error reading at %d: %v", off, err)
+				}
+				if !bytes.Equal(b, content[off:off+20]) {
+					t.Errorf("mismatched data at %d", off)
+				}
+			}
+			if pos, err := f.Seek(-10, io.SeekEnd); err != nil || pos != size-10 {
+				t.Errorf("seek from end to %d with error %v", pos, err)
+			}
+			if _, err := f.Seek(-1, io.SeekStart); err == nil {
+				t.Errorf("no error seeking before the start")
+			}
+		})
+	}
+}
+
+func TestStat(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	tests := []struct {
+		path       string
+		name       string
+		mode       os.FileMode
+		size       int64
+		links      uint32
+		attributes uint32
+	}{
+		{"/longfilename.TXT", "LongFileName.txt", 0o644, int64(len(tr.long)), 2, 0},
+		{"/readonly.txt", "readonly.txt", 0o444, 10, 1, attrReadonly},
+		{"/links/target.bin", "target.bin", 0o644, 20000, hardLinks + 1, 0},
+		{"/compressed/compressed.bin", "compressed.bin", 0o644, int64(len(tr.compressed)), 1, 0x800},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			fi, err := fs.Stat(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Name() != tt.name || fi.Mode() != tt.mode || fi.Size() != tt.size {
+				t.Errorf("name %q, mode %v, size %d instead of %q, %v, %d", fi.Name(), fi.Mode(), fi.Size(), tt.name, tt.mode, tt.size)
+			}
+			if !fi.ModTime().Equal(modTime) {
+				t.Errorf("modification time %v instead of %v", fi.ModTime(), modTime)
+			}
+			sys, ok := fi.Sys().(ntfs.FileStat)
+			if !ok {
+				t.Fatalf("sys was %T instead of ntfs.FileStat", fi.Sys())
+			}
+			if sys.Nlink() != tt.links || sys.Attributes()&tt.attributes != tt.attributes {
+				t.Errorf("links %d, attributes %#x instead of %d, %#x", sys.Nlink(), sys.Attributes(), tt.links, tt.attributes)
+			}
+		})
+	}
+	for _, p := range []string{"/", "/Documents", "/documents/SUB"} {
+		fi, err := fs.Stat(p)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", p, err)
+		}
+		if !fi.IsDir() || fi.Mode() != os.ModeDir|0o755 {
+			t.Errorf("%s has mode %v instead of a directory", p, fi.Mode())
+		}
+	}
+	if _, err := fs.Stat("/readonly.txt/x"); err == nil {
+		t.Errorf("no error for a path through a file")
+	}
+	if _, err := fs.Stat("/$MFT"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v instead of one wrapping os.ErrNotExist for a system file", err)
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), nil))
+	f, err := fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	_, writeErr := f.Write([]byte("x"))
+	_, openErr := fs.OpenFile("/hello.txt", os.O_RDWR)
+	for _, err := range []error{
+		fs.Mkdir("/new"),
+		fs.Remove("/readonly.txt"),
+		fs.RemoveAll("/Documents"),
+		fs.Rename("/readonly.txt", "/other.txt"),
+		fs.Truncate("/readonly.txt", 10),
+		fs.SetLabel("label"),
+		writeErr,
+		openErr,
+	} {
+		if !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("error %v instead of one wrapping filesystem.ErrReadonlyFilesystem", err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	// fixupStride is how far apart the update sequence numbers of a multi-sector record are, whatever the
+	// sector size
+	fixupStride = 512
+	recordMagic = "FILE"
+	indexMagic  = "INDX"
+)
+
+// flags in a file record header
+const (
+	recordInUse       uint16 = 0x1
+	recordIsDirectory uint16 = 0x2
+)
+
+// well-known file records
+const (
+	recordMFT    uint64 = 0
+	recordVolume uint64 = 3
+	recordRoot   uint64 = 5
+	// firstUserRecord is the first record that is not reserved for the filesystem itself
+	firstUserRecord uint64 = 16
+)
+
+// mftReference is a reference to a file record: its number in the low 48 bits and its sequence number in the high
+// 16 bits, which changes whenever the record is reused
+type mftReference uint64
+
+func (r mftReference) record() uint64 {
+	return uint64(r) & 0xffffffffffff
+}
+
+func (r mftReference) sequence() uint16 {
+	return uint16(r >> 48)
+}
+
+// fileRecord is a record in the MFT, with all of the attributes of the file, including those in any
+// extension records
+type fileRecord struct {
+	number     uint64
+	sequence   uint16
+	flags      uint16
+	baseRecord mftReference
+	attributes []*attribute
+}
+
+// applyFixups checks that the last 2 bytes of each 512 byte stride of a record in b match its update sequence
+// number, which shows it was written whole, and puts back the bytes they replaced
+func applyFixups(b []byte, magic string) error {
+	if len(b) < 8 || string(b[0:4]) != magic {
+		return fmt.Errorf("record does not start with %s", magic)
+	}
+	usaOffset := int(binary.LittleEndian.Uint16(b[4:6]))
+	usaCount := int(binary.LittleEndian.Uint16(b[6:8]))
+	if usaCount < 2 || usaOffset+usaCount*2 > len(b) || (usaCount-1)*fixupStride > len(b) {
+		return fmt.Errorf("invalid update sequence of %d at %d for record of %d bytes", usaCount, usaOffset, len(b))
+	}
+	for i := 1; i < usaCount; i++ {
+		end := i * fixupStride
+		if b[end-2] != b[usaOffset] || b[end-1] != b[usaOffset+1] {
+			return fmt.Errorf("update sequence number mismatch at %d, record was not written whole", end)
+		}
+		copy(b[end-2:end], b[usaOffset+2*i:usaOffset+2*i+2])
+	}
+	return nil
+}
+
+// parseFileRecord parses file record number from b, which it changes by applying its fixups
+func parseFileRecord(b []byte, number uint64) (*fileRecord, error) {
+	if err := applyFixups(b, recordMagic); err != nil {
+		return nil, err
+	}
+	r := &fileRecord{
+		number:     number,
+		sequence:   binary.LittleEndian.Uint16(b[16:18]),
+		flags:      binary.LittleEndian.Uint16(b[22:24]),
+		baseRecord: mftReference(binary.LittleEndian.Uint64(b[32:40])),
+	}
+	if r.flags&recordInUse == 0 {
+		return nil, fmt.Errorf("record %d is not in use", number)
+	}
+	used := int(binary.LittleEndian.Uint32(b[24:28]))
+	if used > len(b) {
+		return nil, fmt.Errorf("record %d uses %d bytes, more than its %d", number, used, len(b))
+	}
+	for pos := int(binary.LittleEndian.Uint16(b[20:22])); pos+4 <= used; {
+		if binary.LittleEndian.Uint32(b[pos:pos+4]) == uint32(attrEnd) {
+			return r, nil
+		}
+		a, n, err := parseAttribute(b[pos:used])
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute at %d of record %d: %v", pos, number, err)
+		}
+		r.attributes = append(r.attributes, a)
+		pos += n
+	}
+	return nil, fmt.Errorf("record %d has no end to its attributes", number)
+}
+
+// isDir says whether the record is for a directory
+func (r *fileRecord) isDir() bool {
+	return r.flags&recordIsDirectory != 0
+}
+
+// find returns the parts of the attribute of the given type and name, which may be in several extension records,
+// in order
+func (r *fileRecord) find(t attributeType, name string) []*attribute {
+	var found []*attribute
+	for _, a := range r.attributes {
+		if a.attrType == t && a.name == name {
+			found = append(found, a)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].startVCN < found[j].startVCN
+	})
+	return found
+}
+
+// findFirst returns the first attribute of the given type and name, or nil if there is none
+func (r *fileRecord) findFirst(t attributeType, name string) *attribute {
+	for _, a := range r.attributes {
+		if a.attrType == t && a.name == name {
+			return a
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package ntfs
+
+import (
+	"testing"
+)
+
+func TestParseFileRecord(t *testing.T) {
+	b := fileRecordBytes(recordIsDirectory, 0,
+		residentAttribute(attrStandardInformation, "", standardInformationValue(0)),
+		residentAttribute(attrFileName, "", fileNameValue(recordRoot, "dir", namespaceWin32, 0)),
+		residentAttribute(attrIndexRoot, directoryIndex, indexRootValue([][]byte{indexEntryBytes(0, nil, -1)}, false)),
+	)
+	// put something that must be restored by the fixups at the end of the first sector
+	b[510], b[511] = 0xaa, 0xbb
+	protect(b, 0x30)
+	r, err := parseFileRecord(b, 40)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.number != 40 || r.sequence != 1 || !r.isDir() || len(r.attributes) != 3 {
+		t.Errorf("mismatched record %+v", r)
+	}
+	if b[510] != 0xaa || b[511] != 0xbb {
+		t.Errorf("fixups did not restore the end of the sector")
+	}
+	if a := r.findFirst(attrIndexRoot, directoryIndex); a == nil {
+		t.Errorf("did not find index root")
+	}
+	if a := r.findFirst(attrIndexRoot, ""); a != nil {
+		t.Errorf("found index root with the wrong name")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte)
+	}{
+		{"bad magic", func(b []byte) { b[0] = 'B' }},
+		{"torn write", func(b []byte) { b[1022]++ }},
+		{"not in use", func(b []byte) { b[22] &^= byte(recordInUse) }},
+		{"used past the end", func(b []byte) { b[25] = 0x10 }},
+		{"no end marker", func(b []byte) { b[24], b[25] = 0x40, 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fileRecordBytes(0, 0, residentAttribute(attrData, "", []byte("data")))
+			tt.modify(b)
+			if _, err := parseFileRecord(b, 40); err == nil {
+				t.Errorf("no error for invalid record")
+			}
+		})
+	}
+}
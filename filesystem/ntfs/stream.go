@@ -0,0 +1,199 @@
+package ntfs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// stream is the value of an attribute, which for a non-resident attribute may be spread over several
+// attributes, each with the runs of part of it
+type stream struct {
+	fs              *FileSystem
+	resident        bool
+	value           []byte
+	runs            []run
+	size            int64
+	initializedSize int64
+	// unitSize is the size in bytes of each compression unit, or 0 if the stream is not compressed
+	unitSize int64
+	// the compression unit last decompressed and its data
+	unit     int64
+	unitData []byte
+}
+
+// newStream creates a stream from the parts of an attribute, which must be in order
+func (fs *FileSystem) newStream(parts []*attribute) (*stream, error) {
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("attribute has no parts")
+	}
+	first := parts[0]
+	s := &stream{
+		fs:              fs,
+		resident:        !first.nonResident,
+		value:           first.value,
+		size:            first.dataSize,
+		initializedSize: first.initializedSize,
+		unit:            -1,
+	}
+	if s.resident {
+		return s, nil
+	}
+	if first.flags&attrFlagEncrypted != 0 {
+		return nil, fmt.Errorf("encrypted attributes are not supported")
+	}
+	if first.flags&attrFlagCompressionMask != 0 {
+		if first.compressionUnit == 0 {
+			return nil, fmt.Errorf("compressed attribute has no compression unit")
+		}
+		s.unitSize = fs.bootSector.clusterSize << first.compressionUnit
+	}
+	var vcn int64
+	for _, a := range parts {
+		if !a.nonResident {
+			return nil, fmt.Errorf("resident part of a non-resident attribute")
+		}
+		if a.startVCN != vcn {
+			return nil, fmt.Errorf("part of attribute starts at vcn %d instead of %d", a.startVCN, vcn)
+		}
+		s.runs = append(s.runs, a.runs...)
+		vcn = a.lastVCN + 1
+	}
+	if s.initializedSize > s.size {
+		s.initializedSize = s.size
+	}
+	return s, nil
+}
+
+// readAt reads all of b from off in the stream, which must not go past its end
+func (s *stream) readAt(b []byte, off int64) error {
+	if off < 0 || off+int64(len(b)) > s.size {
+		return fmt.Errorf("cannot read %d bytes at %d of stream of %d bytes", len(b), off, s.size)
+	}
+	if s.resident {
+		copy(b, s.value[off:])
+		return nil
+	}
+	for len(b) > 0 {
+		var (
+			n   int
+			err error
+		)
+		switch {
+		case off >= s.initializedSize:
+			// anything past the initialized size reads as zeros, whatever is on disk
+			n = len(b)
+			for i := range b {
+				b[i] = 0
+			}
+		case s.unitSize > 0:
+			n, err = s.readCompressed(b, off)
+		default:
+			n, err = s.readRun(b, off)
+		}
+		if err != nil {
+			return err
+		}
+		if end := s.initializedSize - off; off < s.initializedSize && int64(n) > end {
+			for i := end; i < int64(n); i++ {
+				b[i] = 0
+			}
+		}
+		b = b[n:]
+		off += int64(n)
+	}
+	return nil
+}
+
+// readAll reads the whole stream
+func (s *stream) readAll() ([]byte, error) {
+	b := make([]byte, s.size)
+	if err := s.readAt(b, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// findRun returns the run that holds vcn
+func (s *stream) findRun(vcn int64) (*run, error) {
+	i := sort.Search(len(s.runs), func(i int) bool {
+		return s.runs[i].vcn+s.runs[i].length > vcn
+	})
+	if i == len(s.runs) || s.runs[i].vcn > vcn {
+		return nil, fmt.Errorf("no run holds vcn %d", vcn)
+	}
+	return &s.runs[i], nil
+}
+
+// readRun reads from off in the stream as far as the run that holds it, and returns how much it read
+func (s *stream) readRun(b []byte, off int64) (int, error) {
+	clusterSize := s.fs.bootSector.clusterSize
+	r, err := s.findRun(off / clusterSize)
+	if err != nil {
+		return 0, err
+	}
+	within := off - r.vcn*clusterSize
+	if left := r.length*clusterSize - within; left < int64(len(b)) {
+		b = b[:left]
+	}
+	if r.sparse {
+		for i := range b {
+			b[i] = 0
+		}
+		return len(b), nil
+	}
+	return s.fs.readFull(b, r.lcn*clusterSize+within)
+}
+
+// readCompressed reads from off in the stream as far as the compression unit that holds it, decompressing the
+// unit unless it is the one last read, and returns how much it read
+func (s *stream) readCompressed(b []byte, off int64) (int, error) {
+	unit := off / s.unitSize
+	if unit != s.unit {
+		data, err := s.readUnit(unit)
+		if err != nil {
+			return 0, fmt.Errorf("could not read compression unit %d: %v", unit, err)
+		}
+		s.unit, s.unitData = unit, data
+	}
+	return copy(b, s.unitData[off-unit*s.unitSize:]), nil
+}
+
+// readUnit reads a compression unit. If all of the clusters it needs are allocated, it is stored as-is, and if none
+// are, it is all zeros. Otherwise the clusters that are allocated, which come first, hold its data compressed.
+func (s *stream) readUnit(unit int64) ([]byte, error) {
+	clusterSize := s.fs.bootSector.clusterSize
+	clusters := s.unitSize / clusterSize
+	// the last unit only needs enough clusters for the end of the stream
+	needed := clusters
+	if left := (s.size - unit*s.unitSize + clusterSize - 1) / clusterSize; left < needed {
+		needed = left
+	}
+	data := make([]byte, s.unitSize)
+	allocated := int64(0)
+	for vcn := unit * clusters; vcn < unit*clusters+needed; {
+		r, err := s.findRun(vcn)
+		if err != nil {
+			return nil, err
+		}
+		n := r.vcn + r.length - vcn
+		if left := unit*clusters + needed - vcn; left < n {
+			n = left
+		}
+		if !r.sparse {
+			pos := (r.lcn + vcn - r.vcn) * clusterSize
+			if _, err := s.fs.readFull(data[allocated*clusterSize:(allocated+n)*clusterSize], pos); err != nil {
+				return nil, err
+			}
+			allocated += n
+		}
+		vcn += n
+	}
+	if allocated == 0 || allocated == needed {
+		return data, nil
+	}
+	out := make([]byte, s.unitSize)
+	if err := lznt1Decompress(out, data[:allocated*clusterSize]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,50 @@
+#!/bin/sh
+# mkntfs.sh image label src frag [mkfs.ntfs options]
+#
+# Makes an NTFS filesystem in image, which must already have its size, and copies the tree in src to it through
+# ntfs-3g, keeping hard links, holes and modification times. The files in frag are written to the root of the
+# filesystem 16 KB at a time in turn, so that none of them is in one piece. It makes the compressed directory with the
+# compressed attribute before copying, so that the files in it are compressed, and gives readonly.txt the read-only
+# attribute, as the tests expect.
+set -e
+img=$1
+label=$2
+src=$3
+frag=$4
+shift 4
+
+mkfs.ntfs -F -f -q -L "$label" "$@" "$img"
+
+mkdir mnt
+# ntfs-3g stays in the foreground, so that it has finished writing the image once it exits after unmounting
+ntfs-3g -o no_detach,compression "$img" mnt &
+pid=$!
+trap 'if mountpoint -q mnt; then umount mnt; fi' EXIT
+i=0
+until mountpoint -q mnt; do
+	if ! kill -0 $pid 2>/dev/null || [ $i -ge 100 ]; then
+		echo "ntfs-3g did not mount $img"
+		exit 1
+	fi
+	sleep 0.1
+	i=$((i + 1))
+done
+
+# files created in the compressed directory are compressed
+mkdir mnt/compressed
+setfattr -h -n system.ntfs_attrib_be -v 0x00000810 mnt/compressed
+cp -R --preserve=links,timestamps "$src"/. mnt/
+# the files in frag are all the same size
+set -- "$frag"/*
+chunks=$(($(stat -c %s "$1") / 16384))
+i=0
+while [ $i -lt $chunks ]; do
+	for f in "$@"; do
+		dd if="$f" of=mnt/"$(basename "$f")" bs=16k skip=$i seek=$i count=1 conv=notrunc,fsync status=none
+	done
+	i=$((i + 1))
+done
+setfattr -h -n system.ntfs_attrib_be -v 0x00000021 mnt/readonly.txt
+
+umount mnt
+wait $pid
@@ -0,0 +1,53 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// KB represents one KB
+	KB int64 = 1024
+	// MB represents one MB
+	MB int64 = 1024 * KB
+	// GB represents one GB
+	GB int64 = 1024 * MB
+)
+
+// ntfsEpochOffset is the number of 100ns intervals from 1601-01-01, where NTFS times start, to the Unix epoch
+const ntfsEpochOffset = 116444736000000000
+
+// ntfsTime converts a time in 100ns intervals since 1601-01-01 UTC to a time.Time
+func ntfsTime(t uint64) time.Time {
+	since := int64(t) - ntfsEpochOffset
+	return time.Unix(since/1e7, since%1e7*100).UTC()
+}
+
+// decodeUTF16 decodes a little-endian UTF-16 string of n characters from the start of b
+func decodeUTF16(b []byte, n int) string {
+	chars := make([]uint16, n)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+func universalizePath(p string) string {
+	// globalize the separator
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+func splitPath(p string) []string {
+	ps := universalizePath(p)
+	parts := strings.Split(ps, "/")
+	// eliminate empty parts
+	ret := make([]string, 0)
+	for _, sub := range parts {
+		if sub != "" && sub != "." {
+			ret = append(ret, sub)
+		}
+	}
+	return ret
+}
@@ -224,7 +224,7 @@ func testGetFilesystemRoot() []*directoryEntry {
 		{false, "goodlink", 0, modTime, 0o777, nil, FileStat{0, 0, map[string]string{}}},
 		{false, "hardlink", 7, modTime, 0o644, nil, FileStat{1, 2, map[string]string{}}},
 		{false, "README.md", 7, modTime, 0o644, nil, FileStat{1, 2, map[string]string{}}},
-		{false, "attrfile", 5, modTime, 0o644, nil, FileStat{0, 0, map[string]string{"abc": "def", "myattr": "hello"}}},
+		{false, "attrfile", 5, modTime, 0o644, nil, FileStat{0, 0, map[string]string{"user.abc": "def", "user.myattr": "hello"}}},
 	}
 }
 
@@ -110,6 +110,20 @@ func (d *directoryEntry) Mode() os.FileMode {
 	return d.mode
 }
 
+// symlinkTarget returns the target of the entry if it is a symlink
+func (d *directoryEntry) symlinkTarget() (string, bool) {
+	if d.inode == nil {
+		return "", false
+	}
+	switch body := d.inode.getBody().(type) {
+	case *basicSymlink:
+		return body.target, true
+	case *extendedSymlink:
+		return body.target, true
+	}
+	return "", false
+}
+
 // Sys interface{}   // underlying data source (can return nil)
 func (d *directoryEntry) Sys() interface{} {
 	return d.sys
@@ -42,70 +42,60 @@ func (fl *File) Read(b []byte) (int, error) {
 	//      e.g. if starting block is at position 10245, then we want blocks 27,28,29 from the disk
 	// 5- read in and uncompress the necessary blocks
 	fs := fl.filesystem
-	size := int(fl.size()) - int(fl.offset)
+	size := fl.size()
+	remaining := size - fl.offset
 	location := int64(fl.startBlock)
-	maxRead := size
 
 	// if there is nothing left to read, just return EOF
-	if size <= 0 {
+	if remaining <= 0 {
 		return 0, io.EOF
 	}
 
 	// we stop when we hit the lesser of
 	//   1- len(b)
 	//   2- file end
-	if len(b) < maxRead {
-		maxRead = len(b)
-	}
-
-	// just read the requested number of bytes and change our offset
-	// figure out which block number has the bytes we are looking for
-	startBlock := int(fl.offset / fs.blocksize)
-	endBlock := int((fl.offset + int64(maxRead)) / fs.blocksize)
-
-	// do we end in fragment territory?
-	fragments := false
-	if endBlock >= len(fl.blockSizes) {
-		fragments = true
-		endBlock--
+	maxRead := len(b)
+	if remaining < int64(maxRead) {
+		maxRead = int(remaining)
 	}
 
+	// read the blocks that hold the bytes from our offset, each from wherever in it we are
 	read := 0
-	offset := fl.offset
-	// we need to cycle through all of the blocks to find where the desired one starts
 	for i, block := range fl.blockSizes {
-		if i > endBlock || read > maxRead {
+		if read >= maxRead {
 			break
 		}
+		blockStart := int64(i) * fs.blocksize
+		pos := fl.offset + int64(read)
 		// if we are in the range of desired ones, read it in
-		if i >= startBlock {
+		if pos < blockStart+fs.blocksize {
 			input, err := fs.readBlock(location, block.compressed, block.size)
 			if err != nil {
 				return read, fmt.Errorf("error reading data block %d from squashfs: %v", i, err)
 			}
-			// we do not need to limit it to the remaining space of b, since copy() only will copy
-			//   to what space it has in b
-			copy(b[read:], input[offset:])
-			read += len(input)
-			fl.offset += int64(read)
-			offset = 0
+			if pos-blockStart < int64(len(input)) {
+				read += copy(b[read:maxRead], input[pos-blockStart:])
+			}
 		}
 		location += int64(block.size)
 	}
-	// did we have a fragment to read?
-	if fragments {
-		input, err := fs.readFragment(fl.fragmentBlockIndex, fl.fragmentOffset, fl.size()%fs.blocksize)
+	// anything left after the blocks is in the fragment
+	if read < maxRead {
+		input, err := fs.readFragment(fl.fragmentBlockIndex, fl.fragmentOffset, size%fs.blocksize)
 		if err != nil {
 			return read, fmt.Errorf("error reading fragment block %d from squashfs: %v", fl.fragmentBlockIndex, err)
 		}
-		copy(b[read:], input)
+		fragmentStart := int64(len(fl.blockSizes)) * fs.blocksize
+		if pos := fl.offset + int64(read); pos-fragmentStart < int64(len(input)) {
+			read += copy(b[read:maxRead], input[pos-fragmentStart:])
+		}
 	}
-	fl.offset += int64(maxRead)
+	fl.offset += int64(read)
 	var retErr error
-	if fl.offset >= int64(size) {
+	if fl.offset >= size {
 		retErr = io.EOF
 	}
-	return maxRead, retErr
+	return read, retErr
 }
 
 // Write writes len(b) bytes to the File.
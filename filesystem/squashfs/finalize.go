@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -99,6 +100,15 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if err != nil {
 		return fmt.Errorf("error walking tree: %v", err)
 	}
+	// xattrs set with Setxattr replace those read from the workspace
+	for _, e := range fileList {
+		if m, ok := fs.stagedXattrs[path.Join("/", e.path)]; ok {
+			e.xattrs = m
+		}
+		if !options.Xattrs {
+			e.xattrs = nil
+		}
+	}
 
 	// location holds where we are writing in our file
 	var (
@@ -189,7 +199,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	// build up a table of uids/gids we can store later
 	idtable := map[uint32]uint16{}
 	// get the inodes in order as a slice
-	if err := createInodes(fileList, idtable, options); err != nil {
+	if err := createInodes(fileList, fs.workspace, idtable, options); err != nil {
 		return fmt.Errorf("error creating file inodes: %v", err)
 	}
 
@@ -351,6 +361,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	// finish by setting as finalized
+	fs.superblock = sb
 	fs.workspace = ""
 	return nil
 }
@@ -448,13 +459,13 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 		default:
 			fType = fileRegular
 		}
-		xattrNames, err := xattr.List(fp)
+		xattrNames, err := xattr.LList(fp)
 		if err != nil {
 			return fmt.Errorf("unable to list xattrs for %s: %v", fp, err)
 		}
 		xattrs := map[string]string{}
 		for _, name := range xattrNames {
-			val, err := xattr.Get(fp, name)
+			val, err := xattr.LGet(fp, name)
 			if err != nil {
 				return fmt.Errorf("unable to get xattr %s for %s: %v", name, fp, err)
 			}
@@ -933,20 +944,35 @@ func writeIDTable(idtable map[uint32]uint16, f util.File, compressor Compressor,
 }
 
 // writeXattrs write the xattrs and its lookup table at the given location.
+//
+// The key-value pairs are written first, as metadata blocks. Then comes the lookup table, also as
+// metadata blocks, with one entry per map giving a reference to where its pairs start, how many
+// there are and their size. Last is the xattr ID table, which gives where the pairs start, how many
+// lookup entries there are, and where each lookup table block is. The location of the xattr ID table
+// is what goes in the superblock.
 func writeXattrs(xattrs []map[string]string, f util.File, compressor Compressor, location int64) (xattrsWritten int, finalLocation uint64, err error) {
 	var (
 		maxSize     = int(metadataBlockSize)
-		offset      int
 		lookupTable []byte
 		buf         []byte
+		// dataWritten is the size of the key-value metadata blocks written so far, which is the
+		// position of the block currently in buf
+		dataWritten int
+		dataStart   = location
 	)
 
 	// each entry in the xattrs slice is a unique key-value map. It may be referenced by one or more inodes.
 	// first convert them to key-value written pairs, and save where they are
 	for _, m := range xattrs {
-		// process one xattr key-value map
+		// process one xattr key-value map, in a consistent order
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
 		var single []byte
-		for k, v := range m {
+		for _, k := range keys {
+			v := m[k]
 			// convert it to the proper type
 			// the entry
 			prefix, name, err := xAttrKeyConvert(k)
@@ -955,7 +981,7 @@ func writeXattrs(xattrs []map[string]string, f util.File, compressor Compressor,
 			}
 			b := make([]byte, 4)
 			binary.LittleEndian.PutUint16(b[0:2], prefix)
-			binary.LittleEndian.PutUint16(b[2:4], uint16(len(k)))
+			binary.LittleEndian.PutUint16(b[2:4], uint16(len(name)))
 			b = append(b, []byte(name)...)
 			single = append(single, b...)
 
@@ -966,10 +992,8 @@ func writeXattrs(xattrs []map[string]string, f util.File, compressor Compressor,
 		}
 		// add the index
 		b := make([]byte, 16)
-		// bits 16:48 (uint32) hold the block position
-		binary.LittleEndian.PutUint32(b[2:6], uint32(xattrsWritten))
-		// bits 48:64 (uint16) hold the offset in the uncompressed block
-		binary.LittleEndian.PutUint16(b[6:8], uint16(offset))
+		// bits 0:16 hold the offset in the uncompressed block, bits 16:64 the position of the block
+		binary.LittleEndian.PutUint64(b[0:8], uint64(dataWritten)<<16|uint64(len(buf)))
 		// bytes 8:12 (uint32) hold the number of pairs
 		binary.LittleEndian.PutUint32(b[8:12], uint32(len(m)))
 		// bytes 12:16 (uint32) hold the size of the entire map for this inode
@@ -977,19 +1001,18 @@ func writeXattrs(xattrs []map[string]string, f util.File, compressor Compressor,
 
 		// add the lookupTable bytes
 		lookupTable = append(lookupTable, b...)
-		// add the actual metadata bytes
+		// add the actual metadata bytes, writing out every full block, so the next map always
+		// starts in the block in buf
 		buf = append(buf, single...)
-		// the offset is moved forward
-		offset += len(single)
-		if len(buf) > maxSize {
+		for len(buf) >= maxSize {
 			written, err := writeMetadataBlock(buf[:maxSize], f, compressor, location)
 			if err != nil {
 				return xattrsWritten, 0, err
 			}
 			// count all we have written
 			xattrsWritten += written
+			dataWritten += written
 			buf = buf[maxSize:]
-			offset -= maxSize
 			location += int64(written)
 		}
 	}
@@ -1008,21 +1031,12 @@ func writeXattrs(xattrs []map[string]string, f util.File, compressor Compressor,
 	var indexEntries []uint64
 
 	// write the lookupTable - this too is stored as metadata blocks
-	var i int
-	for i = 0; i < len(lookupTable); i += maxSize {
-		written, err := writeMetadataBlock(lookupTable[i*maxSize:i*maxSize+maxSize], f, compressor, location)
-		if err != nil {
-			return xattrsWritten, 0, err
+	for i := 0; i < len(lookupTable); i += maxSize {
+		end := i + maxSize
+		if end > len(lookupTable) {
+			end = len(lookupTable)
 		}
-		indexEntries = append(indexEntries, uint64(location))
-		// count all we have written
-		xattrsWritten += written
-		location += int64(written)
-	}
-	// was there any left?
-	remainder := len(lookupTable) % maxSize
-	if remainder > 0 {
-		written, err := writeMetadataBlock(lookupTable[remainder:], f, compressor, location)
+		written, err := writeMetadataBlock(lookupTable[i:end], f, compressor, location)
 		if err != nil {
 			return xattrsWritten, 0, err
 		}
@@ -1032,9 +1046,9 @@ func writeXattrs(xattrs []map[string]string, f util.File, compressor Compressor,
 		location += int64(written)
 	}
 	// finally, we need the ID table
-	b := make([]byte, 16+8*len(indexEntries))
-	binary.LittleEndian.PutUint64(b[0:8], uint64(location))
-	binary.LittleEndian.PutUint32(b[8:12], uint32(len(lookupTable)))
+	b := make([]byte, 16, 16+8*len(indexEntries))
+	binary.LittleEndian.PutUint64(b[0:8], uint64(dataStart))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(xattrs)))
 	for _, e := range indexEntries {
 		b2 := make([]byte, 8)
 		binary.LittleEndian.PutUint64(b2, e)
@@ -1071,7 +1085,7 @@ func xAttrKeyConvert(key string) (prefixID uint16, prefix string, err error) {
 }
 
 // createInodes create an inode of appropriate type for each file, and attach it to the finalizeFileInfo
-func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, options FinalizeOptions) error {
+func createInodes(fileList []*finalizeFileInfo, ws string, idtable map[uint32]uint16, options FinalizeOptions) error {
 	// get the inodes
 	var inodeIndex uint32 = 1
 
@@ -1130,7 +1144,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				- it has extended attributes
 				- it has hard links
 			*/
-			target, err := os.Readlink(e.path)
+			target, err := os.Readlink(path.Join(ws, e.path))
 			if err != nil {
 				return fmt.Errorf("unable to read target for symlink at %s: %v", e.path, err)
 			}
@@ -1174,7 +1188,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				inodeT = inodeBasicDirectory
 			}
 		case fileBlock:
-			major, minor, err := getDeviceNumbers(e.path)
+			major, minor, err := getDeviceNumbers(path.Join(ws, e.path))
 			if err != nil {
 				return fmt.Errorf("unable to read major/minor device numbers for block device at %s: %v", e.path, err)
 			}
@@ -1199,7 +1213,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				inodeT = inodeBasicBlock
 			}
 		case fileChar:
-			major, minor, err := getDeviceNumbers(e.path)
+			major, minor, err := getDeviceNumbers(path.Join(ws, e.path))
 			if err != nil {
 				return fmt.Errorf("unable to read major/minor device numbers for char device at %s: %v", e.path, err)
 			}
@@ -544,6 +544,28 @@ func parseExtendedFile(b []byte, blocksize int) (*extendedFile, int, error) {
 	return d, extra, nil
 }
 
+// inodeMode returns the os.FileMode for an inode with the given header mode and type. Only the permission
+// bits are kept in the header, so the type comes from the inode type.
+func inodeMode(mode os.FileMode, iType inodeType) os.FileMode {
+	mode &= os.ModePerm
+	//nolint:exhaustive // regular files have no type bits
+	switch iType {
+	case inodeBasicDirectory, inodeExtendedDirectory:
+		mode |= os.ModeDir
+	case inodeBasicSymlink, inodeExtendedSymlink:
+		mode |= os.ModeSymlink
+	case inodeBasicBlock, inodeExtendedBlock:
+		mode |= os.ModeDevice
+	case inodeBasicChar, inodeExtendedChar:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case inodeBasicFifo, inodeExtendedFifo:
+		mode |= os.ModeNamedPipe
+	case inodeBasicSocket, inodeExtendedSocket:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
 // basicSymlink
 type basicSymlink struct {
 	links  uint32
@@ -640,11 +662,12 @@ func parseExtendedSymlink(b []byte) (*extendedSymlink, int, error) {
 	s := &extendedSymlink{
 		links: binary.LittleEndian.Uint32(b[0:4]),
 	}
-	// account for the synlink target, plus 4 bytes for the xattr index after it
-	extra = int(binary.LittleEndian.Uint32(b[4:8])) + 4
-	if len(b[target:]) > extra {
-		s.target = string(b[8 : 8+extra])
-		s.xAttrIndex = binary.LittleEndian.Uint32(b[8+extra : 8+extra+4])
+	// account for the symlink target, plus 4 bytes for the xattr index after it
+	targetSize := int(binary.LittleEndian.Uint32(b[4:8]))
+	extra = targetSize + 4
+	if len(b[target:]) >= extra {
+		s.target = string(b[8 : 8+targetSize])
+		s.xAttrIndex = binary.LittleEndian.Uint32(b[8+targetSize : 8+targetSize+4])
 		extra = 0
 	}
 	return s, extra, nil
@@ -382,11 +382,28 @@ func TestBasicSymlink(t *testing.T) {
 }
 
 func TestExtendedSymlink(t *testing.T) {
-	// when we have more data with which to work
-
-	// func (i extendedSymlink) toBytes() []byte {
-	// func (i extendedSymlink) size() int64 {
-	// func parseExtendedSymlink(b []byte) (*extendedSymlink, error) {
+	s := &extendedSymlink{links: 1, target: "../a/b", xAttrIndex: 7}
+	b := s.toBytes()
+	tests := []struct {
+		b     []byte
+		sym   *extendedSymlink
+		extra int
+	}{
+		{b, s, 0},
+		// not enough bytes for the target and xattr index
+		{b[:12], &extendedSymlink{links: 1}, 10},
+	}
+	for i, tt := range tests {
+		sym, extra, err := parseExtendedSymlink(tt.b)
+		switch {
+		case err != nil:
+			t.Errorf("%d: unexpected error: %v", i, err)
+		case extra != tt.extra:
+			t.Errorf("%d: extra %d instead of %d", i, extra, tt.extra)
+		case *sym != *tt.sym:
+			t.Errorf("%d: mismatched results, actual %#v expected %#v", i, *sym, *tt.sym)
+		}
+	}
 }
 
 func TestBasicDevice(t *testing.T) {
@@ -7,9 +7,13 @@ import (
 	"math"
 	"os"
 	"path"
+	"sort"
+	"strings"
 
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/internal/workspace"
 	"github.com/diskfs/go-diskfs/util"
+	"github.com/pkg/xattr"
 )
 
 const (
@@ -17,6 +21,8 @@ const (
 	metadataBlockSize = 8 * KB
 	minBlocksize      = 4 * KB
 	maxBlocksize      = 1 * MB
+	// maxSymlinkHops is how many symlinks Stat follows before giving up, as Linux does
+	maxSymlinkHops = 40
 )
 
 // FileSystem implements the FileSystem interface
@@ -32,6 +38,8 @@ type FileSystem struct {
 	uidsGids   []uint32
 	xattrs     *xAttrTable
 	rootDir    inode
+	// stagedXattrs holds the xattrs set in the workspace, by path, which replace any the files there have
+	stagedXattrs map[string]map[string]string
 }
 
 // Equal compare if two filesystems are equal
@@ -47,7 +55,27 @@ func (fs *FileSystem) Label() string {
 }
 
 func (fs *FileSystem) SetLabel(string) error {
-	return fmt.Errorf("SquashFS filesystem is read-only")
+	return fmt.Errorf("cannot set label on SquashFS filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Statfs get the capacity of the filesystem, from its superblock. As it is read-only, it has no free space.
+//
+// Before it is finalized, it has no size of its own, so TotalBytes is 0, and UsedBytes is the uncompressed
+// size of the files in the workspace, each rounded up to a whole block, which is usually more than the
+// finalized size.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	if fs.workspace != "" {
+		return workspace.Statfs(fs.workspace, fs.blocksize)
+	}
+	if fs.superblock == nil {
+		return filesystem.Statfs{}, fmt.Errorf("filesystem has no superblock")
+	}
+	return filesystem.Statfs{
+		BlockSize:  int64(fs.superblock.blocksize),
+		TotalBytes: int64(fs.superblock.size),
+		UsedBytes:  int64(fs.superblock.size),
+		Files:      uint64(fs.superblock.inodes),
+	}, nil
 }
 
 // Workspace get the workspace path
@@ -208,7 +236,7 @@ func (fs *FileSystem) Type() filesystem.Type {
 // if readonly and not in workspace, will return an error
 func (fs *FileSystem) Mkdir(p string) error {
 	if fs.workspace == "" {
-		return fmt.Errorf("cannot write to read-only filesystem")
+		return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
 	}
 	err := os.MkdirAll(path.Join(fs.workspace, p), 0o755)
 	if err != nil {
@@ -278,7 +306,7 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
 	if fs.workspace == "" {
 		if writeMode {
-			return nil, fmt.Errorf("cannot write to read-only filesystem")
+			return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
 		}
 
 		// get the directory entries
@@ -342,6 +370,284 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	return f, nil
 }
 
+// Stat returns the FileInfo for a file or directory, from the workspace if the filesystem has not been finalized.
+// If p is a symlink, Stat returns the FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Stat(fs.workspace, p)
+	}
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := de.symlinkTarget()
+		if !ok {
+			return de, nil
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, fmt.Errorf("too many levels of symlinks at %s", p)
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+// It reads from the workspace if the filesystem has not been finalized.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Lstat(fs.workspace, p)
+	}
+	return fs.lstat(p)
+}
+
+// Readlink returns the target of a symlink, from the workspace if the filesystem has not been finalized.
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	if fs.workspace != "" {
+		return workspace.Readlink(fs.workspace, p)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	target, ok := de.symlinkTarget()
+	if !ok {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	return target, nil
+}
+
+// Symlink creates newname as a symlink to oldname in the workspace. Once the filesystem is finalized, it is
+// read-only and Symlink returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return workspace.Symlink(fs.workspace, oldname, newname)
+}
+
+// Getxattr returns the value of the named extended attribute of p, from the workspace if the filesystem has not
+// been finalized. Returns an error wrapping filesystem.ErrXattrNotExist if p does not have it.
+func (fs *FileSystem) Getxattr(p, name string) ([]byte, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	val, ok := xattrs[name]
+	if !ok {
+		return nil, fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	return []byte(val), nil
+}
+
+// Listxattr returns the sorted names of the extended attributes of p, from the workspace if the filesystem
+// has not been finalized
+func (fs *FileSystem) Listxattr(p string) ([]string, error) {
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(xattrs))
+	for k := range xattrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Setxattr sets the named extended attribute of p in the workspace. squashfs only supports names in the
+// user, trusted and security namespaces.
+//
+// Extended attributes are kept with the filesystem rather than set on the workspace, so they need no
+// privileges, and replace any the workspace files have. They are only stored in the finalized filesystem if
+// FinalizeOptions.Xattrs is set. Once the filesystem is finalized, it is read-only and Setxattr returns
+// an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Setxattr(p, name string, value []byte) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot set xattr on %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	if _, _, err := xAttrKeyConvert(name); err != nil {
+		return err
+	}
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return err
+	}
+	staged := make(map[string]string, len(xattrs)+1)
+	for k, v := range xattrs {
+		staged[k] = v
+	}
+	staged[name] = string(value)
+	if fs.stagedXattrs == nil {
+		fs.stagedXattrs = map[string]map[string]string{}
+	}
+	fs.stagedXattrs[path.Join("/", p)] = staged
+	return nil
+}
+
+// Removexattr removes the named extended attribute of p in the workspace. Returns an error wrapping
+// filesystem.ErrXattrNotExist if p does not have it. Once the filesystem is finalized, it is read-only
+// and Removexattr returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Removexattr(p, name string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot remove xattr from %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	xattrs, err := fs.getXattrs(p)
+	if err != nil {
+		return err
+	}
+	if _, ok := xattrs[name]; !ok {
+		return fmt.Errorf("no xattr %s on %s: %w", name, p, filesystem.ErrXattrNotExist)
+	}
+	staged := make(map[string]string, len(xattrs))
+	for k, v := range xattrs {
+		if k != name {
+			staged[k] = v
+		}
+	}
+	if fs.stagedXattrs == nil {
+		fs.stagedXattrs = map[string]map[string]string{}
+	}
+	fs.stagedXattrs[path.Join("/", p)] = staged
+	return nil
+}
+
+// getXattrs returns the xattrs of p: those staged for it or the ones in the workspace before the
+// filesystem is finalized, and the ones stored with it after
+func (fs *FileSystem) getXattrs(p string) (map[string]string, error) {
+	if fs.workspace == "" {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		return de.sys.xattrs, nil
+	}
+	fp := workspace.Path(fs.workspace, p)
+	if _, err := os.Lstat(fp); err != nil {
+		return nil, fmt.Errorf("could not stat %s: %w", p, err)
+	}
+	if staged, ok := fs.stagedXattrs[path.Join("/", p)]; ok {
+		return staged, nil
+	}
+	names, err := xattr.LList(fp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list xattrs for %s: %v", p, err)
+	}
+	xattrs := make(map[string]string, len(names))
+	for _, name := range names {
+		val, err := xattr.LGet(fp, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get xattr %s for %s: %v", name, p, err)
+		}
+		xattrs[name] = string(val)
+	}
+	return xattrs, nil
+}
+
+// moveStagedXattrs moves the staged xattrs of oldpath, and everything under it, to newpath,
+// or drops them if newpath is ""
+func (fs *FileSystem) moveStagedXattrs(oldpath, newpath string) {
+	oldpath = path.Join("/", oldpath)
+	for k, v := range fs.stagedXattrs {
+		if k != oldpath && !strings.HasPrefix(k, oldpath+"/") {
+			continue
+		}
+		delete(fs.stagedXattrs, k)
+		if newpath != "" {
+			fs.stagedXattrs[path.Join("/", newpath, strings.TrimPrefix(k, oldpath))] = v
+		}
+	}
+}
+
+// lstat finds the directory entry for p in a finalized filesystem
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	// if the dir == filename, then it is just /
+	if dir == filename {
+		return fs.rootDirEntry(), nil
+	}
+	entries, err := fs.readDirectory(dir)
+	if err != nil {
+		// nothing can be in a directory that does not exist, or is not a directory at all
+		parent, serr := fs.Stat(dir)
+		switch {
+		case serr != nil:
+			return nil, serr
+		case !parent.IsDir():
+			return nil, fmt.Errorf("target file %s does not exist, as %s is not a directory: %w", p, dir, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("could not read directory entries for %s: %v", dir, err)
+	}
+	for _, e := range entries {
+		if e.Name() == filename {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+}
+
+// Remove removes a file or empty directory from the workspace. Once the filesystem is finalized, it is read-only
+// and Remove returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	if err := workspace.Remove(fs.workspace, p); err != nil {
+		return err
+	}
+	fs.moveStagedXattrs(p, "")
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it, from the workspace. It returns nil if the path
+// does not exist. Once the filesystem is finalized, it is read-only and RemoveAll returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	if err := workspace.RemoveAll(fs.workspace, p); err != nil {
+		return err
+	}
+	fs.moveStagedXattrs(p, "")
+	return nil
+}
+
+// Rename renames or moves a file or directory in the workspace. Once the filesystem is finalized, it is read-only
+// and Rename returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if err := workspace.Rename(fs.workspace, oldpath, newpath); err != nil {
+		return err
+	}
+	fs.moveStagedXattrs(newpath, "")
+	fs.moveStagedXattrs(oldpath, newpath)
+	return nil
+}
+
+// Truncate changes the size of a file in the workspace. Once the filesystem is finalized, it is read-only
+// and Truncate returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return workspace.Truncate(fs.workspace, p, size)
+}
+
+// rootDirEntry returns a directory entry for the root directory, which has no entry of its own
+func (fs *FileSystem) rootDirEntry() *directoryEntry {
+	header := fs.rootDir.getHeader()
+	// there is no way to return an error here, so a corrupt xattr table just leaves the root with none
+	var xattrs map[string]string
+	if index, has := fs.rootDir.getBody().xattrIndex(); has && index != noXattrInodeFlag && fs.xattrs != nil {
+		xattrs, _ = fs.xattrs.find(int(index))
+	}
+	return &directoryEntry{
+		isSubdirectory: true,
+		name:           "/",
+		size:           fs.rootDir.size(),
+		modTime:        header.modTime,
+		mode:           inodeMode(header.mode, fs.rootDir.inodeType()),
+		inode:          fs.rootDir,
+		sys: FileStat{
+			uid:    fs.uidsGids[header.uidIdx],
+			gid:    fs.uidsGids[header.gidIdx],
+			xattrs: xattrs,
+		},
+	}
+}
+
 // readDirectory - read directory entry on squashfs only (not workspace)
 func (fs *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 	// use the root inode to find the location of the root direectory in the table
@@ -433,7 +739,7 @@ func (fs *FileSystem) hydrateDirectoryEntries(entries []*directoryEntryRaw) ([]*
 		body, header := in.getBody(), in.getHeader()
 		xattrIndex, has := body.xattrIndex()
 		xattrs := map[string]string{}
-		if has && xattrIndex != noXattrInodeFlag {
+		if has && xattrIndex != noXattrInodeFlag && fs.xattrs != nil {
 			xattrs, err = fs.xattrs.find(int(xattrIndex))
 			if err != nil {
 				return nil, fmt.Errorf("error reading xattrs for %s: %v", e.name, err)
@@ -444,7 +750,7 @@ func (fs *FileSystem) hydrateDirectoryEntries(entries []*directoryEntryRaw) ([]*
 			name:           e.name,
 			size:           body.size(),
 			modTime:        header.modTime,
-			mode:           header.mode,
+			mode:           inodeMode(header.mode, e.inodeType),
 			inode:          in,
 			sys: FileStat{
 				uid:    fs.uidsGids[header.uidIdx],
@@ -503,6 +809,11 @@ func (fs *FileSystem) getInode(blockOffset uint32, byteOffset uint16, iType inod
 // getDirectory read a single directory, given the block offset, and the offset in the
 // block when uncompressed.
 func (fs *FileSystem) getDirectory(blockOffset uint32, byteOffset uint16, size int) (*directory, error) {
+	// the size of a directory counts 3 bytes more than its listing, so an empty directory has no
+	// listing, not even a header
+	if size <= 3 {
+		return &directory{entries: []*directoryEntryRaw{}}, nil
+	}
 	// get the block
 	uncompressed, err := readMetadata(fs.file, fs.compressor, int64(fs.superblock.directoryTableStart), blockOffset, byteOffset, size)
 	if err != nil {
@@ -690,18 +1001,26 @@ func readXattrsTable(s *superblock, file util.File, c Compressor) (*xAttrTable,
 	// now load the actual xAttrs data
 	xAttrEnd := binary.LittleEndian.Uint64(b[:8])
 	xAttrData := make([]byte, 0)
+	blocks := map[uint64]int{}
 	for i := xAttrStart; i < xAttrEnd; {
 		uncompressed, size, err = readMetaBlock(file, c, int64(i))
 		if err != nil {
 			return nil, fmt.Errorf("error reading xattr data meta block at position %d: %v", i, err)
 		}
+		// references to xattrs are by the position of the block they start in
+		blocks[i-xAttrStart] = len(xAttrData)
 		xAttrData = append(xAttrData, uncompressed...)
 		i += uint64(size)
 	}
 
 	// now have all of the indexes and metadata loaded
 	// need to pass it the offset of the beginning of the id table from the beginning of the disk
-	return parseXattrsTable(xAttrData, bIndex, s.idTableStart, c)
+	table, err := parseXattrsTable(xAttrData, bIndex, s.idTableStart, c)
+	if err != nil {
+		return nil, err
+	}
+	table.blocks = blocks
+	return table, nil
 }
 
 //nolint:unparam // this does not use offset or compressor yet, but only because we have not yet added support
@@ -1,6 +1,7 @@
 package squashfs_test
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 func getOpenMode(mode int) string {
@@ -338,7 +340,7 @@ func TestSquashfsReadDirXattr(t *testing.T) {
 		f      string
 		xattrs map[string]string
 	}{
-		{"/", "attrfile", map[string]string{"abc": "def", "myattr": "hello"}},
+		{"/", "attrfile", map[string]string{"user.abc": "def", "user.myattr": "hello"}},
 		{"/", "README.md", map[string]string{}},
 	}
 
@@ -382,3 +384,348 @@ func TestSquashfsReadDirXattr(t *testing.T) {
 func TestFinalize(t *testing.T) {
 
 }
+
+func TestSquashfsChange(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := squashfs.Create(m, 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for _, p := range []string{"/dir/file.txt", "/dir/SUB/file.txt", "/GONE/file.txt"} {
+		if err := fs.Mkdir(path.Dir(p)); err != nil {
+			t.Fatalf("error making directory for %s: %v", p, err)
+		}
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write([]byte("hello")); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+
+	// change the workspace before finalizing
+	if err := fs.Truncate("/dir/file.txt", 2); err != nil {
+		t.Errorf("error truncating: %v", err)
+	}
+	if err := fs.Rename("/dir/file.txt", "/dir/MOVED.TXT"); err != nil {
+		t.Errorf("error renaming: %v", err)
+	}
+	if err := fs.Remove("/dir/SUB/file.txt"); err != nil {
+		t.Errorf("error removing: %v", err)
+	}
+	if err := fs.RemoveAll("/GONE"); err != nil {
+		t.Errorf("error removing tree: %v", err)
+	}
+	if _, err := fs.Stat("/GONE"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of removed directory returned %v", err)
+	}
+	info, err := fs.Stat("/dir/MOVED.TXT")
+	if err != nil {
+		t.Fatalf("error on stat: %v", err)
+	}
+	if info.Size() != 2 {
+		t.Errorf("size %d instead of 2 after truncate", info.Size())
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	// the finalized filesystem has the changes, and cannot be changed again
+	fs, err = squashfs.Read(m, m.Size(), 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	tests := []struct {
+		path  string
+		size  int64
+		isDir bool
+		err   error
+	}{
+		{"/", 0, true, nil},
+		{"/dir", 0, true, nil},
+		{"/dir/MOVED.TXT", 2, false, nil},
+		{"/dir/SUB", 0, true, nil},
+		{"/dir/file.txt", 0, false, os.ErrNotExist},
+		{"/dir/SUB/file.txt", 0, false, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		info, err := fs.Stat(tt.path)
+		switch {
+		case tt.err != nil && !errors.Is(err, tt.err):
+			t.Errorf("Stat(%s): error %v instead of %v", tt.path, err, tt.err)
+		case tt.err == nil && err != nil:
+			t.Errorf("Stat(%s): unexpected error: %v", tt.path, err)
+		case err == nil && info.IsDir() != tt.isDir:
+			t.Errorf("Stat(%s): directory %t instead of %t", tt.path, info.IsDir(), tt.isDir)
+		case err == nil && !tt.isDir && info.Size() != tt.size:
+			t.Errorf("Stat(%s): size %d instead of %d", tt.path, info.Size(), tt.size)
+		}
+	}
+	changes := map[string]error{
+		"Remove":    fs.Remove("/dir/MOVED.TXT"),
+		"RemoveAll": fs.RemoveAll("/dir"),
+		"Rename":    fs.Rename("/dir/MOVED.TXT", "/dir/OTHER.TXT"),
+		"Truncate":  fs.Truncate("/dir/MOVED.TXT", 0),
+		"Mkdir":     fs.Mkdir("/NEW"),
+		"SetLabel":  fs.SetLabel("label"),
+	}
+	for name, err := range changes {
+		if !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("%s on finalized filesystem returned %v", name, err)
+		}
+	}
+}
+
+func TestSquashfsChangeOutsideWorkspace(t *testing.T) {
+	fs, err := squashfs.Create(util.NewMemFile(0), 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	defer os.RemoveAll(fs.Workspace())
+	outside, err := os.MkdirTemp(path.Dir(fs.Workspace()), "outside")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.WriteFile(path.Join(outside, "victim.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	f, err := fs.OpenFile("/IN.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	// whether these fail, or change something in the workspace, none may touch anything outside it
+	rel := path.Join("..", path.Base(outside))
+	_ = fs.Truncate(rel+"/victim.txt", 0)
+	_ = fs.Rename(rel+"/victim.txt", "/STOLEN.TXT")
+	_ = fs.Rename("/IN.TXT", rel+"/IN.TXT")
+	_ = fs.Symlink("/IN.TXT", rel+"/LINK")
+	_ = fs.Remove(rel + "/victim.txt")
+	_ = fs.RemoveAll(rel)
+	if err := fs.Remove("/"); err == nil {
+		t.Errorf("removed root directory")
+	}
+	if err := fs.RemoveAll("../.."); err == nil {
+		t.Errorf("removed root directory")
+	}
+
+	b, err := os.ReadFile(path.Join(outside, "victim.txt"))
+	if err != nil || string(b) != "hello" {
+		t.Errorf("file outside the workspace was changed: %q, %v", b, err)
+	}
+	entries, err := os.ReadDir(outside)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory outside the workspace was changed: %v, %v", entries, err)
+	}
+	if _, err := os.Stat(fs.Workspace()); err != nil {
+		t.Errorf("workspace was removed: %v", err)
+	}
+}
+
+func TestSquashfsSymlink(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := squashfs.Create(m, 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	f, err := fs.OpenFile("/dir/file.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	links := map[string]string{
+		"/dir/relative": "file.txt",
+		"/dir/parent":   "../dir/file.txt",
+		"/absolute":     "/dir/file.txt",
+		"/dangling":     "missing",
+	}
+	var sfs filesystem.SymlinkFileSystem = fs
+	for link, target := range links {
+		if err := sfs.Symlink(target, link); err != nil {
+			t.Fatalf("error creating symlink %s: %v", link, err)
+		}
+	}
+	if target, err := fs.Readlink("/absolute"); err != nil || target != links["/absolute"] {
+		t.Errorf("workspace Readlink returned %q, %v", target, err)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	fs, err = squashfs.Read(m, m.Size(), 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for link, target := range links {
+		actual, err := fs.Readlink(link)
+		if err != nil {
+			t.Errorf("Readlink(%s): unexpected error: %v", link, err)
+		} else if actual != target {
+			t.Errorf("Readlink(%s): %q instead of %q", link, actual, target)
+		}
+		info, err := fs.Lstat(link)
+		if err != nil {
+			t.Errorf("Lstat(%s): unexpected error: %v", link, err)
+		} else if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Lstat(%s): mode %v is not a symlink", link, info.Mode())
+		}
+		info, err = fs.Stat(link)
+		switch {
+		case link == "/dangling":
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Stat(%s): error %v instead of %v", link, err, os.ErrNotExist)
+			}
+		case err != nil:
+			t.Errorf("Stat(%s): unexpected error: %v", link, err)
+		case info.Mode()&os.ModeSymlink != 0 || info.Size() != 5:
+			t.Errorf("Stat(%s): did not follow symlink, mode %v size %d", link, info.Mode(), info.Size())
+		}
+	}
+	if _, err := fs.Readlink("/dir/file.txt"); err == nil {
+		t.Errorf("expected error on Readlink of a regular file")
+	}
+	if err := fs.Symlink("/dir", "/new"); !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+		t.Errorf("Symlink on finalized filesystem returned %v", err)
+	}
+}
+
+func TestSquashfsXattrs(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := squashfs.Create(m, 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for _, p := range []string{"/bin/ping", "/bin/plain", "/etc/moved"} {
+		if err := fs.Mkdir(path.Dir(p)); err != nil {
+			t.Fatalf("error making directory for %s: %v", p, err)
+		}
+		if _, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR); err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+	}
+	capability := []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x20, 0x00, 0x00}
+	var xfs filesystem.XattrFileSystem = fs
+	sets := []struct {
+		path, name, value string
+	}{
+		{"/bin/ping", "security.capability", string(capability)},
+		{"/bin/ping", "security.selinux", "system_u:object_r:ping_exec_t:s0"},
+		{"/bin/ping", "user.removed", "x"},
+		{"/bin", "user.dir", "directory"},
+		{"/etc/moved", "trusted.moved", "along"},
+	}
+	for _, s := range sets {
+		if err := xfs.Setxattr(s.path, s.name, []byte(s.value)); err != nil {
+			t.Fatalf("error setting %s on %s: %v", s.name, s.path, err)
+		}
+	}
+	if err := fs.Setxattr("/bin/ping", "system.posix_acl_access", nil); err == nil {
+		t.Errorf("expected error setting xattr in unsupported namespace")
+	}
+	if err := fs.Removexattr("/bin/ping", "user.removed"); err != nil {
+		t.Errorf("error removing xattr: %v", err)
+	}
+	if err := fs.Removexattr("/bin/ping", "user.removed"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+		t.Errorf("removing missing xattr returned %v", err)
+	}
+	if err := fs.Rename("/etc/moved", "/etc/renamed"); err != nil {
+		t.Fatalf("error renaming: %v", err)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{Xattrs: true}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+
+	fs, err = squashfs.Read(m, m.Size(), 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	tests := []struct {
+		path   string
+		xattrs map[string]string
+	}{
+		{"/bin/ping", map[string]string{"security.capability": string(capability), "security.selinux": "system_u:object_r:ping_exec_t:s0"}},
+		{"/bin", map[string]string{"user.dir": "directory"}},
+		{"/etc/renamed", map[string]string{"trusted.moved": "along"}},
+		{"/bin/plain", map[string]string{}},
+	}
+	for _, tt := range tests {
+		names, err := fs.Listxattr(tt.path)
+		if err != nil {
+			t.Errorf("Listxattr(%s): unexpected error: %v", tt.path, err)
+			continue
+		}
+		if len(names) != len(tt.xattrs) {
+			t.Errorf("Listxattr(%s): %v instead of %d names", tt.path, names, len(tt.xattrs))
+		}
+		for name, expected := range tt.xattrs {
+			val, err := fs.Getxattr(tt.path, name)
+			if err != nil {
+				t.Errorf("Getxattr(%s, %s): unexpected error: %v", tt.path, name, err)
+			} else if string(val) != expected {
+				t.Errorf("Getxattr(%s, %s): %q instead of %q", tt.path, name, val, expected)
+			}
+		}
+	}
+	if _, err := fs.Getxattr("/bin/ping", "user.removed"); !errors.Is(err, filesystem.ErrXattrNotExist) {
+		t.Errorf("Getxattr of removed xattr returned %v", err)
+	}
+	if err := fs.Setxattr("/bin/ping", "user.new", nil); !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+		t.Errorf("Setxattr on finalized filesystem returned %v", err)
+	}
+}
+
+func TestSquashfsStatfs(t *testing.T) {
+	m := util.NewMemFile(0)
+	fs, err := squashfs.Create(m, 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	f, err := fs.OpenFile("/dir/file.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 5000)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	stat, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unexpected error on workspace: %v", err)
+	}
+	// the root, the directory and the file, which takes 2 blocks
+	expected := filesystem.Statfs{BlockSize: 4096, UsedBytes: 8192, Files: 3}
+	if stat != expected {
+		t.Errorf("workspace %+v instead of %+v", stat, expected)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+	finalized, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("unexpected error after finalizing: %v", err)
+	}
+	if finalized.BlockSize != 4096 || finalized.Files != 3 || finalized.FreeBytes != 0 ||
+		finalized.TotalBytes <= 0 || finalized.TotalBytes > m.Size() || finalized.UsedBytes != finalized.TotalBytes {
+		t.Errorf("implausible finalized capacity %+v for %d byte image", finalized, m.Size())
+	}
+
+	fs, err = squashfs.Read(m, m.Size(), 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if stat, err := fs.Statfs(); err != nil || stat != finalized {
+		t.Errorf("read %+v, %v instead of %+v", stat, err, finalized)
+	}
+}
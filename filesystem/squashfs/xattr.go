@@ -32,6 +32,40 @@ func parseXAttrIndex(b []byte) (*xAttrIndex, error) {
 type xAttrTable struct {
 	list []*xAttrIndex
 	data []byte
+	// blocks maps the position of each metadata block, relative to the start of the xattr data, to
+	// where its uncompressed contents start in data
+	blocks map[uint64]int
+}
+
+// xAttrPrefixes are the name prefixes, indexed by the type in the low byte of an xattr key
+var xAttrPrefixes = []string{"user.", "trusted.", "security."}
+
+// xAttrValueOutOfLine is set in the type of an xattr key whose value is stored elsewhere, with only
+// a reference to it after the key
+const xAttrValueOutOfLine uint16 = 0x0100
+
+// offset converts a reference to xattr data, with the position of the metadata block in the upper
+// 48 bits and the offset in its uncompressed contents in the lower 16, to an offset in data
+func (x *xAttrTable) offset(ref uint64) (int, error) {
+	block, offset := ref>>16, int(ref&0xffff)
+	start, ok := x.blocks[block]
+	if !ok && block != 0 {
+		return 0, fmt.Errorf("no xattr metadata block at %d", block)
+	}
+	return start + offset, nil
+}
+
+// value reads a single xattr value at ptr, returning it and the number of bytes it used
+func (x *xAttrTable) value(ptr int) (string, int, error) {
+	if ptr < 0 || len(x.data) < ptr+4 {
+		return "", 0, fmt.Errorf("insufficient bytes to read the xattr value at position %d", ptr)
+	}
+	valSize := int(binary.LittleEndian.Uint32(x.data[ptr : ptr+4]))
+	valStart := ptr + 4
+	if len(x.data[valStart:]) < valSize {
+		return "", 0, fmt.Errorf("xattr value has size %d, but only %d bytes available to read at position %d", valSize, len(x.data[valStart:]), ptr)
+	}
+	return string(x.data[valStart : valStart+valSize]), 4 + valSize, nil
 }
 
 func (x *xAttrTable) find(pos int) (map[string]string, error) {
@@ -39,42 +73,52 @@ func (x *xAttrTable) find(pos int) (map[string]string, error) {
 		return nil, fmt.Errorf("position %d is greater than list size %d", pos, len(x.list))
 	}
 	entry := x.list[pos]
-	b := x.data[entry.pos:]
-	count := entry.count
-	ptr := 0
+	ptr, err := x.offset(entry.pos)
+	if err != nil {
+		return nil, err
+	}
 	xattrs := map[string]string{}
-	for i := 0; i < int(count); i++ {
+	for i := 0; i < int(entry.count); i++ {
 		// must be 4 bytes for header
-		if len(b[pos:]) < 4 {
-			return nil, fmt.Errorf("insufficient bytes %d to read the xattr at position %d", len(b[ptr:]), ptr)
+		if ptr > len(x.data) || len(x.data[ptr:]) < 4 {
+			return nil, fmt.Errorf("insufficient bytes to read the xattr at position %d", ptr)
 		}
+		b := x.data[ptr:]
 		// get the type and size
-		//   xType := binary.LittleEndian.Uint16(b[ptr : ptr+2])
-		xSize := int(binary.LittleEndian.Uint16(b[ptr+2 : ptr+4]))
-		nameStart := ptr + 4
-		valHeaderStart := nameStart + xSize
-		valStart := valHeaderStart + 4
+		xType := binary.LittleEndian.Uint16(b[0:2])
+		xSize := int(binary.LittleEndian.Uint16(b[2:4]))
 		// make sure we have enough bytes
-		if len(b[nameStart:]) < xSize {
-			return nil, fmt.Errorf("xattr header has size %d, but only %d bytes available to read at position %d", xSize, len(b[pos+4:]), ptr)
+		if len(b[4:]) < xSize {
+			return nil, fmt.Errorf("xattr header has size %d, but only %d bytes available to read at position %d", xSize, len(b[4:]), ptr)
 		}
 		if xSize < 1 {
 			return nil, fmt.Errorf("no name given for xattr at position %d", ptr)
 		}
-		key := string(b[nameStart : nameStart+xSize])
-		// read the size of the value
-		if len(b[valHeaderStart:]) < 4 {
-			return nil, fmt.Errorf("insufficient bytes %d to read the xattr value at position %d", len(b[valHeaderStart:]), ptr)
+		prefix := int(xType & 0xff)
+		if prefix >= len(xAttrPrefixes) {
+			return nil, fmt.Errorf("unknown xattr type %d at position %d", prefix, ptr)
 		}
-		valSize := int(binary.LittleEndian.Uint32(b[valHeaderStart:valStart]))
-		if len(b[valStart:]) < valSize {
-			return nil, fmt.Errorf("xattr value has size %d, but only %d bytes available to read at position %d", valSize, len(b[valStart:]), ptr)
+		key := xAttrPrefixes[prefix] + string(b[4:4+xSize])
+		ptr += 4 + xSize
+
+		val, n, err := x.value(ptr)
+		if err != nil {
+			return nil, err
+		}
+		ptr += n
+		if xType&xAttrValueOutOfLine != 0 {
+			if len(val) != 8 {
+				return nil, fmt.Errorf("xattr %s has out of line value reference of %d bytes instead of 8", key, len(val))
+			}
+			valPtr, err := x.offset(binary.LittleEndian.Uint64([]byte(val)))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value reference for xattr %s: %v", key, err)
+			}
+			if val, _, err = x.value(valPtr); err != nil {
+				return nil, err
+			}
 		}
-		val := string(b[valStart : valStart+valSize])
 		xattrs[key] = val
-
-		// increment the position pointer
-		ptr += valStart + valSize
 	}
 	return xattrs, nil
 }
@@ -0,0 +1,78 @@
+package udf
+
+import (
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// Bridge writes the UDF structures of an iso9660/UDF bridge image, in which the files of a workspace are found by
+// both filesystems, sharing their data. It is meant for use by the iso9660 package when it finalizes.
+//
+// The UDF partition starts at block 257 and takes up the rest of the image, so that the data of the files, which
+// the other filesystem writes, is in it. The blocks from 257 to DataStart are the file set descriptor, file entries
+// and directories of UDF, which the other filesystem must not use. Its volume descriptors, before block 32 of 2 KB,
+// are left alone.
+type Bridge struct {
+	writer    *volumeWriter
+	fileList  []*finalizeFileInfo
+	dataStart uint32
+}
+
+// NewBridge lays out the UDF structures for the files in workspace, for an image of blocksize blocks in f
+func NewBridge(f util.File, workspace string, blocksize int64, label string, options FinalizeOptions) (*Bridge, error) {
+	if err := validateBlocksize(blocksize); err != nil {
+		return nil, err
+	}
+	w, err := newVolumeWriter(f, 0, blocksize, label, options.Revision)
+	if err != nil {
+		return nil, err
+	}
+	fileList, err := walkTree(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("error walking tree: %v", err)
+	}
+	setOwners(fileList, options)
+	block, err := w.layout(fileList)
+	if err != nil {
+		return nil, err
+	}
+	return &Bridge{
+		writer:    w,
+		fileList:  fileList,
+		dataStart: partitionStart + block,
+	}, nil
+}
+
+// DataStart is the first block after the UDF structures, from where the other filesystem can write
+func (b *Bridge) DataStart() uint32 {
+	return b.dataStart
+}
+
+// Write writes the UDF structures, once the other filesystem has written the data of the files. vrs is the block
+// where the UDF volume recognition sequence goes, after the volume descriptors of the other filesystem, and end is
+// the last block of the image, where the closing anchor goes. location returns the block of the data of a regular
+// file, by its slash-separated path relative to the workspace.
+func (b *Bridge) Write(vrs, end uint32, location func(p string) (uint32, error)) error {
+	for _, e := range b.fileList {
+		if !e.mode.IsRegular() {
+			continue
+		}
+		block, err := location(e.path)
+		if err != nil {
+			return fmt.Errorf("unable to find data of %s: %v", e.path, err)
+		}
+		// an empty file has no extents, wherever the other filesystem says it is
+		if e.size == 0 {
+			continue
+		}
+		if block < b.dataStart || int64(block)+int64(e.entry.blocks) > int64(end) {
+			return fmt.Errorf("data of %s at block %d is outside of the UDF partition", e.path, block)
+		}
+		b.writer.placeData(e, block-partitionStart)
+	}
+	if err := b.writer.writeFiles(b.fileList); err != nil {
+		return err
+	}
+	return b.writer.writeVolume(int64(vrs)*b.writer.blocksize, end, b.fileList)
+}
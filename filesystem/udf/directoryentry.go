@@ -0,0 +1,118 @@
+package udf
+
+import (
+	"os"
+	"time"
+)
+
+// FileStat is the extended data underlying a single file, similar to https://golang.org/pkg/syscall/#Stat_t
+type FileStat struct {
+	uniqueID   uint64
+	links      uint16
+	uid        uint32
+	gid        uint32
+	major      uint32
+	minor      uint32
+	accessTime time.Time
+	createTime time.Time
+}
+
+// UniqueID get the unique id of file, which identifies it on the filesystem
+func (f *FileStat) UniqueID() uint64 {
+	return f.uniqueID
+}
+
+// Nlink get the number of hard links to file
+func (f *FileStat) Nlink() uint16 {
+	return f.links
+}
+
+// UID get uid of file
+func (f *FileStat) UID() uint32 {
+	return f.uid
+}
+
+// GID get gid of file
+func (f *FileStat) GID() uint32 {
+	return f.gid
+}
+
+// Rdev get the major and minor device numbers of a block or character device
+func (f *FileStat) Rdev() (major, minor uint32) {
+	return f.major, f.minor
+}
+
+// AccessTime get the time file was last read
+func (f *FileStat) AccessTime() time.Time {
+	return f.accessTime
+}
+
+// CreationTime get the time file was created, which only an extended file entry keeps, and otherwise is the
+// zero time
+func (f *FileStat) CreationTime() time.Time {
+	return f.createTime
+}
+
+// directoryEntry is a single directory entry
+// it combines information from the file identifier and the file entry it points to
+// also fulfills os.FileInfo
+//
+//	Name() string       // base name of the file
+//	Size() int64        // length in bytes for regular files; system-dependent for others
+//	Mode() FileMode     // file mode bits
+//	ModTime() time.Time // modification time
+//	IsDir() bool        // abbreviation for Mode().IsDir()
+//	Sys() interface{}   // underlying data source (can return nil)
+type directoryEntry struct {
+	name  string
+	entry *fileEntry
+	sys   FileStat
+}
+
+// newDirectoryEntry creates the directory entry for a file entry found by name
+func newDirectoryEntry(name string, fe *fileEntry) *directoryEntry {
+	return &directoryEntry{
+		name:  name,
+		entry: fe,
+		sys: FileStat{
+			uniqueID:   fe.uniqueID,
+			links:      fe.links,
+			uid:        fe.uid,
+			gid:        fe.gid,
+			major:      fe.major,
+			minor:      fe.minor,
+			accessTime: fe.accessTime,
+			createTime: fe.createTime,
+		},
+	}
+}
+
+// Name string       // base name of the file
+func (d *directoryEntry) Name() string {
+	return d.name
+}
+
+// Size int64        // length in bytes for regular files; system-dependent for others
+func (d *directoryEntry) Size() int64 {
+	return int64(d.entry.size)
+}
+
+// IsDir bool        // abbreviation for Mode().IsDir()
+func (d *directoryEntry) IsDir() bool {
+	return d.entry.isDir()
+}
+
+// ModTime time.Time // modification time
+func (d *directoryEntry) ModTime() time.Time {
+	return d.entry.modTime
+}
+
+// Mode FileMode     // file mode bits
+func (d *directoryEntry) Mode() os.FileMode {
+	return fileMode(d.entry.fileType, d.entry.permissions, d.entry.flags)
+}
+
+// Sys interface{}   // underlying data source (can return nil)
+func (d *directoryEntry) Sys() interface{} {
+	return d.sys
+}
@@ -0,0 +1,18 @@
+// Package udf provides support for reading and creating UDF, the Universal Disk Format of DVD and Blu-ray media,
+// which unlike iso9660 can have files larger than 4 GB.
+//
+// It reads UDF revisions 1.02 to 2.60 on a block device or disk image, with physical partitions and the metadata
+// partitions of 2.50 and later, but not the virtual or sparable partitions of write-once and rewritable discs.
+// Files may have their data in their entry or in extents, and entries may be extended file entries.
+//
+// Like iso9660, a new filesystem is built in a workspace directory and written out by Finalize, after which it
+// is read-only. It is written as UDF 2.01 or 1.02, in a single physical partition. An iso9660/UDF bridge image,
+// which has both filesystems sharing the data of the same files, is made by iso9660.Finalize with its UDF option,
+// using Bridge.
+//
+// references:
+//
+//	https://www.ecma-international.org/publications-and-standards/standards/ecma-167/
+//	http://www.osta.org/specs/pdf/udf260.pdf
+//	https://github.com/torvalds/linux/tree/master/fs/udf
+package udf
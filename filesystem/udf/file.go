@@ -0,0 +1,129 @@
+package udf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in a UDF filesystem
+//
+//	it is NOT used when working in a workspace, where we just use the underlying OS
+type File struct {
+	entry      *fileEntry
+	offset     int64
+	filesystem *FileSystem
+	// extents of the data of the file, other than those pointing to more of them, and where each starts in it
+	extents []extent
+	starts  []int64
+}
+
+// newFile creates a File to read the data of a file entry
+func (fs *FileSystem) newFile(fe *fileEntry) *File {
+	fl := &File{
+		entry:      fe,
+		filesystem: fs,
+	}
+	var pos int64
+	for _, e := range fe.extents {
+		if e.kind == extentNext {
+			continue
+		}
+		fl.extents = append(fl.extents, e)
+		fl.starts = append(fl.starts, pos)
+		pos += int64(e.length)
+	}
+	return fl
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	size := int64(fl.entry.size)
+	if fl.offset >= size {
+		return 0, io.EOF
+	}
+	if remaining := size - fl.offset; remaining < int64(len(b)) {
+		b = b[:remaining]
+	}
+	read := 0
+	for read < len(b) {
+		n, err := fl.readAt(b[read:], fl.offset+int64(read))
+		read += n
+		if err != nil {
+			fl.offset += int64(read)
+			return read, err
+		}
+	}
+	fl.offset += int64(read)
+	return read, nil
+}
+
+// readAt reads as much of b from off as is in a single extent, or in the entry itself
+func (fl *File) readAt(b []byte, off int64) (int, error) {
+	if fl.entry.adType() == adEmbedded {
+		return copy(b, fl.entry.data[off:]), nil
+	}
+	i := sort.Search(len(fl.starts), func(i int) bool {
+		return fl.starts[i]+int64(fl.extents[i].length) > off
+	})
+	if i == len(fl.extents) {
+		return 0, fmt.Errorf("no extent holds offset %d", off)
+	}
+	e := fl.extents[i]
+	within := off - fl.starts[i]
+	if remaining := int64(e.length) - within; remaining < int64(len(b)) {
+		b = b[:remaining]
+	}
+	// an extent that is not recorded reads as zeros
+	if e.kind != extentRecorded {
+		for i := range b {
+			b[i] = 0
+		}
+		return len(b), nil
+	}
+	return fl.filesystem.readPartition(b, e.location, within)
+}
+
+// Write writes len(b) bytes to the File.
+//
+//	you cannot write to a finished UDF filesystem, so this returns an error
+func (fl *File) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("cannot write to a read-only UDF filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = int64(fl.entry.size) + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
@@ -0,0 +1,317 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	fileEntryHeaderSize         = 176
+	extendedFileEntryHeaderSize = 216
+	// icbStrategyDirect is the strategy of an ICB that is a single entry, the only one that is read or written
+	icbStrategyDirect uint16 = 4
+
+	// types of allocation descriptors, in the icb tag flags
+	adShort    uint16 = 0
+	adLong     uint16 = 1
+	adExtended uint16 = 2
+	adEmbedded uint16 = 3
+	extADSize         = 20
+
+	// types of extents, in the top 2 bits of the length of an allocation descriptor; those of 1 and 2 are
+	// not recorded, and read as zeros
+	extentRecorded   uint32 = 0
+	extentNext       uint32 = 3
+	extentLengthMask uint32 = 1<<30 - 1
+
+	// extended attributes, the only one of which used is the device specification, with the numbers of a device
+	extendedAttributeHeaderSize        = 24
+	deviceSpecificationSize            = 24
+	eaDeviceSpecification       uint32 = 12
+)
+
+// extent is a run of the data of a file, in blocks of a partition
+type extent struct {
+	kind     uint32
+	length   uint32
+	location lbAddr
+}
+
+// fileEntry is a file entry or extended file entry, which has the attributes of a file and where its data is
+type fileEntry struct {
+	location    lbAddr
+	extended    bool
+	fileType    uint8
+	flags       uint16
+	uid         uint32
+	gid         uint32
+	permissions uint32
+	links       uint16
+	size        uint64
+	// blocks is how many blocks the data of the file takes, other than any inside the entry
+	blocks     uint64
+	accessTime time.Time
+	modTime    time.Time
+	createTime time.Time
+	uniqueID   uint64
+	// major and minor are the device numbers of a block or character device
+	major uint32
+	minor uint32
+	// data is the data of a file whose data is inside its entry
+	data []byte
+	// extents are where the data of a file is, if it is not inside its entry
+	extents []extent
+}
+
+// adType is the type of allocation descriptors of the entry
+func (fe *fileEntry) adType() uint16 {
+	return fe.flags & icbFlagADMask
+}
+
+func (fe *fileEntry) isDir() bool {
+	return fe.fileType == fileTypeDirectory
+}
+
+func (fe *fileEntry) isSymlink() bool {
+	return fe.fileType == fileTypeSymlink
+}
+
+func (fe *fileEntry) isRegular() bool {
+	return fe.fileType == fileTypeRegular
+}
+
+// parseFileEntry parses a file entry or extended file entry in block location. The allocation descriptors of the
+// data of the file are in extents, which end with any pointing to more of them.
+func parseFileEntry(b []byte, location lbAddr) (*fileEntry, error) {
+	t, err := parseTag(b)
+	if err != nil {
+		return nil, err
+	}
+	if t.location != location.block {
+		return nil, fmt.Errorf("file entry says it is at block %d instead of %d", t.location, location.block)
+	}
+	fe := &fileEntry{location: location}
+	header := fileEntryHeaderSize
+	switch t.id {
+	case tagFileEntry:
+	case tagExtendedFileEntry:
+		fe.extended = true
+		header = extendedFileEntryHeaderSize
+	default:
+		return nil, fmt.Errorf("descriptor has tag identifier %d instead of expected %d or %d", t.id, tagFileEntry, tagExtendedFileEntry)
+	}
+	if len(b) < header {
+		return nil, fmt.Errorf("file entry of %d bytes is smaller than its header of %d", len(b), header)
+	}
+	if strategy := binary.LittleEndian.Uint16(b[20:22]); strategy != icbStrategyDirect {
+		return nil, fmt.Errorf("unsupported ICB strategy %d", strategy)
+	}
+	fe.fileType = b[27]
+	fe.flags = binary.LittleEndian.Uint16(b[34:36])
+	fe.uid = binary.LittleEndian.Uint32(b[36:40])
+	fe.gid = binary.LittleEndian.Uint32(b[40:44])
+	fe.permissions = binary.LittleEndian.Uint32(b[44:48])
+	fe.links = binary.LittleEndian.Uint16(b[48:50])
+	fe.size = binary.LittleEndian.Uint64(b[56:64])
+	if fe.extended {
+		fe.blocks = binary.LittleEndian.Uint64(b[72:80])
+		fe.accessTime = parseTimestamp(b[80:92])
+		fe.modTime = parseTimestamp(b[92:104])
+		fe.createTime = parseTimestamp(b[104:116])
+		fe.uniqueID = binary.LittleEndian.Uint64(b[200:208])
+	} else {
+		fe.blocks = binary.LittleEndian.Uint64(b[64:72])
+		fe.accessTime = parseTimestamp(b[72:84])
+		fe.modTime = parseTimestamp(b[84:96])
+		fe.uniqueID = binary.LittleEndian.Uint64(b[160:168])
+	}
+	eaLength := int(binary.LittleEndian.Uint32(b[header-8 : header-4]))
+	adLength := int(binary.LittleEndian.Uint32(b[header-4 : header]))
+	if eaLength < 0 || adLength < 0 || header+eaLength+adLength > len(b) {
+		return nil, fmt.Errorf("%d bytes of extended attributes and %d of allocation descriptors overflow file entry", eaLength, adLength)
+	}
+	if err := fe.parseExtendedAttributes(b[header : header+eaLength]); err != nil {
+		return nil, err
+	}
+	ads := b[header+eaLength : header+eaLength+adLength]
+	if fe.adType() == adEmbedded {
+		if uint64(len(ads)) < fe.size {
+			return nil, fmt.Errorf("file of %d bytes is larger than the %d bytes in its entry", fe.size, len(ads))
+		}
+		fe.data = ads[:fe.size]
+		return fe, nil
+	}
+	if fe.extents, err = parseAllocationDescriptors(ads, fe.adType(), location.partition); err != nil {
+		return nil, err
+	}
+	return fe, nil
+}
+
+// parseAllocationDescriptors parses the allocation descriptors of type adType, until the first of zero length.
+// Short ones are in partition.
+func parseAllocationDescriptors(b []byte, adType, partition uint16) ([]extent, error) {
+	var (
+		size int
+		ads  []extent
+	)
+	switch adType {
+	case adShort:
+		size = shortADSize
+	case adLong:
+		size = longADSize
+	case adExtended:
+		size = extADSize
+	default:
+		return nil, fmt.Errorf("unknown type of allocation descriptors %d", adType)
+	}
+	for pos := 0; pos+size <= len(b); pos += size {
+		length := binary.LittleEndian.Uint32(b[pos : pos+4])
+		if length == 0 {
+			break
+		}
+		e := extent{kind: length >> 30, length: length & extentLengthMask}
+		switch adType {
+		case adShort:
+			e.location = lbAddr{block: binary.LittleEndian.Uint32(b[pos+4 : pos+8]), partition: partition}
+		case adLong:
+			e.location = parseLongAD(b[pos : pos+longADSize]).location
+		case adExtended:
+			e.location = lbAddr{
+				block:     binary.LittleEndian.Uint32(b[pos+12 : pos+16]),
+				partition: binary.LittleEndian.Uint16(b[pos+16 : pos+18]),
+			}
+		}
+		ads = append(ads, e)
+		// the extent with more of them is the last one
+		if e.kind == extentNext {
+			break
+		}
+	}
+	return ads, nil
+}
+
+// parseExtendedAttributes finds the device numbers of a device in its extended attributes, if it has them
+func (fe *fileEntry) parseExtendedAttributes(b []byte) error {
+	if len(b) < extendedAttributeHeaderSize {
+		return nil
+	}
+	if _, err := checkTag(b, tagExtendedAttributeHeader, fe.location.block); err != nil {
+		return fmt.Errorf("invalid extended attribute header: %v", err)
+	}
+	// the attributes defined by ECMA-167 come first, before those of the implementation
+	end := int(binary.LittleEndian.Uint32(b[16:20]))
+	if end > len(b) {
+		end = len(b)
+	}
+	for pos := extendedAttributeHeaderSize; pos+12 <= end; {
+		kind := binary.LittleEndian.Uint32(b[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(b[pos+8 : pos+12]))
+		if length < 12 || pos+length > end {
+			return fmt.Errorf("extended attribute of type %d at %d has invalid length %d", kind, pos, length)
+		}
+		if kind == eaDeviceSpecification && length >= deviceSpecificationSize {
+			fe.major = binary.LittleEndian.Uint32(b[pos+16 : pos+20])
+			fe.minor = binary.LittleEndian.Uint32(b[pos+20 : pos+24])
+		}
+		pos += length
+	}
+	return nil
+}
+
+// extendedAttributesBytes are the extended attributes of the entry, which a device has for its numbers
+func (fe *fileEntry) extendedAttributesBytes(version uint16) []byte {
+	if fe.fileType != fileTypeBlockDevice && fe.fileType != fileTypeCharDevice {
+		return nil
+	}
+	b := make([]byte, extendedAttributeHeaderSize+deviceSpecificationSize)
+	// no implementation or application attributes
+	binary.LittleEndian.PutUint32(b[16:20], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[20:24], uint32(len(b)))
+	setTag(b[:extendedAttributeHeaderSize], tagExtendedAttributeHeader, version, fe.location.block)
+	d := b[extendedAttributeHeaderSize:]
+	binary.LittleEndian.PutUint32(d[0:4], eaDeviceSpecification)
+	d[4] = 1
+	binary.LittleEndian.PutUint32(d[8:12], deviceSpecificationSize)
+	binary.LittleEndian.PutUint32(d[16:20], fe.major)
+	binary.LittleEndian.PutUint32(d[20:24], fe.minor)
+	return b
+}
+
+// toBytes encodes the entry, with its data inside it or the short allocation descriptors of its extents
+func (fe *fileEntry) toBytes(version uint16) []byte {
+	header := fileEntryHeaderSize
+	id := tagFileEntry
+	if fe.extended {
+		header = extendedFileEntryHeaderSize
+		id = tagExtendedFileEntry
+	}
+	ea := fe.extendedAttributesBytes(version)
+	ads := fe.data
+	if fe.adType() != adEmbedded {
+		ads = make([]byte, 0, len(fe.extents)*shortADSize)
+		for _, e := range fe.extents {
+			ad := make([]byte, shortADSize)
+			binary.LittleEndian.PutUint32(ad[0:4], e.kind<<30|e.length)
+			binary.LittleEndian.PutUint32(ad[4:8], e.location.block)
+			ads = append(ads, ad...)
+		}
+	}
+	b := make([]byte, header+len(ea)+len(ads))
+	// icb tag
+	binary.LittleEndian.PutUint16(b[20:22], icbStrategyDirect)
+	binary.LittleEndian.PutUint16(b[24:26], 1)
+	b[27] = fe.fileType
+	binary.LittleEndian.PutUint16(b[34:36], fe.flags)
+	binary.LittleEndian.PutUint32(b[36:40], fe.uid)
+	binary.LittleEndian.PutUint32(b[40:44], fe.gid)
+	binary.LittleEndian.PutUint32(b[44:48], fe.permissions)
+	binary.LittleEndian.PutUint16(b[48:50], fe.links)
+	binary.LittleEndian.PutUint64(b[56:64], fe.size)
+	if fe.extended {
+		binary.LittleEndian.PutUint64(b[64:72], fe.size)
+		binary.LittleEndian.PutUint64(b[72:80], fe.blocks)
+		copy(b[80:92], timestampBytes(fe.accessTime))
+		copy(b[92:104], timestampBytes(fe.modTime))
+		copy(b[104:116], timestampBytes(fe.createTime))
+		copy(b[116:128], timestampBytes(fe.modTime))
+		binary.LittleEndian.PutUint32(b[128:132], 1)
+		copy(b[168:200], regidBytes(implementationDiskfs, nil))
+		binary.LittleEndian.PutUint64(b[200:208], fe.uniqueID)
+	} else {
+		binary.LittleEndian.PutUint64(b[64:72], fe.blocks)
+		copy(b[72:84], timestampBytes(fe.accessTime))
+		copy(b[84:96], timestampBytes(fe.modTime))
+		copy(b[96:108], timestampBytes(fe.modTime))
+		binary.LittleEndian.PutUint32(b[108:112], 1)
+		copy(b[128:160], regidBytes(implementationDiskfs, nil))
+		binary.LittleEndian.PutUint64(b[160:168], fe.uniqueID)
+	}
+	binary.LittleEndian.PutUint32(b[header-8:header-4], uint32(len(ea)))
+	binary.LittleEndian.PutUint32(b[header-4:header], uint32(len(ads)))
+	copy(b[header:], ea)
+	copy(b[header+len(ea):], ads)
+	setTag(b, id, version, fe.location.block)
+	return b
+}
+
+// parseAllocationExtent parses an allocation extent descriptor in block location, which holds more of the
+// allocation descriptors of a file
+func parseAllocationExtent(b []byte, location lbAddr, adType uint16) ([]extent, error) {
+	if _, err := checkTag(b, tagAllocationExtentDescriptor, location.block); err != nil {
+		return nil, fmt.Errorf("invalid allocation extent descriptor: %v", err)
+	}
+	length := int(binary.LittleEndian.Uint32(b[20:24]))
+	if 24+length > len(b) {
+		return nil, fmt.Errorf("%d bytes of allocation descriptors overflow allocation extent descriptor", length)
+	}
+	return parseAllocationDescriptors(b[24:24+length], adType, location.partition)
+}
+
+// parseIndirectEntry parses an indirect entry, which points to the ICB that replaces it
+func parseIndirectEntry(b []byte, location lbAddr) (lbAddr, error) {
+	if _, err := checkTag(b, tagIndirectEntry, location.block); err != nil {
+		return lbAddr{}, err
+	}
+	return parseLongAD(b[36:52]).location, nil
+}
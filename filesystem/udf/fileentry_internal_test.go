@@ -0,0 +1,119 @@
+package udf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testFileEntry(extended bool) *fileEntry {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 10000, time.UTC)
+	fe := &fileEntry{
+		location:    lbAddr{block: 17},
+		extended:    extended,
+		fileType:    fileTypeRegular,
+		uid:         1000,
+		gid:         100,
+		permissions: 0x14a5,
+		links:       1,
+		size:        10000,
+		blocks:      5,
+		accessTime:  now,
+		modTime:     now.Add(-time.Hour),
+		uniqueID:    1234,
+		extents: []extent{
+			{kind: extentRecorded, length: 6144, location: lbAddr{block: 100}},
+			{kind: extentRecorded, length: 3856, location: lbAddr{block: 300}},
+		},
+	}
+	if extended {
+		fe.createTime = now.Add(-2 * time.Hour)
+	}
+	return fe
+}
+
+func TestFileEntryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry func() *fileEntry
+	}{
+		{"file entry", func() *fileEntry { return testFileEntry(false) }},
+		{"extended file entry", func() *fileEntry { return testFileEntry(true) }},
+		{"embedded symlink", func() *fileEntry {
+			fe := testFileEntry(true)
+			fe.fileType = fileTypeSymlink
+			fe.flags = adEmbedded
+			fe.data = []byte{pathComponentName, 2, 0, 0, 8, 'a'}
+			fe.size = uint64(len(fe.data))
+			fe.blocks = 0
+			fe.extents = nil
+			return fe
+		}},
+		{"device", func() *fileEntry {
+			fe := testFileEntry(false)
+			fe.fileType = fileTypeCharDevice
+			fe.major, fe.minor = 4, 64
+			fe.size, fe.blocks, fe.extents = 0, 0, nil
+			return fe
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := tt.entry()
+			version := descriptorVersionNSR02
+			if fe.extended {
+				version = descriptorVersionNSR03
+			}
+			b := make([]byte, 2048)
+			copy(b, fe.toBytes(version))
+			parsed, err := parseFileEntry(b, fe.location)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, ts := range [][2]time.Time{{parsed.accessTime, fe.accessTime}, {parsed.modTime, fe.modTime}, {parsed.createTime, fe.createTime}} {
+				if !ts[0].Equal(ts[1]) {
+					t.Errorf("mismatched time %v instead of %v", ts[0], ts[1])
+				}
+			}
+			parsed.accessTime, parsed.modTime, parsed.createTime = fe.accessTime, fe.modTime, fe.createTime
+			if !reflect.DeepEqual(parsed, fe) {
+				t.Errorf("mismatched entry\nactual   %+v\nexpected %+v", parsed, fe)
+			}
+		})
+	}
+	t.Run("wrong location", func(t *testing.T) {
+		fe := testFileEntry(true)
+		if _, err := parseFileEntry(fe.toBytes(descriptorVersionNSR03), lbAddr{block: 18}); err == nil {
+			t.Errorf("no error on entry at wrong location")
+		}
+	})
+}
+
+func TestParseAllocationDescriptors(t *testing.T) {
+	long := func(length, block uint32, partition uint16) []byte {
+		return longAD{length: length, location: lbAddr{block: block, partition: partition}}.toBytes()
+	}
+	var b []byte
+	b = append(b, long(extentRecorded<<30|4096, 10, 1)...)
+	b = append(b, long(extentNext<<30|2048, 20, 1)...)
+	b = append(b, long(4096, 30, 1)...)
+	extents, err := parseAllocationDescriptors(b, adLong, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// nothing after the pointer to the next extent of descriptors is read
+	expected := []extent{
+		{kind: extentRecorded, length: 4096, location: lbAddr{block: 10, partition: 1}},
+		{kind: extentNext, length: 2048, location: lbAddr{block: 20, partition: 1}},
+	}
+	if !reflect.DeepEqual(extents, expected) {
+		t.Errorf("mismatched extents %+v instead of %+v", extents, expected)
+	}
+	if _, err := parseAllocationDescriptors(b, adEmbedded, 0); err == nil {
+		t.Errorf("no error on embedded data as allocation descriptors")
+	}
+	if extents, err := parseAllocationDescriptors(bytes.Repeat([]byte{0}, 16), adShort, 0); err != nil || len(extents) != 0 {
+		t.Errorf("read %d extents with error %v from zeros", len(extents), err)
+	}
+}
@@ -0,0 +1,164 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	fileIdentifierHeaderSize = 38
+	// maxNameLength is the most bytes a name can take, with its compression identifier
+	maxNameLength = 255
+
+	// file characteristics of a file identifier descriptor
+	fileCharDirectory uint8 = 1 << 1
+	fileCharDeleted   uint8 = 1 << 2
+	fileCharParent    uint8 = 1 << 3
+
+	// types of the components of the path a symlink points to
+	pathComponentRoot    uint8 = 1
+	pathComponentSlash   uint8 = 2
+	pathComponentParent  uint8 = 3
+	pathComponentCurrent uint8 = 4
+	pathComponentName    uint8 = 5
+	pathComponentSize          = 4
+)
+
+// fileIdentifier is a file identifier descriptor, an entry in a directory, which has the name of a file and
+// where its ICB is
+type fileIdentifier struct {
+	characteristics uint8
+	name            string
+	icb             longAD
+}
+
+// fileIdentifierSize is the size of a file identifier descriptor with a name of nameLength bytes, padded to
+// a multiple of 4 bytes
+func fileIdentifierSize(nameLength int) int {
+	return (fileIdentifierHeaderSize + nameLength + 3) &^ 3
+}
+
+// parseFileIdentifiers parses all of the file identifier descriptors in the data of a directory
+func parseFileIdentifiers(b []byte) ([]*fileIdentifier, error) {
+	var fids []*fileIdentifier
+	for pos := 0; pos < len(b); {
+		if pos+fileIdentifierHeaderSize > len(b) {
+			return nil, fmt.Errorf("file identifier at %d overflows directory", pos)
+		}
+		nameLength := int(b[pos+19])
+		implUseLength := int(binary.LittleEndian.Uint16(b[pos+36 : pos+38]))
+		size := fileIdentifierSize(implUseLength + nameLength)
+		if pos+size > len(b) {
+			// the padding of the last one may be left out
+			if pos+fileIdentifierHeaderSize+implUseLength+nameLength > len(b) {
+				return nil, fmt.Errorf("file identifier at %d overflows directory", pos)
+			}
+			size = len(b) - pos
+		}
+		t, err := parseTag(b[pos : pos+size])
+		if err != nil {
+			return nil, fmt.Errorf("invalid file identifier at %d: %v", pos, err)
+		}
+		if t.id != tagFileIdentifierDescriptor {
+			return nil, fmt.Errorf("descriptor at %d has tag identifier %d instead of expected %d", pos, t.id, tagFileIdentifierDescriptor)
+		}
+		fid := &fileIdentifier{
+			characteristics: b[pos+18],
+			icb:             parseLongAD(b[pos+20 : pos+36]),
+		}
+		nameStart := pos + fileIdentifierHeaderSize + implUseLength
+		if fid.name, err = decodeCS0(b[nameStart : nameStart+nameLength]); err != nil {
+			return nil, fmt.Errorf("invalid name of file identifier at %d: %v", pos, err)
+		}
+		fids = append(fids, fid)
+		pos += size
+	}
+	return fids, nil
+}
+
+// toBytes encodes the file identifier descriptor, which starts in block location of the directory
+func (f *fileIdentifier) toBytes(version uint16, location uint32) ([]byte, error) {
+	var name []byte
+	if f.characteristics&fileCharParent == 0 {
+		name = encodeCS0(f.name)
+	}
+	if len(name) > maxNameLength {
+		return nil, fmt.Errorf("name %s takes %d bytes, more than the maximum %d", f.name, len(name), maxNameLength)
+	}
+	b := make([]byte, fileIdentifierSize(len(name)))
+	binary.LittleEndian.PutUint16(b[16:18], 1)
+	b[18] = f.characteristics
+	b[19] = byte(len(name))
+	copy(b[20:36], f.icb.toBytes())
+	copy(b[fileIdentifierHeaderSize:], name)
+	setTag(b, tagFileIdentifierDescriptor, version, location)
+	return b, nil
+}
+
+// parsePathComponents parses the path components a symlink has as its data into the path it points to
+func parsePathComponents(b []byte) (string, error) {
+	var (
+		parts    []string
+		absolute bool
+	)
+	for pos := 0; pos < len(b); {
+		if pos+pathComponentSize > len(b) {
+			return "", fmt.Errorf("path component at %d overflows symlink", pos)
+		}
+		kind := b[pos]
+		length := int(b[pos+1])
+		end := pos + pathComponentSize + length
+		if end > len(b) {
+			return "", fmt.Errorf("path component at %d of %d bytes overflows symlink", pos, length)
+		}
+		switch kind {
+		case pathComponentRoot, pathComponentSlash:
+			absolute = true
+			parts = nil
+		case pathComponentParent:
+			parts = append(parts, "..")
+		case pathComponentCurrent:
+			parts = append(parts, ".")
+		case pathComponentName:
+			name, err := decodeCS0(b[pos+pathComponentSize : end])
+			if err != nil {
+				return "", fmt.Errorf("invalid name of path component at %d: %v", pos, err)
+			}
+			parts = append(parts, name)
+		default:
+			return "", fmt.Errorf("path component at %d has unknown type %d", pos, kind)
+		}
+		pos = end
+	}
+	target := strings.Join(parts, "/")
+	if absolute {
+		target = "/" + target
+	}
+	return target, nil
+}
+
+// pathComponentsBytes encodes the path a symlink points to as path components
+func pathComponentsBytes(target string) ([]byte, error) {
+	var b []byte
+	if strings.HasPrefix(target, "/") {
+		b = append(b, pathComponentSlash, 0, 0, 0)
+	}
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "":
+		case "..":
+			b = append(b, pathComponentParent, 0, 0, 0)
+		case ".":
+			b = append(b, pathComponentCurrent, 0, 0, 0)
+		default:
+			name := encodeCS0(part)
+			if len(name) > maxNameLength {
+				return nil, fmt.Errorf("symlink target component %s takes %d bytes, more than the maximum %d", part, len(name), maxNameLength)
+			}
+			b = append(b, pathComponentName, byte(len(name)), 0, 0)
+			b = append(b, name...)
+		}
+	}
+	return b, nil
+}
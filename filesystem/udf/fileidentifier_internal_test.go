@@ -0,0 +1,89 @@
+package udf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileIdentifiersRoundTrip(t *testing.T) {
+	fids := []*fileIdentifier{
+		{characteristics: fileCharDirectory | fileCharParent, icb: longAD{length: 2048, location: lbAddr{block: 1}}},
+		{name: "hello.txt", icb: longAD{length: 2048, location: lbAddr{block: 2}, implUse: [6]byte{0, 0, 16}}},
+		{characteristics: fileCharDirectory, name: "dir", icb: longAD{length: 2048, location: lbAddr{block: 3}}},
+		{name: "☃ snowman", icb: longAD{length: 2048, location: lbAddr{block: 4}}},
+	}
+	var b []byte
+	for _, fid := range fids {
+		fb, err := fid.toBytes(descriptorVersionNSR03, 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(fb)%4 != 0 {
+			t.Errorf("%s: %d bytes are not padded to 4", fid.name, len(fb))
+		}
+		b = append(b, fb...)
+	}
+	parsed, err := parseFileIdentifiers(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, fids) {
+		t.Errorf("mismatched file identifiers\nactual   %+v\nexpected %+v", parsed, fids)
+	}
+	if _, err := parseFileIdentifiers(b[:len(b)-20]); err == nil {
+		t.Errorf("no error on truncated file identifiers")
+	}
+	long := &fileIdentifier{name: strings.Repeat("a", 255)}
+	if _, err := long.toBytes(descriptorVersionNSR03, 0); err == nil {
+		t.Errorf("no error on name of 256 bytes")
+	}
+}
+
+func TestPathComponents(t *testing.T) {
+	tests := []struct {
+		target string
+		b      []byte
+	}{
+		{"a", []byte{pathComponentName, 2, 0, 0, 8, 'a'}},
+		{"/a", []byte{pathComponentSlash, 0, 0, 0, pathComponentName, 2, 0, 0, 8, 'a'}},
+		{"../b/./c", []byte{
+			pathComponentParent, 0, 0, 0,
+			pathComponentName, 2, 0, 0, 8, 'b',
+			pathComponentCurrent, 0, 0, 0,
+			pathComponentName, 2, 0, 0, 8, 'c',
+		}},
+		{"/", []byte{pathComponentSlash, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			b, err := pathComponentsBytes(tt.target)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(b, tt.b) {
+				t.Errorf("encoded as %v instead of %v", b, tt.b)
+			}
+			target, err := parsePathComponents(tt.b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.target {
+				t.Errorf("parsed as %s instead of %s", target, tt.target)
+			}
+		})
+	}
+	t.Run("root", func(t *testing.T) {
+		target, err := parsePathComponents([]byte{pathComponentRoot, 0, 0, 0, pathComponentName, 2, 0, 0, 8, 'x'})
+		if err != nil || target != "/x" {
+			t.Errorf("parsed as %s with error %v instead of /x", target, err)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, b := range [][]byte{{pathComponentName, 5, 0, 0, 8}, {9, 0, 0, 0}, {pathComponentName}} {
+			if _, err := parsePathComponents(b); err == nil {
+				t.Errorf("no error on %v", b)
+			}
+		}
+	})
+}
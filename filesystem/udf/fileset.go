@@ -0,0 +1,52 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const fileSetDescriptorSize = 512
+
+// fileSetDescriptor is the file set descriptor, which says where the root directory of the files on the logical
+// volume is
+type fileSetDescriptor struct {
+	recorded                time.Time
+	logicalVolumeIdentifier string
+	identifier              string
+	root                    longAD
+	revision                Revision
+}
+
+func parseFileSetDescriptor(b []byte, location uint32) (*fileSetDescriptor, error) {
+	if _, err := checkTag(b, tagFileSetDescriptor, location); err != nil {
+		return nil, err
+	}
+	identifier, err := parseDstring(b[304:336])
+	if err != nil {
+		return nil, fmt.Errorf("invalid file set identifier: %v", err)
+	}
+	return &fileSetDescriptor{
+		recorded:   parseTimestamp(b[16:28]),
+		identifier: identifier,
+		root:       parseLongAD(b[400:416]),
+	}, nil
+}
+
+func (f *fileSetDescriptor) toBytes(version uint16, location uint32) []byte {
+	b := make([]byte, fileSetDescriptorSize)
+	copy(b[16:28], timestampBytes(f.recorded))
+	// interchange level and maximum, and character set lists
+	binary.LittleEndian.PutUint16(b[28:30], 3)
+	binary.LittleEndian.PutUint16(b[30:32], 3)
+	binary.LittleEndian.PutUint32(b[32:36], 1)
+	binary.LittleEndian.PutUint32(b[36:40], 1)
+	copy(b[48:112], charspecBytes())
+	copy(b[112:240], dstringBytes(f.logicalVolumeIdentifier, 128))
+	copy(b[240:304], charspecBytes())
+	copy(b[304:336], dstringBytes(f.identifier, 32))
+	copy(b[400:416], f.root.toBytes())
+	copy(b[416:448], regidBytes(domainOSTACompliant, udfSuffix(f.revision)))
+	setTag(b, tagFileSetDescriptor, version, location)
+	return b
+}
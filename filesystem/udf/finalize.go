@@ -0,0 +1,498 @@
+package udf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// vdsBlocks is how many blocks each of the main and reserve volume descriptor sequences has
+	vdsBlocks uint32 = 16
+	// partitionStart is where the partition with all of the files starts, right after the first anchor
+	partitionStart = anchorBlock + 1
+	// uniqueIDFirst is the unique id of the first file after the root directory, those below it being reserved
+	uniqueIDFirst uint64 = 16
+	// copyBufferSize is how much of the data of a file is copied at a time
+	copyBufferSize = 1 * MB
+)
+
+// FinalizeOptions options to pass to finalize
+type FinalizeOptions struct {
+	// Revision which revision of UDF to write, Revision102 or Revision201. Defaults to Revision201, which keeps
+	// the creation time of each file in an extended file entry
+	Revision Revision
+	// FileUID set all files to be owned by the UID provided, default is to leave as in filesystem
+	FileUID *uint32
+	// FileGID set all files to be owned by the GID provided, default is to leave as in filesystem
+	FileGID *uint32
+}
+
+// Finalize finalize a read-only filesystem by writing it out to a read-only format
+func (fs *FileSystem) Finalize(options FinalizeOptions) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot finalize an already finalized filesystem")
+	}
+
+	/*
+		Everything is in a single physical partition, which has the file set descriptor, the entries of the files and
+		then their data. In order:
+		- volume recognition sequence at 32 KB: BEA01, NSR02 or NSR03, TEA01
+		- main and reserve volume descriptor sequences, of 16 blocks each, from block 32, or 64 KB if that is further:
+		  primary, implementation use, partition, logical volume, unallocated space, terminating
+		- logical volume integrity descriptor, and a terminating descriptor
+		- anchor volume descriptor pointer at block 256
+		- the partition, from block 257:
+		  - file set descriptor
+		  - the entry of each file, one per block, starting with the root directory; the data of a directory or
+		    symlink goes in its entry if it fits
+		  - the data of directories and symlinks that do not fit in their entries
+		  - the data of regular files
+		- anchor volume descriptor pointer in the last block, after the partition
+	*/
+
+	w, err := newVolumeWriter(fs.file, fs.start, fs.blocksize, fs.label, options.Revision)
+	if err != nil {
+		return err
+	}
+	fileList, err := walkTree(fs.workspace)
+	if err != nil {
+		return fmt.Errorf("error walking tree: %v", err)
+	}
+	setOwners(fileList, options)
+	block, err := w.layout(fileList)
+	if err != nil {
+		return err
+	}
+	for _, e := range fileList {
+		if e.mode.IsRegular() {
+			w.placeData(e, block)
+			block += uint32(e.entry.blocks)
+		}
+	}
+	end := partitionStart + block
+	if fs.size > 0 && int64(end+1)*fs.blocksize > fs.size {
+		return fmt.Errorf("filesystem of %d blocks of %d bytes is larger than the %d bytes available", end+1, fs.blocksize, fs.size)
+	}
+
+	for _, e := range fileList {
+		if e.mode.IsRegular() && e.size > 0 {
+			if err := w.copyData(filepath.Join(fs.workspace, e.path), e.entry); err != nil {
+				return fmt.Errorf("error writing data for %s: %v", e.path, err)
+			}
+		}
+	}
+	if err := w.writeFiles(fileList); err != nil {
+		return err
+	}
+	if err := w.writeVolume(vrsOffset, end, fileList); err != nil {
+		return err
+	}
+
+	// finish by setting as finalized, and ready to read
+	fs.workspace = ""
+	a, err := fs.findAnchor()
+	if err != nil {
+		return fmt.Errorf("unable to read anchor volume descriptor pointer: %v", err)
+	}
+	return fs.load(a)
+}
+
+// volumeWriter writes the structures of a UDF volume, whose files are all in a single partition
+type volumeWriter struct {
+	file      util.File
+	start     int64
+	blocksize int64
+	label     string
+	revision  Revision
+	now       time.Time
+}
+
+func newVolumeWriter(f util.File, start, blocksize int64, label string, revision Revision) (*volumeWriter, error) {
+	switch revision {
+	case 0:
+		revision = Revision201
+	case Revision102, Revision201:
+	default:
+		return nil, fmt.Errorf("cannot write UDF revision %#04x, only %#04x or %#04x", uint16(revision), uint16(Revision102), uint16(Revision201))
+	}
+	return &volumeWriter{
+		file:      f,
+		start:     start,
+		blocksize: blocksize,
+		label:     label,
+		revision:  revision,
+		now:       time.Now(),
+	}, nil
+}
+
+// version is the version of the descriptors, 3 for NSR03 from UDF 2.00, and 2 for NSR02 before it
+func (w *volumeWriter) version() uint16 {
+	if w.revision >= 0x0200 {
+		return descriptorVersionNSR03
+	}
+	return descriptorVersionNSR02
+}
+
+// vdsBlock is where the main volume descriptor sequence starts, at block 32, or 64 KB if that is further, clear of
+// the volume recognition sequence and of any iso9660 volume descriptors
+func (w *volumeWriter) vdsBlock() uint32 {
+	if block := uint32(64 * KB / w.blocksize); block > 32 {
+		return block
+	}
+	return 32
+}
+
+// layout creates the entry of every file, each in its own block of the partition after the file set descriptor,
+// and places the data of each directory and symlink in its entry if it fits, and in the blocks after the entries
+// if not. It returns the block of the partition after them.
+func (w *volumeWriter) layout(fileList []*finalizeFileInfo) (uint32, error) {
+	block := uint32(1)
+	for i, e := range fileList {
+		fileType, permissions, flags := udfMode(e.mode)
+		e.entry = &fileEntry{
+			location:    lbAddr{block: block},
+			extended:    w.version() == descriptorVersionNSR03,
+			fileType:    fileType,
+			flags:       flags,
+			uid:         e.uid,
+			gid:         e.gid,
+			permissions: permissions,
+			links:       1,
+			accessTime:  e.modTime,
+			modTime:     e.modTime,
+			createTime:  e.modTime,
+			major:       e.major,
+			minor:       e.minor,
+		}
+		// the root directory is always 0
+		if i > 0 {
+			e.entry.uniqueID = uniqueIDFirst + uint64(i-1)
+		}
+		block++
+	}
+	for _, e := range fileList {
+		fe := e.entry
+		switch {
+		case e.IsDir():
+			for _, c := range e.children {
+				if c.IsDir() {
+					fe.links++
+				}
+			}
+			b, err := w.directoryBytes(e, 0)
+			if err != nil {
+				return 0, fmt.Errorf("error creating directory %s: %v", e.path, err)
+			}
+			e.data = b
+		case e.mode&os.ModeSymlink != 0:
+		case e.mode.IsRegular():
+			fe.size = uint64(e.size)
+			fe.blocks = uint64((e.size + w.blocksize - 1) / w.blocksize)
+			continue
+		default:
+			continue
+		}
+		fe.size = uint64(len(e.data))
+		header := fileEntryHeaderSize
+		if fe.extended {
+			header = extendedFileEntryHeaderSize
+		}
+		if int64(header+len(e.data)) <= w.blocksize {
+			fe.flags |= adEmbedded
+			continue
+		}
+		e.dataBlock = block
+		fe.blocks = uint64((int64(len(e.data)) + w.blocksize - 1) / w.blocksize)
+		block += uint32(fe.blocks)
+	}
+	return block, nil
+}
+
+// directoryBytes creates the data of a directory, with its file identifiers. Those not in its entry have the
+// location of the block they start in, from block.
+func (w *volumeWriter) directoryBytes(e *finalizeFileInfo, block uint32) ([]byte, error) {
+	var b []byte
+	for _, fid := range e.fileIdentifiers(uint32(w.blocksize), w.revision >= 0x0200) {
+		location := e.entry.location.block
+		if e.entry.adType() != adEmbedded {
+			location = block + uint32(int64(len(b))/w.blocksize)
+		}
+		fb, err := fid.toBytes(w.version(), location)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, fb...)
+	}
+	return b, nil
+}
+
+// placeData sets the extents of the data of a file, of as many blocks as it takes from block of the partition
+func (w *volumeWriter) placeData(e *finalizeFileInfo, block uint32) {
+	// each extent is at most the largest whole number of blocks under 1 GB
+	maxLength := int64(extentLengthMask) / w.blocksize * w.blocksize
+	fe := e.entry
+	fe.extents = nil
+	for remaining := int64(fe.size); remaining > 0; {
+		length := remaining
+		if length > maxLength {
+			length = maxLength
+		}
+		fe.extents = append(fe.extents, extent{kind: extentRecorded, length: uint32(length), location: lbAddr{block: block}})
+		block += uint32(length / w.blocksize)
+		remaining -= length
+	}
+}
+
+// copyData copies the data of a regular file to its extents
+func (w *volumeWriter) copyData(fp string, fe *fileEntry) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, copyBufferSize)
+	var off int64
+	for _, e := range fe.extents {
+		pos := w.start + int64(partitionStart+e.location.block)*w.blocksize
+		for done := int64(0); done < int64(e.length); {
+			n := int64(e.length) - done
+			if n > int64(len(buf)) {
+				n = int64(len(buf))
+			}
+			read, err := f.ReadAt(buf[:n], off)
+			if err != nil && !(err == io.EOF && int64(read) == n) {
+				return fmt.Errorf("could not read %d bytes at %d: %v", n, off, err)
+			}
+			// pad the last block with zeros
+			padded := (n + w.blocksize - 1) / w.blocksize * w.blocksize
+			for i := n; i < padded; i++ {
+				buf[i] = 0
+			}
+			if _, err := w.file.WriteAt(buf[:padded], pos+done); err != nil {
+				return fmt.Errorf("could not write %d bytes at %d: %v", padded, off, err)
+			}
+			done += n
+			off += n
+		}
+	}
+	return nil
+}
+
+// writeFiles writes the file set descriptor, and the entry of every file, with the data of directories and
+// symlinks. The data of regular files is not written, but their extents must be set.
+func (w *volumeWriter) writeFiles(fileList []*finalizeFileInfo) error {
+	version := w.version()
+	fsd := &fileSetDescriptor{
+		recorded:                w.now,
+		logicalVolumeIdentifier: w.label,
+		identifier:              w.label,
+		root:                    fileList[0].icb(uint32(w.blocksize), false),
+		revision:                w.revision,
+	}
+	if err := w.writeBlocks(fsd.toBytes(version, 0), partitionStart); err != nil {
+		return fmt.Errorf("failed to write file set descriptor: %v", err)
+	}
+	for _, e := range fileList {
+		fe := e.entry
+		if e.IsDir() {
+			b, err := w.directoryBytes(e, e.dataBlock)
+			if err != nil {
+				return fmt.Errorf("error creating directory %s: %v", e.path, err)
+			}
+			e.data = b
+		}
+		if e.IsDir() || e.mode&os.ModeSymlink != 0 {
+			if fe.adType() == adEmbedded {
+				fe.data = e.data
+			} else {
+				if err := w.writeBlocks(e.data, partitionStart+e.dataBlock); err != nil {
+					return fmt.Errorf("failed to write data of %s: %v", e.path, err)
+				}
+				w.placeData(e, e.dataBlock)
+			}
+		}
+		if err := w.writeBlocks(fe.toBytes(version), partitionStart+fe.location.block); err != nil {
+			return fmt.Errorf("failed to write entry of %s: %v", e.path, err)
+		}
+	}
+	return nil
+}
+
+// writeVolume writes the structures of the volume: the volume recognition sequence from byte vrs, the volume
+// descriptor sequences, the integrity descriptor and the anchors, the last of which is at block end, right after
+// the partition
+func (w *volumeWriter) writeVolume(vrs int64, end uint32, fileList []*finalizeFileInfo) error {
+	version := w.version()
+	nsr, contents := vrsNSR03, entityNSR03
+	if version == descriptorVersionNSR02 {
+		nsr, contents = vrsNSR02, entityNSR02
+	}
+	step := vrsDescriptorSize
+	if w.blocksize > step {
+		step = w.blocksize
+	}
+	for i, id := range []string{vrsBeginningExtended, nsr, vrsTerminatingExtended} {
+		if _, err := w.file.WriteAt(vrsDescriptorBytes(id), w.start+vrs+int64(i)*step); err != nil {
+			return fmt.Errorf("failed to write volume recognition sequence: %v", err)
+		}
+	}
+
+	main := w.vdsBlock()
+	reserve := main + vdsBlocks
+	integrity := reserve + vdsBlocks
+	length := end - partitionStart
+	for _, location := range []uint32{main, reserve} {
+		pvd := &primaryVolumeDescriptor{
+			volumeIdentifier:    w.label,
+			volumeSetIdentifier: fmt.Sprintf("%016x", w.now.UnixNano()),
+			recorded:            w.now,
+		}
+		pd := &partitionDescriptor{
+			sequenceNumber: 2,
+			contents:       contents,
+			accessType:     accessTypeReadOnly,
+			start:          partitionStart,
+			length:         length,
+		}
+		lvd := &logicalVolumeDescriptor{
+			sequenceNumber: 3,
+			identifier:     w.label,
+			blocksize:      uint32(w.blocksize),
+			revision:       w.revision,
+			fileSet:        longAD{length: uint32(w.blocksize)},
+			integrity:      extentAD{length: 2 * uint32(w.blocksize), location: integrity},
+			partitionMaps:  []partitionMap{{kind: partitionMapPhysical, volumeSequence: 1}},
+		}
+		descriptors := [][]byte{
+			pvd.toBytes(version, location),
+			implementationUseBytes(1, w.label, w.revision, version, location+1),
+			pd.toBytes(version, location+2),
+			lvd.toBytes(version, location+3),
+			unallocatedSpaceBytes(4, version, location+4),
+			terminatingBytes(version, location+5),
+		}
+		for i, b := range descriptors {
+			if err := w.writeBlocks(b, location+uint32(i)); err != nil {
+				return fmt.Errorf("failed to write volume descriptor sequence: %v", err)
+			}
+		}
+	}
+
+	lvid := &integrityDescriptor{
+		recorded:     w.now,
+		integrity:    integrityClose,
+		nextUniqueID: uniqueIDFirst + uint64(len(fileList)-1),
+		freeSpace:    []uint32{0},
+		sizes:        []uint32{length},
+		minRead:      w.revision,
+		minWrite:     w.revision,
+		maxWrite:     w.revision,
+	}
+	for _, e := range fileList {
+		if e.IsDir() {
+			lvid.directories++
+		} else {
+			lvid.files++
+		}
+	}
+	if err := w.writeBlocks(lvid.toBytes(version, integrity), integrity); err != nil {
+		return fmt.Errorf("failed to write logical volume integrity descriptor: %v", err)
+	}
+	if err := w.writeBlocks(terminatingBytes(version, integrity+1), integrity+1); err != nil {
+		return fmt.Errorf("failed to write logical volume integrity descriptor: %v", err)
+	}
+
+	a := &anchor{
+		main:    extentAD{length: vdsBlocks * uint32(w.blocksize), location: main},
+		reserve: extentAD{length: vdsBlocks * uint32(w.blocksize), location: reserve},
+	}
+	for _, location := range []uint32{anchorBlock, end} {
+		if err := w.writeBlocks(a.toBytes(version, location), location); err != nil {
+			return fmt.Errorf("failed to write anchor volume descriptor pointer: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeBlocks writes b from block, padded with zeros to whole blocks
+func (w *volumeWriter) writeBlocks(b []byte, block uint32) error {
+	count := (int64(len(b)) + w.blocksize - 1) / w.blocksize
+	if pad := count*w.blocksize - int64(len(b)); pad > 0 {
+		b = append(b[:len(b):len(b)], make([]byte, pad)...)
+	}
+	if _, err := w.file.WriteAt(b, w.start+int64(block)*w.blocksize); err != nil {
+		return fmt.Errorf("could not write %d blocks at block %d: %v", count, block, err)
+	}
+	return nil
+}
+
+// setOwners sets the owner of every file to those in options, if they are set
+func setOwners(fileList []*finalizeFileInfo, options FinalizeOptions) {
+	for _, e := range fileList {
+		if options.FileUID != nil {
+			e.uid = *options.FileUID
+		}
+		if options.FileGID != nil {
+			e.gid = *options.FileGID
+		}
+	}
+}
+
+// walkTree walks the workspace, returning every file and directory in it, each directory before what is in it,
+// starting with the root
+func walkTree(workspace string) ([]*finalizeFileInfo, error) {
+	dirMap := make(map[string]*finalizeFileInfo)
+	fileList := make([]*finalizeFileInfo, 0)
+	err := filepath.Walk(workspace, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workspace, fp)
+		if err != nil {
+			return err
+		}
+		uid, gid := getFileProperties(fi)
+		entry := &finalizeFileInfo{
+			path:    filepath.ToSlash(rel),
+			name:    fi.Name(),
+			mode:    fi.Mode(),
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			uid:     uid,
+			gid:     gid,
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(fp)
+			if err != nil {
+				return fmt.Errorf("unable to read target for symlink at %s: %v", rel, err)
+			}
+			if entry.data, err = pathComponentsBytes(target); err != nil {
+				return fmt.Errorf("invalid target for symlink at %s: %v", rel, err)
+			}
+		case fi.Mode()&os.ModeDevice != 0:
+			if entry.major, entry.minor, err = getDeviceNumbers(fp); err != nil {
+				return fmt.Errorf("unable to read major/minor device numbers for device at %s: %v", rel, err)
+			}
+		}
+		if rel != "." {
+			parent := dirMap[filepath.Dir(rel)]
+			entry.parent = parent
+			parent.children = append(parent.children, entry)
+		}
+		if fi.IsDir() {
+			dirMap[rel] = entry
+		}
+		fileList = append(fileList, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileList, nil
+}
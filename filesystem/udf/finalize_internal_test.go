@@ -0,0 +1,78 @@
+package udf
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPlaceData(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		blocksize int64
+		lengths   []uint32
+	}{
+		{"empty", 0, 2048, nil},
+		{"partial block", 100, 2048, []uint32{100}},
+		{"one extent", 1 << 30, 4096, []uint32{1<<30 - 4096, 4096}},
+		// over 4 GB, which iso9660 cannot have in a single extent
+		{"5 GB", 5 << 30, 2048, []uint32{1<<30 - 2048, 1<<30 - 2048, 1<<30 - 2048, 1<<30 - 2048, 1<<30 - 2048, 5 * 2048}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &volumeWriter{blocksize: tt.blocksize}
+			e := &finalizeFileInfo{entry: &fileEntry{size: uint64(tt.size)}}
+			w.placeData(e, 10)
+			if len(e.entry.extents) != len(tt.lengths) {
+				t.Fatalf("%d extents instead of %d", len(e.entry.extents), len(tt.lengths))
+			}
+			block := uint32(10)
+			var total int64
+			for i, x := range e.entry.extents {
+				if x.length != tt.lengths[i] || x.location.block != block || x.kind != extentRecorded {
+					t.Errorf("extent %d of %d bytes at %d instead of %d at %d", i, x.length, x.location.block, tt.lengths[i], block)
+				}
+				block += uint32((int64(x.length) + tt.blocksize - 1) / tt.blocksize)
+				total += int64(x.length)
+			}
+			if total != tt.size {
+				t.Errorf("extents have %d bytes instead of %d", total, tt.size)
+			}
+		})
+	}
+}
+
+func TestLayout(t *testing.T) {
+	root := &finalizeFileInfo{path: ".", mode: os.ModeDir | 0o755}
+	dir := &finalizeFileInfo{path: "dir", name: "dir", mode: root.mode, parent: root}
+	file := &finalizeFileInfo{path: "dir/file", name: "file", mode: 0o644, size: 5000, parent: dir}
+	root.children = []*finalizeFileInfo{dir}
+	dir.children = []*finalizeFileInfo{file}
+	// a directory with enough entries that it does not fit in its entry
+	for i := 0; i < 100; i++ {
+		dir.children = append(dir.children, &finalizeFileInfo{name: "a_long_name_for_a_file", mode: 0o644, parent: dir})
+	}
+	fileList := append([]*finalizeFileInfo{root, dir}, dir.children...)
+	w, err := newVolumeWriter(nil, 0, 2048, "test", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	block, err := w.layout(fileList)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the file set descriptor, an entry for each, and the data of dir
+	dirBlocks := uint32((len(dir.data) + 2047) / 2048)
+	if expected := 1 + uint32(len(fileList)) + dirBlocks; block != expected {
+		t.Errorf("layout ends at block %d instead of %d", block, expected)
+	}
+	if root.entry.adType() != adEmbedded || root.entry.links != 2 || root.entry.uniqueID != 0 {
+		t.Errorf("mismatched root entry %+v", root.entry)
+	}
+	if dir.entry.adType() == adEmbedded || dir.dataBlock != 1+uint32(len(fileList)) || dir.entry.uniqueID != uniqueIDFirst {
+		t.Errorf("mismatched dir entry %+v at %d", dir.entry, dir.dataBlock)
+	}
+	if !file.entry.extended || file.entry.size != 5000 || file.entry.blocks != 3 {
+		t.Errorf("mismatched file entry %+v", file.entry)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build aix || darwin || dragonfly || freebsd || (js && wasm) || linux || nacl || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd js,wasm linux nacl netbsd openbsd solaris
+
+//nolint:unconvert // linter gets confused in this file
+package udf
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func getDeviceNumbers(path string) (major, minor uint32, err error) {
+	stat := unix.Stat_t{}
+	err = unix.Lstat(path, &stat)
+	if err != nil {
+		return 0, 0, err
+	}
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)), nil
+}
+
+func getFileProperties(fi os.FileInfo) (uid, gid uint32) {
+	if sys := fi.Sys(); sys != nil {
+		if stat, ok := sys.(*unix.Stat_t); ok {
+			uid = stat.Uid
+			gid = stat.Gid
+		}
+	}
+	return uid, gid
+}
@@ -0,0 +1,81 @@
+package udf
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// finalizeFileInfo is a file info useful for finalization
+// fulfills os.FileInfo
+//
+//	Name() string       // base name of the file
+//	Size() int64        // length in bytes for regular files; system-dependent for others
+//	Mode() FileMode     // file mode bits
+//	ModTime() time.Time // modification time
+//	IsDir() bool        // abbreviation for Mode().IsDir()
+//	Sys() interface{}   // underlying data source (can return nil)
+type finalizeFileInfo struct {
+	path     string
+	name     string
+	size     int64
+	mode     os.FileMode
+	modTime  time.Time
+	uid      uint32
+	gid      uint32
+	major    uint32
+	minor    uint32
+	parent   *finalizeFileInfo
+	children []*finalizeFileInfo
+	entry    *fileEntry
+	// data is the data of a directory or symlink, which goes in its entry if it fits, or from dataBlock if not
+	data      []byte
+	dataBlock uint32
+}
+
+func (fi *finalizeFileInfo) Name() string {
+	return fi.name
+}
+func (fi *finalizeFileInfo) Size() int64 {
+	return fi.size
+}
+func (fi *finalizeFileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+func (fi *finalizeFileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+func (fi *finalizeFileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+func (fi *finalizeFileInfo) Sys() interface{} {
+	return nil
+}
+
+// fileIdentifiers returns the entries of a directory, starting with its parent
+func (fi *finalizeFileInfo) fileIdentifiers(blocksize uint32, uniqueIDs bool) []*fileIdentifier {
+	parent := fi.parent
+	if parent == nil {
+		parent = fi
+	}
+	fids := []*fileIdentifier{
+		{characteristics: fileCharDirectory | fileCharParent, icb: parent.icb(blocksize, uniqueIDs)},
+	}
+	for _, c := range fi.children {
+		fid := &fileIdentifier{name: c.name, icb: c.icb(blocksize, uniqueIDs)}
+		if c.IsDir() {
+			fid.characteristics = fileCharDirectory
+		}
+		fids = append(fids, fid)
+	}
+	return fids
+}
+
+// icb is the long allocation descriptor of the entry of the file, which from UDF 2.00 has its unique id
+func (fi *finalizeFileInfo) icb(blocksize uint32, uniqueID bool) longAD {
+	ad := longAD{length: blocksize, location: fi.entry.location}
+	if uniqueID {
+		binary.LittleEndian.PutUint32(ad.implUse[2:6], uint32(fi.entry.uniqueID))
+	}
+	return ad
+}
@@ -0,0 +1,134 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// tag identifiers of descriptors, those of the volume structures and those in partitions
+const (
+	tagPrimaryVolumeDescriptor        uint16 = 1
+	tagAnchorVolumeDescriptorPointer  uint16 = 2
+	tagVolumeDescriptorPointer        uint16 = 3
+	tagImplementationUseVolume        uint16 = 4
+	tagPartitionDescriptor            uint16 = 5
+	tagLogicalVolumeDescriptor        uint16 = 6
+	tagUnallocatedSpaceDescriptor     uint16 = 7
+	tagTerminatingDescriptor          uint16 = 8
+	tagLogicalVolumeIntegrity         uint16 = 9
+	tagFileSetDescriptor              uint16 = 256
+	tagFileIdentifierDescriptor       uint16 = 257
+	tagAllocationExtentDescriptor     uint16 = 258
+	tagIndirectEntry                  uint16 = 259
+	tagFileEntry                      uint16 = 261
+	tagExtendedAttributeHeader        uint16 = 262
+	tagExtendedFileEntry              uint16 = 266
+	tagSize                                  = 16
+	descriptorVersionNSR02            uint16 = 2
+	descriptorVersionNSR03            uint16 = 3
+	volumeDescriptorSize                     = 512
+	maxVolumeDescriptorSequenceLength        = 64
+)
+
+// crcTable is for the CRC of descriptors, CRC-ITU-T with the polynomial x^16 + x^12 + x^5 + 1, starting from 0
+var crcTable = makeCRCTable()
+
+func makeCRCTable() *[256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return &table
+}
+
+// crcITU calculates the CRC of the bytes of a descriptor after its tag
+func crcITU(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc = crcTable[byte(crc>>8)^c] ^ crc<<8
+	}
+	return crc
+}
+
+// tagChecksum is the sum of the bytes of a tag, other than the checksum itself
+func tagChecksum(b []byte) uint8 {
+	var sum uint8
+	for i := 0; i < tagSize; i++ {
+		if i != 4 {
+			sum += b[i]
+		}
+	}
+	return sum
+}
+
+// descriptorTag is the tag that starts every descriptor, with its identifier and location and the CRC of the rest
+// of the descriptor
+type descriptorTag struct {
+	id        uint16
+	version   uint16
+	crc       uint16
+	crcLength uint16
+	location  uint32
+}
+
+// parseTag parses the tag at the start of b, making sure its checksum is right and, if b holds what it covers,
+// its CRC
+func parseTag(b []byte) (*descriptorTag, error) {
+	if len(b) < tagSize {
+		return nil, fmt.Errorf("tag has %d bytes instead of expected %d", len(b), tagSize)
+	}
+	if actual, expected := b[4], tagChecksum(b); actual != expected {
+		return nil, fmt.Errorf("tag checksum was %#02x instead of expected %#02x", actual, expected)
+	}
+	t := &descriptorTag{
+		id:        binary.LittleEndian.Uint16(b[0:2]),
+		version:   binary.LittleEndian.Uint16(b[2:4]),
+		crc:       binary.LittleEndian.Uint16(b[8:10]),
+		crcLength: binary.LittleEndian.Uint16(b[10:12]),
+		location:  binary.LittleEndian.Uint32(b[12:16]),
+	}
+	if t.version != descriptorVersionNSR02 && t.version != descriptorVersionNSR03 {
+		return nil, fmt.Errorf("descriptor version %d is not %d or %d", t.version, descriptorVersionNSR02, descriptorVersionNSR03)
+	}
+	if end := tagSize + int(t.crcLength); end <= len(b) {
+		if actual := crcITU(b[tagSize:end]); actual != t.crc {
+			return nil, fmt.Errorf("descriptor CRC was %#04x instead of expected %#04x", t.crc, actual)
+		}
+	}
+	return t, nil
+}
+
+// checkTag parses the tag at the start of b, making sure it is for a descriptor with identifier id at location
+func checkTag(b []byte, id uint16, location uint32) (*descriptorTag, error) {
+	t, err := parseTag(b)
+	if err != nil {
+		return nil, err
+	}
+	if t.id != id {
+		return nil, fmt.Errorf("descriptor has tag identifier %d instead of expected %d", t.id, id)
+	}
+	if t.location != location {
+		return nil, fmt.Errorf("descriptor says it is at block %d instead of %d", t.location, location)
+	}
+	return t, nil
+}
+
+// setTag fills in the tag at the start of descriptor b, with the CRC of all of the rest of it
+func setTag(b []byte, id, version uint16, location uint32) {
+	binary.LittleEndian.PutUint16(b[0:2], id)
+	binary.LittleEndian.PutUint16(b[2:4], version)
+	b[5] = 0
+	binary.LittleEndian.PutUint16(b[6:8], 0)
+	binary.LittleEndian.PutUint16(b[8:10], crcITU(b[tagSize:]))
+	binary.LittleEndian.PutUint16(b[10:12], uint16(len(b)-tagSize))
+	binary.LittleEndian.PutUint32(b[12:16], location)
+	b[4] = tagChecksum(b)
+}
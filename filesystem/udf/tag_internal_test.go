@@ -0,0 +1,66 @@
+package udf
+
+import (
+	"testing"
+)
+
+func TestCRCITU(t *testing.T) {
+	tests := []struct {
+		b   []byte
+		crc uint16
+	}{
+		{[]byte("123456789"), 0x31c3},
+		{[]byte{0x70, 0x6a, 0x77}, 0x3299},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if crc := crcITU(tt.b); crc != tt.crc {
+			t.Errorf("crc of %x is %#04x instead of %#04x", tt.b, crc, tt.crc)
+		}
+	}
+}
+
+func TestTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(b []byte)
+		valid  bool
+	}{
+		{"valid", func(b []byte) {}, true},
+		{"bad checksum", func(b []byte) { b[4]++ }, false},
+		{"bad crc", func(b []byte) { b[100]++ }, false},
+		{"bad version", func(b []byte) {
+			b[2] = 4
+			b[4] = tagChecksum(b)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, 512)
+			for i := range b[tagSize:] {
+				b[tagSize+i] = byte(i)
+			}
+			setTag(b, tagFileSetDescriptor, descriptorVersionNSR03, 1234)
+			tt.modify(b)
+			tag, err := checkTag(b, tagFileSetDescriptor, 1234)
+			switch {
+			case tt.valid && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case !tt.valid && err == nil:
+				t.Errorf("no error on invalid tag")
+			case tt.valid && (tag.version != descriptorVersionNSR03 || tag.location != 1234 || int(tag.crcLength) != len(b)-tagSize):
+				t.Errorf("mismatched tag %+v", tag)
+			}
+		})
+	}
+	t.Run("wrong descriptor", func(t *testing.T) {
+		b := make([]byte, 512)
+		setTag(b, tagFileSetDescriptor, descriptorVersionNSR02, 10)
+		if _, err := checkTag(b, tagFileEntry, 10); err == nil {
+			t.Errorf("no error on file set descriptor checked as file entry")
+		}
+		if _, err := checkTag(b, tagFileSetDescriptor, 11); err == nil {
+			t.Errorf("no error on tag at wrong location")
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// compression identifiers of OSTA CS0 strings, with 8 or 16 bits per character
+	compression8  byte = 8
+	compression16 byte = 16
+	// UDF 2.50 and later also have these, for the same, where the names are not to be changed
+	compression8Raw  byte = 254
+	compression16Raw byte = 255
+
+	charspecSize  = 64
+	regidSize     = 32
+	timestampSize = 12
+	longADSize    = 16
+	shortADSize   = 8
+	extentADSize  = 8
+	// timezoneUnspecified is the timezone of a timestamp in local time without a known offset from UTC
+	timezoneUnspecified = -2047
+)
+
+// osta is the character set of every string, the information in a charspec of type CS0
+const osta = "OSTA Compressed Unicode"
+
+// entity identifiers in regids
+const (
+	domainOSTACompliant    = "*OSTA UDF Compliant"
+	entityLVInfo           = "*UDF LV Info"
+	entityMetadata         = "*UDF Metadata Partition"
+	entityNSR02            = "+NSR02"
+	entityNSR03            = "+NSR03"
+	implementationDiskfs   = "*go-diskfs"
+	vrsBeginningExtended   = "BEA01"
+	vrsNSR02               = "NSR02"
+	vrsNSR03               = "NSR03"
+	vrsTerminatingExtended = "TEA01"
+)
+
+// decodeCS0 decodes an OSTA CS0 string: a compression identifier followed by characters of 8 or 16 bits
+func decodeCS0(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", nil
+	}
+	switch b[0] {
+	case compression8, compression8Raw:
+		r := make([]rune, 0, len(b)-1)
+		for _, c := range b[1:] {
+			r = append(r, rune(c))
+		}
+		return string(r), nil
+	case compression16, compression16Raw:
+		if len(b)%2 != 1 {
+			return "", fmt.Errorf("string of 16 bit characters has odd length %d", len(b)-1)
+		}
+		u := make([]uint16, 0, len(b)/2)
+		for i := 1; i < len(b); i += 2 {
+			u = append(u, binary.BigEndian.Uint16(b[i:i+2]))
+		}
+		return string(utf16.Decode(u)), nil
+	default:
+		return "", fmt.Errorf("unknown string compression identifier %d", b[0])
+	}
+}
+
+// encodeCS0 encodes s as an OSTA CS0 string, with 8 bit characters if they all fit and 16 bit ones if not
+func encodeCS0(s string) []byte {
+	r := []rune(s)
+	wide := false
+	for _, c := range r {
+		if c > 0xff {
+			wide = true
+			break
+		}
+	}
+	if !wide {
+		b := make([]byte, 0, len(r)+1)
+		b = append(b, compression8)
+		for _, c := range r {
+			b = append(b, byte(c))
+		}
+		return b
+	}
+	u := utf16.Encode(r)
+	b := make([]byte, 1+2*len(u))
+	b[0] = compression16
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[1+2*i:], c)
+	}
+	return b
+}
+
+// parseDstring parses a fixed size field holding a CS0 string, whose length is in its last byte
+func parseDstring(b []byte) (string, error) {
+	n := int(b[len(b)-1])
+	if n == 0 {
+		return "", nil
+	}
+	if n > len(b)-1 {
+		return "", fmt.Errorf("string of %d bytes overflows field of %d bytes", n, len(b))
+	}
+	return decodeCS0(b[:n])
+}
+
+// dstringBytes encodes s as a CS0 string in a fixed field of size bytes, leaving out whatever characters
+// at the end do not fit
+func dstringBytes(s string, size int) []byte {
+	b := make([]byte, size)
+	if s == "" {
+		return b
+	}
+	r := []rune(s)
+	cs0 := encodeCS0(s)
+	for len(cs0) > size-1 {
+		r = r[:len(r)-1]
+		cs0 = encodeCS0(string(r))
+	}
+	copy(b, cs0)
+	b[size-1] = byte(len(cs0))
+	return b
+}
+
+// charspecBytes is the charspec of OSTA CS0, the only character set used
+func charspecBytes() []byte {
+	b := make([]byte, charspecSize)
+	copy(b[1:], osta)
+	return b
+}
+
+// regidBytes encodes an entity identifier, with its suffix
+func regidBytes(identifier string, suffix []byte) []byte {
+	b := make([]byte, regidSize)
+	copy(b[1:24], identifier)
+	copy(b[24:], suffix)
+	return b
+}
+
+// parseRegid parses an entity identifier, returning it without trailing zeros, and its suffix
+func parseRegid(b []byte) (identifier string, suffix []byte) {
+	id := b[1:24]
+	end := len(id)
+	for end > 0 && (id[end-1] == 0 || id[end-1] == ' ') {
+		end--
+	}
+	return string(id[:end]), b[24:32]
+}
+
+// udfSuffix is the suffix of a UDF entity identifier or domain identifier, which starts with the revision
+func udfSuffix(revision Revision) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint16(b, uint16(revision))
+	return b
+}
+
+// parseTimestamp parses a timestamp, in the timezone it has, or in UTC if that is not known
+func parseTimestamp(b []byte) time.Time {
+	typeAndTimezone := binary.LittleEndian.Uint16(b[0:2])
+	year := int(int16(binary.LittleEndian.Uint16(b[2:4])))
+	if year == 0 && b[4] == 0 && b[5] == 0 {
+		return time.Time{}
+	}
+	// the timezone is a signed 12 bit number of minutes
+	tz := int(typeAndTimezone & 0xfff)
+	if tz&0x800 != 0 {
+		tz -= 0x1000
+	}
+	loc := time.UTC
+	if typeAndTimezone>>12 == 1 && tz != timezoneUnspecified {
+		loc = time.FixedZone("", tz*60)
+	}
+	nsec := int(b[9])*10*int(time.Millisecond) + int(b[10])*100*int(time.Microsecond) + int(b[11])*int(time.Microsecond)
+	return time.Date(year, time.Month(b[4]), int(b[5]), int(b[6]), int(b[7]), int(b[8]), nsec, loc)
+}
+
+// timestampBytes encodes t as a timestamp in local time, with its offset from UTC
+func timestampBytes(t time.Time) []byte {
+	b := make([]byte, timestampSize)
+	if t.IsZero() {
+		return b
+	}
+	_, offset := t.Zone()
+	binary.LittleEndian.PutUint16(b[0:2], 1<<12|uint16(offset/60)&0xfff)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(t.Year()))
+	b[4] = byte(t.Month())
+	b[5] = byte(t.Day())
+	b[6] = byte(t.Hour())
+	b[7] = byte(t.Minute())
+	b[8] = byte(t.Second())
+	micro := t.Nanosecond() / int(time.Microsecond)
+	b[9] = byte(micro / 10000)
+	b[10] = byte(micro / 100 % 100)
+	b[11] = byte(micro % 100)
+	return b
+}
+
+// lbAddr is the address of a logical block, in the partition with the given reference number
+type lbAddr struct {
+	block     uint32
+	partition uint16
+}
+
+// longAD is a long allocation descriptor, of an extent in any partition
+type longAD struct {
+	length   uint32
+	location lbAddr
+	// implUse holds the flags and, from UDF 2.00, the low 32 bits of the unique id of what it points to
+	implUse [6]byte
+}
+
+func parseLongAD(b []byte) longAD {
+	ad := longAD{
+		length: binary.LittleEndian.Uint32(b[0:4]),
+		location: lbAddr{
+			block:     binary.LittleEndian.Uint32(b[4:8]),
+			partition: binary.LittleEndian.Uint16(b[8:10]),
+		},
+	}
+	copy(ad.implUse[:], b[10:16])
+	return ad
+}
+
+func (ad longAD) toBytes() []byte {
+	b := make([]byte, longADSize)
+	binary.LittleEndian.PutUint32(b[0:4], ad.length)
+	binary.LittleEndian.PutUint32(b[4:8], ad.location.block)
+	binary.LittleEndian.PutUint16(b[8:10], ad.location.partition)
+	copy(b[10:16], ad.implUse[:])
+	return b
+}
+
+// extentAD is an extent of the volume, by its length and the sector where it starts
+type extentAD struct {
+	length   uint32
+	location uint32
+}
+
+func parseExtentAD(b []byte) extentAD {
+	return extentAD{
+		length:   binary.LittleEndian.Uint32(b[0:4]),
+		location: binary.LittleEndian.Uint32(b[4:8]),
+	}
+}
+
+func (e extentAD) toBytes() []byte {
+	b := make([]byte, extentADSize)
+	binary.LittleEndian.PutUint32(b[0:4], e.length)
+	binary.LittleEndian.PutUint32(b[4:8], e.location)
+	return b
+}
@@ -0,0 +1,113 @@
+package udf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCS0(t *testing.T) {
+	tests := []struct {
+		s string
+		b []byte
+	}{
+		{"abc", []byte{8, 'a', 'b', 'c'}},
+		{"café", []byte{8, 'c', 'a', 'f', 0xe9}},
+		{"☃x", []byte{16, 0x26, 0x03, 0, 'x'}},
+		{"😀", []byte{16, 0xd8, 0x3d, 0xde, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			b := encodeCS0(tt.s)
+			if !bytes.Equal(b, tt.b) {
+				t.Errorf("encoded as %x instead of %x", b, tt.b)
+			}
+			s, err := decodeCS0(tt.b)
+			if err != nil {
+				t.Fatalf("unexpected error decoding: %v", err)
+			}
+			if s != tt.s {
+				t.Errorf("decoded as %q instead of %q", s, tt.s)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := decodeCS0([]byte{16, 0x26}); err == nil {
+			t.Errorf("no error on odd length of 16 bit characters")
+		}
+		if _, err := decodeCS0([]byte{7, 'a'}); err == nil {
+			t.Errorf("no error on unknown compression identifier")
+		}
+	})
+}
+
+func TestDstring(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"fits", "go-diskfs", "go-diskfs"},
+		{"truncated", strings.Repeat("a", 40), strings.Repeat("a", 30)},
+		{"truncated wide", strings.Repeat("☃", 20), strings.Repeat("☃", 15)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := dstringBytes(tt.s, 32)
+			if len(b) != 32 {
+				t.Fatalf("field of %d bytes instead of 32", len(b))
+			}
+			s, err := parseDstring(b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s != tt.expected {
+				t.Errorf("%q instead of %q", s, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+	}{
+		{"utc", time.Date(2024, 2, 29, 13, 14, 15, 123456000, time.UTC)},
+		{"east", time.Date(1999, 12, 31, 23, 59, 59, 0, time.FixedZone("", 5*3600+30*60))},
+		{"west", time.Date(2010, 7, 4, 1, 2, 3, 999999000, time.FixedZone("", -8*3600))},
+		{"zero", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := parseTimestamp(timestampBytes(tt.t))
+			if !parsed.Equal(tt.t) {
+				t.Errorf("%v instead of %v", parsed, tt.t)
+			}
+			_, offset := parsed.Zone()
+			if _, expected := tt.t.Zone(); offset != expected {
+				t.Errorf("offset %d instead of %d", offset, expected)
+			}
+		})
+	}
+	t.Run("unspecified timezone", func(t *testing.T) {
+		b := timestampBytes(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+		b[0], b[1] = 0x01, 0x18
+		if parsed := parseTimestamp(b); parsed.Location() != time.UTC || parsed.Hour() != 3 {
+			t.Errorf("%v instead of 03:04:05 UTC", parsed)
+		}
+	})
+}
+
+func TestRegid(t *testing.T) {
+	b := regidBytes(domainOSTACompliant, udfSuffix(Revision201))
+	id, suffix := parseRegid(b)
+	if id != domainOSTACompliant {
+		t.Errorf("identifier %q instead of %q", id, domainOSTACompliant)
+	}
+	if suffix[0] != 0x01 || suffix[1] != 0x02 {
+		t.Errorf("suffix %x does not start with revision 2.01", suffix)
+	}
+}
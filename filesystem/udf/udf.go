@@ -0,0 +1,829 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/internal/workspace"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// Revision is a revision of the UDF specification, such as 0x0201 for UDF 2.01
+type Revision uint16
+
+const (
+	// Revision102 is UDF 1.02, which most systems can read
+	Revision102 Revision = 0x0102
+	// Revision201 is UDF 2.01, which adds extended file entries
+	Revision201 Revision = 0x0201
+)
+
+const (
+	defaultBlocksize int64 = 2048
+	minBlocksize     int64 = 512
+	maxBlocksize     int64 = 4096
+	// maxLabelSize is the most bytes a label can take, with its compression identifier
+	maxLabelSize = 127
+	// maxSymlinkHops is how many symlinks Stat follows before giving up, as Linux does
+	maxSymlinkHops = 40
+	// maxIndirections is how many indirect entries or allocation extent descriptors are followed
+	maxIndirections = 1024
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	workspace string
+	size      int64
+	start     int64
+	file      util.File
+	blocksize int64
+	volume    *logicalVolumeDescriptor
+	// partitions of the logical volume, by reference number
+	partitions []*partition
+	integrity  *integrityDescriptor
+	rootDir    *fileEntry
+	// label is the label set in the workspace, to write when it is finalized
+	label string
+}
+
+// partition is a partition of the logical volume, either a physical one, or a metadata partition, whose blocks
+// are those of its metadata file
+type partition struct {
+	start    uint32
+	length   uint32
+	metadata []extent
+}
+
+// Label return the filesystem label, the identifier of its logical volume
+func (fs *FileSystem) Label() string {
+	if fs.workspace != "" || fs.volume == nil {
+		return fs.label
+	}
+	return fs.volume.identifier
+}
+
+// SetLabel sets the label to write to the filesystem when it is finalized, of at most 126 characters, or 63
+// if any of them is not in Latin-1. Once the filesystem is finalized, it is read-only and SetLabel returns an
+// error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) SetLabel(label string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot set label on UDF filesystem: %w", filesystem.ErrReadonlyFilesystem)
+	}
+	if n := len(encodeCS0(label)); n > maxLabelSize {
+		return fmt.Errorf("label %s takes %d bytes, more than the maximum %d", label, n, maxLabelSize)
+	}
+	fs.label = label
+	return nil
+}
+
+// Revision returns the UDF revision of a finalized filesystem, from the domain of its logical volume
+func (fs *FileSystem) Revision() Revision {
+	if fs.volume == nil {
+		return 0
+	}
+	return fs.volume.revision
+}
+
+// Statfs get the capacity of the filesystem, from its partitions. As it is read-only, it has no free space.
+//
+// Before it is finalized, it has no size of its own, so TotalBytes is 0, and UsedBytes is the size of the files
+// in the workspace, each rounded up to a whole block.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	if fs.workspace != "" {
+		return workspace.Statfs(fs.workspace, fs.blocksize)
+	}
+	if fs.volume == nil {
+		return filesystem.Statfs{}, fmt.Errorf("filesystem has no logical volume")
+	}
+	var total int64
+	for _, p := range fs.partitions {
+		if p.metadata == nil {
+			total += int64(p.length) * fs.blocksize
+		}
+	}
+	st := filesystem.Statfs{
+		BlockSize:  fs.blocksize,
+		TotalBytes: total,
+		UsedBytes:  total,
+	}
+	if fs.integrity != nil {
+		st.Files = uint64(fs.integrity.files) + uint64(fs.integrity.directories)
+	}
+	return st, nil
+}
+
+// Workspace get the workspace path
+func (fs *FileSystem) Workspace() string {
+	return fs.workspace
+}
+
+// Create creates a UDF filesystem in a given directory
+//
+// requires the util.File where to create the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File to create the filesystem,
+// and blocksize is is the block size of the filesystem
+//
+// note that you are *not* required to create the filesystem on the entire disk. You could have a disk of size
+// 20GB, and create a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for creating filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// If the provided blocksize is 0, it will use the default of 2 KB, the sector size of optical media. It must be
+// the sector size of the disk, a power of 2 from 512 bytes to 4 KB.
+//
+// Nothing is written until Finalize; until then, the filesystem is a workspace directory.
+func Create(f util.File, size, start, blocksize int64) (*FileSystem, error) {
+	if blocksize == 0 {
+		blocksize = defaultBlocksize
+	}
+	// make sure it is an allowed blocksize
+	if err := validateBlocksize(blocksize); err != nil {
+		return nil, err
+	}
+
+	// create a temporary working area where we can create the filesystem.
+	//  It is only on `Finalize()` that we write it out to the actual disk file
+	tmpdir, err := os.MkdirTemp("", "diskfs_udf")
+	if err != nil {
+		return nil, fmt.Errorf("could not create working directory: %v", err)
+	}
+
+	return &FileSystem{
+		workspace: tmpdir,
+		start:     start,
+		size:      size,
+		file:      f,
+		blocksize: blocksize,
+	}, nil
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// note that you are *not* required to read a filesystem on the entire disk. You could have a disk of size
+// 20GB, and a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for working with filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// The block size of UDF is the sector size of the disk it is on. If the provided blocksize is 0, each of
+// 2 KB, 512 bytes, 4 KB and 1 KB is tried in turn, until one finds an anchor volume descriptor pointer.
+//
+// It reads UDF revisions 1.02 to 2.60, with physical and metadata partitions, but not virtual or sparable ones,
+// which are only on write-once or rewritable optical media.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	blocksizes := []int64{defaultBlocksize, 512, 4096, 1024}
+	if blocksize != 0 {
+		if err := validateBlocksize(blocksize); err != nil {
+			return nil, err
+		}
+		blocksizes = []int64{blocksize}
+	}
+
+	fs := &FileSystem{
+		workspace: "", // no workspace when we do nothing with it
+		start:     start,
+		size:      size,
+		file:      file,
+	}
+	var (
+		a   *anchor
+		err error
+	)
+	for _, bs := range blocksizes {
+		fs.blocksize = bs
+		if a, err = fs.findAnchor(); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find anchor volume descriptor pointer: %v", err)
+	}
+	if err := fs.checkRecognitionSequence(); err != nil {
+		return nil, err
+	}
+	if err := fs.load(a); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// findAnchor finds an anchor volume descriptor pointer, at block 256, or if the size of the filesystem is known,
+// at its last block or 256 before that
+func (fs *FileSystem) findAnchor() (*anchor, error) {
+	locations := []uint32{anchorBlock}
+	if blocks := fs.size / fs.blocksize; blocks > int64(anchorBlock) {
+		locations = append(locations, uint32(blocks-1), uint32(blocks-1-int64(anchorBlock)))
+	}
+	b := make([]byte, volumeDescriptorSize)
+	var err error
+	for _, location := range locations {
+		if _, err = fs.readFull(b, int64(location)*fs.blocksize); err != nil {
+			continue
+		}
+		var a *anchor
+		if a, err = parseAnchor(b, location); err == nil {
+			return a, nil
+		}
+	}
+	return nil, err
+}
+
+// checkRecognitionSequence makes sure that the volume recognition sequence has an NSR descriptor, which
+// says that the volume is recorded according to ECMA-167. Anything else in it, such as the volume descriptors
+// of an iso9660 filesystem, is skipped.
+func (fs *FileSystem) checkRecognitionSequence() error {
+	step := vrsDescriptorSize
+	if fs.blocksize > step {
+		step = fs.blocksize
+	}
+	b := make([]byte, 7)
+	for i := int64(0); i < vrsMaxDescriptors; i++ {
+		if _, err := fs.readFull(b, vrsOffset+i*step); err != nil {
+			return fmt.Errorf("unable to read volume recognition sequence: %v", err)
+		}
+		switch id := string(b[1:6]); {
+		case id == vrsNSR02 || id == vrsNSR03:
+			return nil
+		case b[1] == 0:
+			return fmt.Errorf("volume recognition sequence has no NSR descriptor")
+		}
+	}
+	return fmt.Errorf("no NSR descriptor in the first %d of the volume recognition sequence", vrsMaxDescriptors)
+}
+
+// load reads the logical volume that anchor a points to, with its partitions, and its root directory
+func (fs *FileSystem) load(a *anchor) error {
+	lvd, pds, err := fs.readVolumeDescriptors(a.main)
+	if err != nil {
+		if lvd, pds, err = fs.readVolumeDescriptors(a.reserve); err != nil {
+			return fmt.Errorf("unable to read main or reserve volume descriptor sequence: %v", err)
+		}
+	}
+	if int64(lvd.blocksize) != fs.blocksize {
+		return fmt.Errorf("logical volume has block size %d instead of the sector size %d", lvd.blocksize, fs.blocksize)
+	}
+	fs.volume = lvd
+	fs.partitions = make([]*partition, len(lvd.partitionMaps))
+	for i, pm := range lvd.partitionMaps {
+		if pm.kind != partitionMapPhysical {
+			continue
+		}
+		pd, ok := pds[pm.number]
+		if !ok {
+			return fmt.Errorf("no partition descriptor for partition %d", pm.number)
+		}
+		fs.partitions[i] = &partition{start: pd.start, length: pd.length}
+	}
+	// a metadata partition is in a physical one
+	for i, pm := range lvd.partitionMaps {
+		if pm.kind == partitionMapPhysical {
+			continue
+		}
+		if pm.identifier != entityMetadata {
+			return fmt.Errorf("unsupported partition map %q for partition %d", pm.identifier, pm.number)
+		}
+		if fs.partitions[i], err = fs.readMetadataPartition(pm); err != nil {
+			return fmt.Errorf("unable to read metadata partition %d: %v", pm.number, err)
+		}
+	}
+
+	// the integrity descriptor is only needed for counting files
+	if lvd.integrity.length > 0 {
+		b := make([]byte, fs.blocksize)
+		if _, err := fs.readFull(b, int64(lvd.integrity.location)*fs.blocksize); err == nil {
+			if _, err := checkTag(b, tagLogicalVolumeIntegrity, lvd.integrity.location); err == nil {
+				fs.integrity, _ = parseIntegrityDescriptor(b)
+			}
+		}
+	}
+
+	location := lvd.fileSet.location
+	b, err := fs.readBlock(location)
+	if err != nil {
+		return fmt.Errorf("unable to read file set descriptor: %v", err)
+	}
+	fsd, err := parseFileSetDescriptor(b, location.block)
+	if err != nil {
+		return fmt.Errorf("invalid file set descriptor: %v", err)
+	}
+	root, err := fs.readFileEntry(fsd.root.location)
+	if err != nil {
+		return fmt.Errorf("unable to read root directory: %v", err)
+	}
+	if !root.isDir() {
+		return fmt.Errorf("root file entry is of type %d, not a directory", root.fileType)
+	}
+	fs.rootDir = root
+	return nil
+}
+
+// readVolumeDescriptors reads the volume descriptor sequence in extent e, returning its logical volume descriptor
+// and its partition descriptors by partition number, keeping the prevailing one of each, with the highest
+// volume descriptor sequence number
+func (fs *FileSystem) readVolumeDescriptors(e extentAD) (*logicalVolumeDescriptor, map[uint16]*partitionDescriptor, error) {
+	var lvd *logicalVolumeDescriptor
+	pds := map[uint16]*partitionDescriptor{}
+	block, end := e.location, e.location+uint32(int64(e.length)/fs.blocksize)
+	b := make([]byte, fs.blocksize)
+	for n := 0; n < maxVolumeDescriptorSequenceLength && block < end; n++ {
+		if _, err := fs.readFull(b, int64(block)*fs.blocksize); err != nil {
+			return nil, nil, err
+		}
+		t, err := parseTag(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid volume descriptor at block %d: %v", block, err)
+		}
+		if t.location != block {
+			return nil, nil, fmt.Errorf("volume descriptor says it is at block %d instead of %d", t.location, block)
+		}
+		switch t.id {
+		case tagPartitionDescriptor:
+			pd := parsePartitionDescriptor(b)
+			if prev, ok := pds[pd.number]; !ok || pd.sequenceNumber >= prev.sequenceNumber {
+				pds[pd.number] = pd
+			}
+		case tagLogicalVolumeDescriptor:
+			l, err := parseLogicalVolumeDescriptor(b)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid logical volume descriptor at block %d: %v", block, err)
+			}
+			if lvd == nil || l.sequenceNumber >= lvd.sequenceNumber {
+				lvd = l
+			}
+		case tagVolumeDescriptorPointer:
+			// the sequence continues in another extent
+			next := parseExtentAD(b[20:28])
+			block, end = next.location, next.location+uint32(int64(next.length)/fs.blocksize)
+			continue
+		case tagTerminatingDescriptor:
+			block = end
+			continue
+		}
+		block++
+	}
+	if lvd == nil {
+		return nil, nil, fmt.Errorf("volume descriptor sequence at block %d has no logical volume descriptor", e.location)
+	}
+	return lvd, pds, nil
+}
+
+// readMetadataPartition reads the metadata file of a metadata partition, or its mirror if that cannot be read
+func (fs *FileSystem) readMetadataPartition(pm partitionMap) (*partition, error) {
+	physical := -1
+	for i, m := range fs.volume.partitionMaps {
+		if m.kind == partitionMapPhysical && m.number == pm.number {
+			physical = i
+			break
+		}
+	}
+	if physical < 0 {
+		return nil, fmt.Errorf("no physical partition %d", pm.number)
+	}
+	fe, err := fs.readFileEntry(lbAddr{block: pm.metadataFile, partition: uint16(physical)})
+	if err != nil {
+		if fe, err = fs.readFileEntry(lbAddr{block: pm.metadataMirror, partition: uint16(physical)}); err != nil {
+			return nil, fmt.Errorf("unable to read metadata file or its mirror: %v", err)
+		}
+	}
+	if fe.adType() == adEmbedded {
+		return nil, fmt.Errorf("metadata file has its data in its entry")
+	}
+	p := &partition{length: uint32(fe.size / uint64(fs.blocksize))}
+	for _, e := range fe.extents {
+		if e.kind == extentNext {
+			continue
+		}
+		if e.kind != extentRecorded || e.location.partition != uint16(physical) {
+			return nil, fmt.Errorf("metadata file has an extent that is not recorded in its partition")
+		}
+		p.metadata = append(p.metadata, e)
+	}
+	return p, nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeUDF
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeUDF
+}
+
+// Mkdir make a directory at the given path. It is equivalent to `mkdir -p`, i.e. idempotent, in that:
+//
+// * It will make the entire tree path if it does not exist
+// * It will not return an error if the path already exists
+//
+// if readonly and not in workspace, will return an error
+func (fs *FileSystem) Mkdir(p string) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	err := os.MkdirAll(path.Join(fs.workspace, p), 0o755)
+	if err != nil {
+		return fmt.Errorf("could not create directory %s: %v", p, err)
+	}
+	return nil
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	var fi []os.FileInfo
+	// non-workspace: read from UDF
+	// workspace: read from regular filesystem
+	if fs.workspace != "" {
+		fullPath := path.Join(fs.workspace, p)
+		// read the entries
+		dirEntries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+		}
+		for _, e := range dirEntries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+			}
+			fi = append(fi, info)
+		}
+	} else {
+		dirEntries, err := fs.readDirectory(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+		}
+		fi = make([]os.FileInfo, 0, len(dirEntries))
+		for _, entry := range dirEntries {
+			fi = append(fi, entry)
+		}
+	}
+	return fi, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+// or write contents to the file
+//
+// accepts normal os.OpenFile flags
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	if fs.workspace != "" {
+		f, err := os.OpenFile(path.Join(fs.workspace, p), flag, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("target file %s does not exist: %v", p, err)
+		}
+		return f, nil
+	}
+
+	// cannot open to write or append or create if we do not have a workspace
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if de.IsDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	if !de.entry.isRegular() {
+		return nil, fmt.Errorf("cannot open %s, which is not a regular file", p)
+	}
+	return fs.newFile(de.entry), nil
+}
+
+// Stat returns the FileInfo for a file or directory, from the workspace if the filesystem has not been finalized.
+// If p is a symlink, Stat returns the FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Stat(fs.workspace, p)
+	}
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !de.entry.isSymlink() {
+			return de, nil
+		}
+		target, err := fs.readlink(de.entry)
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, fmt.Errorf("too many levels of symlinks at %s", p)
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+// It reads from the workspace if the filesystem has not been finalized.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	if fs.workspace != "" {
+		return workspace.Lstat(fs.workspace, p)
+	}
+	return fs.lstat(p)
+}
+
+// Readlink returns the target of a symlink, from the workspace if the filesystem has not been finalized.
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	if fs.workspace != "" {
+		return workspace.Readlink(fs.workspace, p)
+	}
+	de, err := fs.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if !de.entry.isSymlink() {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	return fs.readlink(de.entry)
+}
+
+// Symlink creates newname as a symlink to oldname in the workspace. Once the filesystem is finalized, it is
+// read-only and Symlink returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return workspace.Symlink(fs.workspace, oldname, newname)
+}
+
+// Remove removes a file or empty directory from the workspace. Once the filesystem is finalized, it is read-only
+// and Remove returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	return workspace.Remove(fs.workspace, p)
+}
+
+// RemoveAll removes a file, or a directory and everything in it, from the workspace. It returns nil if the path
+// does not exist. Once the filesystem is finalized, it is read-only and RemoveAll returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	return workspace.RemoveAll(fs.workspace, p)
+}
+
+// Rename renames or moves a file or directory in the workspace. Once the filesystem is finalized, it is read-only
+// and Rename returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	return workspace.Rename(fs.workspace, oldpath, newpath)
+}
+
+// Truncate changes the size of a file in the workspace. Once the filesystem is finalized, it is read-only
+// and Truncate returns an error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return workspace.Truncate(fs.workspace, p, size)
+}
+
+// lstat finds the directory entry for p in a finalized filesystem
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	// if the dir == filename, then it is just /
+	if dir == filename {
+		return newDirectoryEntry("/", fs.rootDir), nil
+	}
+	parent, err := fs.findDirectory(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory entries for %s: %v", dir, err)
+	}
+	fe, err := fs.findFileEntry(parent, filename)
+	if err != nil {
+		return nil, fmt.Errorf("target file %s: %w", p, err)
+	}
+	return newDirectoryEntry(filename, fe), nil
+}
+
+// readDirectory returns the entries of the directory at p, without its parent
+func (fs *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
+	fe, err := fs.findDirectory(p)
+	if err != nil {
+		return nil, err
+	}
+	fids, err := fs.readFileIdentifiers(fe)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory at path %s: %v", p, err)
+	}
+	entries := make([]*directoryEntry, 0, len(fids))
+	for _, fid := range fids {
+		child, err := fs.readFileEntry(fid.icb.location)
+		if err != nil {
+			return nil, fmt.Errorf("error finding file entry for %s: %v", fid.name, err)
+		}
+		entries = append(entries, newDirectoryEntry(fid.name, child))
+	}
+	return entries, nil
+}
+
+// findDirectory finds the file entry of the directory at p, without following symlinks
+func (fs *FileSystem) findDirectory(p string) (*fileEntry, error) {
+	fe := fs.rootDir
+	for _, part := range splitPath(p) {
+		var err error
+		if fe, err = fs.findFileEntry(fe, part); err != nil {
+			return nil, fmt.Errorf("could not find path %s: %w", p, err)
+		}
+	}
+	if !fe.isDir() {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	return fe, nil
+}
+
+// findFileEntry finds the file entry of name in the directory of dir
+func (fs *FileSystem) findFileEntry(dir *fileEntry, name string) (*fileEntry, error) {
+	fids, err := fs.readFileIdentifiers(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fid := range fids {
+		if fid.name != name {
+			continue
+		}
+		fe, err := fs.readFileEntry(fid.icb.location)
+		if err != nil {
+			return nil, fmt.Errorf("error finding file entry for %s: %v", name, err)
+		}
+		return fe, nil
+	}
+	return nil, fmt.Errorf("%s does not exist: %w", name, os.ErrNotExist)
+}
+
+// readFileIdentifiers reads the entries of a directory, without its parent or any that are deleted
+func (fs *FileSystem) readFileIdentifiers(fe *fileEntry) ([]*fileIdentifier, error) {
+	if !fe.isDir() {
+		return nil, fmt.Errorf("file entry at block %d is not a directory", fe.location.block)
+	}
+	b, err := fs.readAll(fe)
+	if err != nil {
+		return nil, err
+	}
+	fids, err := parseFileIdentifiers(b)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*fileIdentifier, 0, len(fids))
+	for _, fid := range fids {
+		if fid.characteristics&(fileCharParent|fileCharDeleted) == 0 {
+			entries = append(entries, fid)
+		}
+	}
+	return entries, nil
+}
+
+// readlink returns the target of a symlink
+func (fs *FileSystem) readlink(fe *fileEntry) (string, error) {
+	b, err := fs.readAll(fe)
+	if err != nil {
+		return "", fmt.Errorf("could not read symlink target: %v", err)
+	}
+	return parsePathComponents(b)
+}
+
+// readAll reads all of the data of a file
+func (fs *FileSystem) readAll(fe *fileEntry) ([]byte, error) {
+	b := make([]byte, fe.size)
+	if _, err := io.ReadFull(fs.newFile(fe), b); err != nil && !(err == io.EOF && fe.size == 0) {
+		return nil, fmt.Errorf("could not read file entry at block %d: %v", fe.location.block, err)
+	}
+	return b, nil
+}
+
+// readFileEntry reads the file entry at location, following any indirect entry, along with all of its
+// allocation descriptors
+func (fs *FileSystem) readFileEntry(location lbAddr) (*fileEntry, error) {
+	for hops := 0; hops < maxIndirections; hops++ {
+		b, err := fs.readBlock(location)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file entry at block %d: %v", location.block, err)
+		}
+		if binary.LittleEndian.Uint16(b[0:2]) == tagIndirectEntry {
+			if location, err = parseIndirectEntry(b, location); err != nil {
+				return nil, fmt.Errorf("invalid indirect entry at block %d: %v", location.block, err)
+			}
+			continue
+		}
+		fe, err := parseFileEntry(b, location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file entry at block %d: %v", location.block, err)
+		}
+		if fe.adType() == adEmbedded {
+			return fe, nil
+		}
+		// more of the allocation descriptors are in the extent the last one points to
+		for n := 0; len(fe.extents) > 0 && fe.extents[len(fe.extents)-1].kind == extentNext; n++ {
+			if n == maxIndirections {
+				return nil, fmt.Errorf("file entry at block %d has too many allocation extents", location.block)
+			}
+			next := fe.extents[len(fe.extents)-1].location
+			b, err := fs.readBlock(next)
+			if err != nil {
+				return nil, fmt.Errorf("could not read allocation extent at block %d: %v", next.block, err)
+			}
+			more, err := parseAllocationExtent(b, next, fe.adType())
+			if err != nil {
+				return nil, fmt.Errorf("invalid allocation extent at block %d: %v", next.block, err)
+			}
+			fe.extents = append(fe.extents[:len(fe.extents)-1], more...)
+		}
+		return fe, nil
+	}
+	return nil, fmt.Errorf("too many indirect entries at block %d", location.block)
+}
+
+// readBlock reads the logical block at location
+func (fs *FileSystem) readBlock(location lbAddr) ([]byte, error) {
+	b := make([]byte, fs.blocksize)
+	if _, err := fs.readPartition(b, location, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readPartition reads all of b from byte within of the logical block at location, which need not be in the same
+// extent of a metadata partition
+func (fs *FileSystem) readPartition(b []byte, location lbAddr, within int64) (int, error) {
+	read := 0
+	for read < len(b) {
+		pos, n, err := fs.partitionOffset(location, within+int64(read))
+		if err != nil {
+			return read, err
+		}
+		if n > int64(len(b)-read) {
+			n = int64(len(b) - read)
+		}
+		if _, err := fs.readFull(b[read:read+int(n)], pos); err != nil {
+			return read, err
+		}
+		read += int(n)
+	}
+	return read, nil
+}
+
+// partitionOffset returns where on the filesystem byte within of the logical block at location is, and how
+// many bytes from there are contiguous
+func (fs *FileSystem) partitionOffset(location lbAddr, within int64) (pos, n int64, err error) {
+	if int(location.partition) >= len(fs.partitions) {
+		return 0, 0, fmt.Errorf("no partition with reference number %d", location.partition)
+	}
+	p := fs.partitions[location.partition]
+	off := int64(location.block)*fs.blocksize + within
+	if p.metadata == nil {
+		end := int64(p.length) * fs.blocksize
+		if off >= end {
+			return 0, 0, fmt.Errorf("byte %d is past the end of partition %d", off, location.partition)
+		}
+		return int64(p.start)*fs.blocksize + off, end - off, nil
+	}
+	for _, e := range p.metadata {
+		if off < int64(e.length) {
+			pos, n, err := fs.partitionOffset(e.location, off)
+			if err != nil {
+				return 0, 0, err
+			}
+			if remaining := int64(e.length) - off; n > remaining {
+				n = remaining
+			}
+			return pos, n, nil
+		}
+		off -= int64(e.length)
+	}
+	return 0, 0, fmt.Errorf("block %d is past the end of metadata partition %d", location.block, location.partition)
+}
+
+// readFull reads all of b from pos on the filesystem
+func (fs *FileSystem) readFull(b []byte, pos int64) (int, error) {
+	if fs.size > 0 && pos+int64(len(b)) > fs.size {
+		return 0, fmt.Errorf("cannot read %d bytes at %d, past the end of the filesystem at %d", len(b), pos, fs.size)
+	}
+	n, err := fs.file.ReadAt(b, fs.start+pos)
+	if err != nil && !(err == io.EOF && n == len(b)) {
+		return n, fmt.Errorf("error reading %d bytes at %d: %v", len(b), pos, err)
+	}
+	return n, nil
+}
+
+func validateBlocksize(blocksize int64) error {
+	switch {
+	case blocksize < minBlocksize:
+		return fmt.Errorf("blocksize %d too small, must be at least %d", blocksize, minBlocksize)
+	case blocksize > maxBlocksize:
+		return fmt.Errorf("blocksize %d too large, must be no more than %d", blocksize, maxBlocksize)
+	case blocksize&(blocksize-1) != 0:
+		return fmt.Errorf("blocksize %d is not a power of 2", blocksize)
+	}
+	return nil
+}
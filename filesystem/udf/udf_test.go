@@ -0,0 +1,434 @@
+package udf_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
+)
+
+// tree is what the test images are made from
+type tree struct {
+	files    map[string][]byte
+	links    map[string]string
+	manyDirs int
+}
+
+func newTree(t *testing.T) *tree {
+	t.Helper()
+	random := make([]byte, 100*1024+17)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("unable to generate random data: %v", err)
+	}
+	tr := &tree{
+		files: map[string][]byte{
+			"/hello.txt":          []byte("hello world\n"),
+			"/random.bin":         random,
+			"/empty":              nil,
+			"/block":              bytes.Repeat([]byte{0xa5}, 4096),
+			"/dir/sub/nested.txt": bytes.Repeat([]byte("nested "), 1000),
+			"/dir/ünïcödé ☃.txt":  []byte("snowman\n"),
+		},
+		links: map[string]string{
+			"/link":     "hello.txt",
+			"/dirlink":  "dir/sub",
+			"/abslink":  "/dir/sub/nested.txt",
+			"/uplink":   "../dir/./sub",
+			"/longlink": "/" + strings.Repeat("a", 200) + "/" + strings.Repeat("b", 200),
+		},
+		manyDirs: 400,
+	}
+	for i := 0; i < tr.manyDirs; i++ {
+		tr.files[fmt.Sprintf("/many/file_with_a_long_name_%04d", i)] = []byte(fmt.Sprintf("%d\n", i))
+	}
+	return tr
+}
+
+// populate writes the tree into a filesystem that is not yet finalized
+func (tr *tree) populate(t *testing.T, fs filesystem.SymlinkFileSystem) {
+	t.Helper()
+	for p, content := range tr.files {
+		if err := fs.Mkdir(p[:strings.LastIndex(p, "/")]); err != nil {
+			t.Fatalf("Failed to Mkdir for %s: %v", p, err)
+		}
+		fl, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to OpenFile(%s): %v", p, err)
+		}
+		if _, err := fl.Write(content); err != nil {
+			t.Fatalf("error writing to %s: %v", p, err)
+		}
+		fl.Close()
+	}
+	for p, target := range tr.links {
+		if err := fs.Symlink(target, p); err != nil {
+			t.Fatalf("Failed to Symlink(%s): %v", p, err)
+		}
+	}
+}
+
+// create builds a finalized filesystem from the tree in a new temporary file
+func (tr *tree) create(t *testing.T, blocksize int64, options udf.FinalizeOptions) (*udf.FileSystem, *os.File) {
+	t.Helper()
+	f, err := os.CreateTemp("", "udf_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	fs, err := udf.Create(f, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("Failed to udf.Create: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(fs.Workspace()) })
+	tr.populate(t, fs)
+	if err := fs.SetLabel("go-diskfs"); err != nil {
+		t.Fatalf("Failed to udf.SetLabel: %v", err)
+	}
+	if err := fs.Finalize(options); err != nil {
+		t.Fatalf("Failed to udf.Finalize: %v", err)
+	}
+	return fs, f
+}
+
+// check reads everything in the tree back from fs
+func (tr *tree) check(t *testing.T, fs filesystem.FileSystem) {
+	t.Helper()
+	for p, expected := range tr.files {
+		fl, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		b, err := io.ReadAll(fl)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("%s: read %d bytes that do not match the %d written", p, len(b), len(expected))
+		}
+		fi, err := fs.Stat(p)
+		if err != nil {
+			t.Fatalf("error stating %s: %v", p, err)
+		}
+		if fi.Size() != int64(len(expected)) || !fi.Mode().IsRegular() {
+			t.Errorf("%s: mismatched size %d or mode %v", p, fi.Size(), fi.Mode())
+		}
+	}
+	sfs, ok := fs.(filesystem.SymlinkFileSystem)
+	if !ok {
+		t.Fatalf("udf does not implement filesystem.SymlinkFileSystem")
+	}
+	for p, expected := range tr.links {
+		target, err := sfs.Readlink(p)
+		if err != nil {
+			t.Fatalf("error reading link %s: %v", p, err)
+		}
+		if target != expected {
+			t.Errorf("%s: link to %s instead of %s", p, target, expected)
+		}
+		fi, err := sfs.Lstat(p)
+		if err != nil {
+			t.Fatalf("error stating link %s: %v", p, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: mode %v is not a symlink", p, fi.Mode())
+		}
+	}
+
+	entries, err := fs.ReadDir("/many")
+	if err != nil {
+		t.Fatalf("error reading /many: %v", err)
+	}
+	if len(entries) != tr.manyDirs {
+		t.Errorf("read %d entries in /many instead of %d", len(entries), tr.manyDirs)
+	}
+	root, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading /: %v", err)
+	}
+	names := make([]string, 0, len(root))
+	for _, e := range root {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	expected := []string{"abslink", "block", "dir", "dirlink", "empty", "hello.txt", "link", "longlink", "many", "random.bin", "uplink"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("root has %v instead of %v", names, expected)
+	}
+	if fs.Label() != "go-diskfs" {
+		t.Errorf("label %q instead of go-diskfs", fs.Label())
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name      string
+		blocksize int64
+		options   udf.FinalizeOptions
+		revision  udf.Revision
+	}{
+		{"default", 0, udf.FinalizeOptions{}, udf.Revision201},
+		{"1.02", 2048, udf.FinalizeOptions{Revision: udf.Revision102}, udf.Revision102},
+		{"2.01", 2048, udf.FinalizeOptions{Revision: udf.Revision201}, udf.Revision201},
+		{"small blocks", 512, udf.FinalizeOptions{}, udf.Revision201},
+		{"big blocks", 4096, udf.FinalizeOptions{Revision: udf.Revision102}, udf.Revision102},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, f := tr.create(t, tt.blocksize, tt.options)
+			if fs.Workspace() != "" {
+				t.Errorf("workspace %s remains after Finalize", fs.Workspace())
+			}
+			// what was finalized can be read at once, and again from the file
+			tr.check(t, fs)
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := udf.Read(f, fi.Size(), 0, 0)
+			if err != nil {
+				t.Fatalf("error reading finalized filesystem: %v", err)
+			}
+			tr.check(t, read)
+			if read.Revision() != tt.revision {
+				t.Errorf("revision %#04x instead of %#04x", read.Revision(), tt.revision)
+			}
+			if read.Type() != filesystem.TypeUDF {
+				t.Errorf("type %v instead of filesystem.TypeUDF", read.Type())
+			}
+		})
+	}
+	t.Run("unsupported revision", func(t *testing.T) {
+		f, err := os.CreateTemp("", "udf_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer os.Remove(f.Name())
+		fs, err := udf.Create(f, 0, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to udf.Create: %v", err)
+		}
+		defer os.RemoveAll(fs.Workspace())
+		if err := fs.Finalize(udf.FinalizeOptions{Revision: 0x0250}); err == nil {
+			t.Errorf("finalized revision 2.50 without error")
+		}
+	})
+	t.Run("too small", func(t *testing.T) {
+		f, err := os.CreateTemp("", "udf_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer os.Remove(f.Name())
+		fs, err := udf.Create(f, 1024*1024, 0, 2048)
+		if err != nil {
+			t.Fatalf("Failed to udf.Create: %v", err)
+		}
+		defer os.RemoveAll(fs.Workspace())
+		fl, err := fs.OpenFile("/big", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to udf.OpenFile: %v", err)
+		}
+		if _, err := fl.Write(make([]byte, 1024*1024)); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		if err := fs.Finalize(udf.FinalizeOptions{}); err == nil {
+			t.Errorf("finalized 1 MB file into 1 MB without error")
+		}
+	})
+	t.Run("invalid blocksize", func(t *testing.T) {
+		if _, err := udf.Create(nil, 0, 0, 1000); err == nil {
+			t.Errorf("created with blocksize 1000 without error")
+		}
+	})
+}
+
+func TestStat(t *testing.T) {
+	tr := newTree(t)
+	uid, gid := uint32(1234), uint32(5678)
+	tests := []struct {
+		revision udf.Revision
+		created  bool
+	}{
+		{udf.Revision102, false},
+		{udf.Revision201, true},
+	}
+	for _, tt := range tests {
+		fs, _ := tr.create(t, 2048, udf.FinalizeOptions{Revision: tt.revision, FileUID: &uid, FileGID: &gid})
+		files := []struct {
+			path  string
+			isDir bool
+			size  int64
+			links uint16
+		}{
+			// a directory is linked from its parent and from each of its subdirectories, but not from itself
+			{"/", true, 0, 3},
+			{"/dir", true, 0, 2},
+			{"/link", false, 12, 1},
+			{"/dirlink", true, 0, 1},
+			{"/abslink", false, 7000, 1},
+		}
+		for _, f := range files {
+			t.Run(fmt.Sprintf("%#04x %s", uint16(tt.revision), f.path), func(t *testing.T) {
+				fi, err := fs.Stat(f.path)
+				if err != nil {
+					t.Fatalf("error stating %s: %v", f.path, err)
+				}
+				if fi.IsDir() != f.isDir || (!f.isDir && fi.Size() != f.size) {
+					t.Errorf("mismatched dir %v or size %d", fi.IsDir(), fi.Size())
+				}
+				stat := fi.Sys().(udf.FileStat)
+				if stat.Nlink() != f.links || stat.UID() != uid || stat.GID() != gid {
+					t.Errorf("mismatched links %d, uid %d or gid %d", stat.Nlink(), stat.UID(), stat.GID())
+				}
+				if stat.CreationTime().IsZero() == tt.created {
+					t.Errorf("mismatched creation time %v", stat.CreationTime())
+				}
+			})
+		}
+	}
+	fs, _ := tr.create(t, 2048, udf.FinalizeOptions{})
+	if _, err := fs.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("mismatched error %v instead of os.ErrNotExist", err)
+	}
+	if _, err := fs.ReadDir("/hello.txt"); err == nil {
+		t.Errorf("read directory of a file without error")
+	}
+	statfs, err := fs.Statfs()
+	if err != nil {
+		t.Fatalf("error getting statfs: %v", err)
+	}
+	if statfs.BlockSize != 2048 || statfs.TotalBytes == 0 || statfs.Files == 0 {
+		t.Errorf("mismatched statfs %+v", statfs)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tr := newTree(t)
+	fs, _ := tr.create(t, 512, udf.FinalizeOptions{})
+	expected := tr.files["/random.bin"]
+	fl, err := fs.OpenFile("/random.bin", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	for _, off := range []int64{70000, 5, 100000, 511} {
+		if _, err := fl.Seek(off, io.SeekStart); err != nil {
+			t.Fatalf("error seeking to %d: %v", off, err)
+		}
+		b := make([]byte, 10000)
+		n, err := fl.Read(b)
+		if err != nil && err != io.EOF {
+			t.Fatalf("error reading at %d: %v", off, err)
+		}
+		if !bytes.Equal(b[:n], expected[off:off+int64(n)]) || n == 0 {
+			t.Errorf("mismatched %d bytes at %d", n, off)
+		}
+	}
+	if pos, err := fl.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(expected))-10 {
+		t.Errorf("seek from end to %d, error %v", pos, err)
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	tr := newTree(t)
+	fs, _ := tr.create(t, 2048, udf.FinalizeOptions{})
+	checks := map[string]error{
+		"Mkdir":     fs.Mkdir("/new"),
+		"Remove":    fs.Remove("/hello.txt"),
+		"RemoveAll": fs.RemoveAll("/dir"),
+		"Rename":    fs.Rename("/hello.txt", "/bye.txt"),
+		"Truncate":  fs.Truncate("/hello.txt", 0),
+		"Symlink":   fs.Symlink("hello.txt", "/new"),
+		"SetLabel":  fs.SetLabel("new"),
+		"Finalize":  fs.Finalize(udf.FinalizeOptions{}),
+	}
+	_, err := fs.OpenFile("/hello.txt", os.O_RDWR)
+	checks["OpenFile"] = err
+	fl, err := fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	_, checks["Write"] = fl.Write([]byte("x"))
+	for name, err := range checks {
+		if err == nil {
+			t.Errorf("%s: no error on a finalized filesystem", name)
+			continue
+		}
+		if name != "Finalize" && !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("%s: error %v is not filesystem.ErrReadonlyFilesystem", name, err)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name    string
+		options iso9660.FinalizeOptions
+	}{
+		{"plain", iso9660.FinalizeOptions{UDF: &udf.FinalizeOptions{}}},
+		{"joliet", iso9660.FinalizeOptions{Joliet: true, UDF: &udf.FinalizeOptions{Revision: udf.Revision102}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.CreateTemp("", "udf_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer os.Remove(f.Name())
+			iso, err := iso9660.Create(f, 0, 0, 2048, "")
+			if err != nil {
+				t.Fatalf("Failed to iso9660.Create: %v", err)
+			}
+			defer os.RemoveAll(iso.Workspace())
+			tr.populate(t, iso)
+			tt.options.VolumeIdentifier = "go-diskfs"
+			if err := iso.Finalize(tt.options); err != nil {
+				t.Fatalf("Failed to iso9660.Finalize: %v", err)
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := udf.Read(f, fi.Size(), 0, 0)
+			if err != nil {
+				t.Fatalf("error reading UDF of bridge image: %v", err)
+			}
+			tr.check(t, read)
+			// the iso9660 filesystem has the same data
+			isoRead, err := iso9660.Read(f, fi.Size(), 0, 2048)
+			if err != nil {
+				t.Fatalf("error reading iso9660 of bridge image: %v", err)
+			}
+			for _, p := range []string{"/random.bin", "/block"} {
+				// without Joliet, the names are upper case
+				name := p
+				if !tt.options.Joliet {
+					name = strings.ToUpper(p)
+				}
+				fl, err := isoRead.OpenFile(name, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("error opening %s in iso9660: %v", p, err)
+				}
+				b, err := io.ReadAll(fl)
+				if err != nil {
+					t.Fatalf("error reading %s in iso9660: %v", p, err)
+				}
+				if !bytes.Equal(b, tr.files[p]) {
+					t.Errorf("%s: read %d bytes from iso9660 that do not match the %d written", p, len(b), len(tr.files[p]))
+				}
+			}
+		})
+	}
+}
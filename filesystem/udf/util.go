@@ -0,0 +1,127 @@
+package udf
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	// KB represents one KB
+	KB int64 = 1024
+	// MB represents one MB
+	MB int64 = 1024 * KB
+	// GB represents one GB
+	GB int64 = 1024 * MB
+)
+
+// permissions of a file entry, for other, group and owner, each with execute, write, read, change attribute
+// and delete in that order
+const (
+	permOtherShift = 0
+	permGroupShift = 5
+	permOwnerShift = 10
+	permRWX        = 0x7
+)
+
+// file types in an icb tag
+const (
+	fileTypeDirectory    uint8 = 4
+	fileTypeRegular      uint8 = 5
+	fileTypeBlockDevice  uint8 = 6
+	fileTypeCharDevice   uint8 = 7
+	fileTypeFifo         uint8 = 9
+	fileTypeSocket       uint8 = 10
+	fileTypeSymlink      uint8 = 12
+	fileTypeMetadataFile uint8 = 250
+)
+
+// flags of an icb tag, after the type of its allocation descriptors in the lowest 3 bits
+const (
+	icbFlagADMask uint16 = 0x7
+	icbFlagSetuid uint16 = 1 << 6
+	icbFlagSetgid uint16 = 1 << 7
+	icbFlagSticky uint16 = 1 << 8
+)
+
+// fileMode converts the type, permissions and icb tag flags of a file entry to an os.FileMode
+func fileMode(fileType uint8, permissions uint32, flags uint16) os.FileMode {
+	mode := os.FileMode(permissions>>permOtherShift&permRWX |
+		(permissions>>permGroupShift&permRWX)<<3 |
+		(permissions>>permOwnerShift&permRWX)<<6)
+	switch fileType {
+	case fileTypeDirectory:
+		mode |= os.ModeDir
+	case fileTypeSymlink:
+		mode |= os.ModeSymlink
+	case fileTypeBlockDevice:
+		mode |= os.ModeDevice
+	case fileTypeCharDevice:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case fileTypeFifo:
+		mode |= os.ModeNamedPipe
+	case fileTypeSocket:
+		mode |= os.ModeSocket
+	}
+	if flags&icbFlagSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if flags&icbFlagSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if flags&icbFlagSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// udfMode converts an os.FileMode to the type, permissions and icb tag flags of a file entry
+func udfMode(mode os.FileMode) (fileType uint8, permissions uint32, flags uint16) {
+	perm := uint32(mode.Perm())
+	permissions = (perm&permRWX)<<permOtherShift |
+		(perm>>3&permRWX)<<permGroupShift |
+		(perm>>6&permRWX)<<permOwnerShift
+	switch {
+	case mode.IsDir():
+		fileType = fileTypeDirectory
+	case mode&os.ModeSymlink != 0:
+		fileType = fileTypeSymlink
+	case mode&os.ModeCharDevice != 0:
+		fileType = fileTypeCharDevice
+	case mode&os.ModeDevice != 0:
+		fileType = fileTypeBlockDevice
+	case mode&os.ModeNamedPipe != 0:
+		fileType = fileTypeFifo
+	case mode&os.ModeSocket != 0:
+		fileType = fileTypeSocket
+	default:
+		fileType = fileTypeRegular
+	}
+	if mode&os.ModeSetuid != 0 {
+		flags |= icbFlagSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		flags |= icbFlagSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		flags |= icbFlagSticky
+	}
+	return fileType, permissions, flags
+}
+
+func universalizePath(p string) string {
+	// globalize the separator
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+func splitPath(p string) []string {
+	ps := universalizePath(p)
+	parts := strings.Split(ps, "/")
+	// eliminate empty parts
+	ret := make([]string, 0)
+	for _, sub := range parts {
+		if sub != "" {
+			ret = append(ret, sub)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,314 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	// vrsOffset is where the volume recognition sequence starts, after the system area
+	vrsOffset int64 = 32 * KB
+	// vrsDescriptorSize is the size of each volume structure descriptor in the volume recognition sequence,
+	// each of which starts a new block if blocks are larger
+	vrsDescriptorSize int64 = 2 * KB
+	// vrsMaxDescriptors is how many descriptors of the volume recognition sequence are read looking for an NSR one
+	vrsMaxDescriptors = 64
+	// anchorBlock is where the first anchor volume descriptor pointer is
+	anchorBlock uint32 = 256
+
+	partitionMapPhysical  uint8 = 1
+	partitionMapType2     uint8 = 2
+	partitionMapType1Size       = 6
+	partitionMapType2Size       = 64
+
+	partitionFlagAllocated uint16 = 1
+	accessTypeReadOnly     uint32 = 1
+	integrityClose         uint32 = 1
+	integrityImplUseSize          = 46
+)
+
+// vrsDescriptorBytes is a volume structure descriptor of the volume recognition sequence, such as BEA01
+func vrsDescriptorBytes(identifier string) []byte {
+	b := make([]byte, vrsDescriptorSize)
+	copy(b[1:6], identifier)
+	b[6] = 1
+	return b
+}
+
+// anchor is an anchor volume descriptor pointer, which points to the main and reserve volume descriptor sequences
+type anchor struct {
+	main    extentAD
+	reserve extentAD
+}
+
+func parseAnchor(b []byte, location uint32) (*anchor, error) {
+	if _, err := checkTag(b, tagAnchorVolumeDescriptorPointer, location); err != nil {
+		return nil, fmt.Errorf("invalid anchor volume descriptor pointer: %v", err)
+	}
+	return &anchor{
+		main:    parseExtentAD(b[16:24]),
+		reserve: parseExtentAD(b[24:32]),
+	}, nil
+}
+
+func (a *anchor) toBytes(version uint16, location uint32) []byte {
+	b := make([]byte, volumeDescriptorSize)
+	copy(b[16:24], a.main.toBytes())
+	copy(b[24:32], a.reserve.toBytes())
+	setTag(b, tagAnchorVolumeDescriptorPointer, version, location)
+	return b
+}
+
+// primaryVolumeDescriptor is the primary volume descriptor, which identifies the volume
+type primaryVolumeDescriptor struct {
+	sequenceNumber      uint32
+	volumeIdentifier    string
+	volumeSetIdentifier string
+	recorded            time.Time
+}
+
+func (p *primaryVolumeDescriptor) toBytes(version uint16, location uint32) []byte {
+	b := make([]byte, volumeDescriptorSize)
+	binary.LittleEndian.PutUint32(b[16:20], p.sequenceNumber)
+	copy(b[24:56], dstringBytes(p.volumeIdentifier, 32))
+	// volume sequence number and maximum, interchange level and maximum, and character set lists
+	binary.LittleEndian.PutUint16(b[56:58], 1)
+	binary.LittleEndian.PutUint16(b[58:60], 1)
+	binary.LittleEndian.PutUint16(b[60:62], 2)
+	binary.LittleEndian.PutUint16(b[62:64], 2)
+	binary.LittleEndian.PutUint32(b[64:68], 1)
+	binary.LittleEndian.PutUint32(b[68:72], 1)
+	copy(b[72:200], dstringBytes(p.volumeSetIdentifier, 128))
+	copy(b[200:264], charspecBytes())
+	copy(b[264:328], charspecBytes())
+	copy(b[376:388], timestampBytes(p.recorded))
+	copy(b[388:420], regidBytes(implementationDiskfs, nil))
+	setTag(b, tagPrimaryVolumeDescriptor, version, location)
+	return b
+}
+
+// implementationUseBytes is the implementation use volume descriptor with the logical volume information
+// UDF requires
+func implementationUseBytes(sequenceNumber uint32, label string, revision Revision, version uint16, location uint32) []byte {
+	b := make([]byte, volumeDescriptorSize)
+	binary.LittleEndian.PutUint32(b[16:20], sequenceNumber)
+	copy(b[20:52], regidBytes(entityLVInfo, udfSuffix(revision)))
+	copy(b[52:116], charspecBytes())
+	copy(b[116:244], dstringBytes(label, 128))
+	copy(b[352:384], regidBytes(implementationDiskfs, nil))
+	setTag(b, tagImplementationUseVolume, version, location)
+	return b
+}
+
+// partitionDescriptor describes where a partition of the volume is
+type partitionDescriptor struct {
+	sequenceNumber uint32
+	number         uint16
+	contents       string
+	accessType     uint32
+	start          uint32
+	length         uint32
+}
+
+func parsePartitionDescriptor(b []byte) *partitionDescriptor {
+	contents, _ := parseRegid(b[24:56])
+	return &partitionDescriptor{
+		sequenceNumber: binary.LittleEndian.Uint32(b[16:20]),
+		number:         binary.LittleEndian.Uint16(b[22:24]),
+		contents:       contents,
+		accessType:     binary.LittleEndian.Uint32(b[184:188]),
+		start:          binary.LittleEndian.Uint32(b[188:192]),
+		length:         binary.LittleEndian.Uint32(b[192:196]),
+	}
+}
+
+func (p *partitionDescriptor) toBytes(version uint16, location uint32) []byte {
+	b := make([]byte, volumeDescriptorSize)
+	binary.LittleEndian.PutUint32(b[16:20], p.sequenceNumber)
+	binary.LittleEndian.PutUint16(b[20:22], partitionFlagAllocated)
+	binary.LittleEndian.PutUint16(b[22:24], p.number)
+	copy(b[24:56], regidBytes(p.contents, nil))
+	binary.LittleEndian.PutUint32(b[184:188], p.accessType)
+	binary.LittleEndian.PutUint32(b[188:192], p.start)
+	binary.LittleEndian.PutUint32(b[192:196], p.length)
+	copy(b[196:228], regidBytes(implementationDiskfs, nil))
+	setTag(b, tagPartitionDescriptor, version, location)
+	return b
+}
+
+// partitionMap maps a partition reference number, its index in the logical volume descriptor, to a partition
+type partitionMap struct {
+	kind uint8
+	// identifier of a type 2 partition map, such as that of a metadata partition
+	identifier     string
+	volumeSequence uint16
+	number         uint16
+	// where the metadata file and its mirror are in the partition of a metadata partition
+	metadataFile   uint32
+	metadataMirror uint32
+}
+
+// logicalVolumeDescriptor describes the logical volume, with the partitions it is on and where its file set is
+type logicalVolumeDescriptor struct {
+	sequenceNumber uint32
+	identifier     string
+	blocksize      uint32
+	// revision is the UDF revision of the domain identifier
+	revision      Revision
+	fileSet       longAD
+	integrity     extentAD
+	partitionMaps []partitionMap
+}
+
+func parseLogicalVolumeDescriptor(b []byte) (*logicalVolumeDescriptor, error) {
+	identifier, err := parseDstring(b[84:212])
+	if err != nil {
+		return nil, fmt.Errorf("invalid logical volume identifier: %v", err)
+	}
+	domain, suffix := parseRegid(b[216:248])
+	if domain != domainOSTACompliant {
+		return nil, fmt.Errorf("domain %q is not %q", domain, domainOSTACompliant)
+	}
+	l := &logicalVolumeDescriptor{
+		sequenceNumber: binary.LittleEndian.Uint32(b[16:20]),
+		identifier:     identifier,
+		blocksize:      binary.LittleEndian.Uint32(b[212:216]),
+		revision:       Revision(binary.LittleEndian.Uint16(suffix[0:2])),
+		fileSet:        parseLongAD(b[248:264]),
+		integrity:      parseExtentAD(b[432:440]),
+	}
+	tableLength := int(binary.LittleEndian.Uint32(b[264:268]))
+	count := int(binary.LittleEndian.Uint32(b[268:272]))
+	if 440+tableLength > len(b) {
+		return nil, fmt.Errorf("partition map table of %d bytes overflows descriptor", tableLength)
+	}
+	table := b[440 : 440+tableLength]
+	for i, pos := 0, 0; i < count; i++ {
+		if pos+2 > len(table) || pos+int(table[pos+1]) > len(table) {
+			return nil, fmt.Errorf("partition map %d overflows partition map table", i)
+		}
+		m := table[pos : pos+int(table[pos+1])]
+		pm := partitionMap{kind: m[0]}
+		switch {
+		case pm.kind == partitionMapPhysical && len(m) == partitionMapType1Size:
+			pm.volumeSequence = binary.LittleEndian.Uint16(m[2:4])
+			pm.number = binary.LittleEndian.Uint16(m[4:6])
+		case pm.kind == partitionMapType2 && len(m) == partitionMapType2Size:
+			pm.identifier, _ = parseRegid(m[4:36])
+			pm.volumeSequence = binary.LittleEndian.Uint16(m[36:38])
+			pm.number = binary.LittleEndian.Uint16(m[38:40])
+			pm.metadataFile = binary.LittleEndian.Uint32(m[40:44])
+			pm.metadataMirror = binary.LittleEndian.Uint32(m[44:48])
+		default:
+			return nil, fmt.Errorf("partition map %d of type %d has invalid length %d", i, m[0], len(m))
+		}
+		l.partitionMaps = append(l.partitionMaps, pm)
+		pos += len(m)
+	}
+	return l, nil
+}
+
+// toBytes encodes the descriptor, with a single map of a physical partition
+func (l *logicalVolumeDescriptor) toBytes(version uint16, location uint32) []byte {
+	b := make([]byte, 440+partitionMapType1Size*len(l.partitionMaps))
+	binary.LittleEndian.PutUint32(b[16:20], l.sequenceNumber)
+	copy(b[20:84], charspecBytes())
+	copy(b[84:212], dstringBytes(l.identifier, 128))
+	binary.LittleEndian.PutUint32(b[212:216], l.blocksize)
+	copy(b[216:248], regidBytes(domainOSTACompliant, udfSuffix(l.revision)))
+	copy(b[248:264], l.fileSet.toBytes())
+	binary.LittleEndian.PutUint32(b[264:268], uint32(partitionMapType1Size*len(l.partitionMaps)))
+	binary.LittleEndian.PutUint32(b[268:272], uint32(len(l.partitionMaps)))
+	copy(b[272:304], regidBytes(implementationDiskfs, nil))
+	copy(b[432:440], l.integrity.toBytes())
+	for i, pm := range l.partitionMaps {
+		m := b[440+i*partitionMapType1Size:]
+		m[0] = partitionMapPhysical
+		m[1] = partitionMapType1Size
+		binary.LittleEndian.PutUint16(m[2:4], pm.volumeSequence)
+		binary.LittleEndian.PutUint16(m[4:6], pm.number)
+	}
+	setTag(b, tagLogicalVolumeDescriptor, version, location)
+	return b
+}
+
+// unallocatedSpaceBytes is the unallocated space descriptor of a volume with no unallocated space
+func unallocatedSpaceBytes(sequenceNumber uint32, version uint16, location uint32) []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[16:20], sequenceNumber)
+	setTag(b, tagUnallocatedSpaceDescriptor, version, location)
+	return b
+}
+
+// terminatingBytes is the terminating descriptor, which ends a sequence of descriptors
+func terminatingBytes(version uint16, location uint32) []byte {
+	b := make([]byte, volumeDescriptorSize)
+	setTag(b, tagTerminatingDescriptor, version, location)
+	return b
+}
+
+// integrityDescriptor is the logical volume integrity descriptor, with how much of the volume is used and, from
+// its implementation use, how many files and directories it has
+type integrityDescriptor struct {
+	recorded     time.Time
+	integrity    uint32
+	nextUniqueID uint64
+	freeSpace    []uint32
+	sizes        []uint32
+	files        uint32
+	directories  uint32
+	minRead      Revision
+	minWrite     Revision
+	maxWrite     Revision
+}
+
+func parseIntegrityDescriptor(b []byte) (*integrityDescriptor, error) {
+	partitions := int(binary.LittleEndian.Uint32(b[72:76]))
+	implUseLength := int(binary.LittleEndian.Uint32(b[76:80]))
+	implUse := 80 + 8*partitions
+	if partitions > (len(b)-80)/8 || implUse+implUseLength > len(b) {
+		return nil, fmt.Errorf("tables of %d partitions and %d bytes of implementation use overflow descriptor", partitions, implUseLength)
+	}
+	l := &integrityDescriptor{
+		recorded:     parseTimestamp(b[16:28]),
+		integrity:    binary.LittleEndian.Uint32(b[28:32]),
+		nextUniqueID: binary.LittleEndian.Uint64(b[40:48]),
+	}
+	for i := 0; i < partitions; i++ {
+		l.freeSpace = append(l.freeSpace, binary.LittleEndian.Uint32(b[80+4*i:]))
+		l.sizes = append(l.sizes, binary.LittleEndian.Uint32(b[80+4*partitions+4*i:]))
+	}
+	if implUseLength >= integrityImplUseSize {
+		u := b[implUse:]
+		l.files = binary.LittleEndian.Uint32(u[32:36])
+		l.directories = binary.LittleEndian.Uint32(u[36:40])
+		l.minRead = Revision(binary.LittleEndian.Uint16(u[40:42]))
+		l.minWrite = Revision(binary.LittleEndian.Uint16(u[42:44]))
+		l.maxWrite = Revision(binary.LittleEndian.Uint16(u[44:46]))
+	}
+	return l, nil
+}
+
+func (l *integrityDescriptor) toBytes(version uint16, location uint32) []byte {
+	partitions := len(l.sizes)
+	b := make([]byte, 80+8*partitions+integrityImplUseSize)
+	copy(b[16:28], timestampBytes(l.recorded))
+	binary.LittleEndian.PutUint32(b[28:32], l.integrity)
+	binary.LittleEndian.PutUint64(b[40:48], l.nextUniqueID)
+	binary.LittleEndian.PutUint32(b[72:76], uint32(partitions))
+	binary.LittleEndian.PutUint32(b[76:80], integrityImplUseSize)
+	for i := 0; i < partitions; i++ {
+		binary.LittleEndian.PutUint32(b[80+4*i:], l.freeSpace[i])
+		binary.LittleEndian.PutUint32(b[80+4*partitions+4*i:], l.sizes[i])
+	}
+	u := b[80+8*partitions:]
+	copy(u[0:32], regidBytes(implementationDiskfs, nil))
+	binary.LittleEndian.PutUint32(u[32:36], l.files)
+	binary.LittleEndian.PutUint32(u[36:40], l.directories)
+	binary.LittleEndian.PutUint16(u[40:42], uint16(l.minRead))
+	binary.LittleEndian.PutUint16(u[42:44], uint16(l.minWrite))
+	binary.LittleEndian.PutUint16(u[44:46], uint16(l.maxWrite))
+	setTag(b, tagLogicalVolumeIntegrity, version, location)
+	return b
+}
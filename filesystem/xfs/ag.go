@@ -0,0 +1,54 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	agiMagic uint32 = 0x58414749 // "XAGI"
+	// agiSector is the sector of each allocation group with its inode header, after the superblock and the
+	// free space header
+	agiSector = 2
+	// agiSize is how much of the inode header there is to read without checksums; with them, it is all of its
+	// sector, which they cover
+	agiSize = 296
+)
+
+// agi is the inode header of an allocation group, with how many inodes it has
+type agi struct {
+	count     uint32
+	freeCount uint32
+}
+
+// readAGI reads the inode header of allocation group ag
+func (fs *FileSystem) readAGI(ag uint32) (*agi, error) {
+	sb := fs.superblock
+	b := make([]byte, agiSize)
+	if sb.hasCRC() {
+		b = make([]byte, sb.sectorSize)
+	}
+	pos := int64(ag)*int64(sb.agBlocks)<<sb.blockLog + agiSector*int64(sb.sectorSize)
+	if err := fs.readFull(b, pos); err != nil {
+		return nil, fmt.Errorf("unable to read inode header of allocation group %d: %v", ag, err)
+	}
+	if magic := binary.BigEndian.Uint32(b[0:4]); magic != agiMagic {
+		return nil, fmt.Errorf("inode header of allocation group %d had magic of %#08x instead of expected %#08x", ag, magic, agiMagic)
+	}
+	if seq := binary.BigEndian.Uint32(b[8:12]); seq != ag {
+		return nil, fmt.Errorf("inode header of allocation group %d says it is for %d", ag, seq)
+	}
+	if sb.hasCRC() {
+		if err := verifyCRC(b, 312); err != nil {
+			return nil, fmt.Errorf("inode header of allocation group %d: %v", ag, err)
+		}
+		if !bytes.Equal(b[296:312], sb.metaUUID[:]) {
+			return nil, fmt.Errorf("inode header of allocation group %d has UUID %x, which does not match the superblock", ag, b[296:312])
+		}
+	}
+	return &agi{
+		count:     binary.BigEndian.Uint32(b[16:20]),
+		freeCount: binary.BigEndian.Uint32(b[28:32]),
+	}, nil
+}
@@ -0,0 +1,165 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// directory blocks, of which a directory has one with both its entries and their hash index while it fits,
+// and then data blocks with the entries, followed by leaf blocks with the index and, for a node directory, an
+// index of those and free space blocks
+const (
+	dirBlockMagic    uint32 = 0x58443242 // "XD2B"
+	dirBlockMagicV3  uint32 = 0x58444233 // "XDB3"
+	dirDataMagic     uint32 = 0x58443244 // "XD2D"
+	dirDataMagicV3   uint32 = 0x58444433 // "XDD3"
+	dirHeaderSize           = 16
+	dirHeaderSizeV3         = 64
+	dirBlockTailSize        = 8
+	dirLeafEntrySize        = 8
+	// dirLeafOffset is where the leaf blocks of a directory start, after all of its data blocks
+	dirLeafOffset uint64 = 32 * uint64(GB)
+	// dirFreeTag marks an unused range in a data block
+	dirFreeTag uint16 = 0xffff
+	// dirEntryAlign is what each entry in a data block is aligned to
+	dirEntryAlign = 8
+)
+
+// dirEntry is an entry in a directory
+type dirEntry struct {
+	name  string
+	inode uint64
+}
+
+// parseShortformDir parses the entries of a directory that fit in its inode, after its header with the count
+// of entries, whether they have 4 or 8 byte inode numbers and the inode of its parent
+func (fs *FileSystem) parseShortformDir(b []byte) ([]dirEntry, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("short form directory of %d bytes is too small", len(b))
+	}
+	count := int(b[0])
+	inodeSize := 4
+	if b[1] != 0 {
+		inodeSize = 8
+	}
+	pos := 2 + inodeSize
+	typeSize := 0
+	if fs.superblock.fileType {
+		typeSize = 1
+	}
+	entries := make([]dirEntry, 0, count)
+	for i := 0; i < count; i++ {
+		if pos+3 > len(b) {
+			return nil, fmt.Errorf("entry %d overflows the short form directory", i)
+		}
+		nameLen := int(b[pos])
+		// after the length is the offset the entry would have in a data block
+		name := pos + 3
+		number := name + nameLen + typeSize
+		if number+inodeSize > len(b) {
+			return nil, fmt.Errorf("entry %d overflows the short form directory", i)
+		}
+		entry := dirEntry{name: string(b[name : name+nameLen])}
+		if inodeSize == 4 {
+			entry.inode = uint64(binary.BigEndian.Uint32(b[number:]))
+		} else {
+			entry.inode = binary.BigEndian.Uint64(b[number:])
+		}
+		entries = append(entries, entry)
+		pos = number + inodeSize
+	}
+	return entries, nil
+}
+
+// parseDirBlock parses the entries of directory block b of dir, at filesystem block fsblock, which is either
+// the single block of the directory or one of its data blocks. The entries . and .. are left out.
+func (fs *FileSystem) parseDirBlock(b []byte, fsblock, dir uint64) ([]dirEntry, error) {
+	headerSize := dirHeaderSize
+	blockMagic, dataMagic := dirBlockMagic, dirDataMagic
+	if fs.superblock.hasCRC() {
+		headerSize = dirHeaderSizeV3
+		blockMagic, dataMagic = dirBlockMagicV3, dirDataMagicV3
+	}
+	end := len(b)
+	switch magic := binary.BigEndian.Uint32(b[0:4]); magic {
+	case blockMagic:
+		// the hash index and its count are at the end
+		count := int(binary.BigEndian.Uint32(b[len(b)-dirBlockTailSize:]))
+		end = len(b) - dirBlockTailSize - count*dirLeafEntrySize
+		if end < headerSize {
+			return nil, fmt.Errorf("%d index entries overflow directory block", count)
+		}
+	case dataMagic:
+	default:
+		return nil, fmt.Errorf("directory block had magic of %#08x instead of expected %#08x or %#08x", magic, blockMagic, dataMagic)
+	}
+	if fs.superblock.hasCRC() {
+		if err := fs.verifyOwner(b, 4, 24, 40, dir); err != nil {
+			return nil, fmt.Errorf("invalid directory block: %v", err)
+		}
+		if actual, expected := binary.BigEndian.Uint64(b[8:16]), uint64(fs.superblock.blockOffset(fsblock)/512); actual != expected {
+			return nil, fmt.Errorf("directory block says it is at sector %d instead of %d", actual, expected)
+		}
+	}
+	typeSize := 0
+	if fs.superblock.fileType {
+		typeSize = 1
+	}
+	var entries []dirEntry
+	for pos := headerSize; pos < end; {
+		if pos+dirEntryAlign > end {
+			return nil, fmt.Errorf("entry at %d overflows directory block", pos)
+		}
+		if binary.BigEndian.Uint16(b[pos:pos+2]) == dirFreeTag {
+			size := int(binary.BigEndian.Uint16(b[pos+2 : pos+4]))
+			if size == 0 || size%dirEntryAlign != 0 || pos+size > end {
+				return nil, fmt.Errorf("unused space at %d of %d bytes is invalid", pos, size)
+			}
+			pos += size
+			continue
+		}
+		nameLen := int(b[pos+8])
+		// the inode number, name length, name, file type and tag, which is where the entry is
+		size := (8 + 1 + nameLen + typeSize + 2 + dirEntryAlign - 1) / dirEntryAlign * dirEntryAlign
+		if nameLen == 0 || pos+size > end {
+			return nil, fmt.Errorf("entry at %d with name of %d bytes overflows directory block", pos, nameLen)
+		}
+		name := string(b[pos+9 : pos+9+nameLen])
+		if name != "." && name != ".." {
+			entries = append(entries, dirEntry{name: name, inode: binary.BigEndian.Uint64(b[pos : pos+8])})
+		}
+		pos += size
+	}
+	return entries, nil
+}
+
+// readDirEntries returns the entries of directory dir, without . and ..
+func (fs *FileSystem) readDirEntries(dir *inode) ([]dirEntry, error) {
+	if dir.format == formatLocal {
+		return fs.parseShortformDir(dir.dataFork)
+	}
+	extents, err := fs.extents(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirBlockSize := uint64(fs.superblock.dirBlockSize())
+	blocksPerDirBlock := uint64(1) << fs.superblock.dirBlockLog
+	leafBlock := dirLeafOffset >> fs.superblock.blockLog
+	b := make([]byte, dirBlockSize)
+	var entries []dirEntry
+	// each directory block that starts in an extent before the leaf blocks
+	for _, e := range extents {
+		first := (e.offset + blocksPerDirBlock - 1) &^ (blocksPerDirBlock - 1)
+		for block := first; block < e.offset+e.count && block < leafBlock; block += blocksPerDirBlock {
+			if err := fs.readData(b, block<<fs.superblock.blockLog, extents); err != nil {
+				return nil, fmt.Errorf("unable to read directory block %d: %v", block, err)
+			}
+			blockEntries, err := fs.parseDirBlock(b, e.block+block-e.offset, dir.number)
+			if err != nil {
+				return nil, fmt.Errorf("invalid directory block %d: %v", block, err)
+			}
+			entries = append(entries, blockEntries...)
+		}
+	}
+	return entries, nil
+}
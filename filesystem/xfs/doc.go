@@ -0,0 +1,15 @@
+// Package xfs provides read-only access to an XFS filesystem on a block device or a disk image, such as the
+// root partition of a RHEL-family image.
+//
+// It reads filesystems of version 4 and of version 5, with checksums, which it verifies. Files may be mapped
+// by extents in their inode or by a btree of them, and may be sparse. Directories may be in the short form
+// that fits in their inode, or in one block, or in the leaf and node forms of larger ones, whose data blocks
+// are read in order. Symlinks are supported; extended attributes, quotas and the realtime device are not, and
+// nothing can be written.
+//
+// references:
+//
+//	https://docs.kernel.org/filesystems/xfs/index.html
+//	https://git.kernel.org/pub/scm/fs/xfs/xfs-documentation.git
+//	https://github.com/torvalds/linux/tree/master/fs/xfs/libxfs
+package xfs
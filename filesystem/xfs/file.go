@@ -0,0 +1,141 @@
+package xfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in an XFS filesystem
+type File struct {
+	inode      *inode
+	extents    []extent
+	offset     int64
+	filesystem *FileSystem
+}
+
+// newFile creates a File to read the data of an inode, from its extents
+func (fs *FileSystem) newFile(in *inode) (*File, error) {
+	if in.flags&inodeFlagRealtime != 0 {
+		return nil, fmt.Errorf("inode %d has its data on the realtime device, which is not supported", in.number)
+	}
+	extents, err := fs.extents(in)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read extents of inode %d: %v", in.number, err)
+	}
+	return &File{
+		inode:      in,
+		extents:    extents,
+		filesystem: fs,
+	}, nil
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	size := int64(fl.inode.size)
+	if fl.offset >= size {
+		return 0, io.EOF
+	}
+	if remaining := size - fl.offset; remaining < int64(len(b)) {
+		b = b[:remaining]
+	}
+	if err := fl.filesystem.readData(b, uint64(fl.offset), fl.extents); err != nil {
+		return 0, fmt.Errorf("unable to read inode %d: %v", fl.inode.number, err)
+	}
+	fl.offset += int64(len(b))
+	var retErr error
+	if fl.offset >= size {
+		retErr = io.EOF
+	}
+	return len(b), retErr
+}
+
+// readData reads all of b from off in the data that extents map, where anything they do not map, or that is
+// unwritten, reads as zeros
+func (fs *FileSystem) readData(b []byte, off uint64, extents []extent) error {
+	blockLog := fs.superblock.blockLog
+	for len(b) > 0 {
+		block := off >> blockLog
+		// the last extent that starts at or before block
+		i := sort.Search(len(extents), func(i int) bool {
+			return extents[i].offset > block
+		}) - 1
+		var n uint64
+		switch {
+		case i < 0 || block >= extents[i].offset+extents[i].count:
+			// a hole, up to the next extent
+			n = uint64(len(b))
+			if i+1 < len(extents) && extents[i+1].offset<<blockLog-off < n {
+				n = extents[i+1].offset<<blockLog - off
+			}
+			zero(b[:n])
+		default:
+			e := extents[i]
+			n = (e.offset+e.count)<<blockLog - off
+			if n > uint64(len(b)) {
+				n = uint64(len(b))
+			}
+			if e.unwritten {
+				zero(b[:n])
+				break
+			}
+			pos := fs.superblock.blockOffset(e.block+block-e.offset) + int64(off&(1<<blockLog-1))
+			if err := fs.readFull(b[:n], pos); err != nil {
+				return err
+			}
+		}
+		b = b[n:]
+		off += n
+	}
+	return nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Write writes len(b) bytes to the File.
+//
+//	you cannot write to an XFS filesystem, so this returns an error
+func (fl *File) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("cannot write to a read-only XFS filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = int64(fl.inode.size) + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.filesystem = nil
+	return nil
+}
@@ -0,0 +1,307 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	inodeMagic uint16 = 0x494e // "IN"
+	// inodeCoreSize is the size of an inode before its data fork, for versions 1 and 2 and for version 3
+	inodeCoreSize   = 100
+	inodeCoreSizeV3 = 176
+	// extentSize is the size of an extent record, in an inode or a leaf of a bmap btree
+	extentSize = 16
+)
+
+// formats of the data fork of an inode
+const (
+	formatDev     uint8 = 0
+	formatLocal   uint8 = 1
+	formatExtents uint8 = 2
+	formatBtree   uint8 = 3
+)
+
+const (
+	// inodeFlagRealtime is the flag of an inode whose data is on the realtime device
+	inodeFlagRealtime uint16 = 0x1
+	// inodeFlag2BigTime is the flag of an inode whose times are 64-bit counts of nanoseconds
+	inodeFlag2BigTime uint64 = 0x8
+	// inodeFlag2NRExt64 is the flag of an inode whose count of data extents is 64 bits
+	inodeFlag2NRExt64 uint64 = 0x10
+)
+
+// bigTimeEpochOffset is how many seconds before the unix epoch a big time counts from, so that it can hold
+// every time a 32-bit count of seconds could
+const bigTimeEpochOffset = 1 << 31
+
+// inode is an inode with the data of its data fork
+type inode struct {
+	number     uint64
+	mode       uint16
+	format     uint8
+	nlink      uint32
+	uid        uint32
+	gid        uint32
+	size       uint64
+	flags      uint16
+	accessTime time.Time
+	modTime    time.Time
+	changeTime time.Time
+	createTime time.Time
+	// extentCount is how many extents are in an extents data fork
+	extentCount uint64
+	// dataFork is the data of a local data fork, the extents of an extents one or the root of a btree one
+	dataFork []byte
+}
+
+// parseInode parses inode number from b, which is all of it
+func (fs *FileSystem) parseInode(b []byte, number uint64) (*inode, error) {
+	if magic := binary.BigEndian.Uint16(b[0:2]); magic != inodeMagic {
+		return nil, fmt.Errorf("inode had magic of %#04x instead of expected %#04x", magic, inodeMagic)
+	}
+	version := b[4]
+	coreSize := inodeCoreSize
+	var flags2 uint64
+	switch version {
+	case 1, 2:
+		if fs.superblock.hasCRC() {
+			return nil, fmt.Errorf("inode is version %d on a filesystem with checksums", version)
+		}
+	case 3:
+		if !fs.superblock.hasCRC() {
+			return nil, fmt.Errorf("inode is version 3 on a filesystem without checksums")
+		}
+		if err := verifyCRC(b, 100); err != nil {
+			return nil, err
+		}
+		if actual := binary.BigEndian.Uint64(b[152:160]); actual != number {
+			return nil, fmt.Errorf("inode says it is number %d", actual)
+		}
+		if !bytes.Equal(b[160:176], fs.superblock.metaUUID[:]) {
+			return nil, fmt.Errorf("inode UUID %x does not match the superblock", b[160:176])
+		}
+		coreSize = inodeCoreSizeV3
+		flags2 = binary.BigEndian.Uint64(b[120:128])
+	default:
+		return nil, fmt.Errorf("unknown inode version %d", version)
+	}
+	ts := func(offset int) time.Time {
+		if flags2&inodeFlag2BigTime != 0 {
+			ns := binary.BigEndian.Uint64(b[offset : offset+8])
+			return time.Unix(int64(ns/1e9)-bigTimeEpochOffset, int64(ns%1e9)).UTC()
+		}
+		return time.Unix(int64(int32(binary.BigEndian.Uint32(b[offset:offset+4]))), int64(binary.BigEndian.Uint32(b[offset+4:offset+8]))).UTC()
+	}
+	in := &inode{
+		number:     number,
+		mode:       binary.BigEndian.Uint16(b[2:4]),
+		format:     b[5],
+		nlink:      binary.BigEndian.Uint32(b[16:20]),
+		uid:        binary.BigEndian.Uint32(b[8:12]),
+		gid:        binary.BigEndian.Uint32(b[12:16]),
+		accessTime: ts(32),
+		modTime:    ts(40),
+		changeTime: ts(48),
+		size:       binary.BigEndian.Uint64(b[56:64]),
+		flags:      binary.BigEndian.Uint16(b[90:92]),
+	}
+	if version == 1 {
+		in.nlink = uint32(binary.BigEndian.Uint16(b[6:8]))
+	}
+	if version == 3 {
+		in.createTime = ts(144)
+	}
+	if flags2&inodeFlag2NRExt64 != 0 {
+		in.extentCount = binary.BigEndian.Uint64(b[24:32])
+	} else {
+		in.extentCount = uint64(binary.BigEndian.Uint32(b[76:80]))
+	}
+	// the attribute fork, if there is one, starts at forkOffset 8-byte words after the core
+	forkEnd := len(b)
+	if forkOffset := int(b[82]) * 8; forkOffset > 0 {
+		forkEnd = coreSize + forkOffset
+		if forkEnd > len(b) {
+			return nil, fmt.Errorf("attribute fork at %d is past the end of the inode", forkOffset)
+		}
+	}
+	in.dataFork = b[coreSize:forkEnd]
+	switch in.format {
+	case formatDev, formatBtree:
+	case formatLocal:
+		if in.size > uint64(len(in.dataFork)) {
+			return nil, fmt.Errorf("local data of %d bytes is more than its fork of %d", in.size, len(in.dataFork))
+		}
+		in.dataFork = in.dataFork[:in.size]
+	case formatExtents:
+		if in.extentCount*extentSize > uint64(len(in.dataFork)) {
+			return nil, fmt.Errorf("%d extents overflow the data fork of %d bytes", in.extentCount, len(in.dataFork))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data fork format %d", in.format)
+	}
+	return in, nil
+}
+
+func (in *inode) isDir() bool {
+	return in.mode&modeTypeMask == modeDirectory
+}
+
+func (in *inode) isSymlink() bool {
+	return in.mode&modeTypeMask == modeSymlink
+}
+
+// rdev is the device number of a block or character device
+func (in *inode) rdev() uint32 {
+	if in.format != formatDev || len(in.dataFork) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(in.dataFork[0:4])
+}
+
+// extent maps blocks of a file to blocks of the filesystem
+type extent struct {
+	// offset is the first block of the file in the extent
+	offset uint64
+	// block is the first filesystem block of the extent, with its allocation group in its high bits
+	block uint64
+	count uint64
+	// unwritten says whether the extent is allocated but not yet written, so reads as zeros
+	unwritten bool
+}
+
+// parseExtent parses a packed extent record: a bit for whether it is unwritten, then 54 bits of file offset,
+// 52 of filesystem block and 21 of count
+func parseExtent(b []byte) extent {
+	l0 := binary.BigEndian.Uint64(b[0:8])
+	l1 := binary.BigEndian.Uint64(b[8:16])
+	return extent{
+		unwritten: l0>>63 != 0,
+		offset:    (l0 & (1<<63 - 1)) >> 9,
+		block:     (l0&(1<<9-1))<<43 | l1>>21,
+		count:     l1 & (1<<21 - 1),
+	}
+}
+
+func parseExtents(b []byte, count int) []extent {
+	extents := make([]extent, count)
+	for i := range extents {
+		extents[i] = parseExtent(b[i*extentSize:])
+	}
+	return extents
+}
+
+// bmap btree blocks
+const (
+	bmapMagic   uint32 = 0x424d4150 // "BMAP"
+	bmapMagicV3 uint32 = 0x424d4133 // "BMA3"
+	// bmapHeaderSize is the size of the header of a bmap btree block, without and with checksums
+	bmapHeaderSize   = 24
+	bmapHeaderSizeV3 = 72
+	// bmapRootHeaderSize is the size of the header of the root of a bmap btree in an inode
+	bmapRootHeaderSize = 4
+	// bmapKeySize and bmapPtrSize are the sizes of the key and pointer to each block below an internal block
+	bmapKeySize = 8
+	bmapPtrSize = 8
+	// maxBmapLevel is how deep a bmap btree can be, counting leaves as level 0
+	maxBmapLevel = 9
+)
+
+// extents returns the extents of the data of an inode, in order
+func (fs *FileSystem) extents(in *inode) ([]extent, error) {
+	var extents []extent
+	switch in.format {
+	case formatExtents:
+		extents = parseExtents(in.dataFork, int(in.extentCount))
+	case formatBtree:
+		// the root, whose keys and pointers are each at the start of their half of the fork
+		b := in.dataFork
+		if len(b) < bmapRootHeaderSize {
+			return nil, fmt.Errorf("bmap btree root of %d bytes is too small", len(b))
+		}
+		level := binary.BigEndian.Uint16(b[0:2])
+		count := int(binary.BigEndian.Uint16(b[2:4]))
+		maxRecords := (len(b) - bmapRootHeaderSize) / (bmapKeySize + bmapPtrSize)
+		if level == 0 || level > maxBmapLevel || count > maxRecords {
+			return nil, fmt.Errorf("bmap btree root at level %d with %d pointers is invalid", level, count)
+		}
+		ptrs := b[bmapRootHeaderSize+maxRecords*bmapKeySize:]
+		for i := 0; i < count; i++ {
+			e, err := fs.readBmapBlock(binary.BigEndian.Uint64(ptrs[i*bmapPtrSize:]), level-1, in.number)
+			if err != nil {
+				return nil, err
+			}
+			extents = append(extents, e...)
+		}
+	default:
+		return nil, fmt.Errorf("inode %d has no extents in data fork of format %d", in.number, in.format)
+	}
+	if !sort.SliceIsSorted(extents, func(i, j int) bool { return extents[i].offset < extents[j].offset }) {
+		return nil, fmt.Errorf("extents of inode %d are not in order", in.number)
+	}
+	return extents, nil
+}
+
+// readBmapBlock returns the extents in the bmap btree block at fsblock, which is at level, and in those below it
+func (fs *FileSystem) readBmapBlock(fsblock uint64, level uint16, owner uint64) ([]extent, error) {
+	b := make([]byte, fs.superblock.blockSize)
+	if err := fs.readFull(b, fs.superblock.blockOffset(fsblock)); err != nil {
+		return nil, fmt.Errorf("unable to read bmap btree block %d: %v", fsblock, err)
+	}
+	headerSize := bmapHeaderSize
+	magic := bmapMagic
+	if fs.superblock.hasCRC() {
+		headerSize = bmapHeaderSizeV3
+		magic = bmapMagicV3
+	}
+	if actual := binary.BigEndian.Uint32(b[0:4]); actual != magic {
+		return nil, fmt.Errorf("bmap btree block %d had magic of %#08x instead of expected %#08x", fsblock, actual, magic)
+	}
+	if fs.superblock.hasCRC() {
+		if err := fs.verifyOwner(b, 64, 40, 56, owner); err != nil {
+			return nil, fmt.Errorf("invalid bmap btree block %d: %v", fsblock, err)
+		}
+	}
+	if actual := binary.BigEndian.Uint16(b[4:6]); actual != level {
+		return nil, fmt.Errorf("bmap btree block %d is at level %d instead of expected %d", fsblock, actual, level)
+	}
+	count := int(binary.BigEndian.Uint16(b[6:8]))
+	if level == 0 {
+		if headerSize+count*extentSize > len(b) {
+			return nil, fmt.Errorf("%d extents overflow bmap btree block %d", count, fsblock)
+		}
+		return parseExtents(b[headerSize:], count), nil
+	}
+	maxRecords := (len(b) - headerSize) / (bmapKeySize + bmapPtrSize)
+	if count > maxRecords {
+		return nil, fmt.Errorf("%d pointers overflow bmap btree block %d", count, fsblock)
+	}
+	ptrs := b[headerSize+maxRecords*bmapKeySize:]
+	var extents []extent
+	for i := 0; i < count; i++ {
+		e, err := fs.readBmapBlock(binary.BigEndian.Uint64(ptrs[i*bmapPtrSize:]), level-1, owner)
+		if err != nil {
+			return nil, err
+		}
+		extents = append(extents, e...)
+	}
+	return extents, nil
+}
+
+// verifyOwner checks the checksum of metadata b with checksums, at crcOffset, and that its UUID at uuidOffset
+// is the filesystem's and its owner at ownerOffset is owner
+func (fs *FileSystem) verifyOwner(b []byte, crcOffset, uuidOffset, ownerOffset int, owner uint64) error {
+	if err := verifyCRC(b, crcOffset); err != nil {
+		return err
+	}
+	if !bytes.Equal(b[uuidOffset:uuidOffset+16], fs.superblock.metaUUID[:]) {
+		return fmt.Errorf("UUID %x does not match the superblock", b[uuidOffset:uuidOffset+16])
+	}
+	if actual := binary.BigEndian.Uint64(b[ownerOffset : ownerOffset+8]); actual != owner {
+		return fmt.Errorf("owner is inode %d instead of %d", actual, owner)
+	}
+	return nil
+}
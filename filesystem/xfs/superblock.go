@@ -0,0 +1,176 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	superblockMagic uint32 = 0x58465342 // "XFSB"
+	// superblockSize is how much of the superblock there is to read; the rest of its sector is zeros, but is
+	// covered by its checksum
+	superblockSize = 512
+	labelSize      = 12
+	minBlockSize   = 512
+	maxBlockSize   = 64 * 1024
+	minSectorSize  = 512
+	maxSectorSize  = 32 * 1024
+	minInodeSize   = 256
+	maxInodeSize   = 2048
+	// maxDirBlockSize is the largest a directory block, of one or more filesystem blocks, can be
+	maxDirBlockSize = 64 * 1024
+)
+
+// bits of the version number of a version 4 filesystem; higher versions have every feature that these turn on
+const (
+	versionNumberMask uint16 = 0x000f
+	versionDirV2Bit   uint16 = 0x2000
+	versionMoreBits   uint16 = 0x8000
+)
+
+const (
+	// version2FileType is the bit of features2 of a version 4 filesystem that says directory entries have the
+	// type of their file
+	version2FileType uint32 = 0x200
+)
+
+// incompatible features of a version 5 filesystem, which a reader must know to read it
+const (
+	featureIncompatFileType    uint32 = 0x1
+	featureIncompatSparseInode uint32 = 0x2
+	featureIncompatMetaUUID    uint32 = 0x4
+	featureIncompatBigTime     uint32 = 0x8
+	featureIncompatNeedsRepair uint32 = 0x10
+	featureIncompatNRExt64     uint32 = 0x20
+	featureIncompatExchRange   uint32 = 0x40
+	featureIncompatParent      uint32 = 0x80
+)
+
+// featureIncompatSupported are the incompatible features this package can read. featureIncompatNeedsRepair is
+// not, as it means the filesystem is known to be damaged.
+const featureIncompatSupported = featureIncompatFileType | featureIncompatSparseInode | featureIncompatMetaUUID |
+	featureIncompatBigTime | featureIncompatNRExt64 | featureIncompatExchRange | featureIncompatParent
+
+type superblock struct {
+	blockSize  uint32
+	dataBlocks uint64
+	// metaUUID is the UUID in all of the metadata, which is the UUID of the filesystem unless it has changed
+	// since it was created
+	metaUUID          uuid.UUID
+	rootInode         uint64
+	agBlocks          uint32
+	agCount           uint32
+	version           uint16
+	sectorSize        uint16
+	inodeSize         uint16
+	label             string
+	blockLog          uint8
+	inodesPerBlockLog uint8
+	agBlockLog        uint8
+	dirBlockLog       uint8
+	incompat          uint32
+	// fileType says whether directory entries have the type of their file
+	fileType bool
+}
+
+// parseSuperblock parses the superblock from b, which is its whole sector
+func parseSuperblock(b []byte) (*superblock, error) {
+	if len(b) < superblockSize {
+		return nil, fmt.Errorf("superblock was %d bytes instead of at least %d", len(b), superblockSize)
+	}
+	if magic := binary.BigEndian.Uint32(b[0:4]); magic != superblockMagic {
+		return nil, fmt.Errorf("superblock had magic of %#08x instead of expected %#08x", magic, superblockMagic)
+	}
+	versionNumber := binary.BigEndian.Uint16(b[100:102])
+	s := &superblock{
+		blockSize:   binary.BigEndian.Uint32(b[4:8]),
+		dataBlocks:  binary.BigEndian.Uint64(b[8:16]),
+		rootInode:   binary.BigEndian.Uint64(b[56:64]),
+		agBlocks:    binary.BigEndian.Uint32(b[84:88]),
+		agCount:     binary.BigEndian.Uint32(b[88:92]),
+		version:     versionNumber & versionNumberMask,
+		sectorSize:  binary.BigEndian.Uint16(b[102:104]),
+		inodeSize:   binary.BigEndian.Uint16(b[104:106]),
+		label:       strings.TrimRight(string(b[108:108+labelSize]), "\x00"),
+		blockLog:    b[120],
+		agBlockLog:  b[124],
+		dirBlockLog: b[192],
+	}
+	copy(s.metaUUID[:], b[32:48])
+	features2 := binary.BigEndian.Uint32(b[200:204])
+	switch s.version {
+	case 4:
+		if versionNumber&versionDirV2Bit == 0 {
+			return nil, fmt.Errorf("version 1 directories are not supported")
+		}
+		s.fileType = versionNumber&versionMoreBits != 0 && features2&version2FileType != 0
+	case 5:
+		if len(b) < int(s.sectorSize) {
+			return nil, fmt.Errorf("superblock was %d bytes, less than its sector of %d", len(b), s.sectorSize)
+		}
+		if err := verifyCRC(b[:s.sectorSize], 224); err != nil {
+			return nil, fmt.Errorf("superblock: %v", err)
+		}
+		s.incompat = binary.BigEndian.Uint32(b[216:220])
+		if unsupported := s.incompat &^ featureIncompatSupported; unsupported != 0 {
+			return nil, fmt.Errorf("unsupported incompatible features %#x", unsupported)
+		}
+		s.fileType = s.incompat&featureIncompatFileType != 0
+		if s.incompat&featureIncompatMetaUUID != 0 {
+			copy(s.metaUUID[:], b[248:264])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported version %d", s.version)
+	}
+	if b[126] != 0 {
+		return nil, fmt.Errorf("filesystem was not completely created")
+	}
+	if s.blockSize < minBlockSize || s.blockSize > maxBlockSize || s.blockSize != 1<<s.blockLog {
+		return nil, fmt.Errorf("block size %d is not a power of 2 from %d to %d of log %d", s.blockSize, minBlockSize, maxBlockSize, s.blockLog)
+	}
+	if s.sectorSize < minSectorSize || s.sectorSize > maxSectorSize || s.sectorSize != 1<<b[121] {
+		return nil, fmt.Errorf("sector size %d is not a power of 2 from %d to %d of log %d", s.sectorSize, minSectorSize, maxSectorSize, b[121])
+	}
+	if s.inodeSize < minInodeSize || s.inodeSize > maxInodeSize || s.inodeSize != 1<<b[122] || uint32(s.inodeSize) > s.blockSize {
+		return nil, fmt.Errorf("inode size %d is not a power of 2 from %d to %d of log %d, no larger than a block", s.inodeSize, minInodeSize, maxInodeSize, b[122])
+	}
+	s.inodesPerBlockLog = b[123]
+	if s.blockSize>>s.inodesPerBlockLog != uint32(s.inodeSize) {
+		return nil, fmt.Errorf("%d inodes per block of log %d do not fit", s.blockSize/uint32(s.inodeSize), s.inodesPerBlockLog)
+	}
+	if s.agBlocks == 0 || s.agCount == 0 || s.agBlockLog > 31 || uint64(s.agBlocks) > 1<<s.agBlockLog || uint64(s.agBlocks) <= 1<<s.agBlockLog>>1 {
+		return nil, fmt.Errorf("allocation groups of %d blocks do not match their log %d", s.agBlocks, s.agBlockLog)
+	}
+	if s.dataBlocks > uint64(s.agBlocks)*uint64(s.agCount) {
+		return nil, fmt.Errorf("%d blocks are more than %d allocation groups of %d", s.dataBlocks, s.agCount, s.agBlocks)
+	}
+	if s.dirBlockSize() > maxDirBlockSize {
+		return nil, fmt.Errorf("directory blocks of %d bytes are more than the maximum %d", s.dirBlockSize(), maxDirBlockSize)
+	}
+	return s, nil
+}
+
+// hasCRC says whether metadata has checksums, owners and UUIDs, which it does from version 5
+func (s *superblock) hasCRC() bool {
+	return s.version >= 5
+}
+
+func (s *superblock) dirBlockSize() int {
+	return int(s.blockSize) << s.dirBlockLog
+}
+
+// blockOffset is where the block at fsblock, which has the allocation group in its high bits, is
+func (s *superblock) blockOffset(fsblock uint64) int64 {
+	ag := fsblock >> s.agBlockLog
+	block := fsblock & (1<<s.agBlockLog - 1)
+	return int64(ag*uint64(s.agBlocks)+block) << s.blockLog
+}
+
+// inodeOffset is where inode number is, which has its allocation group, block and place in the block
+func (s *superblock) inodeOffset(number uint64) int64 {
+	offset := number & (1<<s.inodesPerBlockLog - 1)
+	return s.blockOffset(number>>s.inodesPerBlockLog) + int64(offset)*int64(s.inodeSize)
+}
@@ -0,0 +1,11 @@
+#!/bin/sh
+# mkxfs.sh image label protofile [mkfs.xfs options]
+#
+# Makes an XFS filesystem in image, which must already have its size, from protofile, with mkfs.xfs -p.
+set -e
+img=$1
+label=$2
+proto=$3
+shift 3
+
+mkfs.xfs -q -f -L "$label" -p "$proto" "$@" "$img"
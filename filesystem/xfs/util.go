@@ -0,0 +1,102 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+)
+
+const (
+	// KB represents one KB
+	KB int64 = 1024
+	// MB represents one MB
+	MB int64 = 1024 * KB
+	// GB represents one GB
+	GB int64 = 1024 * MB
+)
+
+// unix file mode bits, as stored in an inode
+const (
+	modeTypeMask   uint16 = 0o170000
+	modeSocket     uint16 = 0o140000
+	modeSymlink    uint16 = 0o120000
+	modeRegular    uint16 = 0o100000
+	modeBlockDev   uint16 = 0o060000
+	modeDirectory  uint16 = 0o040000
+	modeCharDev    uint16 = 0o020000
+	modeFifo       uint16 = 0o010000
+	modeSetuid     uint16 = 0o4000
+	modeSetgid     uint16 = 0o2000
+	modeSticky     uint16 = 0o1000
+	modePermission uint16 = 0o777
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// verifyCRC checks the crc32c of metadata b, which is stored little-endian at offset and is calculated with
+// zeros in its place
+func verifyCRC(b []byte, offset int) error {
+	expected := binary.LittleEndian.Uint32(b[offset : offset+4])
+	crc := crc32.Update(0, crc32cTable, b[:offset])
+	crc = crc32.Update(crc, crc32cTable, []byte{0, 0, 0, 0})
+	crc = crc32.Update(crc, crc32cTable, b[offset+4:])
+	if crc != expected {
+		return fmt.Errorf("checksum mismatch, expected %#08x but calculated %#08x", expected, crc)
+	}
+	return nil
+}
+
+// fileMode converts a unix mode, as stored in an inode, to an os.FileMode
+func fileMode(m uint16) os.FileMode {
+	mode := os.FileMode(m & modePermission)
+	switch m & modeTypeMask {
+	case modeDirectory:
+		mode |= os.ModeDir
+	case modeSymlink:
+		mode |= os.ModeSymlink
+	case modeBlockDev:
+		mode |= os.ModeDevice
+	case modeCharDev:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case modeFifo:
+		mode |= os.ModeNamedPipe
+	case modeSocket:
+		mode |= os.ModeSocket
+	}
+	if m&modeSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&modeSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&modeSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// decodeDev splits a device number, as XFS stores it, into major and minor the way Linux xfs_to_linux_dev_t
+// does
+func decodeDev(dev uint32) (major, minor uint32) {
+	return (dev >> 18) & 0x1ff, dev & 0x3ffff
+}
+
+func universalizePath(p string) string {
+	// globalize the separator
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+func splitPath(p string) []string {
+	ps := universalizePath(p)
+	parts := strings.Split(ps, "/")
+	// eliminate empty parts
+	ret := make([]string, 0)
+	for _, sub := range parts {
+		if sub != "" && sub != "." {
+			ret = append(ret, sub)
+		}
+	}
+	return ret
+}
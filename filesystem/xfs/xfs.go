@@ -0,0 +1,370 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// maxSymlinkHops is how many symlinks Stat follows before giving up, as Linux does
+	maxSymlinkHops = 40
+	// maxSymlinkLen is the longest target a symlink can have
+	maxSymlinkLen = 1024
+)
+
+// remote symlink blocks, which have a header when the filesystem has checksums
+const (
+	symlinkMagic      uint32 = 0x58534c4d // "XSLM"
+	symlinkHeaderSize        = 56
+)
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	superblock *superblock
+	size       int64
+	start      int64
+	file       util.File
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the util.File where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the util.File the filesystem is expected to begin,
+// and blocksize is is the logical blocksize to use for reading the filesystem
+//
+// note that you are *not* required to read a filesystem on the entire disk. You could have a disk of size
+// 20GB, and a small filesystem of size 50MB that begins 2GB into the disk.
+// This is extremely useful for working with filesystems on disk partitions.
+//
+// Note, however, that it is much easier to do this using the higher-level APIs at github.com/diskfs/go-diskfs
+// which allow you to work directly with partitions, rather than having to calculate (and hopefully not make any errors)
+// where a partition starts and ends.
+//
+// The block and sector sizes are taken from the superblock, so blocksize is only checked: it must be 0, or a
+// power of 2 from 512 to 4096 bytes.
+//
+// The filesystem is read-only: every method that would change it returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func Read(file util.File, size, start, blocksize int64) (*FileSystem, error) {
+	switch blocksize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("blocksize for XFS must be a power of 2 from 512 to 4096 bytes or 0, not %d", blocksize)
+	}
+	fs := &FileSystem{
+		size:  size,
+		start: start,
+		file:  file,
+	}
+	b := make([]byte, superblockSize)
+	if err := fs.readFull(b, 0); err != nil {
+		return nil, fmt.Errorf("unable to read bytes for superblock: %v", err)
+	}
+	// the checksum covers the whole sector of the superblock
+	if sectorSize := int(binary.BigEndian.Uint16(b[102:104])); sectorSize > superblockSize && sectorSize <= maxSectorSize {
+		b = make([]byte, sectorSize)
+		if err := fs.readFull(b, 0); err != nil {
+			return nil, fmt.Errorf("unable to read bytes for superblock: %v", err)
+		}
+	}
+	sb, err := parseSuperblock(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing superblock: %v", err)
+	}
+	if total := int64(sb.dataBlocks) << sb.blockLog; size > 0 && total > size {
+		return nil, fmt.Errorf("filesystem of %d bytes is larger than the %d bytes available", total, size)
+	}
+	fs.superblock = sb
+	root, err := fs.readInode(sb.rootInode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read root directory: %v", err)
+	}
+	if !root.isDir() {
+		return nil, fmt.Errorf("root inode %d is not a directory", sb.rootInode)
+	}
+	return fs, nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeXFS
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeXFS
+}
+
+// Label return the filesystem label
+func (fs *FileSystem) Label() string {
+	return fs.superblock.label
+}
+
+// SetLabel changes the label on the filesystem, but XFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) SetLabel(label string) error {
+	return fmt.Errorf("cannot set label on XFS filesystem: %w", filesystem.ErrReadonlyFilesystem)
+}
+
+// Statfs get the capacity of the filesystem, from its superblock, and how many files it has, from the inode
+// header of each allocation group. As it is read-only, it has no free space.
+func (fs *FileSystem) Statfs() (filesystem.Statfs, error) {
+	var files uint64
+	for ag := uint32(0); ag < fs.superblock.agCount; ag++ {
+		a, err := fs.readAGI(ag)
+		if err != nil {
+			return filesystem.Statfs{}, err
+		}
+		files += uint64(a.count) - uint64(a.freeCount)
+	}
+	total := int64(fs.superblock.dataBlocks) << fs.superblock.blockLog
+	return filesystem.Statfs{
+		BlockSize:  int64(fs.superblock.blockSize),
+		TotalBytes: total,
+		UsedBytes:  total,
+		Files:      files,
+	}, nil
+}
+
+// Mkdir make a directory at the given path, but XFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Mkdir(p string) error {
+	return fmt.Errorf("cannot make directory %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory, in the order they are stored.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	in, err := fs.lookup(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	if !in.isDir() {
+		return nil, fmt.Errorf("cannot read directory %s, which is not a directory", p)
+	}
+	entries, err := fs.readDirEntries(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+	}
+	fi := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		child, err := fs.readInode(e.inode)
+		if err != nil {
+			return nil, fmt.Errorf("error reading inode for %s: %v", e.name, err)
+		}
+		fi = append(fi, newDirectoryEntry(e.name, child))
+	}
+	return fi, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+//
+// accepts normal os.OpenFile flags, but as XFS is read-only, any that would write return an error wrapping
+// filesystem.ErrReadonlyFilesystem
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		return nil, fmt.Errorf("cannot open %s for writing: %w", p, filesystem.ErrReadonlyFilesystem)
+	}
+	in, err := fs.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if in.isDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	if in.mode&modeTypeMask != modeRegular {
+		return nil, fmt.Errorf("cannot open %s, which is not a regular file", p)
+	}
+	return fs.newFile(in)
+}
+
+// Stat returns the FileInfo for a file or directory. If p is a symlink, Stat returns the FileInfo for its target.
+//
+// Returns an error wrapping os.ErrNotExist if it does not exist.
+func (fs *FileSystem) Stat(p string) (os.FileInfo, error) {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		de, err := fs.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !de.inode.isSymlink() {
+			return de, nil
+		}
+		target, err := fs.readlink(de.inode)
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, fmt.Errorf("too many levels of symlinks at %s", p)
+}
+
+// Lstat returns the FileInfo for a file, directory or symlink, without following a symlink at the end of p.
+func (fs *FileSystem) Lstat(p string) (os.FileInfo, error) {
+	return fs.lstat(p)
+}
+
+// Readlink returns the target of a symlink.
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	de, err := fs.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if !de.inode.isSymlink() {
+		return "", fmt.Errorf("%s is not a symlink", p)
+	}
+	return fs.readlink(de.inode)
+}
+
+// Symlink creates newname as a symlink to oldname, but XFS is read-only, so this always returns an error
+// wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return fmt.Errorf("cannot create symlink %s: %w", newname, filesystem.ErrReadonlyFilesystem)
+}
+
+// Remove remove a file or empty directory, but XFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Remove(p string) error {
+	return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// RemoveAll remove a file or directory and everything in it, but XFS is read-only, so this always returns an
+// error wrapping filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) RemoveAll(p string) error {
+	return fmt.Errorf("cannot remove %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// Rename rename a file or directory, but XFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	return fmt.Errorf("cannot rename %s: %w", oldpath, filesystem.ErrReadonlyFilesystem)
+}
+
+// Truncate change the size of a file, but XFS is read-only, so this always returns an error wrapping
+// filesystem.ErrReadonlyFilesystem.
+func (fs *FileSystem) Truncate(p string, size int64) error {
+	return fmt.Errorf("cannot truncate %s: %w", p, filesystem.ErrReadonlyFilesystem)
+}
+
+// lstat finds the directory entry for p, without following a symlink at the end of it
+func (fs *FileSystem) lstat(p string) (*directoryEntry, error) {
+	in, err := fs.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(path.Join("/", universalizePath(p)))
+	return newDirectoryEntry(name, in), nil
+}
+
+// lookup finds the inode at p from the root directory, by the names in each directory
+func (fs *FileSystem) lookup(p string) (*inode, error) {
+	in, err := fs.readInode(fs.superblock.rootInode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read root directory: %v", err)
+	}
+	name := "/"
+	for _, part := range splitPath(p) {
+		if !in.isDir() {
+			return nil, fmt.Errorf("%s in path %s is not a directory", name, p)
+		}
+		entries, err := fs.readDirEntries(in)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %v", name, err)
+		}
+		var number uint64
+		for _, e := range entries {
+			if e.name == part {
+				number = e.inode
+				break
+			}
+		}
+		if number == 0 {
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, os.ErrNotExist)
+		}
+		if in, err = fs.readInode(number); err != nil {
+			return nil, fmt.Errorf("error finding %s: %v", p, err)
+		}
+		name = part
+	}
+	return in, nil
+}
+
+// readInode reads inode number
+func (fs *FileSystem) readInode(number uint64) (*inode, error) {
+	b := make([]byte, fs.superblock.inodeSize)
+	if err := fs.readFull(b, fs.superblock.inodeOffset(number)); err != nil {
+		return nil, fmt.Errorf("unable to read inode %d: %v", number, err)
+	}
+	in, err := fs.parseInode(b, number)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inode %d: %v", number, err)
+	}
+	return in, nil
+}
+
+// readlink returns the target of a symlink inode, which is in the inode when it fits, and otherwise in blocks
+// that, with checksums, each have a header
+func (fs *FileSystem) readlink(in *inode) (string, error) {
+	if in.size > maxSymlinkLen {
+		return "", fmt.Errorf("symlink target of %d bytes is longer than the maximum %d", in.size, maxSymlinkLen)
+	}
+	if in.format == formatLocal {
+		return string(in.dataFork), nil
+	}
+	extents, err := fs.extents(in)
+	if err != nil {
+		return "", fmt.Errorf("could not read symlink target: %v", err)
+	}
+	sb := fs.superblock
+	target := make([]byte, 0, in.size)
+	for _, e := range extents {
+		for i := uint64(0); i < e.count && uint64(len(target)) < in.size; i++ {
+			b := make([]byte, sb.blockSize)
+			if err := fs.readFull(b, sb.blockOffset(e.block+i)); err != nil {
+				return "", fmt.Errorf("could not read symlink target: %v", err)
+			}
+			if sb.hasCRC() {
+				if magic := binary.BigEndian.Uint32(b[0:4]); magic != symlinkMagic {
+					return "", fmt.Errorf("symlink block had magic of %#08x instead of expected %#08x", magic, symlinkMagic)
+				}
+				if err := fs.verifyOwner(b, 12, 16, 32, in.number); err != nil {
+					return "", fmt.Errorf("invalid symlink block: %v", err)
+				}
+				offset := binary.BigEndian.Uint32(b[4:8])
+				size := binary.BigEndian.Uint32(b[8:12])
+				if int(offset) != len(target) || symlinkHeaderSize+int(size) > len(b) {
+					return "", fmt.Errorf("symlink block has %d bytes at %d instead of at %d", size, offset, len(target))
+				}
+				b = b[symlinkHeaderSize : symlinkHeaderSize+size]
+			}
+			target = append(target, b...)
+		}
+	}
+	if uint64(len(target)) < in.size {
+		return "", fmt.Errorf("symlink target has %d bytes instead of %d", len(target), in.size)
+	}
+	return string(target[:in.size]), nil
+}
+
+// readFull reads all of b from pos in the filesystem
+func (fs *FileSystem) readFull(b []byte, pos int64) error {
+	if fs.size > 0 && pos+int64(len(b)) > fs.size {
+		return fmt.Errorf("cannot read %d bytes at %d, past the end of the filesystem at %d", len(b), pos, fs.size)
+	}
+	n, err := fs.file.ReadAt(b, fs.start+pos)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(b)) {
+		return fmt.Errorf("error reading %d bytes at %d: %v", len(b), pos, err)
+	}
+	return nil
+}
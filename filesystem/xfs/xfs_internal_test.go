@@ -0,0 +1,30 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func packExtent(b []byte, e extent) {
+	l0 := e.offset<<9 | e.block>>43
+	if e.unwritten {
+		l0 |= 1 << 63
+	}
+	binary.BigEndian.PutUint64(b[0:8], l0)
+	binary.BigEndian.PutUint64(b[8:16], e.block<<21|e.count)
+}
+
+func TestParseExtent(t *testing.T) {
+	tests := []extent{
+		{offset: 0, block: 0, count: 1},
+		{offset: 1<<54 - 1, block: 1<<52 - 1, count: 1<<21 - 1, unwritten: true},
+		{offset: 12345, block: 1<<43 + 7, count: 99},
+	}
+	for _, tt := range tests {
+		b := make([]byte, extentSize)
+		packExtent(b, tt)
+		if e := parseExtent(b); e != tt {
+			t.Errorf("extent %+v instead of %+v", e, tt)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package xfs_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/xfs"
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+const (
+	// mkfs.xfs will not make a filesystem smaller than 300 MB
+	imageSize = 320 * xfs.MB
+	label     = "go-diskfs"
+	// where in the superblock are its fields the tests change or need
+	sbRootOffset       = 56
+	sbSectorSizeOffset = 102
+	sbInodeSizeOffset  = 104
+	sbInodesPerLog     = 123
+	sbBlockLogOffset   = 120
+	sbIncompatOffset   = 216
+	sbCRCOffset        = 224
+	inodeCRCOffset     = 100
+	// incompatNeedsRepair is the incompatible feature set while xfs_repair is fixing a filesystem
+	incompatNeedsRepair = 0x10
+	// the number of files in the directories of each form
+	blockDirFiles = 30
+	leafDirFiles  = 300
+	nodeDirFiles  = 2000
+)
+
+var longTarget = "missing/" + strings.Repeat("a", 900)
+
+// tree is what the test images are made from, written to a protofile for mkfs.xfs to copy in
+type tree struct {
+	big   []byte
+	holes []byte
+}
+
+func newTree(t *testing.T) *tree {
+	t.Helper()
+	big := make([]byte, 300*1024+17)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatalf("unable to generate random data: %v", err)
+	}
+	// pieces of data between holes, which mkfs.xfs versions that keep the holes of the files they copy map with
+	// more extents than fit in the inode
+	holes := make([]byte, 40*68*1024)
+	for i := 0; i < 40; i++ {
+		copy(holes[i*68*1024:], big[i*4096:(i+1)*4096])
+	}
+	return &tree{big: big, holes: holes}
+}
+
+// files returns the regular files of the tree and their contents
+func (tr *tree) files() map[string][]byte {
+	files := map[string][]byte{
+		"hello.txt":   []byte("hello world\n"),
+		"big.bin":     tr.big,
+		"holes.bin":   tr.holes,
+		"empty":       nil,
+		"short/a.txt": []byte("a\n"),
+		"short/b.txt": []byte("b\n"),
+	}
+	for dir, n := range map[string]int{"block": blockDirFiles, "leaf": leafDirFiles, "node": nodeDirFiles} {
+		for i := 0; i < n; i++ {
+			files[fmt.Sprintf("%s/file_with_a_long_name_%04d", dir, i)] = []byte(fmt.Sprintf("%d\n", i))
+		}
+	}
+	return files
+}
+
+// write writes the files of the tree to dir, and a protofile for them, whose path it returns. Only the blocks of
+// a file that are not all zeros are written, so that it has holes.
+func (tr *tree) write(t *testing.T, dir string) string {
+	t.Helper()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var proto strings.Builder
+	proto.WriteString("/dev/null\n0 0\nd--755 0 0\n")
+	sources := 0
+	writeEntry := func(name string, content []byte) {
+		p := filepath.Join(src, fmt.Sprintf("%d", sources))
+		sources++
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := f.Truncate(int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+		zeros := make([]byte, 4096)
+		for off := 0; off < len(content); off += 4096 {
+			end := off + 4096
+			if end > len(content) {
+				end = len(content)
+			}
+			if bytes.Equal(content[off:end], zeros[:end-off]) {
+				continue
+			}
+			if _, err := f.WriteAt(content[off:end], int64(off)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fmt.Fprintf(&proto, "%s ---644 0 0 %s\n", name, p)
+	}
+
+	dirs := map[string][]string{}
+	var names []string
+	for name := range tr.files() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if dir, base := path.Split(name); dir != "" {
+			dirs[path.Clean(dir)] = append(dirs[path.Clean(dir)], base)
+			continue
+		}
+		writeEntry(name, tr.files()[name])
+	}
+	for _, dir := range []string{"short", "block", "leaf", "node"} {
+		fmt.Fprintf(&proto, "%s d--755 0 0\n", dir)
+		for _, base := range dirs[dir] {
+			writeEntry(base, tr.files()[dir+"/"+base])
+		}
+		proto.WriteString("$\n")
+	}
+	fmt.Fprintf(&proto, "link l--777 0 0 hello.txt\n")
+	fmt.Fprintf(&proto, "longlink l--777 0 0 %s\n", longTarget)
+	fmt.Fprintf(&proto, "tty c--620 1000 100 136 1\n")
+	fmt.Fprintf(&proto, "setuid -u-755 0 0 %s\n", filepath.Join(src, "0"))
+	proto.WriteString("$\n")
+
+	p := filepath.Join(dir, "proto")
+	if err := os.WriteFile(p, []byte(proto.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// mkfs makes an image with testdata/mkxfs.sh, which runs mkfs.xfs on the protofile of the tree with the given extra
+// arguments. It skips the test if mkfs.xfs is not available.
+func mkfs(t *testing.T, tr *tree, args []string) string {
+	t.Helper()
+	dir := t.TempDir()
+	proto := tr.write(t, dir)
+	img := filepath.Join(dir, "xfs.img")
+	f, err := os.Create(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(imageSize); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	script, err := filepath.Abs(filepath.Join("testdata", "mkxfs.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdArgs := append([]string{"sh", script, img, label, proto}, args...)
+	if out, err := testhelper.RunTools(t, dir, []string{"mkfs.xfs"}, cmdArgs...); err != nil {
+		t.Fatalf("%v failed: %v\n%s", cmdArgs, err, out)
+	}
+	return img
+}
+
+// requireOption skips the test if mkfs.xfs does not have an option, as older versions do not have all of them
+func requireOption(t *testing.T, option string) {
+	t.Helper()
+	// without a device, it shows its usage and exits with an error
+	out, _ := testhelper.RunTools(t, t.TempDir(), []string{"mkfs.xfs"}, "mkfs.xfs")
+	if !bytes.Contains(out, []byte(option+"=")) {
+		t.Skipf("mkfs.xfs does not have %s", option)
+	}
+}
+
+func openImage(t *testing.T, img string) *os.File {
+	t.Helper()
+	f, err := os.Open(img)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", img, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func readImage(t *testing.T, img string) *xfs.FileSystem {
+	t.Helper()
+	fs, err := xfs.Read(openImage(t, img), imageSize, 0, 512)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	return fs
+}
+
+func readFile(t *testing.T, fs *xfs.FileSystem, p string) []byte {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", p, err)
+	}
+	defer f.Close()
+	// read in pieces that do not line up with the blocks
+	var data []byte
+	buf := make([]byte, 1000)
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read %s: %v", p, err)
+		}
+	}
+	return data
+}
+
+// versions are the filesystem versions the tests that do not depend on the layout are run on
+var versions = []struct {
+	name string
+	args []string
+}{
+	{"v5", nil},
+	{"v4", []string{"-m", "crc=0"}},
+}
+
+func TestRead(t *testing.T) {
+	tr := newTree(t)
+	tests := []struct {
+		name      string
+		args      []string
+		option    string
+		blockSize int64
+	}{
+		{"v5", nil, "", 4096},
+		{"v4", []string{"-m", "crc=0"}, "", 4096},
+		{"v4 without file types", []string{"-m", "crc=0", "-n", "ftype=0"}, "", 4096},
+		{"1k blocks", []string{"-b", "size=1024"}, "", 1024},
+		{"4k sectors", []string{"-s", "size=4096"}, "", 4096},
+		{"64k directory blocks", []string{"-n", "size=65536"}, "", 4096},
+		{"2k inodes", []string{"-i", "size=2048"}, "", 4096},
+		{"many allocation groups", []string{"-d", "agcount=16"}, "", 4096},
+		{"without sparse inodes or finobt", []string{"-i", "sparse=0", "-m", "finobt=0"}, "", 4096},
+		{"reverse mapping", []string{"-m", "rmapbt=1"}, "rmapbt", 4096},
+		{"big timestamps", []string{"-m", "bigtime=1"}, "bigtime", 4096},
+		{"large extent counts", []string{"-i", "nrext64=1"}, "nrext64", 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.option != "" {
+				requireOption(t, tt.option)
+			}
+			fs := readImage(t, mkfs(t, tr, tt.args))
+			if fs.Type() != filesystem.TypeXFS {
+				t.Errorf("type %v instead of %v", fs.Type(), filesystem.TypeXFS)
+			}
+			if fs.Label() != label {
+				t.Errorf("label %q instead of %q", fs.Label(), label)
+			}
+			stat, err := fs.Statfs()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stat.BlockSize != tt.blockSize || stat.TotalBytes != imageSize || stat.UsedBytes != imageSize {
+				t.Errorf("mismatched statfs %+v", stat)
+			}
+			if files := uint64(len(tr.files())); stat.Files < files {
+				t.Errorf("%d files in use, fewer than the %d in the tree", stat.Files, files)
+			}
+			for name, content := range tr.files() {
+				if b := readFile(t, fs, "/"+name); !bytes.Equal(b, content) {
+					t.Errorf("read %d bytes of %s that do not match the expected %d", len(b), name, len(content))
+				}
+			}
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	img := mkfs(t, newTree(t), nil)
+	f := openImage(t, img)
+	// the checksum of the superblock covers its whole sector
+	sb := make([]byte, 512)
+	if _, err := f.ReadAt(sb, 0); err != nil {
+		t.Fatalf("unable to read superblock: %v", err)
+	}
+	sb = make([]byte, binary.BigEndian.Uint16(sb[sbSectorSizeOffset:]))
+	if _, err := f.ReadAt(sb, 0); err != nil {
+		t.Fatalf("unable to read superblock: %v", err)
+	}
+	// the root inode is in the first allocation group, so its number is its block and its index in the block
+	root := binary.BigEndian.Uint64(sb[sbRootOffset:])
+	inodeSize := int(binary.BigEndian.Uint16(sb[sbInodeSizeOffset:]))
+	perLog := sb[sbInodesPerLog]
+	rootOffset := int64(root>>perLog)<<sb[sbBlockLogOffset] + int64(root&(1<<perLog-1))*int64(inodeSize)
+	inode := make([]byte, inodeSize)
+	if _, err := f.ReadAt(inode, rootOffset); err != nil {
+		t.Fatalf("unable to read root inode: %v", err)
+	}
+
+	crc32c := crc32.MakeTable(crc32.Castagnoli)
+	// changed returns b with its changes made, and its checksum at offset set again
+	changed := func(b []byte, offset int, change func(b []byte)) []byte {
+		c := append([]byte{}, b...)
+		change(c)
+		binary.LittleEndian.PutUint32(c[offset:], 0)
+		binary.LittleEndian.PutUint32(c[offset:], crc32.Checksum(c, crc32c))
+		return c
+	}
+	tests := []struct {
+		name    string
+		changes map[int64][]byte
+	}{
+		{"not xfs", map[int64][]byte{0: []byte("NOTX")}},
+		{"superblock checksum", map[int64][]byte{300: {0xff}}},
+		{"unsupported feature", map[int64][]byte{0: changed(sb, sbCRCOffset, func(b []byte) { b[sbIncompatOffset+3] |= incompatNeedsRepair })}},
+		{"block size", map[int64][]byte{0: changed(sb, sbCRCOffset, func(b []byte) { b[sbBlockLogOffset] = 11 })}},
+		{"root inode checksum", map[int64][]byte{rootOffset + 60: {0xff}}},
+		{"root inode magic", map[int64][]byte{rootOffset: changed(inode, inodeCRCOffset, func(b []byte) { copy(b, "XX") })}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := &testhelper.FileImpl{
+				Reader: func(b []byte, offset int64) (int, error) {
+					n, err := f.ReadAt(b, offset)
+					for pos, change := range tt.changes {
+						for i, c := range change {
+							if p := pos + int64(i) - offset; p >= 0 && p < int64(n) {
+								b[p] = c
+							}
+						}
+					}
+					return n, err
+				},
+			}
+			if _, err := xfs.Read(changed, imageSize, 0, 512); err == nil {
+				t.Errorf("no error reading invalid image")
+			}
+		})
+	}
+	if _, err := xfs.Read(f, imageSize, 0, 3000); err == nil {
+		t.Errorf("no error for invalid blocksize")
+	}
+	if _, err := xfs.Read(f, imageSize/2, 0, 512); err == nil {
+		t.Errorf("no error for filesystem larger than its space")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	tr := newTree(t)
+	for _, v := range versions {
+		t.Run(v.name, func(t *testing.T) {
+			fs := readImage(t, mkfs(t, tr, v.args))
+			dirs := map[string][]string{
+				"/": {"big.bin", "block", "empty", "hello.txt", "holes.bin", "leaf", "link", "longlink", "node", "setuid", "short", "tty"},
+			}
+			for name := range tr.files() {
+				if dir, base := path.Split(name); dir != "" {
+					dirs["/"+path.Clean(dir)] = append(dirs["/"+path.Clean(dir)], base)
+				}
+			}
+			for dir, expected := range dirs {
+				fi, err := fs.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", dir, err)
+				}
+				names := make([]string, 0, len(fi))
+				for _, f := range fi {
+					names = append(names, f.Name())
+					if content, ok := tr.files()[strings.TrimPrefix(path.Join(dir, f.Name()), "/")]; ok && f.Size() != int64(len(content)) {
+						t.Errorf("size of %s in %s is %d instead of %d", f.Name(), dir, f.Size(), len(content))
+					}
+				}
+				sort.Strings(names)
+				sort.Strings(expected)
+				if strings.Join(names, ",") != strings.Join(expected, ",") {
+					t.Errorf("%s: names %v instead of %v", dir, names, expected)
+				}
+			}
+			if _, err := fs.ReadDir("/hello.txt"); err == nil {
+				t.Errorf("no error reading a file as a directory")
+			}
+			if _, err := fs.ReadDir("/missing"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("error %v instead of one wrapping os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), nil))
+	for _, p := range []string{"/short", "/tty", "/missing", "/hello.txt/x"} {
+		if _, err := fs.OpenFile(p, os.O_RDONLY); err == nil {
+			t.Errorf("%s: no error opening something that is not a file", p)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tr := newTree(t)
+	fs := readImage(t, mkfs(t, tr, nil))
+	for _, p := range []string{"/holes.bin", "/big.bin"} {
+		t.Run(p, func(t *testing.T) {
+			content := tr.files()[strings.TrimPrefix(p, "/")]
+			size := int64(len(content))
+			f, err := fs.OpenFile(p, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer f.Close()
+			tests := []struct {
+				offset int64
+				whence int
+				pos    int64
+			}{
+				{5000, io.SeekStart, 5000},
+				{-200, io.SeekEnd, size - 200},
+				// after reading 150 bytes
+				{-30 * 4096, io.SeekCurrent, size - 50 - 30*4096},
+			}
+			for _, tt := range tests {
+				pos, err := f.Seek(tt.offset, tt.whence)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if pos != tt.pos {
+					t.Errorf("position %d instead of %d", pos, tt.pos)
+				}
+				b := make([]byte, 150)
+				if _, err := io.ReadFull(f, b); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(b, content[pos:pos+150]) {
+					t.Errorf("mismatched data at %d", pos)
+				}
+			}
+			if _, err := f.Seek(-1, io.SeekStart); err == nil {
+				t.Errorf("no error seeking before the start")
+			}
+		})
+	}
+}
+
+func TestStat(t *testing.T) {
+	tr := newTree(t)
+	for _, v := range versions {
+		t.Run(v.name, func(t *testing.T) {
+			fs := readImage(t, mkfs(t, tr, v.args))
+			fi, err := fs.Stat("/link")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Name() != "hello.txt" || fi.Size() != 12 || fi.Mode() != 0o644 {
+				t.Errorf("stat of link was %s of %d bytes with mode %v", fi.Name(), fi.Size(), fi.Mode())
+			}
+			fi, err = fs.Lstat("/link")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Mode() != os.ModeSymlink|0o777 {
+				t.Errorf("mode %v instead of symlink", fi.Mode())
+			}
+			for p, expected := range map[string]string{"/link": "hello.txt", "/longlink": longTarget} {
+				target, err := fs.Readlink(p)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", p, err)
+				}
+				if target != expected {
+					t.Errorf("%s: target %q instead of %q", p, target, expected)
+				}
+			}
+			if _, err := fs.Readlink("/hello.txt"); err == nil {
+				t.Errorf("no error reading a file as a link")
+			}
+			// the long link goes through a directory that does not exist
+			if _, err := fs.Stat("/longlink"); err == nil {
+				t.Errorf("no error following a link to a missing directory")
+			}
+
+			fi, err = fs.Stat("/setuid")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Mode() != os.ModeSetuid|0o755 {
+				t.Errorf("mode %v instead of %v", fi.Mode(), os.ModeSetuid|0o755)
+			}
+
+			fi, err = fs.Stat("/tty")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Mode() != os.ModeDevice|os.ModeCharDevice|0o620 {
+				t.Errorf("mode %v instead of character device", fi.Mode())
+			}
+			sys := fi.Sys().(xfs.FileStat)
+			if major, minor := sys.Rdev(); major != 136 || minor != 1 {
+				t.Errorf("device %d:%d instead of 136:1", major, minor)
+			}
+			if sys.UID() != 1000 || sys.GID() != 100 || sys.Nlink() != 1 {
+				t.Errorf("mismatched stat %+v", sys)
+			}
+			if fi.ModTime().IsZero() {
+				t.Errorf("no modification time")
+			}
+			// only version 5 has creation times
+			if created := !sys.CreationTime().IsZero(); created != (v.name == "v5") {
+				t.Errorf("creation time %v for %s", sys.CreationTime(), v.name)
+			}
+		})
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	fs := readImage(t, mkfs(t, newTree(t), nil))
+	f, err := fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	_, writeErr := f.Write([]byte("x"))
+	_, openErr := fs.OpenFile("/hello.txt", os.O_RDWR)
+	for _, err := range []error{
+		fs.Mkdir("/new"),
+		fs.Remove("/hello.txt"),
+		fs.RemoveAll("/short"),
+		fs.Rename("/hello.txt", "/other.txt"),
+		fs.Truncate("/hello.txt", 10),
+		fs.SetLabel("label"),
+		fs.Symlink("/hello.txt", "/link2"),
+		writeErr,
+		openErr,
+	} {
+		if !errors.Is(err, filesystem.ErrReadonlyFilesystem) {
+			t.Errorf("error %v instead of one wrapping filesystem.ErrReadonlyFilesystem", err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Package qcow2 provides support for reading and creating qcow2 disk images, the native image format of QEMU.
+//
+// An Image wraps a util.File holding the qcow2 container and itself implements util.File,
+// presenting the guest-visible (virtual) disk. Reads of clusters that were never allocated
+// return zeros, and writes allocate clusters on demand, so images stay sparse.
+//
+// Normally, the best way to work with a qcow2 image is through github.com/diskfs/go-diskfs,
+// by passing diskfs.Qcow2 to Create() or WithFormat(diskfs.Qcow2) to Open().
+//
+// Limitations: backing files, encryption, external data files, extended L2 entries
+// and compression types other than zlib are not supported. Clusters that are freed are not reused;
+// new clusters are always appended to the end of the image file.
+//
+// references:
+//
+//	https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+//	https://people.gnome.org/~markmc/qcow-image-format.html
+package qcow2
@@ -0,0 +1,142 @@
+package qcow2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	headerMagic uint32 = 0x514649fb
+	// headerV2Size is the size of a version 2 header, and of the common part of a version 3 header
+	headerV2Size = 72
+	// headerV3Size is the minimum size of a version 3 header
+	headerV3Size = 104
+)
+
+// incompatible feature bits
+const (
+	featureDirty            uint64 = 1 << 0
+	featureCorrupt          uint64 = 1 << 1
+	featureExternalDataFile uint64 = 1 << 2
+	featureCompressionType  uint64 = 1 << 3
+	featureExtendedL2       uint64 = 1 << 4
+)
+
+const (
+	cryptNone uint32 = 0
+)
+
+// header is the qcow2 image header found at the beginning of the image file
+type header struct {
+	version               uint32
+	backingFileOffset     uint64
+	backingFileSize       uint32
+	clusterBits           uint32
+	size                  uint64
+	cryptMethod           uint32
+	l1Size                uint32
+	l1TableOffset         uint64
+	refcountTableOffset   uint64
+	refcountTableClusters uint32
+	nbSnapshots           uint32
+	snapshotsOffset       uint64
+	incompatibleFeatures  uint64
+	compatibleFeatures    uint64
+	autoclearFeatures     uint64
+	refcountOrder         uint32
+	headerLength          uint32
+}
+
+func (h *header) equal(a *header) bool {
+	if (h == nil && a != nil) || (a == nil && h != nil) {
+		return false
+	}
+	if h == nil && a == nil {
+		return true
+	}
+	return *h == *a
+}
+
+// headerFromBytes parses a qcow2 header. The slice must contain at least the version 2 header,
+// and at least the version 3 header if the version is 3.
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) < headerV2Size {
+		return nil, fmt.Errorf("cannot read qcow2 header from %d bytes, need at least %d", len(b), headerV2Size)
+	}
+	if magic := binary.BigEndian.Uint32(b[0:4]); magic != headerMagic {
+		return nil, fmt.Errorf("invalid qcow2 magic %x, expected %x", magic, headerMagic)
+	}
+	h := &header{
+		version:               binary.BigEndian.Uint32(b[4:8]),
+		backingFileOffset:     binary.BigEndian.Uint64(b[8:16]),
+		backingFileSize:       binary.BigEndian.Uint32(b[16:20]),
+		clusterBits:           binary.BigEndian.Uint32(b[20:24]),
+		size:                  binary.BigEndian.Uint64(b[24:32]),
+		cryptMethod:           binary.BigEndian.Uint32(b[32:36]),
+		l1Size:                binary.BigEndian.Uint32(b[36:40]),
+		l1TableOffset:         binary.BigEndian.Uint64(b[40:48]),
+		refcountTableOffset:   binary.BigEndian.Uint64(b[48:56]),
+		refcountTableClusters: binary.BigEndian.Uint32(b[56:60]),
+		nbSnapshots:           binary.BigEndian.Uint32(b[60:64]),
+		snapshotsOffset:       binary.BigEndian.Uint64(b[64:72]),
+	}
+	switch h.version {
+	case 2:
+		// version 2 has fixed values for the fields added in version 3
+		h.refcountOrder = 4
+		h.headerLength = headerV2Size
+	case 3:
+		if len(b) < headerV3Size {
+			return nil, fmt.Errorf("cannot read qcow2 version 3 header from %d bytes, need at least %d", len(b), headerV3Size)
+		}
+		h.incompatibleFeatures = binary.BigEndian.Uint64(b[72:80])
+		h.compatibleFeatures = binary.BigEndian.Uint64(b[80:88])
+		h.autoclearFeatures = binary.BigEndian.Uint64(b[88:96])
+		h.refcountOrder = binary.BigEndian.Uint32(b[96:100])
+		h.headerLength = binary.BigEndian.Uint32(b[100:104])
+		if h.headerLength < headerV3Size {
+			return nil, fmt.Errorf("invalid qcow2 header length %d, must be at least %d", h.headerLength, headerV3Size)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported qcow2 version %d", h.version)
+	}
+	if h.clusterBits < minClusterBits || h.clusterBits > maxClusterBits {
+		return nil, fmt.Errorf("invalid cluster bits %d, must be between %d and %d", h.clusterBits, minClusterBits, maxClusterBits)
+	}
+	if h.refcountOrder > 6 {
+		return nil, fmt.Errorf("invalid refcount order %d, must be at most 6", h.refcountOrder)
+	}
+	return h, nil
+}
+
+// toBytes returns the header ready to be written to the beginning of the image file.
+// Only the fields known to this package are returned, so any extra header bytes beyond
+// them are left untouched on disk.
+func (h *header) toBytes() []byte {
+	size := headerV2Size
+	if h.version >= 3 {
+		size = headerV3Size
+	}
+	b := make([]byte, size)
+	binary.BigEndian.PutUint32(b[0:4], headerMagic)
+	binary.BigEndian.PutUint32(b[4:8], h.version)
+	binary.BigEndian.PutUint64(b[8:16], h.backingFileOffset)
+	binary.BigEndian.PutUint32(b[16:20], h.backingFileSize)
+	binary.BigEndian.PutUint32(b[20:24], h.clusterBits)
+	binary.BigEndian.PutUint64(b[24:32], h.size)
+	binary.BigEndian.PutUint32(b[32:36], h.cryptMethod)
+	binary.BigEndian.PutUint32(b[36:40], h.l1Size)
+	binary.BigEndian.PutUint64(b[40:48], h.l1TableOffset)
+	binary.BigEndian.PutUint64(b[48:56], h.refcountTableOffset)
+	binary.BigEndian.PutUint32(b[56:60], h.refcountTableClusters)
+	binary.BigEndian.PutUint32(b[60:64], h.nbSnapshots)
+	binary.BigEndian.PutUint64(b[64:72], h.snapshotsOffset)
+	if h.version >= 3 {
+		binary.BigEndian.PutUint64(b[72:80], h.incompatibleFeatures)
+		binary.BigEndian.PutUint64(b[80:88], h.compatibleFeatures)
+		binary.BigEndian.PutUint64(b[88:96], h.autoclearFeatures)
+		binary.BigEndian.PutUint32(b[96:100], h.refcountOrder)
+		binary.BigEndian.PutUint32(b[100:104], h.headerLength)
+	}
+	return b
+}
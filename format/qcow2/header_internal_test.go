@@ -0,0 +1,87 @@
+package qcow2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testValidHeaderBytes() ([]byte, *header) {
+	h := &header{
+		version:               3,
+		clusterBits:           16,
+		size:                  10 * 1024 * 1024,
+		l1Size:                1,
+		l1TableOffset:         0x10000,
+		refcountTableOffset:   0x20000,
+		refcountTableClusters: 1,
+		refcountOrder:         4,
+		headerLength:          headerV3Size,
+	}
+	b := make([]byte, headerV3Size)
+	binary.BigEndian.PutUint32(b[0:4], headerMagic)
+	binary.BigEndian.PutUint32(b[4:8], 3)
+	binary.BigEndian.PutUint32(b[20:24], 16)
+	binary.BigEndian.PutUint64(b[24:32], 10*1024*1024)
+	binary.BigEndian.PutUint32(b[36:40], 1)
+	binary.BigEndian.PutUint64(b[40:48], 0x10000)
+	binary.BigEndian.PutUint64(b[48:56], 0x20000)
+	binary.BigEndian.PutUint32(b[56:60], 1)
+	binary.BigEndian.PutUint32(b[96:100], 4)
+	binary.BigEndian.PutUint32(b[100:104], headerV3Size)
+	return b, h
+}
+
+func TestHeaderFromBytes(t *testing.T) {
+	valid, validHeader := testValidHeaderBytes()
+	badMagic := make([]byte, len(valid))
+	copy(badMagic, valid)
+	badMagic[0] = 0
+	v2 := make([]byte, headerV2Size)
+	copy(v2, valid)
+	binary.BigEndian.PutUint32(v2[4:8], 2)
+	v2Header := *validHeader
+	v2Header.version = 2
+	v2Header.headerLength = headerV2Size
+	badCluster := make([]byte, len(valid))
+	copy(badCluster, valid)
+	binary.BigEndian.PutUint32(badCluster[20:24], 30)
+
+	tests := []struct {
+		b   []byte
+		h   *header
+		err error
+	}{
+		{valid[:10], nil, fmt.Errorf("cannot read qcow2 header from 10 bytes")},
+		{badMagic, nil, fmt.Errorf("invalid qcow2 magic")},
+		{valid[:headerV2Size], nil, fmt.Errorf("cannot read qcow2 version 3 header")},
+		{badCluster, nil, fmt.Errorf("invalid cluster bits 30")},
+		{v2, &v2Header, nil},
+		{valid, validHeader, nil},
+	}
+	for i, tt := range tests {
+		h, err := headerFromBytes(tt.b)
+		switch {
+		case (err == nil && tt.err != nil) || (err != nil && tt.err == nil) || (err != nil && tt.err != nil && !strings.HasPrefix(err.Error(), tt.err.Error())):
+			t.Errorf("%d: mismatched error, actual then expected", i)
+			t.Logf("%v", err)
+			t.Logf("%v", tt.err)
+		case !h.equal(tt.h):
+			t.Errorf("%d: mismatched header, actual then expected", i)
+			t.Logf("%#v", h)
+			t.Logf("%#v", tt.h)
+		}
+	}
+}
+
+func TestHeaderToBytes(t *testing.T) {
+	valid, h := testValidHeaderBytes()
+	b := h.toBytes()
+	if !bytes.Equal(b, valid) {
+		t.Errorf("mismatched bytes, actual then expected")
+		t.Logf("% x", b)
+		t.Logf("% x", valid)
+	}
+}
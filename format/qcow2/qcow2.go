@@ -0,0 +1,430 @@
+package qcow2
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// DefaultClusterSize is the cluster size used by Create when none is given, matching qemu-img
+	DefaultClusterSize int64 = 64 * 1024
+	minClusterBits           = 9
+	maxClusterBits           = 21
+	// defaultRefcountOrder gives 16-bit refcounts, the only width qemu used before version 3
+	defaultRefcountOrder uint32 = 4
+)
+
+// bits used in L1 and L2 table entries
+const (
+	entryOffsetMask    uint64 = 0x00fffffffffffe00
+	entryCopied        uint64 = 1 << 63
+	entryCompressed    uint64 = 1 << 62
+	entryZero          uint64 = 1 << 0
+	refcountOffsetMask uint64 = 0xfffffffffffffe00
+)
+
+// Image is a qcow2 disk image. It implements util.File for the virtual disk it contains.
+type Image struct {
+	file          util.File
+	header        *header
+	writable      bool
+	clusterSize   int64
+	l2Entries     int64
+	l1            []uint64
+	l2Cache       map[uint64][]uint64
+	refcountTable []uint64
+	refcountBits  int64
+	// nextCluster is the index of the first host cluster beyond the end of the image file,
+	// where the next allocation will be placed
+	nextCluster int64
+	offset      int64
+}
+
+// Create creates a new qcow2 image with a virtual disk of the given size in bytes, writing it to f
+// starting at offset 0. f is expected to be empty.
+//
+// clusterSize must be a power of 2 between 512 bytes and 2MB. If it is 0, DefaultClusterSize is used.
+//
+// The returned Image is writable.
+func Create(f util.File, size, clusterSize int64) (*Image, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid qcow2 image size %d, must be positive", size)
+	}
+	if clusterSize == 0 {
+		clusterSize = DefaultClusterSize
+	}
+	var clusterBits uint32
+	for clusterBits = minClusterBits; clusterBits <= maxClusterBits; clusterBits++ {
+		if int64(1)<<clusterBits == clusterSize {
+			break
+		}
+	}
+	if clusterBits > maxClusterBits {
+		return nil, fmt.Errorf("invalid cluster size %d, must be a power of 2 between %d and %d", clusterSize, 1<<minClusterBits, 1<<maxClusterBits)
+	}
+
+	l2Entries := clusterSize / 8
+	dataClusters := divRoundUp(size, clusterSize)
+	l1Size := divRoundUp(dataClusters, l2Entries)
+	l1Clusters := divRoundUp(l1Size*8, clusterSize)
+
+	// size the refcount table so that it covers a fully allocated image, including all of its metadata,
+	// so that it does not need to grow in normal use
+	refcountBits := int64(1) << defaultRefcountOrder
+	clustersPerBlock := clusterSize * 8 / refcountBits
+	refcountTableClusters := int64(1)
+	for {
+		hostClusters := 1 + l1Clusters + refcountTableClusters + l1Size + dataClusters
+		blocks := divRoundUp(hostClusters, clustersPerBlock)
+		hostClusters += blocks
+		needed := divRoundUp(divRoundUp(hostClusters, clustersPerBlock)*8, clusterSize)
+		if needed <= refcountTableClusters {
+			break
+		}
+		refcountTableClusters = needed
+	}
+
+	h := &header{
+		version:               3,
+		clusterBits:           clusterBits,
+		size:                  uint64(size),
+		cryptMethod:           cryptNone,
+		l1Size:                uint32(l1Size),
+		l1TableOffset:         uint64(clusterSize),
+		refcountTableOffset:   uint64(clusterSize * (1 + l1Clusters)),
+		refcountTableClusters: uint32(refcountTableClusters),
+		refcountOrder:         defaultRefcountOrder,
+		headerLength:          headerV3Size,
+	}
+
+	// header cluster; the zeroes following the header are the header extension end marker
+	b := make([]byte, clusterSize)
+	copy(b, h.toBytes())
+	if _, err := f.WriteAt(b, 0); err != nil {
+		return nil, fmt.Errorf("unable to write qcow2 header: %v", err)
+	}
+	// empty L1 table and refcount table
+	zeroes := make([]byte, (l1Clusters+refcountTableClusters)*clusterSize)
+	if _, err := f.WriteAt(zeroes, clusterSize); err != nil {
+		return nil, fmt.Errorf("unable to write qcow2 tables: %v", err)
+	}
+
+	img := &Image{
+		file:          f,
+		header:        h,
+		writable:      true,
+		clusterSize:   clusterSize,
+		l2Entries:     l2Entries,
+		l1:            make([]uint64, l1Size),
+		l2Cache:       map[uint64][]uint64{},
+		refcountTable: make([]uint64, refcountTableClusters*clusterSize/8),
+		refcountBits:  refcountBits,
+		nextCluster:   1 + l1Clusters + refcountTableClusters,
+	}
+	// everything written so far is in use exactly once
+	metadataClusters := img.nextCluster
+	for i := int64(0); i < metadataClusters; i++ {
+		if err := img.setRefcount(i, 1); err != nil {
+			return nil, fmt.Errorf("unable to set refcount for metadata cluster %d: %v", i, err)
+		}
+	}
+	return img, nil
+}
+
+// Open opens an existing qcow2 image in f. If writable is false, any attempt to write to the
+// returned Image will return an error.
+func Open(f util.File, writable bool) (*Image, error) {
+	b := make([]byte, headerV3Size)
+	n, err := f.ReadAt(b, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to read qcow2 header: %v", err)
+	}
+	h, err := headerFromBytes(b[:n])
+	if err != nil {
+		return nil, fmt.Errorf("invalid qcow2 header: %v", err)
+	}
+	switch {
+	case h.backingFileOffset != 0:
+		return nil, errors.New("qcow2 images with a backing file are not supported")
+	case h.cryptMethod != cryptNone:
+		return nil, errors.New("encrypted qcow2 images are not supported")
+	case h.incompatibleFeatures&^(featureDirty|featureCorrupt) != 0:
+		return nil, fmt.Errorf("qcow2 image uses unsupported incompatible features %#x", h.incompatibleFeatures)
+	case writable && h.incompatibleFeatures&featureCorrupt != 0:
+		return nil, errors.New("qcow2 image is marked corrupt and cannot be opened for writing")
+	case writable && h.incompatibleFeatures&featureDirty != 0:
+		return nil, errors.New("qcow2 image has dirty refcounts and cannot be opened for writing")
+	}
+
+	clusterSize := int64(1) << h.clusterBits
+	img := &Image{
+		file:         f,
+		header:       h,
+		writable:     writable,
+		clusterSize:  clusterSize,
+		l2Entries:    clusterSize / 8,
+		l2Cache:      map[uint64][]uint64{},
+		refcountBits: int64(1) << h.refcountOrder,
+	}
+	if int64(h.l1Size) < divRoundUp(divRoundUp(int64(h.size), clusterSize), img.l2Entries) {
+		return nil, fmt.Errorf("L1 table with %d entries is too small for image size %d", h.l1Size, h.size)
+	}
+	if img.l1, err = img.readTable(h.l1TableOffset, int64(h.l1Size)); err != nil {
+		return nil, fmt.Errorf("unable to read L1 table: %v", err)
+	}
+	if img.refcountTable, err = img.readTable(h.refcountTableOffset, int64(h.refcountTableClusters)*clusterSize/8); err != nil {
+		return nil, fmt.Errorf("unable to read refcount table: %v", err)
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find end of qcow2 image file: %v", err)
+	}
+	img.nextCluster = divRoundUp(end, clusterSize)
+	return img, nil
+}
+
+// Size returns the size in bytes of the virtual disk
+func (i *Image) Size() int64 {
+	return int64(i.header.size)
+}
+
+// ClusterSize returns the size in bytes of a cluster in the image
+func (i *Image) ClusterSize() int64 {
+	return i.clusterSize
+}
+
+// ReadAt reads len(b) bytes from the virtual disk starting at byte offset off.
+// Unallocated areas of the disk read as zeros.
+func (i *Image) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	size := i.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var retErr error
+	if int64(len(b)) > size-off {
+		b = b[:size-off]
+		retErr = io.EOF
+	}
+	read := 0
+	for read < len(b) {
+		pos := off + int64(read)
+		inCluster := pos % i.clusterSize
+		n := int(i.clusterSize - inCluster)
+		if n > len(b)-read {
+			n = len(b) - read
+		}
+		entry, err := i.l2Entry(pos / i.clusterSize)
+		if err != nil {
+			return read, err
+		}
+		chunk := b[read : read+n]
+		switch {
+		case entry&entryCompressed != 0:
+			data, err := i.readCompressedCluster(entry)
+			if err != nil {
+				return read, err
+			}
+			copy(chunk, data[inCluster:])
+		case entry&entryZero != 0 || entry&entryOffsetMask == 0:
+			for j := range chunk {
+				chunk[j] = 0
+			}
+		default:
+			if _, err := i.file.ReadAt(chunk, int64(entry&entryOffsetMask)+inCluster); err != nil {
+				return read, fmt.Errorf("unable to read cluster data: %v", err)
+			}
+		}
+		read += n
+	}
+	return read, retErr
+}
+
+// WriteAt writes len(b) bytes to the virtual disk starting at byte offset off,
+// allocating clusters as required. Writing zeros to an unallocated area does not allocate it.
+func (i *Image) WriteAt(b []byte, off int64) (int, error) {
+	if !i.writable {
+		return 0, errors.New("qcow2 image not open for writing")
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	if off+int64(len(b)) > i.Size() {
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d beyond end of disk of size %d", len(b), off, i.Size())
+	}
+	written := 0
+	for written < len(b) {
+		pos := off + int64(written)
+		cluster := pos / i.clusterSize
+		inCluster := pos % i.clusterSize
+		n := int(i.clusterSize - inCluster)
+		if n > len(b)-written {
+			n = len(b) - written
+		}
+		chunk := b[written : written+n]
+		if err := i.writeCluster(cluster, inCluster, chunk); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Seek sets the offset for the next Read or Write. Only provided to satisfy util.File;
+// all access to the image is through ReadAt and WriteAt.
+func (i *Image) Seek(offset int64, whence int) (int64, error) {
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset += i.offset
+	case io.SeekEnd:
+		newOffset += i.Size()
+	default:
+		return i.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if newOffset < 0 {
+		return i.offset, fmt.Errorf("cannot set offset %d before start of disk", newOffset)
+	}
+	i.offset = newOffset
+	return i.offset, nil
+}
+
+// Close closes the underlying file, if it implements io.Closer. All writes go straight to the file,
+// so there is nothing else to complete.
+func (i *Image) Close() error {
+	if c, ok := i.file.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// writeCluster writes data at offset inCluster within the guest cluster with the given index
+func (i *Image) writeCluster(cluster, inCluster int64, data []byte) error {
+	entry, err := i.l2Entry(cluster)
+	if err != nil {
+		return err
+	}
+	// the simple case: a cluster that belongs only to us, just overwrite in place
+	if entry&(entryCompressed|entryZero) == 0 && entry&entryCopied != 0 && entry&entryOffsetMask != 0 {
+		if _, err := i.file.WriteAt(data, int64(entry&entryOffsetMask)+inCluster); err != nil {
+			return fmt.Errorf("unable to write cluster data: %v", err)
+		}
+		return nil
+	}
+	readsAsZero := entry&entryCompressed == 0 && (entry&entryZero != 0 || entry&entryOffsetMask == 0)
+	if readsAsZero && isZero(data) {
+		return nil
+	}
+
+	// we need a new cluster, filled with whatever is there now plus the new data
+	b := make([]byte, i.clusterSize)
+	switch {
+	case readsAsZero:
+	case entry&entryCompressed != 0:
+		old, err := i.readCompressedCluster(entry)
+		if err != nil {
+			return err
+		}
+		copy(b, old)
+	default:
+		if _, err := i.file.ReadAt(b, int64(entry&entryOffsetMask)); err != nil {
+			return fmt.Errorf("unable to read shared cluster for copy: %v", err)
+		}
+	}
+	copy(b[inCluster:], data)
+	hostOffset, err := i.allocateClusters(1)
+	if err != nil {
+		return err
+	}
+	if _, err := i.file.WriteAt(b, hostOffset); err != nil {
+		return fmt.Errorf("unable to write cluster data: %v", err)
+	}
+	if err := i.setL2Entry(cluster, uint64(hostOffset)|entryCopied); err != nil {
+		return err
+	}
+	// release our reference to whatever the cluster used to point to
+	switch {
+	case entry&entryCompressed != 0:
+		start, length := i.compressedRange(entry)
+		for c := start / i.clusterSize; c <= (start+length-1)/i.clusterSize; c++ {
+			if err := i.decrementRefcount(c); err != nil {
+				return err
+			}
+		}
+	case entry&entryOffsetMask != 0:
+		if err := i.decrementRefcount(int64(entry&entryOffsetMask) / i.clusterSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressedRange returns the host offset and maximum length of the compressed data of an L2 entry
+func (i *Image) compressedRange(entry uint64) (offset, length int64) {
+	x := 62 - (i.header.clusterBits - 8)
+	offset = int64(entry & (uint64(1)<<x - 1))
+	sectors := int64((entry>>x)&(uint64(1)<<(i.header.clusterBits-8)-1)) + 1
+	length = sectors*512 - offset%512
+	return offset, length
+}
+
+// readCompressedCluster reads and inflates a compressed cluster
+func (i *Image) readCompressedCluster(entry uint64) ([]byte, error) {
+	offset, length := i.compressedRange(entry)
+	compressed := make([]byte, length)
+	n, err := i.file.ReadAt(compressed, offset)
+	// the compressed data may legitimately end before the last sector it claims
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to read compressed cluster: %v", err)
+	}
+	r := flate.NewReader(bytes.NewReader(compressed[:n]))
+	defer r.Close()
+	b := make([]byte, i.clusterSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("unable to decompress cluster: %v", err)
+	}
+	return b, nil
+}
+
+// readTable reads a table of count big-endian 64-bit entries at the given host offset
+func (i *Image) readTable(offset uint64, count int64) ([]uint64, error) {
+	b := make([]byte, count*8)
+	if _, err := i.file.ReadAt(b, int64(offset)); err != nil {
+		return nil, err
+	}
+	table := make([]uint64, count)
+	for j := range table {
+		table[j] = binary.BigEndian.Uint64(b[j*8:])
+	}
+	return table, nil
+}
+
+// writeTableEntry writes a single big-endian 64-bit table entry at the given host offset
+func (i *Image) writeTableEntry(offset int64, value uint64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, value)
+	_, err := i.file.WriteAt(b, offset)
+	return err
+}
+
+func divRoundUp(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
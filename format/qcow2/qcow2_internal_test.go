@@ -0,0 +1,178 @@
+package qcow2
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"os"
+	"testing"
+)
+
+func testCreateImage(t *testing.T, size, clusterSize int64) *Image {
+	t.Helper()
+	f, err := os.CreateTemp("", "qcow2_test")
+	if err != nil {
+		t.Fatalf("unable to create tempfile: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	img, err := Create(f, size, clusterSize)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	return img
+}
+
+// checkRefcounts verifies that every cluster referenced by the image metadata has a refcount of exactly 1
+func checkRefcounts(t *testing.T, img *Image) {
+	t.Helper()
+	used := map[int64]int{0: 1}
+	for c := int64(0); c < divRoundUp(int64(img.header.l1Size)*8, img.clusterSize); c++ {
+		used[int64(img.header.l1TableOffset)/img.clusterSize+c]++
+	}
+	for c := int64(0); c < int64(img.header.refcountTableClusters); c++ {
+		used[int64(img.header.refcountTableOffset)/img.clusterSize+c]++
+	}
+	for _, e := range img.refcountTable {
+		if e != 0 {
+			used[int64(e&refcountOffsetMask)/img.clusterSize]++
+		}
+	}
+	for _, l1 := range img.l1 {
+		if l1&entryOffsetMask == 0 {
+			continue
+		}
+		used[int64(l1&entryOffsetMask)/img.clusterSize]++
+		l2, err := img.l2Table(l1 & entryOffsetMask)
+		if err != nil {
+			t.Fatalf("unable to read L2 table: %v", err)
+		}
+		for _, e := range l2 {
+			if e&entryOffsetMask != 0 && e&entryCompressed == 0 {
+				used[int64(e&entryOffsetMask)/img.clusterSize]++
+			}
+		}
+	}
+	for c := int64(0); c < img.nextCluster; c++ {
+		count, err := img.refcount(c)
+		if err != nil {
+			t.Fatalf("unable to read refcount for cluster %d: %v", c, err)
+		}
+		if count != uint64(used[c]) {
+			t.Errorf("cluster %d: refcount %d, but referenced %d times", c, count, used[c])
+		}
+	}
+}
+
+func TestRefcounts(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		img := testCreateImage(t, 10*1024*1024, 0)
+		checkRefcounts(t, img)
+	})
+	t.Run("allocate", func(t *testing.T) {
+		img := testCreateImage(t, 4*1024*1024, 512)
+		b := make([]byte, 1024*1024)
+		_, _ = rand.Read(b)
+		if _, err := img.WriteAt(b, 512*1024); err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+		checkRefcounts(t, img)
+	})
+	t.Run("grow table", func(t *testing.T) {
+		img := testCreateImage(t, 4*1024*1024, 512)
+		oldOffset := img.header.refcountTableOffset
+		if err := img.growRefcountTable(int64(len(img.refcountTable)) + 10); err != nil {
+			t.Fatalf("unable to grow refcount table: %v", err)
+		}
+		if img.header.refcountTableOffset == oldOffset {
+			t.Errorf("refcount table did not move")
+		}
+		checkRefcounts(t, img)
+		// the old table clusters must now be free
+		count, err := img.refcount(int64(oldOffset) / img.clusterSize)
+		if err != nil {
+			t.Fatalf("unable to read refcount: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("old refcount table cluster has refcount %d instead of 0", count)
+		}
+	})
+}
+
+func TestRefcountEncoding(t *testing.T) {
+	for _, bits := range []int64{1, 2, 4, 8, 16, 32, 64} {
+		width := bits / 8
+		if width == 0 {
+			width = 1
+		}
+		max := uint64(1)<<bits - 1
+		if bits == 64 {
+			max = ^uint64(0)
+		}
+		for _, shift := range []uint{0, uint(8 - bits)} {
+			if bits >= 8 {
+				shift = 0
+			}
+			b := make([]byte, width)
+			for i := range b {
+				b[i] = 0xa5
+			}
+			encodeRefcount(b, bits, shift, max)
+			if v := decodeRefcount(b, bits, shift); v != max {
+				t.Errorf("%d bits shift %d: decoded %d instead of %d", bits, shift, v, max)
+			}
+			encodeRefcount(b, bits, shift, 1)
+			if v := decodeRefcount(b, bits, shift); v != 1 {
+				t.Errorf("%d bits shift %d: decoded %d instead of 1", bits, shift, v)
+			}
+		}
+	}
+}
+
+func TestCompressedCluster(t *testing.T) {
+	img := testCreateImage(t, 1024*1024, 0)
+	data := bytes.Repeat([]byte("compressible "), int(img.clusterSize)/13+1)[:img.clusterSize]
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	// place the compressed data at the start of a fresh cluster and point guest cluster 3 at it
+	offset, err := img.allocateClusters(1)
+	if err != nil {
+		t.Fatalf("unable to allocate cluster: %v", err)
+	}
+	if _, err := img.file.WriteAt(buf.Bytes(), offset); err != nil {
+		t.Fatalf("unable to write compressed data: %v", err)
+	}
+	x := 62 - (img.header.clusterBits - 8)
+	sectors := uint64(divRoundUp(int64(buf.Len()), 512) - 1)
+	if err := img.setL2Entry(3, entryCompressed|sectors<<x|uint64(offset)); err != nil {
+		t.Fatalf("unable to set L2 entry: %v", err)
+	}
+
+	b := make([]byte, img.clusterSize)
+	if _, err := img.ReadAt(b, 3*img.clusterSize); err != nil {
+		t.Fatalf("unable to read compressed cluster: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatalf("mismatched compressed cluster contents")
+	}
+
+	// overwriting part of it must decompress into a new cluster and free the old one
+	if _, err := img.WriteAt([]byte("X"), 3*img.clusterSize+5); err != nil {
+		t.Fatalf("unable to write to compressed cluster: %v", err)
+	}
+	data[5] = 'X'
+	if _, err := img.ReadAt(b, 3*img.clusterSize); err != nil {
+		t.Fatalf("unable to read rewritten cluster: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("mismatched rewritten cluster contents")
+	}
+	if count, _ := img.refcount(offset / img.clusterSize); count != 0 {
+		t.Errorf("compressed cluster still has refcount %d", count)
+	}
+	checkRefcounts(t, img)
+}
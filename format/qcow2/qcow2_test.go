@@ -0,0 +1,269 @@
+package qcow2_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/format/qcow2"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		size        int64
+		clusterSize int64
+		err         bool
+	}{
+		{0, 0, true},
+		{-1, 0, true},
+		{10 * 1024 * 1024, 1000, true},
+		{10 * 1024 * 1024, 256, true},
+		{10 * 1024 * 1024, 0, false},
+		{10 * 1024 * 1024, 512, false},
+		{10*1024*1024 + 3, 4096, false},
+	}
+	for i, tt := range tests {
+		f := testhelper.TempFile(t, "qcow2_test")
+		img, err := qcow2.Create(f, tt.size, tt.clusterSize)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%d: expected error, got none", i)
+		case !tt.err && err != nil:
+			t.Errorf("%d: unexpected error: %v", i, err)
+		case !tt.err && img.Size() != tt.size:
+			t.Errorf("%d: size %d instead of %d", i, img.Size(), tt.size)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	size := int64(20 * 1024 * 1024)
+	f := testhelper.TempFile(t, "qcow2_test")
+	img, err := qcow2.Create(f, size, 4096)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+
+	// unwritten areas read as zeroes
+	b := make([]byte, 10000)
+	for i := range b {
+		b[i] = 0xff
+	}
+	if _, err := img.ReadAt(b, 12345); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("unallocated clusters did not read as zeroes")
+	}
+
+	// writes spanning clusters and L2 tables
+	writes := map[int64][]byte{
+		0:               make([]byte, 512),
+		4000:            make([]byte, 9000),
+		2*1024*1024 - 7: make([]byte, 100),
+		size - 300:      make([]byte, 300),
+	}
+	for off, data := range writes {
+		_, _ = rand.Read(data)
+		n, err := img.WriteAt(data, off)
+		if err != nil {
+			t.Fatalf("unable to write %d bytes at %d: %v", len(data), off, err)
+		}
+		if n != len(data) {
+			t.Fatalf("wrote %d bytes instead of %d", n, len(data))
+		}
+	}
+	if _, err := img.WriteAt([]byte{1}, size); err == nil {
+		t.Errorf("expected error writing beyond end of disk")
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("unable to stat image file: %v", err)
+	}
+	if fileInfo.Size() >= size/4 {
+		t.Errorf("image file of %d bytes is not sparse", fileInfo.Size())
+	}
+
+	check := func(img *qcow2.Image) {
+		t.Helper()
+		for off, data := range writes {
+			b := make([]byte, len(data))
+			if _, err := img.ReadAt(b, off); err != nil && err != io.EOF {
+				t.Fatalf("unable to read %d bytes at %d: %v", len(b), off, err)
+			}
+			if !bytes.Equal(b, data) {
+				t.Errorf("mismatched data at %d", off)
+			}
+		}
+		b := make([]byte, 10)
+		n, err := img.ReadAt(b, size-5)
+		if err != io.EOF || n != 5 {
+			t.Errorf("read past end returned %d, %v instead of 5, EOF", n, err)
+		}
+	}
+	check(img)
+
+	// reopen the image read-only
+	img2, err := qcow2.Open(f, false)
+	if err != nil {
+		t.Fatalf("unable to open image: %v", err)
+	}
+	if img2.Size() != size {
+		t.Errorf("reopened size %d instead of %d", img2.Size(), size)
+	}
+	check(img2)
+	if _, err := img2.WriteAt([]byte{1}, 0); err == nil {
+		t.Errorf("expected error writing to read-only image")
+	}
+
+	// reopen writable and overwrite
+	img3, err := qcow2.Open(f, true)
+	if err != nil {
+		t.Fatalf("unable to open image writable: %v", err)
+	}
+	data := make([]byte, 20000)
+	_, _ = rand.Read(data)
+	writes[100000] = data
+	if _, err := img3.WriteAt(data, 100000); err != nil {
+		t.Fatalf("unable to write to reopened image: %v", err)
+	}
+	check(img3)
+}
+
+func TestOpenInvalid(t *testing.T) {
+	f := testhelper.TempFile(t, "qcow2_test")
+	if _, err := f.WriteAt(make([]byte, 4096), 0); err != nil {
+		t.Fatalf("unable to write tempfile: %v", err)
+	}
+	if _, err := qcow2.Open(f, false); err == nil {
+		t.Errorf("expected error opening non-qcow2 file")
+	}
+}
+
+func TestPartitionAndFilesystem(t *testing.T) {
+	size := int64(100 * 1024 * 1024)
+	f := testhelper.TempFile(t, "qcow2_test")
+	img, err := qcow2.Create(f, size, 0)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	table := &gpt.Table{
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 50*1024*2 - 1, Type: gpt.EFISystemPartition, Name: "EFI System"},
+		},
+	}
+	if err := table.Write(img, size); err != nil {
+		t.Fatalf("unable to write partition table: %v", err)
+	}
+	part := table.Partitions[0]
+	fs, err := fat32.Create(img, part.GetSize(), part.GetStart(), 512, "QCOW")
+	if err != nil {
+		t.Fatalf("unable to create filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/EFI/BOOT"); err != nil {
+		t.Fatalf("unable to mkdir: %v", err)
+	}
+	content := make([]byte, 300000)
+	_, _ = rand.Read(content)
+	rw, err := fs.OpenFile("/EFI/BOOT/BOOTX64.EFI", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("unable to create file: %v", err)
+	}
+	if _, err := rw.Write(content); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	// read it all back through a freshly opened image
+	img2, err := qcow2.Open(f, false)
+	if err != nil {
+		t.Fatalf("unable to open image: %v", err)
+	}
+	table2, err := gpt.Read(img2, 512, 512)
+	if err != nil {
+		t.Fatalf("unable to read partition table: %v", err)
+	}
+	if len(table2.Partitions) == 0 || table2.Partitions[0].Start != 2048 {
+		t.Fatalf("mismatched partition table")
+	}
+	fs2, err := fat32.Read(img2, part.GetSize(), part.GetStart(), 512)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	ro, err := fs2.OpenFile("/EFI/BOOT/BOOTX64.EFI", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open file: %v", err)
+	}
+	b, err := io.ReadAll(ro)
+	if err != nil {
+		t.Fatalf("unable to read file: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("mismatched file contents")
+	}
+}
+
+// TestQemuImg checks images against qemu-img: one written here must pass qemu-img check and hold the same disk for
+// qemu-img, and one that qemu-img writes must hold the same disk here
+func TestQemuImg(t *testing.T) {
+	testhelper.QemuImg(t, "--version")
+	size := int64(20 * 1024 * 1024)
+	disk := make([]byte, size)
+	extents := [][2]int64{{0, 512}, {70000, 100000}, {2*1024*1024 - 7, 300}, {15 * 1024 * 1024, 1024 * 1024}}
+	for _, e := range extents {
+		_, _ = rand.Read(disk[e[0] : e[0]+e[1]])
+	}
+
+	t.Run("written", func(t *testing.T) {
+		f := testhelper.TempFile(t, "qcow2_test")
+		img, err := qcow2.Create(f, size, 0)
+		if err != nil {
+			t.Fatalf("unable to create image: %v", err)
+		}
+		for _, e := range extents {
+			if _, err := img.WriteAt(disk[e[0]:e[0]+e[1]], e[0]); err != nil {
+				t.Fatalf("unable to write %d bytes at %d: %v", e[1], e[0], err)
+			}
+		}
+		if err := img.Close(); err != nil {
+			t.Fatalf("unable to close image: %v", err)
+		}
+		testhelper.QemuImgCheck(t, "qcow2", f.Name())
+		if !bytes.Equal(testhelper.QemuImgRead(t, "qcow2", f.Name()), disk) {
+			t.Errorf("qemu-img read a different disk than was written")
+		}
+	})
+
+	for _, args := range [][]string{nil, {"-c"}, {"-o", "cluster_size=4096"}} {
+		args := args
+		t.Run(fmt.Sprintf("qemu-img %v", args), func(t *testing.T) {
+			f, err := os.Open(testhelper.QemuImgWrite(t, disk, "qcow2", args...))
+			if err != nil {
+				t.Fatalf("unable to open image file: %v", err)
+			}
+			defer f.Close()
+			img, err := qcow2.Open(f, false)
+			if err != nil {
+				t.Fatalf("unable to open image: %v", err)
+			}
+			if img.Size() != size {
+				t.Fatalf("size %d instead of %d", img.Size(), size)
+			}
+			b := make([]byte, size)
+			if _, err := img.ReadAt(b, 0); err != nil && err != io.EOF {
+				t.Fatalf("unable to read disk: %v", err)
+			}
+			if !bytes.Equal(b, disk) {
+				t.Errorf("read a different disk than qemu-img wrote")
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package qcow2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// clustersPerRefcountBlock returns how many host clusters a single refcount block covers
+func (i *Image) clustersPerRefcountBlock() int64 {
+	return i.clusterSize * 8 / i.refcountBits
+}
+
+// allocateClusters allocates count contiguous host clusters at the end of the image file,
+// marking them in use, and returns the host offset of the first one
+func (i *Image) allocateClusters(count int64) (int64, error) {
+	first := i.nextCluster
+	i.nextCluster += count
+	for c := first; c < first+count; c++ {
+		if err := i.setRefcount(c, 1); err != nil {
+			return 0, fmt.Errorf("unable to allocate cluster %d: %v", c, err)
+		}
+	}
+	return first * i.clusterSize, nil
+}
+
+// refcountLocation returns the host offset of the bytes holding the refcount for a host cluster,
+// along with the bit shift within those bytes, or 0 if there is no refcount block for it yet
+func (i *Image) refcountLocation(cluster int64) (offset int64, shift uint, err error) {
+	perBlock := i.clustersPerRefcountBlock()
+	blockIndex := cluster / perBlock
+	if blockIndex >= int64(len(i.refcountTable)) {
+		return 0, 0, nil
+	}
+	block := int64(i.refcountTable[blockIndex] & refcountOffsetMask)
+	if block == 0 {
+		return 0, 0, nil
+	}
+	bit := (cluster % perBlock) * i.refcountBits
+	// refcounts narrower than a byte are packed starting at the least significant bit
+	if i.refcountBits < 8 {
+		shift = uint(bit % 8)
+	}
+	return block + bit/8, shift, nil
+}
+
+// refcount returns the refcount of a host cluster
+func (i *Image) refcount(cluster int64) (uint64, error) {
+	offset, shift, err := i.refcountLocation(cluster)
+	if err != nil || offset == 0 {
+		return 0, err
+	}
+	b, err := i.readRefcountBytes(offset)
+	if err != nil {
+		return 0, err
+	}
+	return decodeRefcount(b, i.refcountBits, shift), nil
+}
+
+// setRefcount sets the refcount of a host cluster, creating refcount blocks and growing
+// the refcount table as needed
+func (i *Image) setRefcount(cluster int64, value uint64) error {
+	if i.refcountBits < 64 && value >= uint64(1)<<i.refcountBits {
+		return fmt.Errorf("refcount %d too large for %d-bit refcounts", value, i.refcountBits)
+	}
+	perBlock := i.clustersPerRefcountBlock()
+	blockIndex := cluster / perBlock
+	if blockIndex >= int64(len(i.refcountTable)) {
+		if err := i.growRefcountTable(blockIndex); err != nil {
+			return err
+		}
+	}
+	if i.refcountTable[blockIndex]&refcountOffsetMask == 0 {
+		if value == 0 {
+			return nil
+		}
+		// place the new refcount block at the end of the file
+		block := i.nextCluster
+		i.nextCluster++
+		if _, err := i.file.WriteAt(make([]byte, i.clusterSize), block*i.clusterSize); err != nil {
+			return fmt.Errorf("unable to write refcount block: %v", err)
+		}
+		i.refcountTable[blockIndex] = uint64(block * i.clusterSize)
+		if err := i.writeTableEntry(int64(i.header.refcountTableOffset)+blockIndex*8, i.refcountTable[blockIndex]); err != nil {
+			return fmt.Errorf("unable to write refcount table entry: %v", err)
+		}
+		// the refcount block itself is in use
+		if err := i.setRefcount(block, 1); err != nil {
+			return err
+		}
+	}
+	offset, shift, err := i.refcountLocation(cluster)
+	if err != nil {
+		return err
+	}
+	b, err := i.readRefcountBytes(offset)
+	if err != nil {
+		return err
+	}
+	encodeRefcount(b, i.refcountBits, shift, value)
+	if _, err := i.file.WriteAt(b, offset); err != nil {
+		return fmt.Errorf("unable to write refcount: %v", err)
+	}
+	return nil
+}
+
+// decrementRefcount drops a reference to a host cluster. Clusters whose refcount drops to 0 are
+// free, but are not reused.
+func (i *Image) decrementRefcount(cluster int64) error {
+	count, err := i.refcount(cluster)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("cluster %d already has refcount 0", cluster)
+	}
+	return i.setRefcount(cluster, count-1)
+}
+
+// growRefcountTable replaces the refcount table with a larger copy at the end of the file,
+// large enough to hold an entry at index minIndex
+func (i *Image) growRefcountTable(minIndex int64) error {
+	perBlock := i.clustersPerRefcountBlock()
+	entries := int64(len(i.refcountTable)) * 2
+	if entries <= minIndex {
+		entries = minIndex + 1
+	}
+	// the new table must also cover itself and the refcount blocks needed to describe it
+	var tableClusters int64
+	for {
+		tableClusters = divRoundUp(entries*8, i.clusterSize)
+		lastCluster := i.nextCluster + tableClusters + divRoundUp(tableClusters, perBlock) + 1
+		needed := lastCluster/perBlock + 1
+		if needed <= entries {
+			break
+		}
+		entries = needed
+	}
+	entries = tableClusters * i.clusterSize / 8
+
+	table := make([]uint64, entries)
+	copy(table, i.refcountTable)
+	first := i.nextCluster
+	i.nextCluster += tableClusters
+	b := make([]byte, tableClusters*i.clusterSize)
+	for j, e := range table {
+		binary.BigEndian.PutUint64(b[j*8:], e)
+	}
+	if _, err := i.file.WriteAt(b, first*i.clusterSize); err != nil {
+		return fmt.Errorf("unable to write new refcount table: %v", err)
+	}
+
+	oldOffset := int64(i.header.refcountTableOffset)
+	oldClusters := int64(i.header.refcountTableClusters)
+	i.header.refcountTableOffset = uint64(first * i.clusterSize)
+	i.header.refcountTableClusters = uint32(tableClusters)
+	if _, err := i.file.WriteAt(i.header.toBytes(), 0); err != nil {
+		return fmt.Errorf("unable to write qcow2 header: %v", err)
+	}
+	i.refcountTable = table
+
+	for c := first; c < first+tableClusters; c++ {
+		if err := i.setRefcount(c, 1); err != nil {
+			return err
+		}
+	}
+	for c := oldOffset / i.clusterSize; c < oldOffset/i.clusterSize+oldClusters; c++ {
+		if err := i.setRefcount(c, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRefcountBytes reads the bytes holding a single refcount at the given host offset
+func (i *Image) readRefcountBytes(offset int64) ([]byte, error) {
+	width := i.refcountBits / 8
+	if width == 0 {
+		width = 1
+	}
+	b := make([]byte, width)
+	if _, err := i.file.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("unable to read refcount: %v", err)
+	}
+	return b, nil
+}
+
+func decodeRefcount(b []byte, bits int64, shift uint) uint64 {
+	if bits < 8 {
+		return uint64(b[0]>>shift) & (uint64(1)<<bits - 1)
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func encodeRefcount(b []byte, bits int64, shift uint, value uint64) {
+	if bits < 8 {
+		mask := byte(uint64(1)<<bits-1) << shift
+		b[0] = b[0]&^mask | byte(value<<shift)&mask
+		return
+	}
+	for j := len(b) - 1; j >= 0; j-- {
+		b[j] = byte(value)
+		value >>= 8
+	}
+}
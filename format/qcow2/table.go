@@ -0,0 +1,94 @@
+package qcow2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// l2Entry returns the L2 table entry for the guest cluster with the given index,
+// or 0 if no L2 table covers the cluster yet
+func (i *Image) l2Entry(cluster int64) (uint64, error) {
+	l1Index := cluster / i.l2Entries
+	if l1Index >= int64(len(i.l1)) {
+		return 0, fmt.Errorf("cluster %d is beyond the L1 table", cluster)
+	}
+	l2Offset := i.l1[l1Index] & entryOffsetMask
+	if l2Offset == 0 {
+		return 0, nil
+	}
+	l2, err := i.l2Table(l2Offset)
+	if err != nil {
+		return 0, err
+	}
+	return l2[cluster%i.l2Entries], nil
+}
+
+// l2Table returns the L2 table at the given host offset, reading it if it is not cached yet
+func (i *Image) l2Table(offset uint64) ([]uint64, error) {
+	if l2, ok := i.l2Cache[offset]; ok {
+		return l2, nil
+	}
+	l2, err := i.readTable(offset, i.l2Entries)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read L2 table at %d: %v", offset, err)
+	}
+	i.l2Cache[offset] = l2
+	return l2, nil
+}
+
+// setL2Entry sets the L2 table entry for the guest cluster with the given index,
+// allocating the L2 table, or copying it if it is shared, as needed
+func (i *Image) setL2Entry(cluster int64, value uint64) error {
+	l1Index := cluster / i.l2Entries
+	l1Entry := i.l1[l1Index]
+	l2Offset := l1Entry & entryOffsetMask
+
+	// either we have no L2 table, or it is shared with a snapshot; both require a new one
+	if l2Offset == 0 || l1Entry&entryCopied == 0 {
+		var l2 []uint64
+		if l2Offset == 0 {
+			l2 = make([]uint64, i.l2Entries)
+		} else {
+			old, err := i.l2Table(l2Offset)
+			if err != nil {
+				return err
+			}
+			l2 = make([]uint64, len(old))
+			copy(l2, old)
+		}
+		newOffset, err := i.allocateClusters(1)
+		if err != nil {
+			return fmt.Errorf("unable to allocate L2 table: %v", err)
+		}
+		b := make([]byte, i.clusterSize)
+		for j, e := range l2 {
+			binary.BigEndian.PutUint64(b[j*8:], e)
+		}
+		if _, err := i.file.WriteAt(b, newOffset); err != nil {
+			return fmt.Errorf("unable to write L2 table: %v", err)
+		}
+		i.l2Cache[uint64(newOffset)] = l2
+		i.l1[l1Index] = uint64(newOffset) | entryCopied
+		if err := i.writeTableEntry(int64(i.header.l1TableOffset)+l1Index*8, i.l1[l1Index]); err != nil {
+			return fmt.Errorf("unable to write L1 table entry: %v", err)
+		}
+		if l2Offset != 0 {
+			delete(i.l2Cache, l2Offset)
+			if err := i.decrementRefcount(int64(l2Offset) / i.clusterSize); err != nil {
+				return err
+			}
+		}
+		l2Offset = uint64(newOffset)
+	}
+
+	l2, err := i.l2Table(l2Offset)
+	if err != nil {
+		return err
+	}
+	l2Index := cluster % i.l2Entries
+	l2[l2Index] = value
+	if err := i.writeTableEntry(int64(l2Offset)+l2Index*8, value); err != nil {
+		return fmt.Errorf("unable to write L2 table entry: %v", err)
+	}
+	return nil
+}
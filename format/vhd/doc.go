@@ -0,0 +1,19 @@
+// Package vhd provides support for reading and creating Microsoft Virtual Hard Disk (VHD) images,
+// both fixed and dynamic.
+//
+// A fixed VHD is the raw disk contents followed by a 512-byte footer. A dynamic VHD begins with a copy
+// of the footer and a dynamic disk header, followed by the Block Allocation Table (BAT), and allocates
+// blocks of the disk only when they are written.
+//
+// An Image wraps a util.File holding the VHD and itself implements util.File, presenting the virtual disk.
+//
+// Normally, the best way to work with a VHD image is through github.com/diskfs/go-diskfs,
+// by passing diskfs.VHDFixed or diskfs.VHDDynamic to Create() or WithFormat() for Open().
+//
+// Differencing disks are not supported.
+//
+// references:
+//
+//	https://www.microsoft.com/en-us/download/details.aspx?id=23850
+//	https://learn.microsoft.com/en-us/windows/win32/vstor/about-vhd
+package vhd
@@ -0,0 +1,61 @@
+package vhd
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	dynamicHeaderSize    = 1024
+	dynamicHeaderCookie  = "cxsparse"
+	dynamicHeaderVersion = 0x00010000
+	// DefaultBlockSize is the block size used for new dynamic disks, matching Hyper-V and Virtual PC
+	DefaultBlockSize int64 = 2 * 1024 * 1024
+	// MaxDynamicSize is the largest size of a dynamic disk supported by the format
+	MaxDynamicSize int64  = 2040 * 1024 * 1024 * 1024
+	unusedBATEntry uint32 = 0xffffffff
+)
+
+// dynamicHeader is the header following the footer copy at the beginning of a dynamic VHD
+type dynamicHeader struct {
+	dataOffset      uint64
+	tableOffset     uint64
+	headerVersion   uint32
+	maxTableEntries uint32
+	blockSize       uint32
+}
+
+func dynamicHeaderFromBytes(b []byte) (*dynamicHeader, error) {
+	if len(b) != dynamicHeaderSize {
+		return nil, fmt.Errorf("cannot read VHD dynamic header from %d bytes, must be exactly %d", len(b), dynamicHeaderSize)
+	}
+	if string(b[0:8]) != dynamicHeaderCookie {
+		return nil, fmt.Errorf("invalid VHD dynamic header cookie %q", b[0:8])
+	}
+	if expected, actual := binary.BigEndian.Uint32(b[36:40]), checksum(b, 36); expected != actual {
+		return nil, fmt.Errorf("invalid VHD dynamic header checksum %x, calculated %x", expected, actual)
+	}
+	h := &dynamicHeader{
+		dataOffset:      binary.BigEndian.Uint64(b[8:16]),
+		tableOffset:     binary.BigEndian.Uint64(b[16:24]),
+		headerVersion:   binary.BigEndian.Uint32(b[24:28]),
+		maxTableEntries: binary.BigEndian.Uint32(b[28:32]),
+		blockSize:       binary.BigEndian.Uint32(b[32:36]),
+	}
+	if h.blockSize == 0 || h.blockSize%512 != 0 {
+		return nil, fmt.Errorf("invalid VHD block size %d", h.blockSize)
+	}
+	return h, nil
+}
+
+func (h *dynamicHeader) toBytes() []byte {
+	b := make([]byte, dynamicHeaderSize)
+	copy(b[0:8], dynamicHeaderCookie)
+	binary.BigEndian.PutUint64(b[8:16], h.dataOffset)
+	binary.BigEndian.PutUint64(b[16:24], h.tableOffset)
+	binary.BigEndian.PutUint32(b[24:28], h.headerVersion)
+	binary.BigEndian.PutUint32(b[28:32], h.maxTableEntries)
+	binary.BigEndian.PutUint32(b[32:36], h.blockSize)
+	binary.BigEndian.PutUint32(b[36:40], checksum(b, 36))
+	return b
+}
@@ -0,0 +1,198 @@
+package vhd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	footerSize         = 512
+	footerCookie       = "conectix"
+	footerFeatures     = 0x00000002
+	footerVersion      = 0x00010000
+	fixedDataOffset    = 0xffffffffffffffff
+	creatorApplication = "gdfs"
+	creatorVersion     = 0x00010000
+	creatorHostOS      = 0x5769326b // "Wi2k"
+)
+
+// DiskType is the type of a VHD disk
+type DiskType uint32
+
+const (
+	// Fixed is a disk whose full size is allocated in the file up front
+	Fixed DiskType = 2
+	// Dynamic is a disk that grows as it is written
+	Dynamic DiskType = 3
+	// Differencing is a disk holding changes to a parent disk; it is not supported
+	Differencing DiskType = 4
+)
+
+// vhdEpoch is the reference for all VHD timestamps
+var vhdEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// geometry is the CHS geometry of the disk
+type geometry struct {
+	cylinders       uint16
+	heads           uint8
+	sectorsPerTrack uint8
+}
+
+// footer is the structure found at the end of every VHD, and copied at the beginning of dynamic ones
+type footer struct {
+	features           uint32
+	version            uint32
+	dataOffset         uint64
+	timestamp          time.Time
+	creatorApplication string
+	creatorVersion     uint32
+	creatorHostOS      uint32
+	originalSize       uint64
+	currentSize        uint64
+	geometry           geometry
+	diskType           DiskType
+	uniqueID           uuid.UUID
+	savedState         bool
+}
+
+func (f *footer) equal(a *footer) bool {
+	if (f == nil && a != nil) || (a == nil && f != nil) {
+		return false
+	}
+	if f == nil && a == nil {
+		return true
+	}
+	return f.features == a.features &&
+		f.version == a.version &&
+		f.dataOffset == a.dataOffset &&
+		f.timestamp.Equal(a.timestamp) &&
+		f.creatorApplication == a.creatorApplication &&
+		f.creatorVersion == a.creatorVersion &&
+		f.creatorHostOS == a.creatorHostOS &&
+		f.originalSize == a.originalSize &&
+		f.currentSize == a.currentSize &&
+		f.geometry == a.geometry &&
+		f.diskType == a.diskType &&
+		f.uniqueID == a.uniqueID &&
+		f.savedState == a.savedState
+}
+
+func footerFromBytes(b []byte) (*footer, error) {
+	if len(b) != footerSize {
+		return nil, fmt.Errorf("cannot read VHD footer from %d bytes, must be exactly %d", len(b), footerSize)
+	}
+	if string(b[0:8]) != footerCookie {
+		return nil, fmt.Errorf("invalid VHD footer cookie %q", b[0:8])
+	}
+	if expected, actual := binary.BigEndian.Uint32(b[64:68]), checksum(b, 64); expected != actual {
+		return nil, fmt.Errorf("invalid VHD footer checksum %x, calculated %x", expected, actual)
+	}
+	f := &footer{
+		features:           binary.BigEndian.Uint32(b[8:12]),
+		version:            binary.BigEndian.Uint32(b[12:16]),
+		dataOffset:         binary.BigEndian.Uint64(b[16:24]),
+		timestamp:          vhdEpoch.Add(time.Duration(binary.BigEndian.Uint32(b[24:28])) * time.Second),
+		creatorApplication: string(b[28:32]),
+		creatorVersion:     binary.BigEndian.Uint32(b[32:36]),
+		creatorHostOS:      binary.BigEndian.Uint32(b[36:40]),
+		originalSize:       binary.BigEndian.Uint64(b[40:48]),
+		currentSize:        binary.BigEndian.Uint64(b[48:56]),
+		geometry: geometry{
+			cylinders:       binary.BigEndian.Uint16(b[56:58]),
+			heads:           b[58],
+			sectorsPerTrack: b[59],
+		},
+		diskType:   DiskType(binary.BigEndian.Uint32(b[60:64])),
+		savedState: b[84] != 0,
+	}
+	if f.version>>16 != footerVersion>>16 {
+		return nil, fmt.Errorf("unsupported VHD version %x", f.version)
+	}
+	copy(f.uniqueID[:], b[68:84])
+	return f, nil
+}
+
+func (f *footer) toBytes() []byte {
+	b := make([]byte, footerSize)
+	copy(b[0:8], footerCookie)
+	binary.BigEndian.PutUint32(b[8:12], f.features)
+	binary.BigEndian.PutUint32(b[12:16], f.version)
+	binary.BigEndian.PutUint64(b[16:24], f.dataOffset)
+	binary.BigEndian.PutUint32(b[24:28], uint32(f.timestamp.Sub(vhdEpoch)/time.Second))
+	copy(b[28:32], fmt.Sprintf("%-4.4s", f.creatorApplication))
+	binary.BigEndian.PutUint32(b[32:36], f.creatorVersion)
+	binary.BigEndian.PutUint32(b[36:40], f.creatorHostOS)
+	binary.BigEndian.PutUint64(b[40:48], f.originalSize)
+	binary.BigEndian.PutUint64(b[48:56], f.currentSize)
+	binary.BigEndian.PutUint16(b[56:58], f.geometry.cylinders)
+	b[58] = f.geometry.heads
+	b[59] = f.geometry.sectorsPerTrack
+	binary.BigEndian.PutUint32(b[60:64], uint32(f.diskType))
+	copy(b[68:84], f.uniqueID[:])
+	if f.savedState {
+		b[84] = 1
+	}
+	binary.BigEndian.PutUint32(b[64:68], checksum(b, 64))
+	return b
+}
+
+// checksum calculates the one's complement of the sum of all bytes, excluding the 4 checksum bytes at
+// checksumOffset
+func checksum(b []byte, checksumOffset int) uint32 {
+	var sum uint32
+	for i, c := range b {
+		if i >= checksumOffset && i < checksumOffset+4 {
+			continue
+		}
+		sum += uint32(c)
+	}
+	return ^sum
+}
+
+// calculateGeometry calculates the CHS geometry for a disk of the given size,
+// using the algorithm from the VHD specification
+func calculateGeometry(size int64) geometry {
+	var (
+		sectorsPerTrack, heads, cylinderTimesHeads int64
+	)
+	totalSectors := size / 512
+	if totalSectors > 65535*16*255 {
+		totalSectors = 65535 * 16 * 255
+	}
+	if totalSectors >= 65535*16*63 {
+		sectorsPerTrack = 255
+		heads = 16
+		cylinderTimesHeads = totalSectors / sectorsPerTrack
+	} else {
+		sectorsPerTrack = 17
+		cylinderTimesHeads = totalSectors / sectorsPerTrack
+		heads = (cylinderTimesHeads + 1023) / 1024
+		if heads < 4 {
+			heads = 4
+		}
+		if cylinderTimesHeads >= heads*1024 || heads > 16 {
+			sectorsPerTrack = 31
+			heads = 16
+			cylinderTimesHeads = totalSectors / sectorsPerTrack
+		}
+		if cylinderTimesHeads >= heads*1024 {
+			sectorsPerTrack = 63
+			heads = 16
+			cylinderTimesHeads = totalSectors / sectorsPerTrack
+		}
+	}
+	return geometry{
+		cylinders:       uint16(cylinderTimesHeads / heads),
+		heads:           uint8(heads),
+		sectorsPerTrack: uint8(sectorsPerTrack),
+	}
+}
+
+// isZero reports whether b is all zeroes
+func isZero(b []byte) bool {
+	return len(bytes.Trim(b, "\x00")) == 0
+}
@@ -0,0 +1,99 @@
+package vhd
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFooterRoundTrip(t *testing.T) {
+	f := &footer{
+		features:           footerFeatures,
+		version:            footerVersion,
+		dataOffset:         footerSize,
+		timestamp:          vhdEpoch.Add(12345 * time.Second),
+		creatorApplication: "qemu",
+		creatorVersion:     0x00050003,
+		creatorHostOS:      creatorHostOS,
+		originalSize:       10 * 1024 * 1024,
+		currentSize:        10 * 1024 * 1024,
+		geometry:           calculateGeometry(10 * 1024 * 1024),
+		diskType:           Dynamic,
+		uniqueID:           uuid.MustParse("5b8c4ef1-7a1c-4c4b-9a44-0c1a3f26e0c5"),
+	}
+	b := f.toBytes()
+	if string(b[0:8]) != footerCookie {
+		t.Errorf("missing footer cookie")
+	}
+	f2, err := footerFromBytes(b)
+	if err != nil {
+		t.Fatalf("unable to read footer: %v", err)
+	}
+	if !f.equal(f2) {
+		t.Errorf("mismatched footer, actual then expected\n%#v\n%#v", f2, f)
+	}
+
+	badChecksum := make([]byte, len(b))
+	copy(badChecksum, b)
+	badChecksum[100]++
+	badVersion := make([]byte, len(b))
+	copy(badVersion, b)
+	binary.BigEndian.PutUint32(badVersion[12:16], 0x00020000)
+	binary.BigEndian.PutUint32(badVersion[64:68], checksum(badVersion, 64))
+	tests := []struct {
+		b   []byte
+		err string
+	}{
+		{b[:100], "cannot read VHD footer from 100 bytes"},
+		{make([]byte, footerSize), "invalid VHD footer cookie"},
+		{badChecksum, "invalid VHD footer checksum"},
+		{badVersion, "unsupported VHD version"},
+	}
+	for i, tt := range tests {
+		_, err := footerFromBytes(tt.b)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%d: mismatched error, actual %v expected prefix %q", i, err, tt.err)
+		}
+	}
+}
+
+func TestDynamicHeaderRoundTrip(t *testing.T) {
+	h := &dynamicHeader{
+		dataOffset:      fixedDataOffset,
+		tableOffset:     1536,
+		headerVersion:   dynamicHeaderVersion,
+		maxTableEntries: 5,
+		blockSize:       uint32(DefaultBlockSize),
+	}
+	h2, err := dynamicHeaderFromBytes(h.toBytes())
+	if err != nil {
+		t.Fatalf("unable to read dynamic header: %v", err)
+	}
+	if *h != *h2 {
+		t.Errorf("mismatched dynamic header, actual %#v expected %#v", h2, h)
+	}
+	bad := h.toBytes()
+	bad[30]++
+	if _, err := dynamicHeaderFromBytes(bad); err == nil {
+		t.Errorf("expected checksum error")
+	}
+}
+
+func TestCalculateGeometry(t *testing.T) {
+	tests := []struct {
+		size int64
+		g    geometry
+	}{
+		{10 * 1024 * 1024, geometry{cylinders: 301, heads: 4, sectorsPerTrack: 17}},
+		{1024 * 1024 * 1024, geometry{cylinders: 2080, heads: 16, sectorsPerTrack: 63}},
+		{4 * 1024 * 1024 * 1024 * 1024, geometry{cylinders: 65535, heads: 16, sectorsPerTrack: 255}},
+	}
+	for _, tt := range tests {
+		if g := calculateGeometry(tt.size); g != tt.g {
+			t.Errorf("size %d: geometry %+v instead of %+v", tt.size, g, tt.g)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package vhd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+// Image is a VHD disk image. It implements util.File for the virtual disk it contains.
+type Image struct {
+	file     util.File
+	footer   *footer
+	writable bool
+	offset   int64
+	// the following only are used for dynamic disks
+	header       *dynamicHeader
+	bat          []uint32
+	blockSize    int64
+	bitmapSize   int64
+	footerOffset int64
+}
+
+// Create creates a new VHD image of the given type with a virtual disk of size bytes, writing it to f
+// starting at offset 0. f is expected to be empty. size must be a multiple of 512.
+//
+// blockSize is used only for Dynamic disks, and must be a power of 2 of at least 512 bytes. If it is 0,
+// DefaultBlockSize is used.
+//
+// The returned Image is writable.
+func Create(f util.File, size int64, diskType DiskType, blockSize int64) (*Image, error) {
+	if size <= 0 || size%512 != 0 {
+		return nil, fmt.Errorf("invalid VHD size %d, must be a positive multiple of 512", size)
+	}
+	ft := &footer{
+		features:           footerFeatures,
+		version:            footerVersion,
+		dataOffset:         fixedDataOffset,
+		timestamp:          time.Now().UTC().Truncate(time.Second),
+		creatorApplication: creatorApplication,
+		creatorVersion:     creatorVersion,
+		creatorHostOS:      creatorHostOS,
+		originalSize:       uint64(size),
+		currentSize:        uint64(size),
+		geometry:           calculateGeometry(size),
+		diskType:           diskType,
+		uniqueID:           uuid.New(),
+	}
+	img := &Image{
+		file:     f,
+		footer:   ft,
+		writable: true,
+	}
+
+	switch diskType {
+	case Fixed:
+		img.footerOffset = size
+	case Dynamic:
+		if size > MaxDynamicSize {
+			return nil, fmt.Errorf("requested size %d is larger than maximum dynamic VHD size %d", size, MaxDynamicSize)
+		}
+		if blockSize == 0 {
+			blockSize = DefaultBlockSize
+		}
+		if blockSize < 512 || blockSize&(blockSize-1) != 0 {
+			return nil, fmt.Errorf("invalid block size %d, must be a power of 2 of at least 512", blockSize)
+		}
+		entries := (size + blockSize - 1) / blockSize
+		tableOffset := int64(footerSize + dynamicHeaderSize)
+		tableSize := roundUp(entries*4, 512)
+		ft.dataOffset = footerSize
+		img.header = &dynamicHeader{
+			dataOffset:      fixedDataOffset,
+			tableOffset:     uint64(tableOffset),
+			headerVersion:   dynamicHeaderVersion,
+			maxTableEntries: uint32(entries),
+			blockSize:       uint32(blockSize),
+		}
+		img.bat = make([]uint32, entries)
+		for i := range img.bat {
+			img.bat[i] = unusedBATEntry
+		}
+		img.setBlockSize(blockSize)
+		img.footerOffset = tableOffset + tableSize
+
+		if _, err := f.WriteAt(ft.toBytes(), 0); err != nil {
+			return nil, fmt.Errorf("unable to write VHD footer copy: %v", err)
+		}
+		if _, err := f.WriteAt(img.header.toBytes(), footerSize); err != nil {
+			return nil, fmt.Errorf("unable to write VHD dynamic header: %v", err)
+		}
+		b := make([]byte, tableSize)
+		for i := range b {
+			b[i] = 0xff
+		}
+		if _, err := f.WriteAt(b, tableOffset); err != nil {
+			return nil, fmt.Errorf("unable to write VHD block allocation table: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported VHD disk type %d", diskType)
+	}
+
+	if _, err := f.WriteAt(ft.toBytes(), img.footerOffset); err != nil {
+		return nil, fmt.Errorf("unable to write VHD footer: %v", err)
+	}
+	return img, nil
+}
+
+// Open opens an existing fixed or dynamic VHD image in f. If writable is false, any attempt to write to the
+// returned Image will return an error.
+func Open(f util.File, writable bool) (*Image, error) {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find end of VHD file: %v", err)
+	}
+	if end < footerSize {
+		return nil, fmt.Errorf("file of %d bytes is too small to be a VHD", end)
+	}
+	b := make([]byte, footerSize)
+	if _, err := f.ReadAt(b, end-footerSize); err != nil {
+		return nil, fmt.Errorf("unable to read VHD footer: %v", err)
+	}
+	ft, err := footerFromBytes(b)
+	footerAtEnd := err == nil
+	if !footerAtEnd {
+		// a dynamic disk keeps a copy of the footer at the beginning, so try that one
+		if _, err2 := f.ReadAt(b, 0); err2 != nil {
+			return nil, fmt.Errorf("unable to read VHD footer copy: %v", err2)
+		}
+		var err2 error
+		if ft, err2 = footerFromBytes(b); err2 != nil || ft.diskType != Dynamic {
+			return nil, fmt.Errorf("invalid VHD footer: %v", err)
+		}
+	}
+
+	img := &Image{
+		file:         f,
+		footer:       ft,
+		writable:     writable,
+		footerOffset: end - footerSize,
+	}
+	switch ft.diskType {
+	case Fixed:
+		if int64(ft.currentSize) > end-footerSize {
+			return nil, fmt.Errorf("fixed VHD of size %d is larger than its file", ft.currentSize)
+		}
+		return img, nil
+	case Dynamic:
+	case Differencing:
+		return nil, errors.New("differencing VHD disks are not supported")
+	default:
+		return nil, fmt.Errorf("unknown VHD disk type %d", ft.diskType)
+	}
+
+	hb := make([]byte, dynamicHeaderSize)
+	if _, err := f.ReadAt(hb, int64(ft.dataOffset)); err != nil {
+		return nil, fmt.Errorf("unable to read VHD dynamic header: %v", err)
+	}
+	if img.header, err = dynamicHeaderFromBytes(hb); err != nil {
+		return nil, fmt.Errorf("invalid VHD dynamic header: %v", err)
+	}
+	img.setBlockSize(int64(img.header.blockSize))
+	if int64(img.header.maxTableEntries)*img.blockSize < int64(ft.currentSize) {
+		return nil, fmt.Errorf("VHD block allocation table with %d entries is too small for disk size %d", img.header.maxTableEntries, ft.currentSize)
+	}
+	bat := make([]byte, int64(img.header.maxTableEntries)*4)
+	if _, err := f.ReadAt(bat, int64(img.header.tableOffset)); err != nil {
+		return nil, fmt.Errorf("unable to read VHD block allocation table: %v", err)
+	}
+	img.bat = make([]uint32, img.header.maxTableEntries)
+	for i := range img.bat {
+		img.bat[i] = binary.BigEndian.Uint32(bat[i*4:])
+	}
+	if !footerAtEnd {
+		// the footer at the end was damaged; new blocks go right after the last existing one
+		img.footerOffset = roundUp(int64(img.header.tableOffset)+int64(len(bat)), 512)
+		for _, e := range img.bat {
+			if e != unusedBATEntry && int64(e)*512+img.bitmapSize+img.blockSize > img.footerOffset {
+				img.footerOffset = int64(e)*512 + img.bitmapSize + img.blockSize
+			}
+		}
+	}
+	return img, nil
+}
+
+// Size returns the size in bytes of the virtual disk
+func (i *Image) Size() int64 {
+	return int64(i.footer.currentSize)
+}
+
+// Type returns the type of the disk, Fixed or Dynamic
+func (i *Image) Type() DiskType {
+	return i.footer.diskType
+}
+
+// ReadAt reads len(b) bytes from the virtual disk starting at byte offset off.
+// Unallocated blocks of a dynamic disk read as zeros.
+func (i *Image) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	size := i.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var retErr error
+	if int64(len(b)) > size-off {
+		b = b[:size-off]
+		retErr = io.EOF
+	}
+	if i.footer.diskType == Fixed {
+		n, err := i.file.ReadAt(b, off)
+		if err != nil {
+			return n, err
+		}
+		return n, retErr
+	}
+	read := 0
+	for read < len(b) {
+		pos := off + int64(read)
+		block := pos / i.blockSize
+		inBlock := pos % i.blockSize
+		n := int(i.blockSize - inBlock)
+		if n > len(b)-read {
+			n = len(b) - read
+		}
+		chunk := b[read : read+n]
+		if entry := i.bat[block]; entry == unusedBATEntry {
+			for j := range chunk {
+				chunk[j] = 0
+			}
+		} else if _, err := i.file.ReadAt(chunk, int64(entry)*512+i.bitmapSize+inBlock); err != nil {
+			return read, fmt.Errorf("unable to read block %d: %v", block, err)
+		}
+		read += n
+	}
+	return read, retErr
+}
+
+// WriteAt writes len(b) bytes to the virtual disk starting at byte offset off,
+// allocating blocks of a dynamic disk as required. Writing zeros to an unallocated block does not allocate it.
+func (i *Image) WriteAt(b []byte, off int64) (int, error) {
+	if !i.writable {
+		return 0, errors.New("VHD image not open for writing")
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	if off+int64(len(b)) > i.Size() {
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d beyond end of disk of size %d", len(b), off, i.Size())
+	}
+	if i.footer.diskType == Fixed {
+		return i.file.WriteAt(b, off)
+	}
+	written := 0
+	for written < len(b) {
+		pos := off + int64(written)
+		block := pos / i.blockSize
+		inBlock := pos % i.blockSize
+		n := int(i.blockSize - inBlock)
+		if n > len(b)-written {
+			n = len(b) - written
+		}
+		chunk := b[written : written+n]
+		if i.bat[block] == unusedBATEntry {
+			if isZero(chunk) {
+				written += n
+				continue
+			}
+			if err := i.allocateBlock(block); err != nil {
+				return written, err
+			}
+		}
+		if _, err := i.file.WriteAt(chunk, int64(i.bat[block])*512+i.bitmapSize+inBlock); err != nil {
+			return written, fmt.Errorf("unable to write block %d: %v", block, err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Seek sets the offset for the next Read or Write. Only provided to satisfy util.File;
+// all access to the image is through ReadAt and WriteAt.
+func (i *Image) Seek(offset int64, whence int) (int64, error) {
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset += i.offset
+	case io.SeekEnd:
+		newOffset += i.Size()
+	default:
+		return i.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if newOffset < 0 {
+		return i.offset, fmt.Errorf("cannot set offset %d before start of disk", newOffset)
+	}
+	i.offset = newOffset
+	return i.offset, nil
+}
+
+// Close closes the underlying file, if it implements io.Closer. All writes go straight to the file,
+// so there is nothing else to complete.
+func (i *Image) Close() error {
+	if c, ok := i.file.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// allocateBlock places a new, zeroed block where the footer is, and moves the footer after it
+func (i *Image) allocateBlock(block int64) error {
+	blockOffset := i.footerOffset
+	// mark every sector in the block as present; the data itself reads as zeroes until written
+	bitmap := make([]byte, i.bitmapSize)
+	for j := range bitmap {
+		bitmap[j] = 0xff
+	}
+	if _, err := i.file.WriteAt(bitmap, blockOffset); err != nil {
+		return fmt.Errorf("unable to write sector bitmap for block %d: %v", block, err)
+	}
+	// writing the footer after the block extends the file, and the data area in between reads as zeroes
+	newFooterOffset := blockOffset + i.bitmapSize + i.blockSize
+	if _, err := i.file.WriteAt(i.footer.toBytes(), newFooterOffset); err != nil {
+		return fmt.Errorf("unable to write VHD footer: %v", err)
+	}
+	i.footerOffset = newFooterOffset
+	i.bat[block] = uint32(blockOffset / 512)
+	entry := make([]byte, 4)
+	binary.BigEndian.PutUint32(entry, i.bat[block])
+	if _, err := i.file.WriteAt(entry, int64(i.header.tableOffset)+block*4); err != nil {
+		return fmt.Errorf("unable to write VHD block allocation table entry: %v", err)
+	}
+	return nil
+}
+
+func (i *Image) setBlockSize(blockSize int64) {
+	i.blockSize = blockSize
+	// one bit per sector, padded to a whole sector
+	i.bitmapSize = roundUp(blockSize/512/8, 512)
+}
+
+func roundUp(n, multiple int64) int64 {
+	return (n + multiple - 1) / multiple * multiple
+}
@@ -0,0 +1,318 @@
+package vhd_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/format/vhd"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		size      int64
+		diskType  vhd.DiskType
+		blockSize int64
+		fileSize  int64
+		err       bool
+	}{
+		{0, vhd.Fixed, 0, 0, true},
+		{10*1024*1024 + 3, vhd.Fixed, 0, 0, true},
+		{10 * 1024 * 1024, vhd.Differencing, 0, 0, true},
+		{10 * 1024 * 1024, vhd.Dynamic, 1000, 0, true},
+		{vhd.MaxDynamicSize + 512, vhd.Dynamic, 0, 0, true},
+		{10 * 1024 * 1024, vhd.Fixed, 0, 10*1024*1024 + 512, false},
+		// footer copy, header, 5 BAT entries padded to a sector, footer
+		{10 * 1024 * 1024, vhd.Dynamic, 0, 512 + 1024 + 512 + 512, false},
+		{10 * 1024 * 1024, vhd.Dynamic, 4096, 512 + 1024 + 10*1024 + 512, false},
+	}
+	for i, tt := range tests {
+		f := testhelper.TempFile(t, "vhd_test")
+		img, err := vhd.Create(f, tt.size, tt.diskType, tt.blockSize)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%d: expected error, got none", i)
+		case !tt.err && err != nil:
+			t.Errorf("%d: unexpected error: %v", i, err)
+		case !tt.err:
+			if img.Size() != tt.size {
+				t.Errorf("%d: size %d instead of %d", i, img.Size(), tt.size)
+			}
+			if img.Type() != tt.diskType {
+				t.Errorf("%d: type %d instead of %d", i, img.Type(), tt.diskType)
+			}
+			fileInfo, err := f.Stat()
+			if err != nil {
+				t.Fatalf("%d: unable to stat image file: %v", i, err)
+			}
+			if fileInfo.Size() != tt.fileSize {
+				t.Errorf("%d: file size %d instead of %d", i, fileInfo.Size(), tt.fileSize)
+			}
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	size := int64(20 * 1024 * 1024)
+	for _, diskType := range []vhd.DiskType{vhd.Fixed, vhd.Dynamic} {
+		f := testhelper.TempFile(t, "vhd_test")
+		img, err := vhd.Create(f, size, diskType, 0)
+		if err != nil {
+			t.Fatalf("%d: unable to create image: %v", diskType, err)
+		}
+
+		// unwritten areas read as zeroes
+		b := make([]byte, 10000)
+		for i := range b {
+			b[i] = 0xff
+		}
+		if _, err := img.ReadAt(b, 12345); err != nil {
+			t.Fatalf("%d: unable to read: %v", diskType, err)
+		}
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Errorf("%d: unwritten blocks did not read as zeroes", diskType)
+		}
+
+		// writes spanning blocks
+		writes := map[int64][]byte{
+			0:               make([]byte, 512),
+			4000:            make([]byte, 9000),
+			2*1024*1024 - 7: make([]byte, 100),
+			size - 300:      make([]byte, 300),
+		}
+		for off, data := range writes {
+			_, _ = rand.Read(data)
+			n, err := img.WriteAt(data, off)
+			if err != nil {
+				t.Fatalf("%d: unable to write %d bytes at %d: %v", diskType, len(data), off, err)
+			}
+			if n != len(data) {
+				t.Fatalf("%d: wrote %d bytes instead of %d", diskType, n, len(data))
+			}
+		}
+		// zeroes do not allocate blocks
+		if _, err := img.WriteAt(make([]byte, 4096), 8*1024*1024); err != nil {
+			t.Fatalf("%d: unable to write zeroes: %v", diskType, err)
+		}
+		if _, err := img.WriteAt([]byte{1}, size); err == nil {
+			t.Errorf("%d: expected error writing beyond end of disk", diskType)
+		}
+		if diskType == vhd.Dynamic {
+			fileInfo, err := f.Stat()
+			if err != nil {
+				t.Fatalf("unable to stat image file: %v", err)
+			}
+			// 3 blocks written, each with its sector bitmap, plus the metadata
+			if expected := int64(512+1024+512+512) + 3*(2*1024*1024+512); fileInfo.Size() != expected {
+				t.Errorf("dynamic image file of %d bytes instead of %d", fileInfo.Size(), expected)
+			}
+		}
+
+		check := func(img *vhd.Image) {
+			t.Helper()
+			for off, data := range writes {
+				b := make([]byte, len(data))
+				if _, err := img.ReadAt(b, off); err != nil && err != io.EOF {
+					t.Fatalf("%d: unable to read %d bytes at %d: %v", diskType, len(b), off, err)
+				}
+				if !bytes.Equal(b, data) {
+					t.Errorf("%d: mismatched data at %d", diskType, off)
+				}
+			}
+			b := make([]byte, 10)
+			n, err := img.ReadAt(b, size-5)
+			if err != io.EOF || n != 5 {
+				t.Errorf("%d: read past end returned %d, %v instead of 5, EOF", diskType, n, err)
+			}
+		}
+		check(img)
+
+		// reopen the image read-only
+		img2, err := vhd.Open(f, false)
+		if err != nil {
+			t.Fatalf("%d: unable to open image: %v", diskType, err)
+		}
+		if img2.Size() != size || img2.Type() != diskType {
+			t.Errorf("%d: reopened as size %d type %d", diskType, img2.Size(), img2.Type())
+		}
+		check(img2)
+		if _, err := img2.WriteAt([]byte{1}, 0); err == nil {
+			t.Errorf("%d: expected error writing to read-only image", diskType)
+		}
+
+		// reopen writable and write some more
+		img3, err := vhd.Open(f, true)
+		if err != nil {
+			t.Fatalf("%d: unable to open image writable: %v", diskType, err)
+		}
+		data := make([]byte, 20000)
+		_, _ = rand.Read(data)
+		writes[10*1024*1024] = data
+		if _, err := img3.WriteAt(data, 10*1024*1024); err != nil {
+			t.Fatalf("%d: unable to write to reopened image: %v", diskType, err)
+		}
+		check(img3)
+		img4, err := vhd.Open(f, false)
+		if err != nil {
+			t.Fatalf("%d: unable to open image: %v", diskType, err)
+		}
+		check(img4)
+	}
+}
+
+func TestOpenDamagedFooter(t *testing.T) {
+	f := testhelper.TempFile(t, "vhd_test")
+	img, err := vhd.Create(f, 10*1024*1024, vhd.Dynamic, 0)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	data := make([]byte, 1000)
+	_, _ = rand.Read(data)
+	if _, err := img.WriteAt(data, 3*1024*1024); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("unable to seek: %v", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 512), end-512); err != nil {
+		t.Fatalf("unable to overwrite footer: %v", err)
+	}
+	img2, err := vhd.Open(f, false)
+	if err != nil {
+		t.Fatalf("unable to open image with damaged footer: %v", err)
+	}
+	b := make([]byte, len(data))
+	if _, err := img2.ReadAt(b, 3*1024*1024); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("mismatched data")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	f := testhelper.TempFile(t, "vhd_test")
+	if _, err := f.WriteAt(make([]byte, 4096), 0); err != nil {
+		t.Fatalf("unable to write tempfile: %v", err)
+	}
+	if _, err := vhd.Open(f, false); err == nil {
+		t.Errorf("expected error opening non-VHD file")
+	}
+}
+
+func TestPartitionAndFilesystem(t *testing.T) {
+	size := int64(100 * 1024 * 1024)
+	f := testhelper.TempFile(t, "vhd_test")
+	img, err := vhd.Create(f, size, vhd.Dynamic, 0)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	table := &gpt.Table{
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 50*1024*2 - 1, Type: gpt.EFISystemPartition, Name: "EFI System"},
+		},
+	}
+	if err := table.Write(img, size); err != nil {
+		t.Fatalf("unable to write partition table: %v", err)
+	}
+	part := table.Partitions[0]
+	fs, err := fat32.Create(img, part.GetSize(), part.GetStart(), 512, "VHD")
+	if err != nil {
+		t.Fatalf("unable to create filesystem: %v", err)
+	}
+	content := make([]byte, 300000)
+	_, _ = rand.Read(content)
+	rw, err := fs.OpenFile("/BOOTX64.EFI", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("unable to create file: %v", err)
+	}
+	if _, err := rw.Write(content); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	img2, err := vhd.Open(f, false)
+	if err != nil {
+		t.Fatalf("unable to open image: %v", err)
+	}
+	fs2, err := fat32.Read(img2, part.GetSize(), part.GetStart(), 512)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	ro, err := fs2.OpenFile("/BOOTX64.EFI", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("unable to open file: %v", err)
+	}
+	b, err := io.ReadAll(ro)
+	if err != nil {
+		t.Fatalf("unable to read file: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("mismatched file contents")
+	}
+}
+
+// TestQemuImg checks images against qemu-img, which knows VHD as vpc: one written here must hold the same disk for
+// qemu-img, and one that qemu-img writes must hold the same disk here. qemu-img cannot check VHD images.
+func TestQemuImg(t *testing.T) {
+	testhelper.QemuImg(t, "--version")
+	// qemu-img sizes VHD images that it did not write by their geometry, which covers exactly this size
+	size := int64(16781312)
+	disk := make([]byte, size)
+	extents := [][2]int64{{0, 512}, {70000, 100000}, {2*1024*1024 - 7, 300}, {size - 1024*1024, 1024 * 1024}}
+	for _, e := range extents {
+		_, _ = rand.Read(disk[e[0] : e[0]+e[1]])
+	}
+
+	for subformat, diskType := range map[string]vhd.DiskType{"fixed": vhd.Fixed, "dynamic": vhd.Dynamic} {
+		subformat, diskType := subformat, diskType
+		t.Run("written "+subformat, func(t *testing.T) {
+			f := testhelper.TempFile(t, "vhd_test")
+			img, err := vhd.Create(f, size, diskType, 0)
+			if err != nil {
+				t.Fatalf("unable to create image: %v", err)
+			}
+			for _, e := range extents {
+				if _, err := img.WriteAt(disk[e[0]:e[0]+e[1]], e[0]); err != nil {
+					t.Fatalf("unable to write %d bytes at %d: %v", e[1], e[0], err)
+				}
+			}
+			if err := img.Close(); err != nil {
+				t.Fatalf("unable to close image: %v", err)
+			}
+			if !bytes.Equal(testhelper.QemuImgRead(t, "vpc", f.Name()), disk) {
+				t.Errorf("qemu-img read a different disk than was written")
+			}
+		})
+
+		t.Run("qemu-img "+subformat, func(t *testing.T) {
+			f, err := os.Open(testhelper.QemuImgWrite(t, disk, "vpc", "-o", "subformat="+subformat))
+			if err != nil {
+				t.Fatalf("unable to open image file: %v", err)
+			}
+			defer f.Close()
+			img, err := vhd.Open(f, false)
+			if err != nil {
+				t.Fatalf("unable to open image: %v", err)
+			}
+			if img.Size() != size {
+				t.Fatalf("size %d instead of %d", img.Size(), size)
+			}
+			b := make([]byte, size)
+			if _, err := img.ReadAt(b, 0); err != nil && err != io.EOF {
+				t.Fatalf("unable to read disk: %v", err)
+			}
+			if !bytes.Equal(b, disk) {
+				t.Errorf("read a different disk than qemu-img wrote")
+			}
+		})
+	}
+}
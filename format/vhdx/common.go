@@ -0,0 +1,59 @@
+package vhdx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/google/uuid"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum calculates the CRC-32C of b with the 4 checksum bytes at checksumOffset taken as zero
+func checksum(b []byte, checksumOffset int) uint32 {
+	crc := crc32.Update(0, crc32cTable, b[:checksumOffset])
+	crc = crc32.Update(crc, crc32cTable, make([]byte, 4))
+	return crc32.Update(crc, crc32cTable, b[checksumOffset+4:])
+}
+
+// setChecksum calculates the checksum of b and stores it at checksumOffset
+func setChecksum(b []byte, checksumOffset int) {
+	binary.LittleEndian.PutUint32(b[checksumOffset:checksumOffset+4], checksum(b, checksumOffset))
+}
+
+// validChecksum reports whether the checksum stored at checksumOffset matches the contents of b
+func validChecksum(b []byte, checksumOffset int) bool {
+	return binary.LittleEndian.Uint32(b[checksumOffset:checksumOffset+4]) == checksum(b, checksumOffset)
+}
+
+// bytesToUUIDBytes converts between the on-disk GUID layout, in which the first 3 sections
+// (4 bytes, 2 bytes, 2 bytes) are little-endian, and the big-endian layout of uuid.UUID.
+// The conversion is its own inverse.
+func bytesToUUIDBytes(in []byte) []byte {
+	b := make([]byte, 16)
+	copy(b, in[0:16])
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b
+}
+
+func readGUID(b []byte) uuid.UUID {
+	var u uuid.UUID
+	copy(u[:], bytesToUUIDBytes(b))
+	return u
+}
+
+func putGUID(b []byte, u uuid.UUID) {
+	copy(b[0:16], bytesToUUIDBytes(u[:]))
+}
+
+func roundUp(n, multiple int64) int64 {
+	return (n + multiple - 1) / multiple * multiple
+}
+
+// isZero reports whether b is all zeroes
+func isZero(b []byte) bool {
+	return len(bytes.Trim(b, "\x00")) == 0
+}
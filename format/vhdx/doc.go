@@ -0,0 +1,19 @@
+// Package vhdx provides support for reading and creating Microsoft VHDX disk images, both fixed and dynamic.
+//
+// A VHDX file starts with a file type identifier, two headers and two region tables, which locate the
+// metadata region describing the virtual disk and the Block Allocation Table (BAT) mapping each block
+// of the virtual disk to its place in the file. A fixed image has all blocks allocated when it is
+// created, while a dynamic image allocates them as they are written.
+//
+// An Image wraps a util.File holding the VHDX and itself implements util.File, presenting the virtual disk.
+//
+// Normally, the best way to work with a VHDX image is through github.com/diskfs/go-diskfs,
+// by passing diskfs.VHDXFixed or diskfs.VHDXDynamic to Create() or WithFormat() for Open().
+//
+// When an image with a pending log is opened, the log is replayed. Updates made by this package are
+// written directly rather than through the log. Differencing disks are not supported.
+//
+// references:
+//
+//	https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-vhdx/83e061f8-f6e2-4de1-91bd-5d518a43d477
+package vhdx
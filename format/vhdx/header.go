@@ -0,0 +1,174 @@
+package vhdx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// the format aligns nearly everything to these
+	kb = 1024
+	mb = 1024 * kb
+
+	fileIdentifierSignature = "vhdxfile"
+	fileIdentifierSize      = 64 * kb
+	creator                 = "go-diskfs"
+
+	headerSignature = "head"
+	headerSize      = 4 * kb
+	header1Offset   = 64 * kb
+	header2Offset   = 128 * kb
+	headerVersion   = 1
+	logVersion      = 0
+
+	regionTableSignature = "regi"
+	regionTableSize      = 64 * kb
+	regionTable1Offset   = 192 * kb
+	regionTable2Offset   = 256 * kb
+	regionEntrySize      = 32
+	maxRegionEntries     = 2047
+)
+
+var (
+	batRegionGUID      = uuid.MustParse("2DC27766-F623-4200-9D64-115E9BFD4A08")
+	metadataRegionGUID = uuid.MustParse("8B7CA206-4790-4B9A-B8FE-575F050F886E")
+)
+
+// header is one of the two headers of a VHDX file; the valid one with the highest sequence number is current
+type header struct {
+	sequenceNumber uint64
+	fileWriteGUID  uuid.UUID
+	dataWriteGUID  uuid.UUID
+	logGUID        uuid.UUID
+	logVersion     uint16
+	version        uint16
+	logLength      uint32
+	logOffset      uint64
+}
+
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) != headerSize {
+		return nil, fmt.Errorf("cannot read VHDX header from %d bytes, must be exactly %d", len(b), headerSize)
+	}
+	if string(b[0:4]) != headerSignature {
+		return nil, fmt.Errorf("invalid VHDX header signature %q", b[0:4])
+	}
+	if !validChecksum(b, 4) {
+		return nil, fmt.Errorf("invalid VHDX header checksum")
+	}
+	h := &header{
+		sequenceNumber: binary.LittleEndian.Uint64(b[8:16]),
+		fileWriteGUID:  readGUID(b[16:32]),
+		dataWriteGUID:  readGUID(b[32:48]),
+		logGUID:        readGUID(b[48:64]),
+		logVersion:     binary.LittleEndian.Uint16(b[64:66]),
+		version:        binary.LittleEndian.Uint16(b[66:68]),
+		logLength:      binary.LittleEndian.Uint32(b[68:72]),
+		logOffset:      binary.LittleEndian.Uint64(b[72:80]),
+	}
+	if h.version != headerVersion {
+		return nil, fmt.Errorf("unsupported VHDX version %d", h.version)
+	}
+	if h.logVersion != logVersion {
+		return nil, fmt.Errorf("unsupported VHDX log version %d", h.logVersion)
+	}
+	if h.logOffset%mb != 0 || h.logLength%mb != 0 || h.logLength == 0 {
+		return nil, fmt.Errorf("invalid VHDX log of %d bytes at offset %d, both must be multiples of 1MB", h.logLength, h.logOffset)
+	}
+	return h, nil
+}
+
+func (h *header) toBytes() []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:4], headerSignature)
+	binary.LittleEndian.PutUint64(b[8:16], h.sequenceNumber)
+	putGUID(b[16:32], h.fileWriteGUID)
+	putGUID(b[32:48], h.dataWriteGUID)
+	putGUID(b[48:64], h.logGUID)
+	binary.LittleEndian.PutUint16(b[64:66], h.logVersion)
+	binary.LittleEndian.PutUint16(b[66:68], h.version)
+	binary.LittleEndian.PutUint32(b[68:72], h.logLength)
+	binary.LittleEndian.PutUint64(b[72:80], h.logOffset)
+	setChecksum(b, 4)
+	return b
+}
+
+// fileIdentifierBytes returns the file type identifier found at the start of every VHDX file
+func fileIdentifierBytes() []byte {
+	b := make([]byte, fileIdentifierSize)
+	copy(b[0:8], fileIdentifierSignature)
+	for i, c := range utf16.Encode([]rune(creator)) {
+		binary.LittleEndian.PutUint16(b[8+i*2:], c)
+	}
+	return b
+}
+
+// regionEntry describes one region of the file, such as the BAT or the metadata
+type regionEntry struct {
+	guid     uuid.UUID
+	offset   uint64
+	length   uint32
+	required bool
+}
+
+// regionTable is the list of regions in the file, stored twice
+type regionTable struct {
+	entries []regionEntry
+}
+
+func regionTableFromBytes(b []byte) (*regionTable, error) {
+	if len(b) != regionTableSize {
+		return nil, fmt.Errorf("cannot read VHDX region table from %d bytes, must be exactly %d", len(b), regionTableSize)
+	}
+	if string(b[0:4]) != regionTableSignature {
+		return nil, fmt.Errorf("invalid VHDX region table signature %q", b[0:4])
+	}
+	if !validChecksum(b, 4) {
+		return nil, fmt.Errorf("invalid VHDX region table checksum")
+	}
+	count := binary.LittleEndian.Uint32(b[8:12])
+	if count > maxRegionEntries {
+		return nil, fmt.Errorf("invalid VHDX region table entry count %d, maximum is %d", count, maxRegionEntries)
+	}
+	r := &regionTable{}
+	for i := 0; i < int(count); i++ {
+		e := b[16+i*regionEntrySize : 16+(i+1)*regionEntrySize]
+		r.entries = append(r.entries, regionEntry{
+			guid:     readGUID(e[0:16]),
+			offset:   binary.LittleEndian.Uint64(e[16:24]),
+			length:   binary.LittleEndian.Uint32(e[24:28]),
+			required: binary.LittleEndian.Uint32(e[28:32])&1 == 1,
+		})
+	}
+	return r, nil
+}
+
+func (r *regionTable) toBytes() []byte {
+	b := make([]byte, regionTableSize)
+	copy(b[0:4], regionTableSignature)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(r.entries)))
+	for i, entry := range r.entries {
+		e := b[16+i*regionEntrySize : 16+(i+1)*regionEntrySize]
+		putGUID(e[0:16], entry.guid)
+		binary.LittleEndian.PutUint64(e[16:24], entry.offset)
+		binary.LittleEndian.PutUint32(e[24:28], entry.length)
+		if entry.required {
+			binary.LittleEndian.PutUint32(e[28:32], 1)
+		}
+	}
+	setChecksum(b, 4)
+	return b
+}
+
+// find returns the region with the given GUID, or nil if there is none
+func (r *regionTable) find(guid uuid.UUID) *regionEntry {
+	for i := range r.entries {
+		if r.entries[i].guid == guid {
+			return &r.entries[i]
+		}
+	}
+	return nil
+}
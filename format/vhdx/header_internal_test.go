@@ -0,0 +1,130 @@
+package vhdx
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGUIDBytes(t *testing.T) {
+	// the BAT region GUID as found on disk
+	onDisk := []byte{0x66, 0x77, 0xc2, 0x2d, 0x23, 0xf6, 0x00, 0x42, 0x9d, 0x64, 0x11, 0x5e, 0x9b, 0xfd, 0x4a, 0x08}
+	if g := readGUID(onDisk); g != batRegionGUID {
+		t.Errorf("read GUID %s instead of %s", g, batRegionGUID)
+	}
+	b := make([]byte, 16)
+	putGUID(b, batRegionGUID)
+	if !bytes.Equal(b, onDisk) {
+		t.Errorf("wrote GUID % x instead of % x", b, onDisk)
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := &header{
+		sequenceNumber: 7,
+		fileWriteGUID:  uuid.New(),
+		dataWriteGUID:  uuid.New(),
+		logGUID:        uuid.New(),
+		logVersion:     logVersion,
+		version:        headerVersion,
+		logLength:      logLength,
+		logOffset:      logOffset,
+	}
+	b := h.toBytes()
+	h2, err := headerFromBytes(b)
+	if err != nil {
+		t.Fatalf("unable to read header: %v", err)
+	}
+	if *h != *h2 {
+		t.Errorf("mismatched header, actual %#v expected %#v", h2, h)
+	}
+
+	badChecksum := h.toBytes()
+	badChecksum[2000]++
+	badVersion := *h
+	badVersion.version = 2
+	badLog := *h
+	badLog.logOffset = 12345
+	tests := []struct {
+		b   []byte
+		err string
+	}{
+		{b[:100], "cannot read VHDX header from 100 bytes"},
+		{make([]byte, headerSize), "invalid VHDX header signature"},
+		{badChecksum, "invalid VHDX header checksum"},
+		{badVersion.toBytes(), "unsupported VHDX version 2"},
+		{badLog.toBytes(), "invalid VHDX log"},
+	}
+	for i, tt := range tests {
+		_, err := headerFromBytes(tt.b)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%d: mismatched error, actual %v expected prefix %q", i, err, tt.err)
+		}
+	}
+}
+
+func TestRegionTableRoundTrip(t *testing.T) {
+	r := &regionTable{entries: []regionEntry{
+		{guid: batRegionGUID, offset: 3 * mb, length: 1 * mb, required: true},
+		{guid: metadataRegionGUID, offset: 2 * mb, length: 1 * mb, required: true},
+		{guid: uuid.New(), offset: 5 * mb, length: 1 * mb},
+	}}
+	r2, err := regionTableFromBytes(r.toBytes())
+	if err != nil {
+		t.Fatalf("unable to read region table: %v", err)
+	}
+	if len(r2.entries) != len(r.entries) {
+		t.Fatalf("%d entries instead of %d", len(r2.entries), len(r.entries))
+	}
+	for i := range r.entries {
+		if r.entries[i] != r2.entries[i] {
+			t.Errorf("%d: mismatched entry, actual %#v expected %#v", i, r2.entries[i], r.entries[i])
+		}
+	}
+	if e := r2.find(metadataRegionGUID); e == nil || e.offset != 2*mb {
+		t.Errorf("unable to find metadata region")
+	}
+	bad := r.toBytes()
+	bad[60000]++
+	if _, err := regionTableFromBytes(bad); err == nil {
+		t.Errorf("expected checksum error")
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	m := &metadata{
+		blockSize:          uint32(DefaultBlockSize),
+		virtualDiskSize:    10 * mb,
+		virtualDiskID:      uuid.New(),
+		logicalSectorSize:  512,
+		physicalSectorSize: 4096,
+	}
+	m2, err := metadataFromBytes(m.toBytes())
+	if err != nil {
+		t.Fatalf("unable to read metadata: %v", err)
+	}
+	if *m != *m2 {
+		t.Errorf("mismatched metadata, actual %#v expected %#v", m2, m)
+	}
+
+	badBlockSize := *m
+	badBlockSize.blockSize = 3 * mb
+	badSector := *m
+	badSector.logicalSectorSize = 1024
+	tests := []struct {
+		b   []byte
+		err string
+	}{
+		{make([]byte, metadataTableSize), "invalid VHDX metadata table signature"},
+		{badBlockSize.toBytes(), "invalid VHDX block size"},
+		{badSector.toBytes(), "invalid VHDX logical sector size"},
+	}
+	for i, tt := range tests {
+		_, err := metadataFromBytes(tt.b)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%d: mismatched error, actual %v expected prefix %q", i, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package vhdx
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+const (
+	logEntrySignature      = "loge"
+	logZeroSignature       = "zero"
+	logDescriptorSignature = "desc"
+	logDataSignature       = "data"
+	logSectorSize          = 4 * kb
+	logEntryHeaderSize     = 64
+	logDescriptorSize      = 32
+)
+
+// logDescriptor is a single update in a log entry, either writing a 4KB sector or zeroing a range
+type logDescriptor struct {
+	zero           bool
+	zeroLength     uint64
+	fileOffset     uint64
+	sequenceNumber uint64
+	// for data descriptors, the sector with the leading and trailing bytes restored
+	data []byte
+}
+
+// apply writes the update of the descriptor to f
+func (d *logDescriptor) apply(f util.File) error {
+	if !d.zero {
+		_, err := f.WriteAt(d.data, int64(d.fileOffset))
+		return err
+	}
+	zeroes := make([]byte, mb)
+	for written := uint64(0); written < d.zeroLength; written += mb {
+		n := d.zeroLength - written
+		if n > mb {
+			n = mb
+		}
+		if _, err := f.WriteAt(zeroes[:n], int64(d.fileOffset+written)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logEntry is one entry in the circular log
+type logEntry struct {
+	offset            uint32
+	entryLength       uint32
+	tail              uint32
+	sequenceNumber    uint64
+	flushedFileOffset uint64
+	lastFileOffset    uint64
+	descriptors       []logDescriptor
+}
+
+// readLogEntry reads and validates the log entry at offset within the log. It returns nil if there is no
+// valid entry belonging to logGUID there.
+func readLogEntry(log []byte, offset uint32, logGUID uuid.UUID) *logEntry {
+	// an entry may wrap around the end of the log
+	read := func(off, length uint32) []byte {
+		b := make([]byte, length)
+		for i := uint32(0); i < length; i++ {
+			b[i] = log[(off+i)%uint32(len(log))]
+		}
+		return b
+	}
+	h := read(offset, logEntryHeaderSize)
+	if string(h[0:4]) != logEntrySignature {
+		return nil
+	}
+	length := binary.LittleEndian.Uint32(h[8:12])
+	if length == 0 || length%logSectorSize != 0 || length > uint32(len(log)) {
+		return nil
+	}
+	b := read(offset, length)
+	if !validChecksum(b, 4) || readGUID(b[32:48]) != logGUID {
+		return nil
+	}
+	e := &logEntry{
+		offset:            offset,
+		entryLength:       length,
+		tail:              binary.LittleEndian.Uint32(b[12:16]),
+		sequenceNumber:    binary.LittleEndian.Uint64(b[16:24]),
+		flushedFileOffset: binary.LittleEndian.Uint64(b[48:56]),
+		lastFileOffset:    binary.LittleEndian.Uint64(b[56:64]),
+	}
+	count := binary.LittleEndian.Uint32(b[24:28])
+	descriptorArea := roundUp(int64(logEntryHeaderSize)+int64(count)*logDescriptorSize, logSectorSize)
+	if descriptorArea > int64(length) {
+		return nil
+	}
+	dataSector := uint32(descriptorArea)
+	for i := uint32(0); i < count; i++ {
+		d := b[logEntryHeaderSize+i*logDescriptorSize : logEntryHeaderSize+(i+1)*logDescriptorSize]
+		desc := logDescriptor{
+			fileOffset:     binary.LittleEndian.Uint64(d[16:24]),
+			sequenceNumber: binary.LittleEndian.Uint64(d[24:32]),
+		}
+		if desc.sequenceNumber != e.sequenceNumber {
+			return nil
+		}
+		switch string(d[0:4]) {
+		case logZeroSignature:
+			desc.zero = true
+			desc.zeroLength = binary.LittleEndian.Uint64(d[8:16])
+		case logDescriptorSignature:
+			if dataSector+logSectorSize > length {
+				return nil
+			}
+			s := b[dataSector : dataSector+logSectorSize]
+			dataSector += logSectorSize
+			seq := uint64(binary.LittleEndian.Uint32(s[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(s[4092:4096]))
+			if string(s[0:4]) != logDataSignature || seq != e.sequenceNumber {
+				return nil
+			}
+			desc.data = make([]byte, logSectorSize)
+			copy(desc.data[0:8], d[8:16])
+			copy(desc.data[8:4092], s[8:4092])
+			copy(desc.data[4092:4096], d[4:8])
+		default:
+			return nil
+		}
+		e.descriptors = append(e.descriptors, desc)
+	}
+	return e
+}
+
+// findLogSequence finds the active sequence of log entries, the valid sequence with the highest sequence
+// number, returning its entries in order from tail to head. It returns nil if the log has no valid sequence.
+func findLogSequence(log []byte, logGUID uuid.UUID) []*logEntry {
+	var best []*logEntry
+	for offset := uint32(0); offset < uint32(len(log)); offset += logSectorSize {
+		head := readLogEntry(log, offset, logGUID)
+		if head == nil || (best != nil && head.sequenceNumber <= best[len(best)-1].sequenceNumber) {
+			continue
+		}
+		// walk from the tail the head refers to, which must lead back to the head
+		var sequence []*logEntry
+		pos := head.tail
+		for {
+			e := readLogEntry(log, pos, logGUID)
+			if e == nil || (len(sequence) > 0 && e.sequenceNumber != sequence[len(sequence)-1].sequenceNumber+1) {
+				sequence = nil
+				break
+			}
+			sequence = append(sequence, e)
+			if e.offset == head.offset {
+				break
+			}
+			if len(sequence) > len(log)/logSectorSize {
+				sequence = nil
+				break
+			}
+			pos = (pos + e.entryLength) % uint32(len(log))
+		}
+		if sequence != nil && sequence[len(sequence)-1].sequenceNumber == head.sequenceNumber {
+			best = sequence
+		}
+	}
+	return best
+}
+
+// replayLog applies the active sequence of the log at offset logOffset in f
+func replayLog(f util.File, logOffset uint64, logLength uint32, logGUID uuid.UUID) error {
+	log := make([]byte, logLength)
+	if _, err := f.ReadAt(log, int64(logOffset)); err != nil {
+		return fmt.Errorf("unable to read VHDX log: %v", err)
+	}
+	sequence := findLogSequence(log, logGUID)
+	if sequence == nil {
+		return fmt.Errorf("no valid sequence in VHDX log")
+	}
+	for _, e := range sequence {
+		for _, d := range e.descriptors {
+			if err := d.apply(f); err != nil {
+				return fmt.Errorf("unable to replay VHDX log entry %d: %v", e.sequenceNumber, err)
+			}
+		}
+	}
+	// the file must be at least as large as it was when the last entry was written
+	head := sequence[len(sequence)-1]
+	b := make([]byte, 1)
+	if head.lastFileOffset > 0 {
+		if _, err := f.ReadAt(b, int64(head.lastFileOffset)-1); err != nil {
+			if _, err := f.WriteAt(b, int64(head.lastFileOffset)-1); err != nil {
+				return fmt.Errorf("unable to extend VHDX file after log replay: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package vhdx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testLogEntry builds a log entry with the given data sectors and zero ranges
+func testLogEntry(logGUID uuid.UUID, seq uint64, tail uint32, data map[uint64][]byte, zeroes map[uint64]uint64) []byte {
+	count := len(data) + len(zeroes)
+	descriptorArea := roundUp(int64(logEntryHeaderSize+count*logDescriptorSize), logSectorSize)
+	b := make([]byte, descriptorArea+int64(len(data))*logSectorSize)
+	copy(b[0:4], logEntrySignature)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[12:16], tail)
+	binary.LittleEndian.PutUint64(b[16:24], seq)
+	binary.LittleEndian.PutUint32(b[24:28], uint32(count))
+	putGUID(b[32:48], logGUID)
+	d := b[logEntryHeaderSize:]
+	for offset, length := range zeroes {
+		copy(d[0:4], logZeroSignature)
+		binary.LittleEndian.PutUint64(d[8:16], length)
+		binary.LittleEndian.PutUint64(d[16:24], offset)
+		binary.LittleEndian.PutUint64(d[24:32], seq)
+		d = d[logDescriptorSize:]
+	}
+	s := b[descriptorArea:]
+	for offset, sector := range data {
+		copy(d[0:4], logDescriptorSignature)
+		copy(d[4:8], sector[4092:4096])
+		copy(d[8:16], sector[0:8])
+		binary.LittleEndian.PutUint64(d[16:24], offset)
+		binary.LittleEndian.PutUint64(d[24:32], seq)
+		d = d[logDescriptorSize:]
+		copy(s[0:4], logDataSignature)
+		binary.LittleEndian.PutUint32(s[4:8], uint32(seq>>32))
+		copy(s[8:4092], sector[8:4092])
+		binary.LittleEndian.PutUint32(s[4092:4096], uint32(seq))
+		s = s[logSectorSize:]
+	}
+	setChecksum(b, 4)
+	return b
+}
+
+func TestReplayLog(t *testing.T) {
+	f, err := os.CreateTemp("", "vhdx_test")
+	if err != nil {
+		t.Fatalf("unable to create tempfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	logGUID := uuid.New()
+	sector1 := bytes.Repeat([]byte{0x11}, logSectorSize)
+	sector1[0], sector1[4095] = 0xaa, 0xbb
+	sector2 := bytes.Repeat([]byte{0x22}, logSectorSize)
+	stale := bytes.Repeat([]byte{0x33}, logSectorSize)
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, 16*kb), 4*mb); err != nil {
+		t.Fatalf("unable to write tempfile: %v", err)
+	}
+
+	// an old entry from a previous sequence, then a sequence of two entries where the head points back to the first
+	old := testLogEntry(logGUID, 3, 0, map[uint64][]byte{4 * mb: stale}, nil)
+	first := testLogEntry(logGUID, 10, uint32(len(old)), map[uint64][]byte{4 * mb: sector1}, nil)
+	second := testLogEntry(logGUID, 11, uint32(len(old)), map[uint64][]byte{4*mb + 8*kb: sector2}, map[uint64]uint64{4*mb + 4*kb: 4 * kb})
+	// an entry from another log is ignored
+	other := testLogEntry(uuid.New(), 50, 0, map[uint64][]byte{4 * mb: stale}, nil)
+	log := make([]byte, logLength)
+	copy(log, old)
+	copy(log[len(old):], first)
+	copy(log[len(old)+len(first):], second)
+	copy(log[len(old)+len(first)+len(second):], other)
+	if _, err := f.WriteAt(log, logOffset); err != nil {
+		t.Fatalf("unable to write log: %v", err)
+	}
+
+	if err := replayLog(f, logOffset, logLength, logGUID); err != nil {
+		t.Fatalf("unable to replay log: %v", err)
+	}
+	b := make([]byte, 16*kb)
+	if _, err := f.ReadAt(b, 4*mb); err != nil {
+		t.Fatalf("unable to read tempfile: %v", err)
+	}
+	expected := append(append(append(append([]byte{}, sector1...), make([]byte, 4*kb)...), sector2...), bytes.Repeat([]byte{0xff}, 4*kb)...)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("log replay resulted in unexpected contents")
+	}
+
+	if err := replayLog(f, logOffset, logLength, uuid.New()); err == nil {
+		t.Errorf("expected error replaying log without entries for the GUID")
+	}
+}
@@ -0,0 +1,152 @@
+package vhdx
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	metadataSignature       = "metadata"
+	metadataTableSize       = 64 * kb
+	metadataEntrySize       = 32
+	maxMetadataEntries      = 2047
+	metadataRegionSize      = 1 * mb
+	metadataFlagUser        = 1 << 0
+	metadataFlagVirtual     = 1 << 1
+	metadataFlagRequired    = 1 << 2
+	fileParametersHasParent = 1 << 1
+)
+
+var (
+	fileParametersGUID     = uuid.MustParse("CAA16737-FA36-4D43-B3B6-33F0AA44E76B")
+	virtualDiskSizeGUID    = uuid.MustParse("2FA54224-CD1B-4876-B211-5DBED83BF4B8")
+	virtualDiskIDGUID      = uuid.MustParse("BECA12AB-B2E6-4523-93EF-C309E000C746")
+	logicalSectorSizeGUID  = uuid.MustParse("8141BF1D-A96F-4709-BA47-F233A8FAAB5F")
+	physicalSectorSizeGUID = uuid.MustParse("CDA348C7-445D-4471-9CC9-E9885251C556")
+)
+
+// metadata holds the values from the metadata region that describe the virtual disk
+type metadata struct {
+	blockSize          uint32
+	leaveAllocated     bool
+	hasParent          bool
+	virtualDiskSize    uint64
+	virtualDiskID      uuid.UUID
+	logicalSectorSize  uint32
+	physicalSectorSize uint32
+}
+
+// metadataFromBytes parses the metadata region b, which starts with the metadata table
+func metadataFromBytes(b []byte) (*metadata, error) {
+	if len(b) < metadataTableSize {
+		return nil, fmt.Errorf("cannot read VHDX metadata from %d bytes, must be at least %d", len(b), metadataTableSize)
+	}
+	if string(b[0:8]) != metadataSignature {
+		return nil, fmt.Errorf("invalid VHDX metadata table signature %q", b[0:8])
+	}
+	count := binary.LittleEndian.Uint16(b[10:12])
+	if count > maxMetadataEntries {
+		return nil, fmt.Errorf("invalid VHDX metadata entry count %d, maximum is %d", count, maxMetadataEntries)
+	}
+	m := &metadata{}
+	found := map[uuid.UUID]bool{}
+	for i := 0; i < int(count); i++ {
+		e := b[32+i*metadataEntrySize : 32+(i+1)*metadataEntrySize]
+		id := readGUID(e[0:16])
+		offset := binary.LittleEndian.Uint32(e[16:20])
+		length := binary.LittleEndian.Uint32(e[20:24])
+		flags := binary.LittleEndian.Uint32(e[24:28])
+		if uint64(offset)+uint64(length) > uint64(len(b)) {
+			return nil, fmt.Errorf("VHDX metadata item %s of %d bytes at %d is outside the metadata region", id, length, offset)
+		}
+		item := b[offset : offset+length]
+		switch {
+		case id == fileParametersGUID && length >= 8:
+			m.blockSize = binary.LittleEndian.Uint32(item[0:4])
+			params := binary.LittleEndian.Uint32(item[4:8])
+			m.leaveAllocated = params&1 == 1
+			m.hasParent = params&fileParametersHasParent != 0
+		case id == virtualDiskSizeGUID && length >= 8:
+			m.virtualDiskSize = binary.LittleEndian.Uint64(item[0:8])
+		case id == virtualDiskIDGUID && length >= 16:
+			m.virtualDiskID = readGUID(item[0:16])
+		case id == logicalSectorSizeGUID && length >= 4:
+			m.logicalSectorSize = binary.LittleEndian.Uint32(item[0:4])
+		case id == physicalSectorSizeGUID && length >= 4:
+			m.physicalSectorSize = binary.LittleEndian.Uint32(item[0:4])
+		case flags&metadataFlagRequired != 0:
+			return nil, fmt.Errorf("unsupported required VHDX metadata item %s", id)
+		default:
+			continue
+		}
+		found[id] = true
+	}
+	for _, id := range []uuid.UUID{fileParametersGUID, virtualDiskSizeGUID, logicalSectorSizeGUID, physicalSectorSizeGUID} {
+		if !found[id] {
+			return nil, fmt.Errorf("missing required VHDX metadata item %s", id)
+		}
+	}
+	if m.blockSize < 1*mb || m.blockSize > 256*mb || m.blockSize&(m.blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid VHDX block size %d", m.blockSize)
+	}
+	if m.logicalSectorSize != 512 && m.logicalSectorSize != 4096 {
+		return nil, fmt.Errorf("invalid VHDX logical sector size %d", m.logicalSectorSize)
+	}
+	if m.physicalSectorSize != 512 && m.physicalSectorSize != 4096 {
+		return nil, fmt.Errorf("invalid VHDX physical sector size %d", m.physicalSectorSize)
+	}
+	if m.virtualDiskSize%uint64(m.logicalSectorSize) != 0 {
+		return nil, fmt.Errorf("invalid VHDX size %d, not a multiple of the sector size %d", m.virtualDiskSize, m.logicalSectorSize)
+	}
+	return m, nil
+}
+
+// toBytes returns the full metadata region, with the table followed by the items
+func (m *metadata) toBytes() []byte {
+	b := make([]byte, metadataRegionSize)
+	copy(b[0:8], metadataSignature)
+	type item struct {
+		id    uuid.UUID
+		flags uint32
+		data  []byte
+	}
+	params := make([]byte, 8)
+	binary.LittleEndian.PutUint32(params[0:4], m.blockSize)
+	var paramFlags uint32
+	if m.leaveAllocated {
+		paramFlags |= 1
+	}
+	if m.hasParent {
+		paramFlags |= fileParametersHasParent
+	}
+	binary.LittleEndian.PutUint32(params[4:8], paramFlags)
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, m.virtualDiskSize)
+	id := make([]byte, 16)
+	putGUID(id, m.virtualDiskID)
+	logical := make([]byte, 4)
+	binary.LittleEndian.PutUint32(logical, m.logicalSectorSize)
+	physical := make([]byte, 4)
+	binary.LittleEndian.PutUint32(physical, m.physicalSectorSize)
+	items := []item{
+		{fileParametersGUID, metadataFlagRequired, params},
+		{virtualDiskSizeGUID, metadataFlagVirtual | metadataFlagRequired, size},
+		{virtualDiskIDGUID, metadataFlagVirtual | metadataFlagRequired, id},
+		{logicalSectorSizeGUID, metadataFlagVirtual | metadataFlagRequired, logical},
+		{physicalSectorSizeGUID, metadataFlagVirtual | metadataFlagRequired, physical},
+	}
+	binary.LittleEndian.PutUint16(b[10:12], uint16(len(items)))
+	offset := metadataTableSize
+	for i, it := range items {
+		e := b[32+i*metadataEntrySize : 32+(i+1)*metadataEntrySize]
+		putGUID(e[0:16], it.id)
+		binary.LittleEndian.PutUint32(e[16:20], uint32(offset))
+		binary.LittleEndian.PutUint32(e[20:24], uint32(len(it.data)))
+		binary.LittleEndian.PutUint32(e[24:28], it.flags)
+		copy(b[offset:], it.data)
+		offset += len(it.data)
+	}
+	return b
+}
@@ -0,0 +1,483 @@
+package vhdx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+// DiskType is the type of a VHDX disk
+type DiskType int
+
+const (
+	// Fixed is a disk whose full size is allocated in the file up front
+	Fixed DiskType = iota
+	// Dynamic is a disk that grows as it is written
+	Dynamic
+)
+
+const (
+	// DefaultBlockSize is the block size used for new disks when none is given, matching Hyper-V
+	DefaultBlockSize int64 = 32 * mb
+	// MaxSize is the largest virtual disk supported by the format
+	MaxSize int64 = 64 * 1024 * 1024 * mb
+
+	logOffset      = 1 * mb
+	logLength      = 1 * mb
+	metadataOffset = logOffset + logLength
+	batOffset      = metadataOffset + metadataRegionSize
+
+	// BAT entry states; the remaining ones only occur in differencing disks
+	payloadBlockNotPresent   = 0
+	payloadBlockUndefined    = 1
+	payloadBlockZero         = 2
+	payloadBlockUnmapped     = 3
+	payloadBlockFullyPresent = 6
+	batStateMask             = 0x7
+	batOffsetShift           = 20
+)
+
+// Image is a VHDX disk image. It implements util.File for the virtual disk it contains.
+type Image struct {
+	file          util.File
+	header        *header
+	headerOffset  int64
+	headerUpdated bool
+	meta          *metadata
+	bat           []uint64
+	batOffset     int64
+	chunkRatio    int64
+	fileEnd       int64
+	writable      bool
+	offset        int64
+}
+
+// Create creates a new VHDX image of the given type with a virtual disk of size bytes, writing it to f
+// starting at offset 0. f is expected to be empty.
+//
+// blockSize must be a power of 2 between 1MB and 256MB; if it is 0, DefaultBlockSize is used.
+// logicalSectorSize must be 512 or 4096; if it is 0, 512 is used. size must be a multiple of it.
+//
+// A Fixed image has every block allocated in the file, which is created sparse where the file
+// supports it. The returned Image is writable.
+func Create(f util.File, size int64, diskType DiskType, blockSize, logicalSectorSize int64) (*Image, error) {
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if logicalSectorSize == 0 {
+		logicalSectorSize = 512
+	}
+	switch {
+	case blockSize < 1*mb || blockSize > 256*mb || blockSize&(blockSize-1) != 0:
+		return nil, fmt.Errorf("invalid block size %d, must be a power of 2 between 1MB and 256MB", blockSize)
+	case logicalSectorSize != 512 && logicalSectorSize != 4096:
+		return nil, fmt.Errorf("invalid logical sector size %d, must be 512 or 4096", logicalSectorSize)
+	case size <= 0 || size%logicalSectorSize != 0:
+		return nil, fmt.Errorf("invalid VHDX size %d, must be a positive multiple of %d", size, logicalSectorSize)
+	case size > MaxSize:
+		return nil, fmt.Errorf("requested size %d is larger than maximum VHDX size %d", size, MaxSize)
+	case diskType != Fixed && diskType != Dynamic:
+		return nil, fmt.Errorf("unsupported VHDX disk type %d", diskType)
+	}
+
+	img := &Image{
+		file: f,
+		header: &header{
+			fileWriteGUID: uuid.New(),
+			dataWriteGUID: uuid.New(),
+			logVersion:    logVersion,
+			version:       headerVersion,
+			logLength:     logLength,
+			logOffset:     logOffset,
+		},
+		headerUpdated: true,
+		meta: &metadata{
+			blockSize:          uint32(blockSize),
+			leaveAllocated:     diskType == Fixed,
+			virtualDiskSize:    uint64(size),
+			virtualDiskID:      uuid.New(),
+			logicalSectorSize:  uint32(logicalSectorSize),
+			physicalSectorSize: 4096,
+		},
+		batOffset: batOffset,
+		writable:  true,
+	}
+	img.setChunkRatio()
+	img.bat = make([]uint64, img.batEntries())
+	batLength := roundUp(int64(len(img.bat))*8, mb)
+	img.fileEnd = batOffset + batLength
+	if diskType == Fixed {
+		for block := int64(0); block < img.payloadBlocks(); block++ {
+			img.bat[img.batIndex(block)] = uint64(img.fileEnd) | payloadBlockFullyPresent
+			img.fileEnd += blockSize
+		}
+	}
+
+	regions := &regionTable{entries: []regionEntry{
+		{guid: batRegionGUID, offset: batOffset, length: uint32(batLength), required: true},
+		{guid: metadataRegionGUID, offset: metadataOffset, length: metadataRegionSize, required: true},
+	}}
+	writes := []struct {
+		name   string
+		b      []byte
+		offset int64
+	}{
+		{"file identifier", fileIdentifierBytes(), 0},
+		{"region table", regions.toBytes(), regionTable1Offset},
+		{"backup region table", regions.toBytes(), regionTable2Offset},
+		{"metadata", img.meta.toBytes(), metadataOffset},
+		{"block allocation table", append(img.batBytes(), make([]byte, batLength-int64(len(img.bat))*8)...), batOffset},
+	}
+	for _, w := range writes {
+		if _, err := f.WriteAt(w.b, w.offset); err != nil {
+			return nil, fmt.Errorf("unable to write VHDX %s: %v", w.name, err)
+		}
+	}
+	// both headers are written, the second with the next sequence number, so it is the current one
+	img.headerOffset = header1Offset
+	if err := img.writeHeader(); err != nil {
+		return nil, err
+	}
+	img.header.sequenceNumber++
+	img.headerOffset = header2Offset
+	if err := img.writeHeader(); err != nil {
+		return nil, err
+	}
+	if diskType == Fixed {
+		if _, err := f.WriteAt([]byte{0}, img.fileEnd-1); err != nil {
+			return nil, fmt.Errorf("unable to allocate VHDX blocks: %v", err)
+		}
+	}
+	return img, nil
+}
+
+// Open opens an existing VHDX image in f. If writable is false, any attempt to write to the
+// returned Image will return an error.
+//
+// If the image has a log that was not yet applied, it is replayed, which requires writable to be true.
+func Open(f util.File, writable bool) (*Image, error) {
+	b := make([]byte, 8)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("unable to read VHDX file identifier: %v", err)
+	}
+	if string(b) != fileIdentifierSignature {
+		return nil, fmt.Errorf("invalid VHDX file identifier %q", b)
+	}
+
+	img := &Image{
+		file:     f,
+		writable: writable,
+	}
+	var headerErr error
+	for _, offset := range []int64{header1Offset, header2Offset} {
+		b := make([]byte, headerSize)
+		if _, err := f.ReadAt(b, offset); err != nil {
+			return nil, fmt.Errorf("unable to read VHDX header: %v", err)
+		}
+		h, err := headerFromBytes(b)
+		if err != nil {
+			headerErr = err
+			continue
+		}
+		if img.header == nil || h.sequenceNumber > img.header.sequenceNumber {
+			img.header = h
+			img.headerOffset = offset
+		}
+	}
+	if img.header == nil {
+		return nil, fmt.Errorf("no valid VHDX header: %v", headerErr)
+	}
+
+	if img.header.logGUID != uuid.Nil {
+		if !writable {
+			return nil, errors.New("VHDX log must be replayed, which requires opening the image writable")
+		}
+		if err := replayLog(f, img.header.logOffset, img.header.logLength, img.header.logGUID); err != nil {
+			return nil, err
+		}
+		if err := img.updateHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	var regions *regionTable
+	var regionErr error
+	for _, offset := range []int64{regionTable1Offset, regionTable2Offset} {
+		b := make([]byte, regionTableSize)
+		if _, err := f.ReadAt(b, offset); err != nil {
+			return nil, fmt.Errorf("unable to read VHDX region table: %v", err)
+		}
+		if regions, regionErr = regionTableFromBytes(b); regionErr == nil {
+			break
+		}
+	}
+	if regionErr != nil {
+		return nil, fmt.Errorf("no valid VHDX region table: %v", regionErr)
+	}
+	for _, r := range regions.entries {
+		if r.required && r.guid != batRegionGUID && r.guid != metadataRegionGUID {
+			return nil, fmt.Errorf("unsupported required VHDX region %s", r.guid)
+		}
+	}
+	batRegion, metadataRegion := regions.find(batRegionGUID), regions.find(metadataRegionGUID)
+	if batRegion == nil || metadataRegion == nil {
+		return nil, errors.New("VHDX region table is missing the block allocation table or metadata region")
+	}
+
+	metaBytes := make([]byte, metadataRegion.length)
+	if _, err := f.ReadAt(metaBytes, int64(metadataRegion.offset)); err != nil {
+		return nil, fmt.Errorf("unable to read VHDX metadata: %v", err)
+	}
+	meta, err := metadataFromBytes(metaBytes)
+	if err != nil {
+		return nil, err
+	}
+	if meta.hasParent {
+		return nil, errors.New("differencing VHDX disks are not supported")
+	}
+	img.meta = meta
+	img.setChunkRatio()
+
+	entries := img.batEntries()
+	if entries*8 > int64(batRegion.length) {
+		return nil, fmt.Errorf("VHDX block allocation table of %d bytes too small for %d entries", batRegion.length, entries)
+	}
+	bat := make([]byte, entries*8)
+	img.batOffset = int64(batRegion.offset)
+	if _, err := f.ReadAt(bat, img.batOffset); err != nil {
+		return nil, fmt.Errorf("unable to read VHDX block allocation table: %v", err)
+	}
+	img.bat = make([]uint64, entries)
+	for i := range img.bat {
+		img.bat[i] = binary.LittleEndian.Uint64(bat[i*8:])
+	}
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find end of VHDX file: %v", err)
+	}
+	img.fileEnd = roundUp(end, mb)
+	return img, nil
+}
+
+// Size returns the size in bytes of the virtual disk
+func (i *Image) Size() int64 {
+	return int64(i.meta.virtualDiskSize)
+}
+
+// Type returns the type of the disk, Fixed or Dynamic
+func (i *Image) Type() DiskType {
+	if i.meta.leaveAllocated {
+		return Fixed
+	}
+	return Dynamic
+}
+
+// LogicalSectorSize returns the sector size of the virtual disk
+func (i *Image) LogicalSectorSize() int64 {
+	return int64(i.meta.logicalSectorSize)
+}
+
+// ReadAt reads len(b) bytes from the virtual disk starting at byte offset off.
+// Blocks that are not present in the file read as zeros.
+func (i *Image) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	size := i.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var retErr error
+	if int64(len(b)) > size-off {
+		b = b[:size-off]
+		retErr = io.EOF
+	}
+	blockSize := int64(i.meta.blockSize)
+	read := 0
+	for read < len(b) {
+		pos := off + int64(read)
+		block := pos / blockSize
+		inBlock := pos % blockSize
+		n := int(blockSize - inBlock)
+		if n > len(b)-read {
+			n = len(b) - read
+		}
+		chunk := b[read : read+n]
+		entry := i.bat[i.batIndex(block)]
+		switch entry & batStateMask {
+		case payloadBlockFullyPresent:
+			if _, err := i.file.ReadAt(chunk, blockFileOffset(entry)+inBlock); err != nil {
+				return read, fmt.Errorf("unable to read block %d: %v", block, err)
+			}
+		case payloadBlockNotPresent, payloadBlockUndefined, payloadBlockZero, payloadBlockUnmapped:
+			for j := range chunk {
+				chunk[j] = 0
+			}
+		default:
+			return read, fmt.Errorf("unsupported state %d for block %d", entry&batStateMask, block)
+		}
+		read += n
+	}
+	return read, retErr
+}
+
+// WriteAt writes len(b) bytes to the virtual disk starting at byte offset off, allocating blocks as required.
+// Writing zeros to a block that is not present does not allocate it.
+func (i *Image) WriteAt(b []byte, off int64) (int, error) {
+	if !i.writable {
+		return 0, errors.New("VHDX image not open for writing")
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	if off+int64(len(b)) > i.Size() {
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d beyond end of disk of size %d", len(b), off, i.Size())
+	}
+	if !i.headerUpdated {
+		if err := i.updateHeader(); err != nil {
+			return 0, err
+		}
+	}
+	blockSize := int64(i.meta.blockSize)
+	written := 0
+	for written < len(b) {
+		pos := off + int64(written)
+		block := pos / blockSize
+		inBlock := pos % blockSize
+		n := int(blockSize - inBlock)
+		if n > len(b)-written {
+			n = len(b) - written
+		}
+		chunk := b[written : written+n]
+		index := i.batIndex(block)
+		if i.bat[index]&batStateMask != payloadBlockFullyPresent {
+			if isZero(chunk) {
+				written += n
+				continue
+			}
+			if err := i.allocateBlock(index); err != nil {
+				return written, err
+			}
+		}
+		if _, err := i.file.WriteAt(chunk, blockFileOffset(i.bat[index])+inBlock); err != nil {
+			return written, fmt.Errorf("unable to write block %d: %v", block, err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Seek sets the offset for the next Read or Write. Only provided to satisfy util.File;
+// all access to the image is through ReadAt and WriteAt.
+func (i *Image) Seek(offset int64, whence int) (int64, error) {
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset += i.offset
+	case io.SeekEnd:
+		newOffset += i.Size()
+	default:
+		return i.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if newOffset < 0 {
+		return i.offset, fmt.Errorf("cannot set offset %d before start of disk", newOffset)
+	}
+	i.offset = newOffset
+	return i.offset, nil
+}
+
+// Close closes the underlying file, if it implements io.Closer. All writes go straight to the file,
+// so there is nothing else to complete.
+func (i *Image) Close() error {
+	if c, ok := i.file.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// allocateBlock places a new, zeroed payload block at the end of the file and records it in the BAT
+func (i *Image) allocateBlock(index int64) error {
+	blockOffset := i.fileEnd
+	blockSize := int64(i.meta.blockSize)
+	// extending the file leaves the block reading as zeroes
+	if _, err := i.file.WriteAt([]byte{0}, blockOffset+blockSize-1); err != nil {
+		return fmt.Errorf("unable to allocate block: %v", err)
+	}
+	i.fileEnd += blockSize
+	i.bat[index] = uint64(blockOffset) | payloadBlockFullyPresent
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, i.bat[index])
+	if _, err := i.file.WriteAt(b, i.batOffset+index*8); err != nil {
+		return fmt.Errorf("unable to write VHDX block allocation table entry: %v", err)
+	}
+	return nil
+}
+
+// updateHeader marks the file as modified by writing a new current header, with fresh write GUIDs and
+// no log, in place of the older of the two headers
+func (i *Image) updateHeader() error {
+	h := *i.header
+	h.sequenceNumber++
+	h.fileWriteGUID = uuid.New()
+	h.dataWriteGUID = uuid.New()
+	h.logGUID = uuid.Nil
+	i.header = &h
+	if i.headerOffset == header1Offset {
+		i.headerOffset = header2Offset
+	} else {
+		i.headerOffset = header1Offset
+	}
+	if err := i.writeHeader(); err != nil {
+		return err
+	}
+	i.headerUpdated = true
+	return nil
+}
+
+func (i *Image) writeHeader() error {
+	if _, err := i.file.WriteAt(i.header.toBytes(), i.headerOffset); err != nil {
+		return fmt.Errorf("unable to write VHDX header: %v", err)
+	}
+	return nil
+}
+
+// setChunkRatio calculates the number of payload blocks for each sector bitmap block
+func (i *Image) setChunkRatio() {
+	i.chunkRatio = (1 << 23) * int64(i.meta.logicalSectorSize) / int64(i.meta.blockSize)
+}
+
+// payloadBlocks is the number of blocks needed for the virtual disk
+func (i *Image) payloadBlocks() int64 {
+	return (i.Size() + int64(i.meta.blockSize) - 1) / int64(i.meta.blockSize)
+}
+
+// batEntries is the number of entries in the BAT, in which the payload block entries are interleaved
+// with an entry for a sector bitmap block after every chunkRatio of them
+func (i *Image) batEntries() int64 {
+	blocks := i.payloadBlocks()
+	return blocks + (blocks-1)/i.chunkRatio
+}
+
+// batIndex returns the index in the BAT of the entry for a payload block
+func (i *Image) batIndex(block int64) int64 {
+	return block + block/i.chunkRatio
+}
+
+func (i *Image) batBytes() []byte {
+	b := make([]byte, len(i.bat)*8)
+	for j, e := range i.bat {
+		binary.LittleEndian.PutUint64(b[j*8:], e)
+	}
+	return b
+}
+
+// blockFileOffset returns the offset in the file of the block in a BAT entry
+func blockFileOffset(entry uint64) int64 {
+	return int64(entry >> batOffsetShift << batOffsetShift)
+}
@@ -0,0 +1,127 @@
+package vmdk
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	createTypeMonolithicSparse = "monolithicSparse"
+	createTypeStreamOptimized  = "streamOptimized"
+	noParentCID                = "ffffffff"
+	descriptorSectors          = 20
+)
+
+// descriptor holds what is needed from the text descriptor embedded in a sparse extent
+type descriptor struct {
+	cid        uint32
+	parentCID  string
+	createType string
+	extents    []extent
+}
+
+// extent is a single line of the extent description
+type extent struct {
+	access  string
+	sectors uint64
+	kind    string
+	file    string
+}
+
+func parseDescriptor(b []byte) (*descriptor, error) {
+	d := &descriptor{}
+	// the descriptor is padded with zeroes to the end of its sectors
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := cut(line, "="); ok {
+			key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"`)
+			switch key {
+			case "CID":
+				cid, err := strconv.ParseUint(value, 16, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid VMDK CID %q: %v", value, err)
+				}
+				d.cid = uint32(cid)
+			case "parentCID":
+				d.parentCID = value
+			case "createType":
+				d.createType = value
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("invalid VMDK descriptor line %q", line)
+		}
+		sectors, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VMDK extent size in %q: %v", line, err)
+		}
+		d.extents = append(d.extents, extent{
+			access:  fields[0],
+			sectors: sectors,
+			kind:    fields[2],
+			file:    strings.Trim(strings.Join(fields[3:], " "), `"`),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read VMDK descriptor: %v", err)
+	}
+	return d, nil
+}
+
+func (d *descriptor) toBytes(sectors uint64, geo geometry) []byte {
+	var sb strings.Builder
+	sb.WriteString("# Disk DescriptorFile\n")
+	sb.WriteString("version=1\n")
+	fmt.Fprintf(&sb, "CID=%08x\n", d.cid)
+	fmt.Fprintf(&sb, "parentCID=%s\n", d.parentCID)
+	fmt.Fprintf(&sb, "createType=\"%s\"\n", d.createType)
+	sb.WriteString("\n# Extent description\n")
+	for _, e := range d.extents {
+		fmt.Fprintf(&sb, "%s %d %s \"%s\"\n", e.access, e.sectors, e.kind, e.file)
+	}
+	sb.WriteString("\n# The Disk Data Base\n#DDB\n\n")
+	sb.WriteString("ddb.virtualHWVersion = \"4\"\n")
+	fmt.Fprintf(&sb, "ddb.geometry.cylinders = \"%d\"\n", geo.cylinders)
+	fmt.Fprintf(&sb, "ddb.geometry.heads = \"%d\"\n", geo.heads)
+	fmt.Fprintf(&sb, "ddb.geometry.sectors = \"%d\"\n", geo.sectors)
+	sb.WriteString("ddb.adapterType = \"lsilogic\"\n")
+	b := make([]byte, descriptorSectors*sectorSize)
+	copy(b, sb.String())
+	return b
+}
+
+// geometry is the CHS geometry reported in the descriptor
+type geometry struct {
+	cylinders uint64
+	heads     uint64
+	sectors   uint64
+}
+
+// calculateGeometry returns the geometry VMware uses for SCSI disks of the given number of sectors
+func calculateGeometry(sectors uint64) geometry {
+	g := geometry{heads: 255, sectors: 63}
+	g.cylinders = sectors / (g.heads * g.sectors)
+	if g.cylinders > 65535 {
+		g.cylinders = 65535
+	}
+	return g
+}
+
+// cut slices s around the first instance of sep, like strings.Cut
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
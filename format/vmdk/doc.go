@@ -0,0 +1,22 @@
+// Package vmdk provides support for VMware VMDK disk images: reading monolithicSparse and streamOptimized
+// images, and creating streamOptimized ones.
+//
+// Both are a single sparse extent: a header, an embedded text descriptor, and grains of disk data located
+// through a grain directory and grain tables. In a streamOptimized image every grain is compressed and
+// preceded by a marker giving its place on the disk, and the grain tables and directory follow the grains,
+// so the image can be written, and read, as a stream.
+//
+// An Image wraps a util.File holding the VMDK and itself implements util.File, presenting the virtual disk.
+// Images opened with Open are read-only. An image from Create accepts writes, and is complete only once
+// Close is called.
+//
+// Normally, the best way to work with a VMDK image is through github.com/diskfs/go-diskfs,
+// by passing diskfs.VMDK to Create() or WithFormat() for Open(), and calling Close() on the Disk when done.
+//
+// Images with a parent, or split into several extents, are not supported.
+//
+// references:
+//
+//	https://web.archive.org/web/20220120213007/https://www.vmware.com/app/vmdk/?src=vmdk
+//	https://github.com/libyal/libvmdk/blob/main/documentation/VMWare%20Virtual%20Disk%20Format%20(VMDK).asciidoc
+package vmdk
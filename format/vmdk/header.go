@@ -0,0 +1,125 @@
+package vmdk
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	sectorSize         = 512
+	headerMagic        = 0x564d444b // "KDMV"
+	headerSize         = sectorSize
+	gdAtEnd     uint64 = 0xffffffffffffffff
+
+	flagValidNewlineTest = 1 << 0
+	flagRedundantGT      = 1 << 1
+	flagCompressed       = 1 << 16
+	flagMarkers          = 1 << 17
+
+	compressionNone    = 0
+	compressionDeflate = 1
+
+	// DefaultGrainSize is the grain size of new images, in bytes
+	DefaultGrainSize int64 = 64 * 1024
+	gtesPerGT              = 512
+)
+
+// header is the sparse extent header at the start of the file, and for stream-optimized images,
+// repeated as a footer at the end
+type header struct {
+	version           uint32
+	flags             uint32
+	capacity          uint64
+	grainSize         uint64
+	descriptorOffset  uint64
+	descriptorSize    uint64
+	numGTEsPerGT      uint32
+	rgdOffset         uint64
+	gdOffset          uint64
+	overHead          uint64
+	uncleanShutdown   bool
+	compressAlgorithm uint16
+}
+
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) != headerSize {
+		return nil, fmt.Errorf("cannot read VMDK header from %d bytes, must be exactly %d", len(b), headerSize)
+	}
+	if magic := binary.LittleEndian.Uint32(b[0:4]); magic != headerMagic {
+		return nil, fmt.Errorf("invalid VMDK magic %x", magic)
+	}
+	h := &header{
+		version:           binary.LittleEndian.Uint32(b[4:8]),
+		flags:             binary.LittleEndian.Uint32(b[8:12]),
+		capacity:          binary.LittleEndian.Uint64(b[12:20]),
+		grainSize:         binary.LittleEndian.Uint64(b[20:28]),
+		descriptorOffset:  binary.LittleEndian.Uint64(b[28:36]),
+		descriptorSize:    binary.LittleEndian.Uint64(b[36:44]),
+		numGTEsPerGT:      binary.LittleEndian.Uint32(b[44:48]),
+		rgdOffset:         binary.LittleEndian.Uint64(b[48:56]),
+		gdOffset:          binary.LittleEndian.Uint64(b[56:64]),
+		overHead:          binary.LittleEndian.Uint64(b[64:72]),
+		uncleanShutdown:   b[72] != 0,
+		compressAlgorithm: binary.LittleEndian.Uint16(b[77:79]),
+	}
+	if h.version < 1 || h.version > 3 {
+		return nil, fmt.Errorf("unsupported VMDK version %d", h.version)
+	}
+	if h.flags&flagValidNewlineTest != 0 && string(b[73:77]) != "\n \r\n" {
+		return nil, fmt.Errorf("VMDK header failed newline test, the file may have been corrupted by a text-mode transfer")
+	}
+	if h.grainSize < 8 || h.grainSize&(h.grainSize-1) != 0 {
+		return nil, fmt.Errorf("invalid VMDK grain size of %d sectors, must be a power of 2 of at least 8", h.grainSize)
+	}
+	if h.numGTEsPerGT == 0 {
+		return nil, fmt.Errorf("invalid VMDK grain table size of 0 entries")
+	}
+	if h.flags&flagCompressed != 0 && h.compressAlgorithm != compressionDeflate {
+		return nil, fmt.Errorf("unsupported VMDK compression algorithm %d", h.compressAlgorithm)
+	}
+	return h, nil
+}
+
+func (h *header) toBytes() []byte {
+	b := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(b[0:4], headerMagic)
+	binary.LittleEndian.PutUint32(b[4:8], h.version)
+	binary.LittleEndian.PutUint32(b[8:12], h.flags)
+	binary.LittleEndian.PutUint64(b[12:20], h.capacity)
+	binary.LittleEndian.PutUint64(b[20:28], h.grainSize)
+	binary.LittleEndian.PutUint64(b[28:36], h.descriptorOffset)
+	binary.LittleEndian.PutUint64(b[36:44], h.descriptorSize)
+	binary.LittleEndian.PutUint32(b[44:48], h.numGTEsPerGT)
+	binary.LittleEndian.PutUint64(b[48:56], h.rgdOffset)
+	binary.LittleEndian.PutUint64(b[56:64], h.gdOffset)
+	binary.LittleEndian.PutUint64(b[64:72], h.overHead)
+	if h.uncleanShutdown {
+		b[72] = 1
+	}
+	copy(b[73:77], "\n \r\n")
+	binary.LittleEndian.PutUint16(b[77:79], h.compressAlgorithm)
+	return b
+}
+
+// markerType is the type of a metadata marker in a stream-optimized image
+type markerType uint32
+
+const (
+	markerEOS    markerType = 0
+	markerGT     markerType = 1
+	markerGD     markerType = 2
+	markerFooter markerType = 3
+)
+
+// metadataMarker returns the sector-sized marker that precedes numSectors of metadata in a
+// stream-optimized image
+func metadataMarker(t markerType, numSectors uint64) []byte {
+	b := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint64(b[0:8], numSectors)
+	binary.LittleEndian.PutUint32(b[12:16], uint32(t))
+	return b
+}
+
+// grainMarkerSize is the size of the header before the data of a compressed grain: the LBA of the grain
+// in sectors and the size of the compressed data
+const grainMarkerSize = 12
@@ -0,0 +1,111 @@
+package vmdk
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := &header{
+		version:           3,
+		flags:             flagValidNewlineTest | flagCompressed | flagMarkers,
+		capacity:          20480,
+		grainSize:         128,
+		descriptorOffset:  1,
+		descriptorSize:    20,
+		numGTEsPerGT:      512,
+		gdOffset:          gdAtEnd,
+		overHead:          128,
+		compressAlgorithm: compressionDeflate,
+	}
+	b := h.toBytes()
+	if string(b[0:4]) != "KDMV" {
+		t.Errorf("magic %q instead of KDMV", b[0:4])
+	}
+	h2, err := headerFromBytes(b)
+	if err != nil {
+		t.Fatalf("unable to read header: %v", err)
+	}
+	if *h != *h2 {
+		t.Errorf("mismatched header, actual %#v expected %#v", h2, h)
+	}
+
+	badNewline := h.toBytes()
+	badNewline[75] = '\n'
+	badGrain := *h
+	badGrain.grainSize = 100
+	badCompression := *h
+	badCompression.compressAlgorithm = 5
+	badVersion := h.toBytes()
+	binary.LittleEndian.PutUint32(badVersion[4:8], 4)
+	tests := []struct {
+		b   []byte
+		err string
+	}{
+		{b[:100], "cannot read VMDK header from 100 bytes"},
+		{make([]byte, headerSize), "invalid VMDK magic"},
+		{badVersion, "unsupported VMDK version 4"},
+		{badNewline, "VMDK header failed newline test"},
+		{badGrain.toBytes(), "invalid VMDK grain size"},
+		{badCompression.toBytes(), "unsupported VMDK compression algorithm 5"},
+	}
+	for i, tt := range tests {
+		_, err := headerFromBytes(tt.b)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%d: mismatched error, actual %v expected prefix %q", i, err, tt.err)
+		}
+	}
+}
+
+func TestParseDescriptor(t *testing.T) {
+	text := `# Disk DescriptorFile
+version=1
+encoding="UTF-8"
+CID=7e5f3c21
+parentCID=ffffffff
+isNativeSnapshot="no"
+createType="monolithicSparse"
+
+# Extent description
+RW 41943040 SPARSE "my disk.vmdk"
+
+# The Disk Data Base
+#DDB
+
+ddb.adapterType = "lsilogic"
+ddb.geometry.cylinders = "2610"
+`
+	b := make([]byte, descriptorSectors*sectorSize)
+	copy(b, text)
+	d, err := parseDescriptor(b)
+	if err != nil {
+		t.Fatalf("unable to parse descriptor: %v", err)
+	}
+	expected := descriptor{
+		cid:        0x7e5f3c21,
+		parentCID:  noParentCID,
+		createType: createTypeMonolithicSparse,
+		extents:    []extent{{access: "RW", sectors: 41943040, kind: "SPARSE", file: "my disk.vmdk"}},
+	}
+	if d.cid != expected.cid || d.parentCID != expected.parentCID || d.createType != expected.createType ||
+		len(d.extents) != 1 || d.extents[0] != expected.extents[0] {
+		t.Errorf("mismatched descriptor, actual %#v expected %#v", d, expected)
+	}
+
+	// and what we write reads back the same
+	d2, err := parseDescriptor(d.toBytes(41943040, calculateGeometry(41943040)))
+	if err != nil {
+		t.Fatalf("unable to parse generated descriptor: %v", err)
+	}
+	if d2.cid != d.cid || d2.createType != d.createType || len(d2.extents) != 1 || d2.extents[0] != d.extents[0] {
+		t.Errorf("mismatched generated descriptor, actual %#v expected %#v", d2, d)
+	}
+
+	if _, err := parseDescriptor([]byte("CID=xyz\n")); err == nil {
+		t.Errorf("expected error for invalid CID")
+	}
+	if _, err := parseDescriptor([]byte("RW 100\n")); err == nil {
+		t.Errorf("expected error for invalid extent")
+	}
+}
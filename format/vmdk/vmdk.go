@@ -0,0 +1,517 @@
+package vmdk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// maxDirtyGrains is how many written grains are held uncompressed before the least recently used one
+	// is compressed and appended to the image
+	maxDirtyGrains = 64
+	// unallocatedGrain and zeroGrain are the grain table entries for grains that are not stored
+	unallocatedGrain = 0
+	zeroGrain        = 1
+)
+
+// Image is a VMDK disk image. It implements util.File for the virtual disk it contains.
+//
+// An Image returned by Open is read-only. An Image returned by Create is a stream-optimized image being
+// written, which is complete only once Close is called.
+type Image struct {
+	file       util.File
+	header     *header
+	grainSize  int64
+	compressed bool
+	// gt holds the grain table entries of all grains, in order
+	gt       []uint32
+	writable bool
+	closed   bool
+	offset   int64
+	// the last grain decompressed, to avoid inflating it again for every sector read
+	cachedGrain     int64
+	cachedGrainData []byte
+	// used only while writing a stream-optimized image
+	dirty      map[int64][]byte
+	dirtyOrder []int64
+	fileEnd    int64
+}
+
+// Create starts a new stream-optimized VMDK image with a virtual disk of size bytes, writing it to f
+// starting at offset 0. f is expected to be empty. size must be a multiple of 512.
+//
+// Grains are compressed as they are written; rewriting a grain that was already compressed appends
+// a new copy, so writing each area of the disk once produces the smallest image.
+// The grain tables and footer are written by Close, which must be called to complete the image.
+func Create(f util.File, size int64) (*Image, error) {
+	if size <= 0 || size%sectorSize != 0 {
+		return nil, fmt.Errorf("invalid VMDK size %d, must be a positive multiple of %d", size, sectorSize)
+	}
+	grainSectors := uint64(DefaultGrainSize / sectorSize)
+	h := &header{
+		version:           3,
+		flags:             flagValidNewlineTest | flagCompressed | flagMarkers,
+		capacity:          uint64(size / sectorSize),
+		grainSize:         grainSectors,
+		descriptorOffset:  1,
+		descriptorSize:    descriptorSectors,
+		numGTEsPerGT:      gtesPerGT,
+		gdOffset:          gdAtEnd,
+		overHead:          grainSectors,
+		compressAlgorithm: compressionDeflate,
+	}
+	name := "disk.vmdk"
+	if named, ok := f.(interface{ Name() string }); ok {
+		name = filepath.Base(named.Name())
+	}
+	cid := make([]byte, 4)
+	if _, err := rand.Read(cid); err != nil {
+		return nil, fmt.Errorf("unable to generate VMDK content ID: %v", err)
+	}
+	d := &descriptor{
+		cid:        binary.LittleEndian.Uint32(cid),
+		parentCID:  noParentCID,
+		createType: createTypeStreamOptimized,
+		extents:    []extent{{access: "RW", sectors: h.capacity, kind: "SPARSE", file: name}},
+	}
+	if _, err := f.WriteAt(h.toBytes(), 0); err != nil {
+		return nil, fmt.Errorf("unable to write VMDK header: %v", err)
+	}
+	if _, err := f.WriteAt(d.toBytes(h.capacity, calculateGeometry(h.capacity)), sectorSize); err != nil {
+		return nil, fmt.Errorf("unable to write VMDK descriptor: %v", err)
+	}
+	img := &Image{
+		file:        f,
+		header:      h,
+		grainSize:   DefaultGrainSize,
+		compressed:  true,
+		writable:    true,
+		cachedGrain: -1,
+		dirty:       map[int64][]byte{},
+		fileEnd:     int64(h.overHead) * sectorSize,
+	}
+	img.gt = make([]uint32, img.grains())
+	return img, nil
+}
+
+// Open opens an existing monolithicSparse or streamOptimized VMDK image in f, for reading only.
+func Open(f util.File, writable bool) (*Image, error) {
+	if writable {
+		return nil, errors.New("VMDK images can only be opened read-only")
+	}
+	b := make([]byte, headerSize)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("unable to read VMDK header: %v", err)
+	}
+	h, err := headerFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if h.descriptorSize == 0 {
+		return nil, errors.New("VMDK image has no embedded descriptor")
+	}
+	db := make([]byte, h.descriptorSize*sectorSize)
+	if _, err := f.ReadAt(db, int64(h.descriptorOffset)*sectorSize); err != nil {
+		return nil, fmt.Errorf("unable to read VMDK descriptor: %v", err)
+	}
+	d, err := parseDescriptor(db)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case d.createType != createTypeMonolithicSparse && d.createType != createTypeStreamOptimized:
+		return nil, fmt.Errorf("unsupported VMDK create type %q", d.createType)
+	case d.parentCID != "" && d.parentCID != noParentCID:
+		return nil, errors.New("VMDK images with a parent are not supported")
+	case len(d.extents) != 1:
+		return nil, fmt.Errorf("unsupported VMDK with %d extents", len(d.extents))
+	}
+
+	if h.gdOffset == gdAtEnd {
+		// the real values are in the footer, just before the end-of-stream marker
+		end, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find end of VMDK file: %v", err)
+		}
+		if _, err := f.ReadAt(b, end-2*sectorSize); err != nil {
+			return nil, fmt.Errorf("unable to read VMDK footer: %v", err)
+		}
+		if h, err = headerFromBytes(b); err != nil {
+			return nil, fmt.Errorf("invalid VMDK footer: %v", err)
+		}
+		if h.gdOffset == gdAtEnd {
+			return nil, errors.New("VMDK footer has no grain directory")
+		}
+	}
+
+	img := &Image{
+		file:        f,
+		header:      h,
+		grainSize:   int64(h.grainSize) * sectorSize,
+		compressed:  h.flags&flagCompressed != 0,
+		cachedGrain: -1,
+	}
+	if err := img.readGrainTables(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Size returns the size in bytes of the virtual disk
+func (i *Image) Size() int64 {
+	return int64(i.header.capacity) * sectorSize
+}
+
+// ReadAt reads len(b) bytes from the virtual disk starting at byte offset off.
+// Grains that are not stored in the image read as zeros.
+func (i *Image) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	}
+	size := i.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var retErr error
+	if int64(len(b)) > size-off {
+		b = b[:size-off]
+		retErr = io.EOF
+	}
+	read := 0
+	for read < len(b) {
+		pos := off + int64(read)
+		grain := pos / i.grainSize
+		inGrain := pos % i.grainSize
+		n := int(i.grainSize - inGrain)
+		if n > len(b)-read {
+			n = len(b) - read
+		}
+		chunk := b[read : read+n]
+		if err := i.readGrain(grain, inGrain, chunk); err != nil {
+			return read, err
+		}
+		read += n
+	}
+	return read, retErr
+}
+
+// WriteAt writes len(b) bytes to the virtual disk starting at byte offset off.
+// Only an Image returned by Create, and not yet closed, can be written.
+func (i *Image) WriteAt(b []byte, off int64) (int, error) {
+	switch {
+	case !i.writable:
+		return 0, errors.New("VMDK image not open for writing")
+	case i.closed:
+		return 0, errors.New("VMDK image already closed")
+	case off < 0:
+		return 0, fmt.Errorf("invalid negative offset %d", off)
+	case off+int64(len(b)) > i.Size():
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d beyond end of disk of size %d", len(b), off, i.Size())
+	}
+	written := 0
+	for written < len(b) {
+		pos := off + int64(written)
+		grain := pos / i.grainSize
+		inGrain := pos % i.grainSize
+		n := int(i.grainSize - inGrain)
+		if n > len(b)-written {
+			n = len(b) - written
+		}
+		data, ok := i.dirty[grain]
+		if !ok {
+			if i.gt[grain] == unallocatedGrain && isZero(b[written:written+n]) {
+				written += n
+				continue
+			}
+			data = make([]byte, i.grainSize)
+			if n < len(data) {
+				if err := i.readGrain(grain, 0, data); err != nil {
+					return written, err
+				}
+			}
+			if err := i.addDirty(grain, data); err != nil {
+				return written, err
+			}
+		} else {
+			i.touchDirty(grain)
+		}
+		copy(data[inGrain:], b[written:written+n])
+		written += n
+	}
+	return written, nil
+}
+
+// Seek sets the offset for the next Read or Write. Only provided to satisfy util.File;
+// all access to the image is through ReadAt and WriteAt.
+func (i *Image) Seek(offset int64, whence int) (int64, error) {
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset += i.offset
+	case io.SeekEnd:
+		newOffset += i.Size()
+	default:
+		return i.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if newOffset < 0 {
+		return i.offset, fmt.Errorf("cannot set offset %d before start of disk", newOffset)
+	}
+	i.offset = newOffset
+	return i.offset, nil
+}
+
+// Close completes an image being written by writing any remaining grains, followed by the grain tables,
+// grain directory, footer and end-of-stream marker. It then closes the underlying file, if it
+// implements io.Closer.
+func (i *Image) Close() error {
+	if i.closed {
+		return nil
+	}
+	if i.writable {
+		if err := i.finish(); err != nil {
+			return err
+		}
+	}
+	i.closed = true
+	if c, ok := i.file.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// grains is the number of grains in the virtual disk
+func (i *Image) grains() int64 {
+	return (i.Size() + i.grainSize - 1) / i.grainSize
+}
+
+// readGrainTables reads the grain directory and every grain table it refers to
+func (i *Image) readGrainTables() error {
+	grains := i.grains()
+	gtes := int64(i.header.numGTEsPerGT)
+	gdEntries := (grains + gtes - 1) / gtes
+	gd := make([]byte, gdEntries*4)
+	if _, err := i.file.ReadAt(gd, int64(i.header.gdOffset)*sectorSize); err != nil {
+		return fmt.Errorf("unable to read VMDK grain directory: %v", err)
+	}
+	i.gt = make([]uint32, grains)
+	gt := make([]byte, gtes*4)
+	for j := int64(0); j < gdEntries; j++ {
+		gtOffset := binary.LittleEndian.Uint32(gd[j*4:])
+		if gtOffset == 0 {
+			continue
+		}
+		if _, err := i.file.ReadAt(gt, int64(gtOffset)*sectorSize); err != nil {
+			return fmt.Errorf("unable to read VMDK grain table %d: %v", j, err)
+		}
+		for k := int64(0); k < gtes && j*gtes+k < grains; k++ {
+			i.gt[j*gtes+k] = binary.LittleEndian.Uint32(gt[k*4:])
+		}
+	}
+	return nil
+}
+
+// readGrain reads len(b) bytes of grain starting at offset inGrain
+func (i *Image) readGrain(grain, inGrain int64, b []byte) error {
+	if data, ok := i.dirty[grain]; ok {
+		copy(b, data[inGrain:])
+		return nil
+	}
+	entry := i.gt[grain]
+	switch {
+	case entry == unallocatedGrain || entry == zeroGrain:
+		for j := range b {
+			b[j] = 0
+		}
+	case !i.compressed:
+		if _, err := i.file.ReadAt(b, int64(entry)*sectorSize+inGrain); err != nil {
+			return fmt.Errorf("unable to read grain %d: %v", grain, err)
+		}
+	default:
+		if i.cachedGrain != grain {
+			data, err := i.readCompressedGrain(grain, int64(entry)*sectorSize)
+			if err != nil {
+				return err
+			}
+			i.cachedGrain, i.cachedGrainData = grain, data
+		}
+		copy(b, i.cachedGrainData[inGrain:])
+	}
+	return nil
+}
+
+func (i *Image) readCompressedGrain(grain, offset int64) ([]byte, error) {
+	marker := make([]byte, grainMarkerSize)
+	if _, err := i.file.ReadAt(marker, offset); err != nil {
+		return nil, fmt.Errorf("unable to read marker of grain %d: %v", grain, err)
+	}
+	size := binary.LittleEndian.Uint32(marker[8:12])
+	compressed := make([]byte, size)
+	if _, err := i.file.ReadAt(compressed, offset+grainMarkerSize); err != nil {
+		return nil, fmt.Errorf("unable to read grain %d: %v", grain, err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress grain %d: %v", grain, err)
+	}
+	data := make([]byte, i.grainSize)
+	// the last grain may be cut short at the end of the disk
+	if _, err := io.ReadFull(zr, data); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("unable to decompress grain %d: %v", grain, err)
+	}
+	return data, nil
+}
+
+// addDirty holds a grain that is being written, compressing the least recently used one if there are too many
+func (i *Image) addDirty(grain int64, data []byte) error {
+	if len(i.dirtyOrder) >= maxDirtyGrains {
+		if err := i.writeGrain(i.dirtyOrder[0]); err != nil {
+			return err
+		}
+	}
+	i.dirty[grain] = data
+	i.dirtyOrder = append(i.dirtyOrder, grain)
+	return nil
+}
+
+// touchDirty marks a held grain as the most recently used
+func (i *Image) touchDirty(grain int64) {
+	for j, g := range i.dirtyOrder {
+		if g == grain {
+			copy(i.dirtyOrder[j:], i.dirtyOrder[j+1:])
+			i.dirtyOrder[len(i.dirtyOrder)-1] = grain
+			return
+		}
+	}
+}
+
+// writeGrain compresses a held grain and appends it to the image
+func (i *Image) writeGrain(grain int64) error {
+	data := i.dirty[grain]
+	delete(i.dirty, grain)
+	for j, g := range i.dirtyOrder {
+		if g == grain {
+			i.dirtyOrder = append(i.dirtyOrder[:j], i.dirtyOrder[j+1:]...)
+			break
+		}
+	}
+	if grain == i.cachedGrain {
+		i.cachedGrain = -1
+	}
+	// a grain that was never stored needs nothing to read as zeroes, but one that was must be superseded
+	// by a new copy, as readers of the stream apply grains in the order they appear
+	if isZero(data) && i.gt[grain] == unallocatedGrain {
+		return nil
+	}
+	// the last grain only holds what is left of the disk
+	if remaining := i.Size() - grain*i.grainSize; remaining < int64(len(data)) {
+		data = data[:remaining]
+	}
+	var buf bytes.Buffer
+	marker := make([]byte, grainMarkerSize)
+	buf.Write(marker)
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return fmt.Errorf("unable to compress grain %d: %v", grain, err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("unable to compress grain %d: %v", grain, err)
+	}
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint64(b[0:8], uint64(grain*i.grainSize/sectorSize))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(b)-grainMarkerSize))
+	b = append(b, make([]byte, roundUp(int64(len(b)), sectorSize)-int64(len(b)))...)
+	if _, err := i.file.WriteAt(b, i.fileEnd); err != nil {
+		return fmt.Errorf("unable to write grain %d: %v", grain, err)
+	}
+	i.gt[grain] = uint32(i.fileEnd / sectorSize)
+	i.fileEnd += int64(len(b))
+	return nil
+}
+
+// finish writes everything that follows the grains in a stream-optimized image
+func (i *Image) finish() error {
+	pending := make([]int64, 0, len(i.dirty))
+	for grain := range i.dirty {
+		pending = append(pending, grain)
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a] < pending[b] })
+	for _, grain := range pending {
+		if err := i.writeGrain(grain); err != nil {
+			return err
+		}
+	}
+
+	write := func(b []byte, what string) error {
+		if _, err := i.file.WriteAt(b, i.fileEnd); err != nil {
+			return fmt.Errorf("unable to write VMDK %s: %v", what, err)
+		}
+		i.fileEnd += int64(len(b))
+		return nil
+	}
+	gtes := int64(i.header.numGTEsPerGT)
+	gtSectors := uint64(roundUp(gtes*4, sectorSize) / sectorSize)
+	gdEntries := (i.grains() + gtes - 1) / gtes
+	gd := make([]byte, roundUp(gdEntries*4, sectorSize))
+	for j := int64(0); j < gdEntries; j++ {
+		entries := i.gt[j*gtes:]
+		if int64(len(entries)) > gtes {
+			entries = entries[:gtes]
+		}
+		empty := true
+		gt := make([]byte, gtSectors*sectorSize)
+		for k, e := range entries {
+			binary.LittleEndian.PutUint32(gt[k*4:], e)
+			if e != unallocatedGrain {
+				empty = false
+			}
+		}
+		if empty {
+			continue
+		}
+		if err := write(metadataMarker(markerGT, gtSectors), "grain table marker"); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(gd[j*4:], uint32(i.fileEnd/sectorSize))
+		if err := write(gt, "grain table"); err != nil {
+			return err
+		}
+	}
+	if err := write(metadataMarker(markerGD, uint64(len(gd)/sectorSize)), "grain directory marker"); err != nil {
+		return err
+	}
+	footer := *i.header
+	footer.gdOffset = uint64(i.fileEnd / sectorSize)
+	if err := write(gd, "grain directory"); err != nil {
+		return err
+	}
+	if err := write(metadataMarker(markerFooter, 1), "footer marker"); err != nil {
+		return err
+	}
+	if err := write(footer.toBytes(), "footer"); err != nil {
+		return err
+	}
+	if err := write(metadataMarker(markerEOS, 0), "end-of-stream marker"); err != nil {
+		return err
+	}
+	i.writable = false
+	return nil
+}
+
+func roundUp(n, multiple int64) int64 {
+	return (n + multiple - 1) / multiple * multiple
+}
+
+// isZero reports whether b is all zeroes
+func isZero(b []byte) bool {
+	return len(bytes.Trim(b, "\x00")) == 0
+}
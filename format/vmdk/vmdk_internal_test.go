@@ -0,0 +1,160 @@
+package vmdk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+// TestOpenMonolithicSparse reads an uncompressed sparse extent laid out the way VMware writes them,
+// with redundant grain tables before the primary ones
+func TestOpenMonolithicSparse(t *testing.T) {
+	const (
+		capacity = 8192
+		overhead = 128
+	)
+	f := testhelper.TempFile(t, "vmdk_test")
+	h := &header{
+		version:          1,
+		flags:            flagValidNewlineTest | flagRedundantGT,
+		capacity:         capacity,
+		grainSize:        128,
+		descriptorOffset: 1,
+		descriptorSize:   descriptorSectors,
+		numGTEsPerGT:     gtesPerGT,
+		rgdOffset:        21,
+		gdOffset:         26,
+		overHead:         overhead,
+	}
+	d := &descriptor{
+		cid:        0x12345678,
+		parentCID:  noParentCID,
+		createType: createTypeMonolithicSparse,
+		extents:    []extent{{access: "RW", sectors: capacity, kind: "SPARSE", file: "test.vmdk"}},
+	}
+	gd := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint32(gd, 27)
+	rgd := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint32(rgd, 22)
+	gt := make([]byte, 4*sectorSize)
+	grains := map[int][]byte{3: make([]byte, 64*1024), 10: make([]byte, 64*1024), 63: make([]byte, 64*1024)}
+	next := uint32(overhead)
+	for _, g := range []int{3, 10, 63} {
+		_, _ = rand.Read(grains[g])
+		binary.LittleEndian.PutUint32(gt[g*4:], next)
+		if _, err := f.WriteAt(grains[g], int64(next)*sectorSize); err != nil {
+			t.Fatalf("unable to write grain: %v", err)
+		}
+		next += 128
+	}
+	// a zero grain has no data
+	binary.LittleEndian.PutUint32(gt[5*4:], zeroGrain)
+	for off, b := range map[int64][]byte{0: h.toBytes(), 1: d.toBytes(capacity, calculateGeometry(capacity)), 21: rgd, 22: gt, 26: gd, 27: gt} {
+		if _, err := f.WriteAt(b, off*sectorSize); err != nil {
+			t.Fatalf("unable to write image: %v", err)
+		}
+	}
+
+	if _, err := Open(f, true); err == nil {
+		t.Errorf("expected error opening writable")
+	}
+	img, err := Open(f, false)
+	if err != nil {
+		t.Fatalf("unable to open image: %v", err)
+	}
+	if img.Size() != capacity*sectorSize {
+		t.Errorf("size %d instead of %d", img.Size(), capacity*sectorSize)
+	}
+	disk := make([]byte, capacity*sectorSize)
+	for g, data := range grains {
+		copy(disk[g*64*1024:], data)
+	}
+	b := make([]byte, len(disk))
+	if _, err := img.ReadAt(b, 0); err != nil {
+		t.Fatalf("unable to read disk: %v", err)
+	}
+	if !bytes.Equal(b, disk) {
+		t.Errorf("mismatched disk contents")
+	}
+	b = make([]byte, 1000)
+	if _, err := img.ReadAt(b, 10*64*1024+5000); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, disk[10*64*1024+5000:10*64*1024+6000]) {
+		t.Errorf("mismatched partial grain")
+	}
+}
+
+// TestStreamOrder checks that a reader applying the grains in the order they appear in the stream,
+// the way streaming importers do, gets the same disk as one using the grain tables
+func TestStreamOrder(t *testing.T) {
+	size := int64(8 * 1024 * 1024)
+	f := testhelper.TempFile(t, "vmdk_test")
+	img, err := Create(f, size)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	// write enough grains to force some out of memory, then rewrite some of those already stored
+	disk := make([]byte, size)
+	_, _ = rand.Read(disk[:(maxDirtyGrains+10)*DefaultGrainSize])
+	if _, err := img.WriteAt(disk[:(maxDirtyGrains+10)*DefaultGrainSize], 0); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	copy(disk[100:], make([]byte, DefaultGrainSize))
+	copy(disk[5*DefaultGrainSize:], make([]byte, DefaultGrainSize))
+	if _, err := img.WriteAt(disk[100:100+DefaultGrainSize], 100); err != nil {
+		t.Fatalf("unable to rewrite: %v", err)
+	}
+	if _, err := img.WriteAt(disk[5*DefaultGrainSize:6*DefaultGrainSize], 5*DefaultGrainSize); err != nil {
+		t.Fatalf("unable to rewrite: %v", err)
+	}
+	if err := img.Close(); err != nil {
+		t.Fatalf("unable to close image: %v", err)
+	}
+
+	f2, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("unable to reopen file: %v", err)
+	}
+	defer f2.Close()
+	streamed := make([]byte, size)
+	offset := int64(DefaultGrainSize)
+	marker := make([]byte, sectorSize)
+	for {
+		if _, err := f2.ReadAt(marker, offset); err != nil {
+			t.Fatalf("unable to read marker at %d: %v", offset, err)
+		}
+		val := binary.LittleEndian.Uint64(marker[0:8])
+		dataSize := binary.LittleEndian.Uint32(marker[8:12])
+		if dataSize == 0 {
+			if markerType(binary.LittleEndian.Uint32(marker[12:16])) == markerEOS {
+				break
+			}
+			offset += sectorSize + int64(val)*sectorSize
+			continue
+		}
+		compressed := make([]byte, dataSize)
+		if _, err := f2.ReadAt(compressed, offset+grainMarkerSize); err != nil {
+			t.Fatalf("unable to read grain at %d: %v", offset, err)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("unable to decompress grain at %d: %v", offset, err)
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("unable to decompress grain at %d: %v", offset, err)
+		}
+		copy(streamed[val*sectorSize:], data)
+		offset += roundUp(grainMarkerSize+int64(dataSize), sectorSize)
+	}
+	if !bytes.Equal(streamed, disk) {
+		t.Errorf("streamed disk contents do not match")
+	}
+}
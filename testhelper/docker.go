@@ -11,6 +11,11 @@ import (
 // DockerRun run a docker container
 // thanks to moby/tool, which is licensed apache 2.0
 func DockerRun(input io.Reader, output io.Writer, trust, rm bool, mounts map[string]string, img string, args ...string) error {
+	return dockerRun(input, output, trust, rm, false, mounts, img, args...)
+}
+
+// dockerRun runs a docker container, as DockerRun, privileged if privileged is set
+func dockerRun(input io.Reader, output io.Writer, trust, rm, privileged bool, mounts map[string]string, img string, args ...string) error {
 	docker, err := exec.LookPath("docker")
 	if err != nil {
 		return errors.New("docker does not seem to be installed")
@@ -25,6 +30,9 @@ func DockerRun(input io.Reader, output io.Writer, trust, rm bool, mounts map[str
 	if rm {
 		dArgs = append(dArgs, "--rm")
 	}
+	if privileged {
+		dArgs = append(dArgs, "--privileged")
+	}
 	for k, v := range mounts {
 		dArgs = append(dArgs, "-v", fmt.Sprintf("%s:%s", k, v))
 	}
@@ -0,0 +1,57 @@
+package testhelper
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// QemuImg runs qemu-img with args, and returns its output. If qemu-img is not installed, the test is skipped;
+// if it fails, the test fails.
+func QemuImg(t *testing.T, args ...string) []byte {
+	t.Helper()
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		t.Skip("qemu-img not available")
+	}
+	out, err := exec.Command("qemu-img", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("qemu-img %v failed: %v\n%s", args, err, out)
+	}
+	return out
+}
+
+// QemuImgCheck has qemu-img check the image at p, of qemu-img format format, for consistency, failing the test
+// if it finds any errors or leaked clusters
+func QemuImgCheck(t *testing.T, format, p string) {
+	t.Helper()
+	QemuImg(t, "check", "-f", format, p)
+}
+
+// QemuImgRead has qemu-img convert the image at p, of qemu-img format format, to raw, and returns the disk it
+// holds
+func QemuImgRead(t *testing.T, format, p string) []byte {
+	t.Helper()
+	raw := filepath.Join(t.TempDir(), "disk.raw")
+	QemuImg(t, "convert", "-f", format, "-O", "raw", p, raw)
+	b, err := os.ReadFile(raw)
+	if err != nil {
+		t.Fatalf("unable to read converted image: %v", err)
+	}
+	return b
+}
+
+// QemuImgWrite has qemu-img convert disk to a new image, of qemu-img format format, passing it any further args,
+// e.g. "-o", "subformat=fixed", and returns the path of the image
+func QemuImgWrite(t *testing.T, disk []byte, format string, args ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "disk.raw")
+	if err := os.WriteFile(raw, disk, 0o644); err != nil {
+		t.Fatalf("unable to write raw disk: %v", err)
+	}
+	p := filepath.Join(dir, "disk."+format)
+	args = append([]string{"convert", "-f", "raw", "-O", format}, args...)
+	QemuImg(t, append(args, raw, p)...)
+	return p
+}
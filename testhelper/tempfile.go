@@ -0,0 +1,21 @@
+package testhelper
+
+import (
+	"os"
+	"testing"
+)
+
+// TempFile creates a file in the temporary directory, named as by os.CreateTemp with pattern, that is closed and
+// removed when the test completes
+func TempFile(t *testing.T, pattern string) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		t.Fatalf("unable to create tempfile: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	return f
+}
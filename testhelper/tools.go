@@ -0,0 +1,51 @@
+package testhelper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// RunTools runs args, a command that needs tools which are not usually installed, e.g. mkfs.btrfs, in the directory
+// dir, and returns its combined output, and an error if it failed. It runs on the host if all of tools are installed,
+// and otherwise in the docker image in TEST_IMAGE, which has them, privileged so that they can mount filesystems with
+// FUSE. The directory of the test, which has its testdata, and dir are mounted at the same paths in the container,
+// and what the command makes in dir is given to the user running the test. If neither can run the tools, the test is
+// skipped.
+func RunTools(t *testing.T, dir string, tools []string, args ...string) ([]byte, error) {
+	t.Helper()
+	var out bytes.Buffer
+	if hasTools(tools) {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
+		cmd.Stdout, cmd.Stderr = &out, &out
+		err := cmd.Run()
+		return out.Bytes(), err
+	}
+	img := os.Getenv("TEST_IMAGE")
+	if img == "" {
+		t.Skipf("%v not available, and TEST_IMAGE not set", tools)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unable to get working directory: %v", err)
+	}
+	mounts := map[string]string{
+		wd:  wd + ":ro",
+		dir: dir,
+	}
+	script := fmt.Sprintf(`cd "$0" && "$@"; status=$?; chown -R %d:%d "$0"; exit $status`, os.Getuid(), os.Getgid())
+	err = dockerRun(nil, &out, false, true, true, mounts, img, append([]string{"sh", "-c", script, dir}, args...)...)
+	return out.Bytes(), err
+}
+
+func hasTools(tools []string) bool {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// memFilePageSize is the unit in which MemFile allocates memory
+const memFilePageSize = 4096
+
+// MemFile is a File held entirely in memory, for building or reading images without touching the
+// host filesystem.
+//
+// Memory is allocated in pages only as they are written with something other than zeroes, so a large
+// MemFile that is mostly empty, like a freshly created disk image, uses little memory. Unwritten areas
+// read as zeroes. Writing beyond the end grows the file, just as with an os.File.
+//
+// A MemFile is safe for concurrent use.
+type MemFile struct {
+	mu     sync.RWMutex
+	pages  map[int64][]byte
+	size   int64
+	offset int64
+}
+
+// NewMemFile creates a MemFile of size bytes, all of them zero
+func NewMemFile(size int64) *MemFile {
+	if size < 0 {
+		size = 0
+	}
+	return &MemFile{
+		pages: map[int64][]byte{},
+		size:  size,
+	}
+}
+
+// Size returns the current size of the file
+func (m *MemFile) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.size
+}
+
+// ReadAt reads len(b) bytes starting at byte offset off. It returns io.EOF if it reaches the end of the file.
+func (m *MemFile) ReadAt(b []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.readAt(b, off)
+}
+
+// WriteAt writes len(b) bytes starting at byte offset off, growing the file if needed
+func (m *MemFile) WriteAt(b []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeAt(b, off)
+}
+
+// Read reads up to len(b) bytes from the current offset, and advances it
+func (m *MemFile) Read(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.readAt(b, m.offset)
+	m.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Write writes len(b) bytes at the current offset, and advances it
+func (m *MemFile) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.writeAt(b, m.offset)
+	m.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read or Write
+func (m *MemFile) Seek(offset int64, whence int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset += m.offset
+	case io.SeekEnd:
+		newOffset += m.size
+	default:
+		return m.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if newOffset < 0 {
+		return m.offset, fmt.Errorf("cannot set offset %d before start of file", newOffset)
+	}
+	m.offset = newOffset
+	return m.offset, nil
+}
+
+// Truncate changes the size of the file, discarding anything beyond the new size
+func (m *MemFile) Truncate(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid negative size %d", size)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for index, page := range m.pages {
+		start := index * memFilePageSize
+		switch {
+		case start >= size:
+			delete(m.pages, index)
+		case start+memFilePageSize > size:
+			// clear the tail, so it reads as zeroes if the file grows again
+			for i := size - start; i < memFilePageSize; i++ {
+				page[i] = 0
+			}
+		}
+	}
+	m.size = size
+	return nil
+}
+
+// WriteTo writes a snapshot of the entire contents of the file to w, regardless of the current offset.
+// Writes to the file wait until it is done.
+func (m *MemFile) WriteTo(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var (
+		written int64
+		zeroes  = make([]byte, memFilePageSize)
+	)
+	for written < m.size {
+		b, ok := m.pages[written/memFilePageSize]
+		if !ok {
+			b = zeroes
+		}
+		if remaining := m.size - written; remaining < int64(len(b)) {
+			b = b[:remaining]
+		}
+		n, err := w.Write(b)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if n != len(b) {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Bytes returns a copy of the entire contents of the file
+func (m *MemFile) Bytes() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b := make([]byte, m.size)
+	_, _ = m.readAt(b, 0)
+	return b
+}
+
+func (m *MemFile) readAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	var retErr error
+	if int64(len(b)) > m.size-off {
+		b = b[:m.size-off]
+		retErr = io.EOF
+	}
+	read := 0
+	for read < len(b) {
+		pos := off + int64(read)
+		index, inPage := pos/memFilePageSize, pos%memFilePageSize
+		chunk := b[read:]
+		if int64(len(chunk)) > memFilePageSize-inPage {
+			chunk = chunk[:memFilePageSize-inPage]
+		}
+		if page, ok := m.pages[index]; ok {
+			copy(chunk, page[inPage:])
+		} else {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		}
+		read += len(chunk)
+	}
+	return read, retErr
+}
+
+func (m *MemFile) writeAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	written := 0
+	for written < len(b) {
+		pos := off + int64(written)
+		index, inPage := pos/memFilePageSize, pos%memFilePageSize
+		chunk := b[written:]
+		if int64(len(chunk)) > memFilePageSize-inPage {
+			chunk = chunk[:memFilePageSize-inPage]
+		}
+		page, ok := m.pages[index]
+		if !ok && !isZero(chunk) {
+			page = make([]byte, memFilePageSize)
+			m.pages[index] = page
+			ok = true
+		}
+		if ok {
+			copy(page[inPage:], chunk)
+		}
+		written += len(chunk)
+	}
+	if end := off + int64(len(b)); end > m.size {
+		m.size = end
+	}
+	return written, nil
+}
+
+// isZero reports whether b is all zeroes
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
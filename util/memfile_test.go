@@ -0,0 +1,279 @@
+package util_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestMemFileReadWrite(t *testing.T) {
+	m := util.NewMemFile(10000)
+	if m.Size() != 10000 {
+		t.Errorf("size %d instead of 10000", m.Size())
+	}
+	b := make([]byte, 10000)
+	for i := range b {
+		b[i] = 0xff
+	}
+	n, err := m.ReadAt(b, 0)
+	if err != nil || n != 10000 {
+		t.Fatalf("read returned %d, %v", n, err)
+	}
+	if !bytes.Equal(b, make([]byte, 10000)) {
+		t.Errorf("new file did not read as zeroes")
+	}
+
+	// writes across pages and beyond the end
+	data := make([]byte, 20000)
+	_, _ = rand.Read(data)
+	if n, err := m.WriteAt(data, 3000); err != nil || n != len(data) {
+		t.Fatalf("write returned %d, %v", n, err)
+	}
+	if m.Size() != 23000 {
+		t.Errorf("size %d instead of 23000 after writing beyond the end", m.Size())
+	}
+	b = make([]byte, 20000)
+	if _, err := m.ReadAt(b, 3000); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("mismatched data")
+	}
+	b = make([]byte, 100)
+	n, err = m.ReadAt(b, 22950)
+	if n != 50 || err != io.EOF {
+		t.Errorf("read past end returned %d, %v instead of 50, EOF", n, err)
+	}
+	if _, err := m.ReadAt(b, -1); err == nil {
+		t.Errorf("expected error reading at negative offset")
+	}
+
+	// writing far beyond the end leaves a hole
+	if _, err := m.WriteAt([]byte{1}, 1024*1024*1024); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	if _, err := m.ReadAt(b, 500*1024*1024); err != nil {
+		t.Fatalf("unable to read hole: %v", err)
+	}
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("hole did not read as zeroes")
+	}
+
+	// truncating down and growing again leaves zeroes
+	if err := m.Truncate(5000); err != nil {
+		t.Fatalf("unable to truncate: %v", err)
+	}
+	if err := m.Truncate(30000); err != nil {
+		t.Fatalf("unable to truncate: %v", err)
+	}
+	b = make([]byte, 25000)
+	if _, err := m.ReadAt(b, 5000); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("truncated data was not cleared")
+	}
+	b = make([]byte, 2000)
+	if _, err := m.ReadAt(b, 3000); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, data[:2000]) {
+		t.Errorf("data before truncation point was lost")
+	}
+}
+
+func TestMemFileReadWriteSeek(t *testing.T) {
+	m := util.NewMemFile(0)
+	if _, err := m.Write([]byte("hello, ")); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	if _, err := m.Write([]byte("world")); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	if pos, err := m.Seek(-5, io.SeekEnd); err != nil || pos != 7 {
+		t.Fatalf("seek returned %d, %v", pos, err)
+	}
+	b, err := io.ReadAll(m)
+	if err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if string(b) != "world" {
+		t.Errorf("read %q instead of %q", b, "world")
+	}
+	if _, err := m.Seek(-100, io.SeekCurrent); err == nil {
+		t.Errorf("expected error seeking before start")
+	}
+	if string(m.Bytes()) != "hello, world" {
+		t.Errorf("contents %q", m.Bytes())
+	}
+}
+
+func TestMemFileWriteTo(t *testing.T) {
+	m := util.NewMemFile(3 * 4096)
+	data := make([]byte, 100)
+	_, _ = rand.Read(data)
+	if _, err := m.WriteAt(data, 5000); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	expected := make([]byte, 3*4096+1)
+	copy(expected[5000:], data)
+	expected[3*4096] = 7
+	if _, err := m.WriteAt([]byte{7}, 3*4096); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unable to write snapshot: %v", err)
+	}
+	if n != int64(len(expected)) || !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("mismatched snapshot of %d bytes", n)
+	}
+}
+
+func TestMemFileConcurrent(t *testing.T) {
+	m := util.NewMemFile(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := bytes.Repeat([]byte{byte(i + 1)}, 10000)
+			_, _ = m.WriteAt(b, int64(i)*10000)
+			_, _ = m.ReadAt(make([]byte, 10000), int64(i)*5000)
+		}(i)
+	}
+	wg.Wait()
+	b := m.Bytes()
+	for i := 0; i < 8; i++ {
+		if !bytes.Equal(b[i*10000:(i+1)*10000], bytes.Repeat([]byte{byte(i + 1)}, 10000)) {
+			t.Errorf("mismatched data for writer %d", i)
+		}
+	}
+}
+
+func TestMemFileImages(t *testing.T) {
+	t.Run("gpt and fat32", func(t *testing.T) {
+		size := int64(100 * 1024 * 1024)
+		m := util.NewMemFile(size)
+		table := &gpt.Table{
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: 512,
+			ProtectiveMBR:      true,
+			Partitions: []*gpt.Partition{
+				{Start: 2048, End: 2048 + 50*1024*2 - 1, Type: gpt.EFISystemPartition, Name: "EFI System"},
+			},
+		}
+		if err := table.Write(m, size); err != nil {
+			t.Fatalf("unable to write partition table: %v", err)
+		}
+		part := table.Partitions[0]
+		fs, err := fat32.Create(m, part.GetSize(), part.GetStart(), 512, "MEMORY")
+		if err != nil {
+			t.Fatalf("unable to create filesystem: %v", err)
+		}
+		content := []byte("hello from memory")
+		rw, err := fs.OpenFile("/HELLO.TXT", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("unable to create file: %v", err)
+		}
+		if _, err := rw.Write(content); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+
+		// round trip through a snapshot
+		var buf bytes.Buffer
+		if _, err := m.WriteTo(&buf); err != nil {
+			t.Fatalf("unable to snapshot: %v", err)
+		}
+		m2 := util.NewMemFile(0)
+		if _, err := m2.WriteAt(buf.Bytes(), 0); err != nil {
+			t.Fatalf("unable to load snapshot: %v", err)
+		}
+		if _, err := gpt.Read(m2, 512, 512); err != nil {
+			t.Fatalf("unable to read partition table: %v", err)
+		}
+		fs2, err := fat32.Read(m2, part.GetSize(), part.GetStart(), 512)
+		if err != nil {
+			t.Fatalf("unable to read filesystem: %v", err)
+		}
+		ro, err := fs2.OpenFile("/HELLO.TXT", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("unable to open file: %v", err)
+		}
+		b, err := io.ReadAll(ro)
+		if err != nil {
+			t.Fatalf("unable to read file: %v", err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Errorf("read %q instead of %q", b, content)
+		}
+	})
+
+	t.Run("squashfs", func(t *testing.T) {
+		m := util.NewMemFile(0)
+		fs, err := squashfs.Create(m, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("unable to create filesystem: %v", err)
+		}
+		rw, err := fs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("unable to create file: %v", err)
+		}
+		if _, err := rw.Write([]byte("hello")); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+		if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("unable to finalize: %v", err)
+		}
+		fs2, err := squashfs.Read(m, m.Size(), 0, 4096)
+		if err != nil {
+			t.Fatalf("unable to read filesystem: %v", err)
+		}
+		entries, err := fs2.ReadDir("/")
+		if err != nil {
+			t.Fatalf("unable to read directory: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "hello.txt" {
+			t.Errorf("unexpected directory contents %v", entries)
+		}
+	})
+
+	t.Run("iso9660", func(t *testing.T) {
+		m := util.NewMemFile(0)
+		fs, err := iso9660.Create(m, 0, 0, 2048, "")
+		if err != nil {
+			t.Fatalf("unable to create filesystem: %v", err)
+		}
+		rw, err := fs.OpenFile("/HELLO.TXT", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("unable to create file: %v", err)
+		}
+		if _, err := rw.Write([]byte("hello")); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+		if err := fs.Finalize(iso9660.FinalizeOptions{}); err != nil {
+			t.Fatalf("unable to finalize: %v", err)
+		}
+		fs2, err := iso9660.Read(m, m.Size(), 0, 2048)
+		if err != nil {
+			t.Fatalf("unable to read filesystem: %v", err)
+		}
+		entries, err := fs2.ReadDir("/")
+		if err != nil {
+			t.Fatalf("unable to read directory: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "HELLO.TXT" {
+			t.Errorf("unexpected directory contents %v", entries)
+		}
+	})
+}
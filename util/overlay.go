@@ -0,0 +1,223 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Range is a range of bytes in a file
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// Overlay is a File that stages all writes in memory over a base File, which it reads but never
+// writes until Commit. Discard drops the staged writes instead, leaving the base as it was.
+//
+// Reads see the base with the staged writes applied. Writing beyond the end of the base grows
+// the Overlay, and the base with it on Commit.
+//
+// An Overlay is safe for concurrent use, but the base must not be changed by anything else while
+// writes are staged.
+type Overlay struct {
+	mu       sync.RWMutex
+	base     File
+	baseSize int64
+	size     int64
+	delta    *MemFile
+	// dirty holds the staged ranges, sorted by offset, with none overlapping or adjacent
+	dirty  []Range
+	offset int64
+}
+
+// NewOverlay creates an Overlay with no staged writes over base
+func NewOverlay(base File) (*Overlay, error) {
+	if base == nil {
+		return nil, errors.New("must pass a base file")
+	}
+	size, err := base.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get size of base file: %v", err)
+	}
+	return &Overlay{
+		base:     base,
+		baseSize: size,
+		size:     size,
+		delta:    NewMemFile(0),
+	}, nil
+}
+
+// Size returns the size of the file, including any staged writes beyond the end of the base
+func (o *Overlay) Size() int64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.size
+}
+
+// ReadAt reads len(b) bytes starting at byte offset off, from the staged writes where there are any
+// and from the base everywhere else
+func (o *Overlay) ReadAt(b []byte, off int64) (int, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.readAt(b, off)
+}
+
+// WriteAt stages a write of len(b) bytes at byte offset off
+func (o *Overlay) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := o.delta.WriteAt(b, off)
+	if err != nil {
+		return n, err
+	}
+	o.addDirty(Range{Offset: off, Length: int64(n)})
+	if end := off + int64(n); end > o.size {
+		o.size = end
+	}
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write. It is provided to satisfy File;
+// the offset is not used by ReadAt or WriteAt.
+func (o *Overlay) Seek(offset int64, whence int) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	newOffset := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newOffset += o.offset
+	case io.SeekEnd:
+		newOffset += o.size
+	default:
+		return o.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if newOffset < 0 {
+		return o.offset, fmt.Errorf("cannot set offset %d before start of file", newOffset)
+	}
+	o.offset = newOffset
+	return o.offset, nil
+}
+
+// DirtyRanges returns the ranges of the file with staged writes, sorted by offset.
+// Overlapping and adjacent writes are merged into a single range.
+func (o *Overlay) DirtyRanges() []Range {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	ranges := make([]Range, len(o.dirty))
+	copy(ranges, o.dirty)
+	return ranges
+}
+
+// Commit writes all staged writes to the base, in order of offset, and then drops them.
+// If writing to the base fails, the ranges not yet written remain staged.
+func (o *Overlay) Commit() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.dirty) > 0 {
+		r := o.dirty[0]
+		b := make([]byte, r.Length)
+		if _, err := o.delta.ReadAt(b, r.Offset); err != nil && err != io.EOF {
+			return fmt.Errorf("unable to read staged write at %d: %v", r.Offset, err)
+		}
+		if _, err := o.base.WriteAt(b, r.Offset); err != nil {
+			return fmt.Errorf("unable to commit %d bytes at %d: %v", r.Length, r.Offset, err)
+		}
+		o.dirty = o.dirty[1:]
+		if end := r.Offset + r.Length; end > o.baseSize {
+			o.baseSize = end
+		}
+	}
+	o.dirty = nil
+	o.delta = NewMemFile(0)
+	o.size = o.baseSize
+	return nil
+}
+
+// Discard drops all staged writes, so the Overlay reads the same as the base again
+func (o *Overlay) Discard() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.dirty = nil
+	o.delta = NewMemFile(0)
+	o.size = o.baseSize
+}
+
+func (o *Overlay) readAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	var retErr error
+	if int64(len(b)) > o.size-off {
+		b = b[:o.size-off]
+		retErr = io.EOF
+	}
+	// the base, with zeroes past its end
+	fromBase := len(b)
+	if int64(fromBase) > o.baseSize-off {
+		fromBase = int(o.baseSize - off)
+		if fromBase < 0 {
+			fromBase = 0
+		}
+	}
+	if fromBase > 0 {
+		if n, err := o.base.ReadAt(b[:fromBase], off); err != nil && !(err == io.EOF && n == fromBase) {
+			return 0, err
+		}
+	}
+	for i := fromBase; i < len(b); i++ {
+		b[i] = 0
+	}
+	// followed by the staged writes
+	end := off + int64(len(b))
+	first := sort.Search(len(o.dirty), func(i int) bool { return o.dirty[i].Offset+o.dirty[i].Length > off })
+	for _, r := range o.dirty[first:] {
+		if r.Offset >= end {
+			break
+		}
+		start, stop := r.Offset, r.Offset+r.Length
+		if start < off {
+			start = off
+		}
+		if stop > end {
+			stop = end
+		}
+		if _, err := o.delta.ReadAt(b[start-off:stop-off], start); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	return len(b), retErr
+}
+
+// addDirty adds r to the dirty ranges, merging it with any it overlaps or touches
+func (o *Overlay) addDirty(r Range) {
+	start, end := r.Offset, r.Offset+r.Length
+	// the first range that ends at or after the start of r, and the first that starts after its end
+	first := sort.Search(len(o.dirty), func(i int) bool { return o.dirty[i].Offset+o.dirty[i].Length >= start })
+	last := sort.Search(len(o.dirty), func(i int) bool { return o.dirty[i].Offset > end })
+	if first < last {
+		if o.dirty[first].Offset < start {
+			start = o.dirty[first].Offset
+		}
+		if e := o.dirty[last-1].Offset + o.dirty[last-1].Length; e > end {
+			end = e
+		}
+	}
+	merged := make([]Range, 0, len(o.dirty)-(last-first)+1)
+	merged = append(merged, o.dirty[:first]...)
+	merged = append(merged, Range{Offset: start, Length: end - start})
+	merged = append(merged, o.dirty[last:]...)
+	o.dirty = merged
+}
@@ -0,0 +1,214 @@
+package util_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestOverlayReadWrite(t *testing.T) {
+	baseData := make([]byte, 10000)
+	_, _ = rand.Read(baseData)
+	base := util.NewMemFile(0)
+	if _, err := base.WriteAt(baseData, 0); err != nil {
+		t.Fatalf("unable to write base: %v", err)
+	}
+	o, err := util.NewOverlay(base)
+	if err != nil {
+		t.Fatalf("unable to create overlay: %v", err)
+	}
+
+	expected := make([]byte, 10000)
+	copy(expected, baseData)
+	writes := []struct {
+		off  int64
+		data []byte
+	}{
+		{100, bytes.Repeat([]byte{1}, 50)},
+		{300, bytes.Repeat([]byte{2}, 50)},
+		// touches the first range
+		{150, bytes.Repeat([]byte{3}, 10)},
+		// overlaps the second range
+		{280, bytes.Repeat([]byte{4}, 30)},
+		{5000, bytes.Repeat([]byte{0}, 100)},
+		// beyond the end of the base
+		{9990, bytes.Repeat([]byte{5}, 20)},
+	}
+	for _, w := range writes {
+		if _, err := o.WriteAt(w.data, w.off); err != nil {
+			t.Fatalf("unable to write at %d: %v", w.off, err)
+		}
+		if end := w.off + int64(len(w.data)); end > int64(len(expected)) {
+			expected = append(expected, make([]byte, end-int64(len(expected)))...)
+		}
+		copy(expected[w.off:], w.data)
+	}
+
+	expectedRanges := []util.Range{{Offset: 100, Length: 60}, {Offset: 280, Length: 70}, {Offset: 5000, Length: 100}, {Offset: 9990, Length: 20}}
+	if ranges := o.DirtyRanges(); !reflect.DeepEqual(ranges, expectedRanges) {
+		t.Errorf("dirty ranges %v instead of %v", ranges, expectedRanges)
+	}
+	if o.Size() != 10010 {
+		t.Errorf("size %d instead of 10010", o.Size())
+	}
+	b := make([]byte, len(expected))
+	if _, err := o.ReadAt(b, 0); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("overlay did not read with staged writes")
+	}
+	b = make([]byte, 40)
+	n, err := o.ReadAt(b, 9980)
+	if n != 30 || err != io.EOF || !bytes.Equal(b[:n], expected[9980:]) {
+		t.Errorf("read past end returned %d, %v", n, err)
+	}
+	if !bytes.Equal(base.Bytes(), baseData) {
+		t.Errorf("base changed before commit")
+	}
+
+	// discard goes back to the base
+	o.Discard()
+	if len(o.DirtyRanges()) != 0 || o.Size() != 10000 {
+		t.Errorf("discard left %v staged with size %d", o.DirtyRanges(), o.Size())
+	}
+	b = make([]byte, 10000)
+	if _, err := o.ReadAt(b, 0); err != nil {
+		t.Fatalf("unable to read: %v", err)
+	}
+	if !bytes.Equal(b, baseData) {
+		t.Errorf("overlay does not match base after discard")
+	}
+
+	// commit applies the writes to the base
+	for _, w := range writes {
+		if _, err := o.WriteAt(w.data, w.off); err != nil {
+			t.Fatalf("unable to write at %d: %v", w.off, err)
+		}
+	}
+	if err := o.Commit(); err != nil {
+		t.Fatalf("unable to commit: %v", err)
+	}
+	if len(o.DirtyRanges()) != 0 {
+		t.Errorf("commit left %v staged", o.DirtyRanges())
+	}
+	if !bytes.Equal(base.Bytes(), expected) {
+		t.Errorf("base does not have committed writes")
+	}
+	if o.Size() != int64(len(expected)) {
+		t.Errorf("size %d after commit instead of %d", o.Size(), len(expected))
+	}
+}
+
+// relabel changes the label of the filesystem in partition 1 of a disk on f
+func relabel(t *testing.T, f util.File, size int64, label string) {
+	t.Helper()
+	d, err := disk.New(f, size, 512, 512)
+	if err != nil {
+		t.Fatalf("unable to open disk: %v", err)
+	}
+	fs, err := d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	if err := fs.SetLabel(label); err != nil {
+		t.Fatalf("unable to set label: %v", err)
+	}
+}
+
+func label(t *testing.T, f util.File, size int64) string {
+	t.Helper()
+	d, err := disk.New(f, size, 512, 512)
+	if err != nil {
+		t.Fatalf("unable to open disk: %v", err)
+	}
+	fs, err := d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("unable to read filesystem: %v", err)
+	}
+	return strings.TrimSpace(fs.Label())
+}
+
+func TestOverlayDisk(t *testing.T) {
+	size := int64(20 * 1024 * 1024)
+	f, err := os.CreateTemp("", "overlay_test")
+	if err != nil {
+		t.Fatalf("unable to create tempfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("unable to size tempfile: %v", err)
+	}
+	d, err := disk.New(f, size, 512, 512)
+	if err != nil {
+		t.Fatalf("unable to create disk: %v", err)
+	}
+	if err := d.Partition(&gpt.Table{
+		LogicalSectorSize: 512,
+		ProtectiveMBR:     true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 10*1024*2 - 1, Type: gpt.EFISystemPartition, Name: "EFI System"},
+		},
+	}); err != nil {
+		t.Fatalf("unable to partition: %v", err)
+	}
+	if _, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "ORIGINAL"}); err != nil {
+		t.Fatalf("unable to create filesystem: %v", err)
+	}
+	original, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("unable to read tempfile: %v", err)
+	}
+
+	o, err := util.NewOverlay(f)
+	if err != nil {
+		t.Fatalf("unable to create overlay: %v", err)
+	}
+	// add a partition and relabel, then throw it all away
+	od, err := disk.New(o, size, 512, 512)
+	if err != nil {
+		t.Fatalf("unable to open overlay disk: %v", err)
+	}
+	table := od.Table.(*gpt.Table)
+	table.Partitions = append(table.Partitions[:1], &gpt.Partition{Start: 2048 + 10*1024*2, End: 2048 + 15*1024*2 - 1, Type: gpt.LinuxFilesystem, Name: "data"})
+	if err := od.Partition(table); err != nil {
+		t.Fatalf("unable to repartition overlay: %v", err)
+	}
+	relabel(t, o, size, "STAGED")
+	if l := label(t, o, size); l != "STAGED" {
+		t.Errorf("overlay label %q instead of STAGED", l)
+	}
+	if len(o.DirtyRanges()) == 0 {
+		t.Errorf("no dirty ranges after writes")
+	}
+	current, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("unable to read tempfile: %v", err)
+	}
+	if !bytes.Equal(current, original) {
+		t.Errorf("base changed before commit")
+	}
+	o.Discard()
+	if l := label(t, o, size); l != "ORIGINAL" {
+		t.Errorf("label %q after discard instead of ORIGINAL", l)
+	}
+
+	// now stage it again and keep it
+	relabel(t, o, size, "COMMITTED")
+	if err := o.Commit(); err != nil {
+		t.Fatalf("unable to commit: %v", err)
+	}
+	if l := label(t, f, size); l != "COMMITTED" {
+		t.Errorf("base label %q after commit instead of COMMITTED", l)
+	}
+}